@@ -0,0 +1,114 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// checksumQueueDepth is the number of blocks read ahead of the goroutine
+// hashing a payload file, before reading waits for it.
+const checksumQueueDepth = 16
+
+// dataChecksum verifies the checksum of a payload file as it is read.
+type dataChecksum interface {
+	io.Reader
+	Verify() error
+}
+
+// concurrentChecksum hashes what is read through it on a goroutine of its
+// own, so that the file is decompressed and stored while it is hashed, and
+// the next file can be read before its hash is done.
+type concurrentChecksum struct {
+	r      io.Reader
+	sum    []byte
+	hash   *artifact.Checksum
+	blocks chan []byte
+	free   chan []byte
+	done   chan struct{}
+	ended  bool
+}
+
+func newConcurrentChecksum(r io.Reader, sum []byte) *concurrentChecksum {
+	c := &concurrentChecksum{
+		r:   r,
+		sum: sum,
+		hash: artifact.NewWriterChecksumAlgorithm(ioutil.Discard,
+			artifact.ChecksumAlgorithmOf(sum)),
+		blocks: make(chan []byte, checksumQueueDepth),
+		free:   make(chan []byte, checksumQueueDepth+1),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *concurrentChecksum) run() {
+	defer close(c.done)
+	for block := range c.blocks {
+		_, _ = c.hash.Write(block)
+		select {
+		case c.free <- block:
+		default:
+		}
+	}
+}
+
+func (c *concurrentChecksum) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		var block []byte
+		select {
+		case block = <-c.free:
+		default:
+		}
+		c.blocks <- append(block[:0], p[:n]...)
+	}
+	return n, err
+}
+
+// end tells the hashing goroutine that the whole file is read.
+func (c *concurrentChecksum) end() {
+	if !c.ended {
+		c.ended = true
+		close(c.blocks)
+	}
+}
+
+// Verify waits for the hash of the file, and checks it.
+func (c *concurrentChecksum) Verify() error {
+	c.end()
+	<-c.done
+	if actual := c.hash.Checksum(); !bytes.Equal(c.sum, actual) {
+		return &artifact.ErrChecksumMismatch{Expected: c.sum, Actual: actual}
+	}
+	return nil
+}
+
+// pendingDataFile is a payload file which has been read, and whose checksum
+// is verified once it is hashed.
+type pendingDataFile struct {
+	name     string
+	size     int64
+	checksum []byte
+	linked   bool
+	kept     bool
+	storeErr error
+	sum      dataChecksum
+}
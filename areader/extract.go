@@ -0,0 +1,148 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// ErrPayloadFileNotFound is returned by ExtractFile if the Payload does not
+// contain the requested file.
+var ErrPayloadFileNotFound = errors.New("file not found in Payload")
+
+// ExtractFile copies the data file called name of the Payload with the given
+// index to w, verifying its checksum. It must be called after
+// ReadArtifactHeaders instead of ReadArtifactData; the data members of the
+// other Payloads, as well as the files before the requested one, are skipped
+// without being stored. Nothing is written to w before the file is found, but
+// on a checksum mismatch w has already received the damaged data. A Reader
+// returned by NewReaderSeekable seeks to the Payload directly, and can
+// extract any number of files.
+func (ar *Reader) ExtractFile(payloadIndex int, name string, w io.Writer) error {
+	if ar.info == nil || ar.menderTarReader == nil {
+		return errors.New("reader: the Artifact headers have not been read")
+	}
+	defer ar.Close()
+
+	inst, ok := ar.installers[payloadIndex]
+	if !ok {
+		return errors.Errorf("reader: the Artifact has no Payload %d", payloadIndex)
+	}
+	if err := artifact.ValidateArchivePath(name); err != nil {
+		return errors.Wrap(err, "reader")
+	}
+	// The manifest holds the checksums of the data files; Payloads
+	// without it have them in their headers.
+	manifestName := filepath.Join(artifact.UpdatePath(payloadIndex), name)
+	var sum []byte
+	if ar.manifest != nil {
+		sum, _ = ar.manifest.Get(manifestName)
+	}
+	if sum == nil {
+		if df := getDataFile(inst, name); df != nil {
+			sum = df.Checksum
+		}
+	}
+	if sum == nil {
+		return errors.Wrapf(ErrPayloadFileNotFound, "reader: %s", manifestName)
+	}
+	// The file may be stored as a link to another one with the same
+	// content, which is extracted instead.
+	names := map[string]bool{name: true}
+	if ar.sharedChecksums(payloadIndex)[string(sum)] {
+		for _, file := range ar.manifest.Files() {
+			other, _ := ar.manifest.Get(file)
+			if filepath.Dir(file) == artifact.UpdatePath(payloadIndex) &&
+				bytes.Equal(other, sum) {
+				names[filepath.Base(file)] = true
+			}
+		}
+	}
+
+	if ar.seeker != nil {
+		r, err := ar.openPayload(payloadIndex)
+		if err != nil {
+			return err
+		}
+		return extractDataFile(r, name, names, sum, w)
+	}
+	for {
+		hdr, err := getNext(ar.menderTarReader)
+		if errors.Cause(err) == io.EOF {
+			return errors.Wrapf(ErrPayloadFileNotFound, "reader: %s", manifestName)
+		} else if err != nil {
+			return errors.Wrap(err, "reader: error reading Payload file")
+		}
+		if filepath.Dir(hdr.Name) != "data" {
+			return errors.New("reader: invalid data file name: " + hdr.Name)
+		}
+		comp, err := artifact.NewCompressorFromFileName(hdr.Name)
+		if err != nil {
+			return errors.New("reader: can't get compressor")
+		}
+		updNo, err := getUpdateNoFromDataPath(comp, hdr.Name)
+		if err != nil {
+			return errors.Wrapf(err, "reader: error getting data Payload number")
+		}
+		if updNo == payloadIndex {
+			return extractDataFile(ar.menderTarReader, name, names, sum, w)
+		}
+	}
+}
+
+// extractDataFile extracts the first regular file of the data member r whose
+// name is in names, which all have the checksum sum.
+func extractDataFile(r io.Reader, name string, names map[string]bool, sum []byte,
+	w io.Writer) error {
+	r, comp, err := detectCompressor(r)
+	if err != nil {
+		return errors.Wrap(err, "Payload: can not detect the compression")
+	}
+	dr, err := comp.NewReader(r)
+	if err != nil {
+		return errors.Wrapf(err, "Payload: can not open %s file for reading data",
+			comp.GetFileExtension())
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return errors.Wrapf(ErrPayloadFileNotFound, "Payload: %s", name)
+		} else if err != nil {
+			return errors.Wrap(err, "Payload: error reading Artifact file header")
+		}
+		if !names[hdr.Name] || hdr.Typeflag == tar.TypeLink {
+			continue
+		}
+		ch := artifact.NewReaderChecksum(tr, sum)
+		if _, err = io.Copy(w, ch); err != nil {
+			return errors.Wrapf(err, "Payload: can not extract %s", name)
+		}
+		if err = ch.Verify(); err != nil {
+			setChecksumMismatchFile(err, name)
+			return errors.Wrap(err, "Payload: error reading data")
+		}
+		return nil
+	}
+}
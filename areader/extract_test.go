@@ -0,0 +1,88 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+func TestExtractFile(t *testing.T) {
+	keep := func(hdr *tar.Header, data []byte) []byte { return data }
+
+	for _, test := range []struct {
+		payload int
+		name    string
+		content string
+	}{
+		{0, "first", "app first"},
+		{0, "second", "app second"},
+		{1, "first", "config first"},
+		{1, "second", "config second"},
+	} {
+		ar := NewReader(writeDamagedArtifact(t, keep))
+		require.NoError(t, ar.ReadArtifactHeaders())
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, ar.ExtractFile(test.payload, test.name, buf))
+		assert.Equal(t, test.content, buf.String())
+	}
+
+	ar := NewReader(writeDamagedArtifact(t, keep))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	err := ar.ExtractFile(0, "third", bytes.NewBuffer(nil))
+	assert.Equal(t, ErrPayloadFileNotFound, errors.Cause(err))
+
+	ar = NewReader(writeDamagedArtifact(t, keep))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	err = ar.ExtractFile(2, "first", bytes.NewBuffer(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no Payload 2")
+
+	ar = NewReader(writeDamagedArtifact(t, keep))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	err = ar.ExtractFile(0, "../first", bytes.NewBuffer(nil))
+	assert.Equal(t, artifact.ErrUnsafeArchivePath, errors.Cause(err))
+
+	// The headers must have been read.
+	err = NewReader(writeDamagedArtifact(t, keep)).ExtractFile(0, "first", bytes.NewBuffer(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "headers have not been read")
+}
+
+func TestExtractFileDamaged(t *testing.T) {
+	ar := NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+		return bytes.Replace(data, []byte("config second"), []byte("config SECOND"), 1)
+	}))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	err := ar.ExtractFile(1, "second", bytes.NewBuffer(nil))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+
+	// Other files of the same Artifact are still intact.
+	ar = NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+		return bytes.Replace(data, []byte("config second"), []byte("config SECOND"), 1)
+	}))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, ar.ExtractFile(1, "first", buf))
+	assert.Equal(t, "config first", buf.String())
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const tarBlockSize = 512
+
+var zeroBlock [tarBlockSize]byte
+
+// parseTarNumber parses a numeric tar header field, which is either octal
+// text or, for large values, base-256 encoded.
+func parseTarNumber(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		var n int64
+		for i, c := range field {
+			if i == 0 {
+				c &= 0x7f
+			}
+			n = n<<8 | int64(c)
+		}
+		return n, nil
+	}
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 8, 64)
+}
+
+// parsePAXSize returns the size record of PAX extended header records, or -1
+// if there is none.
+func parsePAXSize(records []byte) (int64, error) {
+	size := int64(-1)
+	for len(records) > 0 {
+		sp := bytes.IndexByte(records, ' ')
+		if sp < 0 {
+			return 0, errors.New("reader: invalid PAX record")
+		}
+		length, err := strconv.Atoi(string(records[:sp]))
+		if err != nil || length <= sp || length > len(records) {
+			return 0, errors.New("reader: invalid PAX record")
+		}
+		record := strings.TrimSuffix(string(records[sp+1:length]), "\n")
+		if strings.HasPrefix(record, "size=") {
+			if size, err = strconv.ParseInt(record[len("size="):], 10, 64); err != nil {
+				return 0, errors.Wrap(err, "reader: invalid PAX size record")
+			}
+		}
+		records = records[length:]
+	}
+	return size, nil
+}
+
+// archiveReader passes one tar archive through from a stream, and returns
+// io.EOF at its end-of-archive marker, so that whatever follows the archive
+// stays in the stream. It follows the tar framing only as far as needed to
+// find the end. It is safe for concurrent use, as a read-ahead goroutine of
+// a Reader may still be reading when the rest of the archive is skipped.
+type archiveReader struct {
+	lock  sync.Mutex
+	r     io.Reader
+	first []byte
+	block [tarBlockSize]byte
+	out   []byte
+	// dataBlocks is the number of blocks of entry data still to pass.
+	dataBlocks int64
+	// pax collects the contents of a PAX extended header.
+	pax        []byte
+	paxLeft    int64
+	nextSize   int64
+	zeroBlocks int
+	done       bool
+}
+
+func newArchiveReader(r io.Reader, first []byte) *archiveReader {
+	return &archiveReader{r: r, first: first, nextSize: -1}
+}
+
+func (a *archiveReader) Read(p []byte) (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for len(a.out) == 0 {
+		if a.done {
+			return 0, io.EOF
+		}
+		if err := a.nextBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, a.out)
+	a.out = a.out[n:]
+	return n, nil
+}
+
+func (a *archiveReader) nextBlock() error {
+	if a.first != nil {
+		copy(a.block[:], a.first)
+		a.first = nil
+	} else if _, err := io.ReadFull(a.r, a.block[:]); err != nil {
+		if err == io.EOF && a.dataBlocks == 0 && a.zeroBlocks == 1 {
+			// A single zero block at the end of the stream ends the
+			// archive as well.
+			a.done = true
+			return nil
+		} else if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	a.out = a.block[:]
+
+	if a.dataBlocks > 0 {
+		a.dataBlocks--
+		if a.paxLeft > 0 {
+			n := a.paxLeft
+			if n > tarBlockSize {
+				n = tarBlockSize
+			}
+			a.pax = append(a.pax, a.block[:n]...)
+			a.paxLeft -= n
+			if a.paxLeft == 0 {
+				size, err := parsePAXSize(a.pax)
+				if err != nil {
+					return err
+				}
+				a.nextSize = size
+				a.pax = nil
+			}
+		}
+		return nil
+	}
+
+	if bytes.Equal(a.block[:], zeroBlock[:]) {
+		a.zeroBlocks++
+		a.done = a.zeroBlocks == 2
+		return nil
+	}
+	a.zeroBlocks = 0
+	size, err := parseTarNumber(a.block[124:136])
+	if err != nil {
+		return errors.Wrap(err, "reader: invalid tar header")
+	}
+	if a.nextSize >= 0 {
+		size = a.nextSize
+		a.nextSize = -1
+	}
+	switch typeflag := a.block[156]; typeflag {
+	case 'x':
+		a.paxLeft = size
+	case '1', '2', '3', '4', '5', '6':
+		// Header-only entries.
+		size = 0
+	}
+	a.dataBlocks = (size + tarBlockSize - 1) / tarBlockSize
+	return nil
+}
+
+// MultiReader iterates over Artifacts written back-to-back into one stream,
+// such as several .mender files concatenated together.
+type MultiReader struct {
+	r       io.Reader
+	current *archiveReader
+}
+
+func NewMultiReader(r io.Reader) *MultiReader {
+	return &MultiReader{r: r}
+}
+
+// Next returns a Reader for the next Artifact in the stream, or io.EOF if
+// there are no more. Whatever the previous Reader did not read of its
+// Artifact is skipped.
+func (m *MultiReader) Next() (*Reader, error) {
+	if m.current != nil {
+		if _, err := io.Copy(io.Discard, m.current); err != nil {
+			return nil, errors.Wrap(err, "reader: can not skip to the next artifact")
+		}
+		m.current = nil
+	}
+	// Archives may be padded with zero blocks beyond the end-of-archive
+	// marker.
+	block := make([]byte, tarBlockSize)
+	for {
+		n, err := io.ReadFull(m.r, block)
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err == io.ErrUnexpectedEOF && bytes.Equal(block[:n], zeroBlock[:n]) {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, errors.Wrap(err, "reader: can not read next artifact")
+		}
+		if !bytes.Equal(block, zeroBlock[:]) {
+			break
+		}
+	}
+	m.current = newArchiveReader(m.r, block)
+	return NewReader(m.current), nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiReader(t *testing.T) {
+	stream := bytes.NewBuffer(nil)
+	for i, updateType := range []string{"first", "second", "third"} {
+		art, err := MakeModuleImageArtifact(false, i == 1, updateType, i+1, 0)
+		require.NoError(t, err)
+		_, err = io.Copy(stream, art)
+		require.NoError(t, err)
+		// Some tools pad archives to a full record.
+		if i == 0 {
+			stream.Write(make([]byte, 10*tarBlockSize))
+		}
+	}
+
+	mr := NewMultiReader(stream)
+	for i, updateType := range []string{"first", "second", "third"} {
+		ar, err := mr.Next()
+		require.NoError(t, err)
+		// Buffering must not read into the next Artifact.
+		ar.ReadBufferSize = 4096
+		ar.ReadAheadBuffers = 2
+		require.NoError(t, ar.ReadArtifact(), updateType)
+		require.Len(t, ar.GetHandlers(), 1)
+		assert.Equal(t, updateType, *ar.GetHandlers()[0].GetUpdateType())
+		assert.Len(t, ar.GetHandlers()[0].GetUpdateFiles(), i+1)
+	}
+	_, err := mr.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultiReaderSkipsUnread(t *testing.T) {
+	stream := bytes.NewBuffer(nil)
+	for _, updateType := range []string{"first", "second"} {
+		art, err := MakeModuleImageArtifact(false, false, updateType, 1, 0)
+		require.NoError(t, err)
+		_, err = io.Copy(stream, art)
+		require.NoError(t, err)
+	}
+
+	mr := NewMultiReader(stream)
+	ar, err := mr.Next()
+	require.NoError(t, err)
+	require.NoError(t, ar.ReadArtifactHeaders())
+	ar, err = mr.Next()
+	require.NoError(t, err)
+	require.NoError(t, ar.ReadArtifact())
+	assert.Equal(t, "second", *ar.GetHandlers()[0].GetUpdateType())
+	_, err = mr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// A truncated Artifact is an error.
+	art, err := MakeModuleImageArtifact(false, false, "first", 1, 0)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(art)
+	require.NoError(t, err)
+	mr = NewMultiReader(bytes.NewReader(data[:len(data)/2]))
+	ar, err = mr.Next()
+	require.NoError(t, err)
+	assert.Error(t, ar.ReadArtifact())
+	_, err = mr.Next()
+	assert.Error(t, err)
+}
+
+func TestArchiveReaderPAX(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	// The long name needs a PAX extended header.
+	name := strings.Repeat("long-name/", 20) + "file"
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:   name,
+		Mode:   0644,
+		Size:   5,
+		Format: tar.FormatPAX,
+	}))
+	_, err := tw.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	archive := buf.Bytes()
+	buf.WriteString("trailing data")
+
+	first := make([]byte, tarBlockSize)
+	_, err = io.ReadFull(buf, first)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(newArchiveReader(buf, first))
+	require.NoError(t, err)
+	assert.Equal(t, archive, data)
+	assert.Equal(t, "trailing data", buf.String())
+}
+
+func TestParsePAXSize(t *testing.T) {
+	size, err := parsePAXSize([]byte("30 mtime=1432668921.098285006\n19 size=8589934593\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8589934593), size)
+
+	size, err = parsePAXSize([]byte("30 mtime=1432668921.098285006\n"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), size)
+
+	_, err = parsePAXSize([]byte("99 size=1\n"))
+	assert.Error(t, err)
+}
+
+func TestParseTarNumber(t *testing.T) {
+	n, err := parseTarNumber([]byte("00000001750\x00"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), n)
+	n, err = parseTarNumber([]byte{0x80, 0, 0, 0, 0, 0, 0, 0x02, 0, 0, 0, 0x01})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8589934593), n)
+}
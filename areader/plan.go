@@ -0,0 +1,140 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// FilePlan describes one payload file of a DownloadPlan.
+type FilePlan struct {
+	Name     string
+	Checksum string
+	// Size of the file, or 0 if only the headers of the Artifact were read.
+	Size   int64
+	Cached bool
+}
+
+// PayloadPlan describes whether the data member of one payload has to be
+// transferred.
+type PayloadPlan struct {
+	Index int
+	// DataMember is the name of the data member in the Artifact, such as
+	// data/0000.tar.gz.
+	DataMember string
+	Files      []FilePlan
+	// Transfer is true if any of the files is missing from the cache.
+	Transfer bool
+}
+
+// DownloadPlan describes which parts of an Artifact a device needs, given
+// the files it already has.
+type DownloadPlan struct {
+	Payloads []PayloadPlan
+}
+
+// TransferSize returns the total size of the files which are not cached.
+func (p *DownloadPlan) TransferSize() int64 {
+	var size int64
+	for _, payload := range p.Payloads {
+		for _, f := range payload.Files {
+			if !f.Cached {
+				size += f.Size
+			}
+		}
+	}
+	return size
+}
+
+// CachedSize returns the total size of the files which are cached.
+func (p *DownloadPlan) CachedSize() int64 {
+	var size int64
+	for _, payload := range p.Payloads {
+		for _, f := range payload.Files {
+			if f.Cached {
+				size += f.Size
+			}
+		}
+	}
+	return size
+}
+
+// PlanDownload computes which data members and files of the Artifact need
+// to be transferred to a device which already has the files with the given
+// sha256 checksums. It only needs the headers of the Artifact, but the file
+// sizes are only known once the data has been read as well.
+func (ar *Reader) PlanDownload(cached []string) (*DownloadPlan, error) {
+	if ar.info == nil {
+		return nil, errors.New("reader: the Artifact headers have not been read")
+	}
+	cache := make(map[string]bool, len(cached))
+	for _, sum := range cached {
+		sum = strings.ToLower(strings.TrimSpace(sum))
+		if _, err := hex.DecodeString(sum); err != nil || len(sum) != 64 {
+			return nil, errors.Errorf("reader: invalid checksum in cache: %q", sum)
+		}
+		cache[sum] = true
+	}
+
+	ext := ""
+	if ar.compressor != nil {
+		ext = ar.compressor.GetFileExtension()
+	}
+
+	indexes := make([]int, 0, len(ar.installers))
+	for i := range ar.installers {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	plan := &DownloadPlan{}
+	for _, i := range indexes {
+		payload := PayloadPlan{
+			Index:      i,
+			DataMember: artifact.UpdateDataPath(i) + ext,
+		}
+		for _, f := range ar.installers[i].GetUpdateAllFiles() {
+			sum := string(f.Checksum)
+			if sum == "" && ar.manifest != nil {
+				// Before the data is read, the checksums are only
+				// in the manifest.
+				manifestSum, err := ar.manifest.Get(
+					filepath.Join(artifact.UpdatePath(i), f.Name))
+				if err == nil {
+					sum = string(manifestSum)
+				}
+			}
+			file := FilePlan{
+				Name:     f.Name,
+				Checksum: sum,
+				Size:     f.Size,
+				Cached:   cache[strings.ToLower(sum)],
+			}
+			if !file.Cached {
+				payload.Transfer = true
+			}
+			payload.Files = append(payload.Files, file)
+		}
+		plan.Payloads = append(plan.Payloads, payload)
+	}
+	return plan, nil
+}
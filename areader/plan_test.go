@@ -0,0 +1,73 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+func TestPlanDownload(t *testing.T) {
+	sum := sha256.Sum256([]byte(TestUpdateFileContent))
+	checksum := hex.EncodeToString(sum[:])
+
+	read := func(t *testing.T, data bool) *Reader {
+		art, err := MakeRootfsImageArtifact(3, false, false, false)
+		require.NoError(t, err)
+		ar := NewReader(art)
+		require.NoError(t, ar.RegisterHandler(handlers.NewRootfsInstaller()))
+		if data {
+			require.NoError(t, ar.ReadArtifact())
+		} else {
+			require.NoError(t, ar.ReadArtifactHeaders())
+		}
+		return ar
+	}
+
+	_, err := NewReader(strings.NewReader("")).PlanDownload(nil)
+	assert.EqualError(t, err, "reader: the Artifact headers have not been read")
+
+	// Only the headers: the sizes are unknown.
+	plan, err := read(t, false).PlanDownload(nil)
+	require.NoError(t, err)
+	require.Len(t, plan.Payloads, 1)
+	assert.Equal(t, "data/0000.tar.gz", plan.Payloads[0].DataMember)
+	assert.True(t, plan.Payloads[0].Transfer)
+	require.Len(t, plan.Payloads[0].Files, 1)
+	assert.Equal(t, checksum, plan.Payloads[0].Files[0].Checksum)
+	assert.Equal(t, int64(0), plan.TransferSize())
+
+	plan, err = read(t, true).PlanDownload(nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(TestUpdateFileContent)), plan.TransferSize())
+	assert.Equal(t, int64(0), plan.CachedSize())
+
+	plan, err = read(t, true).PlanDownload([]string{strings.ToUpper(checksum)})
+	require.NoError(t, err)
+	assert.False(t, plan.Payloads[0].Transfer)
+	assert.True(t, plan.Payloads[0].Files[0].Cached)
+	assert.Equal(t, int64(0), plan.TransferSize())
+	assert.Equal(t, int64(len(TestUpdateFileContent)), plan.CachedSize())
+
+	_, err = read(t, false).PlanDownload([]string{"abc"})
+	assert.EqualError(t, err, `reader: invalid checksum in cache: "abc"`)
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mendersoftware/mender-artifact/artifact/stage"
+)
+
+// ProgressEvent reports how much of a file of the Artifact has been read.
+type ProgressEvent struct {
+	// Stage is the stage of the artifact/stage package the file belongs
+	// to.
+	Stage string
+	// Name is the name of the file in the Artifact, such as
+	// header.tar.gz or data/0000.tar.gz, or that of the payload file in
+	// the events of DataFileProgressCallback.
+	Name string
+	// Payload is the index of the payload in the Payload stage.
+	Payload int
+	// Read is the number of bytes read of the file so far, and Size its
+	// size.
+	Read int64
+	Size int64
+}
+
+// ProgressFn is called as the Artifact is read.
+type ProgressFn func(event ProgressEvent)
+
+// fileStage returns the stage of the file of the Artifact called name.
+func fileStage(name string) string {
+	switch {
+	case name == "version":
+		return stage.Version
+	case name == "manifest":
+		return stage.Manifest
+	case strings.HasPrefix(name, "manifest.sig"):
+		return stage.ManifestSignature
+	case name == "manifest-augment":
+		return stage.ManifestAugment
+	case strings.HasPrefix(name, "header-augment.tar"):
+		return stage.HeaderAugment
+	case strings.HasPrefix(name, "header.tar"):
+		return stage.Header
+	case filepath.Dir(name) == "data":
+		return stage.Data
+	}
+	return ""
+}
+
+// stageProgress counts the bytes read from the outer tar archive of the
+// Artifact, and reports them for the file being read. The headers of the
+// tar archive are counted to the file before them, which is why the counts
+// are capped at the size.
+type stageProgress struct {
+	r     io.Reader
+	fn    ProgressFn
+	event ProgressEvent
+	count int64
+	start int64
+}
+
+// begin starts reporting the file called name of size bytes, whose
+// contents are read next.
+func (p *stageProgress) begin(name string, size int64, payload int) {
+	if p == nil {
+		return
+	}
+	p.event = ProgressEvent{
+		Stage:   fileStage(name),
+		Name:    name,
+		Payload: payload,
+		Size:    size,
+	}
+	p.start = p.count
+	p.fn(p.event)
+}
+
+// complete reports a file of size bytes which has already been read in
+// full.
+func (p *stageProgress) complete(name string, size int64) {
+	if p == nil {
+		return
+	}
+	p.begin(name, size, 0)
+	if size > 0 {
+		p.event.Read = size
+		p.fn(p.event)
+	}
+}
+
+func (p *stageProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.count += int64(n)
+	if p.event.Name != "" {
+		read := p.count - p.start
+		if read > p.event.Size {
+			read = p.event.Size
+		}
+		if read != p.event.Read {
+			p.event.Read = read
+			p.fn(p.event)
+		}
+	}
+	return n, err
+}
+
+// fileProgress reports the bytes read from a single file.
+type fileProgress struct {
+	r     io.Reader
+	fn    ProgressFn
+	event ProgressEvent
+}
+
+func newFileProgress(r io.Reader, fn ProgressFn, event ProgressEvent) io.Reader {
+	if fn == nil {
+		return r
+	}
+	fn(event)
+	return &fileProgress{r: r, fn: fn, event: event}
+}
+
+func (p *fileProgress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.event.Read += int64(n)
+		p.fn(p.event)
+	}
+	return n, err
+}
@@ -16,6 +16,7 @@ package areader
 
 import (
 	"archive/tar"
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -29,14 +30,48 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/artifact/stage"
 	"github.com/mendersoftware/mender-artifact/handlers"
 	"github.com/mendersoftware/mender-artifact/utils"
 )
 
+// SignatureVerifyFn is called with each of the signatures of the manifest;
+// the Artifact is accepted if it returns nil for any of them.
 type SignatureVerifyFn func(message, sig []byte) error
 type DevicesCompatibleFn func([]string) error
 type ScriptsReadFn func(io.Reader, os.FileInfo) error
 
+// DeviceTypeScriptsReadFn is called with the state scripts which only run on
+// devices of deviceType.
+type DeviceTypeScriptsReadFn func(deviceType string, r io.Reader, info os.FileInfo) error
+
+// DamageFn is called with the name in the Artifact of a payload file, or a
+// whole payload, which could not be read, and the reason.
+type DamageFn func(name string, err error) error
+
+// PayloadFileEvent describes a payload file read by ReadArtifactData.
+type PayloadFileEvent struct {
+	// Payload is the index of the payload the file belongs to.
+	Payload int
+	// Name is the name of the file in the payload.
+	Name string
+	Size int64
+	// Checksum is the hex encoded SHA256 checksum of the file, as listed
+	// in the manifest.
+	Checksum string
+	// Linked is true if the file was stored as a link to an earlier file
+	// with the same content.
+	Linked bool
+	// Err is nil if the file was read and matched its checksum, and the
+	// reason otherwise.
+	Err error
+}
+
+// PayloadFileFn is called with every payload file read.
+type PayloadFileFn func(event PayloadFileEvent) error
+
+// ProgressReader wraps the data of each payload to show the progress of
+// reading it; StageProgressCallback reports all the stages.
 type ProgressReader interface {
 	Wrap(io.Reader, int64) io.Reader
 }
@@ -48,6 +83,10 @@ type Reader struct {
 	IsSigned                  bool
 	ForbidUnknownHandlers     bool
 
+	// DeviceTypeScriptsReadCallback is called instead of
+	// ScriptsReadCallback for the state scripts of a single device type.
+	DeviceTypeScriptsReadCallback DeviceTypeScriptsReadFn
+
 	shouldBeSigned  bool
 	hInfo           artifact.HeaderInfoer
 	augmentedhInfo  artifact.HeaderInfoer
@@ -59,9 +98,75 @@ type Reader struct {
 	installers      map[int]handlers.Installer
 	updateStorers   map[int]handlers.UpdateStorer
 	manifest        *artifact.ChecksumStore
+	signatures      [][]byte
 	menderTarReader *tar.Reader
 	ProgressReader  ProgressReader
 	compressor      artifact.Compressor
+	// The header can be stored uncompressed even when the data is
+	// compressed; see awriter.WriteArtifactArgs.UncompressedHeader.
+	headerCompressor artifact.Compressor
+
+	// ReadBufferSize sets the size of the reads issued to the underlying
+	// stream. Zero means no additional buffering.
+	ReadBufferSize int
+	// ReadAheadBuffers sets how many buffers of ReadBufferSize bytes are
+	// prefetched in the background. Useful for network backed storage.
+	ReadAheadBuffers int
+	readAhead        *artifact.ReadAheadReader
+
+	// TranslateLegacyProvides makes the payload provides returned by the
+	// handlers use current key names in place of legacy ones, see
+	// artifact.LegacyProvides.
+	TranslateLegacyProvides bool
+
+	// CollectScripts keeps the state scripts in memory after reading, so
+	// that they can be fetched with GetScripts afterwards. The scripts are
+	// always held in memory until the type-info is read, and their total
+	// size is limited to MaxScriptsSize bytes, or DefaultMaxScriptsSize if
+	// zero.
+	CollectScripts bool
+	MaxScriptsSize int64
+	scripts        []Script
+
+	// Set by NewReaderSeekable.
+	seeker  io.ReadSeeker
+	entries []TarEntry
+
+	// The payload files which were stored as links to an earlier file with
+	// the same content.
+	linkedDataFiles []string
+
+	// DamageCallback, when set, makes ReadArtifactData continue past
+	// damaged payload files and payloads, which are reported to it instead
+	// of failing the read, unless it returns an error itself. Payload files
+	// listed in the manifest but never found are reported at the end. The
+	// headers must still be intact.
+	DamageCallback DamageFn
+
+	// PayloadFileCallback, when set, is called after each payload file is
+	// read, whether it matched its checksum or not, and before a damaged
+	// file is passed to DamageCallback. Tools which only index the
+	// contents of Artifacts need no UpdateStorer for it, as the files are
+	// discarded without one. The read fails if it returns an error.
+	PayloadFileCallback PayloadFileFn
+
+	// StageProgressCallback, when set, is called as the files of the
+	// Artifact are read: when each file is reached, and as its contents
+	// are read. The data of the payloads is counted as stored, before it
+	// is decompressed.
+	StageProgressCallback ProgressFn
+	stageProgress         *stageProgress
+
+	// DataFileProgressCallback, when set, is called as each payload file
+	// is read, with the uncompressed size of the file.
+	DataFileProgressCallback ProgressFn
+
+	// ChecksumWorkers, if positive, hashes the payload files on their own
+	// goroutines, up to this many at once, while the files are read,
+	// decompressed and stored on the reading one. The checksums, and the
+	// callbacks of the files, then follow the files they are for, in
+	// order, as the hashes are done.
+	ChecksumWorkers int
 }
 
 func NewReader(r io.Reader) *Reader {
@@ -83,46 +188,72 @@ func NewReaderSigned(r io.Reader) *Reader {
 	}
 }
 
-func getReader(tReader io.Reader, headerSum []byte) io.Reader {
+func getReader(tReader io.Reader, headerSum []byte, bufSize int) io.Reader {
 
 	if headerSum != nil {
 		// If artifact is signed we need to calculate header checksum to be
 		// able to validate it later.
-		return artifact.NewReaderChecksum(tReader, headerSum)
+		return artifact.NewReaderChecksumSize(tReader, headerSum, bufSize)
 	}
 	return tReader
 }
 
-func readStateScripts(tr *tar.Reader, header *tar.Header, cb ScriptsReadFn) error {
-
+// readStateScripts reads the state scripts, which come before the rest of
+// the header, into memory; their total size is limited to limit bytes.
+func readStateScripts(tr *tar.Reader, header *tar.Header, limit int64) ([]stateScript, error) {
+	var scripts []stateScript
+	var size int64
 	for {
 		hdr, err := getNext(tr)
 		if errors.Cause(err) == io.EOF {
-			return nil
+			break
 		} else if err != nil {
-			return errors.Wrapf(err,
+			return nil, errors.Wrapf(err,
 				"reader: error reading artifact header file: %v", hdr)
 		}
-		if filepath.Dir(hdr.Name) == "scripts" {
-			if cb != nil {
-				if err = cb(tr, hdr.FileInfo()); err != nil {
-					return err
-				}
-			}
-		} else {
+		if filepath.Dir(hdr.Name) != "scripts" {
 			// if there are no more scripts to read leave the loop
 			*header = *hdr
 			break
 		}
+		if err = artifact.ValidateArchivePath(hdr.Name); err != nil {
+			return nil, errors.Wrap(err, "reader: invalid state script")
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(tr, limit-size+1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reader: can not read state script %s", hdr.Name)
+		}
+		size += int64(len(data))
+		if size > limit {
+			return nil, errors.Errorf("reader: state scripts are larger than %d bytes", limit)
+		}
+		scripts = append(scripts, stateScript{info: hdr.FileInfo(), data: data})
 	}
+	return scripts, nil
+}
 
-	return nil
+// detectCompressor finds the compression of r from the magic bytes it starts
+// with, so that the file names of the entries need not match their contents.
+// The returned reader yields all of r.
+func detectCompressor(r io.Reader) (io.Reader, artifact.Compressor, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(artifact.CompressorMagicSize)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return br, artifact.DetectCompressor(header), nil
 }
 
-func (ar *Reader) readHeader(headerSum []byte, comp artifact.Compressor) error {
+func (ar *Reader) readHeader(headerSum []byte) error {
+
+	r, comp, err := detectCompressor(getReader(ar.menderTarReader, headerSum,
+		ar.ReadBufferSize))
+	if err != nil {
+		return errors.Wrap(err, "readHeader")
+	}
+	ar.compressor = comp
+	ar.headerCompressor = comp
 
-	r := getReader(ar.menderTarReader, headerSum)
-	// header MUST be compressed
 	gz, err := comp.NewReader(r)
 	if err != nil {
 		return errors.Wrapf(err, "readHeader: error opening %s header",
@@ -135,17 +266,27 @@ func (ar *Reader) readHeader(headerSum []byte, comp artifact.Compressor) error {
 	if err = ar.populateArtifactInfo(ar.info.Version, tr); err != nil {
 		return errors.Wrap(err, "readHeader")
 	}
-	// after reading header-info we can check device compatibility
+	// after reading header-info we can check device compatibility; the
+	// excluded device types are left out, so that devices of those types
+	// reject the Artifact.
 	if ar.CompatibleDevicesCallback != nil {
-		if err = ar.CompatibleDevicesCallback(ar.GetCompatibleDevices()); err != nil {
+		var devices []string
+		for _, device := range ar.GetCompatibleDevices() {
+			if !ar.excludesDevice(device) {
+				devices = append(devices, device)
+			}
+		}
+		if err = ar.CompatibleDevicesCallback(devices); err != nil {
 			return err
 		}
 	}
 
 	var hdr tar.Header
 
-	// Next we need to read and process state scripts.
-	if err = readStateScripts(tr, &hdr, ar.ScriptsReadCallback); err != nil {
+	// Next we need to read the state scripts, which are handed on once the
+	// type-info tells which of them only run on a given device type.
+	scripts, err := readStateScripts(tr, &hdr, ar.maxScriptsSize())
+	if err != nil {
 		return err
 	}
 
@@ -159,6 +300,9 @@ func (ar *Reader) readHeader(headerSum []byte, comp artifact.Compressor) error {
 	if err = ar.readHeaderUpdate(tr, &hdr, false); err != nil {
 		return err
 	}
+	if err = ar.handleScripts(scripts); err != nil {
+		return err
+	}
 
 	// Empty the remaining reader
 	// See (MEN-5094)
@@ -190,9 +334,12 @@ func (ar *Reader) populateArtifactInfo(version int, tr *tar.Reader) error {
 	return nil
 }
 
-func (ar *Reader) readAugmentedHeader(headerSum []byte, comp artifact.Compressor) error {
-	r := getReader(ar.menderTarReader, headerSum)
-	// header MUST be compressed
+func (ar *Reader) readAugmentedHeader(headerSum []byte) error {
+	r, comp, err := detectCompressor(getReader(ar.menderTarReader, headerSum,
+		ar.ReadBufferSize))
+	if err != nil {
+		return errors.Wrap(err, "readAugmentedHeader")
+	}
 	gz, err := comp.NewReader(r)
 	if err != nil {
 		return errors.Wrapf(err, "reader: error opening %s header",
@@ -287,19 +434,69 @@ func (ar *Reader) readManifest(name string) error {
 
 func signatureReadAndVerify(tReader *tar.Reader, message []byte,
 	verify SignatureVerifyFn, signed bool) error {
-	// verify signature
-	if verify == nil && signed {
-		return errors.New("reader: verify signature callback not registered")
-	} else if verify != nil {
-		// first read signature...
-		sig := bytes.NewBuffer(nil)
-		if _, err := io.Copy(sig, tReader); err != nil {
-			return errors.Wrapf(err, "reader: can not read signature file")
+	sig, err := ioutil.ReadAll(tReader)
+	if err != nil {
+		return errors.Wrapf(err, "reader: can not read signature file")
+	}
+	return verifySignatures(message, [][]byte{sig}, verify, signed)
+}
+
+// verifySignatures succeeds if any of the signatures of message verifies.
+// All of them are passed to verify, so that callbacks which only record the
+// result see every one. On failure the error of the first signature is
+// returned, so an Artifact with a single signature fails as before.
+func verifySignatures(message []byte, sigs [][]byte,
+	verify SignatureVerifyFn, signed bool) error {
+	if verify == nil {
+		if signed {
+			return errors.New("reader: verify signature callback not registered")
 		}
-		if err := verify(message, sig.Bytes()); err != nil {
-			return errors.Wrapf(err, "reader: invalid signature")
+		return nil
+	}
+	var firstErr error
+	verified := false
+	for _, sig := range sigs {
+		if err := verify(message, sig); err == nil {
+			verified = true
+		} else if firstErr == nil {
+			firstErr = err
 		}
 	}
+	if verified {
+		return nil
+	}
+	return errors.Wrap(artifact.NewInvalidSignatureError(firstErr), "reader")
+}
+
+// ChecksumAlgorithm returns the hash algorithm of the checksums of the
+// Artifact, which is recorded in its version file unless it is sha256.
+func (ar *Reader) ChecksumAlgorithm() string {
+	if ar.info == nil || ar.info.ChecksumAlgorithm == "" {
+		return artifact.ChecksumSHA256
+	}
+	return ar.info.ChecksumAlgorithm
+}
+
+// verifyChecksumAlgorithm checks that the checksums of the manifest have
+// the algorithm of the Artifact.
+func (ar *Reader) verifyChecksumAlgorithm() error {
+	algorithm, err := ar.manifest.Algorithm()
+	if err != nil {
+		return errors.Wrap(err, "reader")
+	}
+	if algorithm != ar.ChecksumAlgorithm() {
+		return errors.Errorf("reader: the manifest has %s checksums, not %s",
+			algorithm, ar.ChecksumAlgorithm())
+	}
+	return nil
+}
+
+func (ar *Reader) readSignature() error {
+	sig, err := ioutil.ReadAll(ar.menderTarReader)
+	if err != nil {
+		return errors.Wrapf(err, "reader: can not read signature file")
+	}
+	ar.signatures = append(ar.signatures, sig)
 	return nil
 }
 
@@ -393,6 +590,21 @@ func (ar *Reader) readHeaderV3(version []byte) error {
 		if err != nil {
 			return errors.Wrap(err, "readHeaderV3")
 		}
+		ar.stageProgress.begin(hdr.Name, hdr.Size, 0)
+		if n, ok := artifact.SignatureFileNumber(hdr.Name); ok && n > 0 {
+			// Additional signatures follow manifest.sig in sequence, and
+			// are not part of the grammar.
+			if n != len(ar.signatures) {
+				return fmt.Errorf(
+					"Invalid structure: %s, wrong element: %s",
+					append(parsePath, hdr.Name), hdr.Name,
+				)
+			}
+			if err = ar.readSignature(); err != nil {
+				return errors.Wrap(err, "readHeaderV3")
+			}
+			continue
+		}
 		parsePath = append(parsePath, hdr.Name)
 		nextParseToken, validPath, err := verifyParseOrder(parsePath)
 		// Only error returned is errParseOrder.
@@ -401,6 +613,15 @@ func (ar *Reader) readHeaderV3(version []byte) error {
 				"Invalid structure: %s, wrong element: %s", parsePath, parsePath[len(parsePath)-1],
 			)
 		}
+		if len(ar.signatures) > 0 {
+			// All the signatures have been read; any of them will do.
+			err = verifySignatures(ar.manifest.GetRaw(), ar.signatures,
+				ar.VerifySignatureCallback, ar.shouldBeSigned)
+			ar.signatures = nil
+			if err != nil {
+				return errors.Wrap(err, "readHeaderV3")
+			}
+		}
 		err = ar.handleHeaderReads(nextParseToken, version)
 		if err != nil {
 			return errors.Wrap(err, "readHeaderV3")
@@ -432,6 +653,9 @@ func (ar *Reader) handleHeaderReads(headerName string, version []byte) error {
 		if err != nil {
 			return err
 		}
+		if err = ar.verifyChecksumAlgorithm(); err != nil {
+			return err
+		}
 		// verify checksums of version
 		if err = verifyVersion(version, ar.manifest); err != nil {
 			return err
@@ -439,11 +663,8 @@ func (ar *Reader) handleHeaderReads(headerName string, version []byte) error {
 		return err
 	case "manifest.sig":
 		ar.IsSigned = true
-		// First read and verify signature
-		if err = signatureReadAndVerify(ar.menderTarReader, ar.manifest.GetRaw(),
-			ar.VerifySignatureCallback, ar.shouldBeSigned); err != nil {
-			return err
-		}
+		// The signature is verified once any further ones have been read.
+		return ar.readSignature()
 	case "manifest-augment":
 		// Get the data from the augmented manifest.
 		ar.augmentFiles, err = readManifestHeader(ar, ar.menderTarReader)
@@ -455,29 +676,18 @@ func (ar *Reader) handleHeaderReads(headerName string, version []byte) error {
 			return err
 		}
 
-		comp, err := artifact.NewCompressorFromFileName(headerName)
-		if err != nil {
-			return errors.New("reader: can't get compressor")
-		}
-		ar.compressor = comp
-
-		if err := ar.readHeader(hc, comp); err != nil {
+		if err := ar.readHeader(hc); err != nil {
 			return errors.Wrap(err, "handleHeaderReads")
 		}
 	case "header-augment.tar", "header-augment.tar.gz",
-		"header-augment.tar.xz", "header-augument.tar.zst":
+		"header-augment.tar.xz", "header-augment.tar.zst":
 		// Get and verify checksums of the augmented header.
 		hc, err := ar.manifest.GetAndMark(headerName)
 		if err != nil {
 			return err
 		}
 
-		comp, err := artifact.NewCompressorFromFileName(headerName)
-		if err != nil {
-			return errors.New("reader: can't get compressor")
-		}
-
-		if err := ar.readAugmentedHeader(hc, comp); err != nil {
+		if err := ar.readAugmentedHeader(hc); err != nil {
 			return errors.Wrap(err, "handleHeaderReads: Failed to read the augmented header")
 		}
 	default:
@@ -525,6 +735,10 @@ func (ar *Reader) readHeaderV2(version []byte) error {
 	if err != nil {
 		return err
 	}
+	ar.stageProgress.complete("manifest", int64(len(ar.manifest.GetRaw())))
+	if err = ar.verifyChecksumAlgorithm(); err != nil {
+		return err
+	}
 
 	// check what is the next file in the artifact
 	// depending if artifact is signed or not we can have
@@ -533,6 +747,7 @@ func (ar *Reader) readHeaderV2(version []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "reader: error reading file after manifest")
 	}
+	ar.stageProgress.begin(hdr.Name, hdr.Size, 0)
 
 	// we are expecting to have a signed artifact, but the signature is missing
 	if ar.shouldBeSigned && (hdr.FileInfo().Name() != "manifest.sig") {
@@ -558,6 +773,7 @@ func (ar *Reader) readHeaderV2(version []byte) error {
 		if err != nil {
 			return errors.New("reader: error reading header")
 		}
+		ar.stageProgress.begin(hdr.Name, hdr.Size, 0)
 		name = hdr.FileInfo().Name()
 		if !strings.HasPrefix(name, "header.tar") {
 			return errors.Errorf("reader: invalid header element: %v", hdr.Name)
@@ -576,13 +792,7 @@ func (ar *Reader) readHeaderV2(version []byte) error {
 			return err
 		}
 
-		comp, err := artifact.NewCompressorFromFileName(name)
-		if err != nil {
-			return errors.New("reader: can't get compressor")
-		}
-		ar.compressor = comp
-
-		if err := ar.readHeader(hc, comp); err != nil {
+		if err := ar.readHeader(hc); err != nil {
 			return err
 		}
 
@@ -602,12 +812,40 @@ func (ar *Reader) ReadArtifact() error {
 	return ar.ReadArtifactData()
 }
 
-func (ar *Reader) ReadArtifactHeaders() error {
+func (ar *Reader) ReadArtifactHeaders() (err error) {
 	// each artifact is tar archive
 	if ar.r == nil {
 		return errors.New("reader: read artifact called on invalid stream")
 	}
-	ar.menderTarReader = tar.NewReader(ar.r)
+	if ar.seeker != nil {
+		// The headers can be read again.
+		if _, err := ar.seeker.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "reader: can not seek in the Artifact")
+		}
+		ar.scripts = nil
+	}
+	readAhead := ar.ReadAheadBuffers
+	if ar.seeker != nil {
+		// Reading ahead would move the position of the seeker.
+		readAhead = 0
+	}
+	r := artifact.NewReadBuffer(ar.r, ar.ReadBufferSize, readAhead)
+	if ra, ok := r.(*artifact.ReadAheadReader); ok {
+		ar.readAhead = ra
+	}
+	defer func() {
+		// The data is not read after a failure, which would stop reading
+		// ahead.
+		if err != nil {
+			ar.Close()
+		}
+	}()
+	ar.stageProgress = nil
+	if ar.StageProgressCallback != nil {
+		ar.stageProgress = &stageProgress{r: r, fn: ar.StageProgressCallback}
+		r = ar.stageProgress
+	}
+	ar.menderTarReader = tar.NewReader(r)
 
 	// first file inside the artifact MUST be version
 	ver, vRaw, err := ReadVersion(ar.menderTarReader)
@@ -615,25 +853,45 @@ func (ar *Reader) ReadArtifactHeaders() error {
 		return errors.Wrapf(err, "reader: can not read version file")
 	}
 	ar.info = ver
+	ar.stageProgress.complete("version", int64(len(vRaw)))
+	if err = artifact.ValidateChecksumAlgorithm(ver.ChecksumAlgorithm); err != nil {
+		return errors.Wrap(err, "reader")
+	}
 
 	switch ver.Version {
-	case 1:
-		err = errors.New("reader: Mender-Artifact version 1 is no longer supported")
 	case 2:
 		err = ar.readHeaderV2(vRaw)
 	case 3:
 		err = ar.readHeaderV3(vRaw)
 	default:
-		return errors.Errorf("reader: unsupported version: %d", ver.Version)
+		return errors.Wrap(&artifact.ErrUnsupportedVersion{Got: ver.Version}, "reader")
 	}
 	if err != nil {
 		return err
 	}
 
+	if ar.TranslateLegacyProvides {
+		ar.translateLegacyProvides()
+	}
 	return nil
 }
 
+func (ar *Reader) translateLegacyProvides() {
+	for _, inst := range ar.installers {
+		for _, w := range []io.Writer{
+			inst.GetUpdateOriginalTypeInfoWriter(),
+			inst.GetUpdateAugmentTypeInfoWriter(),
+		} {
+			if typeInfo, ok := w.(*artifact.TypeInfoV3); ok && typeInfo != nil {
+				typeInfo.ArtifactProvides.TranslateLegacy()
+			}
+		}
+	}
+}
+
 func (ar *Reader) ReadArtifactData() error {
+	defer ar.Close()
+
 	err := ar.initializeUpdateStorers()
 	if err != nil {
 		return err
@@ -645,7 +903,14 @@ func (ar *Reader) ReadArtifactData() error {
 	}
 	if ar.manifest != nil {
 		notMarked := ar.manifest.FilesNotMarked()
-		if len(notMarked) > 0 {
+		if len(notMarked) > 0 && ar.DamageCallback != nil {
+			for _, name := range notMarked {
+				if err = ar.DamageCallback(name,
+					errors.New("missing from the Artifact")); err != nil {
+					return err
+				}
+			}
+		} else if len(notMarked) > 0 {
 			return fmt.Errorf(
 				"Files found in manifest(s), that were not part of artifact: %s",
 				strings.Join(notMarked, ", "),
@@ -656,6 +921,41 @@ func (ar *Reader) ReadArtifactData() error {
 	return nil
 }
 
+// IsMetadataImmutable returns true if the Artifact was written with its
+// metadata locked against modification.
+func (ar *Reader) IsMetadataImmutable() bool {
+	for _, inst := range ar.installers {
+		typeInfo, ok := inst.GetUpdateOriginalTypeInfoWriter().(*artifact.TypeInfoV3)
+		if ok && typeInfo != nil && typeInfo.ImmutableMetadata {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops any background read-ahead of the artifact stream. It is called
+// automatically by ReadArtifactData, and only needs to be called explicitly
+// when reading the headers alone.
+func (ar *Reader) Close() error {
+	if ar.readAhead != nil {
+		return ar.readAhead.Close()
+	}
+	return nil
+}
+
+// GetDeviceTypeScripts returns the names of the state scripts which only run
+// on devices of a given device type, by device type, as listed in the
+// type-info.
+func (ar *Reader) GetDeviceTypeScripts() map[string][]string {
+	for i := 0; i < len(ar.installers); i++ {
+		typeInfo, ok := ar.installers[i].GetUpdateOriginalTypeInfoWriter().(*artifact.TypeInfoV3)
+		if ok && typeInfo != nil && len(typeInfo.DeviceTypeScripts) > 0 {
+			return typeInfo.DeviceTypeScripts
+		}
+	}
+	return nil
+}
+
 func (ar *Reader) GetCompatibleDevices() []string {
 	if ar.hInfo == nil {
 		return nil
@@ -663,6 +963,23 @@ func (ar *Reader) GetCompatibleDevices() []string {
 	return ar.hInfo.GetCompatibleDevices()
 }
 
+// GetExcludedDevices returns the device types the Artifact must not be
+// installed on, see artifact.ArtifactDepends.ExcludedDevices.
+func (ar *Reader) GetExcludedDevices() []string {
+	if ar.hInfo == nil {
+		return nil
+	}
+	if depends := ar.hInfo.GetArtifactDepends(); depends != nil {
+		return depends.ExcludedDevices
+	}
+	return nil
+}
+
+func (ar *Reader) excludesDevice(deviceType string) bool {
+	depends := ar.hInfo.GetArtifactDepends()
+	return depends != nil && depends.ExcludesDevice(deviceType)
+}
+
 func (ar *Reader) GetArtifactName() string {
 	if ar.hInfo == nil {
 		return ""
@@ -674,6 +991,16 @@ func (ar *Reader) GetInfo() artifact.Info {
 	return *ar.info
 }
 
+// Manifest returns the checksums of the files of the Artifact, including
+// those of the augmented manifest, in the format of sha256sum. It is only
+// available once the headers have been read.
+func (ar *Reader) Manifest() []byte {
+	if ar.manifest == nil {
+		return nil
+	}
+	return ar.manifest.GetRaw()
+}
+
 func (ar *Reader) GetUpdates() []artifact.UpdateType {
 	if ar.hInfo == nil {
 		return nil
@@ -937,6 +1264,7 @@ func (ar *Reader) readNextDataFile(tr *tar.Reader) error {
 		return errors.Wrapf(err,
 			"reader: can not find parser for parsing data file [%v]", hdr.Name)
 	}
+	ar.stageProgress.begin(hdr.Name, hdr.Size, updNo)
 
 	var r io.Reader
 	if ar.ProgressReader != nil {
@@ -944,7 +1272,13 @@ func (ar *Reader) readNextDataFile(tr *tar.Reader) error {
 	} else {
 		r = tr
 	}
-	return ar.readAndInstall(r, inst, updNo, comp)
+	err = ar.readAndInstall(r, inst, updNo)
+	if err != nil && ar.DamageCallback != nil {
+		// The rest of the payload is skipped by the next call to
+		// getNext.
+		return ar.DamageCallback(hdr.Name, err)
+	}
+	return err
 }
 
 func (ar *Reader) readData(tr *tar.Reader) error {
@@ -1016,10 +1350,16 @@ func getDataFile(i handlers.Installer, name string) *handlers.DataFile {
 	return nil
 }
 
-func (ar *Reader) readAndInstall(r io.Reader, i handlers.Installer, no int,
-	comp artifact.Compressor) error {
+func (ar *Reader) readAndInstall(r io.Reader, i handlers.Installer, no int) error {
+
+	r, comp, err := detectCompressor(r)
+	if err != nil {
+		return errors.Wrap(err, "Payload: can not detect the compression")
+	}
+	// The compression of the Artifact is that of its data, which is not
+	// necessarily that of the header.
+	ar.compressor = comp
 
-	// each data file is stored in tar.gz format
 	gz, err := comp.NewReader(r)
 	if err != nil {
 		return errors.Wrapf(err, "Payload: can not open %s file for reading data",
@@ -1048,18 +1388,66 @@ func (ar *Reader) readAndInstall(r io.Reader, i handlers.Installer, no int,
 	return err
 }
 
-func (ar *Reader) readAndInstallDataFiles(tar *tar.Reader, i handlers.Installer,
+// keptDataFile is a copy of a payload file which later files can be stored
+// as links to.
+type keptDataFile struct {
+	hdr  tar.Header
+	path string
+}
+
+// sharedChecksums returns the checksums which more than one file of the
+// payload has, according to the manifest.
+func (ar *Reader) sharedChecksums(no int) map[string]bool {
+	shared := map[string]bool{}
+	if ar.manifest == nil {
+		return shared
+	}
+	seen := map[string]bool{}
+	for _, file := range ar.manifest.Files() {
+		if filepath.Dir(file) != artifact.UpdatePath(no) {
+			continue
+		}
+		sum, err := ar.manifest.Get(file)
+		if err != nil {
+			continue
+		}
+		if seen[string(sum)] {
+			shared[string(sum)] = true
+		}
+		seen[string(sum)] = true
+	}
+	return shared
+}
+
+func (ar *Reader) readAndInstallDataFiles(tr *tar.Reader, i handlers.Installer,
 	no int, comp artifact.Compressor, updateStorer handlers.UpdateStorer) error {
 
+	// Files with the same content as another file of the payload are kept
+	// until the end of the payload, as the other file may be stored as a
+	// link to them.
+	shared := ar.sharedChecksums(no)
+	kept := map[string]keptDataFile{}
+	defer func() {
+		for _, k := range kept {
+			utils.RemoveTemp(k.path)
+		}
+	}()
+
+	// The files read, in order, whose checksums are not verified yet.
+	var pending []pendingDataFile
+
 	matcher := regexp.MustCompile(`^[\w\-.,]+$`)
 	for {
-		hdr, err := tar.Next()
+		hdr, err := tr.Next()
 		if errors.Cause(err) == io.EOF {
 			break
 		} else if err != nil {
 			return errors.Wrap(err, "Payload: error reading Artifact file header")
 		}
 
+		if err = artifact.ValidateArchivePath(hdr.Name); err != nil {
+			return errors.Wrap(err, "Payload")
+		}
 		df := getDataFile(i, hdr.Name)
 		if df == nil {
 			return errors.Errorf("Payload: can not find data file: %s", hdr.Name)
@@ -1072,6 +1460,26 @@ func (ar *Reader) readAndInstallDataFiles(tar *tar.Reader, i handlers.Installer,
 			return fmt.Errorf("%s. %s", message, info)
 		}
 
+		var r io.Reader = tr
+		var linked *os.File
+		if hdr.Typeflag == tar.TypeLink {
+			target, ok := kept[hdr.Linkname]
+			if !ok {
+				return errors.Errorf(
+					"Payload: data file %s links to %s, which is not an earlier"+
+						" file with the same content", hdr.Name, hdr.Linkname)
+			}
+			if linked, err = os.Open(target.path); err != nil {
+				return errors.Wrapf(err, "Payload: can not read data file: %s", hdr.Name)
+			}
+			targetHdr := target.hdr
+			targetHdr.Name = hdr.Name
+			hdr = &targetHdr
+			r = linked
+			ar.linkedDataFiles = append(ar.linkedDataFiles,
+				filepath.Join(artifact.UpdatePath(no), hdr.Name))
+		}
+
 		// fill in needed data
 		info := hdr.FileInfo()
 		df.Size = info.Size()
@@ -1089,24 +1497,119 @@ func (ar *Reader) readAndInstallDataFiles(tar *tar.Reader, i handlers.Installer,
 			}
 		}
 		if df.Checksum == nil {
-			return errors.Errorf("Payload: checksum missing for file: %s", hdr.Name)
+			return errors.Wrap(artifact.NewChecksumMissingError(hdr.Name), "Payload")
 		}
 
-		// check checksum
-		ch := artifact.NewReaderChecksum(tar, df.Checksum)
-
-		if err = updateStorer.StoreUpdate(ch, info); err != nil {
-			return errors.Wrapf(err, "Payload: can not install Payload: %s", hdr.Name)
+		var keep *os.File
+		if shared[string(df.Checksum)] && hdr.Typeflag != tar.TypeLink {
+			if keep, err = utils.TempFile("", "data"); err != nil {
+				return errors.Wrap(err, "Payload: can not keep a copy of data file")
+			}
+			kept[hdr.Name] = keptDataFile{hdr: *hdr, path: keep.Name()}
+			r = io.TeeReader(r, keep)
 		}
 
-		if err = ch.Verify(); err != nil {
-			return errors.Wrap(err, "reader: error reading data")
+		r = newFileProgress(r, ar.DataFileProgressCallback, ProgressEvent{
+			Stage:   stage.Data,
+			Name:    hdr.Name,
+			Payload: no,
+			Size:    df.Size,
+		})
+
+		// check checksum
+		var ch dataChecksum
+		if ar.ChecksumWorkers > 0 {
+			ch = newConcurrentChecksum(r, df.Checksum)
+		} else {
+			ch = artifact.NewReaderChecksum(r, df.Checksum)
+		}
+		storeErr := updateStorer.StoreUpdate(ch, info)
+		if cc, ok := ch.(*concurrentChecksum); ok {
+			cc.end()
+		}
+		if linked != nil {
+			linked.Close()
+		}
+		if keep != nil {
+			keep.Close()
+		}
+		pending = append(pending, pendingDataFile{
+			name:     hdr.Name,
+			size:     df.Size,
+			checksum: df.Checksum,
+			linked:   linked != nil,
+			kept:     keep != nil,
+			storeErr: storeErr,
+			sum:      ch,
+		})
+		// Without workers, every file is verified right away.
+		for len(pending) > 0 && len(pending) >= ar.ChecksumWorkers {
+			if err = ar.finishDataFile(pending[0], no, kept); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+	}
+	for _, f := range pending {
+		if err := ar.finishDataFile(f, no, kept); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// finishDataFile verifies the checksum of the payload file f, once it is
+// hashed, and reports it to the callbacks.
+func (ar *Reader) finishDataFile(f pendingDataFile, no int,
+	kept map[string]keptDataFile) error {
+
+	err := f.storeErr
+	if _, ok := f.sum.(*concurrentChecksum); ok && err == nil {
+		// Fail like the other files, whose checksum Read verifies as
+		// they are stored.
+		err = f.sum.Verify()
+	}
+	if err != nil {
+		setChecksumMismatchFile(err, f.name)
+		err = errors.Wrapf(err, "Payload: can not install Payload: %s", f.name)
+	} else if err = f.sum.Verify(); err != nil {
+		setChecksumMismatchFile(err, f.name)
+		err = errors.Wrap(err, "reader: error reading data")
+	}
+	if f.kept && err != nil {
+		// Links to a damaged file fail to resolve.
+		utils.RemoveTemp(kept[f.name].path)
+		delete(kept, f.name)
+	}
+	if ar.PayloadFileCallback != nil {
+		event := PayloadFileEvent{
+			Payload:  no,
+			Name:     f.name,
+			Size:     f.size,
+			Checksum: string(f.checksum),
+			Linked:   f.linked,
+			Err:      err,
+		}
+		if cbErr := ar.PayloadFileCallback(event); cbErr != nil {
+			return cbErr
+		}
+	}
+	if err != nil && ar.DamageCallback != nil {
+		// Skip to the next file, which is only possible as long
+		// as the compressed stream is intact.
+		err = ar.DamageCallback(filepath.Join(artifact.UpdatePath(no), f.name), err)
+	}
+	return err
+}
+
+// LinkedDataFiles returns the payload files read so far which were stored as
+// links to another file with the same content, see
+// awriter.WriteArtifactArgs.DeduplicateFiles.
+func (ar *Reader) LinkedDataFiles() []string {
+	return ar.linkedDataFiles
+}
+
 func (ar *Reader) GetUpdateStorers() ([]handlers.UpdateStorer, error) {
 	err := ar.initializeUpdateStorers()
 	if err != nil {
@@ -1163,9 +1666,58 @@ func (ar *Reader) MergeArtifactDepends() (map[string]interface{}, error) {
 		}
 	}
 
+	if err = ar.mergeDeltaDepends(retMap); err != nil {
+		return nil, err
+	}
+
 	return retMap, nil
 }
 
+// mergeDeltaDepends adds the base Artifacts of delta payloads to the
+// artifact_name depends, as the payloads can only be installed on top of them.
+func (ar *Reader) mergeDeltaDepends(depends map[string]interface{}) error {
+	for _, upd := range ar.installers {
+		metaData, err := upd.GetUpdateMetaData()
+		if err != nil {
+			return err
+		}
+		delta, err := artifact.GetDeltaInfo(metaData)
+		if err != nil {
+			return err
+		} else if delta == nil {
+			continue
+		}
+		if names, ok := depends["artifact_name"]; ok && !dependsOn(names, delta.BaseArtifactName) {
+			return fmt.Errorf(
+				"Delta payload requires Artifact %q, which is not in the artifact_name depends",
+				delta.BaseArtifactName,
+			)
+		}
+		depends["artifact_name"] = []interface{}{delta.BaseArtifactName}
+	}
+	return nil
+}
+
+func dependsOn(names interface{}, name string) bool {
+	switch names := names.(type) {
+	case string:
+		return names == name
+	case []string:
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (ar *Reader) MergeArtifactProvides() (map[string]string, error) {
 
 	provides := ar.GetArtifactProvides()
@@ -1209,12 +1761,59 @@ func (ar *Reader) MergeArtifactProvides() (map[string]string, error) {
 
 func (ar *Reader) MergeArtifactClearsProvides() []string {
 	var list []string
-	for _, inst := range ar.installers {
-		list = append(list, inst.GetUpdateClearsProvides()...)
+	// In the order of the payloads, which the map does not keep.
+	for i := 0; i < len(ar.installers); i++ {
+		if inst, ok := ar.installers[i]; ok {
+			list = append(list, inst.GetUpdateClearsProvides()...)
+		}
 	}
 	return list
 }
 
+// GroupTransition returns what installing the Artifact does to the
+// artifact_group of a device. The headers must have been read.
+func (ar *Reader) GroupTransition() artifact.GroupTransition {
+	return artifact.NewGroupTransition(ar.GetArtifactProvides(), ar.GetArtifactDepends(),
+		ar.MergeArtifactClearsProvides())
+}
+
+// ExternalFiles returns the payload files of thin Artifacts, which are
+// stored outside the Artifact, by payload index. The headers must have been
+// read.
+func (ar *Reader) ExternalFiles() (map[int][]artifact.ExternalFile, error) {
+	files := map[int][]artifact.ExternalFile{}
+	for i, inst := range ar.installers {
+		metaData, err := inst.GetUpdateMetaData()
+		if err != nil {
+			return nil, err
+		}
+		external, err := artifact.GetExternalFiles(metaData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "payload %04d", i)
+		} else if external != nil {
+			files[i] = external
+		}
+	}
+	return files, nil
+}
+
 func (ar *Reader) Compressor() artifact.Compressor {
 	return ar.compressor
 }
+
+// HasUncompressedHeader returns true if the header of the Artifact is stored
+// without compression while its data is compressed.
+func (ar *Reader) HasUncompressedHeader() bool {
+	return ar.headerCompressor != nil && ar.compressor != nil &&
+		ar.headerCompressor.GetFileExtension() == "" &&
+		ar.compressor.GetFileExtension() != ""
+}
+
+// setChecksumMismatchFile records the name of the offending file if err is a
+// checksum mismatch.
+func setChecksumMismatchFile(err error, name string) {
+	var mismatch *artifact.ErrChecksumMismatch
+	if errors.As(err, &mismatch) {
+		mismatch.File = name
+	}
+}
@@ -25,10 +25,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/artifact/stage"
 	"github.com/mendersoftware/mender-artifact/awriter"
 	"github.com/mendersoftware/mender-artifact/handlers"
 	"github.com/pkg/errors"
@@ -328,6 +332,433 @@ func TestReadArtifact(t *testing.T) {
 	}
 }
 
+func TestReadUncompressedHeader(t *testing.T) {
+	for _, comp := range []artifact.Compressor{
+		artifact.NewCompressorGzip(),
+		artifact.NewCompressorLzma(),
+		artifact.NewCompressorZstd(zstd.SpeedFastest),
+	} {
+		for _, uncompressed := range []bool{false, true} {
+			name := fmt.Sprintf("%s uncompressed header: %v", comp.GetFileExtension(), uncompressed)
+			t.Run(name, func(t *testing.T) {
+				upd, err := MakeFakeUpdate(TestUpdateFileContent)
+				require.NoError(t, err)
+				defer os.Remove(upd)
+
+				composer := handlers.NewRootfsV3("")
+				art := bytes.NewBuffer(nil)
+				err = awriter.NewWriter(art, comp).WriteArtifact(&awriter.WriteArtifactArgs{
+					Format:  "mender",
+					Version: 3,
+					Devices: []string{"vexpress"},
+					Name:    "mender-1.1",
+					Updates: &awriter.Updates{
+						Updates:  []handlers.Composer{composer},
+						Augments: []handlers.Composer{handlers.NewAugmentedRootfs(composer, upd)},
+					},
+					Provides:           &artifact.ArtifactProvides{ArtifactName: "mender-1.1"},
+					Depends:            &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+					UncompressedHeader: uncompressed,
+				})
+				require.NoError(t, err)
+
+				updFileContent := bytes.NewBuffer(nil)
+				rfh := handlers.NewRootfsInstaller()
+				rfh.SetUpdateStorerProducer(&testUpdateStorer{updFileContent})
+				aReader := NewReader(art)
+				require.NoError(t, aReader.RegisterHandler(rfh))
+				require.NoError(t, aReader.ReadArtifact())
+
+				assert.Equal(t, TestUpdateFileContent, updFileContent.String())
+				assert.Equal(t, comp.GetFileExtension(),
+					aReader.Compressor().GetFileExtension())
+				assert.Equal(t, uncompressed, aReader.HasUncompressedHeader())
+			})
+		}
+	}
+}
+
+func TestReadMisnamedPayload(t *testing.T) {
+	upd, err := MakeFakeUpdate(TestUpdateFileContent)
+	require.NoError(t, err)
+	defer os.Remove(upd)
+
+	comp := artifact.NewCompressorZstd(zstd.SpeedFastest)
+	composer := handlers.NewRootfsV3(upd)
+	art := bytes.NewBuffer(nil)
+	err = awriter.NewWriter(art, comp).WriteArtifact(&awriter.WriteArtifactArgs{
+		Format:   "mender",
+		Version:  3,
+		Devices:  []string{"vexpress"},
+		Name:     "mender-1.1",
+		Updates:  &awriter.Updates{Updates: []handlers.Composer{composer}},
+		Provides: &artifact.ArtifactProvides{ArtifactName: "mender-1.1"},
+		Depends:  &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+	})
+	require.NoError(t, err)
+
+	// Store the zstd compressed Payload under a gzip file name.
+	renamed := bytes.NewBuffer(nil)
+	tr := tar.NewReader(art)
+	tw := tar.NewWriter(renamed)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Name == "data/0000.tar.zst" {
+			hdr.Name = "data/0000.tar.gz"
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	updFileContent := bytes.NewBuffer(nil)
+	rfh := handlers.NewRootfsInstaller()
+	rfh.SetUpdateStorerProducer(&testUpdateStorer{updFileContent})
+	aReader := NewReader(renamed)
+	require.NoError(t, aReader.RegisterHandler(rfh))
+	require.NoError(t, aReader.ReadArtifact())
+
+	assert.Equal(t, TestUpdateFileContent, updFileContent.String())
+	assert.Equal(t, ".zst", aReader.Compressor().GetFileExtension())
+}
+
+// writeDamagedArtifact writes an Artifact with two module-image payloads of
+// two files each, and then passes it through damage.
+func writeDamagedArtifact(
+	t *testing.T,
+	damage func(hdr *tar.Header, data []byte) []byte,
+) io.Reader {
+	var updates []handlers.Composer
+	for _, updateType := range []string{"app", "config"} {
+		u := handlers.NewModuleImage(updateType)
+		var files []*handlers.DataFile
+		for _, name := range []string{"first", "second"} {
+			content := updateType + " " + name
+			files = append(files, &handlers.DataFile{
+				Name:   name,
+				Reader: strings.NewReader(content),
+				Size:   int64(len(content)),
+			})
+		}
+		require.NoError(t, u.SetUpdateFiles(files))
+		updates = append(updates, u)
+	}
+	art := bytes.NewBuffer(nil)
+	err := awriter.NewWriter(art, artifact.NewCompressorNone()).WriteArtifact(
+		&awriter.WriteArtifactArgs{
+			Format:   "mender",
+			Version:  3,
+			Devices:  []string{"vexpress"},
+			Name:     "mender-1.1",
+			Updates:  &awriter.Updates{Updates: updates},
+			Provides: &artifact.ArtifactProvides{ArtifactName: "mender-1.1"},
+			Depends:  &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+		})
+	require.NoError(t, err)
+
+	damaged := bytes.NewBuffer(nil)
+	tr := tar.NewReader(art)
+	tw := tar.NewWriter(damaged)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		data = damage(hdr, data)
+		hdr.Size = int64(len(data))
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return damaged
+}
+
+func TestReadDamaged(t *testing.T) {
+	tc := map[string]struct {
+		damage  func(hdr *tar.Header, data []byte) []byte
+		damaged []string
+	}{
+		"payload file": {
+			damage: func(hdr *tar.Header, data []byte) []byte {
+				return bytes.Replace(data, []byte("app second"), []byte("app SECOND"), 1)
+			},
+			damaged: []string{"data/0000/second"},
+		},
+		"payload": {
+			damage: func(hdr *tar.Header, data []byte) []byte {
+				if hdr.Name == "data/0000.tar" {
+					return []byte("not a tar archive")
+				}
+				return data
+			},
+			damaged: []string{"data/0000.tar", "data/0000/first", "data/0000/second"},
+		},
+	}
+	for name, test := range tc {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, NewReader(writeDamagedArtifact(t, test.damage)).ReadArtifact())
+
+			var damaged []string
+			ar := NewReader(writeDamagedArtifact(t, test.damage))
+			ar.DamageCallback = func(name string, err error) error {
+				assert.Error(t, err)
+				damaged = append(damaged, name)
+				return nil
+			}
+			require.NoError(t, ar.ReadArtifact())
+			sort.Strings(damaged)
+			assert.Equal(t, test.damaged, damaged)
+		})
+	}
+
+	// The callback can still stop the read.
+	ar := NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+		return bytes.Replace(data, []byte("config first"), []byte("config FIRST"), 1)
+	}))
+	ar.DamageCallback = func(name string, err error) error {
+		return errors.Wrap(err, name)
+	}
+	err := ar.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "data/0001/first")
+}
+
+func TestPayloadFileCallback(t *testing.T) {
+	checksum := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	damage := func(hdr *tar.Header, data []byte) []byte {
+		return bytes.Replace(data, []byte("config second"), []byte("config SECOND"), 1)
+	}
+
+	var events []PayloadFileEvent
+	ar := NewReader(writeDamagedArtifact(t, damage))
+	ar.PayloadFileCallback = func(event PayloadFileEvent) error {
+		events = append(events, event)
+		return nil
+	}
+	ar.DamageCallback = func(name string, err error) error {
+		return nil
+	}
+	require.NoError(t, ar.ReadArtifact())
+
+	require.Len(t, events, 4)
+	for i, event := range events {
+		updateType := []string{"app", "config"}[i/2]
+		name := []string{"first", "second"}[i%2]
+		content := updateType + " " + name
+		assert.Equal(t, i/2, event.Payload)
+		assert.Equal(t, name, event.Name)
+		assert.Equal(t, int64(len(content)), event.Size)
+		assert.Equal(t, checksum(content), event.Checksum)
+		assert.False(t, event.Linked)
+		if i == 3 {
+			assert.Error(t, event.Err)
+		} else {
+			assert.NoError(t, event.Err)
+		}
+	}
+
+	// The callback can stop the read.
+	ar = NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+		return data
+	}))
+	ar.PayloadFileCallback = func(event PayloadFileEvent) error {
+		return errors.New("stop")
+	}
+	err := ar.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stop")
+}
+
+func TestReadChecksumWorkers(t *testing.T) {
+	damage := func(hdr *tar.Header, data []byte) []byte {
+		return bytes.Replace(data, []byte("app second"), []byte("app SECOND"), 1)
+	}
+	for _, workers := range []int{1, 2, 8} {
+		ar := NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+			return data
+		}))
+		ar.ChecksumWorkers = workers
+		require.NoError(t, ar.ReadArtifact(), workers)
+
+		ar = NewReader(writeDamagedArtifact(t, damage))
+		ar.ChecksumWorkers = workers
+		err := ar.ReadArtifact()
+		require.Error(t, err, workers)
+		var mismatch *artifact.ErrChecksumMismatch
+		require.True(t, errors.As(err, &mismatch), workers)
+		assert.Equal(t, "second", mismatch.File)
+
+		// The files are still reported in order.
+		var names []string
+		var damaged []string
+		ar = NewReader(writeDamagedArtifact(t, damage))
+		ar.ChecksumWorkers = workers
+		ar.PayloadFileCallback = func(event PayloadFileEvent) error {
+			names = append(names, fmt.Sprintf("%d/%s", event.Payload, event.Name))
+			return nil
+		}
+		ar.DamageCallback = func(name string, err error) error {
+			damaged = append(damaged, name)
+			return nil
+		}
+		require.NoError(t, ar.ReadArtifact(), workers)
+		assert.Equal(t, []string{"0/first", "0/second", "1/first", "1/second"}, names)
+		assert.Equal(t, []string{"data/0000/second"}, damaged)
+	}
+}
+
+func TestProgressCallbacks(t *testing.T) {
+	ar := NewReader(writeDamagedArtifact(t, func(hdr *tar.Header, data []byte) []byte {
+		return data
+	}))
+	var names []string
+	last := map[string]ProgressEvent{}
+	ar.StageProgressCallback = func(event ProgressEvent) {
+		if _, ok := last[event.Name]; !ok {
+			names = append(names, event.Name)
+			assert.Zero(t, event.Read)
+		}
+		assert.LessOrEqual(t, event.Read, event.Size)
+		last[event.Name] = event
+	}
+	var files []ProgressEvent
+	ar.DataFileProgressCallback = func(event ProgressEvent) {
+		if event.Read == 0 {
+			files = append(files, event)
+		}
+		files[len(files)-1] = event
+	}
+	require.NoError(t, ar.ReadArtifact())
+
+	require.Len(t, names, 5)
+	assert.Equal(t, []string{"version", "manifest"}, names[:2])
+	assert.True(t, strings.HasPrefix(names[2], "header.tar"))
+	assert.True(t, strings.HasPrefix(names[3], "data/0000.tar"))
+	assert.True(t, strings.HasPrefix(names[4], "data/0001.tar"))
+	for i, name := range names {
+		event := last[name]
+		assert.Equal(t, []string{stage.Version, stage.Manifest, stage.Header,
+			stage.Data, stage.Data}[i], event.Stage)
+		assert.Equal(t, event.Size, event.Read, name)
+	}
+	assert.Equal(t, 1, last[names[4]].Payload)
+
+	require.Len(t, files, 4)
+	for i, event := range files {
+		content := []string{"app", "config"}[i/2] + " " + []string{"first", "second"}[i%2]
+		assert.Equal(t, stage.Data, event.Stage)
+		assert.Equal(t, i/2, event.Payload)
+		assert.Equal(t, int64(len(content)), event.Size)
+		assert.Equal(t, event.Size, event.Read)
+	}
+}
+
+// renameTarEntry returns a copy of the tar archive data with the entry
+// called from renamed to to.
+func renameTarEntry(t *testing.T, data []byte, from, to string) []byte {
+	renamed := bytes.NewBuffer(nil)
+	tr := tar.NewReader(bytes.NewReader(data))
+	tw := tar.NewWriter(renamed)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Name == from {
+			hdr.Name = to
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return renamed.Bytes()
+}
+
+func TestReadUnsafePaths(t *testing.T) {
+	tc := map[string]func(hdr *tar.Header, data []byte) []byte{
+		"manifest entry": func(hdr *tar.Header, data []byte) []byte {
+			if hdr.Name == "manifest" {
+				return bytes.Replace(data,
+					[]byte("data/0000/first"), []byte("data/0000/../../first"), 1)
+			}
+			return data
+		},
+		"absolute manifest entry": func(hdr *tar.Header, data []byte) []byte {
+			if hdr.Name == "manifest" {
+				return bytes.Replace(data,
+					[]byte("data/0000/first"), []byte("/etc/first"), 1)
+			}
+			return data
+		},
+		"payload entry": func(hdr *tar.Header, data []byte) []byte {
+			if hdr.Name == "data/0000.tar" {
+				return renameTarEntry(t, data, "first", "../first")
+			}
+			return data
+		},
+	}
+	for name, damage := range tc {
+		t.Run(name, func(t *testing.T) {
+			err := NewReader(writeDamagedArtifact(t, damage)).ReadArtifact()
+			require.Error(t, err)
+			assert.Equal(t, artifact.ErrUnsafeArchivePath, errors.Cause(err))
+		})
+	}
+}
+
+func TestReadStateScriptsUnsafePath(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, name := range []string{"scripts/ArtifactInstall_Enter_00", "scripts/.."} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: 1}))
+		_, err := tw.Write([]byte("#"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	var hdr tar.Header
+	_, err := readStateScripts(tar.NewReader(buf), &hdr, DefaultMaxScriptsSize)
+	require.Error(t, err)
+	assert.Equal(t, artifact.ErrUnsafeArchivePath, errors.Cause(err))
+}
+
+func TestReadStateScriptsLimit(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	for _, name := range []string{"scripts/ArtifactInstall_Enter_00",
+		"scripts/ArtifactInstall_Enter_01", "headers/0000/type-info"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: 2}))
+		_, err := tw.Write([]byte("#!"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	var hdr tar.Header
+	scripts, err := readStateScripts(tar.NewReader(bytes.NewReader(buf.Bytes())), &hdr, 4)
+	require.NoError(t, err)
+	require.Len(t, scripts, 2)
+	assert.Equal(t, "ArtifactInstall_Enter_01", scripts[1].info.Name())
+	assert.Equal(t, []byte("#!"), scripts[1].data)
+	assert.Equal(t, "headers/0000/type-info", hdr.Name)
+
+	_, err = readStateScripts(tar.NewReader(bytes.NewReader(buf.Bytes())), &hdr, 3)
+	assert.EqualError(t, err, "reader: state scripts are larger than 3 bytes")
+}
+
 func TestReadSigned(t *testing.T) {
 	art, err := MakeRootfsImageArtifact(2, true, false, false)
 	assert.NoError(t, err)
@@ -379,6 +810,115 @@ func TestReadSigned(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+type fixedSigner []byte
+
+func (s fixedSigner) Sign(message []byte) ([]byte, error) {
+	return s, nil
+}
+
+func makeMultiSignedArtifact(t *testing.T) []byte {
+	upd, err := MakeFakeUpdate(TestUpdateFileContent)
+	require.NoError(t, err)
+	defer os.Remove(upd)
+
+	s, err := artifact.NewPKISigner([]byte(PrivateKey))
+	require.NoError(t, err)
+	art := bytes.NewBuffer(nil)
+	aw := awriter.NewWriterSigned(art, artifact.NewCompressorGzip(), fixedSigner("old-key"))
+	aw.AddSigner(s)
+	err = aw.WriteArtifact(&awriter.WriteArtifactArgs{
+		Format:  "mender",
+		Version: 3,
+		Devices: []string{"vexpress"},
+		Name:    "mender-1.1",
+		Updates: &awriter.Updates{Updates: []handlers.Composer{handlers.NewRootfsV3(upd)}},
+		Provides: &artifact.ArtifactProvides{
+			ArtifactName: "mender-1.1",
+		},
+		Depends: &artifact.ArtifactDepends{
+			CompatibleDevices: []string{"vexpress"},
+		},
+	})
+	require.NoError(t, err)
+	return art.Bytes()
+}
+
+func TestReadMultipleSignatures(t *testing.T) {
+	art := makeMultiSignedArtifact(t)
+
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(art))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"version", "manifest", "manifest.sig", "manifest.sig.1",
+		"header.tar.gz", "data/0000.tar.gz"}, names)
+
+	// The second signature is made with the current key.
+	aReader := NewReaderSigned(bytes.NewReader(art))
+	aReader.VerifySignatureCallback = mustCreateVerifier(t, []byte(PublicKey)).Verify
+	assert.NoError(t, aReader.ReadArtifact())
+	assert.True(t, aReader.IsSigned)
+
+	// The first one with the old key.
+	aReader = NewReaderSigned(bytes.NewReader(art))
+	aReader.VerifySignatureCallback = func(message, sig []byte) error {
+		if string(sig) != "old-key" {
+			return errors.New("not the old key")
+		}
+		return nil
+	}
+	assert.NoError(t, aReader.ReadArtifact())
+
+	// Neither matches; the error of the first signature is reported.
+	aReader = NewReaderSigned(bytes.NewReader(art))
+	aReader.VerifySignatureCallback = mustCreateVerifier(t, []byte(PublicKeyError)).Verify
+	err := aReader.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reader: invalid signature")
+
+	aReader = NewReaderSigned(bytes.NewReader(art))
+	err = aReader.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reader: verify signature callback not registered")
+
+	// Not verifying at all still works.
+	aReader = NewReader(bytes.NewReader(art))
+	assert.NoError(t, aReader.ReadArtifact())
+}
+
+func TestReadMultipleSignaturesOutOfSequence(t *testing.T) {
+	art := makeMultiSignedArtifact(t)
+
+	renamed := bytes.NewBuffer(nil)
+	tr := tar.NewReader(bytes.NewReader(art))
+	tw := tar.NewWriter(renamed)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Name == "manifest.sig.1" {
+			hdr.Name = "manifest.sig.2"
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = io.Copy(tw, tr)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	aReader := NewReader(renamed)
+	err := aReader.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wrong element: manifest.sig.2")
+}
+
 func TestRegisterMultipleHandlers(t *testing.T) {
 	aReader := NewReader(nil)
 	err := aReader.RegisterHandler(handlers.NewRootfsInstaller())
@@ -416,6 +956,26 @@ func TestReadBroken(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestReadUnsupportedVersion(t *testing.T) {
+	for _, version := range []int{1, 4} {
+		buf := bytes.NewBuffer(nil)
+		tw := tar.NewWriter(buf)
+		data := []byte(fmt.Sprintf(`{"format": "mender", "version": %d}`, version))
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "version", Mode: 0644,
+			Size: int64(len(data))}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		err = NewReader(buf).ReadArtifact()
+		assert.EqualError(t, err,
+			fmt.Sprintf("reader: unsupported artifact version: %d", version))
+		var unsupported *artifact.ErrUnsupportedVersion
+		require.ErrorAs(t, err, &unsupported)
+		assert.Equal(t, version, unsupported.Got)
+	}
+}
+
 func TestReadWithScripts(t *testing.T) {
 	art, err := MakeRootfsImageArtifact(2, false, true, false)
 	assert.NoError(t, err)
@@ -440,6 +1000,80 @@ func TestReadWithScripts(t *testing.T) {
 	assert.Equal(t, 1, noExec)
 }
 
+func TestReadBuffered(t *testing.T) {
+	tc := map[string]struct {
+		version   int
+		signed    bool
+		bufSize   int
+		readAhead int
+	}{
+		"v2 buffered":             {2, false, 4096, 0},
+		"v3 buffered":             {3, false, 4096, 0},
+		"v3 signed buffered":      {3, true, 4096, 0},
+		"v3 read-ahead":           {3, false, 512, 4},
+		"v3 signed read-ahead":    {3, true, 512, 4},
+		"v3 read-ahead small buf": {3, true, 1, 2},
+	}
+
+	for name, test := range tc {
+		t.Run(name, func(t *testing.T) {
+			art, err := MakeRootfsImageArtifact(test.version, test.signed, true, false)
+			require.NoError(t, err)
+
+			aReader := NewReader(art)
+			aReader.ReadBufferSize = test.bufSize
+			aReader.ReadAheadBuffers = test.readAhead
+			aReader.VerifySignatureCallback = func(message, sig []byte) error {
+				return mustCreateVerifier(t, []byte(PublicKey)).Verify(message, sig)
+			}
+			err = aReader.ReadArtifact()
+			assert.NoError(t, err)
+			assert.Equal(t, test.signed, aReader.IsSigned)
+			assert.Equal(t, "mender", aReader.GetInfo().Format)
+		})
+	}
+}
+
+func TestReadHeadersErrorStopsReadAhead(t *testing.T) {
+	aReader := NewReader(bytes.NewReader(make([]byte, 1<<20)))
+	aReader.ReadBufferSize = 512
+	aReader.ReadAheadBuffers = 2
+	require.Error(t, aReader.ReadArtifactHeaders())
+	require.NotNil(t, aReader.readAhead)
+	// Reads after Close fail instead of blocking on the stopped goroutine.
+	_, err := ioutil.ReadAll(aReader.readAhead)
+	assert.Error(t, err)
+}
+
+func benchmarkReadArtifact(b *testing.B, bufSize, readAhead int) {
+	art, err := MakeRootfsImageArtifact(3, false, false, false)
+	require.NoError(b, err)
+	data, err := ioutil.ReadAll(art)
+	require.NoError(b, err)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		aReader := NewReader(iotest.OneByteReader(bytes.NewReader(data)))
+		aReader.ReadBufferSize = bufSize
+		aReader.ReadAheadBuffers = readAhead
+		if err := aReader.ReadArtifact(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadArtifactUnbuffered(b *testing.B) {
+	benchmarkReadArtifact(b, 0, 0)
+}
+
+func BenchmarkReadArtifactBuffered(b *testing.B) {
+	benchmarkReadArtifact(b, 64*1024, 0)
+}
+
+func BenchmarkReadArtifactReadAhead(b *testing.B) {
+	benchmarkReadArtifact(b, 64*1024, 4)
+}
+
 func MakeFakeUpdate(data string) (string, error) {
 	f, err := ioutil.TempFile("", "test_update")
 	if err != nil {
@@ -459,6 +1093,7 @@ type installer struct {
 	Data       *handlers.DataFile
 	updateType string
 	typeInfoV3 *artifact.TypeInfoV3
+	metaData   map[string]interface{}
 }
 
 func (i *installer) GetUpdateFiles() [](*handlers.DataFile) {
@@ -503,8 +1138,16 @@ func (i *installer) GetUpdateClearsProvides() []string {
 	return []string{}
 }
 
+func (i *installer) GetUpdateInstallSize() int64 {
+	return 0
+}
+
+func (i *installer) GetUpdatePayloadSignatures() map[string]string {
+	return nil
+}
+
 func (i *installer) GetUpdateMetaData() (map[string]interface{}, error) {
-	return nil, nil
+	return i.metaData, nil
 }
 
 func (i *installer) GetUpdateOriginalDepends() artifact.TypeInfoDepends {
@@ -721,6 +1364,8 @@ func TestReadBrokenArtifact(t *testing.T) {
 		manipulateArtifact func(tmpdir string)
 		successful         bool
 		errorStr           string
+		errorIs            error
+		checksumMismatch   bool
 		rootfsImage        bool
 		numFiles           int
 		numAugmentFiles    int
@@ -841,8 +1486,9 @@ func TestReadBrokenArtifact(t *testing.T) {
 				file.Seek(0, 0)
 				file.Write(buf)
 			},
-			successful: false,
-			numFiles:   1,
+			successful:       false,
+			checksumMismatch: true,
+			numFiles:         1,
 		},
 		"data/0000.tar.gz missing": {
 			manipulateArtifact: func(tmpdir string) {
@@ -1079,6 +1725,7 @@ func TestReadBrokenArtifact(t *testing.T) {
 			},
 			successful:      false,
 			errorStr:        "checksum missing for file: 'version'",
+			errorIs:         artifact.ErrChecksumMissing,
 			numFiles:        1,
 			numAugmentFiles: 1,
 		},
@@ -1382,6 +2029,14 @@ func TestReadBrokenArtifact(t *testing.T) {
 				if len(c.errorStr) > 0 {
 					assert.Contains(t, err.Error(), c.errorStr)
 				}
+				if c.errorIs != nil {
+					assert.ErrorIs(t, err, c.errorIs)
+				}
+				if c.checksumMismatch {
+					var mismatch *artifact.ErrChecksumMismatch
+					require.ErrorAs(t, err, &mismatch)
+					assert.NotEmpty(t, mismatch.File)
+				}
 				return
 			}
 
@@ -1449,6 +2104,55 @@ func TestMergeDependsSuccess(t *testing.T) {
 				"artifact_group": []interface{}{"ac-dc"},
 			},
 		},
+		{
+			// Delta payload -- the base is added to the artifact_name depends
+			r: Reader{
+				hInfo: &artifact.HeaderInfoV3{
+					ArtifactDepends: &artifact.ArtifactDepends{
+						CompatibleDevices: []string{"qemux86-64"},
+					},
+				},
+				installers: map[int]handlers.Installer{
+					1: &installer{
+						typeInfoV3: &artifact.TypeInfoV3{},
+						metaData: map[string]interface{}{
+							artifact.DeltaMetaDataKey: map[string]interface{}{
+								"base_artifact_name": "release-1",
+							},
+						},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"artifact_name": []interface{}{"release-1"},
+				"device_type":   []interface{}{"qemux86-64"},
+			},
+		},
+		{
+			// Delta payload -- the artifact_name depends are narrowed to the base
+			r: Reader{
+				hInfo: &artifact.HeaderInfoV3{
+					ArtifactDepends: &artifact.ArtifactDepends{
+						ArtifactName:      []string{"release-0", "release-1"},
+						CompatibleDevices: []string{"qemux86-64"},
+					},
+				},
+				installers: map[int]handlers.Installer{
+					1: &installer{
+						typeInfoV3: &artifact.TypeInfoV3{},
+						metaData: map[string]interface{}{
+							artifact.DeltaMetaDataKey: map[string]interface{}{
+								"base_artifact_name": "release-1",
+							},
+						},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"artifact_name": []interface{}{"release-1"},
+				"device_type":   []interface{}{"qemux86-64"},
+			},
+		},
 		{
 			// Artifact version 2
 			r: Reader{
@@ -1497,6 +2201,27 @@ func TestMergeDependsError(t *testing.T) {
 				},
 			},
 		},
+		{
+			// Delta payload -- the base is not among the artifact_name depends
+			r: Reader{
+				hInfo: &artifact.HeaderInfoV3{
+					ArtifactDepends: &artifact.ArtifactDepends{
+						ArtifactName:      []string{"release-0"},
+						CompatibleDevices: []string{"qemux86-64"},
+					},
+				},
+				installers: map[int]handlers.Installer{
+					1: &installer{
+						typeInfoV3: &artifact.TypeInfoV3{},
+						metaData: map[string]interface{}{
+							artifact.DeltaMetaDataKey: map[string]interface{}{
+								"base_artifact_name": "release-1",
+							},
+						},
+					},
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		_, err := test.r.MergeArtifactDepends()
@@ -1611,3 +2336,93 @@ func TestMergeProvidesError(t *testing.T) {
 		assert.NotNil(t, err)
 	}
 }
+
+func TestReadLargePayload(t *testing.T) {
+	if os.Getenv("TEST_LARGE_ARTIFACTS") == "" {
+		t.Skip("TEST_LARGE_ARTIFACTS is not set")
+	}
+
+	f, err := ioutil.TempFile("", "large-update")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	// Larger than the 8GiB limit of the ustar size field; sparse on disk.
+	const size = int64(8<<30) + 1
+	require.NoError(t, f.Truncate(size))
+	require.NoError(t, f.Close())
+
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		aw := awriter.NewWriter(pipeW, artifact.NewCompressorNone())
+		err := aw.WriteArtifact(&awriter.WriteArtifactArgs{
+			Format:  "mender",
+			Version: 3,
+			Devices: []string{"vexpress"},
+			Name:    "mender-1.1",
+			Updates: &awriter.Updates{
+				Updates: []handlers.Composer{handlers.NewRootfsV3(f.Name())},
+			},
+			Provides: &artifact.ArtifactProvides{
+				ArtifactName: "mender-1.1",
+			},
+			Depends: &artifact.ArtifactDepends{
+				CompatibleDevices: []string{"vexpress"},
+			},
+		})
+		pipeW.CloseWithError(err)
+	}()
+
+	r := NewReader(pipeR)
+	require.NoError(t, r.ReadArtifact())
+	files := r.GetHandlers()[0].GetUpdateFiles()
+	require.Len(t, files, 1)
+	assert.Equal(t, size, files[0].Size)
+}
+
+func TestReadDeduplicatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) *handlers.DataFile {
+		file := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+		return &handlers.DataFile{Name: file}
+	}
+	u := handlers.NewModuleImage("app")
+	require.NoError(t, u.SetUpdateFiles([]*handlers.DataFile{
+		write("base", "same"),
+		write("other", "different"),
+	}))
+	a := handlers.NewAugmentedModuleImage(u, "app")
+	require.NoError(t, a.SetUpdateAugmentFiles([]*handlers.DataFile{
+		write("augment", "same"),
+	}))
+	art := bytes.NewBuffer(nil)
+	err := awriter.NewWriter(art, artifact.NewCompressorGzip()).WriteArtifact(
+		&awriter.WriteArtifactArgs{
+			Format:  "mender",
+			Version: 3,
+			Devices: []string{"vexpress"},
+			Name:    "mender-1.1",
+			Updates: &awriter.Updates{
+				Updates:  []handlers.Composer{u},
+				Augments: []handlers.Composer{a},
+			},
+			Provides:         &artifact.ArtifactProvides{ArtifactName: "mender-1.1"},
+			Depends:          &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+			DeduplicateFiles: true,
+		})
+	require.NoError(t, err)
+
+	stored := &[]string{}
+	h := handlers.NewModuleImage("app")
+	h.SetUpdateStorerProducer(&recordingStorer{stored: stored})
+	ar := NewReader(bytes.NewReader(art.Bytes()))
+	require.NoError(t, ar.RegisterHandler(h))
+	require.NoError(t, ar.ReadArtifact())
+	assert.Equal(t, []string{"same", "different", "same"}, *stored)
+	assert.Equal(t, []string{"data/0000/augment"}, ar.LinkedDataFiles())
+
+	ar = NewReader(bytes.NewReader(art.Bytes()))
+	require.NoError(t, ar.ReadArtifactHeaders())
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, ar.ExtractFile(0, "augment", buf))
+	assert.Equal(t, "same", buf.String())
+}
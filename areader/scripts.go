@@ -0,0 +1,154 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxScriptsSize is the default limit of the total size of the state
+// scripts kept by a Reader with CollectScripts set.
+const DefaultMaxScriptsSize = 16 * 1024 * 1024
+
+// Script is a state script of an Artifact.
+type Script struct {
+	Name string
+	// DeviceType is set for the scripts which only run on devices of that
+	// device type.
+	DeviceType string
+	Data       []byte
+}
+
+// stateScript is a state script read from the header, kept until the
+// type-info tells which device type it belongs to.
+type stateScript struct {
+	info os.FileInfo
+	data []byte
+}
+
+func (ar *Reader) maxScriptsSize() int64 {
+	if ar.MaxScriptsSize <= 0 {
+		return DefaultMaxScriptsSize
+	}
+	return ar.MaxScriptsSize
+}
+
+// handleScripts hands the state scripts to ScriptsReadCallback, or to
+// DeviceTypeScriptsReadCallback for those the type-info lists for a device
+// type, and collects them if CollectScripts is set.
+func (ar *Reader) handleScripts(scripts []stateScript) error {
+	deviceTypes := make(map[string]string)
+	for deviceType, names := range ar.GetDeviceTypeScripts() {
+		if err := artifact.ValidateDeviceType(deviceType); err != nil {
+			return errors.Wrap(err, "reader: invalid device type of state scripts")
+		}
+		for _, name := range names {
+			if other, ok := deviceTypes[name]; ok && other != deviceType {
+				return errors.Errorf(
+					"reader: state script %s is listed for device types %s and %s",
+					name, other, deviceType)
+			}
+			deviceTypes[name] = deviceType
+		}
+	}
+
+	found := make(map[string]bool)
+	for _, script := range scripts {
+		name := script.info.Name()
+		deviceType, ok := deviceTypes[name]
+		found[name] = true
+		var err error
+		switch {
+		case ok && ar.DeviceTypeScriptsReadCallback != nil:
+			err = ar.DeviceTypeScriptsReadCallback(deviceType,
+				bytes.NewReader(script.data), script.info)
+		case !ok && ar.ScriptsReadCallback != nil:
+			err = ar.ScriptsReadCallback(bytes.NewReader(script.data), script.info)
+		}
+		if err != nil {
+			return err
+		}
+		if ar.CollectScripts {
+			ar.scripts = append(ar.scripts,
+				Script{Name: name, DeviceType: deviceType, Data: script.data})
+		}
+	}
+
+	for name := range deviceTypes {
+		if found[name] {
+			continue
+		}
+		return errors.Errorf("reader: state script %s is missing from the header", name)
+	}
+	return nil
+}
+
+// GetScripts returns the state scripts of the Artifact in the order they
+// are stored in, if CollectScripts was set while reading it.
+func (ar *Reader) GetScripts() []Script {
+	return ar.scripts
+}
+
+// WriteScripts writes state scripts as executable files into dir, which is
+// created if needed. The scripts of a single device type are written into
+// the subdirectory named after it. Existing files are not replaced, so two
+// scripts with the same name are an error.
+func WriteScripts(dir string, scripts []Script) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "can not create state script directory")
+	}
+	for _, script := range scripts {
+		name := filepath.Base(script.Name)
+		if name != script.Name || name == "." || name == ".." {
+			return errors.Errorf("invalid state script name: %q", script.Name)
+		}
+		if script.DeviceType != "" {
+			if err := artifact.ValidateDeviceType(script.DeviceType); err != nil {
+				return err
+			}
+			name = filepath.Join(script.DeviceType, name)
+			if err := os.MkdirAll(filepath.Join(dir, script.DeviceType), 0755); err != nil {
+				return errors.Wrap(err, "can not create state script directory")
+			}
+		}
+		path, err := artifact.JoinArchivePath(dir, name)
+		if err != nil {
+			return errors.Wrap(err, "invalid state script name")
+		}
+		if err = writeScript(path, script.Data); err != nil {
+			return errors.Wrapf(err, "can not write state script %s", name)
+		}
+	}
+	return nil
+}
+
+// writeScript creates the script file exclusively, so that a duplicate
+// script name is an error instead of silently replacing the first script.
+func writeScript(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	return f.Close()
+}
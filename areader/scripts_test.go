@@ -0,0 +1,151 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+func TestGetScripts(t *testing.T) {
+	art, err := MakeRootfsImageArtifact(3, false, true, false)
+	require.NoError(t, err)
+
+	aReader := NewReader(art)
+	aReader.CollectScripts = true
+	var fromCallback []byte
+	aReader.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		fromCallback, err = ioutil.ReadAll(r)
+		return err
+	}
+	require.NoError(t, aReader.ReadArtifact())
+
+	scripts := aReader.GetScripts()
+	require.Len(t, scripts, 1)
+	assert.Contains(t, scripts[0].Name, "ArtifactInstall_Enter_10_")
+	assert.Equal(t, "execute me!", string(scripts[0].Data))
+	assert.Equal(t, "execute me!", string(fromCallback))
+
+	dir := filepath.Join(t.TempDir(), "scripts")
+	require.NoError(t, WriteScripts(dir, scripts))
+	path := filepath.Join(dir, scripts[0].Name)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "execute me!", string(data))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&0100)
+
+	// The first script is not silently replaced by one with the same name.
+	err = WriteScripts(dir, scripts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not write state script")
+	err = WriteScripts(filepath.Join(t.TempDir(), "scripts"),
+		[]Script{{Name: "ArtifactInstall_Enter_10"}, {Name: "ArtifactInstall_Enter_10"}})
+	assert.Error(t, err)
+
+	for _, name := range []string{"../ArtifactInstall_Enter_10", "..", "a/b"} {
+		err = WriteScripts(dir, []Script{{Name: name}})
+		assert.Error(t, err, name)
+	}
+}
+
+func TestGetDeviceTypeScripts(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ArtifactInstall_Enter_10")
+	require.NoError(t, ioutil.WriteFile(script, []byte("execute me!"), 0755))
+	deviceTypeScript := filepath.Join(dir, "ArtifactInstall_Enter_10_vexpress")
+	require.NoError(t, ioutil.WriteFile(deviceTypeScript, []byte("execute me too!"), 0755))
+	scr := new(artifact.Scripts)
+	require.NoError(t, scr.Add(script))
+	require.NoError(t, scr.AddForDeviceType("vexpress", deviceTypeScript))
+
+	art := bytes.NewBuffer(nil)
+	u := handlers.NewModuleImage("test-type")
+	err := awriter.NewWriter(art, artifact.NewCompressorGzip()).WriteArtifact(
+		&awriter.WriteArtifactArgs{
+			Format:     "mender",
+			Version:    3,
+			Devices:    []string{"vexpress"},
+			Name:       "mender-1.1",
+			Updates:    &awriter.Updates{Updates: []handlers.Composer{u}},
+			Scripts:    scr,
+			Provides:   &artifact.ArtifactProvides{ArtifactName: "mender-1.1"},
+			Depends:    &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+			TypeInfoV3: &artifact.TypeInfoV3{Type: u.GetUpdateType()},
+		})
+	require.NoError(t, err)
+
+	aReader := NewReader(art)
+	aReader.CollectScripts = true
+	var fromCallback, generic []string
+	aReader.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		generic = append(generic, info.Name())
+		return nil
+	}
+	aReader.DeviceTypeScriptsReadCallback = func(
+		deviceType string, r io.Reader, info os.FileInfo) error {
+		fromCallback = append(fromCallback, deviceType+"/"+info.Name())
+		return nil
+	}
+	require.NoError(t, aReader.ReadArtifact())
+
+	assert.Equal(t, map[string][]string{"vexpress": {"ArtifactInstall_Enter_10_vexpress"}},
+		aReader.GetDeviceTypeScripts())
+	assert.Equal(t, []string{"ArtifactInstall_Enter_10"}, generic)
+	assert.Equal(t, []string{"vexpress/ArtifactInstall_Enter_10_vexpress"}, fromCallback)
+	assert.Equal(t, []Script{
+		{Name: "ArtifactInstall_Enter_10", Data: []byte("execute me!")},
+		{Name: "ArtifactInstall_Enter_10_vexpress", DeviceType: "vexpress",
+			Data: []byte("execute me too!")},
+	}, aReader.GetScripts())
+
+	out := filepath.Join(dir, "out")
+	require.NoError(t, WriteScripts(out, aReader.GetScripts()))
+	assert.FileExists(t, filepath.Join(out, "ArtifactInstall_Enter_10"))
+	assert.FileExists(t, filepath.Join(out, "vexpress", "ArtifactInstall_Enter_10_vexpress"))
+}
+
+func TestGetScriptsNotCollected(t *testing.T) {
+	art, err := MakeRootfsImageArtifact(3, false, true, false)
+	require.NoError(t, err)
+
+	aReader := NewReader(art)
+	require.NoError(t, aReader.ReadArtifact())
+	assert.Empty(t, aReader.GetScripts())
+}
+
+func TestGetScriptsTooLarge(t *testing.T) {
+	art, err := MakeRootfsImageArtifact(3, false, true, false)
+	require.NoError(t, err)
+
+	aReader := NewReader(art)
+	aReader.CollectScripts = true
+	aReader.MaxScriptsSize = 5
+	err = aReader.ReadArtifact()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "state scripts are larger than 5 bytes")
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"archive/tar"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// TarEntry locates an entry of the outer tar archive of an Artifact.
+type TarEntry struct {
+	Name string
+	// Offset of the contents of the entry from the start of the Artifact.
+	Offset int64
+	Size   int64
+}
+
+// NewReaderSeekable returns a Reader which, besides reading the Artifact
+// sequentially, can use the offsets of the entries in it to read them again,
+// or read single payloads, without streaming the rest of the Artifact. The
+// offsets are indexed on first use, which only reads the tar headers. The
+// Reader must not be used concurrently, as all reads share the position of
+// rs, and ReadArtifactData can not follow the reads which seek; use
+// ReadPayload instead. ReadAheadBuffers is ignored.
+func NewReaderSeekable(rs io.ReadSeeker) *Reader {
+	ar := NewReader(rs)
+	ar.seeker = rs
+	return ar
+}
+
+// Entries returns the entries of the outer tar archive of an Artifact read
+// by a Reader returned by NewReaderSeekable.
+func (ar *Reader) Entries() ([]TarEntry, error) {
+	if ar.seeker == nil {
+		return nil, errors.New("reader: the Artifact is not seekable")
+	}
+	if ar.entries != nil {
+		return ar.entries, nil
+	}
+	if _, err := ar.seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "reader: can not seek in the Artifact")
+	}
+	// The tar reader skips the contents of the entries by seeking, and
+	// leaves the position at the start of the contents after each header.
+	entries := []TarEntry{}
+	tr := tar.NewReader(ar.seeker)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "reader: can not index the Artifact")
+		}
+		offset, err := ar.seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, errors.Wrap(err, "reader: can not seek in the Artifact")
+		}
+		entries = append(entries, TarEntry{Name: hdr.Name, Offset: offset, Size: hdr.Size})
+	}
+	ar.entries = entries
+	return entries, nil
+}
+
+// OpenEntry returns a reader of the contents of the entry called name of the
+// outer tar archive of the Artifact, which is valid until the next read
+// from ar.
+func (ar *Reader) OpenEntry(name string) (io.Reader, error) {
+	entries, err := ar.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name == name {
+			if _, err = ar.seeker.Seek(e.Offset, io.SeekStart); err != nil {
+				return nil, errors.Wrap(err, "reader: can not seek in the Artifact")
+			}
+			return io.LimitReader(ar.seeker, e.Size), nil
+		}
+	}
+	return nil, errors.Errorf("reader: %s not found in the Artifact", name)
+}
+
+// openPayload returns a reader of the data member of the payload with the
+// given index.
+func (ar *Reader) openPayload(index int) (io.Reader, error) {
+	entries, err := ar.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if filepath.Dir(e.Name) != "data" {
+			continue
+		}
+		comp, err := artifact.NewCompressorFromFileName(e.Name)
+		if err != nil {
+			continue
+		}
+		if no, err := getUpdateNoFromDataPath(comp, e.Name); err == nil && no == index {
+			return ar.OpenEntry(e.Name)
+		}
+	}
+	return nil, errors.Errorf("reader: the data of Payload %d is missing", index)
+}
+
+// ReadPayload reads the data of the payload with the given index into its
+// UpdateStorer, like ReadArtifactData does for all the payloads, after the
+// headers have been read by a Reader returned by NewReaderSeekable. It can
+// be called for any of the payloads, in any order, and more than once.
+func (ar *Reader) ReadPayload(index int) error {
+	if ar.info == nil {
+		return errors.New("reader: the Artifact headers have not been read")
+	}
+	inst, ok := ar.installers[index]
+	if !ok {
+		return errors.Errorf("reader: the Artifact has no Payload %d", index)
+	}
+	if err := ar.initializeUpdateStorers(); err != nil {
+		return err
+	}
+	r, err := ar.openPayload(index)
+	if err != nil {
+		return err
+	}
+	return ar.readAndInstall(r, inst, index)
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package areader
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+// recordingStorer records the contents of the payload files it stores.
+type recordingStorer struct {
+	payload int
+	stored  *[]string
+}
+
+func (s *recordingStorer) NewUpdateStorer(
+	updateType *string, payloadNum int) (handlers.UpdateStorer, error) {
+	return &recordingStorer{payload: payloadNum, stored: s.stored}, nil
+}
+
+func (s *recordingStorer) Initialize(artifactHeaders, artifactAugmentedHeaders artifact.HeaderInfoer,
+	payloadHeaders handlers.ArtifactUpdateHeaders) error {
+	return nil
+}
+
+func (s *recordingStorer) PrepareStoreUpdate() error {
+	return nil
+}
+
+func (s *recordingStorer) StoreUpdate(r io.Reader, info os.FileInfo) error {
+	data, err := ioutil.ReadAll(r)
+	*s.stored = append(*s.stored, string(data))
+	return err
+}
+
+func (s *recordingStorer) FinishStoreUpdate() error {
+	return nil
+}
+
+func newSeekableTestReader(t *testing.T) (*Reader, *[]string) {
+	art, err := ioutil.ReadAll(writeDamagedArtifact(t,
+		func(hdr *tar.Header, data []byte) []byte { return data }))
+	require.NoError(t, err)
+	ar := NewReaderSeekable(bytes.NewReader(art))
+	stored := &[]string{}
+	for _, updateType := range []string{"app", "config"} {
+		h := handlers.NewModuleImage(updateType)
+		h.SetUpdateStorerProducer(&recordingStorer{stored: stored})
+		require.NoError(t, ar.RegisterHandler(h))
+	}
+	return ar, stored
+}
+
+func TestSeekableEntries(t *testing.T) {
+	ar, _ := newSeekableTestReader(t)
+	entries, err := ar.Entries()
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t,
+		[]string{"version", "manifest", "header.tar", "data/0000.tar", "data/0001.tar"}, names)
+
+	r, err := ar.OpenEntry("version")
+	require.NoError(t, err)
+	var info artifact.Info
+	require.NoError(t, json.NewDecoder(r).Decode(&info))
+	assert.Equal(t, 3, info.Version)
+
+	_, err = ar.OpenEntry("manifest.sig")
+	assert.EqualError(t, err, "reader: manifest.sig not found in the Artifact")
+
+	_, err = NewReader(bytes.NewReader(nil)).Entries()
+	assert.EqualError(t, err, "reader: the Artifact is not seekable")
+}
+
+func TestSeekableReadPayload(t *testing.T) {
+	ar, stored := newSeekableTestReader(t)
+	assert.EqualError(t, ar.ReadPayload(0), "reader: the Artifact headers have not been read")
+
+	require.NoError(t, ar.ReadArtifactHeaders())
+	require.NoError(t, ar.ReadPayload(1))
+	assert.Equal(t, []string{"config first", "config second"}, *stored)
+
+	// Payloads can be read in any order, and again.
+	*stored = nil
+	require.NoError(t, ar.ReadPayload(0))
+	require.NoError(t, ar.ReadPayload(1))
+	assert.Equal(t,
+		[]string{"app first", "app second", "config first", "config second"}, *stored)
+
+	assert.EqualError(t, ar.ReadPayload(2), "reader: the Artifact has no Payload 2")
+
+	// And so can the headers.
+	require.NoError(t, ar.ReadArtifactHeaders())
+	assert.Equal(t, "mender-1.1", ar.GetArtifactName())
+}
+
+func TestSeekableExtractFile(t *testing.T) {
+	ar, _ := newSeekableTestReader(t)
+	require.NoError(t, ar.ReadArtifactHeaders())
+	for _, test := range []struct {
+		payload int
+		name    string
+		content string
+	}{
+		{1, "second", "config second"},
+		{0, "first", "app first"},
+		{1, "first", "config first"},
+	} {
+		buf := bytes.NewBuffer(nil)
+		require.NoError(t, ar.ExtractFile(test.payload, test.name, buf))
+		assert.Equal(t, test.content, buf.String())
+	}
+}
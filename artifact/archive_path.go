@@ -0,0 +1,52 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateArchivePath checks that name, the name of a file in an Artifact,
+// is a relative path which stays inside the directory it is extracted to.
+// Absolute names, backslashes and empty, "." or ".." elements are rejected.
+func ValidateArchivePath(name string) error {
+	if name == "" {
+		return errors.Wrap(ErrUnsafeArchivePath, "empty file name")
+	}
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return errors.Wrapf(ErrUnsafeArchivePath, "%q is absolute", name)
+	}
+	if strings.Contains(name, "\\") {
+		return errors.Wrapf(ErrUnsafeArchivePath, "%q contains a backslash", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return errors.Wrapf(ErrUnsafeArchivePath, "%q is not a canonical relative path", name)
+		}
+	}
+	return nil
+}
+
+// JoinArchivePath returns the path in dir to extract the file of an
+// Artifact called name to, after checking it with ValidateArchivePath.
+func JoinArchivePath(dir, name string) (string, error) {
+	if err := ValidateArchivePath(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateArchivePath(t *testing.T) {
+	for _, name := range []string{
+		"file",
+		"data/0000/file.ext4",
+		"scripts/ArtifactInstall_Enter_00",
+		"..file",
+		"file..",
+	} {
+		assert.NoError(t, ValidateArchivePath(name), name)
+	}
+
+	for _, name := range []string{
+		"",
+		"/etc/passwd",
+		"../file",
+		"data/../../file",
+		"data/..",
+		"./file",
+		"data//file",
+		"data/",
+		"..\\file",
+		"C:\\file",
+	} {
+		err := ValidateArchivePath(name)
+		assert.Error(t, err, name)
+		assert.Equal(t, ErrUnsafeArchivePath, errors.Cause(err), name)
+	}
+}
+
+func TestJoinArchivePath(t *testing.T) {
+	path, err := JoinArchivePath("/tmp/dump", "scripts/script")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("/tmp/dump", "scripts", "script"), path)
+
+	_, err = JoinArchivePath("/tmp/dump", "../script")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsafe path in Artifact")
+}
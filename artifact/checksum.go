@@ -15,12 +15,15 @@
 package artifact
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"syscall"
 
@@ -28,6 +31,51 @@ import (
 	"github.com/pkg/errors"
 )
 
+// The hash algorithms of the checksums in Artifacts. The checksums are hex
+// encoded, so the algorithm of each is told by its length. Artifacts which
+// do not use sha256 record their algorithm in the version file.
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA384 = "sha384"
+	ChecksumSHA512 = "sha512"
+)
+
+// ChecksumAlgorithms are all the supported checksum algorithms.
+var ChecksumAlgorithms = []string{ChecksumSHA256, ChecksumSHA384, ChecksumSHA512}
+
+// ValidateChecksumAlgorithm checks that algorithm is supported. The empty
+// string stands for sha256.
+func ValidateChecksumAlgorithm(algorithm string) error {
+	switch algorithm {
+	case "", ChecksumSHA256, ChecksumSHA384, ChecksumSHA512:
+		return nil
+	}
+	return errors.Errorf("unsupported checksum algorithm %q; use one of %s",
+		algorithm, strings.Join(ChecksumAlgorithms, ", "))
+}
+
+// ChecksumAlgorithmOf returns the algorithm of the hex encoded checksum
+// sum, which is sha256 unless sum has the length of one of the others.
+func ChecksumAlgorithmOf(sum []byte) string {
+	switch len(sum) {
+	case hex.EncodedLen(sha512.Size384):
+		return ChecksumSHA384
+	case hex.EncodedLen(sha512.Size):
+		return ChecksumSHA512
+	}
+	return ChecksumSHA256
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case ChecksumSHA384:
+		return sha512.New384()
+	case ChecksumSHA512:
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
 type Checksum struct {
 	w io.Writer // underlying writer
 	h hash.Hash // writer calculated hash
@@ -37,23 +85,31 @@ type Checksum struct {
 }
 
 func NewWriterChecksum(w io.Writer) *Checksum {
+	return NewWriterChecksumAlgorithm(w, ChecksumSHA256)
+}
+
+// NewWriterChecksumAlgorithm is the same as NewWriterChecksum, but
+// calculates the checksum with algorithm, which must be valid.
+func NewWriterChecksumAlgorithm(w io.Writer, algorithm string) *Checksum {
 	if w == nil {
 		return new(Checksum)
 	}
 
-	h := sha256.New()
+	h := newChecksumHash(algorithm)
 	return &Checksum{
 		w: io.MultiWriter(h, w),
 		h: h,
 	}
 }
 
+// NewReaderChecksum returns a reader of r which verifies that its contents
+// have the checksum sum, calculated with the algorithm of its length.
 func NewReaderChecksum(r io.Reader, sum []byte) *Checksum {
 	if r == nil {
 		return new(Checksum)
 	}
 
-	h := sha256.New()
+	h := newChecksumHash(ChecksumAlgorithmOf(sum))
 	return &Checksum{
 		r: io.TeeReader(r, h),
 		c: sum,
@@ -61,6 +117,15 @@ func NewReaderChecksum(r io.Reader, sum []byte) *Checksum {
 	}
 }
 
+// NewReaderChecksumSize is the same as NewReaderChecksum, but reads from r in
+// chunks of at least bufSize bytes.
+func NewReaderChecksumSize(r io.Reader, sum []byte, bufSize int) *Checksum {
+	if r != nil && bufSize > 0 {
+		r = bufio.NewReaderSize(r, bufSize)
+	}
+	return NewReaderChecksum(r, sum)
+}
+
 func (c *Checksum) Write(p []byte) (int, error) {
 	if c.w == nil {
 		return 0, syscall.EBADF
@@ -97,8 +162,7 @@ func (c *Checksum) Checksum() []byte {
 func (c *Checksum) Verify() error {
 	sum := c.Checksum()
 	if !bytes.Equal(c.c, sum) {
-		return errors.Errorf("invalid checksum; expected: [%s]; actual: [%s]",
-			c.c, sum)
+		return &ErrChecksumMismatch{Expected: c.c, Actual: sum}
 	}
 	return nil
 }
@@ -138,7 +202,7 @@ func (c *ChecksumStore) Add(file string, sum []byte) error {
 func (c *ChecksumStore) Get(file string) ([]byte, error) {
 	sum, ok := c.sums[file]
 	if !ok {
-		return nil, errors.Errorf("checksum: checksum missing for file: '%s'", file)
+		return nil, errors.Wrap(NewChecksumMissingError(file), "checksum")
 	}
 	return sum, nil
 }
@@ -152,6 +216,35 @@ func (c *ChecksumStore) GetAndMark(file string) ([]byte, error) {
 	return sum, err
 }
 
+// Algorithm returns the algorithm of the checksums in the store, and an
+// error if they do not all have the same one. It is sha256 if the store is
+// empty.
+func (c *ChecksumStore) Algorithm() (string, error) {
+	algorithm := ""
+	for _, file := range c.Files() {
+		alg := ChecksumAlgorithmOf(c.sums[file])
+		if algorithm != "" && alg != algorithm {
+			return "", errors.Errorf("checksum: the checksum of %s is %s, not %s",
+				file, alg, algorithm)
+		}
+		algorithm = alg
+	}
+	if algorithm == "" {
+		return ChecksumSHA256, nil
+	}
+	return algorithm, nil
+}
+
+// Files returns the names of all the files in the store, sorted.
+func (c *ChecksumStore) Files() []string {
+	list := make([]string, 0, len(c.sums))
+	for file := range c.sums {
+		list = append(list, file)
+	}
+	sort.Strings(list)
+	return list
+}
+
 func (c *ChecksumStore) FilesNotMarked() []string {
 	var list []string
 	for file, marked := range c.marked {
@@ -191,6 +284,9 @@ func (c *ChecksumStore) readChecksums(line string) error {
 	if len(chunks) != 2 {
 		return errors.Errorf("checksum: malformed checksum line: '%s'", line)
 	}
+	if err := ValidateArchivePath(chunks[1]); err != nil {
+		return errors.Wrap(err, "checksum")
+	}
 	// add element to map
 	return c.Add(chunks[1], []byte(chunks[0]))
 }
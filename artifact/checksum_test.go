@@ -111,7 +111,10 @@ func TestChecksumRead(t *testing.T) {
 	sum = bytes.NewBuffer([]byte(checksumData))
 	r = NewReaderChecksum(sum, []byte("12121212"))
 	_, err = io.Copy(ioutil.Discard, r)
-	assert.Error(t, err)
+	var mismatch *ErrChecksumMismatch
+	assert.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, []byte("12121212"), mismatch.Expected)
+	assert.Equal(t, []byte(sumData), mismatch.Actual)
 }
 
 func TestChecksumReadBigData(t *testing.T) {
@@ -142,7 +145,8 @@ func TestStore(t *testing.T) {
 	assert.Equal(t, []byte("1234567890"), sum)
 
 	sum, err = s.Get("non-existing")
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrChecksumMissing)
+	assert.EqualError(t, err, "checksum: checksum missing for file: 'non-existing'")
 	assert.Nil(t, sum)
 
 	raw := s.GetRaw()
@@ -162,3 +166,40 @@ func TestStore(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, []byte("1234567890  test\n1212121212  version\n"), s.GetRaw())
 }
+
+func TestChecksumAlgorithms(t *testing.T) {
+	for _, algorithm := range ChecksumAlgorithms {
+		assert.NoError(t, ValidateChecksumAlgorithm(algorithm))
+
+		w := NewWriterChecksumAlgorithm(ioutil.Discard, algorithm)
+		_, err := w.Write([]byte(checksumData))
+		assert.NoError(t, err)
+		sum := w.Checksum()
+		assert.Equal(t, algorithm, ChecksumAlgorithmOf(sum))
+
+		r := NewReaderChecksum(bytes.NewBufferString(checksumData), sum)
+		_, err = io.Copy(ioutil.Discard, r)
+		assert.NoError(t, err)
+		r = NewReaderChecksum(bytes.NewBufferString(checksumData+"x"), sum)
+		_, err = io.Copy(ioutil.Discard, r)
+		assert.Error(t, err)
+	}
+	assert.NoError(t, ValidateChecksumAlgorithm(""))
+	assert.Error(t, ValidateChecksumAlgorithm("md5"))
+	w := NewWriterChecksum(ioutil.Discard)
+	_, err := w.Write([]byte(checksumData))
+	assert.NoError(t, err)
+	assert.Equal(t, sumData, string(w.Checksum()))
+
+	s := NewChecksumStore()
+	algorithm, err := s.Algorithm()
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumSHA256, algorithm)
+	assert.NoError(t, s.Add("sha256", []byte(sumData)))
+	algorithm, err = s.Algorithm()
+	assert.NoError(t, err)
+	assert.Equal(t, ChecksumSHA256, algorithm)
+	assert.NoError(t, s.Add("sha512", bytes.Repeat([]byte("0"), 128)))
+	_, err = s.Algorithm()
+	assert.Error(t, err)
+}
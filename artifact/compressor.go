@@ -15,6 +15,7 @@
 package artifact
 
 import (
+	"bytes"
 	"io"
 	"sort"
 	"strings"
@@ -30,6 +31,50 @@ type Compressor interface {
 	NewWriter(w io.Writer) (io.WriteCloser, error)
 }
 
+// CompressorOptionRsyncable makes the compressor restart its output at
+// content defined points, so that a change in the input only changes the
+// output close to it. Tools diffing whole Artifacts then find more of the
+// previous release in the new one, at a small cost in compression ratio.
+const CompressorOptionRsyncable = "rsyncable"
+
+// ConfigurableCompressor is implemented by compressors which take options,
+// such as CompressorOptionRsyncable.
+type ConfigurableCompressor interface {
+	Compressor
+	WithOptions(opts ...string) (Compressor, error)
+}
+
+// ApplyCompressorOptions returns a copy of compressor with opts applied. It
+// fails if the compressor does not support one of them.
+func ApplyCompressorOptions(compressor Compressor, opts []string) (Compressor, error) {
+	if len(opts) == 0 {
+		return compressor, nil
+	}
+	configurable, ok := compressor.(ConfigurableCompressor)
+	if !ok {
+		return nil, errors.Errorf("compressor does not support option %q", opts[0])
+	}
+	return configurable.WithOptions(opts...)
+}
+
+// ConcurrentCompressor is implemented by compressors which compress with
+// several goroutines at once, by default as many as GOMAXPROCS.
+type ConcurrentCompressor interface {
+	Compressor
+	WithConcurrency(n int) Compressor
+}
+
+// LimitCompressorConcurrency returns a copy of compressor which compresses
+// with at most n goroutines at once. Compressors which only use one, and
+// values of n below one, leave compressor as it is.
+func LimitCompressorConcurrency(compressor Compressor, n int) Compressor {
+	concurrent, ok := compressor.(ConcurrentCompressor)
+	if !ok || n < 1 {
+		return compressor
+	}
+	return concurrent.WithConcurrency(n)
+}
+
 func RegisterCompressor(id string, compressor Compressor) {
 	compressors[id] = compressor
 }
@@ -44,6 +89,35 @@ func NewCompressorFromFileName(name string) (Compressor, error) {
 	return NewCompressorNone(), nil
 }
 
+// compressorMagic maps the magic bytes which start the streams of the
+// registered compressors to their file extensions.
+var compressorMagic = []struct {
+	magic     []byte
+	extension string
+}{
+	{[]byte{0x1f, 0x8b}, ".gz"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, ".xz"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, ".zst"},
+}
+
+// CompressorMagicSize is the number of bytes at the start of a stream which
+// DetectCompressor needs to recognize every compression.
+const CompressorMagicSize = 6
+
+// DetectCompressor returns the registered compressor able to read the stream
+// starting with header, or NewCompressorNone() if the stream is not
+// compressed with any of them.
+func DetectCompressor(header []byte) Compressor {
+	for _, m := range compressorMagic {
+		if bytes.HasPrefix(header, m.magic) {
+			// Compressors sharing an extension read the same streams.
+			compressor, _ := NewCompressorFromFileName(m.extension)
+			return compressor
+		}
+	}
+	return NewCompressorNone()
+}
+
 func NewCompressorFromId(id string) (Compressor, error) {
 	compressor, ok := compressors[id]
 	if !ok {
@@ -17,10 +17,23 @@ package artifact
 import (
 	"io"
 
+	"github.com/pkg/errors"
+
 	gzip "github.com/klauspost/pgzip"
 )
 
+// gzipRsyncableBits sets the average size of the members of rsyncable
+// output to 256 KiB of uncompressed data.
+const gzipRsyncableBits = 18
+
+// gzipBlockSize is the default size of the blocks pgzip compresses at once.
+const gzipBlockSize = 1 << 20
+
 type CompressorGzip struct {
+	rsyncable bool
+	// The maximum number of blocks compressed at once, or zero for the
+	// default of pgzip.
+	concurrency int
 }
 
 func NewCompressorGzip() Compressor {
@@ -36,7 +49,40 @@ func (c *CompressorGzip) NewReader(r io.Reader) (io.ReadCloser, error) {
 }
 
 func (c *CompressorGzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
-	return gzip.NewWriterLevel(w, gzip.BestCompression)
+	if c.rsyncable {
+		return newRsyncableWriter(w, gzipRsyncableBits, c.newGzipWriter), nil
+	}
+	return c.newGzipWriter(w)
+}
+
+func (c *CompressorGzip) newGzipWriter(w io.Writer) (io.WriteCloser, error) {
+	gw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil || c.concurrency == 0 {
+		return gw, err
+	}
+	if err = gw.SetConcurrency(gzipBlockSize, c.concurrency); err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+func (c *CompressorGzip) WithConcurrency(n int) Compressor {
+	configured := *c
+	configured.concurrency = n
+	return &configured
+}
+
+func (c *CompressorGzip) WithOptions(opts ...string) (Compressor, error) {
+	configured := *c
+	for _, opt := range opts {
+		switch opt {
+		case CompressorOptionRsyncable:
+			configured.rsyncable = true
+		default:
+			return nil, errors.Errorf("gzip does not support option %q", opt)
+		}
+	}
+	return &configured, nil
 }
 
 func init() {
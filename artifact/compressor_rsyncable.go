@@ -0,0 +1,136 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"io"
+)
+
+// rsyncableGear maps each input byte to a random value for the rolling hash.
+// The values must never change, or the same input would be split
+// differently by different versions.
+var rsyncableGear = func() (gear [256]uint64) {
+	// splitmix64, with a fixed seed.
+	x := uint64(0x6d656e646572)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+	return gear
+}()
+
+// rsyncableWriter splits its input at content defined points and compresses
+// each part as a separate member (gzip) or frame (zstd) of the output, which
+// decompresses to the concatenation of the parts. As every part is
+// compressed on its own, a change in the input does not affect the output
+// of the parts after it.
+//
+// The points are found with a gear hash: each byte shifts the hash left by
+// one and adds its gear value, so the top bits of the hash depend on the
+// last 64 bytes only. A part ends where its top bits are all zero, which
+// happens once every 1<<bits bytes on average.
+type rsyncableWriter struct {
+	w         io.Writer
+	newMember func(io.Writer) (io.WriteCloser, error)
+
+	mask    uint64
+	minSize int
+	maxSize int
+
+	member io.WriteCloser
+	size   int
+	hash   uint64
+}
+
+// newRsyncableWriter returns a writer compressing parts of about 1<<bits
+// bytes with newMember.
+func newRsyncableWriter(
+	w io.Writer,
+	bits uint,
+	newMember func(io.Writer) (io.WriteCloser, error),
+) *rsyncableWriter {
+	avg := 1 << bits
+	return &rsyncableWriter{
+		w:         w,
+		newMember: newMember,
+		mask:      ^uint64(0) << (64 - bits),
+		minSize:   avg / 4,
+		maxSize:   avg * 4,
+	}
+}
+
+func (r *rsyncableWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := r.boundary(p[written:])
+		if err := r.writeMember(p[written : written+n]); err != nil {
+			return written, err
+		}
+		written += n
+		if r.size >= r.maxSize || (r.size >= r.minSize && r.hash&r.mask == 0) {
+			if err := r.closeMember(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// boundary feeds p to the rolling hash, and returns the number of bytes up
+// to and including the first possible end of the current part, or len(p).
+func (r *rsyncableWriter) boundary(p []byte) int {
+	size := r.size
+	for i, b := range p {
+		r.hash = r.hash<<1 + rsyncableGear[b]
+		size++
+		if size >= r.maxSize || (size >= r.minSize && r.hash&r.mask == 0) {
+			return i + 1
+		}
+	}
+	return len(p)
+}
+
+func (r *rsyncableWriter) writeMember(p []byte) error {
+	if r.member == nil {
+		member, err := r.newMember(r.w)
+		if err != nil {
+			return err
+		}
+		r.member = member
+		r.size = 0
+	}
+	n, err := r.member.Write(p)
+	r.size += n
+	return err
+}
+
+func (r *rsyncableWriter) closeMember() error {
+	member := r.member
+	r.member = nil
+	return member.Close()
+}
+
+func (r *rsyncableWriter) Close() error {
+	if r.member == nil {
+		// Even empty input needs one member to be valid output.
+		if err := r.writeMember(nil); err != nil {
+			return err
+		}
+	}
+	return r.closeMember()
+}
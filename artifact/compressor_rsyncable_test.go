@@ -0,0 +1,174 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compressWith(t *testing.T, c Compressor, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func commonSuffix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	return n
+}
+
+// testText returns compressible data without long repeats.
+func testText(size int) []byte {
+	words := []string{"mender ", "artifact ", "update ", "device ", "rootfs ", "\n"}
+	rnd := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rnd.Intn(len(words))])
+	}
+	return buf.Bytes()
+}
+
+func TestCompressorRsyncable(t *testing.T) {
+	data := testText(1024 * 1024)
+
+	for _, id := range []string{"gzip", "zstd_fast"} {
+		t.Run(id, func(t *testing.T) {
+			c, err := NewCompressorFromId(id)
+			require.NoError(t, err)
+			c, err = ApplyCompressorOptions(c, []string{CompressorOptionRsyncable})
+			require.NoError(t, err)
+
+			for _, input := range [][]byte{data, nil} {
+				r, err := c.NewReader(bytes.NewReader(compressWith(t, c, input)))
+				require.NoError(t, err)
+				decompressed, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.True(t, bytes.Equal(input, decompressed))
+			}
+		})
+	}
+}
+
+func TestRsyncableWriter(t *testing.T) {
+	data := testText(1024 * 1024)
+	changed := append(append(append([]byte{}, data[:1000]...),
+		"an insertion close to the start"...), data[1000:]...)
+
+	zstdFast := NewCompressorZstd(zstd.SpeedDefault).(*CompressorZstd)
+	for name, newMember := range map[string]func(io.Writer) (io.WriteCloser, error){
+		"gzip": (&CompressorGzip{}).newGzipWriter,
+		"zstd": zstdFast.newFrameWriter,
+	} {
+		t.Run(name, func(t *testing.T) {
+			compress := func(data []byte) []byte {
+				var buf bytes.Buffer
+				w := newRsyncableWriter(&buf, 14, newMember)
+				// Boundaries must not depend on how the input is split
+				// into writes.
+				for len(data) > 0 {
+					n := 1000
+					if n > len(data) {
+						n = len(data)
+					}
+					_, err := w.Write(data[:n])
+					require.NoError(t, err)
+					data = data[n:]
+				}
+				require.NoError(t, w.Close())
+				return buf.Bytes()
+			}
+
+			out := compress(data)
+			var single bytes.Buffer
+			w := newRsyncableWriter(&single, 14, newMember)
+			_, err := w.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+			assert.True(t, bytes.Equal(out, single.Bytes()))
+
+			// The regular output changes all the way to the end, while
+			// only the first parts of the rsyncable one do.
+			plain := func(data []byte) []byte {
+				var buf bytes.Buffer
+				w, err := newMember(&buf)
+				require.NoError(t, err)
+				_, err = w.Write(data)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				return buf.Bytes()
+			}
+			assert.Less(t, commonSuffix(plain(data), plain(changed)), len(out)/2)
+			assert.Greater(t, commonSuffix(out, compress(changed)), len(out)*3/4)
+		})
+	}
+}
+
+func TestCompressorRsyncableInsertion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compresses megabytes of data")
+	}
+	// Large enough for several parts of the default size of each.
+	for id, size := range map[string]int{
+		"gzip":      16 << gzipRsyncableBits,
+		"zstd_fast": 16 << zstdRsyncableBits,
+	} {
+		t.Run(id, func(t *testing.T) {
+			data := testText(size)
+			changed := append(append(append([]byte{}, data[:5000]...),
+				"an insertion close to the start"...), data[5000:]...)
+
+			c, err := NewCompressorFromId(id)
+			require.NoError(t, err)
+			c, err = ApplyCompressorOptions(c, []string{CompressorOptionRsyncable})
+			require.NoError(t, err)
+
+			out := compressWith(t, c, data)
+			assert.Greater(t, commonSuffix(out, compressWith(t, c, changed)), len(out)*3/4)
+		})
+	}
+}
+
+func TestApplyCompressorOptions(t *testing.T) {
+	c, err := ApplyCompressorOptions(NewCompressorNone(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, NewCompressorNone(), c)
+
+	_, err = ApplyCompressorOptions(NewCompressorNone(), []string{CompressorOptionRsyncable})
+	assert.EqualError(t, err, `compressor does not support option "rsyncable"`)
+
+	_, err = ApplyCompressorOptions(NewCompressorGzip(), []string{"fast"})
+	assert.EqualError(t, err, `gzip does not support option "fast"`)
+
+	// The registered compressor is not changed.
+	gz, err := NewCompressorFromId("gzip")
+	require.NoError(t, err)
+	_, err = ApplyCompressorOptions(gz, []string{CompressorOptionRsyncable})
+	require.NoError(t, err)
+	assert.False(t, gz.(*CompressorGzip).rsyncable)
+}
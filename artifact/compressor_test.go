@@ -15,9 +15,12 @@
 package artifact
 
 import (
+	"bytes"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -51,6 +54,30 @@ func TestCompressorFromFileName(t *testing.T) {
 	assert.Equal(t, c.GetFileExtension(), "")
 }
 
+func TestDetectCompressor(t *testing.T) {
+	for _, id := range GetRegisteredCompressorIds() {
+		c, err := NewCompressorFromId(id)
+		assert.NoError(t, err)
+
+		buf := bytes.NewBuffer(nil)
+		w, err := c.NewWriter(buf)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("some content which gets compressed"))
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+
+		header := buf.Bytes()
+		if len(header) > CompressorMagicSize {
+			header = header[:CompressorMagicSize]
+		}
+		assert.Equal(t, c.GetFileExtension(),
+			DetectCompressor(header).GetFileExtension(), id)
+	}
+
+	assert.Equal(t, "", DetectCompressor(nil).GetFileExtension())
+	assert.Equal(t, "", DetectCompressor([]byte{0x1f}).GetFileExtension())
+}
+
 func TestRegisteredCompressors(t *testing.T) {
 	compressorIds := GetRegisteredCompressorIds()
 
@@ -62,3 +89,32 @@ func TestRegisteredCompressors(t *testing.T) {
 	// Verify 'none' is at the beginning of the list
 	assert.Equal(t, "none", compressorIds[0])
 }
+
+func TestLimitCompressorConcurrency(t *testing.T) {
+	data := bytes.Repeat([]byte("some content which gets compressed "), 100000)
+	for _, id := range GetRegisteredCompressorIds() {
+		c, err := NewCompressorFromId(id)
+		require.NoError(t, err)
+
+		limited := LimitCompressorConcurrency(c, 1)
+		if _, ok := c.(ConcurrentCompressor); ok {
+			assert.NotSame(t, c, limited, id)
+		} else {
+			assert.Same(t, c, limited, id)
+		}
+		assert.Same(t, c, LimitCompressorConcurrency(c, 0), id)
+
+		buf := bytes.NewBuffer(nil)
+		w, err := limited.NewWriter(buf)
+		require.NoError(t, err, id)
+		_, err = w.Write(data)
+		require.NoError(t, err, id)
+		require.NoError(t, w.Close(), id)
+
+		r, err := c.NewReader(buf)
+		require.NoError(t, err, id)
+		out, err := ioutil.ReadAll(r)
+		require.NoError(t, err, id)
+		assert.Equal(t, data, out, id)
+	}
+}
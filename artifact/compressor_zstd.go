@@ -20,10 +20,20 @@ import (
 	"io"
 
 	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
 )
 
+// zstdRsyncableBits sets the average size of the frames of rsyncable output
+// to 1 MiB of uncompressed data.
+const zstdRsyncableBits = 20
+
 type CompressorZstd struct {
-	level zstd.EncoderLevel
+	level     zstd.EncoderLevel
+	rsyncable bool
+	seekable  bool
+	// The maximum number of goroutines of each encoder, or zero for the
+	// default of the zstd package.
+	concurrency int
 }
 
 func NewCompressorZstd(level zstd.EncoderLevel) Compressor {
@@ -32,6 +42,17 @@ func NewCompressorZstd(level zstd.EncoderLevel) Compressor {
 	}
 }
 
+// NewCompressorZstdSeekable returns a compressor writing seekable zstd
+// streams, which end with a table of their frames so that they can be read
+// from any frame. The frames are split at the same points as with the
+// rsyncable option.
+func NewCompressorZstdSeekable(level zstd.EncoderLevel) Compressor {
+	return &CompressorZstd{
+		level:    level,
+		seekable: true,
+	}
+}
+
 func (c *CompressorZstd) GetFileExtension() string {
 	return ".zst"
 }
@@ -45,7 +66,44 @@ func (c *CompressorZstd) NewReader(r io.Reader) (io.ReadCloser, error) {
 }
 
 func (c *CompressorZstd) NewWriter(w io.Writer) (io.WriteCloser, error) {
-	return zstd.NewWriter(w, zstd.WithEncoderLevel(c.level))
+	if c.seekable {
+		return newZstdSeekableWriter(w, c.encoderOptions()), nil
+	}
+	if c.rsyncable {
+		return newRsyncableWriter(w, zstdRsyncableBits, c.newFrameWriter), nil
+	}
+	return c.newFrameWriter(w)
+}
+
+func (c *CompressorZstd) newFrameWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, c.encoderOptions()...)
+}
+
+func (c *CompressorZstd) encoderOptions() []zstd.EOption {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(c.level)}
+	if c.concurrency > 0 {
+		opts = append(opts, zstd.WithEncoderConcurrency(c.concurrency))
+	}
+	return opts
+}
+
+func (c *CompressorZstd) WithConcurrency(n int) Compressor {
+	configured := *c
+	configured.concurrency = n
+	return &configured
+}
+
+func (c *CompressorZstd) WithOptions(opts ...string) (Compressor, error) {
+	configured := *c
+	for _, opt := range opts {
+		switch opt {
+		case CompressorOptionRsyncable:
+			configured.rsyncable = true
+		default:
+			return nil, errors.Errorf("zstd does not support option %q", opt)
+		}
+	}
+	return &configured, nil
 }
 
 func init() {
@@ -53,4 +111,5 @@ func init() {
 	RegisterCompressor("zstd_fast", NewCompressorZstd(zstd.SpeedDefault))
 	RegisterCompressor("zstd_better", NewCompressorZstd(zstd.SpeedBetterCompression))
 	RegisterCompressor("zstd_best", NewCompressorZstd(zstd.SpeedBestCompression))
+	RegisterCompressor("zstd_seekable", NewCompressorZstdSeekable(zstd.SpeedDefault))
 }
@@ -0,0 +1,256 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+//go:build !nozstd
+// +build !nozstd
+
+package artifact
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// The seekable zstd format stores a table of the sizes of the independent
+// frames of the stream in a skippable frame at its end. Decoders which do
+// not know about it skip the table, and read the stream as any other.
+const (
+	zstdSkippableMagic      = 0x184d2a5e
+	zstdSeekableMagic       = 0x8f92eab1
+	zstdSeekTableFooterSize = 9
+	zstdSeekChecksumFlag    = 0x80
+)
+
+// ZstdSeekFrame is a frame of a seekable zstd stream, which decompresses on
+// its own.
+type ZstdSeekFrame struct {
+	CompressedOffset   int64
+	CompressedSize     int64
+	DecompressedOffset int64
+	DecompressedSize   int64
+}
+
+// zstdSeekableWriter compresses frames split at content defined points, as
+// the rsyncable option does, and ends the stream with their seek table.
+type zstdSeekableWriter struct {
+	frames *rsyncableWriter
+	w      io.Writer
+	opts   []zstd.EOption
+
+	written int64
+	entries []byte
+	count   uint32
+}
+
+func newZstdSeekableWriter(w io.Writer, opts []zstd.EOption) *zstdSeekableWriter {
+	sw := &zstdSeekableWriter{w: w, opts: opts}
+	sw.frames = newRsyncableWriter(writerFunc(sw.writeCompressed), zstdRsyncableBits,
+		sw.newFrame)
+	return sw
+}
+
+// writerFunc turns a function into an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (sw *zstdSeekableWriter) writeCompressed(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	sw.written += int64(n)
+	return n, err
+}
+
+// zstdSeekFrameWriter adds its frame to the seek table when closed.
+type zstdSeekFrameWriter struct {
+	*zstd.Encoder
+	table *zstdSeekableWriter
+	start int64
+	size  int64
+}
+
+func (sw *zstdSeekableWriter) newFrame(w io.Writer) (io.WriteCloser, error) {
+	enc, err := zstd.NewWriter(w, sw.opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdSeekFrameWriter{Encoder: enc, table: sw, start: sw.written}, nil
+}
+
+func (fw *zstdSeekFrameWriter) Write(p []byte) (int, error) {
+	n, err := fw.Encoder.Write(p)
+	fw.size += int64(n)
+	return n, err
+}
+
+func (fw *zstdSeekFrameWriter) Close() error {
+	if err := fw.Encoder.Close(); err != nil {
+		return err
+	}
+	// Frames are at most four times the average size, so the sizes always
+	// fit the 32 bits of the table.
+	var entry [8]byte
+	binary.LittleEndian.PutUint32(entry[0:], uint32(fw.table.written-fw.start))
+	binary.LittleEndian.PutUint32(entry[4:], uint32(fw.size))
+	fw.table.entries = append(fw.table.entries, entry[:]...)
+	fw.table.count++
+	return nil
+}
+
+func (sw *zstdSeekableWriter) Write(p []byte) (int, error) {
+	return sw.frames.Write(p)
+}
+
+// Close writes the last frame and the seek table.
+func (sw *zstdSeekableWriter) Close() error {
+	if err := sw.frames.Close(); err != nil {
+		return err
+	}
+	size := len(sw.entries) + zstdSeekTableFooterSize
+	table := make([]byte, 8+size)
+	binary.LittleEndian.PutUint32(table[0:], zstdSkippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(size))
+	footer := table[8+copy(table[8:], sw.entries):]
+	binary.LittleEndian.PutUint32(footer[0:], sw.count)
+	// footer[4] is the descriptor; the entries have no checksums.
+	binary.LittleEndian.PutUint32(footer[5:], zstdSeekableMagic)
+	_, err := sw.writeCompressed(table)
+	return err
+}
+
+// ReadZstdSeekTable returns the frames of the seekable zstd stream of size
+// bytes in r.
+func ReadZstdSeekTable(r io.ReaderAt, size int64) ([]ZstdSeekFrame, error) {
+	if size < 8+zstdSeekTableFooterSize {
+		return nil, errors.New("zstd stream too short to be seekable")
+	}
+	footer := make([]byte, zstdSeekTableFooterSize)
+	if _, err := r.ReadAt(footer, size-zstdSeekTableFooterSize); err != nil {
+		return nil, errors.Wrap(err, "can not read zstd seek table")
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		return nil, errors.New("zstd stream is not seekable")
+	}
+	count := int64(binary.LittleEndian.Uint32(footer))
+	entrySize := int64(8)
+	if footer[4]&zstdSeekChecksumFlag != 0 {
+		entrySize = 12
+	}
+	tableSize := count*entrySize + zstdSeekTableFooterSize
+	if tableSize+8 > size {
+		return nil, errors.New("invalid zstd seek table size")
+	}
+	table := make([]byte, 8+tableSize)
+	if _, err := r.ReadAt(table, size-int64(len(table))); err != nil {
+		return nil, errors.Wrap(err, "can not read zstd seek table")
+	}
+	if binary.LittleEndian.Uint32(table) != zstdSkippableMagic ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return nil, errors.New("invalid zstd seek table frame")
+	}
+
+	frames := make([]ZstdSeekFrame, count)
+	var compressed, decompressed int64
+	for i := range frames {
+		entry := table[8+int64(i)*entrySize:]
+		frames[i] = ZstdSeekFrame{
+			CompressedOffset:   compressed,
+			CompressedSize:     int64(binary.LittleEndian.Uint32(entry)),
+			DecompressedOffset: decompressed,
+			DecompressedSize:   int64(binary.LittleEndian.Uint32(entry[4:])),
+		}
+		compressed += frames[i].CompressedSize
+		decompressed += frames[i].DecompressedSize
+	}
+	if compressed != size-int64(len(table)) {
+		return nil, errors.New("zstd seek table does not match the stream")
+	}
+	return frames, nil
+}
+
+// ZstdSeekableReader gives random access to the decompressed data of a
+// seekable zstd stream, only decompressing the frames which are read.
+type ZstdSeekableReader struct {
+	r       io.ReaderAt
+	frames  []ZstdSeekFrame
+	decoder *zstd.Decoder
+}
+
+// NewZstdSeekableReader returns a reader of the seekable zstd stream of size
+// bytes in r.
+func NewZstdSeekableReader(r io.ReaderAt, size int64) (*ZstdSeekableReader, error) {
+	frames, err := ReadZstdSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ZstdSeekableReader{r: r, frames: frames, decoder: decoder}, nil
+}
+
+// Frames returns the frames of the stream.
+func (zr *ZstdSeekableReader) Frames() []ZstdSeekFrame {
+	return zr.frames
+}
+
+// Size returns the size of the decompressed data.
+func (zr *ZstdSeekableReader) Size() int64 {
+	if len(zr.frames) == 0 {
+		return 0
+	}
+	last := zr.frames[len(zr.frames)-1]
+	return last.DecompressedOffset + last.DecompressedSize
+}
+
+// ReadAt reads the decompressed data at off into p.
+func (zr *ZstdSeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	i := sort.Search(len(zr.frames), func(i int) bool {
+		return zr.frames[i].DecompressedOffset+zr.frames[i].DecompressedSize > off
+	})
+	n := 0
+	for ; i < len(zr.frames) && n < len(p); i++ {
+		frame := zr.frames[i]
+		compressed := make([]byte, frame.CompressedSize)
+		if _, err := zr.r.ReadAt(compressed, frame.CompressedOffset); err != nil {
+			return n, errors.Wrap(err, "can not read zstd frame")
+		}
+		data, err := zr.decoder.DecodeAll(compressed, nil)
+		if err != nil {
+			return n, errors.Wrap(err, "can not decompress zstd frame")
+		}
+		if int64(len(data)) != frame.DecompressedSize {
+			return n, errors.New("zstd frame does not match the seek table")
+		}
+		n += copy(p[n:], data[off+int64(n)-frame.DecompressedOffset:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close releases the decoder.
+func (zr *ZstdSeekableReader) Close() error {
+	zr.decoder.Close()
+	return nil
+}
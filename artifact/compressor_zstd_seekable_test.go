@@ -0,0 +1,86 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+//go:build !nozstd
+// +build !nozstd
+
+package artifact
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressorZstdSeekable(t *testing.T) {
+	c, err := NewCompressorFromId("zstd_seekable")
+	require.NoError(t, err)
+	assert.Equal(t, ".zst", c.GetFileExtension())
+
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	compressed := buf.Bytes()
+
+	// Plain zstd readers skip the seek table.
+	assert.Equal(t, ".zst", DetectCompressor(compressed).GetFileExtension())
+	r, err := NewCompressorZstd(zstd.SpeedDefault).NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	zr, err := NewZstdSeekableReader(bytes.NewReader(compressed), int64(len(compressed)))
+	require.NoError(t, err)
+	defer zr.Close()
+	assert.Greater(t, len(zr.Frames()), 1)
+	assert.Equal(t, int64(len(data)), zr.Size())
+
+	// A read across a frame boundary.
+	off := zr.Frames()[1].DecompressedOffset - 10
+	p := make([]byte, 100)
+	n, err := zr.ReadAt(p, off)
+	require.NoError(t, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, data[off:off+100], p)
+
+	n, err = zr.ReadAt(p, int64(len(data))-50)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 50, n)
+	assert.Equal(t, data[len(data)-50:], p[:50])
+
+	_, err = ReadZstdSeekTable(bytes.NewReader(compressed), int64(len(compressed))-1)
+	assert.EqualError(t, err, "zstd stream is not seekable")
+}
+
+func TestCompressorZstdSeekableEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewCompressorZstdSeekable(zstd.SpeedFastest).NewWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	frames, err := ReadZstdSeekTable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.Equal(t, int64(0), frames[0].DecompressedSize)
+}
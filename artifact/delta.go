@@ -0,0 +1,70 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// DeltaMetaDataKey is the payload meta-data key of delta payloads, which
+// only hold the files that changed relative to the payload of a base
+// Artifact.
+const DeltaMetaDataKey = "mender_delta"
+
+// DeltaInfo describes a delta payload.
+type DeltaInfo struct {
+	// BaseArtifactName is the name of the Artifact the delta applies to.
+	BaseArtifactName string `json:"base_artifact_name"`
+	// DeletedFiles are the files of the base payload which are not part of
+	// the updated payload anymore.
+	DeletedFiles []string `json:"deleted_files"`
+}
+
+// MetaData returns the delta information in the generic form of payload
+// meta-data, to be stored under DeltaMetaDataKey.
+func (d *DeltaInfo) MetaData() map[string]interface{} {
+	deleted := make([]interface{}, 0, len(d.DeletedFiles))
+	for _, name := range d.DeletedFiles {
+		deleted = append(deleted, name)
+	}
+	return map[string]interface{}{
+		"base_artifact_name": d.BaseArtifactName,
+		"deleted_files":      deleted,
+	}
+}
+
+// GetDeltaInfo returns the delta information in the payload meta-data, or
+// nil if the payload is not a delta payload.
+func GetDeltaInfo(metaData map[string]interface{}) (*DeltaInfo, error) {
+	value, ok := metaData[DeltaMetaDataKey]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "delta: can not encode meta-data")
+	}
+	var delta DeltaInfo
+	if err = json.Unmarshal(data, &delta); err != nil {
+		return nil, errors.Wrapf(err, "delta: invalid %s meta-data", DeltaMetaDataKey)
+	}
+	if delta.BaseArtifactName == "" {
+		return nil, errors.Errorf("delta: %s meta-data without base_artifact_name",
+			DeltaMetaDataKey)
+	}
+	return &delta, nil
+}
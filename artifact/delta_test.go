@@ -0,0 +1,57 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeltaInfo(t *testing.T) {
+	delta := &DeltaInfo{
+		BaseArtifactName: "release-1",
+		DeletedFiles:     []string{"a", "b"},
+	}
+
+	// The meta-data survives being stored in, and read from, a header.
+	data, err := json.Marshal(map[string]interface{}{
+		"other":          "value",
+		DeltaMetaDataKey: delta.MetaData(),
+	})
+	require.NoError(t, err)
+	var metaData map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &metaData))
+
+	got, err := GetDeltaInfo(metaData)
+	require.NoError(t, err)
+	assert.Equal(t, delta, got)
+
+	got, err = GetDeltaInfo(map[string]interface{}{"other": "value"})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = GetDeltaInfo(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = GetDeltaInfo(map[string]interface{}{DeltaMetaDataKey: "release-1"})
+	assert.Error(t, err)
+	_, err = GetDeltaInfo(map[string]interface{}{
+		DeltaMetaDataKey: map[string]interface{}{"deleted_files": []interface{}{}},
+	})
+	assert.EqualError(t, err, "delta: mender_delta meta-data without base_artifact_name")
+}
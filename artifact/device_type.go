@@ -0,0 +1,83 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// MaxDeviceTypeLength is the longest device type ValidateDeviceType accepts.
+const MaxDeviceTypeLength = 128
+
+// ValidateDeviceType checks that a device type only consists of ASCII
+// letters, digits and the characters '.', '_', '-' and '+'. Devices compare
+// device types exactly, so whitespace and other characters which are easily
+// lost or changed on the way are not allowed.
+func ValidateDeviceType(deviceType string) error {
+	if deviceType == "" {
+		return errors.Wrap(ErrInvalidDeviceType, "device type is empty")
+	}
+	if len(deviceType) > MaxDeviceTypeLength {
+		return errors.Wrapf(ErrInvalidDeviceType, "device type %q is longer than %d characters",
+			deviceType, MaxDeviceTypeLength)
+	}
+	for _, c := range deviceType {
+		switch {
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+		case c == '.' || c == '_' || c == '-' || c == '+':
+		case unicode.IsSpace(c):
+			return errors.Wrapf(ErrInvalidDeviceType, "device type %q contains whitespace",
+				deviceType)
+		default:
+			return errors.Wrapf(ErrInvalidDeviceType,
+				"device type %q contains the invalid character %q", deviceType, c)
+		}
+	}
+	return nil
+}
+
+// ValidateDeviceTypes validates every device type in deviceTypes.
+func ValidateDeviceTypes(deviceTypes []string) error {
+	for _, deviceType := range deviceTypes {
+		if err := ValidateDeviceType(deviceType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NormalizeDeviceType trims whitespace around a device type and lowercases
+// it.
+func NormalizeDeviceType(deviceType string) string {
+	return strings.ToLower(strings.TrimSpace(deviceType))
+}
+
+// NormalizeDeviceTypes normalizes every device type in deviceTypes, and
+// drops the ones which become duplicates.
+func NormalizeDeviceTypes(deviceTypes []string) []string {
+	var normalized []string
+	seen := map[string]bool{}
+	for _, deviceType := range deviceTypes {
+		deviceType = NormalizeDeviceType(deviceType)
+		if !seen[deviceType] {
+			seen[deviceType] = true
+			normalized = append(normalized, deviceType)
+		}
+	}
+	return normalized
+}
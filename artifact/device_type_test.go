@@ -0,0 +1,63 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeviceType(t *testing.T) {
+	tests := map[string]struct {
+		deviceType string
+		err        string
+	}{
+		"simple":        {deviceType: "raspberrypi4"},
+		"punctuation":   {deviceType: "qemux86-64_v1.2+x"},
+		"uppercase":     {deviceType: "BeagleBone"},
+		"empty":         {err: "device type is empty"},
+		"space":         {deviceType: "my device", err: "contains whitespace"},
+		"trailing":      {deviceType: "mydevice\n", err: "contains whitespace"},
+		"slash":         {deviceType: "my/device", err: "invalid character '/'"},
+		"non-ascii":     {deviceType: "dévice", err: "invalid character 'é'"},
+		"too long":      {deviceType: strings.Repeat("a", MaxDeviceTypeLength+1), err: "longer"},
+		"longest valid": {deviceType: strings.Repeat("a", MaxDeviceTypeLength)},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateDeviceType(test.deviceType)
+			if test.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+			assert.True(t, errors.Is(err, ErrInvalidDeviceType))
+		})
+	}
+
+	assert.NoError(t, ValidateDeviceTypes([]string{"a", "b"}))
+	assert.Error(t, ValidateDeviceTypes([]string{"a", "b c"}))
+}
+
+func TestNormalizeDeviceTypes(t *testing.T) {
+	assert.Equal(t, "beaglebone", NormalizeDeviceType(" BeagleBone\t"))
+	assert.Equal(t, []string{"beaglebone", "pi"},
+		NormalizeDeviceTypes([]string{"BeagleBone", "pi ", "beaglebone"}))
+	assert.Nil(t, NormalizeDeviceTypes(nil))
+}
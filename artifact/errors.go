@@ -0,0 +1,112 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the reader and the writer, possibly wrapped. Use
+// errors.Is and errors.As to check for them.
+var (
+	// ErrInvalidSignature is returned when the signature of an Artifact
+	// does not verify.
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrChecksumMissing is returned when there is no checksum for a file
+	// of the Artifact.
+	ErrChecksumMissing = errors.New("checksum missing")
+	// ErrInvalidDeviceType is returned by ValidateDeviceType for device
+	// types which devices could fail to match.
+	ErrInvalidDeviceType = errors.New("invalid device type")
+	// ErrInvalidPayloadFileName is returned by ValidatePayloadFileName for
+	// names which can not be stored in an Artifact.
+	ErrInvalidPayloadFileName = errors.New("invalid payload file name")
+	// ErrUnsafeArchivePath is returned by ValidateArchivePath for names of
+	// files in an Artifact which could be extracted outside of the target
+	// directory.
+	ErrUnsafeArchivePath = errors.New("unsafe path in Artifact")
+	// ErrInvalidMetadataValue is returned by ValidateMetadataValue for
+	// names, keys and values which would not display as they are.
+	ErrInvalidMetadataValue = errors.New("invalid metadata value")
+)
+
+// ErrChecksumMismatch is returned when the contents of a file do not match
+// its checksum.
+type ErrChecksumMismatch struct {
+	// File is the name of the file in the Artifact, if known. It is not
+	// part of the message, as callers already mention the file.
+	File     string
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("invalid checksum; expected: [%s]; actual: [%s]",
+		e.Expected, e.Actual)
+}
+
+// ErrUnsupportedVersion is returned for Artifact format versions which can
+// not be read or written.
+type ErrUnsupportedVersion struct {
+	Got int
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("unsupported artifact version: %d", e.Got)
+}
+
+// sentinelError matches one of the sentinel errors above, while keeping a
+// more detailed message and the underlying cause.
+type sentinelError struct {
+	sentinel error
+	msg      string
+	cause    error
+}
+
+func (e *sentinelError) Error() string {
+	if e.cause == nil {
+		return e.msg
+	}
+	return e.msg + ": " + e.cause.Error()
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.cause
+}
+
+// NewInvalidSignatureError returns an error matching ErrInvalidSignature,
+// caused by err.
+func NewInvalidSignatureError(err error) error {
+	return &sentinelError{
+		sentinel: ErrInvalidSignature,
+		msg:      ErrInvalidSignature.Error(),
+		cause:    err,
+	}
+}
+
+// NewChecksumMissingError returns an error matching ErrChecksumMissing, for
+// the given file.
+func NewChecksumMissingError(file string) error {
+	return &sentinelError{
+		sentinel: ErrChecksumMissing,
+		msg:      fmt.Sprintf("checksum missing for file: '%s'", file),
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// ExternalPayloadMetaDataKey is the payload meta-data key of thin Artifacts,
+// whose payload files are not stored in the Artifact, but downloaded from
+// the URLs listed under it, for example from a CDN.
+const ExternalPayloadMetaDataKey = "mender_external_payload"
+
+// ExternalFile is a payload file which is stored outside the Artifact.
+type ExternalFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Checksum is the hex encoded sha256 checksum of the file.
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// Validate checks that the file has a name, an http or https URL and a
+// sha256 checksum.
+func (f *ExternalFile) Validate() error {
+	if f.Name == "" {
+		return errors.New("external payload: file without a name")
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return errors.Wrapf(err, "external payload: invalid URL of %s", f.Name)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("external payload: the URL of %s is not an http or https URL: %s",
+			f.Name, f.URL)
+	}
+	if sum, err := hex.DecodeString(f.Checksum); err != nil || len(sum) != 32 {
+		return errors.Errorf("external payload: invalid sha256 checksum of %s: %q",
+			f.Name, f.Checksum)
+	}
+	if f.Size < 0 {
+		return errors.Errorf("external payload: negative size of %s", f.Name)
+	}
+	return nil
+}
+
+// Verify reads the contents of the file from r, and checks its size and
+// checksum.
+func (f *ExternalFile) Verify(r io.Reader) error {
+	sum := NewWriterChecksum(ioutil.Discard)
+	n, err := io.Copy(sum, io.LimitReader(r, f.Size+1))
+	if err != nil {
+		return errors.Wrapf(err, "external payload: can not read %s", f.Name)
+	}
+	if n > f.Size {
+		return errors.Errorf("external payload: %s is larger than %d bytes", f.Name, f.Size)
+	} else if n < f.Size {
+		return errors.Errorf("external payload: %s is %d bytes, not %d", f.Name, n, f.Size)
+	}
+	if actual := sum.Checksum(); !bytes.EqualFold(actual, []byte(f.Checksum)) {
+		return &ErrChecksumMismatch{File: f.Name, Expected: []byte(f.Checksum), Actual: actual}
+	}
+	return nil
+}
+
+// ExternalFilesMetaData returns the files in the generic form of payload
+// meta-data, to be stored under ExternalPayloadMetaDataKey.
+func ExternalFilesMetaData(files []ExternalFile) []interface{} {
+	list := make([]interface{}, 0, len(files))
+	for _, f := range files {
+		list = append(list, map[string]interface{}{
+			"name":     f.Name,
+			"url":      f.URL,
+			"checksum": f.Checksum,
+			"size":     f.Size,
+		})
+	}
+	return list
+}
+
+// GetExternalFiles returns the external files in the payload meta-data, or
+// nil if the payload is stored in the Artifact.
+func GetExternalFiles(metaData map[string]interface{}) ([]ExternalFile, error) {
+	value, ok := metaData[ExternalPayloadMetaDataKey]
+	if !ok {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "external payload: can not encode meta-data")
+	}
+	var files []ExternalFile
+	if err = json.Unmarshal(data, &files); err != nil {
+		return nil, errors.Wrapf(err, "external payload: invalid %s meta-data",
+			ExternalPayloadMetaDataKey)
+	}
+	if len(files) == 0 {
+		return nil, errors.Errorf("external payload: %s meta-data without files",
+			ExternalPayloadMetaDataKey)
+	}
+	for i := range files {
+		if err = files[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
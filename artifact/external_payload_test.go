@@ -0,0 +1,102 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalFiles(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	files := []ExternalFile{{
+		Name:     "rootfs.ext4",
+		URL:      "https://cdn.example.com/rootfs.ext4",
+		Checksum: hex.EncodeToString(sum[:]),
+		Size:     7,
+	}}
+
+	// The meta-data survives being stored in, and read from, a header.
+	data, err := json.Marshal(map[string]interface{}{
+		"other":                    "value",
+		ExternalPayloadMetaDataKey: ExternalFilesMetaData(files),
+	})
+	require.NoError(t, err)
+	var metaData map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &metaData))
+
+	got, err := GetExternalFiles(metaData)
+	require.NoError(t, err)
+	assert.Equal(t, files, got)
+
+	got, err = GetExternalFiles(map[string]interface{}{"other": "value"})
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	_, err = GetExternalFiles(map[string]interface{}{ExternalPayloadMetaDataKey: "url"})
+	assert.Error(t, err)
+	_, err = GetExternalFiles(map[string]interface{}{
+		ExternalPayloadMetaDataKey: []interface{}{},
+	})
+	assert.EqualError(t, err,
+		"external payload: mender_external_payload meta-data without files")
+}
+
+func TestExternalFileValidate(t *testing.T) {
+	valid := ExternalFile{
+		Name:     "file",
+		URL:      "http://cdn.example.com/file",
+		Checksum: strings.Repeat("ab", 32),
+	}
+	assert.NoError(t, valid.Validate())
+
+	tests := map[string]func(f *ExternalFile){
+		"no name":      func(f *ExternalFile) { f.Name = "" },
+		"file URL":     func(f *ExternalFile) { f.URL = "file:///srv/file" },
+		"relative URL": func(f *ExternalFile) { f.URL = "file" },
+		"short sum":    func(f *ExternalFile) { f.Checksum = "abcd" },
+		"not hex":      func(f *ExternalFile) { f.Checksum = strings.Repeat("xy", 32) },
+		"negative":     func(f *ExternalFile) { f.Size = -1 },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			f := valid
+			change(&f)
+			assert.Error(t, f.Validate())
+		})
+	}
+}
+
+func TestExternalFileVerify(t *testing.T) {
+	sum := sha256.Sum256([]byte("payload"))
+	f := ExternalFile{Name: "file", Checksum: hex.EncodeToString(sum[:]), Size: 7}
+
+	assert.NoError(t, f.Verify(strings.NewReader("payload")))
+	assert.EqualError(t, f.Verify(strings.NewReader("pay")),
+		"external payload: file is 3 bytes, not 7")
+	assert.EqualError(t, f.Verify(strings.NewReader("payload and more")),
+		"external payload: file is larger than 7 bytes")
+
+	err := f.Verify(strings.NewReader("PAYLOAD"))
+	var mismatch *ErrChecksumMismatch
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, "file", mismatch.File)
+}
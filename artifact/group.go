@@ -0,0 +1,98 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GroupTransition describes what installing an Artifact does to the
+// artifact_group of a device. The group the Artifact provides replaces the
+// one of the device. Without one, the device leaves its group if a payload
+// clears artifact_group, as rootfs-image payloads do by default, and keeps
+// it otherwise.
+type GroupTransition struct {
+	// From are the groups a device must be in to install the Artifact; it
+	// installs in any group, or none, if empty.
+	From []string
+	// To is the group the device is in afterwards, if the Artifact sets
+	// one.
+	To string
+	// Cleared is true if the device leaves its group.
+	Cleared bool
+}
+
+// NewGroupTransition returns the transition of an Artifact with the given
+// Artifact provides and depends, and the clears provides of its payloads.
+func NewGroupTransition(
+	provides *ArtifactProvides,
+	depends *ArtifactDepends,
+	clearsProvides []string,
+) GroupTransition {
+	var t GroupTransition
+	if depends != nil {
+		t.From = depends.ArtifactGroup
+	}
+	if provides != nil {
+		t.To = provides.ArtifactGroup
+	}
+	t.Cleared = t.To == "" && ClearsArtifactGroup(clearsProvides)
+	return t
+}
+
+// ClearsArtifactGroup returns true if one of the clears provides patterns
+// matches artifact_group.
+func ClearsArtifactGroup(clearsProvides []string) bool {
+	for _, pattern := range clearsProvides {
+		if match, err := filepath.Match(pattern, ArtifactGroupKey); err == nil && match {
+			return true
+		}
+	}
+	return false
+}
+
+// KeepsGroup returns true if devices stay in the group they are in.
+func (t GroupTransition) KeepsGroup() bool {
+	return t.To == "" && !t.Cleared
+}
+
+func (t GroupTransition) String() string {
+	from := "any group"
+	if len(t.From) > 0 {
+		from = "group " + strings.Join(t.From, " or ")
+	}
+	switch {
+	case t.To != "":
+		return fmt.Sprintf("%s -> group %s", from, t.To)
+	case t.Cleared:
+		return from + " -> no group"
+	}
+	return from + " -> unchanged"
+}
+
+// ValidateGroupName checks that name can be used as an artifact_group.
+func ValidateGroupName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("the group name can not be empty")
+	}
+	if strings.TrimSpace(name) != name {
+		return errors.Errorf("the group name %q has leading or trailing whitespace", name)
+	}
+	return ValidateMetadataValue(name)
+}
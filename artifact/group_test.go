@@ -0,0 +1,68 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupTransition(t *testing.T) {
+	tests := map[string]struct {
+		provides *ArtifactProvides
+		depends  *ArtifactDepends
+		clears   []string
+		expected string
+		keeps    bool
+	}{
+		"no headers": {
+			expected: "any group -> unchanged",
+			keeps:    true,
+		},
+		"set group": {
+			provides: &ArtifactProvides{ArtifactGroup: "b"},
+			depends:  &ArtifactDepends{ArtifactGroup: []string{"a"}},
+			clears:   []string{"artifact_group"},
+			expected: "group a -> group b",
+		},
+		"cleared by pattern": {
+			provides: &ArtifactProvides{},
+			depends:  &ArtifactDepends{ArtifactGroup: []string{"a", "b"}},
+			clears:   []string{"rootfs-image.*", "artifact_*"},
+			expected: "group a or b -> no group",
+		},
+		"not cleared": {
+			provides: &ArtifactProvides{},
+			clears:   []string{"rootfs-image.*", "["},
+			expected: "any group -> unchanged",
+			keeps:    true,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			transition := NewGroupTransition(test.provides, test.depends, test.clears)
+			assert.Equal(t, test.expected, transition.String())
+			assert.Equal(t, test.keeps, transition.KeepsGroup())
+		})
+	}
+}
+
+func TestValidateGroupName(t *testing.T) {
+	assert.NoError(t, ValidateGroupName("fleet-a"))
+	assert.EqualError(t, ValidateGroupName(" "), "the group name can not be empty")
+	assert.EqualError(t, ValidateGroupName("fleet-a\n"),
+		`the group name "fleet-a\n" has leading or trailing whitespace`)
+}
@@ -40,6 +40,10 @@ var ErrValidatingData = errors.New("error validating data")
 type Info struct {
 	Format  string `json:"format"`
 	Version int    `json:"version"`
+	// ChecksumAlgorithm is the algorithm of the checksums of the manifest,
+	// if it is not sha256. Readers which do not know the field reject the
+	// Artifact, instead of failing to verify the checksums.
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
 }
 
 // Validate performs sanity checks on artifact info.
@@ -47,7 +51,7 @@ func (i Info) Validate() error {
 	if len(i.Format) == 0 || i.Version == 0 {
 		return errors.Wrap(ErrValidatingData, "Artifact Info needs a format type and a version")
 	}
-	return nil
+	return errors.Wrap(ValidateChecksumAlgorithm(i.ChecksumAlgorithm), "Artifact Info")
 }
 
 func decode(p []byte, data WriteValidator) error {
@@ -275,6 +279,26 @@ type ArtifactDepends struct {
 	ArtifactName      []string `json:"artifact_name,omitempty"`
 	CompatibleDevices []string `json:"device_type,omitempty"`
 	ArtifactGroup     []string `json:"artifact_group,omitempty"`
+	// ExcludedDevices are device types the Artifact must not be installed
+	// on, even if they match CompatibleDevices, such as a revision of a
+	// board with a known problem. Clients released before this field
+	// ignore it, and the server does not filter deployments by it: it is
+	// only enforced by readers which check the compatible devices with
+	// areader.Reader.CompatibleDevicesCallback, and by the preflight
+	// command, so the excluded devices must also be kept out of the
+	// deployments of the Artifact.
+	ExcludedDevices []string `json:"excluded_device_type,omitempty"`
+}
+
+// ExcludesDevice returns true if deviceType is one of the excluded device
+// types.
+func (a *ArtifactDepends) ExcludesDevice(deviceType string) bool {
+	for _, excluded := range a.ExcludedDevices {
+		if excluded == deviceType {
+			return true
+		}
+	}
+	return false
 }
 
 var ErrCompatibleDevices error = errors.New(
@@ -293,6 +317,7 @@ func (a *ArtifactDepends) UnmarshalJSON(b []byte) error {
 	a.ArtifactName = buf.ArtifactName
 	a.CompatibleDevices = buf.CompatibleDevices
 	a.ArtifactGroup = buf.ArtifactGroup
+	a.ExcludedDevices = buf.ExcludedDevices
 	return nil
 }
 
@@ -397,6 +422,27 @@ func (t TypeInfoProvides) Map() map[string]string {
 	return t
 }
 
+// LegacyProvides maps provides keys written by older versions of
+// mender-artifact to their current names.
+var LegacyProvides = map[string]string{
+	LegacyRootfsImageChecksumKey: RootfsImageChecksumKey,
+}
+
+// TranslateLegacy renames the legacy keys in t to their current names. If
+// both are present, the value under the current name is kept.
+func (t TypeInfoProvides) TranslateLegacy() {
+	for legacy, current := range LegacyProvides {
+		value, ok := t[legacy]
+		if !ok {
+			continue
+		}
+		if _, exists := t[current]; !exists {
+			t[current] = value
+		}
+		delete(t, legacy)
+	}
+}
+
 func NewTypeInfoProvides(m interface{}) (ti TypeInfoProvides, err error) {
 
 	const errMsgInvalidTypeFmt = "Invalid TypeInfo provides type: %T"
@@ -448,6 +494,25 @@ type TypeInfoV3 struct {
 	ArtifactDepends        TypeInfoDepends  `json:"artifact_depends,omitempty"`
 	ArtifactProvides       TypeInfoProvides `json:"artifact_provides,omitempty"`
 	ClearsArtifactProvides []string         `json:"clears_artifact_provides,omitempty"`
+
+	// Uncompressed size of the payload once installed, in bytes.
+	InstallSize int64 `json:"install_size,omitempty"`
+
+	// Base64 encoded signatures of the payload files, by file name. Each
+	// signature is made over the hex encoded checksum of the file, as it
+	// appears in the manifest, with the checksum algorithm of the Artifact.
+	PayloadSignatures map[string]string `json:"payload_signatures,omitempty"`
+
+	// Locks the Artifact metadata against modification by tooling. It is
+	// stored here rather than in header-info, as readers ignore the
+	// unknown fields of type-info.
+	ImmutableMetadata bool `json:"immutable_metadata,omitempty"`
+
+	// Names of the state scripts which only run on devices of a given
+	// device type, by device type. The scripts themselves are stored in
+	// scripts/ with all the others, where readers which do not know the
+	// field run them on every device.
+	DeviceTypeScripts map[string][]string `json:"device_type_scripts,omitempty"`
 }
 
 // Validate checks that the required `Type` field is set.
@@ -133,6 +133,25 @@ func TestHeaderInfoV3(t *testing.T) {
 	assert.Equal(t, hi.GetArtifactName(), provides.ArtifactName)
 }
 
+func TestArtifactDependsExcludedDevices(t *testing.T) {
+	var depends ArtifactDepends
+	err := json.Unmarshal([]byte(`{"device_type": ["dev1", "dev2"],
+		"excluded_device_type": ["dev2"]}`), &depends)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev2"}, depends.ExcludedDevices)
+	assert.True(t, depends.ExcludesDevice("dev2"))
+	assert.False(t, depends.ExcludesDevice("dev1"))
+
+	data, err := json.Marshal(&depends)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"excluded_device_type":["dev2"]`)
+
+	depends.ExcludedDevices = nil
+	data, err = json.Marshal(&depends)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "excluded_device_type")
+}
+
 func TestMarshalJSONHeaderInfoV3(t *testing.T) {
 	tests := map[string]struct {
 		hi       HeaderInfoV3
@@ -511,3 +530,28 @@ func TestNewTypeInfoError(t *testing.T) {
 		assert.Nil(t, tip)
 	}
 }
+
+func TestTypeInfoProvidesTranslateLegacy(t *testing.T) {
+	provides := TypeInfoProvides{
+		"rootfs_image_checksum": "abc",
+		"rootfs-image.version":  "v1",
+	}
+	provides.TranslateLegacy()
+	assert.Equal(t, TypeInfoProvides{
+		"rootfs-image.checksum": "abc",
+		"rootfs-image.version":  "v1",
+	}, provides)
+
+	// The current name takes precedence.
+	provides = TypeInfoProvides{
+		"rootfs_image_checksum": "old",
+		"rootfs-image.checksum": "new",
+	}
+	provides.TranslateLegacy()
+	assert.Equal(t, TypeInfoProvides{"rootfs-image.checksum": "new"}, provides)
+
+	// Nil maps are left alone.
+	var empty TypeInfoProvides
+	empty.TranslateLegacy()
+	assert.Nil(t, empty)
+}
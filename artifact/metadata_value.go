@@ -0,0 +1,80 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateMetadataValue checks that a name, key or value of the Artifact
+// metadata is valid UTF-8 without control characters. Such characters could
+// make the value display as something else, for example a line break could
+// make it look like several entries in the output of `mender-artifact read`.
+func ValidateMetadataValue(value string) error {
+	if !utf8.ValidString(value) {
+		return errors.Wrapf(ErrInvalidMetadataValue, "%q is not valid UTF-8", value)
+	}
+	for _, c := range value {
+		if unicode.IsControl(c) {
+			return errors.Wrapf(ErrInvalidMetadataValue,
+				"%q contains the control character %q", value, c)
+		}
+	}
+	return nil
+}
+
+// ValidateMetadataValues validates every value in values.
+func ValidateMetadataValues(values []string) error {
+	for _, value := range values {
+		if err := ValidateMetadataValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateValues validates the type, and the keys and values of the provides,
+// depends and clears provides of ti with ValidateMetadataValue.
+func (ti *TypeInfoV3) ValidateValues() error {
+	if ti.Type != nil {
+		if err := ValidateMetadataValue(*ti.Type); err != nil {
+			return errors.Wrap(err, "type")
+		}
+	}
+	for key, value := range ti.ArtifactProvides {
+		if err := ValidateMetadataValues([]string{key, value}); err != nil {
+			return errors.Wrap(err, "provides")
+		}
+	}
+	for key, value := range ti.ArtifactDepends {
+		values := []string{key}
+		switch v := value.(type) {
+		case string:
+			values = append(values, v)
+		case []string:
+			values = append(values, v...)
+		}
+		if err := ValidateMetadataValues(values); err != nil {
+			return errors.Wrap(err, "depends")
+		}
+	}
+	if err := ValidateMetadataValues(ti.ClearsArtifactProvides); err != nil {
+		return errors.Wrap(err, "clears provides")
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMetadataValue(t *testing.T) {
+	assert.NoError(t, ValidateMetadataValue(""))
+	assert.NoError(t, ValidateMetadataValue("rootfs-image.version"))
+	assert.NoError(t, ValidateMetadataValue("mise à jour 日本語 1.0"))
+
+	err := ValidateMetadataValue("1.0\nrootfs-image.checksum: fake")
+	assert.True(t, errors.Is(err, ErrInvalidMetadataValue))
+	assert.Contains(t, err.Error(), `contains the control character '\n'`)
+
+	err = ValidateMetadataValue("\x1b[2Jrelease")
+	assert.Contains(t, err.Error(), `contains the control character '\x1b'`)
+
+	err = ValidateMetadataValue("release\xff")
+	assert.True(t, errors.Is(err, ErrInvalidMetadataValue))
+	assert.Contains(t, err.Error(), "is not valid UTF-8")
+}
+
+func TestTypeInfoV3ValidateValues(t *testing.T) {
+	updateType := "rootfs-image"
+	valid := TypeInfoV3{
+		Type:                   &updateType,
+		ArtifactProvides:       TypeInfoProvides{"rootfs-image.version": "1.0"},
+		ArtifactDepends:        TypeInfoDepends{"a": "b", "c": []string{"d", "e"}},
+		ClearsArtifactProvides: []string{"rootfs-image.*"},
+	}
+	assert.NoError(t, valid.ValidateValues())
+	assert.NoError(t, (&TypeInfoV3{}).ValidateValues())
+
+	invalid := valid
+	invalid.ArtifactProvides = TypeInfoProvides{"key\t": "1.0"}
+	assert.Contains(t, invalid.ValidateValues().Error(), "provides: ")
+
+	invalid = valid
+	invalid.ArtifactDepends = TypeInfoDepends{"c": []string{"d", "e\r"}}
+	assert.Contains(t, invalid.ValidateValues().Error(), "depends: ")
+
+	invalid = valid
+	invalid.ClearsArtifactProvides = []string{"\x00"}
+	assert.Contains(t, invalid.ValidateValues().Error(), "clears provides: ")
+}
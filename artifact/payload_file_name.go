@@ -0,0 +1,101 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// OriginalFileNamesMetaDataKey is the payload meta-data key holding the
+// original names of payload files which were renamed to be valid, by their
+// name in the Artifact.
+const OriginalFileNamesMetaDataKey = "mender_original_file_names"
+
+// FilePathsMetaDataKey is the payload meta-data key holding the paths of
+// payload files added from a directory tree, relative to its root and by
+// their name in the Artifact, so that update modules can restore the tree.
+const FilePathsMetaDataKey = "mender_file_paths"
+
+// ValidatePayloadFileName checks that the name of a payload file only
+// consists of ASCII letters, digits and the characters '.', ',', '_' and
+// '-', which is what the reader accepts in the payload of an Artifact.
+func ValidatePayloadFileName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return errors.Wrapf(ErrInvalidPayloadFileName, "%q is not a file name", name)
+	}
+	for _, c := range name {
+		if !isPayloadFileNameChar(c) {
+			return errors.Wrapf(ErrInvalidPayloadFileName,
+				"payload file name %q contains the invalid character %q;"+
+					" only letters, digits and characters in the set \".,_-\" are allowed",
+				name, c)
+		}
+	}
+	return nil
+}
+
+func isPayloadFileNameChar(c rune) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) ||
+		c == '.' || c == ',' || c == '_' || c == '-'
+}
+
+// SanitizePayloadFileName replaces every character of name which
+// ValidatePayloadFileName does not accept with '_'.
+func SanitizePayloadFileName(name string) string {
+	if name == "" || name == "." || name == ".." {
+		return strings.Repeat("_", len(name)+1)
+	}
+	return strings.Map(func(c rune) rune {
+		if isPayloadFileNameChar(c) {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+// SanitizePayloadFileNames sanitizes names, and makes them unique by adding
+// "-2", "-3" and so on before the extension of the names which are already
+// taken. Names which are valid and unique are kept as they are.
+func SanitizePayloadFileNames(names []string) []string {
+	taken := map[string]bool{}
+	for _, name := range names {
+		if ValidatePayloadFileName(name) == nil {
+			taken[name] = true
+		}
+	}
+	sanitized := make([]string, len(names))
+	kept := map[string]bool{}
+	for i, name := range names {
+		if taken[name] && !kept[name] {
+			kept[name] = true
+			sanitized[i] = name
+			continue
+		}
+		candidate := SanitizePayloadFileName(name)
+		ext := filepath.Ext(candidate)
+		base := strings.TrimSuffix(candidate, ext)
+		for n := 2; taken[candidate]; n++ {
+			candidate = fmt.Sprintf("%s-%d%s", base, n, ext)
+		}
+		taken[candidate] = true
+		sanitized[i] = candidate
+	}
+	return sanitized
+}
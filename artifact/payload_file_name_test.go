@@ -0,0 +1,61 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePayloadFileName(t *testing.T) {
+	tests := map[string]struct {
+		name string
+		err  string
+	}{
+		"simple":      {name: "rootfs.ext4"},
+		"punctuation": {name: "update_v1,2-final.tar.gz"},
+		"empty":       {err: `"" is not a file name`},
+		"dot":         {name: ".", err: `"." is not a file name`},
+		"space":       {name: "my update", err: "invalid character ' '"},
+		"newline":     {name: "update\n", err: `invalid character '\n'`},
+		"non-ascii":   {name: "mise-à-jour", err: "invalid character 'à'"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidatePayloadFileName(test.name)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, errors.Is(err, ErrInvalidPayloadFileName))
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestSanitizePayloadFileNames(t *testing.T) {
+	assert.Equal(t, "my_update_.tar", SanitizePayloadFileName("my update\n.tar"))
+	assert.Equal(t, "__", SanitizePayloadFileName("."))
+
+	// Valid names are kept, even if a renamed file comes first.
+	assert.Equal(t,
+		[]string{"a_b-2.txt", "a_b.txt", "c", "a_b-3.txt", "c-2", "d"},
+		SanitizePayloadFileNames([]string{"a b.txt", "a_b.txt", "c", "a+b.txt", "c", "d"}))
+	for _, name := range SanitizePayloadFileNames([]string{"", ".", ".."}) {
+		assert.NoError(t, ValidatePayloadFileName(name))
+	}
+}
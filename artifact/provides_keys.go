@@ -0,0 +1,72 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+// Standard keys of the payload provides.
+const (
+	// RootfsImageType is the payload type of full root filesystem updates,
+	// and the default software filesystem of the software version keys.
+	RootfsImageType = "rootfs-image"
+	// RootfsImageVersionKey is the software version of a rootfs-image
+	// payload.
+	RootfsImageVersionKey = RootfsImageType + ".version"
+	// RootfsImageChecksumKey is the checksum of the root filesystem of a
+	// rootfs-image payload.
+	RootfsImageChecksumKey = RootfsImageType + ".checksum"
+	// LegacyRootfsImageChecksumKey is the name older versions of
+	// mender-artifact used for RootfsImageChecksumKey.
+	LegacyRootfsImageChecksumKey = "rootfs_image_checksum"
+	// RootfsImageArchKey is the architecture of the binaries in the root
+	// filesystem of a rootfs-image payload, e.g. "aarch64".
+	RootfsImageArchKey = RootfsImageType + ".arch"
+	// RootfsImageOSKey is the operating system of the root filesystem of a
+	// rootfs-image payload, by its os-release ID, e.g. "debian".
+	RootfsImageOSKey = RootfsImageType + ".os"
+	// ArtifactGroupKey is the Artifact group, which rootfs-image payloads
+	// clear by default.
+	ArtifactGroupKey = "artifact_group"
+)
+
+// softwareKeyPrefix returns the namespace of the keys of the software with
+// the given name, in the given filesystem.
+func softwareKeyPrefix(filesystem, name string) string {
+	if filesystem == "" {
+		filesystem = RootfsImageType
+	}
+	if name == "" {
+		return filesystem + "."
+	}
+	return filesystem + "." + name + "."
+}
+
+// BuildSoftwareVersionKey returns the provides key of the version of the
+// software with the given name, in the given filesystem, which defaults to
+// "rootfs-image". Without a name it is the version of the filesystem itself,
+// e.g. "rootfs-image.version"; otherwise e.g. "rootfs-image.app.version".
+func BuildSoftwareVersionKey(filesystem, name string) string {
+	return softwareKeyPrefix(filesystem, name) + "version"
+}
+
+// BuildSoftwareProvidesKey returns the provides key holding the given
+// attribute of the software, e.g. "rootfs-image.app.build-id".
+func BuildSoftwareProvidesKey(filesystem, name, attribute string) string {
+	return softwareKeyPrefix(filesystem, name) + attribute
+}
+
+// BuildSoftwareClearsProvides returns the clears-provides pattern which
+// matches all provides keys of the software, e.g. "rootfs-image.app.*".
+func BuildSoftwareClearsProvides(filesystem, name string) string {
+	return softwareKeyPrefix(filesystem, name) + "*"
+}
@@ -0,0 +1,41 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSoftwareKeys(t *testing.T) {
+	tests := []struct {
+		filesystem, name         string
+		version, buildID, clears string
+	}{
+		{"", "", "rootfs-image.version", "rootfs-image.build-id", "rootfs-image.*"},
+		{"", "app", "rootfs-image.app.version", "rootfs-image.app.build-id",
+			"rootfs-image.app.*"},
+		{"data", "app", "data.app.version", "data.app.build-id", "data.app.*"},
+		{"data", "", "data.version", "data.build-id", "data.*"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.version, BuildSoftwareVersionKey(test.filesystem, test.name))
+		assert.Equal(t, test.buildID,
+			BuildSoftwareProvidesKey(test.filesystem, test.name, "build-id"))
+		assert.Equal(t, test.clears, BuildSoftwareClearsProvides(test.filesystem, test.name))
+	}
+	assert.Equal(t, RootfsImageVersionKey, BuildSoftwareVersionKey("", ""))
+}
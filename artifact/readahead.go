@@ -0,0 +1,135 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// DefaultReadBufferSize is the buffer size used by the read-ahead reader when
+// no explicit size is given.
+const DefaultReadBufferSize = 1024 * 1024
+
+type readAheadChunk struct {
+	data []byte
+	err  error
+}
+
+// ReadAheadReader reads from the underlying reader in a separate goroutine,
+// keeping up to a fixed number of buffers filled ahead of the consumer. This
+// hides the latency of slow, network backed storage, where the small
+// sequential reads done by the tar and decompression layers would otherwise
+// dominate.
+type ReadAheadReader struct {
+	chunks  chan readAheadChunk
+	free    chan []byte
+	done    chan struct{}
+	once    sync.Once
+	current []byte
+	buf     []byte
+	err     error
+}
+
+// NewReadAheadReader returns a reader prefetching up to depth buffers of
+// bufSize bytes each from r. Close must be called if the reader is abandoned
+// before reaching the end of the stream, to stop the prefetching goroutine.
+func NewReadAheadReader(r io.Reader, bufSize, depth int) *ReadAheadReader {
+	if bufSize <= 0 {
+		bufSize = DefaultReadBufferSize
+	}
+	if depth <= 0 {
+		depth = 1
+	}
+	ra := &ReadAheadReader{
+		chunks: make(chan readAheadChunk, depth),
+		free:   make(chan []byte, depth+1),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < depth+1; i++ {
+		ra.free <- make([]byte, bufSize)
+	}
+	go ra.fill(r)
+	return ra
+}
+
+func (ra *ReadAheadReader) fill(r io.Reader) {
+	defer close(ra.chunks)
+	for {
+		var buf []byte
+		select {
+		case buf = <-ra.free:
+		case <-ra.done:
+			return
+		}
+		n, err := io.ReadFull(r, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		select {
+		case ra.chunks <- readAheadChunk{data: buf[:n], err: err}:
+		case <-ra.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (ra *ReadAheadReader) Read(p []byte) (int, error) {
+	for len(ra.current) == 0 {
+		if ra.buf != nil {
+			ra.free <- ra.buf[:cap(ra.buf)]
+			ra.buf = nil
+		}
+		if ra.err != nil {
+			return 0, ra.err
+		}
+		chunk, ok := <-ra.chunks
+		if !ok {
+			return 0, io.ErrClosedPipe
+		}
+		ra.buf = chunk.data
+		ra.current = chunk.data
+		ra.err = chunk.err
+	}
+	n := copy(p, ra.current)
+	ra.current = ra.current[n:]
+	return n, nil
+}
+
+// Close stops the prefetching goroutine. It does not close the underlying
+// reader.
+func (ra *ReadAheadReader) Close() error {
+	ra.once.Do(func() {
+		close(ra.done)
+	})
+	return nil
+}
+
+// NewReadBuffer wraps r in a reader issuing reads of at least bufSize bytes
+// to the underlying stream, optionally prefetching readAhead buffers in the
+// background. If both bufSize and readAhead are zero, r is returned as is.
+func NewReadBuffer(r io.Reader, bufSize, readAhead int) io.Reader {
+	if readAhead > 0 {
+		return NewReadAheadReader(r, bufSize, readAhead)
+	}
+	if bufSize > 0 {
+		return bufio.NewReaderSize(r, bufSize)
+	}
+	return r
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAheadReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, bufSize := range []int{0, 1, 7, 4096, 100000} {
+		for _, depth := range []int{0, 1, 3} {
+			r := NewReadAheadReader(iotest.HalfReader(bytes.NewReader(data)),
+				bufSize, depth)
+			out, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, data, out)
+			assert.NoError(t, r.Close())
+		}
+	}
+
+	// Errors from the underlying reader are passed through.
+	myErr := errors.New("read failed")
+	r := NewReadAheadReader(io.MultiReader(bytes.NewReader(data[:5]),
+		iotest.ErrReader(myErr)), 10, 2)
+	out, err := ioutil.ReadAll(r)
+	assert.Equal(t, myErr, err)
+	assert.Equal(t, data[:5], out)
+
+	// Closing before reaching the end does not block.
+	r = NewReadAheadReader(bytes.NewReader(data), 10, 2)
+	assert.NoError(t, r.Close())
+	assert.NoError(t, r.Close())
+}
+
+func TestNewReadBuffer(t *testing.T) {
+	src := bytes.NewReader([]byte("data"))
+	assert.Equal(t, src, NewReadBuffer(src, 0, 0))
+	assert.IsType(t, &ReadAheadReader{}, NewReadBuffer(src, 0, 1))
+
+	out, err := ioutil.ReadAll(NewReadBuffer(src, 16, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(out))
+}
+
+func TestReaderChecksumSize(t *testing.T) {
+	data := []byte("some data to checksum")
+	sum := NewWriterChecksum(ioutil.Discard)
+	_, err := sum.Write(data)
+	assert.NoError(t, err)
+
+	c := NewReaderChecksumSize(iotest.OneByteReader(bytes.NewReader(data)),
+		sum.Checksum(), 8)
+	_, err = io.Copy(ioutil.Discard, c)
+	assert.NoError(t, err)
+
+	c = NewReaderChecksumSize(bytes.NewReader(data), []byte("bad"), 8)
+	_, err = io.Copy(ioutil.Discard, c)
+	assert.Error(t, err)
+}
@@ -17,12 +17,16 @@ package artifact
 import (
 	"path/filepath"
 	"regexp"
+	"sort"
 
 	"github.com/pkg/errors"
 )
 
 type Scripts struct {
 	names map[string]string
+	// deviceTypes holds the scripts which only run on devices of one
+	// device type.
+	deviceTypes map[string]*Scripts
 }
 
 var availableScriptType = map[string]bool{
@@ -39,6 +43,22 @@ var availableScriptType = map[string]bool{
 	"ArtifactFailure":        true,
 }
 
+// scriptStates lists the states with scripts in the order the client runs
+// them; the rollback and failure states only run if the update fails.
+var scriptStates = []string{
+	"ArtifactInstall",
+	"ArtifactReboot",
+	"ArtifactCommit",
+	"ArtifactRollback",
+	"ArtifactRollbackReboot",
+	"ArtifactFailure",
+}
+
+var scriptActions = []string{"Enter", "Leave", "Error"}
+
+// all scripts must be formated like `ArtifactInstall_Enter_05_wifi-driver`
+var scriptNameRe = regexp.MustCompile(`([A-Za-z]+)_(Enter|Leave|Error)_([0-9][0-9])(_\S+)?`)
+
 func (s *Scripts) Add(path string) error {
 	if s.names == nil {
 		s.names = make(map[string]string)
@@ -46,13 +66,10 @@ func (s *Scripts) Add(path string) error {
 
 	name := filepath.Base(path)
 
-	// all scripts must be formated like `ArtifactInstall_Enter_05_wifi-driver`
-	re := regexp.MustCompile(`([A-Za-z]+)_(Enter|Leave|Error)_[0-9][0-9](_\S+)?`)
-
 	// `matches` should contain a slice of string of match of regex;
 	// the first element should be the whole matched name of the script and
 	// the second one shold be the name of the state
-	matches := re.FindStringSubmatch(name)
+	matches := scriptNameRe.FindStringSubmatch(name)
 	if matches == nil || len(matches) < 3 {
 		return errors.Errorf(
 			"Invalid script name: %q. Scripts must have a name on the form:"+
@@ -68,15 +85,143 @@ func (s *Scripts) Add(path string) error {
 	if _, exists := s.names[name]; exists {
 		return errors.Errorf("Script already exists: %s", name)
 	}
+	for deviceType, scr := range s.deviceTypes {
+		if _, exists := scr.names[name]; exists {
+			return errors.Errorf("Script already exists for device type %s: %s",
+				deviceType, name)
+		}
+	}
 
 	s.names[name] = path
 	return nil
 }
 
+// AddForDeviceType adds a script which only runs on devices of deviceType.
+// All the scripts are stored side by side in scripts/, so the name must not
+// be used by any other script; the device type of the script is listed in
+// the device_type_scripts field of the type-info.
+func (s *Scripts) AddForDeviceType(deviceType, path string) error {
+	if err := ValidateDeviceType(deviceType); err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	if _, exists := s.names[name]; exists {
+		return errors.Errorf("Script already exists: %s", name)
+	}
+	for other, scr := range s.deviceTypes {
+		if _, exists := scr.names[name]; exists && other != deviceType {
+			return errors.Errorf("Script already exists for device type %s: %s",
+				other, name)
+		}
+	}
+	if s.deviceTypes == nil {
+		s.deviceTypes = make(map[string]*Scripts)
+	}
+	scr, ok := s.deviceTypes[deviceType]
+	if !ok {
+		scr = &Scripts{}
+		s.deviceTypes[deviceType] = scr
+	}
+	if err := scr.Add(path); err != nil {
+		return errors.Wrapf(err, "device type %s", deviceType)
+	}
+	return nil
+}
+
+// DeviceTypes returns the sorted device types which have scripts of their
+// own.
+func (s *Scripts) DeviceTypes() []string {
+	deviceTypes := make([]string, 0, len(s.deviceTypes))
+	for deviceType := range s.deviceTypes {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	return deviceTypes
+}
+
+// ForDeviceType returns the scripts which only run on devices of
+// deviceType, or nil if there are none.
+func (s *Scripts) ForDeviceType(deviceType string) *Scripts {
+	return s.deviceTypes[deviceType]
+}
+
+// DeviceTypeNames returns the names of the scripts of each device type, in
+// the order they run, as listed in the type-info.
+func (s *Scripts) DeviceTypeNames() map[string][]string {
+	if len(s.deviceTypes) == 0 {
+		return nil
+	}
+	names := make(map[string][]string, len(s.deviceTypes))
+	for deviceType, scr := range s.deviceTypes {
+		for _, path := range scr.Ordered() {
+			names[deviceType] = append(names[deviceType], filepath.Base(path))
+		}
+	}
+	return names
+}
+
+// Get returns the paths of the scripts, sorted by script name, so that they
+// are always stored in the same order.
 func (s *Scripts) Get() []string {
-	scr := make([]string, 0, len(s.names))
-	for _, script := range s.names {
-		scr = append(scr, script)
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	scr := make([]string, 0, len(names))
+	for _, name := range names {
+		scr = append(scr, s.names[name])
+	}
+	return scr
+}
+
+func indexOf(list []string, s string) int {
+	for i, l := range list {
+		if l == s {
+			return i
+		}
+	}
+	return len(list)
+}
+
+// Ordered returns the paths of the scripts in the order the client runs them:
+// by state, then by action, then by name.
+func (s *Scripts) Ordered() []string {
+	names := make([]string, 0, len(s.names))
+	for name := range s.names {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a := scriptNameRe.FindStringSubmatch(names[i])
+		b := scriptNameRe.FindStringSubmatch(names[j])
+		if a[1] != b[1] {
+			return indexOf(scriptStates, a[1]) < indexOf(scriptStates, b[1])
+		}
+		if a[2] != b[2] {
+			return indexOf(scriptActions, a[2]) < indexOf(scriptActions, b[2])
+		}
+		return names[i] < names[j]
+	})
+	scr := make([]string, 0, len(names))
+	for _, name := range names {
+		scr = append(scr, s.names[name])
 	}
 	return scr
 }
+
+// CheckOrdering returns an error if two scripts share state, action and
+// ordering number, as the order they run in then only depends on their
+// descriptions.
+func (s *Scripts) CheckOrdering() error {
+	seen := make(map[string]string)
+	for _, path := range s.Ordered() {
+		m := scriptNameRe.FindStringSubmatch(filepath.Base(path))
+		slot := m[1] + "_" + m[2] + "_" + m[3]
+		if other, ok := seen[slot]; ok {
+			return errors.Errorf("Scripts %s and %s have the same state and ordering"+
+				" number %s; give them distinct ordering numbers", other, path, slot)
+		}
+		seen[slot] = path
+	}
+	return nil
+}
@@ -17,6 +17,7 @@ package artifact
 import (
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -61,3 +62,68 @@ func TestAdding(t *testing.T) {
 	assert.Contains(t, err.Error(), "Invalid script")
 	assert.Len(t, s.names, 3)
 }
+
+func TestOrdered(t *testing.T) {
+	s := Scripts{}
+	for _, path := range []string{
+		"layer2/ArtifactFailure_Enter_00",
+		"layer1/ArtifactCommit_Leave_01",
+		"layer2/ArtifactInstall_Error_00",
+		"layer1/ArtifactInstall_Enter_10_b",
+		"layer2/ArtifactInstall_Enter_05",
+		"layer2/ArtifactInstall_Leave_00",
+		"layer1/ArtifactCommit_Enter_99",
+	} {
+		assert.NoError(t, s.Add(path))
+	}
+	assert.Equal(t, []string{
+		"layer2/ArtifactInstall_Enter_05",
+		"layer1/ArtifactInstall_Enter_10_b",
+		"layer2/ArtifactInstall_Leave_00",
+		"layer2/ArtifactInstall_Error_00",
+		"layer1/ArtifactCommit_Enter_99",
+		"layer1/ArtifactCommit_Leave_01",
+		"layer2/ArtifactFailure_Enter_00",
+	}, s.Ordered())
+	assert.NoError(t, s.CheckOrdering())
+
+	assert.NoError(t, s.Add("layer3/ArtifactInstall_Enter_10_a"))
+	err := s.CheckOrdering()
+	assert.EqualError(t, err, "Scripts layer3/ArtifactInstall_Enter_10_a and"+
+		" layer1/ArtifactInstall_Enter_10_b have the same state and ordering number"+
+		" ArtifactInstall_Enter_10; give them distinct ordering numbers")
+}
+
+func TestAddForDeviceType(t *testing.T) {
+	s := Scripts{}
+	assert.NoError(t, s.Add("/dir/ArtifactInstall_Enter_10"))
+	assert.NoError(t, s.AddForDeviceType("rpi4", "/rpi4/ArtifactInstall_Enter_10_rpi4"))
+	assert.NoError(t, s.AddForDeviceType("rpi4", "/rpi4/ArtifactCommit_Enter_10"))
+	assert.NoError(t, s.AddForDeviceType("rpi3", "/rpi3/ArtifactReboot_Leave_00"))
+
+	// Scripts of a device type are kept apart from the others.
+	assert.Equal(t, []string{"/dir/ArtifactInstall_Enter_10"}, s.Get())
+	assert.Equal(t, []string{"rpi3", "rpi4"}, s.DeviceTypes())
+	assert.Equal(t, []string{"/rpi4/ArtifactInstall_Enter_10_rpi4", "/rpi4/ArtifactCommit_Enter_10"},
+		s.ForDeviceType("rpi4").Ordered())
+	assert.Nil(t, s.ForDeviceType("rpi2"))
+	assert.Equal(t, map[string][]string{
+		"rpi3": {"ArtifactReboot_Leave_00"},
+		"rpi4": {"ArtifactInstall_Enter_10_rpi4", "ArtifactCommit_Enter_10"},
+	}, s.DeviceTypeNames())
+
+	err := s.AddForDeviceType("rpi4", "/other/ArtifactCommit_Enter_10")
+	assert.EqualError(t, err, "device type rpi4: Script already exists: ArtifactCommit_Enter_10")
+	// All the scripts are stored side by side, so their names are unique.
+	err = s.AddForDeviceType("rpi4", "/rpi4/ArtifactInstall_Enter_10")
+	assert.EqualError(t, err, "Script already exists: ArtifactInstall_Enter_10")
+	err = s.AddForDeviceType("rpi4", "/rpi4/ArtifactReboot_Leave_00")
+	assert.EqualError(t, err, "Script already exists for device type rpi3: ArtifactReboot_Leave_00")
+	err = s.Add("/dir/ArtifactReboot_Leave_00")
+	assert.EqualError(t, err, "Script already exists for device type rpi3: ArtifactReboot_Leave_00")
+	err = s.AddForDeviceType("../rpi4", "/rpi4/ArtifactFailure_Enter_10")
+	assert.Error(t, err)
+	assert.Equal(t, ErrInvalidDeviceType, errors.Cause(err))
+
+	assert.Nil(t, (&Scripts{}).DeviceTypeNames())
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SignatureFileName is the name in the Artifact of its first signature.
+// Any further signatures of the same manifest follow it as
+// "manifest.sig.1", "manifest.sig.2" and so on.
+const SignatureFileName = "manifest.sig"
+
+// NthSignatureFileName returns the name of signature number n of an
+// Artifact, counting from zero.
+func NthSignatureFileName(n int) string {
+	if n == 0 {
+		return SignatureFileName
+	}
+	return SignatureFileName + "." + strconv.Itoa(n)
+}
+
+// SignatureFileNumber returns the number of the signature called name, and
+// false if name is not the name of a signature.
+func SignatureFileNumber(name string) (int, bool) {
+	if name == SignatureFileName {
+		return 0, true
+	}
+	suffix := strings.TrimPrefix(name, SignatureFileName+".")
+	if suffix == name || suffix == "" || strings.TrimLeft(suffix, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(suffix)
+	if err != nil || n == 0 || suffix != strconv.Itoa(n) {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureFileNames(t *testing.T) {
+	assert.Equal(t, "manifest.sig", NthSignatureFileName(0))
+	assert.Equal(t, "manifest.sig.1", NthSignatureFileName(1))
+	assert.Equal(t, "manifest.sig.12", NthSignatureFileName(12))
+
+	for n := 0; n < 15; n++ {
+		got, ok := SignatureFileNumber(NthSignatureFileName(n))
+		assert.True(t, ok)
+		assert.Equal(t, n, got)
+	}
+
+	for _, name := range []string{
+		"manifest",
+		"manifest.sig.",
+		"manifest.sig.0",
+		"manifest.sig.01",
+		"manifest.sig.-1",
+		"manifest.sig.1a",
+		"manifest.sigs",
+		"header.tar",
+	} {
+		_, ok := SignatureFileNumber(name)
+		assert.False(t, ok, name)
+	}
+}
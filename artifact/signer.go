@@ -17,6 +17,7 @@ package artifact
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -129,6 +130,29 @@ func (e *ECDSA256) Verify(message, sig []byte, key interface{}) error {
 
 }
 
+// ED25519 Crypto interface implementation. The message is signed as it is,
+// since ed25519 hashes it itself.
+type ED25519 struct{}
+
+func (e *ED25519) Sign(message []byte, key interface{}) ([]byte, error) {
+	ed25519Key, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signer: invalid private key")
+	}
+	return ed25519.Sign(ed25519Key, message), nil
+}
+
+func (e *ED25519) Verify(message, sig []byte, key interface{}) error {
+	ed25519Key, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return errors.New("signer: invalid ed25519 public key")
+	}
+	if !ed25519.Verify(ed25519Key, message, sig) {
+		return errors.New("signer: verification failed")
+	}
+	return nil
+}
+
 func MarshalECDSASignature(r, s *big.Int) ([]byte, error) {
 	// we serialize the r and s into one array where the first
 	// half is the r and the other one s;
@@ -225,7 +249,7 @@ type SigningMethod struct {
 }
 
 // PKISigner implements public-key encryption and supports X.509-encodded keys.
-// For now both RSA and 256 bits ECDSA are supported.
+// RSA, 256 bits ECDSA and ed25519 are supported.
 type PKISigner struct {
 	signMethod, verifyMethod *SigningMethod
 }
@@ -317,6 +341,8 @@ func GetKeyAndVerifyMethod(keyPEM []byte) (*SigningMethod, error) {
 		return &SigningMethod{Key: pub, Method: new(RSA)}, nil
 	case *ecdsa.PublicKey:
 		return &SigningMethod{Key: pub, Method: new(ECDSA256)}, nil
+	case ed25519.PublicKey:
+		return &SigningMethod{Key: pub, Method: new(ED25519)}, nil
 	default:
 		return nil, errors.Errorf("unsupported public key type: %v", pub)
 	}
@@ -367,6 +393,17 @@ func GetKeyAndSignMethod(keyPEM []byte) (*SigningMethod, error) {
 		}
 	}
 
+	// ed25519 keys only come in PKCS #8.
+	if ed25519Key, ok := key.(ed25519.PrivateKey); ok {
+		if err == nil {
+			pub, keyErr := x509.MarshalPKIXPublicKey(ed25519Key.Public())
+			if keyErr != nil {
+				return nil, errors.Wrap(keyErr, "signer: can not extract public ed25519 key")
+			}
+			return &SigningMethod{Key: ed25519Key, Public: pub, Method: new(ED25519)}, nil
+		}
+	}
+
 	if err == nil {
 		err = errors.New("unsupported private key type")
 	}
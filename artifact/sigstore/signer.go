@@ -0,0 +1,177 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package sigstore signs and verifies Artifacts with Sigstore, using the
+// cosign tool. Signing is either keyless, with a short lived certificate from
+// Fulcio for an OIDC identity, or with a cosign key. Either way the signature
+// is recorded in the Rekor transparency log, and the returned signature is the
+// cosign bundle holding the signature, the certificate and the Rekor entry,
+// which is stored in the Artifact in place of a plain signature.
+package sigstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// KeylessArgument selects keyless signing in place of a cosign key.
+const KeylessArgument = "keyless"
+
+// OIDCIssuerEnv is the environment variable holding the OIDC issuer of the
+// identity that keyless signatures must be made by, when verifying them.
+const OIDCIssuerEnv = "SIGSTORE_OIDC_ISSUER"
+
+const cosignMissingErr = "The `cosign` binary is not found on the system. See" +
+	" https://docs.sigstore.dev/cosign/system_config/installation/ to install it."
+
+// cosignCommand is the cosign binary; tests replace it.
+var cosignCommand = "cosign"
+
+// Signer signs and verifies Artifacts with cosign.
+type Signer struct {
+	// key is the cosign key; the private key when signing, and the
+	// public key when verifying. It is empty for keyless signatures.
+	key string
+	// identity and issuer are the certificate identity and OIDC issuer
+	// keyless signatures are verified against.
+	identity string
+	issuer   string
+}
+
+// NewSigner returns a Signer signing with the cosign private key in the file
+// key, or keyless if key is KeylessArgument. The password of the key is read
+// by cosign from the COSIGN_PASSWORD environment variable.
+func NewSigner(key string) (*Signer, error) {
+	if key == KeylessArgument {
+		return &Signer{}, nil
+	}
+	if _, err := os.Stat(key); err != nil {
+		return nil, errors.Wrap(err, "sigstore: can not read the cosign key")
+	}
+	return &Signer{key: key}, nil
+}
+
+// NewVerifier returns a Signer verifying signatures with the cosign public
+// key in the file called argument, if there is one. Otherwise argument is the
+// certificate identity, such as an email address, keyless signatures must be
+// made by, with the OIDC issuer taken from OIDCIssuerEnv.
+func NewVerifier(argument string) (*Signer, error) {
+	if _, err := os.Stat(argument); err == nil {
+		return &Signer{key: argument}, nil
+	}
+	issuer := os.Getenv(OIDCIssuerEnv)
+	if issuer == "" {
+		return nil, errors.Errorf("sigstore: %s is neither a cosign public key, nor a"+
+			" certificate identity with the OIDC issuer given in %s", argument, OIDCIssuerEnv)
+	}
+	return &Signer{identity: argument, issuer: issuer}, nil
+}
+
+// Sign signs message with cosign, which uploads the signature to Rekor, and
+// returns the cosign bundle.
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	dir, err := writeMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.RemoveTemp(dir)
+
+	bundle := filepath.Join(dir, "bundle")
+	args := []string{"sign-blob", "--yes", "--bundle", bundle}
+	if s.key != "" {
+		args = append(args, "--key", s.key)
+	}
+	if err = runCosign(append(args, filepath.Join(dir, "message"))...); err != nil {
+		return nil, errors.Wrap(err, "sigstore: can not sign")
+	}
+	sig, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "sigstore: can not read the cosign bundle")
+	}
+	return sig, nil
+}
+
+// Verify verifies the cosign bundle sig of message, including its Rekor
+// entry, and for keyless signatures its Fulcio certificate.
+func (s *Signer) Verify(message, sig []byte) error {
+	if s.key == "" && s.identity == "" {
+		return errors.New("sigstore: no key or identity to verify the signature with")
+	}
+	dir, err := writeMessage(message)
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(dir)
+
+	bundle := filepath.Join(dir, "bundle")
+	if err = ioutil.WriteFile(bundle, sig, 0600); err != nil {
+		return errors.Wrap(err, "sigstore: can not write the cosign bundle")
+	}
+	args := []string{"verify-blob", "--bundle", bundle}
+	if s.key != "" {
+		args = append(args, "--key", s.key)
+	} else {
+		args = append(args, "--certificate-identity", s.identity,
+			"--certificate-oidc-issuer", s.issuer)
+	}
+	if err = runCosign(append(args, filepath.Join(dir, "message"))...); err != nil {
+		return errors.Wrap(err, "sigstore: signature verification failed")
+	}
+	return nil
+}
+
+// writeMessage writes message to a file called "message" in a new temporary
+// directory, which the caller removes.
+func writeMessage(message []byte) (string, error) {
+	dir, err := utils.TempDir("", "sigstore")
+	if err != nil {
+		return "", errors.Wrap(err, "sigstore: can not create temporary directory")
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "message"), message, 0600); err != nil {
+		utils.RemoveTemp(dir)
+		return "", errors.Wrap(err, "sigstore: can not write the message")
+	}
+	return dir, nil
+}
+
+func runCosign(args ...string) error {
+	bin, err := utils.GetBinaryPath(cosignCommand)
+	if err != nil {
+		return errors.New(cosignMissingErr)
+	}
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.Command(bin, args...)
+	// The keyless flow prints the URL to log in with, so the user must see
+	// it.
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	if !strings.HasPrefix(args[0], "sign") {
+		cmd.Stderr = stderr
+	}
+	if err = cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.Wrap(err, msg)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package sigstore
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCosign "signs" with the checksum of the message and the key, and only
+// accepts keyless signatures by dev@example.com.
+const fakeCosign = `#!/bin/sh
+cmd=$1
+shift
+while [ $# -gt 1 ]; do
+	case $1 in
+	--bundle) bundle=$2; shift 2;;
+	--key) key=$2; shift 2;;
+	--certificate-identity) identity=$2; shift 2;;
+	--certificate-oidc-issuer) issuer=$2; shift 2;;
+	--yes) shift;;
+	*) echo "unexpected argument $1" >&2; exit 2;;
+	esac
+done
+sig="$(sha256sum "$1" | cut -d' ' -f1) $key"
+case $cmd in
+sign-blob)
+	echo "$sig" > "$bundle";;
+verify-blob)
+	if [ -z "$key" ] && [ "$identity $issuer" != "dev@example.com https://issuer" ]; then
+		echo "none of the expected identities matched" >&2
+		exit 1
+	fi
+	if [ "$(cat "$bundle")" != "$sig" ]; then
+		echo "invalid signature when validating ASN.1 encoded signature" >&2
+		exit 1
+	fi;;
+esac
+`
+
+func useFakeCosign(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake cosign is a shell script")
+	}
+	bin := filepath.Join(t.TempDir(), "cosign")
+	require.NoError(t, os.WriteFile(bin, []byte(fakeCosign), 0755))
+	saved := cosignCommand
+	cosignCommand = bin
+	t.Cleanup(func() { cosignCommand = saved })
+}
+
+func TestSignVerifyWithKey(t *testing.T) {
+	useFakeCosign(t)
+	dir := t.TempDir()
+	key := filepath.Join(dir, "cosign.key")
+	require.NoError(t, os.WriteFile(key, []byte("key"), 0600))
+
+	signer, err := NewSigner(key)
+	require.NoError(t, err)
+	sig, err := signer.Sign([]byte("manifest"))
+	require.NoError(t, err)
+
+	verifier, err := NewVerifier(key)
+	require.NoError(t, err)
+	assert.NoError(t, verifier.Verify([]byte("manifest"), sig))
+	err = verifier.Verify([]byte("tampered"), sig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid signature")
+
+	_, err = NewSigner(filepath.Join(dir, "missing.key"))
+	assert.Error(t, err)
+}
+
+func TestSignVerifyKeyless(t *testing.T) {
+	useFakeCosign(t)
+
+	signer, err := NewSigner(KeylessArgument)
+	require.NoError(t, err)
+	sig, err := signer.Sign([]byte("manifest"))
+	require.NoError(t, err)
+
+	t.Setenv(OIDCIssuerEnv, "")
+	_, err = NewVerifier("dev@example.com")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), OIDCIssuerEnv)
+
+	t.Setenv(OIDCIssuerEnv, "https://issuer")
+	verifier, err := NewVerifier("dev@example.com")
+	require.NoError(t, err)
+	assert.NoError(t, verifier.Verify([]byte("manifest"), sig))
+
+	verifier, err = NewVerifier("mallory@example.com")
+	require.NoError(t, err)
+	err = verifier.Verify([]byte("manifest"), sig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "none of the expected identities matched")
+
+	// A signer can not verify keyless signatures without an identity.
+	assert.Error(t, signer.Verify([]byte("manifest"), sig))
+}
+
+func TestCosignMissing(t *testing.T) {
+	saved := cosignCommand
+	cosignCommand = "cosign-is-not-installed"
+	defer func() { cosignCommand = saved }()
+
+	signer, err := NewSigner(KeylessArgument)
+	require.NoError(t, err)
+	_, err = signer.Sign([]byte("manifest"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "The `cosign` binary is not found")
+}
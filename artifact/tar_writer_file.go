@@ -18,6 +18,7 @@ import (
 	"archive/tar"
 	"io"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -33,6 +34,38 @@ func NewTarWriterFile(tw *tar.Writer) *FileArchiver {
 	return &w
 }
 
+// reproducibleTime replaces the modification times of all the members of
+// Artifacts, unless it is zero.
+var reproducibleTime time.Time
+
+// SetReproducibleTime makes all the members of the Artifacts written
+// afterwards have the modification time t, and no owner, so that writing
+// the same contents gives identical Artifacts. A zero t turns it off.
+func SetReproducibleTime(t time.Time) {
+	reproducibleTime = t
+}
+
+// ReproducibleTime returns the time set with SetReproducibleTime.
+func ReproducibleTime() time.Time {
+	return reproducibleTime
+}
+
+// SetPAXFormat selects the PAX format for hdr, so that members larger than
+// the 8GiB limit of the ustar size field are stored reliably, whatever the
+// tar writer would otherwise choose. Times are kept at the precision of the
+// default format, so that small members get no extended records.
+func SetPAXFormat(hdr *tar.Header) {
+	hdr.Format = tar.FormatPAX
+	hdr.ModTime = hdr.ModTime.Round(time.Second)
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	if !reproducibleTime.IsZero() {
+		hdr.ModTime = reproducibleTime
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+	}
+}
+
 func (fa *FileArchiver) Write(f *os.File, archivePath string) error {
 	info, err := f.Stat()
 	if err != nil {
@@ -43,6 +76,7 @@ func (fa *FileArchiver) Write(f *os.File, archivePath string) error {
 		return errors.Wrapf(err, "arch: invalid file info header")
 	}
 	hdr.Name = archivePath
+	SetPAXFormat(hdr)
 	if err = fa.Writer.WriteHeader(hdr); err != nil {
 		return errors.Wrapf(err, "arch: error writing header")
 	}
@@ -52,3 +86,33 @@ func (fa *FileArchiver) Write(f *os.File, archivePath string) error {
 	}
 	return nil
 }
+
+// WriteReader archives the size bytes read from r, as a regular file named
+// archivePath. It fails unless r yields exactly size bytes.
+func (fa *FileArchiver) WriteReader(r io.Reader, size int64, modTime time.Time,
+	archivePath string) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archivePath,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	}
+	SetPAXFormat(hdr)
+	if err := fa.Writer.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "arch: error writing header")
+	}
+
+	n, err := io.Copy(fa.Writer, r)
+	if err == tar.ErrWriteTooLong {
+		return errors.Errorf("arch: %s is larger than its size of %d bytes",
+			archivePath, size)
+	} else if err != nil {
+		return errors.Wrapf(err, "arch: can not write %s", archivePath)
+	}
+	if n != size {
+		return errors.Errorf("arch: %s has %d bytes, not its size of %d bytes",
+			archivePath, n, size)
+	}
+	return nil
+}
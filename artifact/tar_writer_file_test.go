@@ -20,9 +20,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTarFile(t *testing.T) {
@@ -65,3 +68,124 @@ func TestTarFile(t *testing.T) {
 		assert.Equal(t, "some data", data.String())
 	}
 }
+
+// headWriter keeps the first bytes written to it and discards the rest.
+type headWriter struct {
+	head  []byte
+	limit int
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if room := w.limit - len(w.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		w.head = append(w.head, p[:room]...)
+	}
+	return len(p), nil
+}
+
+func TestTarFileLarge(t *testing.T) {
+	if os.Getenv("TEST_LARGE_ARTIFACTS") == "" {
+		t.Skip("TEST_LARGE_ARTIFACTS is not set")
+	}
+
+	f, err := ioutil.TempFile("", "test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Larger than the 8GiB limit of the ustar size field; sparse on disk.
+	const size = int64(8<<30) + 1
+	require.NoError(t, f.Truncate(size))
+
+	w := &headWriter{limit: 4096}
+	tw := tar.NewWriter(w)
+	require.NoError(t, NewTarWriterFile(tw).Write(f, "large_file"))
+	require.NoError(t, tw.Close())
+
+	hdr, err := tar.NewReader(bytes.NewReader(w.head)).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "large_file", hdr.Name)
+	assert.Equal(t, size, hdr.Size)
+	assert.Equal(t, tar.FormatPAX, hdr.Format)
+}
+
+func TestTarFileSmallHasNoExtendedHeader(t *testing.T) {
+	f, err := ioutil.TempFile("", "test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.WriteString("some data")
+	require.NoError(t, err)
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	require.NoError(t, NewTarWriterFile(tw).Write(f, "my_file"))
+	require.NoError(t, tw.Close())
+
+	// One header block, one data block and the two terminating blocks; no
+	// extended header for the access and change times.
+	assert.Equal(t, 4*512, buf.Len())
+	hdr, err := tar.NewReader(buf).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "my_file", hdr.Name)
+}
+
+func TestTarFileReader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	fa := NewTarWriterFile(tw)
+
+	require.NoError(t, fa.WriteReader(strings.NewReader("some data"), 9,
+		time.Unix(1000, 0), "my_file"))
+	require.NoError(t, tw.Close())
+
+	tr := tar.NewReader(buf)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "my_file", hdr.Name)
+	assert.Equal(t, int64(9), hdr.Size)
+	data, err := ioutil.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, "some data", string(data))
+
+	fa = NewTarWriterFile(tar.NewWriter(ioutil.Discard))
+	err = fa.WriteReader(strings.NewReader("some data"), 4, time.Now(), "long")
+	assert.Contains(t, err.Error(), "larger than its size")
+
+	fa = NewTarWriterFile(tar.NewWriter(ioutil.Discard))
+	err = fa.WriteReader(strings.NewReader("some data"), 20, time.Now(), "short")
+	assert.Contains(t, err.Error(), "has 9 bytes")
+}
+
+func TestTarFileReproducible(t *testing.T) {
+	f, err := ioutil.TempFile("", "test")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.WriteString("some data")
+	require.NoError(t, err)
+
+	SetReproducibleTime(time.Unix(1700000000, 0))
+	defer SetReproducibleTime(time.Time{})
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
+	require.NoError(t, NewTarWriterFile(tw).Write(f, "my_file"))
+	require.NoError(t, NewTarWriterStream(tw).Write([]byte("{}"), "my_stream"))
+	require.NoError(t, tw.Close())
+
+	tr := tar.NewReader(buf)
+	for _, name := range []string{"my_file", "my_stream"} {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, name, hdr.Name)
+		assert.Equal(t, int64(1700000000), hdr.ModTime.Unix())
+		assert.Zero(t, hdr.Uid)
+		assert.Empty(t, hdr.Uname)
+	}
+}
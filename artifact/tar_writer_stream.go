@@ -51,6 +51,7 @@ func (str *StreamArchiver) Write(data []byte, archivePath string) error {
 		Mode: 0600,
 		Size: int64(len(data)),
 	}
+	SetPAXFormat(hdr)
 	if err := str.Writer.WriteHeader(hdr); err != nil {
 		return errors.Wrapf(err, "arch: can not write stream header")
 	}
@@ -0,0 +1,123 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// VerityBlockSize is the data and hash block size of the hash trees.
+	VerityBlockSize = 4096
+	// VeritySaltSize is the recommended salt size.
+	VeritySaltSize = 32
+)
+
+// VerityHashTree is a dm-verity (format version 1, sha256) hash tree. The tree
+// is stored without a superblock, so the parameters must be passed to
+// veritysetup explicitly.
+type VerityHashTree struct {
+	RootHash   []byte
+	Salt       []byte
+	DataBlocks int64
+
+	// levels[0] holds the hashes of the data blocks, each following level
+	// the hashes of the blocks of the previous one.
+	levels [][]byte
+}
+
+func verityHash(salt, block []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+// verityHashLevel returns the hashes of all blocks in data, zero padded to a
+// whole number of blocks.
+func verityHashLevel(salt, data []byte) []byte {
+	var level []byte
+	for i := 0; i < len(data); i += VerityBlockSize {
+		level = append(level, verityHash(salt, data[i:i+VerityBlockSize])...)
+	}
+	return verityPad(level)
+}
+
+func verityPad(level []byte) []byte {
+	if rem := len(level) % VerityBlockSize; rem != 0 {
+		level = append(level, make([]byte, VerityBlockSize-rem)...)
+	}
+	return level
+}
+
+// NewVerityHashTree computes the hash tree of the data read from r, which
+// must be a whole number of blocks.
+func NewVerityHashTree(r io.Reader, salt []byte) (*VerityHashTree, error) {
+	t := &VerityHashTree{Salt: salt}
+
+	var level []byte
+	block := make([]byte, VerityBlockSize)
+	for {
+		_, err := io.ReadFull(r, block)
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			return nil, errors.Errorf(
+				"verity: data size is not a multiple of %d bytes", VerityBlockSize)
+		} else if err != nil {
+			return nil, errors.Wrap(err, "verity: can not read data")
+		}
+		level = append(level, verityHash(salt, block)...)
+		t.DataBlocks++
+	}
+	if t.DataBlocks == 0 {
+		return nil, errors.New("verity: no data to hash")
+	}
+
+	level = verityPad(level)
+	t.levels = append(t.levels, level)
+	for len(level) > VerityBlockSize {
+		level = verityHashLevel(salt, level)
+		t.levels = append(t.levels, level)
+	}
+	t.RootHash = verityHash(salt, level)
+	return t, nil
+}
+
+// Size returns the size of the hash tree in bytes.
+func (t *VerityHashTree) Size() int64 {
+	var size int64
+	for _, level := range t.levels {
+		size += int64(len(level))
+	}
+	return size
+}
+
+// WriteTo writes the hash tree in the on-disk layout used by dm-verity, with
+// the top level first.
+func (t *VerityHashTree) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for i := len(t.levels) - 1; i >= 0; i-- {
+		n, err := w.Write(t.levels[i])
+		written += int64(n)
+		if err != nil {
+			return written, errors.Wrap(err, "verity: can not write hash tree")
+		}
+	}
+	return written, nil
+}
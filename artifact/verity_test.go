@@ -0,0 +1,86 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package artifact
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func saltedHash(salt, data []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), data...))
+	return sum[:]
+}
+
+func TestVerityHashTreeSingleBlock(t *testing.T) {
+	salt := []byte("salt")
+	data := bytes.Repeat([]byte{0xaa}, VerityBlockSize)
+
+	tree, err := NewVerityHashTree(bytes.NewReader(data), salt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tree.DataBlocks)
+	assert.Equal(t, int64(VerityBlockSize), tree.Size())
+
+	hashBlock := make([]byte, VerityBlockSize)
+	copy(hashBlock, saltedHash(salt, data))
+	assert.Equal(t, saltedHash(salt, hashBlock), tree.RootHash)
+
+	buf := bytes.NewBuffer(nil)
+	n, err := tree.WriteTo(buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(VerityBlockSize), n)
+	assert.Equal(t, hashBlock, buf.Bytes())
+}
+
+func TestVerityHashTreeLevels(t *testing.T) {
+	salt := []byte("salt")
+	// One more data block than fits in a single hash block, so two levels
+	// are needed.
+	hashesPerBlock := VerityBlockSize / sha256.Size
+	data := make([]byte, (hashesPerBlock+1)*VerityBlockSize)
+	for i := range data {
+		data[i] = byte(i / VerityBlockSize)
+	}
+
+	tree, err := NewVerityHashTree(bytes.NewReader(data), salt)
+	require.NoError(t, err)
+	assert.Equal(t, int64(hashesPerBlock+1), tree.DataBlocks)
+	assert.Equal(t, int64(3*VerityBlockSize), tree.Size())
+
+	buf := bytes.NewBuffer(nil)
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+	written := buf.Bytes()
+
+	// The top level comes first, and hashes the two blocks of the bottom
+	// level.
+	top, bottom := written[:VerityBlockSize], written[VerityBlockSize:]
+	assert.Equal(t, saltedHash(salt, data[:VerityBlockSize]), bottom[:sha256.Size])
+	assert.Equal(t, saltedHash(salt, bottom[:VerityBlockSize]), top[:sha256.Size])
+	assert.Equal(t, saltedHash(salt, bottom[VerityBlockSize:]), top[sha256.Size:2*sha256.Size])
+	assert.Equal(t, saltedHash(salt, top), tree.RootHash)
+}
+
+func TestVerityHashTreeErrors(t *testing.T) {
+	_, err := NewVerityHashTree(bytes.NewReader(make([]byte, 100)), nil)
+	assert.Contains(t, err.Error(), "not a multiple of 4096 bytes")
+
+	_, err = NewVerityHashTree(bytes.NewReader(nil), nil)
+	assert.Contains(t, err.Error(), "no data to hash")
+}
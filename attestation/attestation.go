@@ -0,0 +1,250 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package attestation verifies attestations of the Artifact installed on a
+// device, made with the TPM of the device. The device quotes its PCRs with
+// the digest of its claims, which are the provides of the installed
+// Artifact, as qualifying data. Verifying the quote with the attestation key
+// of the device, and the claims against the Artifact, proves that the device
+// runs that Artifact.
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// Claims are what the device attests to.
+type Claims struct {
+	// Provides are the provides of the installed Artifact, as stored by
+	// the device, including artifact_name.
+	Provides map[string]string `json:"provides"`
+	// Nonce is the challenge of the verifier, if any, which shows that the
+	// attestation is fresh.
+	Nonce string `json:"nonce,omitempty"`
+}
+
+// Digest returns the SHA256 of the JSON encoding of the claims, which is the
+// qualifying data of the quote.
+func (c *Claims) Digest() ([]byte, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation: can not encode claims")
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// Attestation is the attestation file produced by the device.
+type Attestation struct {
+	Claims
+	// Quote is the TPMS_ATTEST structure returned by TPM2_Quote.
+	Quote []byte `json:"quote"`
+	// Signature is the signature of the quote by the attestation key, in
+	// PKCS #1 v1.5 form for RSA keys, and ASN.1 or r||s form for ECDSA
+	// keys. The hash is SHA256.
+	Signature []byte `json:"signature"`
+	// PCRs are the hex encoded values of the quoted PCRs of the SHA256
+	// bank, by PCR index. They are optional, but when given they must match
+	// the quote.
+	PCRs map[string]string `json:"pcrs,omitempty"`
+}
+
+// Parse reads an attestation file.
+func Parse(r io.Reader) (*Attestation, error) {
+	var a Attestation
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&a); err != nil {
+		return nil, errors.Wrap(err, "attestation: can not parse")
+	}
+	return &a, nil
+}
+
+// Reference is what devices running an Artifact attest to.
+type Reference struct {
+	// Provides are the merged provides of the Artifact.
+	Provides map[string]string
+	// Checksums are the keys of the provides which hold checksums of the
+	// payload. Devices must attest to all of them.
+	Checksums []string
+}
+
+// NewReference returns the Reference of the Artifact read by ar, which must
+// have been read completely. Checksum provides must match the checksum of a
+// payload file in the manifest.
+func NewReference(ar *areader.Reader) (*Reference, error) {
+	provides, err := ar.MergeArtifactProvides()
+	if err != nil {
+		return nil, errors.Wrap(err, "attestation")
+	}
+	if provides == nil {
+		return nil, errors.New("attestation: Artifacts before version 3 have no provides")
+	}
+	manifest := map[string]bool{}
+	for _, handler := range ar.GetHandlers() {
+		for _, file := range handler.GetUpdateAllFiles() {
+			manifest[string(file.Checksum)] = true
+		}
+	}
+	ref := &Reference{Provides: provides}
+	for key, value := range provides {
+		if !strings.HasSuffix(key, ".checksum") && key != artifact.LegacyRootfsImageChecksumKey {
+			continue
+		}
+		if !manifest[value] {
+			return nil, errors.Errorf(
+				"attestation: provide %s is not the checksum of a payload file", key)
+		}
+		ref.Checksums = append(ref.Checksums, key)
+	}
+	sort.Strings(ref.Checksums)
+	return ref, nil
+}
+
+// Verify checks that the quote of a is signed by key, that it quotes the
+// claims of a, and that the claims match ref. If nonce is not empty, the
+// claims must hold it.
+func (a *Attestation) Verify(key crypto.PublicKey, ref *Reference, nonce string) error {
+	if err := verifySignature(key, a.Quote, a.Signature); err != nil {
+		return err
+	}
+	quote, err := ParseQuote(a.Quote)
+	if err != nil {
+		return errors.Wrap(err, "attestation")
+	}
+	digest, err := a.Claims.Digest()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(quote.ExtraData, digest) {
+		return errors.New("attestation: the quote is not of the claims")
+	}
+	if nonce != "" && a.Nonce != nonce {
+		return errors.New("attestation: the nonce does not match")
+	}
+	if a.PCRs != nil {
+		if err := a.verifyPCRs(quote); err != nil {
+			return err
+		}
+	}
+	return a.verifyClaims(ref)
+}
+
+func (a *Attestation) verifyClaims(ref *Reference) error {
+	if len(a.Provides) == 0 {
+		return errors.New("attestation: no provides claimed")
+	}
+	if a.Provides["artifact_name"] != ref.Provides["artifact_name"] {
+		return errors.Errorf("attestation: the device runs %q, not %q",
+			a.Provides["artifact_name"], ref.Provides["artifact_name"])
+	}
+	for key, value := range a.Provides {
+		expected, ok := ref.Provides[key]
+		if !ok {
+			return errors.Errorf("attestation: the Artifact does not provide %s", key)
+		}
+		if value != expected {
+			return errors.Errorf("attestation: the device has %s %q, not %q",
+				key, value, expected)
+		}
+	}
+	for _, key := range ref.Checksums {
+		if _, ok := a.Provides[key]; !ok {
+			return errors.Errorf("attestation: the checksum %s is not claimed", key)
+		}
+	}
+	return nil
+}
+
+// verifyPCRs checks that the PCR values of a are the ones quoted.
+func (a *Attestation) verifyPCRs(quote *Quote) error {
+	var indexes []int
+	values := map[int][]byte{}
+	for index, value := range a.PCRs {
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return errors.Errorf("attestation: invalid PCR index %q", index)
+		}
+		data, err := hex.DecodeString(value)
+		if err != nil || len(data) != sha256.Size {
+			return errors.Errorf("attestation: invalid SHA256 value of PCR %d", i)
+		}
+		indexes = append(indexes, i)
+		values[i] = data
+	}
+	sort.Ints(indexes)
+
+	if len(quote.PCRSelections) != 1 || quote.PCRSelections[0].Hash != TPMAlgSHA256 {
+		return errors.New("attestation: the quote must select PCRs of the SHA256 bank only")
+	}
+	selected := quote.PCRSelections[0].PCRs
+	if len(selected) != len(indexes) {
+		return errors.Errorf("attestation: the quote selects PCRs %v, not %v",
+			selected, indexes)
+	}
+	h := sha256.New()
+	for i, pcr := range selected {
+		if pcr != indexes[i] {
+			return errors.Errorf("attestation: the quote selects PCRs %v, not %v",
+				selected, indexes)
+		}
+		h.Write(values[pcr])
+	}
+	if !bytes.Equal(h.Sum(nil), quote.PCRDigest) {
+		return errors.New("attestation: the PCR values do not match the quote")
+	}
+	return nil
+}
+
+func verifySignature(key crypto.PublicKey, message, sig []byte) error {
+	digest := sha256.Sum256(message)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.Wrap(err, "attestation: invalid signature of the quote")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(key, digest[:], r, s) {
+				return nil
+			}
+		}
+		return errors.New("attestation: invalid signature of the quote")
+	default:
+		return errors.Errorf("attestation: unsupported key type %T", key)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testReference = &Reference{
+	Provides: map[string]string{
+		"artifact_name":         "release-1",
+		"rootfs-image.version":  "release-1",
+		"rootfs-image.checksum": strings.Repeat("ab", 32),
+	},
+	Checksums: []string{"rootfs-image.checksum"},
+}
+
+func pcrDigest(values ...[]byte) []byte {
+	h := sha256.New()
+	for _, v := range values {
+		h.Write(v)
+	}
+	return h.Sum(nil)
+}
+
+// makeAttestation makes an attestation of claims, like a device would,
+// signed with key.
+func makeAttestation(t *testing.T, key interface{}, claims Claims) *Attestation {
+	digest, err := claims.Digest()
+	require.NoError(t, err)
+	pcr0 := sha256.Sum256([]byte("pcr0"))
+	pcr7 := sha256.Sum256([]byte("pcr7"))
+	quote := (&Quote{
+		QualifiedSigner: []byte("signer"),
+		ExtraData:       digest,
+		Clock:           1000,
+		PCRSelections:   []PCRSelection{{Hash: TPMAlgSHA256, PCRs: []int{0, 7}}},
+		PCRDigest:       pcrDigest(pcr0[:], pcr7[:]),
+	}).Marshal()
+
+	hashed := sha256.Sum256(quote)
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, 5, hashed[:])
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	}
+	require.NoError(t, err)
+
+	return &Attestation{
+		Claims:    claims,
+		Quote:     quote,
+		Signature: sig,
+		PCRs: map[string]string{
+			"0": hex.EncodeToString(pcr0[:]),
+			"7": hex.EncodeToString(pcr7[:]),
+		},
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	q := &Quote{
+		QualifiedSigner: []byte{1, 2, 3},
+		ExtraData:       []byte("extra"),
+		Clock:           42,
+		ResetCount:      1,
+		RestartCount:    2,
+		Safe:            true,
+		FirmwareVersion: 7,
+		PCRSelections: []PCRSelection{
+			{Hash: TPMAlgSHA256, PCRs: []int{0, 1, 23}},
+			{Hash: 0x0004, PCRs: []int{31}},
+		},
+		PCRDigest: []byte("digest"),
+	}
+	parsed, err := ParseQuote(q.Marshal())
+	require.NoError(t, err)
+	assert.Equal(t, q, parsed)
+
+	_, err = ParseQuote(q.Marshal()[:20])
+	assert.Error(t, err)
+	_, err = ParseQuote(append(q.Marshal(), 0))
+	assert.EqualError(t, err, "quote: 1 bytes of trailing data")
+	bad := q.Marshal()
+	bad[0] = 0
+	_, err = ParseQuote(bad)
+	assert.EqualError(t, err, "quote: invalid magic value 0x00544347")
+}
+
+func TestVerify(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	claims := Claims{Provides: testReference.Provides, Nonce: "challenge"}
+
+	a := makeAttestation(t, ecKey, claims)
+	assert.NoError(t, a.Verify(&ecKey.PublicKey, testReference, "challenge"))
+	assert.NoError(t, a.Verify(&ecKey.PublicKey, testReference, ""))
+	assert.EqualError(t, a.Verify(&ecKey.PublicKey, testReference, "other"),
+		"attestation: the nonce does not match")
+	assert.EqualError(t, a.Verify(&rsaKey.PublicKey, testReference, ""),
+		"attestation: invalid signature of the quote: crypto/rsa: verification error")
+
+	// Raw r||s ECDSA signatures are accepted too.
+	hashed := sha256.Sum256(a.Quote)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, hashed[:])
+	require.NoError(t, err)
+	a.Signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	assert.NoError(t, a.Verify(&ecKey.PublicKey, testReference, ""))
+	a.Signature = append(big.NewInt(1).FillBytes(make([]byte, 32)), a.Signature[32:]...)
+	assert.EqualError(t, a.Verify(&ecKey.PublicKey, testReference, ""),
+		"attestation: invalid signature of the quote")
+
+	a = makeAttestation(t, rsaKey, claims)
+	assert.NoError(t, a.Verify(&rsaKey.PublicKey, testReference, ""))
+
+	// Claims which were not quoted.
+	a.Provides = map[string]string{"artifact_name": "release-2"}
+	assert.EqualError(t, a.Verify(&rsaKey.PublicKey, testReference, ""),
+		"attestation: the quote is not of the claims")
+
+	// PCR values which were not quoted.
+	a = makeAttestation(t, rsaKey, claims)
+	a.PCRs["7"] = strings.Repeat("00", 32)
+	assert.EqualError(t, a.Verify(&rsaKey.PublicKey, testReference, ""),
+		"attestation: the PCR values do not match the quote")
+	delete(a.PCRs, "7")
+	assert.EqualError(t, a.Verify(&rsaKey.PublicKey, testReference, ""),
+		"attestation: the quote selects PCRs [0 7], not [0]")
+	a.PCRs = nil
+	assert.NoError(t, a.Verify(&rsaKey.PublicKey, testReference, ""))
+}
+
+func TestVerifyClaims(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		provides map[string]string
+		err      string
+	}{
+		"other artifact": {
+			provides: map[string]string{"artifact_name": "release-2"},
+			err:      `attestation: the device runs "release-2", not "release-1"`,
+		},
+		"other checksum": {
+			provides: map[string]string{
+				"artifact_name":         "release-1",
+				"rootfs-image.checksum": strings.Repeat("cd", 32),
+			},
+			err: `attestation: the device has rootfs-image.checksum "cdcd`,
+		},
+		"checksum not claimed": {
+			provides: map[string]string{"artifact_name": "release-1"},
+			err:      "attestation: the checksum rootfs-image.checksum is not claimed",
+		},
+		"unknown provide": {
+			provides: map[string]string{
+				"artifact_name":         "release-1",
+				"rootfs-image.checksum": strings.Repeat("ab", 32),
+				"other":                 "value",
+			},
+			err: "attestation: the Artifact does not provide other",
+		},
+		"nothing": {
+			err: "attestation: no provides claimed",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			a := makeAttestation(t, key, Claims{Provides: test.provides})
+			err := a.Verify(&key.PublicKey, testReference, "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	a, err := Parse(strings.NewReader(`{
+		"provides": {"artifact_name": "release-1"},
+		"quote": "AQID",
+		"signature": "BAUG",
+		"pcrs": {"0": "00"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "release-1", a.Provides["artifact_name"])
+	assert.Equal(t, []byte{1, 2, 3}, a.Quote)
+	assert.Equal(t, []byte{4, 5, 6}, a.Signature)
+
+	_, err = Parse(strings.NewReader(`{"provide": {}}`))
+	assert.Error(t, err)
+}
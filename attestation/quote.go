@@ -0,0 +1,181 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package attestation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Constants of the TPM 2.0 specification, part 2.
+const (
+	tpmGeneratedValue = 0xff544347
+	tpmStAttestQuote  = 0x8018
+	// TPMAlgSHA256 is the algorithm ID of SHA256, the only PCR bank the
+	// verifier supports.
+	TPMAlgSHA256 = 0x000b
+)
+
+// PCRSelection is the selection of PCRs of one bank in a quote.
+type PCRSelection struct {
+	Hash uint16
+	PCRs []int
+}
+
+// Quote is the TPMS_ATTEST structure signed by the TPM in TPM2_Quote.
+type Quote struct {
+	QualifiedSigner []byte
+	// ExtraData is the qualifying data the caller passed to the TPM.
+	ExtraData       []byte
+	Clock           uint64
+	ResetCount      uint32
+	RestartCount    uint32
+	Safe            bool
+	FirmwareVersion uint64
+	PCRSelections   []PCRSelection
+	// PCRDigest is the digest of the values of the selected PCRs.
+	PCRDigest []byte
+}
+
+// ParseQuote parses the TPMS_ATTEST structure of a quote.
+func ParseQuote(data []byte) (*Quote, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Magic uint32
+		Type  uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, errors.Wrap(err, "quote: can not read header")
+	}
+	if header.Magic != tpmGeneratedValue {
+		return nil, errors.Errorf("quote: invalid magic value 0x%08x", header.Magic)
+	}
+	if header.Type != tpmStAttestQuote {
+		return nil, errors.Errorf("quote: attestation type 0x%04x is not a quote", header.Type)
+	}
+
+	q := &Quote{}
+	var err error
+	if q.QualifiedSigner, err = readSized(r); err != nil {
+		return nil, errors.Wrap(err, "quote: can not read qualified signer")
+	}
+	if q.ExtraData, err = readSized(r); err != nil {
+		return nil, errors.Wrap(err, "quote: can not read extra data")
+	}
+	var info struct {
+		Clock           uint64
+		ResetCount      uint32
+		RestartCount    uint32
+		Safe            uint8
+		FirmwareVersion uint64
+		SelectionCount  uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &info); err != nil {
+		return nil, errors.Wrap(err, "quote: can not read clock info")
+	}
+	q.Clock = info.Clock
+	q.ResetCount = info.ResetCount
+	q.RestartCount = info.RestartCount
+	q.Safe = info.Safe != 0
+	q.FirmwareVersion = info.FirmwareVersion
+
+	if info.SelectionCount > 16 {
+		return nil, errors.Errorf("quote: too many PCR selections: %d", info.SelectionCount)
+	}
+	for i := uint32(0); i < info.SelectionCount; i++ {
+		var sel struct {
+			Hash uint16
+			Size uint8
+		}
+		if err := binary.Read(r, binary.BigEndian, &sel); err != nil {
+			return nil, errors.Wrap(err, "quote: can not read PCR selection")
+		}
+		bitmap := make([]byte, sel.Size)
+		if _, err := io.ReadFull(r, bitmap); err != nil {
+			return nil, errors.Wrap(err, "quote: can not read PCR selection")
+		}
+		selection := PCRSelection{Hash: sel.Hash}
+		for pcr := 0; pcr < len(bitmap)*8; pcr++ {
+			if bitmap[pcr/8]&(1<<(pcr%8)) != 0 {
+				selection.PCRs = append(selection.PCRs, pcr)
+			}
+		}
+		q.PCRSelections = append(q.PCRSelections, selection)
+	}
+	if q.PCRDigest, err = readSized(r); err != nil {
+		return nil, errors.Wrap(err, "quote: can not read PCR digest")
+	}
+	if r.Len() != 0 {
+		return nil, errors.Errorf("quote: %d bytes of trailing data", r.Len())
+	}
+	return q, nil
+}
+
+// Marshal returns the TPMS_ATTEST structure of q.
+func (q *Quote) Marshal() []byte {
+	var buf bytes.Buffer
+	w := func(v interface{}) {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+	w(uint32(tpmGeneratedValue))
+	w(uint16(tpmStAttestQuote))
+	w(uint16(len(q.QualifiedSigner)))
+	buf.Write(q.QualifiedSigner)
+	w(uint16(len(q.ExtraData)))
+	buf.Write(q.ExtraData)
+	w(q.Clock)
+	w(q.ResetCount)
+	w(q.RestartCount)
+	if q.Safe {
+		w(uint8(1))
+	} else {
+		w(uint8(0))
+	}
+	w(q.FirmwareVersion)
+	w(uint32(len(q.PCRSelections)))
+	for _, sel := range q.PCRSelections {
+		// At least three bytes, as TPMs have at least 24 PCRs.
+		bitmap := make([]byte, 3)
+		for _, pcr := range sel.PCRs {
+			for pcr/8 >= len(bitmap) {
+				bitmap = append(bitmap, 0)
+			}
+			bitmap[pcr/8] |= 1 << (pcr % 8)
+		}
+		w(sel.Hash)
+		w(uint8(len(bitmap)))
+		buf.Write(bitmap)
+	}
+	w(uint16(len(q.PCRDigest)))
+	buf.Write(q.PCRDigest)
+	return buf.Bytes()
+}
+
+// readSized reads a TPM2B structure: a 16 bit size followed by the data.
+func readSized(r *bytes.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if int(size) > r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
@@ -0,0 +1,338 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package avalidate validates Artifacts like the validate command of
+// mender-artifact: it checks the structure of the Artifact, its signature
+// and the checksum of every file of its manifest, and reports the results of
+// all the checks, instead of stopping at the first problem as a plain
+// areader.Reader does.
+package avalidate
+
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+// The states of the signature of an Artifact in a Report.
+const (
+	// SignatureNone is an unsigned Artifact validated without a key.
+	SignatureNone = "none"
+	// SignatureMissing is an unsigned Artifact validated with a key.
+	SignatureMissing = "missing"
+	// SignatureUnverified is a signed Artifact validated without a key.
+	SignatureUnverified = "unverified"
+	// SignatureVerified is a signed Artifact which the key verifies.
+	SignatureVerified = "verified"
+	// SignatureInvalid is a signed Artifact which the key does not verify.
+	SignatureInvalid = "invalid"
+)
+
+// The states of the files of the manifest in a Report.
+const (
+	FileOK = "ok"
+	// FileFailed is a file which is missing, or does not match its
+	// checksum.
+	FileFailed = "failed"
+	// FileUnchecked is a file after one which could not be read.
+	FileUnchecked = "unchecked"
+)
+
+// Report is the result of validating an Artifact.
+type Report struct {
+	Valid     bool                   `json:"valid"`
+	Errors    []string               `json:"errors,omitempty"`
+	Signature string                 `json:"signature"`
+	Name      string                 `json:"name,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Provides  map[string]string      `json:"provides,omitempty"`
+	Depends   map[string]interface{} `json:"depends,omitempty"`
+	Files     []File                 `json:"files"`
+	Payloads  []Payload              `json:"payloads"`
+	// UnknownHandlers are the payload types which neither a handler given
+	// with WithHandlers nor the reader itself handles. Their payloads are
+	// validated as update module payloads.
+	UnknownHandlers []string `json:"unknown_handlers,omitempty"`
+
+	// Reader is the reader the Artifact was read with, to inspect the
+	// validated headers further, or nil if the headers could not be read.
+	Reader *areader.Reader `json:"-"`
+
+	err error
+}
+
+// File is the result of checking one file of the manifest.
+type File struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	// ChecksumMismatch is true if the file failed as its contents do not
+	// match its checksum.
+	ChecksumMismatch bool `json:"checksum_mismatch,omitempty"`
+}
+
+// Payload is the type, provides and depends of a payload.
+type Payload struct {
+	Type     string                    `json:"type"`
+	Provides artifact.TypeInfoProvides `json:"provides,omitempty"`
+	Depends  artifact.TypeInfoDepends  `json:"depends,omitempty"`
+}
+
+// Fail marks the Artifact as invalid because of err, for checks done on top
+// of Validate.
+func (r *Report) Fail(err error) {
+	r.Valid = false
+	r.Errors = append(r.Errors, err.Error())
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first problem found, or nil if the Artifact is valid.
+func (r *Report) Err() error {
+	return r.err
+}
+
+// ChecksumMismatches returns the names of the files which do not match
+// their checksums.
+func (r *Report) ChecksumMismatches() []string {
+	var names []string
+	for _, f := range r.Files {
+		if f.ChecksumMismatch {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+type options struct {
+	key                   artifact.Verifier
+	handlers              []handlers.Installer
+	forbidUnknownHandlers bool
+	readBufferSize        int
+	readAhead             int
+	checksumWorkers       int
+	progress              areader.ProgressFn
+}
+
+// Option configures Validate.
+type Option func(*options)
+
+// WithVerifier verifies the signature of the Artifact with key. Without it,
+// signed Artifacts are invalid, as they can not be verified.
+func WithVerifier(key artifact.Verifier) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// WithHandlers registers the handlers of the payload types the device
+// handles, see areader.Reader.RegisterHandler.
+func WithHandlers(handlers ...handlers.Installer) Option {
+	return func(o *options) {
+		o.handlers = append(o.handlers, handlers...)
+	}
+}
+
+// WithForbidUnknownHandlers makes the Artifacts with payloads of unknown
+// types invalid, as the client does with the ones it can not install.
+func WithForbidUnknownHandlers() Option {
+	return func(o *options) {
+		o.forbidUnknownHandlers = true
+	}
+}
+
+// WithReadBufferSize reads the Artifact in chunks of size bytes, see
+// areader.Reader.ReadBufferSize.
+func WithReadBufferSize(size int) Option {
+	return func(o *options) {
+		o.readBufferSize = size
+	}
+}
+
+// WithReadAhead reads the Artifact ahead into buffers buffers, see
+// areader.Reader.ReadAheadBuffers.
+func WithReadAhead(buffers int) Option {
+	return func(o *options) {
+		o.readAhead = buffers
+	}
+}
+
+// WithChecksumWorkers hashes workers payload files at once, see
+// areader.Reader.ChecksumWorkers.
+func WithChecksumWorkers(workers int) Option {
+	return func(o *options) {
+		o.checksumWorkers = workers
+	}
+}
+
+// WithProgress calls progress as the Artifact is read.
+func WithProgress(progress areader.ProgressFn) Option {
+	return func(o *options) {
+		o.progress = progress
+	}
+}
+
+// Validate reads the Artifact from r, and reports whether it is valid. It
+// goes on past damaged payload files, so that the result of every file of
+// the manifest is reported. The error is the first problem found, as
+// returned by Report.Err, so that callers which only need to know whether
+// the Artifact is valid can ignore the report.
+func Validate(r io.Reader, opts ...Option) (Report, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	report := Report{
+		Valid:     true,
+		Signature: SignatureNone,
+		Files:     []File{},
+		Payloads:  []Payload{},
+	}
+
+	verified := false
+	var sigErr error
+	ar := areader.NewReader(r)
+	ar.ReadBufferSize = o.readBufferSize
+	ar.ReadAheadBuffers = o.readAhead
+	ar.ChecksumWorkers = o.checksumWorkers
+	ar.StageProgressCallback = o.progress
+	ar.VerifySignatureCallback = func(message, sig []byte) error {
+		if o.key == nil || verified {
+			return nil
+		}
+		sigErr = o.key.Verify(message, sig)
+		verified = sigErr == nil
+		return nil
+	}
+	known := map[string]bool{"rootfs-image": true}
+	for _, handler := range o.handlers {
+		if err := ar.RegisterHandler(handler); err != nil {
+			return report, errors.Wrap(err, "can not register the payload handlers")
+		}
+		known[*handler.GetUpdateType()] = true
+	}
+	damaged := map[string]error{}
+	ar.DamageCallback = func(name string, err error) error {
+		damaged[name] = err
+		return nil
+	}
+
+	if err := ar.ReadArtifactHeaders(); err != nil {
+		report.Fail(err)
+		return report, report.Err()
+	}
+	report.Name = ar.GetArtifactName()
+	report.Version = ar.GetInfo().Version
+	report.UnknownHandlers = unknownHandlers(ar, known)
+	dataErr := ar.ReadArtifactData()
+
+	switch {
+	case !ar.IsSigned && o.key != nil:
+		report.Signature = SignatureMissing
+		report.Fail(errors.New("missing signature"))
+	case !ar.IsSigned:
+	case o.key == nil:
+		report.Signature = SignatureUnverified
+		report.Fail(errors.New("missing verifier"))
+	case verified:
+		report.Signature = SignatureVerified
+	default:
+		report.Signature = SignatureInvalid
+		report.Fail(sigErr)
+	}
+	if o.forbidUnknownHandlers {
+		for _, updateType := range report.UnknownHandlers {
+			report.Fail(errors.Errorf("Artifact Payload type '%s' is not supported",
+				updateType))
+		}
+	}
+
+	// Files after the one which failed the read were not checked.
+	for _, line := range strings.Split(string(ar.Manifest()), "\n") {
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		file := File{Name: fields[1], Checksum: fields[0], Status: FileOK}
+		if err, ok := damaged[file.Name]; ok {
+			var mismatch *artifact.ErrChecksumMismatch
+			file.Status = FileFailed
+			file.Error = err.Error()
+			file.ChecksumMismatch = errors.As(err, &mismatch)
+			report.Fail(errors.Wrap(err, file.Name))
+		} else if dataErr != nil {
+			file.Status = FileUnchecked
+		}
+		report.Files = append(report.Files, file)
+	}
+	if dataErr != nil {
+		report.Fail(dataErr)
+		return report, report.Err()
+	}
+	report.Reader = ar
+
+	var err error
+	if report.Provides, err = ar.MergeArtifactProvides(); err != nil {
+		report.Fail(err)
+	}
+	if report.Depends, err = ar.MergeArtifactDepends(); err != nil {
+		report.Fail(err)
+	}
+	inst := ar.GetHandlers()
+	for i := 0; i < len(inst); i++ {
+		p, ok := inst[i]
+		if !ok {
+			report.Fail(errors.Errorf("payload %04d is missing", i))
+			continue
+		}
+		payload := Payload{}
+		if updateType := p.GetUpdateType(); updateType != nil {
+			payload.Type = *updateType
+		}
+		if payload.Provides, err = p.GetUpdateProvides(); err != nil {
+			report.Fail(errors.Wrapf(err, "payload %04d", i))
+		}
+		if payload.Depends, err = p.GetUpdateDepends(); err != nil {
+			report.Fail(errors.Wrapf(err, "payload %04d", i))
+		}
+		report.Payloads = append(report.Payloads, payload)
+	}
+	return report, report.Err()
+}
+
+// unknownHandlers returns the payload types of the Artifact read by ar which
+// are not known, in the order of the payloads.
+func unknownHandlers(ar *areader.Reader, known map[string]bool) []string {
+	var unknown []string
+	inst := ar.GetHandlers()
+	for i := 0; i < len(inst); i++ {
+		p, ok := inst[i]
+		if !ok || p.GetUpdateType() == nil {
+			continue
+		}
+		updateType := *p.GetUpdateType()
+		if !known[updateType] {
+			unknown = append(unknown, updateType)
+			known[updateType] = true
+		}
+	}
+	return unknown
+}
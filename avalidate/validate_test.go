@@ -0,0 +1,201 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package avalidate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+func writeTestArtifact(t *testing.T, signer artifact.Signer) []byte {
+	u := handlers.NewModuleImage("test-type")
+	require.NoError(t, u.SetUpdateFiles([]*handlers.DataFile{
+		{Name: "file", Reader: strings.NewReader("update"), Size: int64(len("update"))},
+	}))
+	buf := bytes.NewBuffer(nil)
+	aw := awriter.NewWriter(buf, artifact.NewCompressorGzip())
+	if signer != nil {
+		aw = awriter.NewWriterSigned(buf, artifact.NewCompressorGzip(), signer)
+	}
+	err := aw.WriteArtifact(&awriter.WriteArtifactArgs{
+		Format:     "mender",
+		Version:    3,
+		Devices:    []string{"vexpress"},
+		Name:       "release-1",
+		Updates:    &awriter.Updates{Updates: []handlers.Composer{u}},
+		Provides:   &artifact.ArtifactProvides{ArtifactName: "release-1"},
+		Depends:    &artifact.ArtifactDepends{CompatibleDevices: []string{"vexpress"}},
+		TypeInfoV3: &artifact.TypeInfoV3{Type: u.GetUpdateType()},
+	})
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func makeTestKeys(t *testing.T) (*artifact.PKISigner, *artifact.PKISigner) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	signer, err := artifact.NewPKISigner(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	require.NoError(t, err)
+	verifier, err := artifact.NewPKIVerifier(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	require.NoError(t, err)
+	return signer, verifier
+}
+
+func TestValidate(t *testing.T) {
+	art := writeTestArtifact(t, nil)
+	report, err := Validate(bytes.NewReader(art))
+	require.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, SignatureNone, report.Signature)
+	assert.Equal(t, "release-1", report.Name)
+	assert.Equal(t, 3, report.Version)
+	assert.Equal(t, "release-1", report.Provides["artifact_name"])
+	require.Len(t, report.Files, 3)
+	for _, f := range report.Files {
+		assert.Equal(t, FileOK, f.Status, f.Name)
+	}
+	require.Len(t, report.Payloads, 1)
+	assert.Equal(t, "test-type", report.Payloads[0].Type)
+	assert.Equal(t, []string{"test-type"}, report.UnknownHandlers)
+	assert.Empty(t, report.ChecksumMismatches())
+	require.NotNil(t, report.Reader)
+	assert.Equal(t, "release-1", report.Reader.GetArtifactName())
+
+	// Broken headers leave little to report.
+	report, err = Validate(bytes.NewReader(art[:len(art)/2]))
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, err.Error(), report.Errors[0])
+	assert.Nil(t, report.Reader)
+
+	report, err = Validate(strings.NewReader("not an Artifact"))
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	assert.Empty(t, report.Files)
+}
+
+func TestValidateHandlers(t *testing.T) {
+	art := writeTestArtifact(t, nil)
+
+	report, err := Validate(bytes.NewReader(art),
+		WithHandlers(handlers.NewModuleImage("test-type")), WithForbidUnknownHandlers())
+	require.NoError(t, err)
+	assert.Empty(t, report.UnknownHandlers)
+
+	report, err = Validate(bytes.NewReader(art),
+		WithHandlers(handlers.NewModuleImage("other-type")), WithForbidUnknownHandlers())
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, []string{"test-type"}, report.UnknownHandlers)
+	assert.EqualError(t, err, "Artifact Payload type 'test-type' is not supported")
+	// The rest of the Artifact is still checked.
+	require.Len(t, report.Files, 3)
+	assert.Equal(t, FileOK, report.Files[0].Status)
+}
+
+func TestValidateSignature(t *testing.T) {
+	signer, verifier := makeTestKeys(t)
+	_, otherVerifier := makeTestKeys(t)
+	signed := writeTestArtifact(t, signer)
+	unsigned := writeTestArtifact(t, nil)
+
+	tests := map[string]struct {
+		art       []byte
+		opts      []Option
+		signature string
+		err       string
+	}{
+		"verified": {
+			art:       signed,
+			opts:      []Option{WithVerifier(verifier)},
+			signature: SignatureVerified,
+		},
+		"no verifier": {
+			art:       signed,
+			signature: SignatureUnverified,
+			err:       "missing verifier",
+		},
+		"wrong key": {
+			art:       signed,
+			opts:      []Option{WithVerifier(otherVerifier)},
+			signature: SignatureInvalid,
+			err:       "verification",
+		},
+		"unsigned": {
+			art:       unsigned,
+			opts:      []Option{WithVerifier(verifier)},
+			signature: SignatureMissing,
+			err:       "missing signature",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			report, err := Validate(bytes.NewReader(test.art), test.opts...)
+			assert.Equal(t, test.signature, report.Signature)
+			if test.err == "" {
+				require.NoError(t, err)
+				assert.True(t, report.Valid)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+			assert.False(t, report.Valid)
+			// The payloads are checked all the same.
+			require.Len(t, report.Payloads, 1)
+		})
+	}
+}
+
+func TestValidateChecksumMismatch(t *testing.T) {
+	broken := bytes.NewBuffer(nil)
+	require.NoError(t, awriter.MutateArtifact(bytes.NewReader(writeTestArtifact(t, nil)),
+		broken, awriter.FaultDataChecksum))
+
+	for _, workers := range []int{0, 2} {
+		report, err := Validate(bytes.NewReader(broken.Bytes()),
+			WithChecksumWorkers(workers))
+		require.Error(t, err, workers)
+		assert.False(t, report.Valid)
+		assert.Equal(t, []string{"data/0000/file"}, report.ChecksumMismatches())
+		for _, f := range report.Files {
+			if f.Name == "data/0000/file" {
+				assert.Equal(t, FileFailed, f.Status)
+				assert.Contains(t, f.Error, "invalid checksum")
+			} else {
+				assert.Equal(t, FileOK, f.Status, f.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package awriter
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// ErrImmutableMetadata is returned by CloneArtifact for Artifacts written
+// with immutable metadata, unless CloneArgs.ForceUnlock is set.
+var ErrImmutableMetadata = errors.New("The Artifact has immutable metadata")
+
+// CloneArgs are the changes CloneArtifact makes to the Artifact it copies.
+type CloneArgs struct {
+	// Name is the artifact_name of the clone.
+	Name string
+	// Provides are added to the artifact_provides of each payload, and
+	// replace those with the same keys.
+	Provides map[string]string
+	// The clone is signed with Signer if set, and unsigned otherwise;
+	// the signatures of the original do not match the new manifest.
+	Signer artifact.Signer
+	// ForceUnlock allows cloning Artifacts with immutable metadata.
+	ForceUnlock bool
+}
+
+var typeInfoPath = regexp.MustCompile(`^headers/[0-9]{4}/type-info$`)
+
+// cloneMember is a member of the Artifact tar, kept in memory until it can
+// be written.
+type cloneMember struct {
+	header *tar.Header
+	body   []byte
+}
+
+// CloneArtifact copies a version 3 Artifact from src to dst under a new
+// name. Only the headers and the manifests are rewritten; the payload data
+// is streamed through unchanged, so cloning is as fast as copying.
+func CloneArtifact(src io.Reader, dst io.Writer, args *CloneArgs) error {
+	if args.Name == "" {
+		return errors.New("The clone needs an Artifact name")
+	}
+	rTar := tar.NewReader(src)
+	wTar := tar.NewWriter(dst)
+
+	// All the members before the data are metadata, which is small. They
+	// are kept until the first data member, since the manifest needs the
+	// checksum of the header which follows it.
+	var members []cloneMember
+	var header *tar.Header
+	var err error
+	for {
+		header, err = rTar.Next()
+		if err == io.EOF || (err == nil && strings.HasPrefix(header.Name, "data/")) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+		if _, ok := artifact.SignatureFileNumber(header.Name); ok {
+			continue
+		}
+		body, err := readTarBody(header, rTar)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read %s", header.Name)
+		}
+		members = append(members, cloneMember{header: header, body: body})
+	}
+	if err = cloneMetadata(wTar, members, args); err != nil {
+		return err
+	}
+
+	for header != nil {
+		if err = wTar.WriteHeader(header); err != nil {
+			return errors.Wrap(err, "Could not write tar header")
+		}
+		if _, err = io.Copy(wTar, rTar); err != nil {
+			return errors.Wrap(err, "Failed to copy tar body")
+		}
+		header, err = rTar.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+	}
+	return errors.Wrap(wTar.Close(), "Could not finalize tar archive")
+}
+
+// cloneMetadata rewrites the headers of the Artifact, updates their
+// checksums in the manifests and writes all the members.
+func cloneMetadata(wTar *tar.Writer, members []cloneMember, args *CloneArgs) error {
+	if len(members) == 0 || members[0].header.Name != "version" {
+		return errors.New("`version` not found. Corrupt Artifact?")
+	}
+	var version artifact.Info
+	if err := json.Unmarshal(members[0].body, &version); err != nil {
+		return errors.Wrap(err, "Could not parse version")
+	}
+	if version.Version != 3 {
+		return errors.Errorf("Version %d Artifacts can not be cloned", version.Version)
+	}
+
+	var manifest, augmentManifest *cloneMember
+	for i := range members {
+		m := &members[i]
+		var err error
+		switch {
+		case m.header.Name == "manifest":
+			manifest = m
+		case m.header.Name == "manifest-augment":
+			augmentManifest = m
+		case strings.HasPrefix(m.header.Name, "header.tar"):
+			if manifest == nil {
+				return ErrManifestNotFound
+			}
+			err = cloneHeader(m, manifest, args, false)
+		case strings.HasPrefix(m.header.Name, "header-augment.tar"):
+			if augmentManifest == nil {
+				return errors.New("`manifest-augment` not found. Corrupt Artifact?")
+			}
+			err = cloneHeader(m, augmentManifest, args, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if manifest == nil {
+		return ErrManifestNotFound
+	}
+
+	for _, m := range members {
+		if err := writeTarFile(wTar, cloneTarHeader(m), m.body); err != nil {
+			return err
+		}
+		if m.header.Name == "manifest" && args.Signer != nil {
+			err := signAndWrite(wTar, m.body, args.Signer, artifact.SignatureFileName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cloneTarHeader(m cloneMember) *tar.Header {
+	header := *m.header
+	header.Size = int64(len(m.body))
+	return &header
+}
+
+// cloneHeader rewrites the compressed header tar in m, and its checksum in
+// manifest. Provides are only added to the original header, since the
+// augmented one only overrides what it has itself.
+func cloneHeader(m, manifest *cloneMember, args *CloneArgs, augmented bool) error {
+	comp, err := artifact.NewCompressorFromFileName(m.header.Name)
+	if err != nil {
+		return err
+	}
+	r, err := comp.NewReader(bytes.NewReader(m.body))
+	if err != nil {
+		return errors.Wrapf(err, "Could not open %s", m.header.Name)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	w, err := comp.NewWriter(&buf)
+	if err != nil {
+		return errors.Wrap(err, "Could not open compressor")
+	}
+	hTarR := tar.NewReader(r)
+	hTarW := tar.NewWriter(w)
+	for {
+		header, err := hTarR.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrapf(err, "Could not read %s", m.header.Name)
+		}
+		body, err := readTarBody(header, hTarR)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read %s", header.Name)
+		}
+		switch {
+		case header.Name == "header-info":
+			body, err = cloneHeaderInfo(body, args)
+		case typeInfoPath.MatchString(header.Name) && !augmented:
+			body, err = cloneTypeInfo(body, args)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "Could not rewrite %s", header.Name)
+		}
+		header.Size = int64(len(body))
+		if err = writeTarFile(hTarW, header, body); err != nil {
+			return err
+		}
+	}
+	if err = hTarW.Close(); err != nil {
+		return errors.Wrapf(err, "Could not finalize %s", m.header.Name)
+	}
+	if err = w.Close(); err != nil {
+		return errors.Wrapf(err, "Could not compress %s", m.header.Name)
+	}
+
+	m.body = buf.Bytes()
+	// The new checksum has the algorithm of the others.
+	sums := artifact.NewChecksumStore()
+	if err = sums.ReadRaw(manifest.body); err != nil {
+		return err
+	}
+	algorithm, err := sums.Algorithm()
+	if err != nil {
+		return err
+	}
+	sum := artifact.NewWriterChecksumAlgorithm(io.Discard, algorithm)
+	if _, err = sum.Write(m.body); err != nil {
+		return err
+	}
+	manifest.body, err = replaceManifestChecksum(manifest.body, m.header.Name, sum.Checksum())
+	return err
+}
+
+// cloneHeaderInfo renames the Artifact in header-info, keeping the other
+// fields as they are.
+func cloneHeaderInfo(body []byte, args *CloneArgs) ([]byte, error) {
+	var info map[string]json.RawMessage
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	provides := map[string]interface{}{}
+	if raw, ok := info["artifact_provides"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &provides); err != nil {
+			return nil, err
+		}
+	}
+	provides["artifact_name"] = args.Name
+	raw, err := json.Marshal(provides)
+	if err != nil {
+		return nil, err
+	}
+	info["artifact_provides"] = raw
+	return json.Marshal(info)
+}
+
+// cloneTypeInfo adds the provides of args to the artifact_provides of a
+// type-info, unless it locks the metadata of the Artifact.
+func cloneTypeInfo(body []byte, args *CloneArgs) ([]byte, error) {
+	var info map[string]json.RawMessage
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	var immutable bool
+	if raw, ok := info["immutable_metadata"]; ok {
+		if err := json.Unmarshal(raw, &immutable); err != nil {
+			return nil, err
+		}
+	}
+	if immutable && !args.ForceUnlock {
+		return nil, ErrImmutableMetadata
+	}
+	provides := args.Provides
+	if len(provides) == 0 {
+		return body, nil
+	}
+	merged := map[string]string{}
+	if raw, ok := info["artifact_provides"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &merged); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range provides {
+		merged[key] = value
+	}
+	raw, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	info["artifact_provides"] = raw
+	return json.Marshal(info)
+}
+
+// replaceManifestChecksum replaces the checksum of file in manifest, which
+// has a "<checksum>  <file>" line for each file.
+func replaceManifestChecksum(manifest []byte, file string, sum []byte) ([]byte, error) {
+	lines := strings.SplitAfter(string(manifest), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == file {
+			lines[i] = string(sum) + "  " + file + "\n"
+			return []byte(strings.Join(lines, "")), nil
+		}
+	}
+	return nil, errors.Errorf("%s not found in the manifest", file)
+}
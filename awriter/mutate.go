@@ -0,0 +1,233 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package awriter
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Fault is a way of breaking an Artifact, to test how clients and servers
+// handle malformed Artifacts.
+type Fault string
+
+const (
+	// FaultMissingVersion removes the version file.
+	FaultMissingVersion Fault = "missing-version"
+	// FaultMissingManifest removes the manifest.
+	FaultMissingManifest Fault = "missing-manifest"
+	// FaultMissingHeader removes the header tar.
+	FaultMissingHeader Fault = "missing-header"
+	// FaultMissingData removes the data tar of the first payload.
+	FaultMissingData Fault = "missing-data"
+	// FaultHeaderChecksum changes the checksum of the header tar in the
+	// manifest.
+	FaultHeaderChecksum Fault = "header-checksum"
+	// FaultDataChecksum changes the checksum of the first data tar in the
+	// manifest.
+	FaultDataChecksum Fault = "data-checksum"
+	// FaultManifestExtraFile adds a file which is not in the Artifact to
+	// the manifest.
+	FaultManifestExtraFile Fault = "manifest-extra-file"
+	// FaultManifestMissingData removes the data tars from the manifest.
+	FaultManifestMissingData Fault = "manifest-missing-data"
+	// FaultParseOrder moves the header tar in front of the manifest.
+	FaultParseOrder Fault = "parse-order"
+)
+
+// Faults are all the faults MutateArtifact can introduce.
+var Faults = []Fault{
+	FaultMissingVersion,
+	FaultMissingManifest,
+	FaultMissingHeader,
+	FaultMissingData,
+	FaultHeaderChecksum,
+	FaultDataChecksum,
+	FaultManifestExtraFile,
+	FaultManifestMissingData,
+	FaultParseOrder,
+}
+
+// Known returns true if MutateArtifact can introduce the fault.
+func (f Fault) Known() bool {
+	for _, fault := range Faults {
+		if f == fault {
+			return true
+		}
+	}
+	return false
+}
+
+// mutateExtraFile is the manifest line added by FaultManifestExtraFile.
+const mutateExtraFile = "0000000000000000000000000000000000000000000000000000000000000000" +
+	"  missing_file\n"
+
+// MutateArtifact copies the Artifact from src to dst, breaking it with
+// fault. Like CloneArtifact, it only keeps the metadata in memory, and
+// streams the payload data through. Signatures are copied unchanged, so
+// the ones of a changed manifest no longer match.
+func MutateArtifact(src io.Reader, dst io.Writer, fault Fault) error {
+	if !fault.Known() {
+		return errors.Errorf("Unknown fault: %s", fault)
+	}
+	rTar := tar.NewReader(src)
+	wTar := tar.NewWriter(dst)
+
+	var members []cloneMember
+	var header *tar.Header
+	var err error
+	for {
+		header, err = rTar.Next()
+		if err == io.EOF || (err == nil && strings.HasPrefix(header.Name, "data/")) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+		body, err := readTarBody(header, rTar)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read %s", header.Name)
+		}
+		members = append(members, cloneMember{header: header, body: body})
+	}
+	if len(members) == 0 {
+		return errors.New("`version` not found. Corrupt Artifact?")
+	}
+	if members, err = mutateMetadata(members, fault); err != nil {
+		return err
+	}
+	for _, m := range members {
+		if err = writeTarFile(wTar, cloneTarHeader(m), m.body); err != nil {
+			return err
+		}
+	}
+
+	if fault == FaultMissingData {
+		if header == nil {
+			return errors.New("The Artifact has no payload data to remove")
+		}
+		// Skip the first data tar.
+		if header, err = rTar.Next(); err == io.EOF {
+			header = nil
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+	}
+	for header != nil {
+		if err = wTar.WriteHeader(header); err != nil {
+			return errors.Wrap(err, "Could not write tar header")
+		}
+		if _, err = io.Copy(wTar, rTar); err != nil {
+			return errors.Wrap(err, "Failed to copy tar body")
+		}
+		header, err = rTar.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+	}
+	return errors.Wrap(wTar.Close(), "Could not finalize tar archive")
+}
+
+// memberIndex returns the index of the member called name, possibly with a
+// compression suffix, or -1.
+func memberIndex(members []cloneMember, name string) int {
+	for i, m := range members {
+		if m.header.Name == name || strings.HasPrefix(m.header.Name, name+".") {
+			return i
+		}
+	}
+	return -1
+}
+
+// mutateMetadata applies fault to the metadata members of the Artifact.
+func mutateMetadata(members []cloneMember, fault Fault) ([]cloneMember, error) {
+	manifest := memberIndex(members, "manifest")
+	headerTar := memberIndex(members, "header.tar")
+
+	var remove int
+	switch fault {
+	case FaultMissingData:
+		return members, nil
+	case FaultMissingVersion:
+		remove = memberIndex(members, "version")
+	case FaultMissingManifest:
+		remove = manifest
+	case FaultMissingHeader:
+		remove = headerTar
+	case FaultParseOrder:
+		if manifest < 0 || headerTar < 0 {
+			return nil, errors.New("The Artifact has no manifest and header to reorder")
+		}
+		header := members[headerTar]
+		members = append(members[:headerTar], members[headerTar+1:]...)
+		members = append(members[:manifest+1], members[manifest:]...)
+		members[manifest] = header
+		return members, nil
+	default:
+		if manifest < 0 {
+			return nil, ErrManifestNotFound
+		}
+		body, err := mutateManifest(string(members[manifest].body), fault)
+		if err != nil {
+			return nil, err
+		}
+		members[manifest].body = []byte(body)
+		return members, nil
+	}
+	if remove < 0 {
+		return nil, errors.Errorf("The Artifact has nothing to remove for the %s fault", fault)
+	}
+	return append(members[:remove], members[remove+1:]...), nil
+}
+
+// mutateManifest applies one of the faults which change the manifest,
+// which has a "<checksum>  <file>" line for each file.
+func mutateManifest(manifest string, fault Fault) (string, error) {
+	if fault == FaultManifestExtraFile {
+		return manifest + mutateExtraFile, nil
+	}
+	prefix := "data/"
+	if fault == FaultHeaderChecksum {
+		prefix = "header.tar"
+	}
+	lines := strings.SplitAfter(manifest, "\n")
+	found := false
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], prefix) {
+			continue
+		}
+		found = true
+		if fault == FaultManifestMissingData {
+			lines[i] = ""
+			continue
+		}
+		// Replace the first digit with another hex digit.
+		digit := "0"
+		if line[0] == '0' {
+			digit = "1"
+		}
+		lines[i] = digit + line[1:]
+		break
+	}
+	if !found {
+		return "", errors.Errorf("The manifest has no %s entry for the %s fault", prefix, fault)
+	}
+	return strings.Join(lines, ""), nil
+}
@@ -16,6 +16,7 @@ package awriter
 
 import (
 	"archive/tar"
+	"encoding/json"
 	"io"
 
 	"github.com/pkg/errors"
@@ -50,12 +51,13 @@ func SignExisting(src io.Reader, dst io.Writer, key artifact.Signer, overwrite b
 			}
 			foundManifest = true
 			continue
-		case "manifest.sig":
-			if overwrite {
-				continue
-			} else {
+		}
+		if _, ok := artifact.SignatureFileNumber(header.Name); ok {
+			if !overwrite {
 				return ErrAlreadyExistingSignature
 			}
+			// Replace all the signatures, not only the first.
+			continue
 		}
 
 		err = wTar.WriteHeader(header)
@@ -81,57 +83,129 @@ func SignExisting(src io.Reader, dst io.Writer, key artifact.Signer, overwrite b
 	return nil
 }
 
+// AddSignature adds a signature made with key to an existing Artifact, and
+// keeps the signatures it has already. The new signature follows them, as
+// manifest.sig if the Artifact is unsigned, or as the next manifest.sig.N.
+// See Writer.AddSigner.
+func AddSignature(src io.Reader, dst io.Writer, key artifact.Signer) error {
+	var manifest []byte
+	var version artifact.Info
+	var signatures int
+	var added bool
+	rTar := tar.NewReader(src)
+	wTar := tar.NewWriter(dst)
+	for {
+		header, err := rTar.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "Could not read tar header")
+		}
+
+		_, isSignature := artifact.SignatureFileNumber(header.Name)
+		switch {
+		case header.Name == "version":
+			body, err := readTarBody(header, rTar)
+			if err != nil {
+				return errors.Wrap(err, "Could not read version")
+			}
+			if err = json.Unmarshal(body, &version); err != nil {
+				return errors.Wrap(err, "Could not parse version")
+			}
+			if err = writeTarFile(wTar, header, body); err != nil {
+				return err
+			}
+			continue
+		case header.Name == "manifest":
+			if manifest, err = readTarBody(header, rTar); err != nil {
+				return errors.Wrap(err, "Could not read manifest")
+			}
+			if err = writeTarFile(wTar, header, manifest); err != nil {
+				return err
+			}
+			continue
+		case isSignature:
+			signatures++
+		case manifest != nil && !added:
+			if signatures > 0 && version.Version < 3 {
+				return errors.Errorf(
+					"Version %d Artifacts can only hold one signature", version.Version)
+			}
+			if err = signAndWrite(wTar, manifest, key,
+				artifact.NthSignatureFileName(signatures)); err != nil {
+				return err
+			}
+			added = true
+		}
+
+		err = wTar.WriteHeader(header)
+		if err != nil {
+			return errors.Wrap(err, "Could not write tar header")
+		}
+
+		_, err = io.Copy(wTar, rTar)
+		if err != nil {
+			return errors.Wrap(err, "Failed to copy tar body")
+		}
+	}
+
+	if !added {
+		return ErrManifestNotFound
+	}
+
+	return errors.Wrap(wTar.Close(), "Could not finalize tar archive")
+}
+
 func signManifestAndOutputSignature(
 	header *tar.Header,
 	src *tar.Reader,
 	dst *tar.Writer,
 	key artifact.Signer,
 ) error {
-	buf := make([]byte, header.Size)
-	read, err := src.Read(buf)
-	if err != nil && err != io.EOF {
-		return errors.Wrap(err, "Could not read manifest")
-	} else if int64(read) != header.Size {
-		return errors.New("Unexpected mismatch between header size and read size")
-	}
-
-	err = dst.WriteHeader(header)
+	buf, err := readTarBody(header, src)
 	if err != nil {
-		return errors.Wrap(err, "Could not write manifest header")
+		return errors.Wrap(err, "Could not read manifest")
 	}
-	written, err := dst.Write(buf)
-	if err != nil {
-		return errors.Wrap(err, "Could not write manifest")
-	} else if written != read {
-		return errors.New("Could not write entire manifest")
+	if err = writeTarFile(dst, header, buf); err != nil {
+		return err
 	}
+	return signAndWrite(dst, buf, key, artifact.SignatureFileName)
+}
 
-	signedBuf, err := key.Sign(buf)
+func signAndWrite(dst *tar.Writer, manifest []byte, key artifact.Signer, name string) error {
+	signedBuf, err := key.Sign(manifest)
 	if err != nil {
 		return errors.Wrap(err, "Could not sign manifest")
 	}
 
 	signedHeader := &tar.Header{
-		Name: "manifest.sig",
+		Name: name,
 		Size: int64(len(signedBuf)),
 		Mode: 0644,
 	}
+	artifact.SetPAXFormat(signedHeader)
+	return writeTarFile(dst, signedHeader, signedBuf)
+}
 
-	err = dst.WriteHeader(signedHeader)
-	if err != nil {
-		return errors.Wrap(err, "Could not write signature header")
+// readTarBody reads the whole body of the current file of src, which must
+// be as large as its header says.
+func readTarBody(header *tar.Header, src *tar.Reader) ([]byte, error) {
+	buf := make([]byte, header.Size)
+	if _, err := io.ReadFull(src, buf); err != nil {
+		return nil, errors.Wrap(err, "Unexpected mismatch between header size and read size")
 	}
-	written, err = dst.Write(signedBuf)
-	if err != nil {
-		return errors.Wrap(err, "Could not write signature")
-	} else if written != len(signedBuf) {
-		return errors.New("Could not write entire manifest.sig")
+	if read, err := src.Read(make([]byte, 1)); err != io.EOF || read != 0 {
+		return nil, errors.New("File bigger than its header size")
 	}
+	return buf, nil
+}
 
-	read, err = src.Read(buf)
-	if err != io.EOF || read != 0 {
-		return errors.New("File bigger than its header size")
+func writeTarFile(dst *tar.Writer, header *tar.Header, body []byte) error {
+	if err := dst.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "Could not write %s header", header.Name)
+	}
+	if _, err := dst.Write(body); err != nil {
+		return errors.Wrapf(err, "Could not write %s", header.Name)
 	}
-
 	return nil
 }
@@ -0,0 +1,106 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package awriter
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// spillBuffer holds the data written to it in memory up to limit bytes, and
+// moves it to a temporary file in dir when it grows beyond that.
+type spillBuffer struct {
+	limit  int64
+	dir    string
+	prefix string
+	mem    bytes.Buffer
+	file   *os.File
+	size   int64
+}
+
+func (aw *Writer) newSpillBuffer(prefix string) *spillBuffer {
+	return &spillBuffer{limit: aw.MemoryBufferSize, dir: aw.TempDir, prefix: prefix}
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.size+int64(len(p)) > b.limit {
+		f, err := utils.TempFile(b.dir, b.prefix)
+		if err != nil {
+			return 0, errors.Wrap(err, "writer: can not create temporary file")
+		}
+		b.file = f
+		if _, err = b.mem.WriteTo(f); err != nil {
+			return 0, errors.Wrap(err, "writer: can not write temporary file")
+		}
+	}
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written to the buffer.
+func (b *spillBuffer) Size() int64 {
+	return b.size
+}
+
+// Spilled returns true if the buffer has been moved to a temporary file.
+func (b *spillBuffer) Spilled() bool {
+	return b.file != nil
+}
+
+// Reader returns a reader of the contents of the buffer from the start.
+func (b *spillBuffer) Reader() (io.Reader, error) {
+	if b.file == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "writer: can not rewind temporary file")
+	}
+	return b.file, nil
+}
+
+// Close releases the memory and removes the temporary file, if any.
+func (b *spillBuffer) Close() {
+	b.mem = bytes.Buffer{}
+	if b.file != nil {
+		b.file.Close()
+		utils.RemoveTemp(b.file.Name())
+		b.file = nil
+	}
+}
+
+// writeSpillBuffer stores the contents of b in tw as a file called
+// archivePath.
+func writeSpillBuffer(tw *tar.Writer, b *spillBuffer, archivePath string) error {
+	r, err := b.Reader()
+	if err != nil {
+		return err
+	}
+	return artifact.NewTarWriterFile(tw).WriteReader(r, b.Size(), time.Now(), archivePath)
+}
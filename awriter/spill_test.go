@@ -0,0 +1,92 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package awriter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir := t.TempDir()
+	b := &spillBuffer{limit: 10, dir: dir, prefix: "data"}
+
+	_, err := b.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	assert.False(t, b.Spilled())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	_, err = b.Write([]byte("abc"))
+	require.NoError(t, err)
+	assert.True(t, b.Spilled())
+	assert.Equal(t, int64(13), b.Size())
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	for i := 0; i < 2; i++ {
+		r, err := b.Reader()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "0123456789abc", string(data))
+	}
+
+	b.Close()
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Nothing is written to disk below the limit.
+	b = &spillBuffer{limit: 10, dir: filepath.Join(dir, "missing")}
+	_, err = b.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	_, err = b.Write([]byte("a"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "can not create temporary file")
+}
+
+func TestWriteInMemory(t *testing.T) {
+	upd, err := MakeFakeUpdate("my test update")
+	require.NoError(t, err)
+	defer os.Remove(upd)
+
+	write := func(memory int64) error {
+		w := NewWriter(ioutil.Discard, artifact.NewCompressorGzip())
+		w.MemoryBufferSize = memory
+		w.TempDir = filepath.Join(t.TempDir(), "missing")
+		return w.WriteArtifact(&WriteArtifactArgs{
+			Format:  "mender",
+			Version: 2,
+			Devices: []string{"asd"},
+			Name:    "name",
+			Updates: &Updates{Updates: []handlers.Composer{handlers.NewRootfsV2(upd)}},
+		})
+	}
+	assert.NoError(t, write(1024*1024))
+	err = write(0)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not create temporary file")
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package awriter
+
+import (
+	"io"
+	"time"
+)
+
+// MemberStats holds the compression statistics of one compressed member of
+// an Artifact, such as header.tar.gz or data/0000.tar.gz.
+type MemberStats struct {
+	Name string
+	// InputSize is the size of the tar archive before compression.
+	InputSize int64
+	// OutputSize is the size of the member in the Artifact.
+	OutputSize int64
+	// Duration is the time spent composing and compressing the member,
+	// including reading the payload files.
+	Duration time.Duration
+}
+
+// Ratio returns the output size relative to the input size, or 0 for an
+// empty input.
+func (s MemberStats) Ratio() float64 {
+	if s.InputSize == 0 {
+		return 0
+	}
+	return float64(s.OutputSize) / float64(s.InputSize)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -22,6 +22,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -42,9 +44,38 @@ type ProgressWriter interface {
 type Writer struct {
 	w              io.Writer // underlying writer
 	signer         artifact.Signer
+	extraSigners   []artifact.Signer
 	c              artifact.Compressor
 	State          chan string    // Report progress
 	ProgressWriter ProgressWriter // Report progress whilst writing
+
+	// The manifest comes first in an Artifact, and holds the checksums of
+	// the header and the data, which are therefore composed before being
+	// written. MemoryBufferSize is the number of bytes of each of them
+	// kept in memory; larger ones are buffered in temporary files in
+	// TempDir, or in the default directory for temporary files if empty.
+	// As all payloads are composed before any is written, TempDir needs
+	// room for the compressed data of all of them. Each is removed as soon
+	// as it has been written.
+	MemoryBufferSize int64
+	TempDir          string
+
+	// ReproducibleTime, unless zero, is the modification time of all the
+	// members of the Artifacts written, which have no owner either, so
+	// that the same input always gives an identical Artifact. It is set
+	// with artifact.SetReproducibleTime while writing, and therefore
+	// applies to all the Artifacts written at the same time.
+	ReproducibleTime time.Time
+
+	// Concurrency, if positive, is the maximum number of goroutines
+	// compressing at once, for the compressors which use several, so that
+	// writing Artifacts leaves CPUs to other jobs on the same host.
+	Concurrency int
+
+	manifest []byte
+	stats    []MemberStats
+	// The checksum algorithm of the Artifact being written.
+	checksumAlgorithm string
 }
 
 func NewWriter(w io.Writer, c artifact.Compressor) *Writer {
@@ -55,6 +86,20 @@ func NewWriter(w io.Writer, c artifact.Compressor) *Writer {
 	}
 }
 
+// Manifest returns the contents of the manifest of the last Artifact
+// written, followed by the augmented manifest, if any. Each line holds the
+// checksum and the name of one file of the Artifact, in the format of
+// sha256sum.
+func (aw *Writer) Manifest() []byte {
+	return aw.manifest
+}
+
+// Stats returns the compression statistics of the compressed members of the
+// last Artifact written: the headers, followed by the data of each payload.
+func (aw *Writer) Stats() []MemberStats {
+	return aw.stats
+}
+
 func NewWriterSigned(
 	w io.Writer,
 	c artifact.Compressor,
@@ -65,6 +110,27 @@ func NewWriterSigned(
 	return nw
 }
 
+// AddSigner makes the Writer sign the Artifacts it writes with signer too,
+// after the signer given to NewWriterSigned and any added before. The
+// signatures are stored as manifest.sig, manifest.sig.1 and so on, and a
+// reader accepts the Artifact if any of them verifies. Only version 3
+// Artifacts can hold more than one signature, and readers older than this
+// format extension reject them.
+func (aw *Writer) AddSigner(signer artifact.Signer) {
+	if aw.signer == nil {
+		aw.signer = signer
+		return
+	}
+	aw.extraSigners = append(aw.extraSigners, signer)
+}
+
+func (aw *Writer) signers() []artifact.Signer {
+	if aw.signer == nil {
+		return nil
+	}
+	return append([]artifact.Signer{aw.signer}, aw.extraSigners...)
+}
+
 type Updates struct {
 	// Both of these are indexed the same, so Augment at index X corresponds
 	// to Update at index X.
@@ -72,11 +138,93 @@ type Updates struct {
 	Augments []handlers.Composer
 }
 
-// Iterate through all data files inside `upd` and calculate checksums.
+// ValidatePayloadFileNames checks that the names of the data files in `upd`
+// are valid, and unique within each payload. WriteArtifact does this before
+// writing anything; callers can use it to fail even earlier.
+func ValidatePayloadFileNames(upd *Updates) error {
+	if upd == nil {
+		return nil
+	}
+	for i, u := range upd.Updates {
+		files := u.GetUpdateFiles()
+		if i < len(upd.Augments) && upd.Augments[i] != nil {
+			files = append(files, upd.Augments[i].GetUpdateAugmentFiles()...)
+		}
+		seen := map[string]string{}
+		for _, f := range files {
+			name := f.GetPayloadName()
+			if err := artifact.ValidatePayloadFileName(name); err != nil {
+				return errors.Wrapf(err, "writer: data file %s", f.Name)
+			}
+			if other, ok := seen[name]; ok {
+				return errors.Errorf(
+					"writer: data files %s and %s would both be named %s in payload %d",
+					other, f.Name, name, i)
+			}
+			seen[name] = f.Name
+		}
+	}
+	return nil
+}
+
+// validateExcludedDevices checks that the excluded device types, which
+// only version 3 Artifacts can hold, leave a compatible device type.
+func validateExcludedDevices(args *WriteArtifactArgs) error {
+	if args.Depends == nil || len(args.Depends.ExcludedDevices) == 0 {
+		return nil
+	}
+	if args.Version < 3 {
+		return errors.New("excluded device types require Artifact version 3")
+	}
+	for _, device := range args.Depends.CompatibleDevices {
+		if !args.Depends.ExcludesDevice(device) {
+			return nil
+		}
+	}
+	return errors.New("all the compatible device types are excluded")
+}
+
+// validateMetadataValues checks the names, keys and values of the metadata
+// in args, which readers display, with artifact.ValidateMetadataValue.
+func validateMetadataValues(args *WriteArtifactArgs) error {
+	values := append([]string{args.Name}, args.Devices...)
+	if args.Provides != nil {
+		values = append(values, args.Provides.ArtifactName, args.Provides.ArtifactGroup)
+	}
+	if args.Depends != nil {
+		values = append(values, args.Depends.ArtifactName...)
+		values = append(values, args.Depends.CompatibleDevices...)
+		values = append(values, args.Depends.ArtifactGroup...)
+		values = append(values, args.Depends.ExcludedDevices...)
+	}
+	if err := artifact.ValidateMetadataValues(values); err != nil {
+		return err
+	}
+	if err := validateExcludedDevices(args); err != nil {
+		return err
+	}
+	typeInfos := []*artifact.TypeInfoV3{args.TypeInfoV3, args.AugmentTypeInfoV3}
+	for _, header := range args.PayloadHeaders {
+		typeInfos = append(typeInfos, header.TypeInfoV3)
+	}
+	for _, typeInfo := range typeInfos {
+		if typeInfo == nil {
+			continue
+		}
+		if err := typeInfo.ValidateValues(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Iterate through all data files inside `upd` and calculate checksums with
+// algorithm.
 func calcDataHash(
 	manifestChecksumStore *artifact.ChecksumStore,
 	upd *Updates,
 	augmented bool,
+	algorithm string,
 ) error {
 	var updates []handlers.Composer
 	if augmented {
@@ -96,20 +244,23 @@ func calcDataHash(
 			files = u.GetUpdateFiles()
 		}
 		for _, f := range files {
-			ch := artifact.NewWriterChecksum(ioutil.Discard)
-			df, err := os.Open(f.Name)
-			if err != nil {
-				return errors.Wrapf(err, "writer: can not open data file: %s", f.Name)
+			// Streamed files can only be read once, and got their checksums
+			// when the data was composed.
+			if f.Reader == nil {
+				ch := artifact.NewWriterChecksumAlgorithm(ioutil.Discard, algorithm)
+				df, err := os.Open(f.Name)
+				if err != nil {
+					return errors.Wrapf(err, "writer: can not open data file: %s", f.Name)
+				}
+				defer df.Close()
+				if _, err := io.Copy(ch, df); err != nil {
+					return errors.Wrapf(err, "writer: can not calculate checksum: %s", f.Name)
+				}
+				f.Checksum = ch.Checksum()
 			}
-			defer df.Close()
-			if _, err := io.Copy(ch, df); err != nil {
-				return errors.Wrapf(err, "writer: can not calculate checksum: %s", f.Name)
-			}
-			sum := ch.Checksum()
-			f.Checksum = sum
-			err = manifestChecksumStore.Add(
-				filepath.Join(artifact.UpdatePath(i), filepath.Base(f.Name)),
-				sum,
+			err := manifestChecksumStore.Add(
+				filepath.Join(artifact.UpdatePath(i), f.GetPayloadName()),
+				f.Checksum,
 			)
 			if err != nil {
 				return errors.Wrapf(err, "writer: can not calculate checksum: %s", f.Name)
@@ -120,26 +271,29 @@ func calcDataHash(
 }
 
 // writeTempHeader can write both the standard and the augmented header
-func writeTempHeader(c artifact.Compressor, manifestChecksumStore *artifact.ChecksumStore,
-	name string, args *WriteArtifactArgs, augmented bool) (*os.File, error) {
+func (aw *Writer) writeTempHeader(c artifact.Compressor,
+	manifestChecksumStore *artifact.ChecksumStore,
+	name string, args *WriteArtifactArgs, augmented bool) (*spillBuffer, MemberStats, error) {
 
-	// create temporary header file
-	f, err := ioutil.TempFile("", name)
-	if err != nil {
-		return nil, errors.New("writer: can not create temporary header file")
-	}
+	fullName := fmt.Sprintf("%s.tar%s", name, c.GetFileExtension())
+	stats := MemberStats{Name: fullName}
+	start := time.Now()
 
-	ch := artifact.NewWriterChecksum(f)
+	f := aw.newSpillBuffer(name)
+	ch := artifact.NewWriterChecksumAlgorithm(f, aw.checksumAlgorithm)
+	out := &countingWriter{w: ch}
+	in := &countingWriter{}
 	// use function to make sure to close gz and tar before
 	// calculating checksum
-	err = func() error {
-		gz, err := c.NewWriter(ch)
+	err := func() error {
+		gz, err := c.NewWriter(out)
 		if err != nil {
 			return errors.Wrapf(err, "writer: can not open compressor")
 		}
 		defer gz.Close()
 
-		htw := tar.NewWriter(gz)
+		in.w = gz
+		htw := tar.NewWriter(in)
 		defer htw.Close()
 
 		// Header differs in version 3 from version 2.
@@ -150,16 +304,18 @@ func writeTempHeader(c artifact.Compressor, manifestChecksumStore *artifact.Chec
 	}()
 
 	if err != nil {
-		os.Remove(f.Name())
-		return nil, err
+		f.Close()
+		return nil, stats, err
 	}
-	fullName := fmt.Sprintf("%s.tar%s", name, c.GetFileExtension())
 	err = manifestChecksumStore.Add(fullName, ch.Checksum())
 	if err != nil {
-		return nil, errors.Wrapf(err, "writer: can not calculate checksum: %s", fullName)
+		return nil, stats, errors.Wrapf(err, "writer: can not calculate checksum: %s", fullName)
 	}
 
-	return f, nil
+	stats.InputSize = in.n
+	stats.OutputSize = out.n
+	stats.Duration = time.Since(start)
+	return f, stats, nil
 }
 
 func WriteSignature(tw *tar.Writer, message []byte,
@@ -173,12 +329,28 @@ func WriteSignature(tw *tar.Writer, message []byte,
 		return errors.Wrap(err, "writer: can not sign artifact")
 	}
 	sw := artifact.NewTarWriterStream(tw)
-	if err := sw.Write(sig, "manifest.sig"); err != nil {
+	if err := sw.Write(sig, artifact.SignatureFileName); err != nil {
 		return errors.Wrap(err, "writer: can not tar signature")
 	}
 	return nil
 }
 
+// WriteSignatures writes one signature of message for each of the signers,
+// named as returned by artifact.NthSignatureFileName.
+func WriteSignatures(tw *tar.Writer, message []byte, signers []artifact.Signer) error {
+	for n, signer := range signers {
+		sig, err := signer.Sign(message)
+		if err != nil {
+			return errors.Wrapf(err, "writer: can not sign artifact with key %d", n+1)
+		}
+		sw := artifact.NewTarWriterStream(tw)
+		if err := sw.Write(sig, artifact.NthSignatureFileName(n)); err != nil {
+			return errors.Wrap(err, "writer: can not tar signature")
+		}
+	}
+	return nil
+}
+
 type WriteArtifactArgs struct {
 	Format            string
 	Version           int
@@ -193,6 +365,88 @@ type WriteArtifactArgs struct {
 	AugmentTypeInfoV3 *artifact.TypeInfoV3
 	AugmentMetaData   map[string]interface{} // Generic JSON
 	Bootstrap         bool
+	// ImmutableMetadata locks the metadata of the Artifact against
+	// modification, in the type-info of every payload.
+	ImmutableMetadata bool
+	// PayloadSigner signs the individual payload files, independently of
+	// the signature of the Artifact.
+	PayloadSigner artifact.Signer
+	// UncompressedHeader stores the headers without compression, whatever
+	// the compression of the payloads, so that devices do not need to
+	// decompress them.
+	UncompressedHeader bool
+	// PayloadHeaders holds the type-info and meta-data of each of the
+	// Updates, in the same order. Payloads without an entry use
+	// TypeInfoV3 and MetaData.
+	PayloadHeaders []PayloadHeader
+	// DeduplicateFiles stores augment files with the same content as an
+	// earlier file of their payload as a link to it, instead of a second
+	// copy. Only readers resolving such links can read the Artifact.
+	DeduplicateFiles bool
+	// Timestamp, unless zero, is the modification time of all the members
+	// of the Artifact, like Writer.ReproducibleTime, which takes
+	// precedence. Without either, the SOURCE_DATE_EPOCH environment
+	// variable gives the time, if set.
+	Timestamp time.Time
+	// ChecksumAlgorithm is the hash algorithm of the checksums of the
+	// manifest, one of artifact.ChecksumAlgorithms, or sha256 if empty.
+	// Other algorithms than sha256 are recorded in the version file.
+	ChecksumAlgorithm string
+}
+
+// SourceDateEpochEnv is the environment variable with the time, in seconds
+// since the epoch, which reproducible builds stamp their output with.
+const SourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// sourceDateEpoch returns the time in SourceDateEpochEnv, or the zero time
+// if it is not set.
+func sourceDateEpoch() (time.Time, error) {
+	value := os.Getenv(SourceDateEpochEnv)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, errors.Errorf("invalid %s value %q", SourceDateEpochEnv, value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// timestamp returns the modification time of the members of the Artifact,
+// or the zero time to keep the times of the files.
+func (aw *Writer) timestamp(args *WriteArtifactArgs) (time.Time, error) {
+	if !aw.ReproducibleTime.IsZero() {
+		return aw.ReproducibleTime, nil
+	}
+	if !args.Timestamp.IsZero() {
+		return args.Timestamp, nil
+	}
+	return sourceDateEpoch()
+}
+
+// info returns the contents of the version file of the Artifact.
+func (aw *Writer) info(args *WriteArtifactArgs) *artifact.Info {
+	info := &artifact.Info{Version: args.Version, Format: args.Format}
+	// Left out for sha256, so that such Artifacts stay readable by older
+	// readers.
+	if aw.checksumAlgorithm != artifact.ChecksumSHA256 {
+		info.ChecksumAlgorithm = aw.checksumAlgorithm
+	}
+	return info
+}
+
+// PayloadHeader is the type-info and meta-data of a single payload.
+type PayloadHeader struct {
+	TypeInfoV3 *artifact.TypeInfoV3
+	MetaData   map[string]interface{} // Generic JSON
+}
+
+// headerCompressor returns the compressor of the headers of the Artifact.
+func (aw *Writer) headerCompressor(args *WriteArtifactArgs) artifact.Compressor {
+	if args.UncompressedHeader {
+		return artifact.NewCompressorNone()
+	}
+	return aw.c
 }
 
 func (aw *Writer) WriteArtifact(args *WriteArtifactArgs) (err error) {
@@ -204,7 +458,36 @@ func (aw *Writer) WriteArtifact(args *WriteArtifactArgs) (err error) {
 	}
 
 	if !(args.Version == 2 || args.Version == 3) {
-		return errors.New("Unsupported artifact version")
+		return errors.Wrap(&artifact.ErrUnsupportedVersion{Got: args.Version}, "writer")
+	}
+	aw.stats = nil
+
+	// Fail before any of the payloads are read.
+	if err := ValidatePayloadFileNames(args.Updates); err != nil {
+		return err
+	}
+	if err := validateMetadataValues(args); err != nil {
+		return errors.Wrap(err, "writer")
+	}
+	if err := validateDeviceTypeScripts(args); err != nil {
+		return errors.Wrap(err, "writer")
+	}
+	if err := artifact.ValidateChecksumAlgorithm(args.ChecksumAlgorithm); err != nil {
+		return errors.Wrap(err, "writer")
+	}
+	aw.checksumAlgorithm = args.ChecksumAlgorithm
+	if aw.checksumAlgorithm == "" {
+		aw.checksumAlgorithm = artifact.ChecksumSHA256
+	}
+	aw.c = artifact.LimitCompressorConcurrency(aw.c, aw.Concurrency)
+
+	timestamp, err := aw.timestamp(args)
+	if err != nil {
+		return errors.Wrap(err, "writer")
+	}
+	if !timestamp.IsZero() {
+		defer artifact.SetReproducibleTime(artifact.ReproducibleTime())
+		artifact.SetReproducibleTime(timestamp)
 	}
 
 	if args.Version == 3 {
@@ -222,7 +505,7 @@ func (aw *Writer) writeArtifactV2(args *WriteArtifactArgs) error {
 
 	aw.State <- stage.Version
 	// write version file
-	inf, err := artifact.ToStream(&artifact.Info{Version: args.Version, Format: args.Format})
+	inf, err := artifact.ToStream(aw.info(args))
 	if err != nil {
 		return err
 	}
@@ -231,23 +514,32 @@ func (aw *Writer) writeArtifactV2(args *WriteArtifactArgs) error {
 		return errors.Wrapf(err, "writer: can not write version tar header")
 	}
 
+	dataTars, dataStats, err := aw.composeData(args.Updates, args.DeduplicateFiles)
+	defer removeDataTars(dataTars)
+	if err != nil {
+		return err
+	}
+
 	aw.State <- stage.Manifest
 	manifestChecksumStore := artifact.NewChecksumStore()
 	// calculate checksums of all data files
 	// we need this regardless of which artifact version we are writing
-	if err := calcDataHash(manifestChecksumStore, args.Updates, false); err != nil {
+	if err := calcDataHash(manifestChecksumStore, args.Updates, false,
+		aw.checksumAlgorithm); err != nil {
 		return err
 	}
-	tmpHdr, err := writeTempHeader(aw.c, manifestChecksumStore, "header", args, false)
+	hc := aw.headerCompressor(args)
+	tmpHdr, hdrStats, err := aw.writeTempHeader(hc, manifestChecksumStore, "header", args, false)
 
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpHdr.Name())
+	defer tmpHdr.Close()
+	aw.stats = append([]MemberStats{hdrStats}, dataStats...)
 
 	if err = writeManifestVersion(
 		args.Version,
-		aw.signer,
+		aw.signers(),
 		tw,
 		manifestChecksumStore,
 		nil,
@@ -255,20 +547,17 @@ func (aw *Writer) writeArtifactV2(args *WriteArtifactArgs) error {
 	); err != nil {
 		return errors.Wrap(err, "WriteArtifact")
 	}
+	aw.manifest = manifestChecksumStore.GetRaw()
 
 	// write header
 	aw.State <- stage.Header
-	if _, err := tmpHdr.Seek(0, 0); err != nil {
-		return errors.Wrapf(err, "writer: error preparing tmp header for writing")
-	}
-	fw := artifact.NewTarWriterFile(tw)
-	if err := fw.Write(tmpHdr, "header.tar"+aw.c.GetFileExtension()); err != nil {
+	if err := writeSpillBuffer(tw, tmpHdr, "header.tar"+hc.GetFileExtension()); err != nil {
 		return errors.Wrapf(err, "writer: can not tar header")
 	}
 
 	// write data files
 	aw.State <- stage.Data
-	return writeData(tw, aw.c, args.Updates, aw.ProgressWriter)
+	return writeData(tw, aw.c, dataTars)
 }
 
 func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
@@ -279,7 +568,7 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	////////////////////////
 	// write version file //
 	////////////////////////
-	inf, err := artifact.ToStream(&artifact.Info{Version: args.Version, Format: args.Format})
+	inf, err := artifact.ToStream(aw.info(args))
 	if err != nil {
 		return err
 	}
@@ -293,6 +582,12 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	// Write manifest.sig     //
 	// Write manifest-augment //
 	////////////////////////////
+	dataTars, dataStats, err := aw.composeData(args.Updates, args.DeduplicateFiles)
+	defer removeDataTars(dataTars)
+	if err != nil {
+		return err
+	}
+
 	aw.State <- stage.Manifest
 	augmentedDataPresent := (len(args.Updates.Augments) > 0)
 
@@ -302,25 +597,30 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	// Holds the checksum for 'header-augment.tar.gz'.
 	augManifestChecksumStore := artifact.NewChecksumStore()
 	aw.State <- stage.ManifestSignature
-	if err := calcDataHash(manifestChecksumStore, args.Updates, false); err != nil {
+	if err := calcDataHash(manifestChecksumStore, args.Updates, false,
+		aw.checksumAlgorithm); err != nil {
 		return err
 	}
 	if augmentedDataPresent {
-		if err := calcDataHash(augManifestChecksumStore, args.Updates, true); err != nil {
+		if err := calcDataHash(augManifestChecksumStore, args.Updates, true,
+			aw.checksumAlgorithm); err != nil {
 			return err
 		}
 	}
 	// The header in version 3 will have the original rootfs-checksum in type-info!
-	tmpHdr, err := writeTempHeader(aw.c, manifestChecksumStore, "header", args, false)
+	hc := aw.headerCompressor(args)
+	tmpHdr, hdrStats, err := aw.writeTempHeader(hc, manifestChecksumStore, "header", args, false)
 	if err != nil {
 		return errors.Wrap(err, "writeArtifactV3: writing header")
 	}
-	defer os.Remove(tmpHdr.Name())
+	defer tmpHdr.Close()
+	stats := []MemberStats{hdrStats}
 
-	var tmpAugHdr *os.File
+	var tmpAugHdr *spillBuffer
 	if augmentedDataPresent {
-		tmpAugHdr, err = writeTempHeader(
-			aw.c,
+		var augHdrStats MemberStats
+		tmpAugHdr, augHdrStats, err = aw.writeTempHeader(
+			hc,
 			augManifestChecksumStore,
 			"header-augment",
 			args,
@@ -329,12 +629,14 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 		if err != nil {
 			return errors.Wrap(err, "writeArtifactV3: writing augmented header")
 		}
-		defer os.Remove(tmpAugHdr.Name())
+		defer tmpAugHdr.Close()
+		stats = append(stats, augHdrStats)
 	}
+	aw.stats = append(stats, dataStats...)
 
 	if err = writeManifestVersion(
 		args.Version,
-		aw.signer,
+		aw.signers(),
 		tw,
 		manifestChecksumStore,
 		augManifestChecksumStore,
@@ -342,16 +644,14 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	); err != nil {
 		return errors.Wrap(err, "WriteArtifact")
 	}
+	aw.manifest = append(append([]byte{}, manifestChecksumStore.GetRaw()...),
+		augManifestChecksumStore.GetRaw()...)
 
 	////////////////////
 	// Write header   //
 	////////////////////
 	aw.State <- stage.Header
-	if _, err := tmpHdr.Seek(0, 0); err != nil {
-		return errors.Wrapf(err, "writer: error preparing tmp header for writing")
-	}
-	fw := artifact.NewTarWriterFile(tw)
-	if err := fw.Write(tmpHdr, "header.tar"+aw.c.GetFileExtension()); err != nil {
+	if err := writeSpillBuffer(tw, tmpHdr, "header.tar"+hc.GetFileExtension()); err != nil {
 		return errors.Wrapf(err, "writer: can not tar header")
 	}
 
@@ -360,11 +660,8 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	/////////////////////////////
 	if augmentedDataPresent {
 		aw.State <- stage.HeaderAugment
-		if _, err := tmpAugHdr.Seek(0, 0); err != nil {
-			return errors.Wrapf(err, "writer: error preparing tmp augment-header for writing")
-		}
-		fw = artifact.NewTarWriterFile(tw)
-		if err := fw.Write(tmpAugHdr, "header-augment.tar"+aw.c.GetFileExtension()); err != nil {
+		err := writeSpillBuffer(tw, tmpAugHdr, "header-augment.tar"+hc.GetFileExtension())
+		if err != nil {
 			return errors.Wrapf(err, "writer: can not tar augmented-header")
 		}
 	}
@@ -373,13 +670,31 @@ func (aw *Writer) writeArtifactV3(args *WriteArtifactArgs) (err error) {
 	// Write the datafiles  //
 	//////////////////////////
 	aw.State <- stage.Data
-	return writeData(tw, aw.c, args.Updates, aw.ProgressWriter)
+	return writeData(tw, aw.c, dataTars)
+}
+
+// addVersionChecksum adds the checksum of the version file to the
+// manifest, with the algorithm of the other checksums.
+func addVersionChecksum(manifestChecksumStore *artifact.ChecksumStore, version []byte) error {
+	algorithm, err := manifestChecksumStore.Algorithm()
+	if err != nil {
+		return errors.Wrap(err, "writer")
+	}
+	ch := artifact.NewWriterChecksumAlgorithm(ioutil.Discard, algorithm)
+	if _, err = ch.Write(version); err != nil {
+		return errors.Wrapf(err, "writer: can not write manifest stream")
+	}
+	err = manifestChecksumStore.Add("version", ch.Checksum())
+	if err != nil {
+		return errors.Wrapf(err, "writer: can not write manifest stream")
+	}
+	return nil
 }
 
 // writeArtifactVersion writes version specific artifact records.
 func writeManifestVersion(
 	version int,
-	signer artifact.Signer,
+	signers []artifact.Signer,
 	tw *tar.Writer,
 	manifestChecksumStore,
 	augmanChecksumStore *artifact.ChecksumStore,
@@ -388,14 +703,8 @@ func writeManifestVersion(
 	switch version {
 	case 2:
 		// add checksum of `version`
-		ch := artifact.NewWriterChecksum(ioutil.Discard)
-		_, err := ch.Write(artifactInfoStream)
-		if err != nil {
-			return errors.Wrapf(err, "writer: can not write manifest stream")
-		}
-		err = manifestChecksumStore.Add("version", ch.Checksum())
-		if err != nil {
-			return errors.Wrapf(err, "writer: can not write manifest stream")
+		if err := addVersionChecksum(manifestChecksumStore, artifactInfoStream); err != nil {
+			return err
 		}
 		// write `manifest` file
 		sw := artifact.NewTarWriterStream(tw)
@@ -403,27 +712,24 @@ func writeManifestVersion(
 			return errors.Wrapf(err, "writer: can not write manifest stream")
 		}
 		// write signature
-		if err := WriteSignature(tw, manifestChecksumStore.GetRaw(), signer); err != nil {
+		if len(signers) > 1 {
+			return errors.New("writer: version 2 Artifacts can only hold one signature")
+		}
+		if err := WriteSignatures(tw, manifestChecksumStore.GetRaw(), signers); err != nil {
 			return err
 		}
 	case 3:
 		// Add checksum of `version`.
-		ch := artifact.NewWriterChecksum(ioutil.Discard)
-		_, err := ch.Write(artifactInfoStream)
-		if err != nil {
-			return errors.Wrapf(err, "writer: can not write manifest stream")
-		}
-		err = manifestChecksumStore.Add("version", ch.Checksum())
-		if err != nil {
-			return errors.Wrapf(err, "writer: can not write manifest stream")
+		if err := addVersionChecksum(manifestChecksumStore, artifactInfoStream); err != nil {
+			return err
 		}
 		// Write `manifest` file.
 		sw := artifact.NewTarWriterStream(tw)
 		if err := sw.Write(manifestChecksumStore.GetRaw(), "manifest"); err != nil {
 			return errors.Wrapf(err, "writer: can not write manifest stream")
 		}
-		// Write signature.
-		if err := WriteSignature(tw, manifestChecksumStore.GetRaw(), signer); err != nil {
+		// Write signatures.
+		if err := WriteSignatures(tw, manifestChecksumStore.GetRaw(), signers); err != nil {
 			return err
 		}
 		// Write the augmented manifest, if any.
@@ -434,14 +740,47 @@ func writeManifestVersion(
 			}
 		}
 	default:
-		return fmt.Errorf("writer: unsupported artifact version: %d", version)
+		return errors.Wrap(&artifact.ErrUnsupportedVersion{Got: version}, "writer")
+	}
+	return nil
+}
+
+// validateDeviceTypeScripts checks that state scripts for a device type are
+// only given for version 3 Artifacts compatible with that device type.
+func validateDeviceTypeScripts(args *WriteArtifactArgs) error {
+	if args.Scripts == nil {
+		return nil
+	}
+	compatible := append([]string{}, args.Devices...)
+	if args.Depends != nil {
+		compatible = append(compatible, args.Depends.CompatibleDevices...)
+	}
+	for _, deviceType := range args.Scripts.DeviceTypes() {
+		if args.Version < 3 {
+			return errors.New("state scripts for a device type require Artifact version 3")
+		}
+		found := false
+		for _, device := range compatible {
+			found = found || device == deviceType
+		}
+		if !found {
+			return errors.Errorf(
+				"state scripts given for device type %s, which is not compatible"+
+					" with the Artifact", deviceType)
+		}
 	}
 	return nil
 }
 
+// writeScripts stores all the state scripts in scripts/, where every reader
+// accepts them; the device types of the scripts are listed in the type-info.
 func writeScripts(tw *tar.Writer, scr *artifact.Scripts) error {
+	scripts := scr.Get()
+	for _, deviceType := range scr.DeviceTypes() {
+		scripts = append(scripts, scr.ForDeviceType(deviceType).Get()...)
+	}
 	sw := artifact.NewTarWriterFile(tw)
-	for _, script := range scr.Get() {
+	for _, script := range scripts {
 		f, err := os.Open(script)
 		if err != nil {
 			return errors.Wrapf(err, "writer: can not open script file: %s", script)
@@ -495,16 +834,15 @@ func writeHeader(tarWriter *tar.Writer, args *WriteArtifactArgs, augmented bool)
 	}
 
 	// write scripts
+	var deviceTypeScripts map[string][]string
 	if !augmented && args.Scripts != nil {
 		if err := writeScripts(tarWriter, args.Scripts); err != nil {
 			return err
 		}
+		deviceTypeScripts = args.Scripts.DeviceTypeNames()
 	}
 
 	for i, upd := range composers {
-		// TODO: We only have one `args` variable here, so making more
-		// than one update is kind of useless. Should probably be made
-		// into a list as well.
 		composeHeaderArgs := handlers.ComposeHeaderArgs{
 			TarWriter: tarWriter,
 			No:        i,
@@ -514,10 +852,37 @@ func writeHeader(tarWriter *tar.Writer, args *WriteArtifactArgs, augmented bool)
 		if augmented {
 			composeHeaderArgs.TypeInfoV3 = args.AugmentTypeInfoV3
 			composeHeaderArgs.MetaData = args.AugmentMetaData
+		} else if i < len(args.PayloadHeaders) {
+			composeHeaderArgs.TypeInfoV3 = args.PayloadHeaders[i].TypeInfoV3
+			composeHeaderArgs.MetaData = args.PayloadHeaders[i].MetaData
 		} else {
 			composeHeaderArgs.TypeInfoV3 = args.TypeInfoV3
 			composeHeaderArgs.MetaData = args.MetaData
 		}
+		if composeHeaderArgs.TypeInfoV3 != nil {
+			typeInfo, err := withInstallSize(composeHeaderArgs.TypeInfoV3, upd, augmented)
+			if err != nil {
+				return err
+			}
+			if args.PayloadSigner != nil && !augmented {
+				typeInfo, err = withPayloadSignatures(typeInfo, upd, args.PayloadSigner)
+				if err != nil {
+					return err
+				}
+			}
+			if !augmented {
+				// Every payload lists the state scripts of each
+				// device type, replacing what a copied type-info had.
+				original := *typeInfo
+				original.ImmutableMetadata = args.ImmutableMetadata
+				original.DeviceTypeScripts = deviceTypeScripts
+				typeInfo = &original
+			}
+			composeHeaderArgs.TypeInfoV3 = typeInfo
+		} else if !augmented && len(deviceTypeScripts) > 0 {
+			return errors.New(
+				"writer: state scripts for a device type require the type-info of every payload")
+		}
 		if err := upd.ComposeHeader(&composeHeaderArgs); err != nil {
 			return errors.Wrapf(err, "writer: error composing header")
 		}
@@ -525,98 +890,213 @@ func writeHeader(tarWriter *tar.Writer, args *WriteArtifactArgs, augmented bool)
 	return nil
 }
 
-func writeData(
-	tw *tar.Writer,
-	comp artifact.Compressor,
+// withInstallSize returns a copy of typeInfo with the install size filled in
+// from the sizes of the payload files, unless it was explicitly given. The
+// augmented header declares the size of all files, since it overrides the
+// original one. Readers which do not know the field ignore it.
+func withInstallSize(
+	typeInfo *artifact.TypeInfoV3,
+	upd handlers.Composer,
+	augmented bool,
+) (*artifact.TypeInfoV3, error) {
+	if typeInfo.InstallSize != 0 {
+		return typeInfo, nil
+	}
+	files := upd.GetUpdateFiles()
+	if augmented {
+		if len(upd.GetUpdateAugmentFiles()) == 0 {
+			return typeInfo, nil
+		}
+		files = upd.GetUpdateAllFiles()
+	}
+	var size int64
+	for _, file := range files {
+		fileSize, err := file.GetSize()
+		if err != nil {
+			return nil, errors.Wrapf(err, "writer: can not determine install size")
+		}
+		size += fileSize
+	}
+	withSize := *typeInfo
+	withSize.InstallSize = size
+	return &withSize, nil
+}
+
+// withPayloadSignatures returns a copy of typeInfo with signatures of the
+// payload files. The checksums of the files must already be calculated.
+// Readers which do not know the field ignore it, leaving the check to the
+// update modules.
+func withPayloadSignatures(
+	typeInfo *artifact.TypeInfoV3,
+	upd handlers.Composer,
+	signer artifact.Signer,
+) (*artifact.TypeInfoV3, error) {
+	signatures := make(map[string]string)
+	for _, file := range upd.GetUpdateFiles() {
+		sig, err := signer.Sign(file.Checksum)
+		if err != nil {
+			return nil, errors.Wrapf(err, "writer: can not sign payload file %s", file.Name)
+		}
+		signatures[file.GetPayloadName()] = string(sig)
+	}
+	withSignatures := *typeInfo
+	withSignatures.PayloadSignatures = signatures
+	return &withSignatures, nil
+}
+
+// composeData writes the data tars of all payloads to temporary files. This
+// is done before the headers are written, as streamed payload files only get
+// their checksums when they are read. writeData releases them one by one.
+func (aw *Writer) composeData(
 	updates *Updates,
-	pw ProgressWriter,
-) error {
+	dedup bool,
+) ([]*spillBuffer, []MemberStats, error) {
+	comp := aw.c
+	var dataTars []*spillBuffer
+	var stats []MemberStats
 	for i, upd := range updates.Updates {
 		var augment handlers.Composer = nil
 		if i < len(updates.Augments) {
 			augment = updates.Augments[i]
 		}
-		if err := writeOneDataTar(tw, comp, i, upd, augment, pw); err != nil {
-			return errors.Wrapf(err, "writer: error writing data files")
+		start := time.Now()
+		f := aw.newSpillBuffer("data")
+		dataTars = append(dataTars, f)
+		in, out, err := composeOneDataTar(comp, upd, augment, dedup, aw.checksumAlgorithm,
+			aw.ProgressWriter, f)
+		if err != nil {
+			return dataTars, stats, errors.Wrapf(err, "writer: error writing data files")
 		}
+		stats = append(stats, MemberStats{
+			Name:       artifact.UpdateDataPath(i) + comp.GetFileExtension(),
+			InputSize:  in,
+			OutputSize: out,
+			Duration:   time.Since(start),
+		})
 	}
-	return nil
+	return dataTars, stats, nil
 }
 
-func writeOneDataTar(tw *tar.Writer, comp artifact.Compressor, no int,
-	baseUpdate, augmentUpdate handlers.Composer, pw ProgressWriter) error {
+func removeDataTars(dataTars []*spillBuffer) {
+	for _, f := range dataTars {
+		f.Close()
+	}
+}
 
-	f, ferr := ioutil.TempFile("", "data")
-	if ferr != nil {
-		return errors.New("Payload: can not create temporary data file")
+func writeData(
+	tw *tar.Writer,
+	comp artifact.Compressor,
+	dataTars []*spillBuffer,
+) error {
+	for i, f := range dataTars {
+		if err := writeSpillBuffer(tw, f,
+			artifact.UpdateDataPath(i)+comp.GetFileExtension()); err != nil {
+			return errors.Wrap(err, "Payload: can not write tar data header")
+		}
+		// The temporary space of a payload is not needed once it is
+		// written, and the remaining ones may still be large.
+		f.Close()
 	}
-	defer os.Remove(f.Name())
+	return nil
+}
+
+// composeOneDataTar writes the data member of a payload into f, and returns
+// the sizes of the member before and after compression. With dedup, augment
+// files with the same content as an earlier file are stored as links to it.
+// The checksums of the files are calculated with algorithm.
+func composeOneDataTar(comp artifact.Compressor,
+	baseUpdate, augmentUpdate handlers.Composer, dedup bool, algorithm string,
+	pw ProgressWriter, f io.Writer) (int64, int64, error) {
 
+	out := &countingWriter{w: f}
+	in := &countingWriter{}
 	err := func() error {
-		gz, err := comp.NewWriter(f)
+		gz, err := comp.NewWriter(out)
 		if err != nil {
 			return errors.Wrap(err, "Could not open compressor")
 		}
 		defer gz.Close()
 
-		var w io.Writer
 		if pw != nil {
-			w = pw.Wrap(gz)
+			in.w = pw.Wrap(gz)
 		} else {
-			w = gz
+			in.w = gz
 		}
-		tarw := tar.NewWriter(w)
+		tarw := tar.NewWriter(in)
 		defer tarw.Close()
 
 		if len(baseUpdate.GetUpdateFiles()) == 0 && pw != nil {
 			pw.Reset(0, "bootstrap", 0)
 		}
+		// The payload names of the files written so far, by checksum.
+		written := map[string]string{}
+		remember := func(file *handlers.DataFile) error {
+			if !dedup {
+				return nil
+			}
+			sum, err := dataFileChecksum(file, algorithm)
+			if err != nil {
+				return err
+			}
+			if _, ok := written[string(sum)]; !ok {
+				written[string(sum)] = file.GetPayloadName()
+			}
+			return nil
+		}
 		for i, file := range baseUpdate.GetUpdateFiles() {
-			fi, err := os.Stat(file.Name)
+			size, err := file.GetSize()
 			if err != nil {
 				return err
 			}
 			if pw != nil {
-				pw.Reset(fi.Size(), file.Name, i)
+				pw.Reset(size, file.Name, i)
 			}
-			err = writeOneDataFile(tarw, file)
+			err = writeOneDataFile(tarw, file, algorithm)
 			if err != nil {
 				return err
 			}
 			if pw != nil {
 				pw.Finish()
 			}
+			if err = remember(file); err != nil {
+				return err
+			}
 		}
 		if augmentUpdate == nil {
 			return nil
 		}
 
 		for _, file := range augmentUpdate.GetUpdateAugmentFiles() {
-			err = writeOneDataFile(tarw, file)
+			// Streamed files are only known once written.
+			if dedup && file.Reader == nil {
+				sum, err := dataFileChecksum(file, algorithm)
+				if err != nil {
+					return err
+				}
+				if target, ok := written[string(sum)]; ok {
+					if err = writeDataFileLink(tarw, file, target); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			err = writeOneDataFile(tarw, file, algorithm)
 			if err != nil {
 				return err
 			}
+			if err = remember(file); err != nil {
+				return err
+			}
 		}
 		return nil
 	}()
-
-	if err != nil {
-		return err
-	}
-
-	if _, err = f.Seek(0, 0); err != nil {
-		return errors.Wrap(err, "Payload: can not reset file position")
-	}
-
-	dfw := artifact.NewTarWriterFile(tw)
-	if err = dfw.Write(f, artifact.UpdateDataPath(no)+comp.GetFileExtension()); err != nil {
-		return errors.Wrap(err, "Payload: can not write tar data header")
-	}
-	return nil
+	return in.n, out.n, err
 }
 
-func writeOneDataFile(tarw *tar.Writer, file *handlers.DataFile) error {
-	matched, err := regexp.MatchString(`^[\w\-.,]+$`, filepath.Base(file.Name))
+// checkDataFileName checks that the payload name of file only has allowed
+// characters.
+func checkDataFileName(file *handlers.DataFile) error {
+	matched, err := regexp.MatchString(`^[\w\-.,]+$`, file.GetPayloadName())
 
 	if err != nil {
 		return errors.Wrapf(err, "Payload: invalid regular expression pattern")
@@ -627,13 +1107,75 @@ func writeOneDataFile(tarw *tar.Writer, file *handlers.DataFile) error {
 		info := "Only letters, digits and characters in the set \".,_-\" are allowed"
 		return fmt.Errorf("%s. %s", message, info)
 	}
+	return nil
+}
 
+// dataFileChecksum returns the checksum of the content of file with
+// algorithm, which is read from disk unless it is known already.
+func dataFileChecksum(file *handlers.DataFile, algorithm string) ([]byte, error) {
+	if file.Checksum != nil && artifact.ChecksumAlgorithmOf(file.Checksum) == algorithm {
+		return file.Checksum, nil
+	}
+	ch := artifact.NewWriterChecksumAlgorithm(ioutil.Discard, algorithm)
 	df, err := os.Open(file.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Payload: can not open data file: %s", file.Name)
+	}
+	defer df.Close()
+	if _, err := io.Copy(ch, df); err != nil {
+		return nil, errors.Wrapf(err, "Payload: can not calculate checksum: %s", file.Name)
+	}
+	file.Checksum = ch.Checksum()
+	return file.Checksum, nil
+}
+
+// writeDataFileLink stores file as a hard link to target, an earlier member
+// of the data tar with the same content.
+func writeDataFileLink(tarw *tar.Writer, file *handlers.DataFile, target string) error {
+	if err := checkDataFileName(file); err != nil {
+		return err
+	}
+	info, err := os.Stat(file.Name)
 	if err != nil {
 		return errors.Wrapf(err, "Payload: can not open data file: %s", file.Name)
 	}
+	hdr := &tar.Header{
+		Typeflag: tar.TypeLink,
+		Name:     file.GetPayloadName(),
+		Linkname: target,
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+	}
+	artifact.SetPAXFormat(hdr)
+	if err = tarw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "Payload: can not write link for data file: %s", file.Name)
+	}
+	return nil
+}
+
+func writeOneDataFile(tarw *tar.Writer, file *handlers.DataFile, algorithm string) error {
+	if err := checkDataFileName(file); err != nil {
+		return err
+	}
+
 	fw := artifact.NewTarWriterFile(tarw)
-	if err := fw.Write(df, filepath.Base(file.Name)); err != nil {
+	if file.Reader != nil {
+		ch := artifact.NewWriterChecksumAlgorithm(ioutil.Discard, algorithm)
+		err := fw.WriteReader(io.TeeReader(file.Reader, ch), file.Size, file.Date,
+			file.GetPayloadName())
+		if err != nil {
+			return errors.Wrapf(err, "Payload: can not write streamed data file: %s",
+				file.Name)
+		}
+		file.Checksum = ch.Checksum()
+		return nil
+	}
+
+	df, err := os.Open(file.Name)
+	if err != nil {
+		return errors.Wrapf(err, "Payload: can not open data file: %s", file.Name)
+	}
+	if err := fw.Write(df, file.GetPayloadName()); err != nil {
 		df.Close()
 		return errors.Wrapf(err,
 			"Payload: can not write tar temp data header: %v", file)
@@ -0,0 +1,224 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package bench measures how fast Artifacts are written, read and validated,
+// so that performance regressions in the compression and checksum pipeline
+// can be spotted by comparing numbers between releases.
+package bench
+
+import (
+	"bufio"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+const (
+	MiB = 1024 * 1024
+	GiB = 1024 * MiB
+)
+
+// payloadChunkSize is the size of the chunks making up synthetic payloads.
+// Half of each chunk is random, and half is text from a four letter
+// alphabet, so that the compressors have work to do on all of it.
+const payloadChunkSize = 64 * 1024
+
+// payloadSeed makes synthetic payloads identical between runs.
+const payloadSeed = 42
+
+// Config describes a benchmark run.
+type Config struct {
+	// PayloadSize is the size of the synthetic payload, in bytes.
+	PayloadSize int64
+	// Compressor is the id of the compressor used for the Artifact.
+	Compressor string
+	// Dir holds the temporary payload and Artifact. The default temporary
+	// directory is used if it is empty.
+	Dir string
+}
+
+// Result holds the timings of a benchmark run.
+type Result struct {
+	PayloadSize  int64
+	Compressor   string
+	ArtifactSize int64
+	Write        time.Duration
+	Read         time.Duration
+	Validate     time.Duration
+}
+
+// Throughput returns the number of payload MiB processed per second in d.
+func (r *Result) Throughput(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(r.PayloadSize) / MiB / d.Seconds()
+}
+
+// MakePayload writes size bytes of synthetic payload to name. The same size
+// always gives the same contents.
+func MakePayload(name string, size int64) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return errors.Wrap(err, "bench: can not create payload")
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	rng := rand.New(rand.NewSource(payloadSeed))
+	chunk := make([]byte, payloadChunkSize)
+	for left := size; left > 0; left -= payloadChunkSize {
+		rng.Read(chunk)
+		for i := payloadChunkSize / 2; i < payloadChunkSize; i++ {
+			chunk[i] = 'a' + chunk[i]&3
+		}
+		n := int64(payloadChunkSize)
+		if left < n {
+			n = left
+		}
+		if _, err = w.Write(chunk[:n]); err != nil {
+			return errors.Wrap(err, "bench: can not write payload")
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return errors.Wrap(err, "bench: can not write payload")
+	}
+	return errors.Wrap(f.Close(), "bench: can not write payload")
+}
+
+// WriteArtifact writes a rootfs-image Artifact holding the payload file to
+// art, using the given compressor.
+func WriteArtifact(payload, art string, comp artifact.Compressor) error {
+	f, err := os.Create(art)
+	if err != nil {
+		return errors.Wrap(err, "bench: can not create Artifact")
+	}
+	defer f.Close()
+
+	updateType := "rootfs-image"
+	aw := awriter.NewWriter(f, comp)
+	err = aw.WriteArtifact(&awriter.WriteArtifactArgs{
+		Format:  "mender",
+		Version: 3,
+		Devices: []string{"bench-device"},
+		Name:    "bench",
+		Updates: &awriter.Updates{
+			Updates: []handlers.Composer{handlers.NewRootfsV3(payload)},
+		},
+		Provides: &artifact.ArtifactProvides{
+			ArtifactName: "bench",
+		},
+		Depends: &artifact.ArtifactDepends{
+			CompatibleDevices: []string{"bench-device"},
+		},
+		TypeInfoV3: &artifact.TypeInfoV3{
+			Type: &updateType,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "bench: can not write Artifact")
+	}
+	return errors.Wrap(f.Close(), "bench: can not write Artifact")
+}
+
+// ReadArtifact reads the Artifact the way a device installing it does,
+// streaming the payload to a rootfs-image handler which discards it.
+func ReadArtifact(art string) error {
+	f, err := os.Open(art)
+	if err != nil {
+		return errors.Wrap(err, "bench: can not open Artifact")
+	}
+	defer f.Close()
+
+	ar := areader.NewReader(f)
+	if err = ar.RegisterHandler(handlers.NewRootfsInstaller()); err != nil {
+		return errors.Wrap(err, "bench: can not register handler")
+	}
+	return errors.Wrap(ar.ReadArtifact(), "bench: can not read Artifact")
+}
+
+// ValidateArtifact checks the structure and the checksums of the Artifact
+// without any handlers, as 'mender-artifact validate' does.
+func ValidateArtifact(art string) error {
+	f, err := os.Open(art)
+	if err != nil {
+		return errors.Wrap(err, "bench: can not open Artifact")
+	}
+	defer f.Close()
+
+	ar := areader.NewReader(f)
+	return errors.Wrap(ar.ReadArtifact(), "bench: can not validate Artifact")
+}
+
+func timed(fn func() error) (time.Duration, error) {
+	start := time.Now()
+	err := fn()
+	return time.Since(start), err
+}
+
+// Run writes, reads and validates an Artifact as described by cfg, and
+// returns how long each step took. Creating the payload is not timed.
+func Run(cfg Config) (*Result, error) {
+	comp, err := artifact.NewCompressorFromId(cfg.Compressor)
+	if err != nil {
+		return nil, errors.Wrap(err, "bench")
+	}
+	dir, err := utils.TempDir(cfg.Dir, "bench")
+	if err != nil {
+		return nil, errors.Wrap(err, "bench: can not create directory")
+	}
+	defer utils.RemoveTemp(dir)
+
+	payload := filepath.Join(dir, "payload")
+	if err = MakePayload(payload, cfg.PayloadSize); err != nil {
+		return nil, err
+	}
+	art := filepath.Join(dir, "artifact.mender")
+
+	res := &Result{PayloadSize: cfg.PayloadSize, Compressor: cfg.Compressor}
+	if res.Write, err = timed(func() error {
+		return WriteArtifact(payload, art, comp)
+	}); err != nil {
+		return nil, err
+	}
+	// The payload is not needed anymore; leave room for large runs.
+	if err = os.Remove(payload); err != nil {
+		return nil, errors.Wrap(err, "bench: can not remove payload")
+	}
+	info, err := os.Stat(art)
+	if err != nil {
+		return nil, errors.Wrap(err, "bench: can not stat Artifact")
+	}
+	res.ArtifactSize = info.Size()
+	if res.Read, err = timed(func() error {
+		return ReadArtifact(art)
+	}); err != nil {
+		return nil, err
+	}
+	if res.Validate, err = timed(func() error {
+		return ValidateArtifact(art)
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// benchSizes returns the payload sizes to benchmark with; the 1GiB and 8GiB
+// payloads need TEST_LARGE_ARTIFACTS to be set.
+func benchSizes() []int64 {
+	if os.Getenv("TEST_LARGE_ARTIFACTS") == "" {
+		return []int64{100 * MiB}
+	}
+	return []int64{100 * MiB, GiB, 8 * GiB}
+}
+
+func sizeName(size int64) string {
+	if size >= GiB {
+		return fmt.Sprintf("%dGiB", size/GiB)
+	}
+	return fmt.Sprintf("%dMiB", size/MiB)
+}
+
+func TestMakePayload(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	first := filepath.Join(tmpdir, "first")
+	require.NoError(t, MakePayload(first, 3*payloadChunkSize/2))
+	second := filepath.Join(tmpdir, "second")
+	require.NoError(t, MakePayload(second, 3*payloadChunkSize/2))
+
+	firstData, err := ioutil.ReadFile(first)
+	require.NoError(t, err)
+	secondData, err := ioutil.ReadFile(second)
+	require.NoError(t, err)
+	assert.Len(t, firstData, 3*payloadChunkSize/2)
+	assert.Equal(t, firstData, secondData)
+	// The second half of every chunk is compressible text.
+	assert.Empty(t, bytes.Trim(firstData[payloadChunkSize/2:payloadChunkSize], "abcd"))
+}
+
+func TestRun(t *testing.T) {
+	for _, id := range artifact.GetRegisteredCompressorIds() {
+		t.Run(id, func(t *testing.T) {
+			res, err := Run(Config{PayloadSize: MiB, Compressor: id, Dir: t.TempDir()})
+			require.NoError(t, err)
+			assert.Equal(t, int64(MiB), res.PayloadSize)
+			assert.Equal(t, id, res.Compressor)
+			assert.NotZero(t, res.ArtifactSize)
+			assert.NotZero(t, res.Write)
+			assert.NotZero(t, res.Read)
+			assert.NotZero(t, res.Validate)
+			assert.NotZero(t, res.Throughput(res.Write))
+		})
+	}
+
+	_, err := Run(Config{PayloadSize: MiB, Compressor: "bogus"})
+	assert.Error(t, err)
+}
+
+// benchArtifacts runs fn for every payload size and compressor, with a
+// payload and an Artifact of that size prepared beforehand.
+func benchArtifacts(b *testing.B, fn func(b *testing.B, payload, art string,
+	comp artifact.Compressor)) {
+
+	for _, size := range benchSizes() {
+		b.Run(sizeName(size), func(b *testing.B) {
+			tmpdir := b.TempDir()
+			payload := filepath.Join(tmpdir, "payload")
+			require.NoError(b, MakePayload(payload, size))
+			for _, id := range artifact.GetRegisteredCompressorIds() {
+				b.Run(id, func(b *testing.B) {
+					comp, err := artifact.NewCompressorFromId(id)
+					require.NoError(b, err)
+					art := filepath.Join(tmpdir, id+".mender")
+					require.NoError(b, WriteArtifact(payload, art, comp))
+					defer os.Remove(art)
+
+					b.SetBytes(size)
+					b.ResetTimer()
+					fn(b, payload, art, comp)
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	benchArtifacts(b, func(b *testing.B, payload, art string, comp artifact.Compressor) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, WriteArtifact(payload, art, comp))
+		}
+	})
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchArtifacts(b, func(b *testing.B, payload, art string, comp artifact.Compressor) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, ReadArtifact(art))
+		}
+	})
+}
+
+func BenchmarkValidate(b *testing.B) {
+	benchArtifacts(b, func(b *testing.B, payload, art string, comp artifact.Compressor) {
+		for i := 0; i < b.N; i++ {
+			require.NoError(b, ValidateArtifact(art))
+		}
+	})
+}
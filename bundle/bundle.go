@@ -0,0 +1,374 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package bundle packages several Artifacts which make up a release, like an
+// application, a root filesystem and a configuration Artifact, into a single
+// file which can be verified as a unit.
+//
+// A bundle is an uncompressed tar archive holding, in this order, the index
+// listing the Artifacts with their checksums, optionally the signature of the
+// index, and the Artifacts themselves. Signing the index covers every
+// Artifact through its checksum.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+const (
+	// Format identifies the index of a bundle.
+	Format = "mender-bundle"
+	// Version is the version of the bundle format.
+	Version = 1
+
+	// IndexName is the name of the index in the bundle.
+	IndexName = "index.json"
+	// SignatureName is the name of the signature of the index.
+	SignatureName = "index.json.sig"
+)
+
+var (
+	ErrNotSigned       = errors.New("bundle is not signed")
+	ErrMissingVerifier = errors.New("missing verifier")
+)
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Artifact is an entry of the index of a bundle.
+type Artifact struct {
+	// Name of the Artifact within the release, e.g. "rootfs".
+	Name string `json:"name"`
+	// File is the name of the Artifact in the bundle.
+	File         string   `json:"file"`
+	ArtifactName string   `json:"artifact_name"`
+	DeviceTypes  []string `json:"device_types"`
+	Size         int64    `json:"size"`
+	// Checksum is the hex encoded sha256 checksum of the Artifact.
+	Checksum string `json:"checksum"`
+}
+
+// Index lists the Artifacts of a bundle.
+type Index struct {
+	Format    string     `json:"format"`
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// ReleaseArtifact is an Artifact of a Release.
+type ReleaseArtifact struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+}
+
+// Release describes the Artifacts to bundle, as read from a release file:
+//
+//	name: release-1
+//	artifacts:
+//	  - name: rootfs
+//	    path: rootfs.mender
+//	  - name: app
+//	    path: app.mender
+type Release struct {
+	Name      string            `yaml:"name"`
+	Artifacts []ReleaseArtifact `yaml:"artifacts"`
+}
+
+// ReadRelease reads the release file at path. Relative paths of Artifacts are
+// relative to the directory of the release file.
+func ReadRelease(path string) (*Release, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read release file")
+	}
+	var release Release
+	if err = yaml.Unmarshal(data, &release); err != nil {
+		return nil, errors.Wrapf(err, "invalid release file %s", path)
+	}
+	for i, a := range release.Artifacts {
+		if a.Path != "" && !filepath.IsAbs(a.Path) {
+			release.Artifacts[i].Path = filepath.Join(filepath.Dir(path), a.Path)
+		}
+	}
+	if err = release.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid release file %s", path)
+	}
+	return &release, nil
+}
+
+// Validate checks that the release has a name and Artifacts with unique
+// names and paths.
+func (r *Release) Validate() error {
+	if r.Name == "" {
+		return errors.New("the release has no name")
+	}
+	if len(r.Artifacts) == 0 {
+		return errors.New("the release has no artifacts")
+	}
+	names := map[string]bool{}
+	for _, a := range r.Artifacts {
+		if !namePattern.MatchString(a.Name) {
+			return errors.Errorf("invalid artifact name %q", a.Name)
+		}
+		if names[a.Name] {
+			return errors.Errorf("duplicate artifact name %q", a.Name)
+		}
+		names[a.Name] = true
+		if a.Path == "" {
+			return errors.Errorf("artifact %q has no path", a.Name)
+		}
+	}
+	return nil
+}
+
+// hashingReader hashes and counts everything read through it.
+type hashingReader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, hash: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+func (h *hashingReader) checksum() string {
+	return hex.EncodeToString(h.hash.Sum(nil))
+}
+
+// readArtifact reads the whole Artifact from r, checking the checksums of
+// its files, and returns its entry for the index.
+func readArtifact(r io.Reader) (Artifact, error) {
+	hr := newHashingReader(r)
+	ar := areader.NewReader(hr)
+	if err := ar.ReadArtifact(); err != nil {
+		return Artifact{}, err
+	}
+	// Hash whatever follows the last file the reader needed.
+	if _, err := io.Copy(ioutil.Discard, hr); err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{
+		ArtifactName: ar.GetArtifactName(),
+		DeviceTypes:  ar.GetCompatibleDevices(),
+		Size:         hr.size,
+		Checksum:     hr.checksum(),
+	}, nil
+}
+
+func readArtifactFile(path string) (Artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer f.Close()
+	return readArtifact(f)
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "can not write %s", name)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return errors.Wrapf(err, "can not write %s", name)
+	}
+	return nil
+}
+
+// copyArtifact copies the Artifact at path into the bundle, making sure it
+// did not change since it was added to the index.
+func copyArtifact(tw *tar.Writer, a Artifact, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hr := newHashingReader(io.LimitReader(f, a.Size))
+	if err = writeTarFile(tw, a.File, a.Size, hr); err != nil {
+		return err
+	}
+	if hr.checksum() != a.Checksum {
+		return errors.Errorf("artifact %s changed while creating the bundle", path)
+	}
+	return nil
+}
+
+// Create writes the bundle of the Artifacts of the release to w, and signs
+// its index with signer unless it is nil. Every Artifact is read in full
+// first, so that a damaged Artifact is never bundled.
+func Create(w io.Writer, release *Release, signer artifact.Signer) (*Index, error) {
+	if err := release.Validate(); err != nil {
+		return nil, err
+	}
+	index := &Index{
+		Format:  Format,
+		Version: Version,
+		Name:    release.Name,
+	}
+	for _, ra := range release.Artifacts {
+		a, err := readArtifactFile(ra.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid artifact %s", ra.Path)
+		}
+		a.Name = ra.Name
+		a.File = fmt.Sprintf("artifacts/%s.mender", ra.Name)
+		index.Artifacts = append(index.Artifacts, a)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "can not encode bundle index")
+	}
+	tw := tar.NewWriter(w)
+	err = writeTarFile(tw, IndexName, int64(len(data)), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		sig, err := signer.Sign(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not sign bundle index")
+		}
+		err = writeTarFile(tw, SignatureName, int64(len(sig)), bytes.NewReader(sig))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, a := range index.Artifacts {
+		if err = copyArtifact(tw, a, release.Artifacts[i].Path); err != nil {
+			return nil, err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "can not write bundle")
+	}
+	return index, nil
+}
+
+func readIndex(tr *tar.Reader) (*Index, []byte, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can not read bundle index")
+	}
+	if hdr.Name != IndexName {
+		return nil, nil, errors.Errorf("invalid bundle: expected %s, found %s",
+			IndexName, hdr.Name)
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can not read bundle index")
+	}
+	var index Index
+	if err = json.Unmarshal(data, &index); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid bundle index")
+	}
+	if index.Format != Format {
+		return nil, nil, errors.Errorf("invalid bundle format %q", index.Format)
+	}
+	if index.Version != Version {
+		return nil, nil, errors.Errorf("unsupported bundle version %d", index.Version)
+	}
+	return &index, data, nil
+}
+
+// Verify reads the bundle from r and checks the signature of its index with
+// verifier, and every Artifact in it against the index. A signed bundle needs
+// a verifier, and a verifier needs a signed bundle.
+func Verify(r io.Reader, verifier artifact.Verifier) (*Index, error) {
+	tr := tar.NewReader(r)
+	index, data, err := readIndex(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is nil at the end of the bundle.
+	hdr, err := tr.Next()
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "can not read bundle")
+	}
+	signed := hdr != nil && hdr.Name == SignatureName
+	switch {
+	case signed && verifier == nil:
+		return nil, ErrMissingVerifier
+	case !signed && verifier != nil:
+		return nil, ErrNotSigned
+	case signed:
+		sig, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not read bundle signature")
+		}
+		if err = verifier.Verify(data, sig); err != nil {
+			return nil, errors.Wrap(err, "invalid bundle signature")
+		}
+		if hdr, err = tr.Next(); err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "can not read bundle")
+		}
+	}
+
+	for _, expected := range index.Artifacts {
+		if hdr == nil || hdr.Name != expected.File {
+			return nil, errors.Errorf("invalid bundle: %s is missing", expected.File)
+		}
+		a, err := readArtifact(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid artifact %s", expected.File)
+		}
+		if a.Size != expected.Size || a.Checksum != expected.Checksum {
+			return nil, errors.Errorf("invalid bundle: checksum mismatch of %s",
+				expected.File)
+		}
+		if a.ArtifactName != expected.ArtifactName {
+			return nil, errors.Errorf("invalid bundle: %s is %s, not %s",
+				expected.File, a.ArtifactName, expected.ArtifactName)
+		}
+		if hdr, err = tr.Next(); err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "can not read bundle")
+		}
+	}
+	if hdr != nil {
+		return nil, errors.Errorf("invalid bundle: unexpected %s", hdr.Name)
+	}
+	return index, nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package bundle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+func writeTestArtifact(t *testing.T, dir, name string) string {
+	upd := filepath.Join(dir, name+".ext4")
+	require.NoError(t, os.WriteFile(upd, []byte("update of "+name), 0644))
+
+	path := filepath.Join(dir, name+".mender")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	aw := awriter.NewWriter(f, artifact.NewCompressorGzip())
+	err = aw.WriteArtifact(&awriter.WriteArtifactArgs{
+		Format:  "mender",
+		Version: 3,
+		Devices: []string{"vexpress"},
+		Name:    name,
+		Updates: &awriter.Updates{Updates: []handlers.Composer{handlers.NewRootfsV3(upd)}},
+		Provides: &artifact.ArtifactProvides{
+			ArtifactName: name,
+		},
+		Depends: &artifact.ArtifactDepends{
+			CompatibleDevices: []string{"vexpress"},
+		},
+	})
+	require.NoError(t, err)
+	return path
+}
+
+func writeTestRelease(t *testing.T) string {
+	dir := t.TempDir()
+	writeTestArtifact(t, dir, "rootfs-1")
+	writeTestArtifact(t, dir, "app-1")
+	path := filepath.Join(dir, "release.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`name: release-1
+artifacts:
+  - name: rootfs
+    path: rootfs-1.mender
+  - name: app
+    path: app-1.mender
+`), 0644))
+	return path
+}
+
+func makeTestKeys(t *testing.T) (*artifact.PKISigner, *artifact.PKISigner) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+
+	signer, err := artifact.NewPKISigner(
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}))
+	require.NoError(t, err)
+	verifier, err := artifact.NewPKIVerifier(
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+	require.NoError(t, err)
+	return signer, verifier
+}
+
+func TestReadRelease(t *testing.T) {
+	path := writeTestRelease(t)
+	release, err := ReadRelease(path)
+	require.NoError(t, err)
+	assert.Equal(t, "release-1", release.Name)
+	require.Len(t, release.Artifacts, 2)
+	assert.Equal(t, filepath.Join(filepath.Dir(path), "rootfs-1.mender"),
+		release.Artifacts[0].Path)
+
+	for _, test := range []struct {
+		release Release
+		err     string
+	}{
+		{Release{}, "no name"},
+		{Release{Name: "r"}, "no artifacts"},
+		{Release{Name: "r", Artifacts: []ReleaseArtifact{{Name: "../a", Path: "a"}}},
+			"invalid artifact name"},
+		{Release{Name: "r", Artifacts: []ReleaseArtifact{{Name: "a"}}}, "has no path"},
+		{Release{Name: "r", Artifacts: []ReleaseArtifact{
+			{Name: "a", Path: "a"}, {Name: "a", Path: "b"}}}, "duplicate artifact name"},
+	} {
+		err := test.release.Validate()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), test.err)
+	}
+}
+
+func TestCreateAndVerify(t *testing.T) {
+	release, err := ReadRelease(writeTestRelease(t))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	index, err := Create(&buf, release, nil)
+	require.NoError(t, err)
+	require.Len(t, index.Artifacts, 2)
+	assert.Equal(t, "artifacts/rootfs.mender", index.Artifacts[0].File)
+	assert.Equal(t, "rootfs-1", index.Artifacts[0].ArtifactName)
+	assert.Equal(t, []string{"vexpress"}, index.Artifacts[1].DeviceTypes)
+
+	verified, err := Verify(bytes.NewReader(buf.Bytes()), nil)
+	require.NoError(t, err)
+	assert.Equal(t, index, verified)
+
+	_, verifier := makeTestKeys(t)
+	_, err = Verify(bytes.NewReader(buf.Bytes()), verifier)
+	assert.Equal(t, ErrNotSigned, err)
+
+	// Damage the last Artifact.
+	damaged := append([]byte{}, buf.Bytes()...)
+	i := bytes.LastIndex(damaged, []byte("artifacts/app.mender"))
+	require.NotEqual(t, -1, i)
+	damaged[i+512+100] ^= 0xff
+	_, err = Verify(bytes.NewReader(damaged), nil)
+	assert.Error(t, err)
+}
+
+func TestCreateAndVerifySigned(t *testing.T) {
+	release, err := ReadRelease(writeTestRelease(t))
+	require.NoError(t, err)
+	signer, verifier := makeTestKeys(t)
+
+	var buf bytes.Buffer
+	_, err = Create(&buf, release, signer)
+	require.NoError(t, err)
+
+	index, err := Verify(bytes.NewReader(buf.Bytes()), verifier)
+	require.NoError(t, err)
+	assert.Equal(t, "release-1", index.Name)
+
+	_, err = Verify(bytes.NewReader(buf.Bytes()), nil)
+	assert.Equal(t, ErrMissingVerifier, err)
+
+	_, otherVerifier := makeTestKeys(t)
+	_, err = Verify(bytes.NewReader(buf.Bytes()), otherVerifier)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid bundle signature")
+}
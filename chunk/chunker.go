@@ -0,0 +1,127 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package chunk stores Artifacts as content-defined chunks, so that data
+// which is shared between Artifacts is only stored once.
+package chunk
+
+import (
+	"io"
+)
+
+// Chunk size limits. Boundaries are placed where the content matches a
+// pattern, so the same data gets the same boundaries wherever it is in a
+// file, and chunks average about AvgSize bytes.
+const (
+	MinSize = 16 * 1024
+	AvgSize = 64 * 1024
+	MaxSize = 256 * 1024
+)
+
+// FastCDC normalized chunking masks: a harder to match mask before AvgSize
+// and an easier one after it. The gear hash shifts left, so the high bits
+// depend on the most bytes.
+const (
+	maskS = uint64(1<<18-1) << (64 - 18)
+	maskL = uint64(1<<14-1) << (64 - 14)
+)
+
+// gear holds the random values of the gear hash. It must never change, as
+// that would move the boundaries of all chunks.
+var gear [256]uint64
+
+func init() {
+	// splitmix64, seeded with a fixed value.
+	x := uint64(0x6d656e646572)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// cutPoint returns the length of the first chunk of data, which is at most
+// MaxSize bytes long.
+func cutPoint(data []byte) int {
+	n := len(data)
+	if n > MaxSize {
+		n = MaxSize
+	}
+	if n <= MinSize {
+		return n
+	}
+	normal := AvgSize
+	if n < normal {
+		normal = n
+	}
+
+	var h uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Chunker splits a stream into content-defined chunks using FastCDC.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+	// data is the part of buf which has not been returned yet.
+	data []byte
+	eof  bool
+}
+
+func NewChunker(r io.Reader) *Chunker {
+	return &Chunker{
+		r:   r,
+		buf: make([]byte, 2*MaxSize),
+	}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if len(c.data) < MaxSize && !c.eof {
+		n := copy(c.buf, c.data)
+		for n < MaxSize && !c.eof {
+			m, err := c.r.Read(c.buf[n:])
+			n += m
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		c.data = c.buf[:n]
+	}
+	if len(c.data) == 0 {
+		return nil, io.EOF
+	}
+	cut := cutPoint(c.data)
+	chunk := c.data[:cut]
+	c.data = c.data[cut:]
+	return chunk, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	var result [][]byte
+	c := NewChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return result
+		}
+		require.NoError(t, err)
+		result = append(result, append([]byte{}, chunk...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(1, 4*1024*1024)
+	result := chunks(t, data)
+
+	assert.Equal(t, data, bytes.Join(result, nil))
+	for i, chunk := range result {
+		assert.LessOrEqual(t, len(chunk), MaxSize)
+		if i < len(result)-1 {
+			assert.GreaterOrEqual(t, len(chunk), MinSize)
+		}
+	}
+	// The average is close to AvgSize for random data.
+	avg := len(data) / len(result)
+	assert.True(t, avg > AvgSize/2 && avg < 2*AvgSize, avg)
+
+	assert.Empty(t, chunks(t, nil))
+	assert.Equal(t, [][]byte{[]byte("short")}, chunks(t, []byte("short")))
+}
+
+func TestChunkerInsertion(t *testing.T) {
+	data := randomData(2, 2*1024*1024)
+	original := map[string]bool{}
+	for _, chunk := range chunks(t, data) {
+		original[string(chunk)] = true
+	}
+
+	// Inserting data only changes the chunks around the insertion.
+	modified := append(append(append([]byte{}, data[:1000000]...),
+		[]byte("inserted")...), data[1000000:]...)
+	result := chunks(t, modified)
+	var changed int
+	for _, chunk := range result {
+		if !original[string(chunk)] {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 2)
+}
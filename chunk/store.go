@@ -0,0 +1,197 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package chunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+var digestRe = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Ref refers to a chunk in a Store.
+type Ref struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// Index lists the chunks an Artifact is made of.
+type Index struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Chunks []Ref  `json:"chunks"`
+}
+
+// Stats tells how much of an Artifact was new to a Store.
+type Stats struct {
+	Chunks    int
+	NewChunks int
+	NewBytes  int64
+}
+
+// Store is a directory of chunks, each stored once under its digest, and
+// of indexes listing the chunks of each Artifact.
+type Store struct {
+	dir string
+}
+
+// OpenStore opens the store in dir, creating it if needed.
+func OpenStore(dir string) (*Store, error) {
+	for _, sub := range []string{"chunks", "artifacts"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, errors.Wrap(err, "can not create chunk store")
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (s *Store) chunkPath(digest string) string {
+	hexDigest := strings.TrimPrefix(digest, "sha256:")
+	return filepath.Join(s.dir, "chunks", hexDigest[:2], hexDigest)
+}
+
+func (s *Store) indexPath(digest string) string {
+	return filepath.Join(s.dir, "artifacts", strings.TrimPrefix(digest, "sha256:")+".json")
+}
+
+// writeFile writes a file in the store atomically, so that a store is never
+// left with partial chunks or indexes.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := utils.TempFile(filepath.Dir(path), "chunk")
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Put splits the Artifact read from r into chunks, stores the ones which
+// the store does not have yet, and stores its index.
+func (s *Store) Put(name string, r io.Reader) (*Index, *Stats, error) {
+	index := &Index{Name: name}
+	stats := &Stats{}
+	h := sha256.New()
+	chunker := NewChunker(io.TeeReader(r, h))
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, errors.Wrap(err, "can not read artifact")
+		}
+		ref := Ref{Digest: digestOf(data), Size: int64(len(data))}
+		index.Chunks = append(index.Chunks, ref)
+		index.Size += ref.Size
+		stats.Chunks++
+
+		path := s.chunkPath(ref.Digest)
+		if _, err = os.Stat(path); err == nil {
+			continue
+		}
+		if err = writeFile(path, data); err != nil {
+			return nil, nil, errors.Wrap(err, "can not store chunk")
+		}
+		stats.NewChunks++
+		stats.NewBytes += ref.Size
+	}
+	index.Digest = "sha256:" + hex.EncodeToString(h.Sum(nil))
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writeFile(s.indexPath(index.Digest), data); err != nil {
+		return nil, nil, errors.Wrap(err, "can not store artifact index")
+	}
+	return index, stats, nil
+}
+
+// Index returns the index of the Artifact with the given digest. The
+// "sha256:" prefix of the digest may be left out.
+func (s *Store) Index(digest string) (*Index, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		digest = "sha256:" + digest
+	}
+	if !digestRe.MatchString(digest) {
+		return nil, errors.Errorf("invalid artifact digest: %q", digest)
+	}
+	data, err := ioutil.ReadFile(s.indexPath(digest))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("no artifact with digest %s in the store", digest)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "can not read artifact index")
+	}
+	index := new(Index)
+	if err = json.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrap(err, "invalid artifact index")
+	}
+	for _, ref := range index.Chunks {
+		if !digestRe.MatchString(ref.Digest) {
+			return nil, errors.Errorf("invalid chunk digest in index: %q", ref.Digest)
+		}
+	}
+	return index, nil
+}
+
+// Get writes the Artifact described by index to w, verifying every chunk
+// and the whole Artifact on the way.
+func (s *Store) Get(index *Index, w io.Writer) error {
+	h := sha256.New()
+	w = io.MultiWriter(w, h)
+	for _, ref := range index.Chunks {
+		data, err := ioutil.ReadFile(s.chunkPath(ref.Digest))
+		if err != nil {
+			return errors.Wrapf(err, "can not read chunk %s", ref.Digest)
+		}
+		if int64(len(data)) != ref.Size || digestOf(data) != ref.Digest {
+			return errors.Errorf("chunk %s is corrupt", ref.Digest)
+		}
+		if _, err = w.Write(data); err != nil {
+			return err
+		}
+	}
+	if digest := "sha256:" + hex.EncodeToString(h.Sum(nil)); digest != index.Digest {
+		return errors.Errorf("reconstructed artifact has digest %s; expected %s",
+			digest, index.Digest)
+	}
+	return nil
+}
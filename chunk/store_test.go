@@ -0,0 +1,68 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	require.NoError(t, err)
+
+	first := randomData(3, 1024*1024)
+	index, stats, err := store.Put("first.mender", bytes.NewReader(first))
+	require.NoError(t, err)
+	assert.Equal(t, "first.mender", index.Name)
+	assert.Equal(t, int64(len(first)), index.Size)
+	assert.Equal(t, stats.Chunks, stats.NewChunks)
+	assert.Equal(t, int64(len(first)), stats.NewBytes)
+
+	second := append(append([]byte{}, first...), randomData(4, 100)...)
+	index2, stats, err := store.Put("second.mender", bytes.NewReader(second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.NewChunks)
+	assert.Less(t, stats.NewBytes, int64(MaxSize))
+
+	for _, test := range []struct {
+		index *Index
+		data  []byte
+	}{{index, first}, {index2, second}} {
+		loaded, err := store.Index(test.index.Digest[len("sha256:"):])
+		require.NoError(t, err)
+		assert.Equal(t, test.index, loaded)
+
+		var buf bytes.Buffer
+		require.NoError(t, store.Get(loaded, &buf))
+		assert.Equal(t, test.data, buf.Bytes())
+	}
+
+	_, err = store.Index("sha256:" + string(bytes.Repeat([]byte("0"), 64)))
+	assert.Contains(t, err.Error(), "no artifact")
+	_, err = store.Index("../../etc/passwd")
+	assert.Contains(t, err.Error(), "invalid artifact digest")
+
+	// Corrupt chunks are detected.
+	path := store.chunkPath(index.Chunks[0].Digest)
+	require.NoError(t, writeFile(path, []byte("corrupt")))
+	err = store.Get(index, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is corrupt")
+}
@@ -15,7 +15,7 @@
 package cli
 
 import (
-	"context"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -24,11 +24,9 @@ import (
 
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
-	"github.com/mendersoftware/mender-artifact/artifact/gcp"
-	"github.com/mendersoftware/mender-artifact/artifact/keyfactor"
-	"github.com/mendersoftware/mender-artifact/artifact/vault"
 	"github.com/mendersoftware/mender-artifact/awriter"
 	"github.com/mendersoftware/mender-artifact/handlers"
+	"github.com/mendersoftware/mender-artifact/utils"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
@@ -39,7 +37,9 @@ type unpackedArtifact struct {
 	unpackDir string
 	ar        *areader.Reader
 	scripts   []string
-	files     []string
+	// Scripts which only run on a single device type, by device type.
+	deviceTypeScripts map[string][]string
+	files             []string
 
 	// Args needed to reconstruct the artifact
 	writeArgs *awriter.WriteArtifactArgs
@@ -68,7 +68,10 @@ func (w *writeUpdateStorer) PrepareStoreUpdate() error {
 }
 
 func (w *writeUpdateStorer) StoreUpdate(r io.Reader, info os.FileInfo) error {
-	fullpath := filepath.Join(w.dir, info.Name())
+	fullpath, err := artifact.JoinArchivePath(w.dir, info.Name())
+	if err != nil {
+		return err
+	}
 	w.names = append(w.names, fullpath)
 	fd, err := os.OpenFile(fullpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
@@ -125,67 +128,79 @@ type SigningKey interface {
 	artifact.Verifier
 }
 
+// keyCommands tells what the commands taking signing keys need them for.
+var keyCommands = map[string]KeyUsage{
+	"validate":           KeyUsageVerify,
+	"validate-batch":     KeyUsageVerify,
+	"read":               KeyUsageVerify,
+	"browse":             KeyUsageVerify,
+	"audit":              KeyUsageVerify,
+	"inspect-signature":  KeyUsageVerify,
+	"preflight-verify":   KeyUsageVerify,
+	"rootfs-image":       KeyUsageSign,
+	"module-image":       KeyUsageSign,
+	"src-tarball":        KeyUsageSign,
+	"bootstrap-artifact": KeyUsageSign,
+	"sign":               KeyUsageSign,
+	"modify":             KeyUsageSign,
+	"upgrade":            KeyUsageSign,
+	"clone":              KeyUsageSign,
+	"merge":              KeyUsageSign,
+	"cp":                 KeyUsageSign,
+	"serve":              KeyUsageSign,
+	// Subcommands of bundle.
+	"create": KeyUsageSign,
+	"verify": KeyUsageVerify,
+}
+
 func getKey(c *cli.Context) (SigningKey, error) {
 	var chosenOptions []string
-	possibleOptions := []string{
-		"key",
-		"gcp-kms-key",
-		"vault-transit-key",
-		"key-pkcs11",
-		"keyfactor-signserver-worker",
-	}
-	for _, optName := range possibleOptions {
-		if c.String(optName) == "" {
+	var provider, argument string
+	for _, f := range keyProviderFlags {
+		if c.String(f.flag) == "" {
 			continue
 		}
-		chosenOptions = append(chosenOptions, optName)
+		chosenOptions = append(chosenOptions, f.flag)
+		provider, argument = f.provider, c.String(f.flag)
+	}
+	if spec := c.String("key-provider"); spec != "" {
+		chosenOptions = append(chosenOptions, "key-provider")
+		var err error
+		if provider, argument, err = parseKeyProvider(spec); err != nil {
+			return nil, err
+		}
 	}
 	if len(chosenOptions) == 0 {
 		return nil, nil
 	} else if len(chosenOptions) > 1 {
 		return nil, fmt.Errorf("too many signing keys given: %v", chosenOptions)
 	}
-	switch chosenOption := chosenOptions[0]; chosenOption {
-	case "key":
-		key, err := ioutil.ReadFile(c.String("key"))
-		if err != nil {
-			return nil, errors.Wrap(err, "Error reading key file")
-		}
 
-		// The "key" flag can either be public or private depending on the
-		// command name. Explicitly map each command's name to which one it
-		// should be, so we return the correct key type.
-		publicKeyCommands := map[string]bool{
-			"validate": true,
-			"read":     true,
-		}
-		privateKeyCommands := map[string]bool{
-			"rootfs-image":       true,
-			"module-image":       true,
-			"bootstrap-artifact": true,
-			"sign":               true,
-			"modify":             true,
-			"copy":               true,
-		}
-		if publicKeyCommands[c.Command.Name] {
-			return artifact.NewPKIVerifier(key)
-		}
-		if privateKeyCommands[c.Command.Name] {
-			return artifact.NewPKISigner(key)
-		}
+	// Flags like "key" can either be public or private depending on the
+	// command name, so each command explicitly says which one it needs.
+	usage, ok := keyCommands[c.Command.Name]
+	if !ok {
 		return nil, fmt.Errorf("unsupported command %q with %q flag, "+
-			"please add command to allowlist", c.Command.Name, "key")
-	case "gcp-kms-key":
-		return gcp.NewKMSSigner(context.TODO(), c.String("gcp-kms-key"))
-	case "vault-transit-key":
-		return vault.NewVaultSigner(c.String("vault-transit-key"))
-	case "key-pkcs11":
-		return artifact.NewPKCS11Signer(c.String("key-pkcs11"))
-	case "keyfactor-signserver-worker":
-		return keyfactor.NewSignServerSigner(c.String("keyfactor-signserver-worker"))
-	default:
-		return nil, fmt.Errorf("unsupported signing key type %q", chosenOption)
+			"please add command to allowlist", c.Command.Name, chosenOptions[0])
+	}
+	return keyProviders[provider].Key(argument, usage)
+}
+
+// openArtifactInput opens the Artifact at path for reading, or returns
+// standard input if path is "-". The Artifact must be read as a stream.
+func openArtifactInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
 	}
+	return os.Open(path)
+}
+
+// artifactInputName describes the Artifact at path in messages.
+func artifactInputName(path string) string {
+	if path == "-" {
+		return "<stdin>"
+	}
+	return path
 }
 
 func unpackArtifact(name string) (ua *unpackedArtifact, err error) {
@@ -199,48 +214,42 @@ func unpackArtifact(name string) (ua *unpackedArtifact, err error) {
 	}
 	defer f.Close()
 
+	imageProgress.Stage("Unpacking Artifact %s", name)
 	aReader := areader.NewReader(f)
+	imageProgress.ShowReading(aReader)
 	ua.ar = aReader
 
-	tmpdir, err := ioutil.TempDir("", "mender-artifact")
+	tmpdir, err := utils.TempDir("", "unpack")
 	if err != nil {
 		return nil, err
 	}
 	ua.unpackDir = tmpdir
 	defer func() {
 		if err != nil {
-			os.RemoveAll(tmpdir)
+			utils.RemoveTemp(tmpdir)
 		}
 	}()
 
-	sDir := filepath.Join(tmpdir, "scripts")
-	err = os.Mkdir(sDir, 0755)
+	aReader.CollectScripts = true
+	err = aReader.ReadArtifactHeaders()
 	if err != nil {
 		return nil, err
 	}
-	storeScripts := func(r io.Reader, info os.FileInfo) error {
-		sLocation := filepath.Join(sDir, info.Name())
-		ua.scripts = append(ua.scripts, sLocation)
-		f, fileErr := os.OpenFile(sLocation, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
-		if fileErr != nil {
-			return errors.Wrapf(fileErr,
-				"can not create script file: %v", sLocation)
-		}
-		defer f.Close()
-
-		_, err = io.Copy(f, r)
-		if err != nil {
-			return errors.Wrapf(err,
-				"can not write script file: %v", sLocation)
-		}
-		return nil
-	}
-	aReader.ScriptsReadCallback = storeScripts
 
-	err = aReader.ReadArtifactHeaders()
-	if err != nil {
+	sDir := filepath.Join(tmpdir, "scripts")
+	if err = areader.WriteScripts(sDir, aReader.GetScripts()); err != nil {
 		return nil, err
 	}
+	ua.deviceTypeScripts = make(map[string][]string)
+	for _, script := range aReader.GetScripts() {
+		if script.DeviceType != "" {
+			ua.deviceTypeScripts[script.DeviceType] = append(
+				ua.deviceTypeScripts[script.DeviceType],
+				filepath.Join(sDir, script.DeviceType, script.Name))
+			continue
+		}
+		ua.scripts = append(ua.scripts, filepath.Join(sDir, script.Name))
+	}
 
 	fDir := filepath.Join(tmpdir, "files")
 	err = os.Mkdir(fDir, 0755)
@@ -349,6 +358,8 @@ func reconstructPayloadWriteData(
 			ArtifactDepends:        uDepends,
 			ArtifactProvides:       uProvides,
 			ClearsArtifactProvides: inst[0].GetUpdateOriginalClearsProvides(),
+			InstallSize:            installSizeOverride(inst[0]),
+			PayloadSignatures:      inst[0].GetUpdatePayloadSignatures(),
 		}
 
 		if metaData, err = inst[0].GetUpdateMetaData(); err != nil {
@@ -359,6 +370,20 @@ func reconstructPayloadWriteData(
 	return
 }
 
+// installSizeOverride returns the declared install size of the payload if it
+// was given explicitly, or zero if it is the one computed from the payload
+// files, so that it is recomputed when the files change.
+func installSizeOverride(upd handlers.ArtifactUpdate) int64 {
+	var size int64
+	for _, file := range upd.GetUpdateAllFiles() {
+		size += file.Size
+	}
+	if declared := upd.GetUpdateInstallSize(); declared != size {
+		return declared
+	}
+	return 0
+}
+
 func reconstructArtifactWriteData(ua *unpackedArtifact) (*awriter.WriteArtifactArgs, error) {
 	info := ua.ar.GetInfo()
 	inst := ua.ar.GetHandlers()
@@ -388,22 +413,32 @@ func reconstructArtifactWriteData(ua *unpackedArtifact) (*awriter.WriteArtifactA
 	if err != nil {
 		return nil, err
 	}
+	for deviceType, paths := range ua.deviceTypeScripts {
+		for _, path := range paths {
+			if err = scr.AddForDeviceType(deviceType, path); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	name := ua.ar.GetArtifactName()
 
 	args := &awriter.WriteArtifactArgs{
-		Format:            info.Format,
-		Version:           info.Version,
-		Devices:           ua.ar.GetCompatibleDevices(),
-		Name:              name,
-		Updates:           upd,
-		Scripts:           scr,
-		Provides:          ua.ar.GetArtifactProvides(),
-		Depends:           ua.ar.GetArtifactDepends(),
-		TypeInfoV3:        typeInfoV3,
-		MetaData:          metaData,
-		AugmentTypeInfoV3: augTypeInfoV3,
-		AugmentMetaData:   augMetaData,
+		Format:             info.Format,
+		Version:            info.Version,
+		Devices:            ua.ar.GetCompatibleDevices(),
+		Name:               name,
+		Updates:            upd,
+		Scripts:            scr,
+		Provides:           ua.ar.GetArtifactProvides(),
+		Depends:            ua.ar.GetArtifactDepends(),
+		TypeInfoV3:         typeInfoV3,
+		MetaData:           metaData,
+		AugmentTypeInfoV3:  augTypeInfoV3,
+		AugmentMetaData:    augMetaData,
+		ImmutableMetadata:  ua.ar.IsMetadataImmutable(),
+		UncompressedHeader: ua.ar.HasUncompressedHeader(),
+		ChecksumAlgorithm:  info.ChecksumAlgorithm,
 	}
 
 	return args, nil
@@ -413,13 +448,34 @@ func repack(comp artifact.Compressor, ua *unpackedArtifact, to io.Writer, key Si
 	aWriter := awriter.NewWriter(to, comp)
 	if key != nil {
 		aWriter = awriter.NewWriterSigned(to, comp, key)
+	} else if ua.ar.IsSigned {
+		warnf(WarningUnsignedOutput,
+			"The Artifact %s was signed, but no signing key was given; the result is unsigned",
+			ua.origPath)
+	}
+
+	// for rootfs-images: Update the dm-verity hash tree if there is one.
+	_, hasVerity := ua.writeArgs.TypeInfoV3.ArtifactProvides["rootfs-image.verity.root-hash"]
+	if hasVerity {
+		if len(ua.files) != 1 {
+			return errors.New("Only rootfs-image Artifacts with one file are supported")
+		}
+		if err := refreshVerityHashTree(ua.files[0], ua.writeArgs.TypeInfoV3); err != nil {
+			return err
+		}
+	}
+
+	if err := dropStalePayloadSignatures(ua); err != nil {
+		return err
 	}
 
 	// for rootfs-images: Update rootfs-image.checksum provide if there is one.
-	_, hasChecksumProvide := ua.writeArgs.TypeInfoV3.ArtifactProvides["rootfs-image.checksum"]
+	_, hasChecksumProvide :=
+		ua.writeArgs.TypeInfoV3.ArtifactProvides[artifact.RootfsImageChecksumKey]
 	// for rootfs-images: Update legacy rootfs_image_checksum provide if there is one.
-	_, hasLegacyChecksumProvide := ua.writeArgs.TypeInfoV3.ArtifactProvides["rootfs_image_checksum"]
-	if *ua.writeArgs.TypeInfoV3.Type == "rootfs-image" && (hasChecksumProvide ||
+	_, hasLegacyChecksumProvide :=
+		ua.writeArgs.TypeInfoV3.ArtifactProvides[artifact.LegacyRootfsImageChecksumKey]
+	if *ua.writeArgs.TypeInfoV3.Type == artifact.RootfsImageType && (hasChecksumProvide ||
 		hasLegacyChecksumProvide) {
 		if len(ua.files) != 1 {
 			return errors.New("Only rootfs-image Artifacts with one file are supported")
@@ -434,15 +490,51 @@ func repack(comp artifact.Compressor, ua *unpackedArtifact, to io.Writer, key Si
 		}
 	}
 
+	imageProgress.Stage("Repacking Artifact %s", ua.origPath)
+	imageProgress.ShowWriting(aWriter)
 	return aWriter.WriteArtifact(ua.writeArgs)
 }
 
+// dropStalePayloadSignatures removes the signatures of payload files which
+// were modified since the Artifact was unpacked, since they can not be
+// renewed without the payload signing key.
+func dropStalePayloadSignatures(ua *unpackedArtifact) error {
+	signatures := ua.writeArgs.TypeInfoV3.PayloadSignatures
+	if len(signatures) == 0 {
+		return nil
+	}
+	original := make(map[string][]byte)
+	for _, file := range ua.ar.GetHandlers()[0].GetUpdateFiles() {
+		original[filepath.Base(file.Name)] = file.Checksum
+	}
+	for _, name := range ua.files {
+		ch := artifact.NewWriterChecksumAlgorithm(ioutil.Discard, ua.ar.ChecksumAlgorithm())
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(ch, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		base := filepath.Base(name)
+		if _, signed := signatures[base]; signed &&
+			!bytes.Equal(ch.Checksum(), original[base]) {
+			warnf(WarningPayloadSignatureRemoved,
+				"Removing the payload signature of modified file %s", base)
+			delete(signatures, base)
+		}
+	}
+	return nil
+}
+
 func repackArtifact(comp artifact.Compressor, key SigningKey, ua *unpackedArtifact) error {
-	tmp, err := ioutil.TempFile(filepath.Dir(ua.origPath), "mender-artifact")
+	tmp, err := utils.TempFile(filepath.Dir(ua.origPath), "repack")
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmp.Name())
+	defer utils.RemoveTemp(tmp.Name())
 	defer tmp.Close()
 
 	if err = repack(comp, ua, tmp, key); err != nil {
@@ -453,3 +545,31 @@ func repackArtifact(comp artifact.Compressor, key SigningKey, ua *unpackedArtifa
 
 	return os.Rename(tmp.Name(), ua.origPath)
 }
+
+// writeOutputFile writes outputFile with write, through a temporary file in
+// the same directory which only replaces outputFile once it is complete, so
+// that a failed run leaves no partial output behind. The file gets the
+// permissions of the source Artifact.
+func writeOutputFile(outputFile, source string, write func(w io.Writer) error) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	tmp, err := utils.TempFile(filepath.Dir(outputFile), "output")
+	if err != nil {
+		return errors.Wrap(err, "Can not create temporary file for storing artifact")
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	if err = tmp.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outputFile)
+}
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -225,6 +226,25 @@ func generateKeys() ([]byte, []byte, error) {
 	return privSer.Bytes(), pubSer.Bytes(), nil
 }
 
+// Every command taking a key must say what it needs it for, or getKey fails.
+func TestKeyCommands(t *testing.T) {
+	var check func(cmds []cli.Command)
+	check = func(cmds []cli.Command) {
+		for _, cmd := range cmds {
+			for _, flag := range cmd.Flags {
+				name := strings.Split(flag.GetName(), ",")[0]
+				for _, keyFlag := range keyProviderFlags {
+					if name == keyFlag.flag || name == "key-provider" {
+						assert.Contains(t, keyCommands, cmd.Name, "--%s of %s", name, cmd.Name)
+					}
+				}
+			}
+			check(cmd.Subcommands)
+		}
+	}
+	check(getCliContext().Commands)
+}
+
 func TestArtifactsSigned(t *testing.T) {
 	updateTestDir, _ := ioutil.TempDir("", "update")
 	defer os.RemoveAll(updateTestDir)
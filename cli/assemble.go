@@ -0,0 +1,192 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// assembleDescriptor is what dump --descriptor writes, and assemble reads:
+// the compression of the Artifact, and the write command line which
+// recreates it from the dumped files.
+type assembleDescriptor struct {
+	Compression string   `json:"compression"`
+	Cmdline     []string `json:"cmdline"`
+}
+
+// The write flags whose value is the path of a dumped file.
+var assemblePathFlags = map[string]bool{
+	"--file":      true,
+	"--meta-data": true,
+	"--script":    true,
+}
+
+// compressorId returns the id of a registered compressor with the file
+// extension of comp. The level of zstd is not stored in the Artifact, so
+// the first zstd level in order is returned for it.
+func compressorId(comp artifact.Compressor) string {
+	if comp == nil || comp.GetFileExtension() == "" {
+		return "none"
+	}
+	for _, id := range artifact.GetRegisteredCompressorIds() {
+		registered, err := artifact.NewCompressorFromId(id)
+		if err == nil && registered.GetFileExtension() == comp.GetFileExtension() {
+			return id
+		}
+	}
+	return "none"
+}
+
+// mapCmdlinePaths returns args with fn applied to the paths of the dumped
+// files given to the write command.
+func mapCmdlinePaths(args []string, fn func(string) (string, error)) ([]string, error) {
+	mapped := make([]string, len(args))
+	copy(mapped, args)
+	for i := 1; i < len(mapped); i++ {
+		var err error
+		switch flag := mapped[i-1]; {
+		case assemblePathFlags[flag]:
+			mapped[i], err = fn(mapped[i])
+		case flag == "--script-for-device-type":
+			split := strings.SplitN(mapped[i], ":", 2)
+			if len(split) == 2 {
+				split[1], err = fn(split[1])
+				mapped[i] = split[0] + ":" + split[1]
+			}
+		case flag == "--payload":
+			fields := strings.Split(mapped[i], ",")
+			for j, field := range fields {
+				for _, key := range []string{"file=", "meta-data="} {
+					if strings.HasPrefix(field, key) && err == nil {
+						var path string
+						path, err = fn(strings.TrimPrefix(field, key))
+						fields[j] = key + path
+					}
+				}
+			}
+			mapped[i] = strings.Join(fields, ",")
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The value is not a flag.
+		i++
+	}
+	return mapped, nil
+}
+
+// writeAssembleDescriptor writes the descriptor of the Artifact read by ar,
+// whose files were dumped into the paths in args and payloadArgs, to path.
+func writeAssembleDescriptor(
+	path string,
+	ar *areader.Reader,
+	args []string,
+	payloadArgs map[int][]string,
+) error {
+	cmdline, err := buildCmdline(ar, args, payloadArgs)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	cmdline, err = mapCmdlinePaths(cmdline, func(file string) (string, error) {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Rel(dir, abs)
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(assembleDescriptor{
+		Compression: compressorId(ar.Compressor()),
+		Cmdline:     cmdline,
+	}, "", defaultIndentation)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// readAssembleDescriptor reads the descriptor at path, with the paths of
+// the dumped files resolved against its directory.
+func readAssembleDescriptor(path string) (*assembleDescriptor, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var desc assembleDescriptor
+	if err = json.Unmarshal(data, &desc); err != nil {
+		return nil, errors.Wrapf(err, "invalid descriptor %s", path)
+	}
+	if len(desc.Cmdline) < 2 || desc.Cmdline[0] != "write" {
+		return nil, errors.Errorf("the descriptor %s has no write command line", path)
+	}
+	dir := filepath.Dir(path)
+	desc.Cmdline, err = mapCmdlinePaths(desc.Cmdline, func(file string) (string, error) {
+		if filepath.IsAbs(file) {
+			return file, nil
+		}
+		return filepath.Join(dir, file), nil
+	})
+	return &desc, err
+}
+
+func assembleArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Need to specify exactly one descriptor with assemble command",
+			errArtifactInvalidParameters)
+	}
+	desc, err := readAssembleDescriptor(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Can not read descriptor: %s", err.Error()),
+			errArtifactOpen)
+	}
+
+	compression := desc.Compression
+	if c.String("compression") != "" {
+		compression = c.String("compression")
+	} else if c.GlobalIsSet("compression") {
+		compression = c.GlobalString("compression")
+	}
+	args := []string{c.App.Name, "--compression", compression}
+	for _, flag := range []string{"source-date-epoch", "io-nice", "lang"} {
+		if value := c.GlobalString(flag); value != "" {
+			args = append(args, "--"+flag, value)
+		}
+	}
+	if cpus := c.GlobalInt("cpu-limit"); cpus != 0 {
+		args = append(args, "--cpu-limit", strconv.Itoa(cpus))
+	}
+	args = append(args, desc.Cmdline...)
+	args = append(args, "--output-path", c.String("output-path"))
+	return getCliContext().Run(args)
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssemble(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	makeFile(t, tmpdir, "meta-data", "{\"a\":\"b\"}")
+	makeFile(t, tmpdir, "ArtifactInstall_Enter_00", "#!/bin/sh\ntrue\n")
+	require.NoError(t, os.Chmod(path.Join(tmpdir, "ArtifactInstall_Enter_00"), 0755))
+	artfile := path.Join(tmpdir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "--source-date-epoch", "1700000000",
+		"write", "module-image",
+		"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+		"-f", path.Join(tmpdir, "file"), "-m", path.Join(tmpdir, "meta-data"),
+		"-s", path.Join(tmpdir, "ArtifactInstall_Enter_00"),
+		"--provides", "my.version:1", "--depends", "other.version:2"})
+	require.NoError(t, err)
+
+	dumpdir := path.Join(tmpdir, "dump")
+	err = Run([]string{"mender-artifact", "dump",
+		"--files", path.Join(dumpdir, "files"),
+		"--meta-data", path.Join(dumpdir, "meta"),
+		"--scripts", path.Join(dumpdir, "scripts"),
+		"--descriptor", path.Join(dumpdir, "descriptor.json"),
+		artfile})
+	require.NoError(t, err)
+
+	// The paths are relative to the descriptor, so the dumped files can be
+	// moved.
+	moved := path.Join(tmpdir, "moved")
+	require.NoError(t, os.Rename(dumpdir, moved))
+	desc, err := readAssembleDescriptor(path.Join(moved, "descriptor.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "gzip", desc.Compression)
+	assert.Contains(t, desc.Cmdline, path.Join(moved, "files", "file"))
+
+	assembled := path.Join(tmpdir, "assembled.mender")
+	err = Run([]string{"mender-artifact", "--source-date-epoch", "1700000000",
+		"assemble", "-o", assembled, path.Join(moved, "descriptor.json")})
+	require.NoError(t, err)
+
+	original, err := ioutil.ReadFile(artfile)
+	require.NoError(t, err)
+	recreated, err := ioutil.ReadFile(assembled)
+	require.NoError(t, err)
+	assert.Equal(t, original, recreated)
+
+	// Another compression can be chosen.
+	err = Run([]string{"mender-artifact", "assemble", "--compression", "none",
+		"-o", assembled, path.Join(moved, "descriptor.json")})
+	require.NoError(t, err)
+	a, err := readDiffDocument(artfile)
+	require.NoError(t, err)
+	b, err := readDiffDocument(assembled)
+	require.NoError(t, err)
+	assert.Empty(t, diffArtifactDocuments(a, b))
+}
+
+func TestSourceDateEpochInvalid(t *testing.T) {
+	err := Run([]string{"mender-artifact", "--source-date-epoch", "yesterday",
+		"read", "nonexistent.mender"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --source-date-epoch value")
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+type auditFile struct {
+	Name      string `json:"name"`
+	Checksum  string `json:"checksum"`
+	Size      int64  `json:"size"`
+	Mode      string `json:"mode"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type auditPayload struct {
+	Type           string                    `json:"type"`
+	Provides       artifact.TypeInfoProvides `json:"provides,omitempty"`
+	Depends        artifact.TypeInfoDepends  `json:"depends,omitempty"`
+	ClearsProvides []string                  `json:"clears_provides,omitempty"`
+	MetaData       map[string]interface{}    `json:"meta_data,omitempty"`
+	Files          []auditFile               `json:"files"`
+}
+
+// auditReport lists everything contained in an Artifact, so that it can be
+// recorded by audit systems.
+type auditReport struct {
+	Name              string                     `json:"name"`
+	Format            string                     `json:"format"`
+	Version           int                        `json:"version"`
+	Signature         string                     `json:"signature"`
+	CompatibleDevices []string                   `json:"compatible_devices"`
+	Provides          *artifact.ArtifactProvides `json:"artifact_provides,omitempty"`
+	Depends           *artifact.ArtifactDepends  `json:"artifact_depends,omitempty"`
+	Scripts           []auditFile                `json:"scripts"`
+	Payloads          []auditPayload             `json:"payloads"`
+}
+
+// auditUpdateStorer reads through the files of a payload, recording their
+// modes. The checksums are verified by the reader.
+type auditUpdateStorer struct {
+	modes map[string]os.FileMode
+}
+
+func (s *auditUpdateStorer) Initialize(artifactHeaders,
+	artifactAugmentedHeaders artifact.HeaderInfoer,
+	payloadHeaders handlers.ArtifactUpdateHeaders) error {
+	return nil
+}
+
+func (s *auditUpdateStorer) PrepareStoreUpdate() error {
+	return nil
+}
+
+func (s *auditUpdateStorer) StoreUpdate(r io.Reader, info os.FileInfo) error {
+	s.modes[info.Name()] = info.Mode()
+	_, err := io.Copy(ioutil.Discard, r)
+	return err
+}
+
+func (s *auditUpdateStorer) FinishStoreUpdate() error {
+	return nil
+}
+
+func (s *auditUpdateStorer) NewUpdateStorer(
+	updateType *string,
+	payloadNum int,
+) (handlers.UpdateStorer, error) {
+	return s, nil
+}
+
+func formatAuditMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+func auditPayloadReport(p handlers.Installer, modes map[string]os.FileMode) (auditPayload, error) {
+	var payload auditPayload
+	var err error
+	if updType := p.GetUpdateType(); updType != nil {
+		payload.Type = *updType
+	}
+	if payload.Provides, err = p.GetUpdateProvides(); err != nil {
+		return payload, errors.Wrap(err, "invalid provides section")
+	}
+	if payload.Depends, err = p.GetUpdateDepends(); err != nil {
+		return payload, errors.Wrap(err, "invalid depends section")
+	}
+	payload.ClearsProvides = p.GetUpdateClearsProvides()
+	if payload.MetaData, err = p.GetUpdateMetaData(); err != nil {
+		return payload, errors.Wrap(err, "invalid metadata section")
+	}
+
+	signatures := p.GetUpdatePayloadSignatures()
+	payload.Files = []auditFile{}
+	for _, f := range p.GetUpdateAllFiles() {
+		name := filepath.Base(f.Name)
+		payload.Files = append(payload.Files, auditFile{
+			Name:      name,
+			Checksum:  string(f.Checksum),
+			Size:      f.Size,
+			Mode:      formatAuditMode(modes[name]),
+			Signature: signatures[name],
+		})
+	}
+	return payload, nil
+}
+
+func auditArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.NewExitError("Can not open artifact: "+c.Args().First(),
+			errArtifactOpen)
+	}
+	defer f.Close()
+
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	report := auditReport{
+		Signature: "none",
+		Scripts:   []auditFile{},
+		Payloads:  []auditPayload{},
+	}
+
+	ar := areader.NewReader(f)
+	ar.ReadBufferSize = c.Int("read-buffer-size")
+	ar.ReadAheadBuffers = c.Int("read-ahead")
+	ar.VerifySignatureCallback = func(message, sig []byte) error {
+		if report.Signature == "verified" {
+			// Another signature of the Artifact has been verified.
+			return nil
+		}
+		report.Signature = "unverified"
+		if key != nil {
+			if verr := key.Verify(message, sig); verr != nil {
+				report.Signature = "verification failed"
+			} else {
+				report.Signature = "verified"
+			}
+		}
+		return nil
+	}
+	ar.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		h := sha256.New()
+		size, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		report.Scripts = append(report.Scripts, auditFile{
+			Name:     info.Name(),
+			Checksum: hex.EncodeToString(h.Sum(nil)),
+			Size:     size,
+			Mode:     formatAuditMode(info.Mode()),
+		})
+		return nil
+	}
+
+	if err = ar.ReadArtifactHeaders(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+	inst := ar.GetHandlers()
+	modes := make(map[int]map[string]os.FileMode, len(inst))
+	for i, h := range inst {
+		modes[i] = make(map[string]os.FileMode)
+		h.SetUpdateStorerProducer(&auditUpdateStorer{modes: modes[i]})
+	}
+	if err = ar.ReadArtifactData(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+
+	info := ar.GetInfo()
+	report.Name = ar.GetArtifactName()
+	report.Format = info.Format
+	report.Version = info.Version
+	report.CompatibleDevices = ar.GetCompatibleDevices()
+	report.Provides = ar.GetArtifactProvides()
+	report.Depends = ar.GetArtifactDepends()
+
+	indices := make([]int, 0, len(inst))
+	for i := range inst {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		payload, err := auditPayloadReport(inst[i], modes[i])
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Payload %d: %s", i, err.Error()),
+				errArtifactInvalid)
+		}
+		report.Payloads = append(report.Payloads, payload)
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	out = append(out, '\n')
+
+	if c.String("output") == "-" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = ioutil.WriteFile(c.String("output"), out, 0644)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditArtifact(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	file1 := filepath.Join(tmpdir, "file1")
+	require.NoError(t, ioutil.WriteFile(file1, []byte("first file"), 0644))
+	file2 := filepath.Join(tmpdir, "file2")
+	require.NoError(t, ioutil.WriteFile(file2, []byte("second file"), 0600))
+	require.NoError(t, os.Chmod(file2, 0600))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0755))
+	metaData := filepath.Join(tmpdir, "meta-data")
+	require.NoError(t, ioutil.WriteFile(metaData, []byte(`{"key": "value"}`), 0644))
+	art := filepath.Join(tmpdir, "artifact.mender")
+	output := filepath.Join(tmpdir, "audit.json")
+
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module",
+		"-f", file1, "-f", file2, "-s", script, "-m", metaData,
+		"-p", "my-module.version:1", "-o", art})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "audit", art, "-o", output})
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(output)
+	require.NoError(t, err)
+	var report auditReport
+	require.NoError(t, json.Unmarshal(data, &report))
+
+	sum := func(content string) string {
+		s := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(s[:])
+	}
+
+	assert.Equal(t, "release-1", report.Name)
+	assert.Equal(t, "mender", report.Format)
+	assert.Equal(t, 3, report.Version)
+	assert.Equal(t, "none", report.Signature)
+	assert.Equal(t, []string{"my-device"}, report.CompatibleDevices)
+	require.NotNil(t, report.Provides)
+	assert.Equal(t, "release-1", report.Provides.ArtifactName)
+
+	assert.Equal(t, []auditFile{{
+		Name:     "ArtifactInstall_Enter_00",
+		Checksum: sum("#!/bin/sh\n"),
+		Size:     10,
+		Mode:     "0755",
+	}}, report.Scripts)
+
+	require.Len(t, report.Payloads, 1)
+	payload := report.Payloads[0]
+	assert.Equal(t, "my-module", payload.Type)
+	assert.Equal(t, "1", payload.Provides["my-module.version"])
+	assert.Equal(t, map[string]interface{}{"key": "value"}, payload.MetaData)
+	assert.Equal(t, []auditFile{
+		{Name: "file1", Checksum: sum("first file"), Size: 10, Mode: "0644"},
+		{Name: "file2", Checksum: sum("second file"), Size: 11, Mode: "0600"},
+	}, payload.Files)
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+var backupFlag = cli.BoolFlag{
+	Name:  "backup",
+	Usage: "Save a copy of the Artifact or image before modifying it",
+}
+
+var backupSuffixFlag = cli.StringFlag{
+	Name:  "backup-suffix",
+	Usage: "Name the backup after the Artifact or image with `SUFFIX` appended",
+	Value: ".orig",
+}
+
+var backupDirFlag = cli.StringFlag{
+	Name:  "backup-dir",
+	Usage: "Save the backup in `DIR` instead of next to the Artifact or image",
+}
+
+var backupKeepFlag = cli.IntFlag{
+	Name: "backup-keep",
+	Usage: "Keep the `N` latest backups, numbering the older ones .1, .2 and so on," +
+		" and delete the rest",
+	Value: 1,
+}
+
+// backupImage saves a copy of the image or Artifact which a command is about
+// to modify, if --backup is given and nothing is modified because of
+// --dry-run. Earlier backups are rotated, keeping --backup-keep of them.
+func backupImage(c *cli.Context, path string) error {
+	if !c.Bool("backup") || c.Bool("dry-run") {
+		return nil
+	}
+	keep := c.Int("backup-keep")
+	if keep < 1 {
+		return errors.New("--backup-keep must be at least 1")
+	}
+	dir := c.String("backup-dir")
+	if dir == "" {
+		dir = filepath.Dir(path)
+	}
+	backup := filepath.Join(dir, filepath.Base(path)+c.String("backup-suffix"))
+	if backup == filepath.Clean(path) {
+		return errors.New("the backup would replace the original; use --backup-suffix" +
+			" or --backup-dir")
+	}
+
+	if err := rotateBackups(backup, keep); err != nil {
+		return errors.Wrap(err, "can not rotate the backups")
+	}
+	imageProgress.Stage("Backing up %s to %s", path, backup)
+	if err := copyBackup(path, backup); err != nil {
+		return errors.Wrapf(err, "can not back up %s", path)
+	}
+	return nil
+}
+
+// numberedBackup returns the name of the n:th older backup.
+func numberedBackup(backup string, n int) string {
+	if n == 0 {
+		return backup
+	}
+	return fmt.Sprintf("%s.%d", backup, n)
+}
+
+// rotateBackups makes room for a new backup, keeping keep-1 of the existing
+// ones, and deleting those which are older.
+func rotateBackups(backup string, keep int) error {
+	for n := keep - 1; ; n++ {
+		err := os.Remove(numberedBackup(backup, n))
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	for n := keep - 2; n >= 0; n-- {
+		err := os.Rename(numberedBackup(backup, n), numberedBackup(backup, n+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBackup copies src to dst, keeping its permissions and modification time.
+// dst only appears once it is complete.
+func copyBackup(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := utils.TempFile(filepath.Dir(dst), "backup")
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	_, err = io.Copy(tmp, imageProgress.Reader(in, info.Size()))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// backupImagePath is backupImage for [artifact|sdimg]:<filepath> arguments.
+func backupImagePath(c *cli.Context, imgAndPath string) error {
+	imagepath, _, err := parseImgPath(imgAndPath)
+	if err != nil {
+		return err
+	}
+	return backupImage(c, imagepath)
+}
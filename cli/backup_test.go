@@ -0,0 +1,95 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readArtifactName(t *testing.T, path string) string {
+	name, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", "--field", "name", path})
+	require.NoError(t, err)
+	return name
+}
+
+func TestModifyBackup(t *testing.T) {
+	modifyFlagsTested.addFlags([]string{
+		"backup", "backup-suffix", "backup-dir", "backup-keep",
+	})
+
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+	original := readArtifactName(t, art)
+
+	require.NoError(t, Run([]string{"mender-artifact", "modify", "--backup",
+		"-n", "release-2", art}))
+	assert.Equal(t, "release-2", readArtifactName(t, art))
+	assert.Equal(t, original, readArtifactName(t, art+".orig"))
+
+	require.NoError(t, Run([]string{"mender-artifact", "modify", "--backup",
+		"--backup-keep", "2", "-n", "release-3", art}))
+	assert.Equal(t, "release-2", readArtifactName(t, art+".orig"))
+	assert.Equal(t, original, readArtifactName(t, art+".orig.1"))
+
+	require.NoError(t, Run([]string{"mender-artifact", "modify", "--backup",
+		"-n", "release-4", art}))
+	assert.Equal(t, "release-3", readArtifactName(t, art+".orig"))
+	assert.NoFileExists(t, art+".orig.1")
+
+	backupDir := t.TempDir()
+	require.NoError(t, Run([]string{"mender-artifact", "modify", "--backup",
+		"--backup-dir", backupDir, "--backup-suffix", ".bak", "-n", "release-5", art}))
+	assert.Equal(t, "release-4",
+		readArtifactName(t, filepath.Join(backupDir, "artifact.mender.bak")))
+
+	err := Run([]string{"mender-artifact", "modify", "--backup",
+		"--backup-suffix", "", "-n", "release-6", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the backup would replace the original")
+	err = Run([]string{"mender-artifact", "modify", "--backup",
+		"--backup-keep", "0", "-n", "release-6", art})
+	require.Error(t, err)
+	assert.Equal(t, "release-5", readArtifactName(t, art))
+}
+
+func TestSignBackup(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+	key := filepath.Join(dir, "private.key")
+	require.NoError(t, os.WriteFile(key, []byte(PrivateECDSAKey), 0600))
+	info, err := os.Stat(art)
+	require.NoError(t, err)
+
+	require.NoError(t, Run([]string{"mender-artifact", "sign", "-k", key,
+		"--backup", art}))
+	backup, err := os.Stat(art + ".orig")
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), backup.Size())
+	assert.Equal(t, info.Mode(), backup.Mode())
+	assert.True(t, info.ModTime().Equal(backup.ModTime()))
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", art + ".orig"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Signature: no signature")
+}
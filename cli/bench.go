@@ -0,0 +1,131 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/bench"
+)
+
+var benchSizeSuffixes = []struct {
+	suffix string
+	factor int64
+}{
+	{"GiB", bench.GiB},
+	{"MiB", bench.MiB},
+	{"KiB", 1024},
+	{"G", bench.GiB},
+	{"M", bench.MiB},
+	{"K", 1024},
+}
+
+// parseBenchSize parses a payload size such as "100MiB" or "8G"; sizes
+// without a suffix are in bytes.
+func parseBenchSize(s string) (int64, error) {
+	factor := int64(1)
+	number := s
+	for _, sf := range benchSizeSuffixes {
+		if strings.HasSuffix(s, sf.suffix) {
+			factor = sf.factor
+			number = strings.TrimSuffix(s, sf.suffix)
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid payload size: %q", s)
+	}
+	return size * factor, nil
+}
+
+type benchResult struct {
+	PayloadSize     int64   `json:"payload_size"`
+	Compressor      string  `json:"compressor"`
+	ArtifactSize    int64   `json:"artifact_size"`
+	WriteSeconds    float64 `json:"write_seconds"`
+	ReadSeconds     float64 `json:"read_seconds"`
+	ValidateSeconds float64 `json:"validate_seconds"`
+}
+
+func benchArtifact(c *cli.Context) error {
+	sizes := c.StringSlice("size")
+	if len(sizes) == 0 {
+		sizes = []string{"100MiB"}
+	}
+	compressors := c.StringSlice("compression")
+	if len(compressors) == 0 {
+		compressors = artifact.GetRegisteredCompressorIds()
+	}
+
+	var configs []bench.Config
+	for _, s := range sizes {
+		size, err := parseBenchSize(s)
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		for _, id := range compressors {
+			if _, err = artifact.NewCompressorFromId(id); err != nil {
+				return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+			}
+			configs = append(configs, bench.Config{
+				PayloadSize: size,
+				Compressor:  id,
+				Dir:         c.String("dir"),
+			})
+		}
+	}
+
+	var results []benchResult
+	if !c.Bool("json") {
+		fmt.Printf("%-12s %-10s %12s %12s %12s %12s\n", "Payload", "Compressor",
+			"Artifact", "Write MiB/s", "Read MiB/s", "Valid. MiB/s")
+	}
+	for _, cfg := range configs {
+		res, err := bench.Run(cfg)
+		if err != nil {
+			return cli.NewExitError(err.Error(), errSystemError)
+		}
+		if c.Bool("json") {
+			results = append(results, benchResult{
+				PayloadSize:     res.PayloadSize,
+				Compressor:      res.Compressor,
+				ArtifactSize:    res.ArtifactSize,
+				WriteSeconds:    res.Write.Seconds(),
+				ReadSeconds:     res.Read.Seconds(),
+				ValidateSeconds: res.Validate.Seconds(),
+			})
+			continue
+		}
+		fmt.Printf("%-12d %-10s %12d %12.1f %12.1f %12.1f\n", res.PayloadSize,
+			res.Compressor, res.ArtifactSize, res.Throughput(res.Write),
+			res.Throughput(res.Read), res.Throughput(res.Validate))
+	}
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return cli.NewExitError(err.Error(), errSystemError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBenchSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"512":    512,
+		"4K":     4096,
+		"100MiB": 100 * 1024 * 1024,
+		"8G":     8 * 1024 * 1024 * 1024,
+		"1GiB":   1024 * 1024 * 1024,
+	} {
+		size, err := parseBenchSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+	for _, s := range []string{"", "MiB", "-1M", "0", "1TiB"} {
+		_, err := parseBenchSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBenchArtifact(t *testing.T) {
+	data, err := runAndCollectStdout([]string{"mender-artifact", "bench", "--json",
+		"--size", "64K", "--size", "1M", "--compression", "none", "--compression", "gzip",
+		"--dir", t.TempDir()})
+	require.NoError(t, err)
+	var results []benchResult
+	require.NoError(t, json.Unmarshal([]byte(data), &results))
+	require.Len(t, results, 4)
+	assert.Equal(t, int64(64*1024), results[0].PayloadSize)
+	assert.Equal(t, "none", results[0].Compressor)
+	assert.Equal(t, int64(1024*1024), results[3].PayloadSize)
+	assert.Equal(t, "gzip", results[3].Compressor)
+	assert.NotZero(t, results[3].ArtifactSize)
+
+	err = Run([]string{"mender-artifact", "bench", "--compression", "bogus"})
+	assert.Error(t, err)
+}
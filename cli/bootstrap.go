@@ -0,0 +1,179 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// deviceDescription is what a device reports about itself: its device type,
+// and the provides of the Artifacts installed on it, as mender-update
+// show-provides prints them. A bootstrap Artifact made from it gives a new
+// device of the same kind the same state.
+type deviceDescription struct {
+	DeviceType string            `json:"device_type"`
+	Provides   map[string]string `json:"provides"`
+}
+
+// readDeviceJSON reads the device description in the JSON file at path.
+func readDeviceJSON(path string) (*deviceDescription, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read the device description")
+	}
+	var device deviceDescription
+	if err = json.Unmarshal(data, &device); err != nil {
+		return nil, errors.Wrapf(err, "%s is not a JSON device description", path)
+	}
+	if device.DeviceType == "" {
+		return nil, errors.Errorf("the device description in %s has no device_type", path)
+	}
+	if device.Provides["artifact_name"] == "" {
+		return nil, errors.Errorf(
+			"the device description in %s provides no artifact_name", path)
+	}
+	return &device, nil
+}
+
+// applyDeviceJSON sets the device type, the Artifact name and the provides
+// group of the bootstrap Artifact to those in the --from-device-json
+// description, unless they are given on the command line, and returns the
+// other provides of the device, for the payload.
+func applyDeviceJSON(c *cli.Context) (map[string]string, error) {
+	path := c.String("from-device-json")
+	if path == "" {
+		return nil, nil
+	}
+	device, err := readDeviceJSON(path)
+	if err != nil {
+		return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	set := func(flag, value string) error {
+		if value == "" || c.IsSet(flag) {
+			return nil
+		}
+		return c.Set(flag, value)
+	}
+	if err = set("device-type", device.DeviceType); err == nil {
+		err = set("artifact-name", device.Provides["artifact_name"])
+	}
+	if err == nil {
+		err = set("provides-group", device.Provides["artifact_group"])
+	}
+	if err != nil {
+		return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	provides := map[string]string{}
+	for key, value := range device.Provides {
+		switch key {
+		case "artifact_name", "artifact_group", "device_type":
+		default:
+			provides[key] = value
+		}
+	}
+	return provides, nil
+}
+
+// bootstrapProblems returns the reasons why the Artifact read by ar does not
+// qualify as a bootstrap Artifact, which a device installs without a
+// payload, to record the state it was provisioned with. Devices only find
+// out when they start with it.
+func bootstrapProblems(ar *areader.Reader) []string {
+	var problems []string
+	if version := ar.GetInfo().Version; version < 3 {
+		problems = append(problems, fmt.Sprintf(
+			"the Artifact has format version %d; bootstrap Artifacts need version 3",
+			version))
+	}
+	if ar.GetArtifactName() == "" {
+		problems = append(problems, "the Artifact provides no artifact_name")
+	}
+	if len(ar.GetCompatibleDevices()) == 0 {
+		problems = append(problems, "the Artifact is compatible with no device type")
+	}
+	if depends := ar.GetArtifactDepends(); depends != nil {
+		// A device being bootstrapped has no Artifact installed yet.
+		if len(depends.ArtifactName) > 0 {
+			problems = append(problems,
+				"the Artifact depends on an installed Artifact name")
+		}
+		if len(depends.ArtifactGroup) > 0 {
+			problems = append(problems,
+				"the Artifact depends on an installed Artifact group")
+		}
+	}
+
+	payloads := ar.GetHandlers()
+	if len(payloads) == 0 {
+		problems = append(problems, "the Artifact has no payload")
+	}
+	indexes := make([]int, 0, len(payloads))
+	for i := range payloads {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		payload := payloads[i]
+		if updateType := payload.GetUpdateType(); updateType != nil {
+			problems = append(problems, fmt.Sprintf(
+				"payload %d has type %s; bootstrap payloads are empty", i, *updateType))
+		}
+		if files := payload.GetUpdateAllFiles(); len(files) > 0 {
+			problems = append(problems, fmt.Sprintf(
+				"payload %d has %d file(s); bootstrap payloads are empty", i, len(files)))
+		}
+	}
+	return problems
+}
+
+// checkBootstrap fails if the Artifact read by ar is not a valid bootstrap
+// Artifact, listing the reasons.
+func checkBootstrap(ar *areader.Reader) error {
+	problems := bootstrapProblems(ar)
+	if len(problems) == 0 {
+		return nil
+	}
+	message := tr("Not a valid bootstrap Artifact:")
+	for _, problem := range problems {
+		message += "\n- " + problem
+	}
+	return cli.NewExitError(message, errArtifactInvalid)
+}
+
+// addDeviceProvides adds the provides of the device to the payload provides
+// of typeInfo, except the ones given on the command line.
+func addDeviceProvides(typeInfo *artifact.TypeInfoV3, provides map[string]string) {
+	if len(provides) == 0 {
+		return
+	}
+	if typeInfo.ArtifactProvides == nil {
+		typeInfo.ArtifactProvides = artifact.TypeInfoProvides{}
+	}
+	for key, value := range provides {
+		if _, ok := typeInfo.ArtifactProvides[key]; !ok {
+			typeInfo.ArtifactProvides[key] = value
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readArtifactFields(t *testing.T, path string) artifactFields {
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read", "--no-progress",
+		"--json", path})
+	require.NoError(t, err)
+	var fields artifactFields
+	require.NoError(t, json.Unmarshal([]byte(out), &fields))
+	return fields
+}
+
+func TestWriteBootstrapFromDeviceJSON(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "device.json", `{
+		"device_type": "raspberrypi4",
+		"provides": {
+			"artifact_name": "release-1",
+			"artifact_group": "production",
+			"rootfs-image.version": "release-1",
+			"rootfs-image.checksum": "0123"
+		}
+	}`)
+	device := filepath.Join(dir, "device.json")
+	art := filepath.Join(dir, "bootstrap.mender")
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "bootstrap-artifact",
+		"--no-progress", "--from-device-json", device, "-o", art}))
+	fields := readArtifactFields(t, art)
+	assert.Equal(t, "release-1", fields.Name)
+	assert.Equal(t, []string{"raspberrypi4"}, fields.CompatibleDevices)
+	assert.Equal(t, "production", fields.Provides["artifact_group"])
+	require.Len(t, fields.Payloads, 1)
+	assert.Equal(t, map[string]string{
+		"rootfs-image.version":  "release-1",
+		"rootfs-image.checksum": "0123",
+	}, fields.Payloads[0].Provides)
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read", "--no-progress",
+		"--bootstrap-check", art})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Valid bootstrap Artifact")
+
+	// The command line takes precedence.
+	require.NoError(t, Run([]string{"mender-artifact", "write", "bootstrap-artifact",
+		"--no-progress", "--from-device-json", device, "-o", art,
+		"-n", "release-2", "-t", "other-device", "-p", "rootfs-image.version:release-2"}))
+	fields = readArtifactFields(t, art)
+	assert.Equal(t, "release-2", fields.Name)
+	assert.Equal(t, []string{"other-device"}, fields.CompatibleDevices)
+	assert.Equal(t, "release-2", fields.Payloads[0].Provides["rootfs-image.version"])
+	assert.Equal(t, "0123", fields.Payloads[0].Provides["rootfs-image.checksum"])
+
+	// Without the description, the flags are still needed.
+	err = Run([]string{"mender-artifact", "write", "bootstrap-artifact", "--no-progress",
+		"-o", art, "-n", "release-1"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must provide `device-type`")
+
+	makeFile(t, dir, "incomplete.json", `{"provides": {"artifact_name": "release-1"}}`)
+	err = Run([]string{"mender-artifact", "write", "bootstrap-artifact", "--no-progress",
+		"--from-device-json", filepath.Join(dir, "incomplete.json"), "-o", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no device_type")
+}
+
+func TestReadBootstrapCheck(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	art := filepath.Join(dir, "artifact.mender")
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", art, "-n", "release-1", "-t", "test-device",
+		"-T", "test-type", "-f", filepath.Join(dir, "file")}))
+	err := Run([]string{"mender-artifact", "read", "--no-progress", "--bootstrap-check", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Not a valid bootstrap Artifact")
+	assert.Contains(t, err.Error(), "payload 0 has type test-type")
+	assert.Contains(t, err.Error(), "payload 0 has 1 file(s)")
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "bootstrap-artifact",
+		"--no-progress", "-o", art, "-n", "release-2", "-t", "test-device",
+		"-N", "release-1", "-G", "production"}))
+	err = Run([]string{"mender-artifact", "read", "--no-progress", "--bootstrap-check", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "depends on an installed Artifact name")
+	assert.Contains(t, err.Error(), "depends on an installed Artifact group")
+	assert.NotContains(t, err.Error(), "payload")
+}
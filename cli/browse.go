@@ -0,0 +1,193 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+const browseHelp = `Commands:
+  header           Show the Artifact header, provides and depends
+  signature        Show the signature status
+  payloads         List the payloads
+  payload <n>      Show the type-info, meta-data and files of payload n
+  scripts          List the state scripts
+  script <name>    Show the contents of a state script
+  help             Show this help
+  quit             Leave the browser
+`
+
+// artifactBrowser answers the commands of the browse command about an
+// Artifact which has been read completely.
+type artifactBrowser struct {
+	ar      *areader.Reader
+	sigInfo string
+	scripts map[string][]byte
+}
+
+// newArtifactBrowser returns a browser for the Artifact in r, which collects
+// the state scripts when the Artifact is read.
+func newArtifactBrowser(r io.Reader) *artifactBrowser {
+	b := &artifactBrowser{
+		ar:      areader.NewReader(r),
+		sigInfo: signatureNone,
+		scripts: make(map[string][]byte),
+	}
+	b.ar.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		b.scripts[info.Name()] = content
+		return nil
+	}
+	return b
+}
+
+func (b *artifactBrowser) scriptNames() []string {
+	names := make([]string, 0, len(b.scripts))
+	for name := range b.scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *artifactBrowser) listPayloads() {
+	updates := b.ar.GetHandlers()
+	indices := make([]int, 0, len(updates))
+	for i := range updates {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		updType := "Empty type"
+		if t := updates[i].GetUpdateType(); t != nil {
+			updType = *t
+		}
+		fmt.Printf("%d: %s (%d files)\n", i, updType, len(updates[i].GetUpdateAllFiles()))
+	}
+}
+
+func (b *artifactBrowser) showPayload(arg string) {
+	n, err := strconv.Atoi(arg)
+	p, ok := b.ar.GetHandlers()[n]
+	if err != nil || !ok {
+		fmt.Printf("No such payload: %q\n", arg)
+		return
+	}
+	printPayload(p, 0)
+}
+
+func (b *artifactBrowser) showScript(name string) {
+	content, ok := b.scripts[name]
+	if !ok {
+		fmt.Printf("No such state script: %q\n", name)
+		return
+	}
+	fmt.Print(string(content))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		fmt.Println()
+	}
+}
+
+// run executes the commands read from in, until quit or the end of input. It
+// is used instead of the full screen browser when not on a terminal.
+func (b *artifactBrowser) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Print("browse> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := strings.Join(fields[1:], " ")
+		switch fields[0] {
+		case "header":
+			printArtifactInfo(b.ar, b.sigInfo)
+		case "signature":
+			fmt.Printf("%s: %s\n", tr("Signature"), tr(b.sigInfo))
+		case "payloads":
+			b.listPayloads()
+		case "payload":
+			b.showPayload(arg)
+		case "scripts":
+			printStateScripts(b.scriptNames(), 0)
+		case "script":
+			b.showScript(arg)
+		case "help":
+			fmt.Print(browseHelp)
+		case "quit", "q", "exit":
+			return nil
+		default:
+			fmt.Printf("Unknown command: %q; type 'help' for a list of commands\n", fields[0])
+		}
+	}
+}
+
+func browseArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(trf("Can not open artifact: %s", c.Args().First()),
+			errArtifactOpen)
+	}
+	defer f.Close()
+
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	b := newArtifactBrowser(f)
+	b.ar.ReadBufferSize = c.Int("read-buffer-size")
+	b.ar.ReadAheadBuffers = c.Int("read-ahead")
+	b.ar.VerifySignatureCallback = describeSignature(key, &b.sigInfo)
+	if err = b.ar.ReadArtifact(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+
+	title := fmt.Sprintf("%s: %s", c.Args().First(), b.ar.GetArtifactName())
+	if isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd()) {
+		err = newBrowseTUI(title, b).run(os.Stdin, os.Stdout)
+	} else {
+		fmt.Printf("%s; type 'help' for a list of commands\n", title)
+		err = b.run(os.Stdin)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	return nil
+}
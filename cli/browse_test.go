@@ -0,0 +1,161 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrowseArtifact(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-browse")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	file := filepath.Join(tmpdir, "file1")
+	require.NoError(t, ioutil.WriteFile(file, []byte("first file"), 0644))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho hello\n"), 0755))
+	art := filepath.Join(tmpdir, "artifact.mender")
+
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module",
+		"-f", file, "-s", script, "-o", art})
+	require.NoError(t, err)
+
+	commands := filepath.Join(tmpdir, "commands")
+	require.NoError(t, ioutil.WriteFile(commands, []byte(
+		"header\nsignature\npayloads\npayload 0\npayload 1\n"+
+			"scripts\nscript ArtifactInstall_Enter_00\nbogus\nquit\nheader\n"), 0644))
+	in, err := os.Open(commands)
+	require.NoError(t, err)
+	defer in.Close()
+	orgStdin := os.Stdin
+	os.Stdin = in
+	defer func() { os.Stdin = orgStdin }()
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "browse", art})
+	require.NoError(t, err)
+
+	assert.Contains(t, data, "release-1; type 'help' for a list of commands\n")
+	assert.Contains(t, data, "Mender Artifact:\n  Name: release-1\n")
+	assert.Contains(t, data, "Signature: no signature\n")
+	assert.Contains(t, data, "0: my-module (1 files)\n")
+	assert.Contains(t, data, "- Type: my-module\n")
+	assert.Contains(t, data, "name: file1\n")
+	assert.Contains(t, data, "No such payload: \"1\"\n")
+	assert.Contains(t, data, "State scripts:\n  - ArtifactInstall_Enter_00\n")
+	assert.Contains(t, data, "#!/bin/sh\necho hello\n")
+	assert.Contains(t, data, "Unknown command: \"bogus\"")
+	// Nothing is run after quit.
+	assert.Equal(t, 1, strings.Count(data, "Mender Artifact:"))
+}
+
+func TestDecodeKeys(t *testing.T) {
+	assert.Equal(t, []string{keyUp, keyDown, "q"}, decodeKeys([]byte("\x1b[A\x1b[Bq")))
+	assert.Equal(t, []string{keyRight, keyLeft}, decodeKeys([]byte("\x1bOC\x1bOD")))
+	assert.Equal(t, []string{keyPageDown, keyHome, keyEnter, keyEnter},
+		decodeKeys([]byte("\x1b[6~\x1b[1~\r\n")))
+	assert.Equal(t, []string{keyEscape}, decodeKeys([]byte("\x1b")))
+	assert.Equal(t, []string{"ä", keyTab}, decodeKeys([]byte("ä\t")))
+}
+
+func TestBrowseTUI(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-browse")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	file := filepath.Join(tmpdir, "file1")
+	require.NoError(t, ioutil.WriteFile(file, []byte("first file"), 0644))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, ioutil.WriteFile(script,
+		[]byte("#!/bin/sh\n"+strings.Repeat("echo hello\n", 30)+"echo last\n"), 0755))
+	art := filepath.Join(tmpdir, "artifact.mender")
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module",
+		"-f", file, "-s", script, "-o", art})
+	require.NoError(t, err)
+
+	f, err := os.Open(art)
+	require.NoError(t, err)
+	defer f.Close()
+	b := newArtifactBrowser(f)
+	require.NoError(t, b.ar.ReadArtifact())
+
+	tui := newBrowseTUI("artifact.mender: release-1", b)
+	tui.width, tui.height = 80, 12
+	screen := func() string {
+		return strings.Join(tui.render(), "\n")
+	}
+	press := func(keys ...string) {
+		for _, key := range keys {
+			tui.handleKey(key)
+		}
+	}
+
+	lines := tui.render()
+	require.Len(t, lines, 12)
+	videoCodes := regexp.MustCompile("\x1b\\[[0-9]*m")
+	for _, line := range lines {
+		assert.Equal(t, 80, utf8.RuneCountInString(videoCodes.ReplaceAllString(line, "")))
+	}
+	assert.Contains(t, lines[0], " artifact.mender: release-1")
+	// The tree is on the left and the header of the Artifact on the right.
+	assert.Contains(t, lines[1], "Header")
+	assert.Contains(t, lines[1], "|Mender Artifact:")
+	assert.Contains(t, lines[2], "  Name: release-1")
+	assert.Contains(t, screen(), "- Payloads")
+	assert.Contains(t, screen(), "  + 0: my-module")
+	assert.Contains(t, screen(), "    ArtifactInstall_Enter_|")
+
+	// Expand the payload and select its file.
+	press(keyDown, keyDown, keyDown, keyRight, keyDown)
+	assert.Contains(t, screen(), "      file1")
+	assert.Contains(t, screen(), "|  - checksum: ")
+	assert.Contains(t, screen(), "name: file1")
+
+	// Left goes back to the payload, and collapses it.
+	press(keyLeft)
+	assert.Contains(t, screen(), "|- Type: my-module")
+	press(keyLeft)
+	assert.NotContains(t, screen(), "file1")
+
+	// Open the state script and scroll through it in the viewer.
+	press(keyEnd, keyEnter)
+	assert.True(t, tui.viewer)
+	assert.Contains(t, screen(), "|#!/bin/sh")
+	assert.NotContains(t, screen(), "echo last")
+	press(keyPageDown, keyPageDown, keyPageDown, keyPageDown)
+	assert.Contains(t, screen(), "|echo last")
+	assert.Contains(t, screen(), "23-32/32")
+	press(keyHome)
+	assert.Contains(t, screen(), "|#!/bin/sh")
+
+	// Back to the tree; up selects the state scripts entry.
+	press(keyTab, keyUp)
+	assert.False(t, tui.viewer)
+	assert.Contains(t, screen(), "State scripts:")
+	assert.False(t, tui.done)
+	press("q")
+	assert.True(t, tui.done)
+}
@@ -0,0 +1,474 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mendersoftware/mender-artifact/cli/util"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+// Key presses, as returned by decodeKeys. Other keys are returned as the
+// character they type.
+const (
+	keyUp       = "\x1b[A"
+	keyDown     = "\x1b[B"
+	keyRight    = "\x1b[C"
+	keyLeft     = "\x1b[D"
+	keyHome     = "\x1b[H"
+	keyEnd      = "\x1b[F"
+	keyPageUp   = "\x1b[5~"
+	keyPageDown = "\x1b[6~"
+	keyEnter    = "\r"
+	keyTab      = "\t"
+	keyEscape   = "\x1b"
+	keyCtrlC    = "\x03"
+)
+
+// Equivalent escape sequences sent by some terminals.
+var keyAliases = map[string]string{
+	"\x1bOA":  keyUp,
+	"\x1bOB":  keyDown,
+	"\x1bOC":  keyRight,
+	"\x1bOD":  keyLeft,
+	"\x1bOH":  keyHome,
+	"\x1bOF":  keyEnd,
+	"\x1b[1~": keyHome,
+	"\x1b[7~": keyHome,
+	"\x1b[4~": keyEnd,
+	"\x1b[8~": keyEnd,
+	"\n":      keyEnter,
+}
+
+// decodeKeys splits the bytes read from a terminal in raw mode into key
+// presses.
+func decodeKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		n := 1
+		if b[0] == 0x1b && len(b) > 1 && (b[1] == '[' || b[1] == 'O') {
+			// An escape sequence ends with its first byte in @ to ~.
+			n = 2
+			for n < len(b) && (b[n] < 0x40 || b[n] > 0x7e) {
+				n++
+			}
+			if n < len(b) {
+				n++
+			}
+		} else if b[0] >= utf8.RuneSelf {
+			_, n = utf8.DecodeRune(b)
+		}
+		key := string(b[:n])
+		if alias, ok := keyAliases[key]; ok {
+			key = alias
+		}
+		keys = append(keys, key)
+		b = b[n:]
+	}
+	return keys
+}
+
+// browseNode is an entry of the tree in the left pane of the browser, with the
+// text shown for it in the right pane.
+type browseNode struct {
+	title    string
+	content  string
+	children []*browseNode
+	expanded bool
+}
+
+// browseRow is a visible line of the tree.
+type browseRow struct {
+	node  *browseNode
+	depth int
+}
+
+// browseTUI is the state of the full screen browser: the tree of the Artifact
+// on the left, and the viewer for the selected entry on the right.
+type browseTUI struct {
+	title    string
+	nodes    []*browseNode
+	selected int  // Index of the selected row of the tree.
+	treeTop  int  // First row of the tree shown.
+	scroll   int  // First line of the viewer shown.
+	viewer   bool // Whether the keys move the viewer instead of the tree.
+	width    int
+	height   int
+	done     bool
+}
+
+// captureOutput returns what print writes to stdout, so that the printers of
+// the read command can fill the viewer.
+func captureOutput(print func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err.Error()
+	}
+	done := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		done <- data
+	}()
+	orgStdout := os.Stdout
+	os.Stdout = w
+	print()
+	os.Stdout = orgStdout
+	w.Close()
+	data := <-done
+	r.Close()
+	return string(data)
+}
+
+// newBrowseTUI builds the tree of the Artifact read by b: the header, the
+// signature, the payloads with their files, and the state scripts.
+func newBrowseTUI(title string, b *artifactBrowser) *browseTUI {
+	header := &browseNode{
+		title:   tr("Header"),
+		content: captureOutput(func() { printArtifactInfo(b.ar, b.sigInfo) }),
+	}
+	signature := &browseNode{
+		title:   tr("Signature"),
+		content: fmt.Sprintf("%s: %s\n", tr("Signature"), tr(b.sigInfo)),
+	}
+
+	payloads := &browseNode{
+		title:    tr("Payloads"),
+		content:  captureOutput(b.listPayloads),
+		expanded: true,
+	}
+	updates := b.ar.GetHandlers()
+	indices := make([]int, 0, len(updates))
+	for i := range updates {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	for _, i := range indices {
+		p := updates[i]
+		updType := "Empty type"
+		if t := p.GetUpdateType(); t != nil {
+			updType = *t
+		}
+		node := &browseNode{
+			title:   fmt.Sprintf("%d: %s", i, updType),
+			content: captureOutput(func() { printPayload(p, 0) }),
+		}
+		for _, f := range p.GetUpdateAllFiles() {
+			f := f
+			node.children = append(node.children, &browseNode{
+				title: f.Name,
+				content: captureOutput(func() {
+					printFiles([]*handlers.DataFile{f}, p.GetUpdatePayloadSignatures(), 0)
+				}),
+			})
+		}
+		payloads.children = append(payloads.children, node)
+	}
+
+	names := b.scriptNames()
+	scripts := &browseNode{
+		title:    tr("State scripts"),
+		content:  captureOutput(func() { printStateScripts(names, 0) }),
+		expanded: true,
+	}
+	for _, name := range names {
+		scripts.children = append(scripts.children, &browseNode{
+			title:   name,
+			content: string(b.scripts[name]),
+		})
+	}
+
+	return &browseTUI{
+		title: title,
+		nodes: []*browseNode{header, signature, payloads, scripts},
+	}
+}
+
+func (t *browseTUI) rows() []browseRow {
+	var rows []browseRow
+	var walk func(nodes []*browseNode, depth int)
+	walk = func(nodes []*browseNode, depth int) {
+		for _, node := range nodes {
+			rows = append(rows, browseRow{node: node, depth: depth})
+			if node.expanded {
+				walk(node.children, depth+1)
+			}
+		}
+	}
+	walk(t.nodes, 0)
+	return rows
+}
+
+func (t *browseTUI) current() browseRow {
+	return t.rows()[t.selected]
+}
+
+func (t *browseTUI) contentLines() []string {
+	return strings.Split(strings.TrimRight(t.current().node.content, "\n"), "\n")
+}
+
+// paneHeight is the number of lines between the title and the status line.
+func (t *browseTUI) paneHeight() int {
+	if t.height < 3 {
+		return 1
+	}
+	return t.height - 2
+}
+
+func (t *browseTUI) selectRow(i int) {
+	if last := len(t.rows()) - 1; i > last {
+		i = last
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i != t.selected {
+		t.selected = i
+		t.scroll = 0
+	}
+}
+
+func (t *browseTUI) scrollBy(n int) {
+	t.scroll += n
+	if last := len(t.contentLines()) - t.paneHeight(); t.scroll > last {
+		t.scroll = last
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+// handleKey applies a key press, as returned by decodeKeys.
+func (t *browseTUI) handleKey(key string) {
+	switch key {
+	case "q", keyEscape, keyCtrlC:
+		t.done = true
+		return
+	case keyTab:
+		t.viewer = !t.viewer
+		return
+	case keyPageUp:
+		t.scrollBy(-t.paneHeight())
+		return
+	case keyPageDown, " ":
+		t.scrollBy(t.paneHeight())
+		return
+	}
+
+	if t.viewer {
+		switch key {
+		case keyUp, "k":
+			t.scrollBy(-1)
+		case keyDown, "j":
+			t.scrollBy(1)
+		case keyHome, "g":
+			t.scroll = 0
+		case keyEnd, "G":
+			t.scrollBy(len(t.contentLines()))
+		case keyLeft, "h":
+			t.viewer = false
+		}
+		return
+	}
+
+	row := t.current()
+	switch key {
+	case keyUp, "k":
+		t.selectRow(t.selected - 1)
+	case keyDown, "j":
+		t.selectRow(t.selected + 1)
+	case keyHome, "g":
+		t.selectRow(0)
+	case keyEnd, "G":
+		t.selectRow(len(t.rows()) - 1)
+	case keyEnter:
+		if len(row.node.children) > 0 {
+			row.node.expanded = !row.node.expanded
+		} else {
+			t.viewer = true
+		}
+	case keyRight, "l":
+		if len(row.node.children) > 0 && !row.node.expanded {
+			row.node.expanded = true
+		} else {
+			t.viewer = true
+		}
+	case keyLeft, "h":
+		if row.node.expanded {
+			row.node.expanded = false
+			return
+		}
+		rows := t.rows()
+		for i := t.selected - 1; i >= 0; i-- {
+			if rows[i].depth < row.depth {
+				t.selectRow(i)
+				break
+			}
+		}
+	}
+}
+
+// fitWidth makes s printable and exactly width characters wide.
+func fitWidth(s string, width int) string {
+	var line strings.Builder
+	n := 0
+	for _, c := range strings.ReplaceAll(s, "\t", "    ") {
+		if n == width {
+			break
+		}
+		if !unicode.IsPrint(c) {
+			c = '.'
+		}
+		line.WriteRune(c)
+		n++
+	}
+	return line.String() + strings.Repeat(" ", width-n)
+}
+
+const (
+	videoReverse = "\x1b[7m"
+	videoBold    = "\x1b[1m"
+	videoReset   = "\x1b[0m"
+)
+
+// render returns the lines of the screen.
+func (t *browseTUI) render() []string {
+	treeWidth := t.width / 3
+	if treeWidth < 20 {
+		treeWidth = t.width / 2
+	}
+	viewWidth := t.width - treeWidth - 1
+	if viewWidth < 0 {
+		viewWidth = 0
+	}
+	height := t.paneHeight()
+
+	rows := t.rows()
+	if t.selected < t.treeTop {
+		t.treeTop = t.selected
+	}
+	if t.selected >= t.treeTop+height {
+		t.treeTop = t.selected - height + 1
+	}
+	content := t.contentLines()
+
+	lines := make([]string, 0, height+2)
+	lines = append(lines, videoReverse+fitWidth(" "+t.title, t.width)+videoReset)
+	for i := 0; i < height; i++ {
+		var left string
+		if r := t.treeTop + i; r < len(rows) {
+			marker := "  "
+			if len(rows[r].node.children) > 0 {
+				marker = "+ "
+				if rows[r].node.expanded {
+					marker = "- "
+				}
+			}
+			left = fitWidth(strings.Repeat("  ", rows[r].depth)+marker+rows[r].node.title,
+				treeWidth)
+			if r == t.selected {
+				video := videoReverse
+				if t.viewer {
+					video = videoBold
+				}
+				left = video + left + videoReset
+			}
+		} else {
+			left = fitWidth("", treeWidth)
+		}
+		var right string
+		if c := t.scroll + i; c < len(content) {
+			right = content[c]
+		}
+		lines = append(lines, left+"|"+fitWidth(right, viewWidth))
+	}
+	last := t.scroll + height
+	if last > len(content) {
+		last = len(content)
+	}
+	status := fmt.Sprintf(" %d-%d/%d  %s", t.scroll+1, last, len(content),
+		tr("arrows: move, enter: open, tab: switch pane, pgup/pgdn: scroll, q: quit"))
+	lines = append(lines, videoReverse+fitWidth(status, t.width)+videoReset)
+	return lines
+}
+
+func (t *browseTUI) draw(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("\x1b[H")
+	out.WriteString(strings.Join(t.render(), "\r\n"))
+	return out.Flush()
+}
+
+// run shows the browser on the terminal out, reading keys from the terminal
+// in, until the user quits.
+func (t *browseTUI) run(in, out *os.File) error {
+	fd := int(in.Fd())
+	state, err := util.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = util.RestoreTerminal(fd, state) }()
+	// Use the alternate screen, so that the shell is left as it was.
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	// The reader is left blocked on the terminal when the user quits; the
+	// process ends right after.
+	keys := make(chan []byte)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := in.Read(buf)
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+
+	for !t.done {
+		if t.width, t.height, err = util.TerminalSize(int(out.Fd())); err != nil {
+			return err
+		}
+		if err = t.draw(out); err != nil {
+			return err
+		}
+		select {
+		case b, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, key := range decodeKeys(b) {
+				t.handleKey(key)
+			}
+		case <-resized:
+			fmt.Fprint(out, "\x1b[2J")
+		}
+	}
+	return nil
+}
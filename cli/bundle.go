@@ -0,0 +1,101 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/bundle"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+func createBundle(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give one release file", errArtifactInvalidParameters)
+	}
+	output := c.String("output-path")
+	if output == "" {
+		return cli.NewExitError("Please give the bundle to create with --output-path",
+			errArtifactInvalidParameters)
+	}
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	release, err := bundle.ReadRelease(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	// The bundle only appears once it is complete.
+	tmp, err := utils.TempFile(filepath.Dir(output), "bundle")
+	if err != nil {
+		return cli.NewExitError(
+			errors.Wrap(err, "Can not create temporary file for storing bundle"),
+			errArtifactCreate)
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	index, err := bundle.Create(tmp, release, key)
+	if err != nil {
+		return cli.NewExitError("Can not create bundle: "+err.Error(), errArtifactCreate)
+	}
+	if err = tmp.Close(); err != nil {
+		return cli.NewExitError(err, errArtifactCreate)
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return cli.NewExitError("Can not store bundle: "+err.Error(), errArtifactCreate)
+	}
+	fmt.Printf("Bundle '%s' of release %s created\n", output, index.Name)
+	printBundleArtifacts(index)
+	return nil
+}
+
+func verifyBundle(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give one bundle", errArtifactInvalidParameters)
+	}
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.NewExitError("Can not open bundle: "+err.Error(), errArtifactOpen)
+	}
+	defer f.Close()
+
+	index, err := bundle.Verify(f, key)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+	fmt.Printf("Bundle '%s' of release %s verified successfully\n",
+		c.Args().First(), index.Name)
+	printBundleArtifacts(index)
+	return nil
+}
+
+func printBundleArtifacts(index *bundle.Index) {
+	for _, a := range index.Artifacts {
+		fmt.Printf("  %s: %s [%s]\n", a.Name, a.ArtifactName, strings.Join(a.DeviceTypes, ", "))
+	}
+}
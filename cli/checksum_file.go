@@ -0,0 +1,97 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+const checksumFileFlag = "checksum-file"
+
+// checksumFile writes <artifact>.sha256 next to a written Artifact, holding
+// the checksum of the Artifact and the manifest of its contents, in the
+// format of sha256sum. The Artifact is hashed while it is written. The
+// manifest is left out when its checksums are not sha256 ones.
+//
+// The files of the manifest are inside the Artifact, not next to it, so
+// "sha256sum -c" needs --ignore-missing to check the Artifact alone.
+type checksumFile struct {
+	output       string
+	hash         hash.Hash
+	withManifest bool
+}
+
+// newChecksumFile returns the checksumFile of the Artifact written to
+// output, or nil if --checksum-file is not set.
+func newChecksumFile(c *cli.Context, output string) (*checksumFile, error) {
+	if !c.Bool(checksumFileFlag) {
+		return nil, nil
+	}
+	if output == "-" {
+		return nil, cli.NewExitError("--"+checksumFileFlag+
+			" can not be used when writing the Artifact to stdout",
+			errArtifactInvalidParameters)
+	}
+	algorithm := c.String("checksum-algorithm")
+	return &checksumFile{
+		output:       output,
+		hash:         sha256.New(),
+		withManifest: algorithm == "" || algorithm == artifact.ChecksumSHA256,
+	}, nil
+}
+
+// wrap returns a writer which also hashes what is written to w.
+func (f *checksumFile) wrap(w io.Writer) io.Writer {
+	if f == nil {
+		return w
+	}
+	return io.MultiWriter(w, f.hash)
+}
+
+// write writes the checksum file, once the Artifact is written.
+func (f *checksumFile) write(manifest []byte) error {
+	if f == nil {
+		return nil
+	}
+	path := f.output + ".sha256"
+	out, err := os.Create(path)
+	if err != nil {
+		return cli.NewExitError(
+			errors.Wrap(err, "can not create checksum file").Error(), errArtifactCreate)
+	}
+	_, err = fmt.Fprintf(out, "%x  %s\n", f.hash.Sum(nil), filepath.Base(f.output))
+	if err == nil && f.withManifest {
+		_, err = out.Write(manifest)
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return cli.NewExitError(
+			errors.Wrap(err, "can not write checksum file").Error(), errArtifactCreate)
+	}
+	return nil
+}
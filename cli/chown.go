@@ -0,0 +1,156 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// idEntry is a line of a passwd or group file: the name, the user or group
+// ID, and for passwd the primary group ID.
+type idEntry struct {
+	name string
+	id   int
+	gid  int
+}
+
+// parseIDFile parses a passwd or group file, skipping comments and lines it
+// can not parse.
+func parseIDFile(data string) []idEntry {
+	var entries []idEntry
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Split(line, ":")
+		if strings.HasPrefix(line, "#") || len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		entry := idEntry{name: fields[0], id: id, gid: -1}
+		if len(fields) > 3 {
+			if gid, err := strconv.Atoi(fields[3]); err == nil {
+				entry.gid = gid
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// readImageIDFile reads the passwd or group file of the image.
+func readImageIDFile(image VPImage, name string) ([]idEntry, error) {
+	dir, err := utils.TempDir("", "chown")
+	if err != nil {
+		return nil, err
+	}
+	defer utils.RemoveTemp(dir)
+	host := filepath.Join(dir, filepath.Base(name))
+	if err = CopyFromImage(image, name, host); err != nil {
+		return nil, errors.Wrapf(err, "can not read %s of the image", name)
+	}
+	data, err := ioutil.ReadFile(host)
+	if err != nil {
+		return nil, err
+	}
+	return parseIDFile(string(data)), nil
+}
+
+// resolveImageOwner resolves the USER[:GROUP] of --chown to user and group
+// IDs. Names are looked up in the /etc/passwd and /etc/group of the image,
+// since the users of the host have nothing to do with those of the device.
+// Without a group, the primary group of the user is used.
+func resolveImageOwner(image VPImage, spec string) (int, int, error) {
+	user, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		user, group = spec[:i], spec[i+1:]
+		if group == "" {
+			return -1, -1, errors.Errorf("invalid owner %q: the group is empty", spec)
+		}
+	}
+	if user == "" {
+		return -1, -1, errors.Errorf("invalid owner %q: the user is empty", spec)
+	}
+
+	uid, err := strconv.Atoi(user)
+	userIsID := err == nil
+	gid, err := strconv.Atoi(group)
+	groupIsID := err == nil
+
+	if !userIsID || group == "" {
+		passwd, err := readImageIDFile(image, "/etc/passwd")
+		if err != nil {
+			return -1, -1, err
+		}
+		var entry *idEntry
+		for i := range passwd {
+			if (!userIsID && passwd[i].name == user) || (userIsID && passwd[i].id == uid) {
+				entry = &passwd[i]
+				break
+			}
+		}
+		switch {
+		case entry == nil && !userIsID:
+			return -1, -1, errors.Errorf("user %q not found in /etc/passwd of the image", user)
+		case entry == nil || (group == "" && entry.gid < 0):
+			return -1, -1, errors.Errorf("the primary group of user %q is not in"+
+				" /etc/passwd of the image; give the group as %s:GROUP", user, user)
+		}
+		uid = entry.id
+		if group == "" {
+			return uid, entry.gid, nil
+		}
+	}
+	if groupIsID {
+		return uid, gid, nil
+	}
+
+	groups, err := readImageIDFile(image, "/etc/group")
+	if err != nil {
+		return -1, -1, err
+	}
+	for _, entry := range groups {
+		if entry.name == group {
+			return uid, entry.id, nil
+		}
+	}
+	return -1, -1, errors.Errorf("group %q not found in /etc/group of the image", group)
+}
+
+// chownInstalled sets the owner of the file or directory opened with
+// virtualImage.OpenFile or OpenDir to the --chown owner.
+func chownInstalled(v interface{}, spec string) error {
+	var image VPImage
+	switch v := v.(type) {
+	case *vImageAndFile:
+		image = v.image
+	case *vImageAndDir:
+		image = v.image
+	default:
+		return errors.New("can not change the owner of files in this image")
+	}
+	uid, gid, err := resolveImageOwner(image, spec)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(chownVP(v, uid, gid), "can not change the owner")
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/ext4"
+)
+
+func TestInstallChown(t *testing.T) {
+	tmp := t.TempDir()
+	img := filepath.Join(tmp, "rootfs.img")
+	require.NoError(t, copyFile("mender_test.img", img))
+	makeFile(t, tmp, "passwd", "# users\nroot:x:0:0:root:/root:/bin/sh\n"+
+		"mender:x:1000:1001::/home/mender:/bin/sh\n")
+	makeFile(t, tmp, "group", "root:x:0:\nmender:x:1001:\nadmin:x:27:mender\n")
+	makeFile(t, tmp, "key", "secret")
+	for _, name := range []string{"passwd", "group"} {
+		require.NoError(t, Run([]string{"mender-artifact", "install", "-m", "0644",
+			filepath.Join(tmp, name), img + ":/etc/" + name}))
+	}
+
+	owner := func(path string) (uint32, uint32) {
+		fs, err := ext4.Open(img, os.O_RDONLY)
+		require.NoError(t, err)
+		defer fs.Close()
+		info, err := fs.Stat(path)
+		require.NoError(t, err)
+		st := info.Sys().(*ext4.Stat)
+		return st.Uid, st.Gid
+	}
+
+	tests := map[string]struct {
+		owner    string
+		uid, gid uint32
+		err      string
+	}{
+		"names":         {owner: "mender:admin", uid: 1000, gid: 27},
+		"primary group": {owner: "mender", uid: 1000, gid: 1001},
+		"numeric":       {owner: "1234:5678", uid: 1234, gid: 5678},
+		"numeric user":  {owner: "1000", uid: 1000, gid: 1001},
+		"unknown user":  {owner: "nobody:admin", err: `user "nobody" not found`},
+		"unknown group": {owner: "mender:wheel", err: `group "wheel" not found`},
+		"no group":      {owner: "1234", err: "the primary group of user \"1234\""},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := Run([]string{"mender-artifact", "install", "-m", "0600",
+				"--chown", test.owner, filepath.Join(tmp, "key"), img + ":/etc/mender/key"})
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			uid, gid := owner("/etc/mender/key")
+			assert.Equal(t, test.uid, uid)
+			assert.Equal(t, test.gid, gid)
+		})
+	}
+
+	require.NoError(t, Run([]string{"mender-artifact", "install", "-d",
+		"--chown", "mender", img + ":/home/mender"}))
+	uid, gid := owner("/home/mender")
+	assert.Equal(t, uint32(1000), uid)
+	assert.Equal(t, uint32(1001), gid)
+}
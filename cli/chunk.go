@@ -0,0 +1,101 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/chunk"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+func chunkArtifact(c *cli.Context) error {
+	if c.String("store") == "" {
+		return cli.NewExitError("Please give the chunk store directory with --store",
+			errArtifactInvalidParameters)
+	}
+	store, err := chunk.OpenStore(c.String("store"))
+	if err != nil {
+		return cli.NewExitError(err, errSystemError)
+	}
+	if c.String("restore") != "" {
+		return restoreChunkedArtifact(c, store)
+	}
+
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give one Artifact to store, or --restore",
+			errArtifactInvalidParameters)
+	}
+	path := c.Args().First()
+	f, err := os.Open(path)
+	if err != nil {
+		return cli.NewExitError("Can not open artifact: "+path, errArtifactOpen)
+	}
+	defer f.Close()
+
+	if err = areader.NewReader(f).ReadArtifactHeaders(); err != nil {
+		return cli.NewExitError("Can not read artifact: "+err.Error(), errArtifactInvalid)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return cli.NewExitError(err, errSystemError)
+	}
+
+	index, stats, err := store.Put(filepath.Base(path), f)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	fmt.Printf("Stored %s as %s\n", index.Name, index.Digest)
+	fmt.Printf("%d chunks, %d new; %d of %d bytes stored\n",
+		stats.Chunks, stats.NewChunks, stats.NewBytes, index.Size)
+	return nil
+}
+
+func restoreChunkedArtifact(c *cli.Context, store *chunk.Store) error {
+	index, err := store.Index(c.String("restore"))
+	if err != nil {
+		return cli.NewExitError(err, errArtifactInvalidParameters)
+	}
+	output := c.String("output-path")
+	if output == "" {
+		output = filepath.Base(index.Name)
+	}
+
+	tmp, err := utils.TempFile(filepath.Dir(output), "chunk")
+	if err != nil {
+		return cli.NewExitError(
+			errors.Wrap(err, "Can not create temporary file for storing artifact"), 1)
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	if err = store.Get(index, tmp); err != nil {
+		return cli.NewExitError("Can not restore artifact: "+err.Error(), 1)
+	}
+	if err = tmp.Close(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return cli.NewExitError("Can not store artifact: "+err.Error(), 1)
+	}
+	fmt.Printf("Restored %s to %s\n", index.Digest, output)
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkArtifact(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	rootfs := filepath.Join(dir, "update.ext4")
+	require.NoError(t, os.WriteFile(rootfs, bytes.Repeat([]byte("rootfs "), 200000), 0644))
+
+	var digests []string
+	for _, name := range []string{"release-1", "release-2"} {
+		art := filepath.Join(dir, name+".mender")
+		require.NoError(t, Run([]string{"mender-artifact", "--compression", "none",
+			"write", "rootfs-image", "-o", art, "-n", name, "-t", "vexpress", "-f", rootfs}))
+
+		out, err := runAndCollectStdout([]string{"mender-artifact", "chunk",
+			"--store", store, art})
+		require.NoError(t, err)
+		m := regexp.MustCompile(`Stored ` + name + `.mender as (sha256:[0-9a-f]{64})\n` +
+			`(\d+) chunks, (\d+) new`).FindStringSubmatch(out)
+		require.NotNil(t, m, out)
+		digests = append(digests, m[1])
+		if name == "release-2" {
+			// Only the chunks with the header differ.
+			assert.NotEqual(t, m[2], m[3], out)
+		}
+	}
+
+	for i, name := range []string{"release-1", "release-2"} {
+		restored := filepath.Join(dir, "restored.mender")
+		_, err := runAndCollectStdout([]string{"mender-artifact", "chunk",
+			"--store", store, "--restore", digests[i], "-o", restored})
+		require.NoError(t, err)
+		expected, err := os.ReadFile(filepath.Join(dir, name+".mender"))
+		require.NoError(t, err)
+		actual, err := os.ReadFile(restored)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+
+	err := Run([]string{"mender-artifact", "chunk", "--store", store, rootfs})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Can not read artifact")
+
+	err = Run([]string{"mender-artifact", "chunk", rootfs})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--store")
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// cleanupTemp lists the temporary files and directories which other
+// mender-artifact processes left behind, and removes those of processes
+// which are no longer running with --remove.
+func cleanupTemp(c *cli.Context) error {
+	leftovers, err := utils.FindLeftoverTemp(c.String("dir"))
+	if err != nil {
+		return cli.NewExitError("Can not list temporary files: "+err.Error(), errSystemError)
+	}
+	var size, removed int64
+	for _, l := range leftovers {
+		status := fmt.Sprintf("left by process %d", l.PID)
+		switch {
+		case l.Running:
+			status = fmt.Sprintf("in use by running process %d", l.PID)
+		case c.Bool("remove"):
+			if err = utils.RemoveTemp(l.Path); err != nil {
+				return cli.NewExitError("Can not remove "+l.Path+": "+err.Error(),
+					errSystemError)
+			}
+			status = "removed"
+			removed += l.Size
+		}
+		size += l.Size
+		fmt.Printf("%s: %d bytes, %s\n", l.Path, l.Size, status)
+	}
+	fmt.Printf("%d temporary files, %d bytes; %d bytes removed\n",
+		len(leftovers), size, removed)
+	return nil
+}
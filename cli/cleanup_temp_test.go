@@ -0,0 +1,57 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+func TestCleanupTemp(t *testing.T) {
+	dir := t.TempDir()
+	out, err := runAndCollectStdout([]string{"mender-artifact", "cleanup-temp",
+		"--dir", dir})
+	require.NoError(t, err)
+	assert.Equal(t, "0 temporary files, 0 bytes; 0 bytes removed", out)
+
+	left := filepath.Join(dir, utils.TempPrefix+"999999999-data")
+	require.NoError(t, os.WriteFile(left, []byte("data"), 0644))
+	running := filepath.Join(dir, fmt.Sprintf("%s%d-file", utils.TempPrefix, os.Getppid()))
+	require.NoError(t, os.WriteFile(running, []byte("in use"), 0644))
+
+	out, err = runAndCollectStdout([]string{"mender-artifact", "cleanup-temp",
+		"--dir", dir})
+	require.NoError(t, err)
+	assert.Contains(t, out, left+": 4 bytes, left by process 999999999\n")
+	assert.Contains(t, out, fmt.Sprintf("%s: 6 bytes, in use by running process %d\n",
+		running, os.Getppid()))
+	assert.Contains(t, out, "2 temporary files, 10 bytes; 0 bytes removed")
+	assert.FileExists(t, left)
+
+	out, err = runAndCollectStdout([]string{"mender-artifact", "cleanup-temp",
+		"--dir", dir, "--remove"})
+	require.NoError(t, err)
+	assert.Contains(t, out, left+": 4 bytes, removed\n")
+	assert.Contains(t, out, "2 temporary files, 10 bytes; 4 bytes removed")
+	assert.NoFileExists(t, left)
+	assert.FileExists(t, running)
+}
@@ -16,11 +16,17 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/artifact/sigstore"
+	"github.com/mendersoftware/mender-artifact/utils"
 
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 )
 
@@ -42,6 +48,8 @@ const (
 	softwareNameFlag             = "software-name"
 	softwareVersionFlag          = "software-version"
 	softwareFilesystemFlag       = "software-filesystem"
+	setGroupFlag                 = "set-group"
+	clearGroupFlag               = "clear-group"
 )
 
 // Version of the mender-artifact CLI tool
@@ -83,6 +91,14 @@ GLOBAL OPTIONS:
    NOTE:
        For the commands <write>, <modify>, the '--compression' flag functions as
        a global option
+
+       Every option can also be given in a MENDER_ARTIFACT_<OPTION> environment
+       variable, e.g. MENDER_ARTIFACT_OUTPUT_PATH for '--output-path', and in the
+       config file ~/.mender-artifact.yaml (or $MENDER_ARTIFACT_CONFIG), which maps
+       option names to values. The command line takes precedence over the
+       environment, which takes precedence over the config file. '--version'
+       has no variable, and variables with invalid values are ignored with a
+       warning.
 `
 
 func applyCompressionInCommand(c *cli.Context) error {
@@ -94,7 +110,25 @@ func applyCompressionInCommand(c *cli.Context) error {
 	return nil
 }
 
+// getCompressor returns the compressor selected with --compression, with the
+// options given with --compression-opt.
+func getCompressor(c *cli.Context) (artifact.Compressor, error) {
+	id := c.GlobalString("compression")
+	comp, err := artifact.NewCompressorFromId(id)
+	if err != nil {
+		return nil, errors.Errorf("compressor '%s' is not supported: %s", id, err.Error())
+	}
+	comp, err = artifact.ApplyCompressorOptions(comp, c.StringSlice("compression-opt"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "compressor '%s'", id)
+	}
+	return artifact.LimitCompressorConcurrency(comp, cpuLimit), nil
+}
+
 func Run(args []string) error {
+	collectedWarnings.reset()
+	// Remove the temporary files the command leaves behind, also when it fails.
+	defer utils.CleanupTemp()
 	return getCliContext().Run(args)
 }
 
@@ -109,6 +143,11 @@ func getCliContext() *cli.App {
 	app.Email = "contact@northern.tech"
 
 	app.EnableBashCompletion = true
+	// Exit errors end the process before Run returns, and its deferred cleanup.
+	app.ExitErrHandler = func(c *cli.Context, err error) {
+		_ = utils.CleanupTemp()
+		cli.HandleExitCoder(err)
+	}
 
 	compressors := artifact.GetRegisteredCompressorIds()
 
@@ -117,6 +156,25 @@ func getCliContext() *cli.App {
 		Usage: fmt.Sprintf("Compression to use for the artifact, "+
 			"currently supports: %v.", strings.Join(compressors, ", ")),
 	}
+	compressionOptFlag := cli.StringSliceFlag{
+		Name: "compression-opt",
+		Usage: "Option for the compressor, can be given multiple times. Currently " +
+			"supports: " + artifact.CompressorOptionRsyncable + " (gzip and zstd), which " +
+			"compresses the Artifact in independent parts so that binary diffs between " +
+			"releases are smaller, at a small cost in size.",
+	}
+	uncompressedHeaderFlag := cli.BoolFlag{
+		Name: "uncompressed-header",
+		Usage: "Store the Artifact header without compression, so that devices with" +
+			" little memory do not need to decompress it. The payloads are still compressed.",
+	}
+	checksumAlgorithmFlag := cli.StringFlag{
+		Name: "checksum-algorithm",
+		Usage: "Hash algorithm of the checksums of the Artifact: " +
+			strings.Join(artifact.ChecksumAlgorithms, ", ") + ". Other algorithms than" +
+			" sha256 are recorded in the Artifact, and need a reader which supports them",
+		Value: artifact.ChecksumSHA256,
+	}
 	globalCompressionFlag := compressionFlag
 	// The global flag is the last fallback, so here we provide a default.
 	globalCompressionFlag.Value = "gzip"
@@ -125,7 +183,7 @@ func getCliContext() *cli.App {
 	privateKeyFlag := cli.StringFlag{
 		Name: "key, k",
 		Usage: "Full path to the private key that will be used to sign " +
-			"the Artifact.",
+			"the Artifact. Use /dev/fd/N to read it from an inherited file descriptor.",
 	}
 
 	gcpKMSKeyFlag := cli.StringFlag{
@@ -134,6 +192,14 @@ func getCliContext() *cli.App {
 			"the Artifact.",
 	}
 
+	keyProviderFlag := cli.StringFlag{
+		Name: "key-provider",
+		Usage: "Key given as <provider>:<argument>, where the provider is one of " +
+			strings.Join(GetRegisteredKeyProviders(), ", ") + ". The argument is the key" +
+			" file for 'file', the name of the environment variable holding the key for" +
+			" 'env', and what the flag of the same key backend takes otherwise.",
+	}
+
 	signserverWorkerName := cli.StringFlag{
 		Name: "keyfactor-signserver-worker",
 		Usage: "The name of the SignServer worker that will be used to sign " +
@@ -150,6 +216,23 @@ func getCliContext() *cli.App {
 			"to sign can be specified with VAULT_KEY_VERSION environment variable.",
 	}
 
+	sigstoreSignFlag := cli.StringFlag{
+		Name: "sigstore",
+		Usage: "Sign the Artifact with Sigstore, using the cosign tool, either '" +
+			sigstore.KeylessArgument + "' to sign with a short lived certificate for an" +
+			" OIDC identity, or with the cosign private key in the given file. The" +
+			" signature is recorded in the Rekor transparency log, and the cosign" +
+			" bundle is stored as the signature of the Artifact.",
+	}
+
+	sigstoreVerifyFlag := cli.StringFlag{
+		Name: "sigstore",
+		Usage: "Verify a Sigstore signature and its Rekor entry with the cosign tool," +
+			" using the cosign public key in the given file, or else the certificate" +
+			" identity, such as an email address, which must have signed the Artifact," +
+			" issued by the OIDC issuer given in " + sigstore.OIDCIssuerEnv + ".",
+	}
+
 	pkcs11Flag := cli.StringFlag{
 		Name:  "key-pkcs11",
 		Usage: "Use PKCS#11 interface to sign and verify artifacts",
@@ -161,9 +244,26 @@ func getCliContext() *cli.App {
 			"the Artifact signature.",
 	}
 
+	readBufferSizeFlag := cli.IntFlag{
+		Name: "read-buffer-size",
+		Usage: "Size in bytes of the reads issued when reading the Artifact. " +
+			"Larger values speed up reading from network mounts.",
+	}
+
+	readAheadFlag := cli.IntFlag{
+		Name: "read-ahead",
+		Usage: "Number of read buffers to prefetch in the background while " +
+			"reading the Artifact.",
+	}
+
 	//
 	// Common Artifact flags
 	//
+	normalizeDeviceTypes := cli.BoolFlag{
+		Name:  "normalize-device-types",
+		Usage: "Trim whitespace around device types and lowercase them",
+	}
+
 	artifactName := cli.StringFlag{
 		Name:     "artifact-name, n",
 		Usage:    "Name of the artifact",
@@ -182,12 +282,77 @@ func getCliContext() *cli.App {
 		Name:  "depends-groups, G",
 		Usage: "The group(s) the artifact depends on",
 	}
+	artifactNotDeviceType := cli.StringSliceFlag{
+		Name: "not-device-type",
+		Usage: "A device type the artifact must never be installed on, even if it is" +
+			" compatible. Clients released before this option ignore it, and the server" +
+			" does not filter deployments by it, so also keep these devices out of the" +
+			" deployments. Can be given multiple times",
+	}
+	artifactImmutableMetadata := cli.BoolFlag{
+		Name: "immutable-metadata",
+		Usage: "Lock the Artifact metadata; the modify, clone and merge commands will" +
+			" refuse to change the Artifact unless --force-unlock is given. The lock" +
+			" only binds this tool: it is stored in the payload type-info, which" +
+			" clients and older versions of this tool read, but ignore",
+	}
 	artifactAddScripts := cli.StringSliceFlag{
 		Name: "script, s",
 		Usage: "Adds additional state script to an already existing artifact." +
 			"You can specify multiple scripts providing this parameter multiple times.",
 	}
 
+	scriptDirFlag := cli.StringSliceFlag{
+		Name: "script-dir",
+		Usage: "Full path to a directory of state scripts, which are collected from all" +
+			" its subdirectories. Can be given multiple times; scripts of the same state" +
+			" must have distinct ordering numbers across all the directories",
+	}
+	deviceTypeScriptFlag := cli.StringSliceFlag{
+		Name: "script-for-device-type",
+		Usage: "DEVICE-TYPE:PATH of a state script which only runs on devices of" +
+			" DEVICE-TYPE, which must be one of the compatible device types. Can be" +
+			" given multiple times; requires Artifact version 3. The name must differ" +
+			" from those of all the other scripts, and Mender clients which do not" +
+			" understand device type scripts run it on devices of every type.",
+	}
+	writeDryRunFlag := cli.BoolFlag{
+		Name: "dry-run",
+		Usage: "Check the state scripts and print them in the order they run, without" +
+			" writing the Artifact",
+	}
+	checksumFile := cli.BoolFlag{
+		Name: checksumFileFlag,
+		Usage: "Also write <output-path>.sha256, with the checksum of the Artifact and," +
+			" for sha256 checksums, of each file in it, in the format of sha256sum. The" +
+			" files in the Artifact are not next to it, so check it with" +
+			" 'sha256sum -c --ignore-missing'",
+	}
+
+	writeStats := cli.BoolFlag{
+		Name: writeStatsFlag,
+		Usage: "Print the input and output size, the compression ratio and the time" +
+			" spent for the header and each payload of the Artifact to stderr",
+	}
+
+	bufferMemory := cli.Int64Flag{
+		Name: "buffer-memory",
+		Usage: "Number of bytes of the header and of each payload kept in memory while" +
+			" composing the Artifact, before they are buffered in temporary files." +
+			" By default, temporary files are always used",
+	}
+	reproducible := cli.BoolFlag{
+		Name: "reproducible",
+		Usage: "Write the same Artifact for the same input: all the files in it get the" +
+			" modification time of the global --source-date-epoch, or of the Unix epoch," +
+			" and no owner",
+	}
+	tempDir := cli.StringFlag{
+		Name: "temp-dir",
+		Usage: "Directory for the temporary files used while composing the Artifact," +
+			" instead of the default one",
+	}
+
 	// Common Software Version flags
 	softwareVersionNoDefault := cli.BoolFlag{
 		Name:  noDefaultSoftwareVersionFlag,
@@ -215,9 +380,12 @@ func getCliContext() *cli.App {
 		Usage: "Generic `KEY:VALUE` which is added to the type-info -> artifact_depends section." +
 			" Can be given multiple times",
 	}
-	payloadMetaData := cli.StringFlag{
-		Name:  "meta-data, m",
-		Usage: "The meta-data JSON `FILE` for this payload",
+	payloadMetaData := cli.StringSliceFlag{
+		Name: "meta-data, m",
+		Usage: "The meta-data JSON `FILE` for this payload, or a directory of meta-data:" +
+			" each subdirectory is an object and each .json file the value of a key of" +
+			" the same name. Can be given multiple times to merge several, but a key" +
+			" can not be set to different values",
 	}
 	clearsArtifactProvides := cli.StringSliceFlag{
 		Name:  clearsProvidesFlag,
@@ -227,13 +395,21 @@ func getCliContext() *cli.App {
 		Name:  noDefaultClearsProvidesFlag,
 		Usage: "Do not add any default clears_artifact_provides fields to Artifact payload",
 	}
+	profileFlag := cli.StringFlag{
+		Name: "profile",
+		Usage: "Use the defaults of the format version, compression, checksum provide," +
+			" clears provides and software version flags for a generation of Mender" +
+			" clients, and warn about the features of the Artifact it does not handle." +
+			" Flags which are given explicitly take precedence. `PROFILE` is one of " +
+			writeProfileNames(),
+	}
 
 	//
 	// write
 	//
 	writeRootfsCommand := cli.Command{
 		Name:   "rootfs-image",
-		Action: writeRootfs,
+		Action: withProfileCheck(writeRootfs),
 		Usage:  "Writes Mender artifact containing rootfs image",
 	}
 
@@ -252,6 +428,7 @@ func getCliContext() *cli.App {
 				"compatible devices providing this parameter multiple times.",
 			Required: true,
 		},
+		normalizeDeviceTypes,
 		artifactName,
 		cli.StringFlag{
 			Name:  "output-path, o",
@@ -264,6 +441,7 @@ func getCliContext() *cli.App {
 		},
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		vaultTransitKeyFlag,
 		signserverWorkerName,
 		cli.StringSliceFlag{
@@ -271,6 +449,14 @@ func getCliContext() *cli.App {
 			Usage: "Full path to the state script(s). You can specify multiple " +
 				"scripts providing this parameter multiple times.",
 		},
+		scriptDirFlag,
+		deviceTypeScriptFlag,
+		writeDryRunFlag,
+		checksumFile,
+		writeStats,
+		bufferMemory,
+		tempDir,
+		reproducible,
 		cli.BoolFlag{
 			Name: "legacy-rootfs-image-checksum",
 			Usage: "Use the legacy key name rootfs_image_checksum to store the providese checksum" +
@@ -282,6 +468,20 @@ func getCliContext() *cli.App {
 				"parameters. This is needed in case the targeted devices do not support " +
 				"provides and depends yet.",
 		},
+		cli.BoolFlag{
+			Name: "detect-platform",
+			Usage: "Detect the architecture of the rootfs image from /bin/sh, and its" +
+				" operating system from its os-release file, and store them in the " +
+				artifact.RootfsImageArchKey + " and " + artifact.RootfsImageOSKey +
+				" provides, unless given with --provides. Needs an ext4 image.",
+		},
+		cli.BoolFlag{
+			Name: "verity",
+			Usage: "Append a dm-verity hash tree (sha256, 4096 byte blocks, no superblock)" +
+				" to the rootfs image, and store its root hash, salt and hash offset in the" +
+				" rootfs-image.verity.* provides. The image size must be a multiple of" +
+				" 4096 bytes.",
+		},
 		cli.StringSliceFlag{
 			Name: "ssh-args, S",
 			Usage: "Arguments to pass to ssh - only applies when " +
@@ -298,11 +498,17 @@ func getCliContext() *cli.App {
 		artifactNameDepends,
 		artifactProvidesGroup,
 		artifactDependsGroups,
+		artifactNotDeviceType,
+		artifactImmutableMetadata,
 		payloadDepends,
 		payloadProvides,
 		clearsArtifactProvides,
 		noDefaultClearsArtifactProvides,
 		compressionFlag,
+		compressionOptFlag,
+		uncompressedHeaderFlag,
+		checksumAlgorithmFlag,
+		profileFlag,
 		//////////////////////
 		// Sotware versions //
 		//////////////////////
@@ -316,14 +522,14 @@ func getCliContext() *cli.App {
 		softwareFilesystem,
 	}
 
-	writeRootfsCommand.Before = applyCompressionInCommand
+	writeRootfsCommand.Before = applyProfileInCommand
 
 	//
 	// Update modules: module-image
 	//
 	writeModuleCommand := cli.Command{
 		Name:   "module-image",
-		Action: writeModuleImage,
+		Action: withProfileCheck(writeModuleImage),
 		Usage:  "Writes Mender artifact for an update module",
 		UsageText: "Writes a generic Mender artifact that will be used by an update module. " +
 			"This command is not meant to be used directly, but should rather be wrapped by an " +
@@ -340,6 +546,7 @@ func getCliContext() *cli.App {
 				"compatible devices providing this parameter multiple times.",
 			Required: true,
 		},
+		normalizeDeviceTypes,
 		cli.StringFlag{
 			Name:  "output-path, o",
 			Usage: "Full path to output artifact file, '-' for stdout.",
@@ -354,22 +561,67 @@ func getCliContext() *cli.App {
 			Usage: "Full path to the state script(s). You can specify multiple " +
 				"scripts providing this parameter multiple times.",
 		},
+		scriptDirFlag,
+		deviceTypeScriptFlag,
+		writeDryRunFlag,
+		checksumFile,
+		writeStats,
+		bufferMemory,
+		tempDir,
+		reproducible,
 		artifactName,
 		artifactNameDepends,
 		artifactProvidesGroup,
 		artifactDependsGroups,
+		artifactNotDeviceType,
+		artifactImmutableMetadata,
 		cli.StringFlag{
 			Name:     "type, T",
 			Usage:    "Type of payload. This is the same as the name of the update module",
 			Required: true,
 		},
 		payloadProvides,
+		cli.StringSliceFlag{
+			Name: "provides-for-device",
+			Usage: "`DEVICE-TYPE=KEY:VALUE` which is added to the type-info ->" +
+				" artifact_provides section for that device type only. Can be given" +
+				" multiple times. One Artifact is written for each device type, named" +
+				" after the output path with the device type added",
+		},
 		payloadDepends,
 		payloadMetaData,
+		cli.BoolFlag{
+			Name: "sanitize-filenames",
+			Usage: "Rename payload files whose names are not allowed in an Artifact," +
+				" or which have the same name, instead of failing. The original names" +
+				" are recorded in the payload meta-data under " +
+				artifact.OriginalFileNamesMetaDataKey,
+		},
 		cli.StringSliceFlag{
 			Name:  "file, f",
 			Usage: "Include `FILE` in payload. Can be given more than once.",
 		},
+		cli.StringFlag{
+			Name: "dir",
+			Usage: "Include all files in the directory tree `DIR` in payload. Their" +
+				" paths relative to DIR are stored in the meta-data under \"" +
+				artifact.FilePathsMetaDataKey + "\", by their name in the payload," +
+				" which is made unique if needed.",
+		},
+		cli.StringFlag{
+			Name: "files-from",
+			Usage: "Include the files in the tar or zip `ARCHIVE` in payload, under" +
+				" their base names. Tar archives may be gzip, xz or zstd compressed," +
+				" and are streamed without being extracted.",
+		},
+		cli.StringSliceFlag{
+			Name: "payload",
+			Usage: "Add another payload after the one given by the other flags, described by" +
+				" a comma separated list of `KEY=VALUE` pairs: type (required), file," +
+				" provides, depends and clears-provides, which may be repeated, and" +
+				" meta-data. Can be given multiple times, e.g." +
+				" --payload type=config,file=app.conf,provides=config.version:2",
+		},
 		cli.StringFlag{
 			Name:  "augment-type",
 			Usage: "Type of augmented payload. This is the same as the name of the update module",
@@ -384,19 +636,55 @@ func getCliContext() *cli.App {
 			Usage: "Generic `KEY:VALUE` which is added to the augmented type-info ->" +
 				" artifact_depends section. Can be given multiple times",
 		},
-		cli.StringFlag{
-			Name:  "augment-meta-data",
-			Usage: "The meta-data JSON `FILE` for this payload, for the augmented section",
+		cli.StringSliceFlag{
+			Name: "augment-meta-data",
+			Usage: "The meta-data JSON `FILE` or directory for this payload, for the" +
+				" augmented section. Can be given multiple times, like --meta-data",
 		},
 		cli.StringSliceFlag{
 			Name:  "augment-file",
 			Usage: "Include `FILE` in payload in the augment section. Can be given more than once.",
 		},
+		cli.BoolFlag{
+			Name: "deduplicate-augment-files",
+			Usage: "Store augment files with the same content as another file of the payload" +
+				" as a link to it, instead of a second copy. Only readers which resolve" +
+				" such links, like mender-artifact itself, can read the Artifact",
+		},
 		clearsArtifactProvides,
 		noDefaultClearsArtifactProvides,
+		cli.StringFlag{
+			Name: "payload-sign-key",
+			Usage: "Full path to the private key `FILE` used to sign each payload file" +
+				" individually, independently of the Artifact signature. The signatures" +
+				" are stored in type-info, for update modules to verify",
+		},
+		cli.Int64Flag{
+			Name: "install-size",
+			Usage: "Declared install size of the payload in `BYTES`, for update modules whose" +
+				" footprint on the device differs from the payload size. Defaults to" +
+				" the total size of the payload files",
+		},
+		cli.StringFlag{
+			Name: "delta-base",
+			Usage: "Write a delta Artifact against the Artifact `FILE`: only the files which" +
+				" are new or changed compared to its payload are included, the removed" +
+				" files are listed in the meta-data, and the Artifact depends on it",
+		},
+		cli.StringSliceFlag{
+			Name: "external-payload",
+			Usage: "Write a thin Artifact, which stores the `URL` each --file is" +
+				" downloaded from, with its checksum and size, instead of the file." +
+				" Give it once for every --file, in the same order",
+		},
 		compressionFlag,
+		compressionOptFlag,
+		uncompressedHeaderFlag,
+		checksumAlgorithmFlag,
+		profileFlag,
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		vaultTransitKeyFlag,
 		signserverWorkerName,
 		//////////////////////
@@ -411,7 +699,93 @@ func getCliContext() *cli.App {
 		softwareVersionValue,
 		softwareFilesystem,
 	}
-	writeModuleCommand.Before = applyCompressionInCommand
+	writeModuleCommand.Before = applyProfileInCommand
+
+	//
+	// Source tarballs: src-tarball
+	//
+	writeSrcTarballCommand := cli.Command{
+		Name:   "src-tarball",
+		Action: writeSrcTarball,
+		Usage:  "Writes Mender artifact for a source or configuration tarball",
+		UsageText: "Writes a module-image Artifact with the tarball as the only payload file." +
+			" A build-id computed from the contents of the tarball is provided as" +
+			" rootfs-image.PAYLOAD_TYPE.build-id, next to the software version, and is" +
+			" cleared by the default clears provides. The build-id does not depend on" +
+			" timestamps, ownership, entry order or compression of the tarball.",
+	}
+
+	writeSrcTarballCommand.CustomHelpTemplate = CustomSubcommandHelpTemplate
+
+	writeSrcTarballCommand.Flags = []cli.Flag{
+		cli.StringSliceFlag{
+			Name: "device-type, t",
+			Usage: "Type of device(s) supported by the Artifact. You can specify multiple " +
+				"compatible devices providing this parameter multiple times.",
+			Required: true,
+		},
+		normalizeDeviceTypes,
+		cli.StringFlag{
+			Name:  "output-path, o",
+			Usage: "Full path to output artifact file, '-' for stdout.",
+		},
+		cli.IntFlag{
+			Name:  "version, v",
+			Usage: "Version of the artifact.",
+			Value: LatestFormatVersion,
+		},
+		cli.StringSliceFlag{
+			Name: "script, s",
+			Usage: "Full path to the state script(s). You can specify multiple " +
+				"scripts providing this parameter multiple times.",
+		},
+		scriptDirFlag,
+		deviceTypeScriptFlag,
+		writeDryRunFlag,
+		checksumFile,
+		writeStats,
+		bufferMemory,
+		tempDir,
+		reproducible,
+		artifactName,
+		artifactNameDepends,
+		artifactProvidesGroup,
+		artifactDependsGroups,
+		artifactNotDeviceType,
+		cli.StringFlag{
+			Name:  "type, T",
+			Usage: "Type of payload. This is the same as the name of the update module",
+			Value: "src-tarball",
+		},
+		payloadProvides,
+		payloadDepends,
+		payloadMetaData,
+		cli.StringSliceFlag{
+			Name:     "file, f",
+			Usage:    "The tarball `FILE`, optionally gzip, xz or zstd compressed.",
+			Required: true,
+		},
+		clearsArtifactProvides,
+		noDefaultClearsArtifactProvides,
+		compressionFlag,
+		compressionOptFlag,
+		uncompressedHeaderFlag,
+		checksumAlgorithmFlag,
+		privateKeyFlag,
+		gcpKMSKeyFlag,
+		keyProviderFlag,
+		vaultTransitKeyFlag,
+		signserverWorkerName,
+		softwareVersionNoDefault,
+		cli.StringFlag{
+			Name: softwareNameFlag,
+			Usage: "Name of the key to store the software version and build-id:" +
+				" rootfs-image.NAME.version, instead of rootfs-image.PAYLOAD_TYPE.version",
+		},
+		softwareVersionValue,
+		softwareFilesystem,
+	}
+	writeSrcTarballCommand.Before = applyCompressionInCommand
 
 	//
 	// Write Bootstrap artifact
@@ -424,14 +798,31 @@ func getCliContext() *cli.App {
 
 	writeBootstrapArtifactCommand.CustomHelpTemplate = CustomSubcommandHelpTemplate
 
+	// Given by --from-device-json otherwise.
+	bootstrapArtifactName := artifactName
+	bootstrapArtifactName.Required = false
+
 	writeBootstrapArtifactCommand.Flags = []cli.Flag{
+		checksumFile,
+		writeStats,
+		bufferMemory,
+		tempDir,
+		reproducible,
 		cli.StringSliceFlag{
 			Name: "device-type, t",
 			Usage: "Type of device(s) supported by the Artifact. You can specify multiple " +
 				"compatible devices providing this parameter multiple times.",
-			Required: true,
 		},
-		artifactName,
+		normalizeDeviceTypes,
+		bootstrapArtifactName,
+		cli.StringFlag{
+			Name: "from-device-json",
+			Usage: "Read the device type, the Artifact name and group and the payload" +
+				" provides from the device description in `FILE`, unless given on the" +
+				` command line: a JSON object like {"device_type": "...", "provides":` +
+				` {"artifact_name": "...", ...}}, with the provides mender-update` +
+				" show-provides prints",
+		},
 		cli.StringFlag{
 			Name:  "output-path, o",
 			Usage: "Full path to output artifact file, '-' for standard output.",
@@ -446,11 +837,15 @@ func getCliContext() *cli.App {
 			Usage: "Suppress the progressbar output",
 		},
 		compressionFlag,
+		compressionOptFlag,
+		uncompressedHeaderFlag,
+		checksumAlgorithmFlag,
 		clearsArtifactProvides,
 		payloadProvides,
 		payloadDepends,
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		signserverWorkerName,
 		vaultTransitKeyFlag,
 		/////////////////////////
@@ -459,6 +854,7 @@ func getCliContext() *cli.App {
 		artifactNameDepends,
 		artifactProvidesGroup,
 		artifactDependsGroups,
+		artifactNotDeviceType,
 	}
 
 	writeBootstrapArtifactCommand.Before = applyCompressionInCommand
@@ -470,6 +866,7 @@ func getCliContext() *cli.App {
 		Subcommands: []cli.Command{
 			writeRootfsCommand,
 			writeModuleCommand,
+			writeSrcTarballCommand,
 			writeBootstrapArtifactCommand,
 		},
 	}
@@ -483,13 +880,83 @@ func getCliContext() *cli.App {
 		Category:    "Artifact creation and validation",
 		Action:      validateArtifact,
 		UsageText:   "mender-artifact validate [options] <pathspec>",
-		Description: "This command validates artifact file provided by pathspec.",
+		Description: "This command validates artifact file provided by pathspec ('-' for stdin).",
 		Flags: []cli.Flag{
 			publicKeyFlag,
 			gcpKMSKeyFlag,
+			keyProviderFlag,
 			signserverWorkerName,
 			vaultTransitKeyFlag,
 			pkcs11Flag,
+			sigstoreVerifyFlag,
+			readBufferSizeFlag,
+			readAheadFlag,
+			noProgressFlag,
+			cli.IntFlag{
+				Name: "checksum-jobs",
+				Usage: "Hash up to `N` payload files at once, on other CPUs than the one" +
+					" reading the Artifact. 0 hashes each file as it is read",
+			},
+			cli.BoolFlag{
+				Name: "json",
+				Usage: "Print the result as a JSON report of the signature, the checksum" +
+					" of every file of the manifest, the payloads and the provides and" +
+					" depends, instead of a message. The exit code is the same",
+			},
+			cli.StringFlag{
+				Name: "attestation",
+				Usage: "Verify that the device which produced the attestation " +
+					"`FILE` (a signed TPM quote of its provides) runs the Artifact.",
+			},
+			cli.StringFlag{
+				Name:  "attestation-key",
+				Usage: "Public PEM `KEY` of the device attestation key.",
+			},
+			cli.StringFlag{
+				Name:  "attestation-nonce",
+				Usage: "`NONCE` the attestation must have been made for.",
+			},
+			cli.BoolFlag{
+				Name: "fetch-external",
+				Usage: "Download the payload files of thin Artifacts from their URLs," +
+					" and verify their checksums and sizes",
+			},
+			cli.StringFlag{
+				Name: "target-client",
+				Usage: "Warn about features of the Artifact which the Mender client " +
+					"`VERSION` (e.g. 3.3) does not handle, like clears_artifact_provides, " +
+					"bootstrap payloads or zstd compression.",
+			},
+		},
+	}
+
+	validateBatchCommand := cli.Command{
+		Name:      "validate-batch",
+		Usage:     "Validates many Artifacts in parallel.",
+		Category:  "Artifact creation and validation",
+		Action:    validateArtifactBatch,
+		ArgsUsage: "<directory or artifact>...",
+		Description: "Validates the given Artifacts, and the Artifacts ending in .mender in" +
+			" the given directories and their subdirectories, like 'validate --json'." +
+			" Prints the result of each Artifact and a summary, and fails if any" +
+			" Artifact is invalid.",
+		Flags: []cli.Flag{
+			publicKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+			sigstoreVerifyFlag,
+			cli.IntFlag{
+				Name:  "jobs, j",
+				Usage: "Validate `N` Artifacts at a time [default: the number of CPUs, or --cpu-limit]",
+			},
+			cli.StringFlag{
+				Name: "report",
+				Usage: "Write the result of every Artifact and the summary as JSON to" +
+					" `FILE`",
+			},
 		},
 	}
 
@@ -502,17 +969,52 @@ func getCliContext() *cli.App {
 		ArgsUsage:   "<artifact path>",
 		Category:    "Artifact inspection",
 		Action:      readArtifact,
-		Description: "This command validates artifact file provided by pathspec.",
+		Description: "This command reads artifact file provided by pathspec ('-' for stdin).",
 		Flags: []cli.Flag{
 			publicKeyFlag,
 			gcpKMSKeyFlag,
+			keyProviderFlag,
 			signserverWorkerName,
 			vaultTransitKeyFlag,
 			pkcs11Flag,
+			readBufferSizeFlag,
+			readAheadFlag,
 			cli.BoolFlag{
 				Name:  "no-progress",
 				Usage: "Suppress the progressbar output",
 			},
+			cli.BoolFlag{
+				Name: "translate-legacy-provides",
+				Usage: "Show legacy payload provides under their current names," +
+					" e.g. rootfs_image_checksum as rootfs-image.checksum",
+			},
+			cli.BoolFlag{
+				Name: "multi",
+				Usage: "Read every Artifact in a file of several Artifacts" +
+					" concatenated together",
+			},
+			cli.StringFlag{
+				Name: "plan",
+				Usage: "Print which payloads a device with the files in `CACHE`" +
+					" (a JSON list of sha256 checksums) needs to download",
+			},
+			cli.StringSliceFlag{
+				Name: "field",
+				Usage: "Print only the value of `FIELD`, e.g. name or" +
+					" provides.rootfs-image.version, one per line." +
+					" Can be given several times",
+			},
+			cli.BoolFlag{
+				Name: "json",
+				Usage: "Print the fields given with --field as a JSON object," +
+					" or all of them without --field",
+			},
+			cli.BoolFlag{
+				Name: "bootstrap-check",
+				Usage: "Fail unless the Artifact is a valid bootstrap Artifact: with" +
+					" empty payloads, an Artifact name, device types and no depends on" +
+					" an installed Artifact",
+			},
 		},
 	}
 
@@ -531,18 +1033,34 @@ func getCliContext() *cli.App {
 	sign.Flags = []cli.Flag{
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		signserverWorkerName,
 		vaultTransitKeyFlag,
+		sigstoreSignFlag,
 		cli.StringFlag{
 			Name: "output-path, o",
-			Usage: "Full path to output signed artifact file; " +
+			Usage: "Full path to output signed artifact file, '-' for stdout; " +
 				"if none is provided existing artifact will be replaced with the signed one",
 		},
 		cli.BoolFlag{
 			Name:  "force, f",
 			Usage: "Force creating new signature if the artifact is already signed",
 		},
+		cli.BoolFlag{
+			Name: "add-signature",
+			Usage: "Add the signature to the ones the Artifact has already, so that" +
+				" devices holding any of the public keys accept it. Needs a version" +
+				" 3 Artifact when it is already signed, and a reader which" +
+				" understands several signatures.",
+		},
+		noProgressFlag,
 		pkcs11Flag,
+		noLockFlag,
+		lockTimeoutFlag,
+		backupFlag,
+		backupSuffixFlag,
+		backupDirFlag,
+		backupKeepFlag,
 	}
 
 	//
@@ -555,7 +1073,8 @@ func getCliContext() *cli.App {
 		Action:    modifyArtifact,
 		UsageText: "mender-artifact modify [options] <pathspec>",
 		Description: "This command modifies existing image or artifact file provided by pathspec." +
-			" NOTE: Currently only ext4 payloads can be modified",
+			" NOTE: Currently only ext4, btrfs and xfs payloads can be modified; btrfs and" +
+			" xfs payloads are loop mounted, which needs root privileges",
 	}
 
 	modify.Flags = []cli.Flag{
@@ -584,6 +1103,7 @@ func getCliContext() *cli.App {
 		artifactNameDepends,
 		artifactProvidesGroup,
 		artifactDependsGroups,
+		artifactNotDeviceType,
 		artifactAddScripts,
 		payloadProvides,
 		payloadDepends,
@@ -593,15 +1113,49 @@ func getCliContext() *cli.App {
 			Name:  deleteClearsProvidesFlag,
 			Usage: "Erase one \"Clears Provides\" filter from the Artifact.",
 		},
+		cli.StringFlag{
+			Name: setGroupFlag,
+			Usage: "Make devices installing the Artifact join the artifact_group `GROUP`," +
+				" replacing the group they are in",
+		},
+		cli.BoolFlag{
+			Name: clearGroupFlag,
+			Usage: "Make devices installing the Artifact leave their artifact_group. The" +
+				" Artifact then provides no group, and clears artifact_group, as" +
+				" rootfs-image Artifacts do by default; without that clears provides," +
+				" devices would stay in their old group",
+		},
+		cli.BoolFlag{
+			Name:  "force-unlock",
+			Usage: "Modify the Artifact even if it was written with --immutable-metadata",
+		},
+		cli.BoolFlag{
+			Name: "normalize-device-types",
+			Usage: "Trim whitespace around the device types of the Artifact and" +
+				" lowercase them",
+		},
 		cli.StringFlag{
 			Name:  "tenant-token, t",
 			Usage: "Full path to the tenant token that will be injected into modified file.",
 		},
+		cli.IntFlag{
+			Name: "tenant-token-fd",
+			Usage: "Read the tenant token from the inherited file descriptor `FD`" +
+				" instead, so that it does not show in the process list.",
+		},
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		signserverWorkerName,
 		vaultTransitKeyFlag,
 		compressionFlag,
+		compressionOptFlag,
+		noLockFlag,
+		lockTimeoutFlag,
+		backupFlag,
+		backupSuffixFlag,
+		backupDirFlag,
+		backupKeepFlag,
 	}
 	modify.Before = func(c *cli.Context) error {
 		if c.String("name") != "" {
@@ -610,23 +1164,44 @@ func getCliContext() *cli.App {
 		return applyCompressionInCommand(c)
 	}
 
+	dryRunFlag := cli.BoolFlag{
+		Name: "dry-run",
+		Usage: "Print the files that would be added, replaced or removed, without" +
+			" modifying the image",
+	}
+
 	copy := cli.Command{
 		Name:        "cp",
-		Usage:       "cp <src> <dst>",
+		Usage:       "cp [-r] <src> <dst>",
 		Category:    "Artifact modification",
-		Description: "Copies a file into or out of a mender artifact, or sdimg",
+		Description: "Copies a file or directory into or out of a mender artifact, or sdimg",
 		UsageText: "Copy from or into an artifact, or sdimg where either the <src>" +
 			" or <dst> has to be of the form [artifact|sdimg]:<filepath>, <src> can" +
-			"come from stdin in the case that <src> is '-'",
+			"come from stdin in the case that <src> is '-'. With -r, directories are" +
+			" copied with everything below them, and a <dst> ending with '/' is a" +
+			" directory to copy <src> into",
 		Action: Copy,
 	}
 
 	copy.Flags = []cli.Flag{
 		compressionFlag,
+		cli.BoolFlag{
+			Name:  "recursive, r",
+			Usage: "Copy directories recursively, preserving the file permissions",
+		},
+		dryRunFlag,
+		noProgressFlag,
 		privateKeyFlag,
 		gcpKMSKeyFlag,
+		keyProviderFlag,
 		signserverWorkerName,
 		vaultTransitKeyFlag,
+		noLockFlag,
+		lockTimeoutFlag,
+		backupFlag,
+		backupSuffixFlag,
+		backupDirFlag,
+		backupKeepFlag,
 	}
 
 	cat := cli.Command{
@@ -637,6 +1212,26 @@ func getCliContext() *cli.App {
 		Action:      Cat,
 	}
 
+	ls := cli.Command{
+		Name:  "ls",
+		Usage: "ls [-l] [-R] [artifact|sdimg|uefiimg]:<path>",
+		Description: "Lists the contents of a directory, or a single file, in a mender artifact" +
+			" or mender image.",
+		Category: "Artifact modification",
+		Action:   List,
+	}
+
+	ls.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "long, l",
+			Usage: "Show the mode, size and modification time of every entry",
+		},
+		cli.BoolFlag{
+			Name:  "recursive, R",
+			Usage: "List the subdirectories recursively",
+		},
+	}
+
 	install := cli.Command{
 		Name: "install",
 		Usage: "install -m <permissions> <hostfile> [artifact|sdimg|uefiimg]:<filepath> or" +
@@ -656,6 +1251,19 @@ func getCliContext() *cli.App {
 			Name:  "directory, d",
 			Usage: "Create a directory inside an artifact",
 		},
+		cli.StringFlag{
+			Name: "chown",
+			Usage: "Set the owner of the file or directory to `USER[:GROUP]`, which are" +
+				" names in the /etc/passwd and /etc/group of the image, or numeric IDs." +
+				" Without a group, the primary group of the user is used",
+		},
+		dryRunFlag,
+		noLockFlag,
+		lockTimeoutFlag,
+		backupFlag,
+		backupSuffixFlag,
+		backupDirFlag,
+		backupKeepFlag,
 	}
 
 	remove := cli.Command{
@@ -671,6 +1279,621 @@ func getCliContext() *cli.App {
 			Name:  "recursive, r",
 			Usage: "remove directories and their contents recursively",
 		},
+		dryRunFlag,
+		noLockFlag,
+		lockTimeoutFlag,
+		backupFlag,
+		backupSuffixFlag,
+		backupDirFlag,
+		backupKeepFlag,
+	}
+
+	dataPartitionFileFlag := cli.StringFlag{
+		Name:  "file, f",
+		Usage: "The tar archive `FILE` holding the data partition contents",
+		Value: "-",
+	}
+
+	dataPartitionCommand := cli.Command{
+		Name:     "data-partition",
+		Usage:    "Exports or imports the contents of the data partition of an sdimg.",
+		Category: "Artifact modification",
+		Subcommands: []cli.Command{
+			{
+				Name:      "export",
+				Usage:     "Archives the data partition contents of an sdimg as a tar file.",
+				ArgsUsage: "<sdimg>",
+				Action:    exportDataPartition,
+				Flags:     []cli.Flag{dataPartitionFileFlag},
+			},
+			{
+				Name: "import",
+				Usage: "Restores data partition contents of an sdimg from a tar file," +
+					" replacing existing files.",
+				ArgsUsage: "<sdimg>",
+				Action:    importDataPartition,
+				Flags:     []cli.Flag{dataPartitionFileFlag},
+			},
+		},
+	}
+
+	//
+	// upgrade
+	//
+	upgrade := cli.Command{
+		Name:      "upgrade",
+		Usage:     "Converts a version 2 Artifact to version 3.",
+		Category:  "Artifact modification",
+		ArgsUsage: "<v2-artifact>",
+		Description: "Rewrites a version 2 rootfs-image Artifact as a version 3 Artifact with" +
+			" equivalent metadata. The artifact name is provided as the" +
+			" rootfs-image.version, and the rootfs-image.checksum is computed, as" +
+			" \"write rootfs-image\" does by default.",
+		Action: upgradeArtifact,
+	}
+	upgrade.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:     "output-path, o",
+			Usage:    "Full path to the output Artifact",
+			Required: true,
+		},
+		privateKeyFlag,
+		gcpKMSKeyFlag,
+		keyProviderFlag,
+		signserverWorkerName,
+		vaultTransitKeyFlag,
+		compressionFlag,
+		compressionOptFlag,
+	}
+	upgrade.Before = applyCompressionInCommand
+
+	//
+	// clone
+	//
+	clone := cli.Command{
+		Name:      "clone",
+		Usage:     "Copies an Artifact under a new name.",
+		Category:  "Artifact modification",
+		ArgsUsage: "<artifact>",
+		Description: "Rewrites only the headers and the manifest of a version 3 Artifact," +
+			" streaming the payloads through unchanged, so that even large Artifacts" +
+			" are re-released under a new name almost instantly. The payloads, and" +
+			" the artifact_info file in root filesystems, are not modified. The clone" +
+			" is unsigned unless a signing key is given.",
+		Action: cloneArtifact,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "output-path, o",
+				Usage:    "Full path to the cloned Artifact, '-' for stdout",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "artifact-name, n",
+				Usage:    "Name of the cloned Artifact",
+				Required: true,
+			},
+			payloadProvides,
+			cli.BoolFlag{
+				Name:  "force-unlock",
+				Usage: "Clone the Artifact even if it was written with --immutable-metadata",
+			},
+			privateKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+		},
+	}
+
+	//
+	// merge
+	//
+	merge := cli.Command{
+		Name:      "merge",
+		Usage:     "Combines the payloads of several Artifacts into one Artifact.",
+		Category:  "Artifact modification",
+		ArgsUsage: "<merged artifact> <artifact> <artifact>...",
+		Description: "Writes one Artifact with the payloads of the given single payload" +
+			" version 3 Artifacts, in the given order, with their type-info, meta-data" +
+			" and state scripts. The Artifacts must be compatible with the same device" +
+			" types, and have the same group provides and depends, which the merged" +
+			" Artifact keeps. Their payloads must not provide or depend on the same" +
+			" keys. The merged Artifact is unsigned unless a signing key is given.",
+		Action: mergeArtifacts,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "artifact-name, n",
+				Usage: "Name of the merged Artifact [default: the name of the first Artifact]",
+			},
+			cli.BoolFlag{
+				Name:  "force-unlock",
+				Usage: "Merge the Artifacts even if they were written with --immutable-metadata",
+			},
+			privateKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+			compressionFlag,
+			compressionOptFlag,
+		},
+	}
+	merge.Before = applyCompressionInCommand
+
+	//
+	// mutate
+	//
+	mutate := cli.Command{
+		Name:      "mutate",
+		Usage:     "Writes a deliberately broken copy of an Artifact.",
+		Category:  "Artifact creation and validation",
+		ArgsUsage: "<artifact>",
+		Description: "Copies the Artifact with one fault introduced, such as a missing" +
+			" file or a wrong checksum in the manifest, to test how clients and servers" +
+			" handle malformed Artifacts. The faults are: " + faultNames() + "." +
+			" Signatures are copied unchanged, and do not match a changed manifest.",
+		Action: mutateArtifact,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "output-path, o",
+				Usage:    "Full path to the mutated Artifact, '-' for stdout",
+				Required: true,
+			},
+			cli.StringFlag{
+				Name:     "fault",
+				Usage:    "The `FAULT` to introduce",
+				Required: true,
+			},
+		},
+	}
+
+	//
+	// assemble
+	//
+	assemble := cli.Command{
+		Name:      "assemble",
+		Usage:     "Recreates an Artifact from the files dumped by the dump command.",
+		Category:  "Artifact creation and validation",
+		ArgsUsage: "<descriptor>",
+		Description: "Writes the Artifact described by the descriptor written by" +
+			" 'dump --descriptor', from the files dumped with --files, --meta-data and" +
+			" --scripts. With the global --source-date-epoch flag, writing the dumped" +
+			" files of an Artifact written with the same flag gives an identical" +
+			" Artifact, except for the signature. The compression is the one of the" +
+			" dumped Artifact; as the zstd level can not be read from an Artifact, give" +
+			" --compression to select the right one.",
+		Action: assembleArtifact,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:     "output-path, o",
+				Usage:    "Full path to the assembled Artifact, '-' for stdout",
+				Required: true,
+			},
+			compressionFlag,
+		},
+	}
+
+	//
+	// cleanup-temp
+	//
+	cleanupTempCommand := cli.Command{
+		Name:     "cleanup-temp",
+		Usage:    "Lists and removes temporary files left behind.",
+		Category: "Artifact modification",
+		Description: "mender-artifact removes its temporary files when it exits, even on" +
+			" errors and interrupts, but not when it is killed. This lists the ones" +
+			" left in the temporary directory, with the process which created them," +
+			" and removes those of processes which are no longer running with --remove.",
+		Action: cleanupTemp,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "remove",
+				Usage: "Remove the temporary files of processes which are no longer running",
+			},
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "Temporary `DIR` to look in [default: the system temporary directory]",
+			},
+		},
+	}
+
+	//
+	// doctor
+	//
+	doctorCommand := cli.Command{
+		Name:  "doctor",
+		Usage: "Checks for the external tools which some commands need.",
+		Description: "Some commands run external tools, such as debugfs to modify ext4" +
+			" images, or mtools to modify vfat images. This checks that they are" +
+			" installed, in versions which are known to work, and lists the features" +
+			" which are unavailable or degraded without them.",
+		Action: doctor,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the results as JSON",
+			},
+		},
+	}
+
+	//
+	// diff
+	//
+	diffCommand := cli.Command{
+		Name:      "diff",
+		Usage:     "Shows what differs between two Artifacts.",
+		ArgsUsage: "<artifact> <other artifact>",
+		Description: "Reads both Artifacts, verifying all checksums, and compares their" +
+			" header info, provides, depends, clears provides, meta-data, state scripts" +
+			" and the checksums of the payload files. Each difference is printed on a" +
+			" line starting with '+' for fields only the other Artifact has, '-' for" +
+			" fields only the first one has, and '~' for changed values.",
+		Category: "Artifact inspection",
+		Action:   diffArtifacts,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the differences as a JSON list",
+			},
+		},
+	}
+
+	//
+	// browse
+	//
+	browseCommand := cli.Command{
+		Name:      "browse",
+		Usage:     "Interactively browses the contents of an Artifact.",
+		ArgsUsage: "<artifact>",
+		Description: "Reads the whole Artifact, verifying all checksums, and then shows it" +
+			" full screen: a tree of its header, signature, payloads with their files," +
+			" and state scripts on the left, and the selected entry, such as the" +
+			" contents of a state script, on the right. The arrow keys move in the" +
+			" tree, enter opens an entry, tab switches between the panes and q quits." +
+			" When the input or output is not a terminal, commands are read line by" +
+			" line instead; type 'help' at the prompt for a list of commands.",
+		Category: "Artifact inspection",
+		Action:   browseArtifact,
+		Flags: []cli.Flag{
+			publicKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+			readBufferSizeFlag,
+			readAheadFlag,
+		},
+	}
+
+	//
+	// inspect-signature
+	//
+	inspectSignatureCommand := cli.Command{
+		Name:      "inspect-signature",
+		Usage:     "Reports the signature state of an Artifact through the exit code.",
+		ArgsUsage: "<artifact>",
+		Description: "Reads the whole Artifact, verifying all checksums, and reports the" +
+			" state of its signature with the exit code, so that pipelines can enforce" +
+			" signing policies with a single call:\n" +
+			"    0: signed, and verified with the given key or certificate\n" +
+			fmt.Sprintf("   %d: unsigned\n", exitSignatureUnsigned) +
+			fmt.Sprintf("   %d: signed, but no key was given or the signature does not"+
+				" match it\n", exitSignatureUnknownKey) +
+			fmt.Sprintf("   %d: signed and verified with the given certificate, which is"+
+				" expired or not yet valid\n", exitSignatureExpiredCert) +
+			fmt.Sprintf("   %d: invalid parameters\n", exitSignatureInvalidParameters) +
+			"   Other codes mean that the Artifact could not be read.",
+		Category: "Artifact inspection",
+		Action:   inspectSignature,
+		Flags: []cli.Flag{
+			publicKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+			readBufferSizeFlag,
+			readAheadFlag,
+			cli.StringFlag{
+				Name: "certificate, c",
+				Usage: "Full path to the PEM encoded X.509 certificate whose key" +
+					" should have signed the Artifact.",
+			},
+		},
+	}
+
+	//
+	// bench
+	//
+	benchCommand := cli.Command{
+		Name:   "bench",
+		Usage:  "Measures how fast Artifacts are written, read and validated.",
+		Hidden: true,
+		Description: "Writes, reads and validates Artifacts with synthetic payloads of the" +
+			" given sizes, with each of the given compressors, and prints the throughput" +
+			" of every step, so that numbers can be compared between releases.",
+		Action: benchArtifact,
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name: "size",
+				Usage: "Size of the synthetic payload, such as 100MiB, 1GiB or 8GiB;" +
+					" can be given multiple times [default: 100MiB]",
+			},
+			cli.StringSliceFlag{
+				Name: "compression",
+				Usage: "Compressor to benchmark; can be given multiple times" +
+					" [default: all of " + strings.Join(artifact.GetRegisteredCompressorIds(), ", ") + "]",
+			},
+			cli.StringFlag{
+				Name:  "dir",
+				Usage: "Directory for the temporary payload and Artifact",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the results as JSON",
+			},
+		},
+	}
+
+	//
+	// audit
+	//
+	pushCommand := cli.Command{
+		Name:      "push",
+		Usage:     "Pushes an Artifact to an OCI registry.",
+		ArgsUsage: "<artifact path> oci://<registry>/<repository>:<tag>",
+		Category:  "Artifact distribution",
+		Action:    pushArtifact,
+		Description: "Stores the Artifact as an OCI artifact, so that it can be kept in" +
+			" the same registries as container images. The Artifact name, device types" +
+			" and provides are added to the manifest as annotations.",
+		Flags: ociFlags,
+	}
+
+	pullCommand := cli.Command{
+		Name:        "pull",
+		Usage:       "Pulls an Artifact from an OCI registry.",
+		ArgsUsage:   "oci://<registry>/<repository>:<tag>",
+		Category:    "Artifact distribution",
+		Action:      pullArtifact,
+		Description: "Fetches an Artifact stored with the push command.",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name: "output-path, o",
+				Usage: "Full path to the pulled Artifact; by default it is stored in" +
+					" the current directory under its name when pushed",
+			},
+		}, ociFlags...),
+	}
+
+	bundleCommand := cli.Command{
+		Name:     "bundle",
+		Usage:    "Creates and verifies release bundles of several Artifacts.",
+		Category: "Artifact distribution",
+		Description: "A release bundle packages the Artifacts of a release, such as an" +
+			" application, a root filesystem and a configuration Artifact, together with" +
+			" an index of their checksums, signed as a whole, so that the release can be" +
+			" distributed and verified as one unit.",
+		Subcommands: []cli.Command{
+			{
+				Name:      "create",
+				Usage:     "Creates a bundle of the Artifacts listed in a release file.",
+				ArgsUsage: "<release.yaml>",
+				Description: "The release file gives the name of the release and its" +
+					" Artifacts:\n\n" +
+					"   name: release-1\n" +
+					"   artifacts:\n" +
+					"     - name: rootfs\n" +
+					"       path: rootfs.mender\n" +
+					"     - name: app\n" +
+					"       path: app.mender\n\n" +
+					"Relative paths are relative to the release file. Every Artifact is" +
+					" checked before it is bundled, and the bundle is only written once" +
+					" it is complete.",
+				Action: createBundle,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "output-path, o",
+						Usage: "Full path to the bundle",
+					},
+					privateKeyFlag,
+					gcpKMSKeyFlag,
+					keyProviderFlag,
+					signserverWorkerName,
+					vaultTransitKeyFlag,
+					pkcs11Flag,
+				},
+			},
+			{
+				Name:      "verify",
+				Usage:     "Verifies a bundle and every Artifact in it.",
+				ArgsUsage: "<bundle>",
+				Action:    verifyBundle,
+				Flags: []cli.Flag{
+					publicKeyFlag,
+					gcpKMSKeyFlag,
+					keyProviderFlag,
+					signserverWorkerName,
+					vaultTransitKeyFlag,
+					pkcs11Flag,
+				},
+			},
+		},
+	}
+
+	chunkCommand := cli.Command{
+		Name:      "chunk",
+		Usage:     "Stores an Artifact in a deduplicating chunk store (experimental).",
+		ArgsUsage: "<artifact path>",
+		Category:  "Artifact distribution",
+		Action:    chunkArtifact,
+		Description: "Splits the Artifact into content-defined chunks and stores each" +
+			" chunk once, so that data shared between Artifacts takes no extra space." +
+			" Artifacts are restored byte for byte with --restore <digest>. Payloads" +
+			" written with \"--compression none\" share the most data, as" +
+			" compression hides what is common between them.",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "store",
+				Usage: "The chunk store `DIR`ectory",
+			},
+			cli.StringFlag{
+				Name:  "restore",
+				Usage: "Restore the Artifact with `DIGEST` instead of storing one",
+			},
+			cli.StringFlag{
+				Name: "output-path, o",
+				Usage: "Full path to the restored Artifact; by default it is stored in" +
+					" the current directory under its original name",
+			},
+		},
+	}
+
+	pruneCommand := cli.Command{
+		Name:      "prune",
+		Usage:     "Deletes all but the latest Artifacts in a directory.",
+		ArgsUsage: "<directory>",
+		Category:  "Artifact distribution",
+		Action:    pruneArtifacts,
+		Description: "Reads the headers of the Artifacts in the directory and keeps" +
+			" the latest of them by modification time, printing what is kept and" +
+			" what is deleted. Nothing is deleted unless --delete is given. Files" +
+			" which are not Artifacts are left alone.",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "keep-latest",
+				Usage: "Keep the latest `N` Artifacts",
+				Value: 5,
+			},
+			cli.BoolFlag{
+				Name:  "per-device-type",
+				Usage: "Keep the latest Artifacts of each device type",
+			},
+			cli.BoolFlag{
+				Name:  "delete",
+				Usage: "Delete the Artifacts instead of only printing the plan",
+			},
+		},
+	}
+
+	auditCommand := cli.Command{
+		Name:      "audit",
+		Usage:     "Lists the contents of an Artifact as JSON, for audit systems.",
+		ArgsUsage: "<artifact>",
+		Description: "Reads the whole Artifact, verifying all checksums, and emits its" +
+			" header information, the state scripts and every file in every payload" +
+			" with checksum, size and mode as a JSON document.",
+		Category: "Artifact inspection",
+		Action:   auditArtifact,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "File to write the JSON document to, or - for standard output",
+				Value: "-",
+			},
+			publicKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+			readBufferSizeFlag,
+			readAheadFlag,
+		},
+	}
+
+	//
+	// preflight
+	//
+	preflightCommand := cli.Command{
+		Name:      "preflight",
+		Usage:     "Writes the preflight bundle of an Artifact.",
+		ArgsUsage: "<artifact>",
+		Description: "Writes the Artifact without its payload data: the manifests, the" +
+			" signature and the headers with the state scripts. The bundle is small" +
+			" enough to send to a device or gateway, which can check the signature and" +
+			" the depends with preflight-verify before downloading the whole Artifact.",
+		Category: "Artifact distribution",
+		Action:   preflightArtifact,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output-path, o",
+				Usage: "Full path to the preflight bundle",
+			},
+		},
+	}
+	preflightVerifyCommand := cli.Command{
+		Name:      "preflight-verify",
+		Usage:     "Checks whether a device can install the Artifact of a preflight bundle.",
+		ArgsUsage: "<preflight bundle>",
+		Description: "Verifies the signature of the preflight bundle, if a key is given," +
+			" and checks that a device with the given device type and provides" +
+			" satisfies the depends of the Artifact.",
+		Category: "Artifact distribution",
+		Action:   preflightVerify,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "device-type, t",
+				Usage: "Device type of the device",
+			},
+			cli.StringSliceFlag{
+				Name: "provides, p",
+				Usage: "`KEY:VALUE` provided by the device, such as" +
+					" artifact_name:release-1. Can be given multiple times",
+			},
+			publicKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			pkcs11Flag,
+		},
+	}
+
+	//
+	// mount
+	//
+	mountCommand := cli.Command{
+		Name:      "mount",
+		Usage:     "Mounts the rootfs-image payload of an Artifact read-only.",
+		ArgsUsage: "<artifact> <mountpoint>",
+		Description: "Loop mounts the rootfs-image payload of the Artifact read-only on" +
+			" the mountpoint. Only uncompressed payloads, as written with --compression" +
+			" none, are mounted in place, inside the Artifact. A compressed payload has" +
+			" to be decompressed in full, so it is only mounted with --extract, which" +
+			" needs as much temporary space as the uncompressed payload. Mounting" +
+			" usually requires root privileges; with --print-recipe the commands to" +
+			" mount and clean up are printed instead.",
+		Category: "Artifact inspection",
+		Action:   mountArtifact,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name: "print-recipe",
+				Usage: "Only print the commands to mount the payload. With --extract," +
+					" a compressed payload is extracted by the commands, when they are run",
+			},
+			cli.BoolFlag{
+				Name: "extract",
+				Usage: "Mount a compressed payload by extracting it to a temporary" +
+					" file first",
+			},
+			readBufferSizeFlag,
+			readAheadFlag,
+		},
+	}
+
+	explainPathCommand := cli.Command{
+		Name:      "explain-path",
+		Usage:     "Explains how an image path is interpreted.",
+		ArgsUsage: "[artifact|sdimg|uefiimg|img]:<filepath>",
+		Description: "Prints the image type, the target partitions and the path inside the" +
+			" partitions that the cat, cp, install and rm commands resolve the given" +
+			" path to.",
+		Category: "Artifact modification",
+		Action:   explainPath,
 	}
 
 	//
@@ -681,19 +1904,23 @@ func getCliContext() *cli.App {
 		Usage:     "Dump contents from Artifacts",
 		ArgsUsage: "<Artifact>",
 		Description: "Dump various raw files from the Artifact. These can be used to create a new" +
-			" Artifact with the same components.",
+			" Artifact with the same components. Use '-' to read the Artifact from standard" +
+			" input.",
 		Category: "Artifact inspection",
 		Action:   DumpCommand,
 	}
 	dumpCommand.Flags = []cli.Flag{
 		cli.StringFlag{
-			Name:  "files",
-			Usage: "Dump all included files in the first payload into given folder",
+			Name: "files",
+			Usage: "Dump all included payload files into given folder. The files of" +
+				" Artifacts with several payloads go into a subdirectory for each payload," +
+				" named after its index, such as 0000 and 0001",
 		},
 		cli.StringFlag{
 			Name: "meta-data",
-			Usage: "Dump the contents of the meta-data field in the first payload into given" +
-				" folder",
+			Usage: "Dump the contents of the meta-data field of each payload into given" +
+				" folder, in files named after the index of the payload, such as" +
+				" 0000.meta-data",
 		},
 		cli.StringFlag{
 			Name:  "scripts",
@@ -712,26 +1939,222 @@ func getCliContext() *cli.App {
 			Usage: "Same as 'print-cmdline', except that the arguments are separated by a null" +
 				" character (0x00).",
 		},
+		cli.StringFlag{
+			Name: "descriptor",
+			Usage: "Write the compression and the command line which recreate the Artifact" +
+				" to the given file, for the assemble command. Paths are stored relative to" +
+				" the directory of the descriptor, so that the dumped files can be moved" +
+				" along with it.",
+		},
+		cli.BoolFlag{
+			Name: "salvage",
+			Usage: "Continue past payload files which fail their checksum or can not be read," +
+				" dumping all intact components, and report the damaged ones. The headers" +
+				" must be intact. Fails after dumping if anything was damaged.",
+		},
+	}
+
+	serveCommand := cli.Command{
+		Name:      "serve",
+		Usage:     "Serves Artifact operations over HTTP.",
+		UsageText: "mender-artifact serve [options]",
+		Description: "Runs a server which validates, reads the metadata of, signs and" +
+			" repackages the Artifacts POSTed to it, so that other services do not" +
+			" need to run this tool themselves. The endpoints are " + serveValidatePath +
+			", " + serveMetadataPath + ", " + serveSignPath + " (with force=true to" +
+			" replace an existing signature) and " + serveRepackagePath + " (with" +
+			" compression=<compressor> to change the compression). Signing and" +
+			" repackaging reply with the resulting Artifact, the others with JSON." +
+			" The signing key given is also used to verify signatures. Signing and" +
+			" repackaging are only enabled when clients are authenticated, with" +
+			" --token-file or --tls-client-ca.",
+		Category: "Artifact modification",
+		Action:   serveArtifacts,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name: "listen",
+				Usage: "The address to listen on. Use an address such as :8080 to accept" +
+					" connections from other hosts",
+				Value: "127.0.0.1:8080",
+			},
+			cli.Int64Flag{
+				Name:  "max-upload-size",
+				Usage: "The largest Artifact accepted, in bytes; 0 means no limit",
+				Value: 4 << 30,
+			},
+			cli.DurationFlag{
+				Name:  "request-timeout",
+				Usage: "How long receiving an Artifact, or replying to a request, may take",
+				Value: 30 * time.Minute,
+			},
+			cli.StringFlag{
+				Name: "token-file",
+				Usage: "Require clients to send the token in `FILE` as a bearer token in" +
+					" the Authorization header",
+			},
+			cli.StringFlag{
+				Name:  "tls-cert",
+				Usage: "Serve over HTTPS with the certificate in `FILE`",
+			},
+			cli.StringFlag{
+				Name:  "tls-key",
+				Usage: "The private key of the --tls-cert certificate, in `FILE`",
+			},
+			cli.StringFlag{
+				Name: "tls-client-ca",
+				Usage: "Require clients to present a certificate signed by the CA in" +
+					" `FILE`; needs --tls-cert",
+			},
+			privateKeyFlag,
+			gcpKMSKeyFlag,
+			keyProviderFlag,
+			signserverWorkerName,
+			vaultTransitKeyFlag,
+			sigstoreSignFlag,
+			pkcs11Flag,
+		},
 	}
 
 	globalFlags := []cli.Flag{
 		globalCompressionFlag,
+		cli.StringFlag{
+			Name: "color",
+			Usage: "Whether to use colors in status output: auto, always or never. With auto," +
+				" colors are used on terminals, unless the NO_COLOR environment variable is set",
+			Value: "auto",
+		},
+		cli.StringFlag{
+			Name: "progress",
+			Usage: "Whether to show the progress of commands which unpack and repack" +
+				" images, such as modify and cp: auto, always or never. With auto," +
+				" progress is shown on terminals",
+			Value: "auto",
+		},
+		cli.StringFlag{
+			Name: "warnings-json",
+			Usage: "After running the command, write the warnings it issued as JSON to the" +
+				" given file descriptor number or file",
+		},
+		cli.StringFlag{
+			Name: "source-date-epoch",
+			Usage: "Give all the files in the Artifacts written the modification time of" +
+				" these seconds since the epoch, and no owner, so that writing the same" +
+				" contents again gives an identical Artifact",
+			EnvVar: "SOURCE_DATE_EPOCH",
+		},
+		cli.IntFlag{
+			Name: "cpu-limit",
+			Usage: "Compress and checksum with at most this many CPUs at once, to leave" +
+				" the others to other jobs on the host. 0 uses all of them",
+		},
+		cli.StringFlag{
+			Name: "io-nice",
+			Usage: "Read and write images, also in subprocesses such as debugfs and" +
+				" fsck, with this I/O priority of ionice: idle, or a best-effort level" +
+				" from 0 (highest) to 7 (lowest)",
+		},
+		cli.StringFlag{
+			Name: "lang",
+			Usage: "The language of the output of read, and of the most common error" +
+				" messages: auto, en, de or es. With auto, the language of the locale" +
+				" of LC_ALL, LC_MESSAGES or LANG is used, or English without a" +
+				" translation. Defaults to auto when the output is a terminal, and to en" +
+				" otherwise",
+		},
+	}
+	// Reported once the command line is parsed, so that --help still works.
+	var configErr error
+	app.Before = func(c *cli.Context) error {
+		if configErr != nil {
+			return cli.NewExitError(configErr.Error(), errArtifactInvalidParameters)
+		}
+		if err := setLanguage(c.GlobalString("lang")); err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		for _, flag := range []string{"color", "progress"} {
+			switch c.GlobalString(flag) {
+			case "auto", "always", "never":
+			default:
+				return cli.NewExitError(
+					fmt.Sprintf("invalid --%s value %q; use auto, always or never",
+						flag, c.GlobalString(flag)),
+					errArtifactInvalidParameters)
+			}
+		}
+		imageProgress = newStageReporter(c.GlobalString("progress"),
+			c.GlobalString("color"), os.Stderr)
+		// Reset for every run, since the setting is global.
+		var epoch time.Time
+		if value := c.GlobalString("source-date-epoch"); value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				return cli.NewExitError(
+					fmt.Sprintf("invalid --source-date-epoch value %q", value),
+					errArtifactInvalidParameters)
+			}
+			epoch = time.Unix(seconds, 0)
+		}
+		artifact.SetReproducibleTime(epoch)
+		if err := setResourceLimits(c.GlobalInt("cpu-limit"),
+			c.GlobalString("io-nice")); err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		return nil
+	}
+	app.After = func(c *cli.Context) error {
+		if dest := c.GlobalString("warnings-json"); dest != "" {
+			if err := emitWarningsJSON(dest); err != nil {
+				return cli.NewExitError(err.Error(), errSystemError)
+			}
+		}
+		return nil
 	}
 
 	app.Commands = []cli.Command{
 		writeCommand,
 		readCommand,
 		validate,
+		validateBatchCommand,
 		sign,
 		modify,
+		upgrade,
+		clone,
+		merge,
+		mutate,
+		assemble,
 		copy,
 		cat,
+		ls,
 		install,
 		remove,
+		dataPartitionCommand,
+		auditCommand,
+		preflightCommand,
+		preflightVerifyCommand,
+		inspectSignatureCommand,
+		browseCommand,
+		diffCommand,
+		cleanupTempCommand,
+		doctorCommand,
+		mountCommand,
+		explainPathCommand,
 		dumpCommand,
+		benchCommand,
+		pushCommand,
+		pullCommand,
+		chunkCommand,
+		bundleCommand,
+		pruneCommand,
+		serveCommand,
 	}
 	app.Flags = append([]cli.Flag{}, globalFlags...)
 
+	config, err := readConfigFile()
+	if err == nil {
+		err = configureFlags(app, config)
+	}
+	configErr = err
+
 	// Display all flags and commands alphabetically
 	for _, cmd := range app.Commands {
 		sortFlags(cmd)
@@ -0,0 +1,80 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/awriter"
+)
+
+func cloneArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give the Artifact to clone",
+			errArtifactInvalidParameters)
+	}
+	artFile := c.Args().First()
+	outputFile := c.String("output-path")
+	if isSameFile(artFile, outputFile) {
+		return cli.NewExitError("The clone can not replace the original Artifact",
+			errArtifactInvalidParameters)
+	}
+
+	provides, err := extractKeyValues(c.StringSlice("provides"))
+	if err != nil {
+		return err
+	}
+	args := &awriter.CloneArgs{
+		Name:        c.String("artifact-name"),
+		ForceUnlock: c.Bool("force-unlock"),
+	}
+	if provides != nil {
+		args.Provides = *provides
+	}
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError("Can not use signing key provided: "+err.Error(), 1)
+	}
+	if key != nil {
+		args.Signer = key
+	}
+
+	f, err := os.Open(artFile)
+	if err != nil {
+		err = errors.Wrapf(err, "Can not open: %s", artFile)
+		return cli.NewExitError(err, errArtifactOpen)
+	}
+	defer f.Close()
+
+	clone := func(w io.Writer) error {
+		return awriter.CloneArtifact(f, w, args)
+	}
+	if outputFile == "-" {
+		err = clone(os.Stdout)
+	} else {
+		err = writeOutputFile(outputFile, artFile, clone)
+	}
+	if errors.Cause(err) == awriter.ErrImmutableMetadata {
+		return cli.NewExitError("Artifact ["+artFile+"] has immutable metadata;"+
+			" use --force-unlock to clone it anyway", errArtifactUnsupportedFeature)
+	} else if err != nil {
+		return cli.NewExitError("Can not clone artifact: "+err.Error(), errArtifactCreate)
+	}
+	return nil
+}
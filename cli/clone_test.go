@@ -0,0 +1,113 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// artifactMember returns the body of the named member of the Artifact tar.
+func artifactMember(t *testing.T, art, name string) []byte {
+	f, err := os.Open(art)
+	require.NoError(t, err)
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		require.NoError(t, err)
+		if hdr.Name == name {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			return data
+		}
+	}
+}
+
+func TestCloneArtifact(t *testing.T) {
+	tmpdir := t.TempDir()
+	payload := filepath.Join(tmpdir, "payload")
+	require.NoError(t, os.WriteFile(payload, []byte(strings.Repeat("data", 1000)), 0644))
+	original := filepath.Join(tmpdir, "original.mender")
+	clone := filepath.Join(tmpdir, "clone.mender")
+
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-T", "app", "-t", "my-device", "-n", "release-1", "-f", payload,
+		"-p", "app.version:1", "-p", "app.channel:stable", "-o", original})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "clone", original, "-o", clone,
+		"-n", "release-1-hotfix", "-p", "app.version:1-hotfix"})
+	require.NoError(t, err)
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read", clone})
+	require.NoError(t, err)
+	assert.Contains(t, data, "Name: release-1-hotfix\n")
+	assert.Contains(t, data, "Compatible devices: [my-device]\n")
+	assert.Contains(t, data, "app.version: 1-hotfix\n")
+	assert.Contains(t, data, "app.channel: stable\n")
+	require.NoError(t, Run([]string{"mender-artifact", "validate", clone}))
+
+	// The payload is copied as it is.
+	assert.Equal(t, artifactMember(t, original, "data/0000.tar.gz"),
+		artifactMember(t, clone, "data/0000.tar.gz"))
+	assert.Equal(t, artifactMember(t, original, "version"),
+		artifactMember(t, clone, "version"))
+
+	err = Run([]string{"mender-artifact", "clone", clone, "-o", clone, "-n", "other"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not replace the original")
+}
+
+func TestCloneImmutableArtifact(t *testing.T) {
+	tmpdir := t.TempDir()
+	payload := filepath.Join(tmpdir, "payload")
+	require.NoError(t, os.WriteFile(payload, []byte("data"), 0644))
+	original := filepath.Join(tmpdir, "original.mender")
+	clone := filepath.Join(tmpdir, "clone.mender")
+
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-T", "app", "-t", "my-device", "-n", "release-1", "-f", payload,
+		"--immutable-metadata", "-o", original})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "clone", original, "-o", clone, "-n", "release-2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use --force-unlock")
+	_, err = os.Stat(clone)
+	assert.True(t, os.IsNotExist(err))
+
+	// A failed clone leaves an existing output as it was.
+	require.NoError(t, os.WriteFile(clone, []byte("previous"), 0644))
+	err = Run([]string{"mender-artifact", "clone", original, "-o", clone, "-n", "release-2"})
+	require.Error(t, err)
+	previous, err := os.ReadFile(clone)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(previous))
+
+	err = Run([]string{"mender-artifact", "clone", original, "-o", clone,
+		"-n", "release-2", "--force-unlock"})
+	require.NoError(t, err)
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read", clone})
+	require.NoError(t, err)
+	assert.Contains(t, data, "Name: release-2\n")
+}
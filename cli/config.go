@@ -0,0 +1,321 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Every flag can be given in an environment variable with this prefix,
+	// followed by the name of the flag in upper case with '-' replaced by
+	// '_', e.g. MENDER_ARTIFACT_OUTPUT_PATH for --output-path.
+	flagEnvVarPrefix = "MENDER_ARTIFACT_"
+	// Overrides the location of the config file.
+	configFileEnvVar = "MENDER_ARTIFACT_CONFIG"
+	// Name of the config file in the home directory.
+	defaultConfigFile = ".mender-artifact.yaml"
+)
+
+// genericFlagNames are the flags which get no environment variable, as it
+// is commonly set for other purposes, like MENDER_ARTIFACT_VERSION for the
+// version of mender-artifact itself.
+var genericFlagNames = map[string]bool{
+	"help":    true,
+	"version": true,
+}
+
+// flagEnvVar returns the environment variable of a flag named like
+// "output-path, o".
+func flagEnvVar(name string) string {
+	name = strings.TrimSpace(strings.Split(name, ",")[0])
+	return flagEnvVarPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// envVarOf returns the environment variable of the flag named name, unless
+// it already has envVar. Generic flags get none, and a variable whose value
+// does not parse is left out, and added to ignored, so that it does not fail
+// every command with the flag.
+func envVarOf(envVar, name string, parse func(string) error, ignored map[string]bool) string {
+	if envVar != "" {
+		return envVar
+	}
+	if genericFlagNames[strings.TrimSpace(strings.Split(name, ",")[0])] {
+		return ""
+	}
+	envVar = flagEnvVar(name)
+	if value, ok := os.LookupEnv(envVar); ok && parse != nil && parse(value) != nil {
+		ignored[envVar] = true
+		return ""
+	}
+	return envVar
+}
+
+// The parsers of the environment values of flags, as in urfave/cli.
+func parseIntEnv(value string) error {
+	_, err := strconv.ParseInt(value, 0, 64)
+	return err
+}
+
+func parseDurationEnv(value string) error {
+	_, err := time.ParseDuration(value)
+	return err
+}
+
+func parseBoolEnv(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, err := strconv.ParseBool(value)
+	return err
+}
+
+// readConfigFile reads the flag defaults from the config file, which maps
+// flag names to values, or lists of values for flags which can be given
+// several times. It is not an error if the default config file is missing.
+func readConfigFile() (map[string]interface{}, error) {
+	path, explicit := os.LookupEnv(configFileEnvVar)
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, defaultConfigFile)
+	}
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "can not read config file")
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file %s", path)
+	}
+	return config, nil
+}
+
+// configureFlags gives every flag of the app, its commands and subcommands
+// its environment variable, unless it already has one, and its default from
+// config. The command line takes precedence over the environment, which
+// takes precedence over the config file. A value in config applies to every
+// flag of that name which can take it, as the same name can be a single
+// value in one command and a list in another. Environment variables with
+// invalid values are ignored with a warning.
+func configureFlags(app *cli.App, config map[string]interface{}) error {
+	used := map[string]bool{}
+	failed := map[string]error{}
+	ignored := map[string]bool{}
+	configureFlagList(app.Flags, config, used, failed, ignored)
+	configureCommands(app.Commands, config, used, failed, ignored)
+
+	ignoredVars := make([]string, 0, len(ignored))
+	for envVar := range ignored {
+		ignoredVars = append(ignoredVars, envVar)
+	}
+	sort.Strings(ignoredVars)
+	for _, envVar := range ignoredVars {
+		warnf(WarningEnvironmentIgnored, "Ignoring %s=%q, which is not a valid value",
+			envVar, os.Getenv(envVar))
+	}
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var unknown []string
+	for _, name := range names {
+		if used[name] {
+			continue
+		}
+		if err, ok := failed[name]; ok {
+			return errors.Wrapf(err, "config file: %s", name)
+		}
+		unknown = append(unknown, name)
+	}
+	if len(unknown) > 0 {
+		return errors.Errorf("config file: unknown options: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func configureCommands(
+	cmds []cli.Command,
+	config map[string]interface{},
+	used map[string]bool,
+	failed map[string]error,
+	ignored map[string]bool,
+) {
+	for i := range cmds {
+		configureFlagList(cmds[i].Flags, config, used, failed, ignored)
+		configureCommands(cmds[i].Subcommands, config, used, failed, ignored)
+	}
+}
+
+func configureFlagList(
+	flags []cli.Flag,
+	config map[string]interface{},
+	used map[string]bool,
+	failed map[string]error,
+	ignored map[string]bool,
+) {
+	for i, flag := range flags {
+		name := strings.TrimSpace(strings.Split(flag.GetName(), ",")[0])
+		value, inConfig := config[name]
+		var values []string
+		if inConfig {
+			var err error
+			if values, err = configValues(value); err != nil {
+				failed[name] = err
+				inConfig = false
+			}
+		}
+		configured, err := configureFlag(flag, values, inConfig, ignored)
+		if err != nil {
+			failed[name] = err
+			configured, _ = configureFlag(flag, nil, false, ignored)
+		} else if inConfig {
+			used[name] = true
+		}
+		flags[i] = configured
+	}
+}
+
+// configValues returns the value of a flag in the config file as strings.
+func configValues(value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			switch v.(type) {
+			case []interface{}, map[string]interface{}:
+				return nil, errors.New("lists can only hold plain values")
+			}
+			values = append(values, fmt.Sprint(v))
+		}
+		return values, nil
+	case map[string]interface{}:
+		return nil, errors.New("expected a value or a list of values")
+	case nil:
+		return nil, errors.New("missing value")
+	default:
+		return []string{fmt.Sprint(value)}, nil
+	}
+}
+
+func singleValue(values []string) (string, error) {
+	if len(values) != 1 {
+		return "", errors.New("expected a single value")
+	}
+	return values[0], nil
+}
+
+func configureFlag(
+	flag cli.Flag,
+	values []string,
+	inConfig bool,
+	ignored map[string]bool,
+) (cli.Flag, error) {
+	switch f := flag.(type) {
+	case cli.StringFlag:
+		f.EnvVar = envVarOf(f.EnvVar, f.Name, nil, ignored)
+		if inConfig {
+			value, err := singleValue(values)
+			if err != nil {
+				return nil, err
+			}
+			f.Value = value
+			// The config file provides it.
+			f.Required = false
+		}
+		return f, nil
+	case cli.StringSliceFlag:
+		f.EnvVar = envVarOf(f.EnvVar, f.Name, nil, ignored)
+		if inConfig {
+			value := cli.StringSlice(values)
+			f.Value = &value
+			f.Required = false
+		}
+		return f, nil
+	case cli.IntFlag:
+		f.EnvVar = envVarOf(f.EnvVar, f.Name, parseIntEnv, ignored)
+		if inConfig {
+			value, err := singleValue(values)
+			if err != nil {
+				return nil, err
+			}
+			if f.Value, err = strconv.Atoi(value); err != nil {
+				return nil, errors.Errorf("%q is not a number", value)
+			}
+		}
+		return f, nil
+	case cli.DurationFlag:
+		f.EnvVar = envVarOf(f.EnvVar, f.Name, parseDurationEnv, ignored)
+		if inConfig {
+			value, err := singleValue(values)
+			if err != nil {
+				return nil, err
+			}
+			if f.Value, err = time.ParseDuration(value); err != nil {
+				return nil, errors.Errorf("%q is not a duration", value)
+			}
+		}
+		return f, nil
+	case cli.BoolFlag:
+		f.EnvVar = envVarOf(f.EnvVar, f.Name, parseBoolEnv, ignored)
+		if inConfig {
+			value, err := singleValue(values)
+			if err != nil {
+				return nil, err
+			}
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.Errorf("%q is not a boolean", value)
+			}
+			if on {
+				// Can then be turned off with --flag=false.
+				return cli.BoolTFlag{
+					Name:     f.Name,
+					Usage:    f.Usage,
+					EnvVar:   f.EnvVar,
+					FilePath: f.FilePath,
+					Required: f.Required,
+					Hidden:   f.Hidden,
+				}, nil
+			}
+		}
+		return f, nil
+	default:
+		if inConfig {
+			return nil, errors.New("can not be set in the config file")
+		}
+		return f, nil
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagEnvVar(t *testing.T) {
+	assert.Equal(t, "MENDER_ARTIFACT_OUTPUT_PATH", flagEnvVar("output-path, o"))
+	assert.Equal(t, "MENDER_ARTIFACT_KEY", flagEnvVar("key"))
+}
+
+func TestConfigureFlags(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+	configFile := filepath.Join(tmpdir, "config.yaml")
+	t.Setenv(configFileEnvVar, configFile)
+
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+artifact-name: from-config
+device-type: [device-1, device-2]
+uncompressed-header: true
+read-ahead: 2
+`), 0644))
+
+	write := func(args ...string) {
+		require.NoError(t, Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "testType", "-f", updateFile}, args...)))
+	}
+	read := func() string {
+		out, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+		require.NoError(t, err)
+		return out
+	}
+	members := func() string {
+		out, err := exec.Command("tar", "tf", artfile).Output()
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	// Defaults from the config file.
+	write()
+	out := read()
+	assert.Contains(t, out, "Name: from-config")
+	assert.Contains(t, out, "Compatible devices: [device-1, device-2]")
+	assert.Contains(t, members(), "header.tar\n")
+
+	// The environment takes precedence over the config file.
+	t.Setenv("MENDER_ARTIFACT_ARTIFACT_NAME", "from-env")
+	t.Setenv("MENDER_ARTIFACT_DEVICE_TYPE", "device-3")
+	write()
+	out = read()
+	assert.Contains(t, out, "Name: from-env")
+	assert.Contains(t, out, "Compatible devices: [device-3]")
+
+	// And the command line over both.
+	write("-n", "from-args", "--uncompressed-header=false")
+	out = read()
+	assert.Contains(t, out, "Name: from-args")
+	assert.Contains(t, members(), "header.tar.gz\n")
+}
+
+func TestConfigureFlagsEnvironment(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+	t.Setenv(configFileEnvVar, "")
+
+	// Variables set for other purposes, or with invalid values, do not
+	// break the commands.
+	t.Setenv("MENDER_ARTIFACT_VERSION", "3.11.2")
+	t.Setenv("MENDER_ARTIFACT_READ_AHEAD", "many")
+	t.Setenv("MENDER_ARTIFACT_NO_PROGRESS", "maybe")
+	err := Run([]string{"mender-artifact", "write", "module-image", "-o", artfile,
+		"-T", "testType", "-t", "device", "-n", "release-1", "-f", updateFile})
+	require.NoError(t, err)
+
+	var messages []string
+	for _, warning := range Warnings() {
+		if warning.Class == WarningEnvironmentIgnored {
+			messages = append(messages, warning.Message)
+		}
+	}
+	assert.Equal(t, []string{
+		`Ignoring MENDER_ARTIFACT_NO_PROGRESS="maybe", which is not a valid value`,
+		`Ignoring MENDER_ARTIFACT_READ_AHEAD="many", which is not a valid value`,
+	}, messages)
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tmpdir := t.TempDir()
+	configFile := filepath.Join(tmpdir, "config.yaml")
+	t.Setenv(configFileEnvVar, configFile)
+
+	tests := map[string]struct {
+		config string
+		err    string
+	}{
+		"missing file": {
+			err: "can not read config file",
+		},
+		"not yaml": {
+			config: "- [",
+			err:    "invalid config file " + configFile,
+		},
+		"unknown options": {
+			config: "no-such-option: 1\nalso-unknown: 2\nkey: key.pem\n",
+			err:    "config file: unknown options: also-unknown, no-such-option",
+		},
+		"not a number": {
+			config: "read-ahead: many",
+			err:    `config file: read-ahead: "many" is not a number`,
+		},
+		"not a boolean": {
+			config: "no-progress: maybe",
+			err:    `config file: no-progress: "maybe" is not a boolean`,
+		},
+		"list for a single value": {
+			// --file is a list in module-image only, which is fine.
+			config: "file: [a, b]\nkey: [a, b]",
+			err:    "config file: key: expected a single value",
+		},
+		"map": {
+			config: "key: {a: b}",
+			err:    "config file: key: expected a value or a list of values",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(configFile))
+			if test.config != "" {
+				require.NoError(t, os.WriteFile(configFile, []byte(test.config), 0644))
+			}
+			err := Run([]string{"mender-artifact", "read", "artifact.mender"})
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), test.err), err.Error())
+			assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+		})
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"strings"
 
 	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 var isimg = regexp.MustCompile(`\.(mender|sdimg|uefiimg|img)(:|$)`)
@@ -69,6 +71,11 @@ func Copy(c *cli.Context) (err error) {
 	if c.String("compression") != "" {
 		fmt.Fprintf(os.Stderr, "Warning: The compression flag is not respected for the copy"+
 			" command.\nIf you wish to change the compression type, use the <modify> command.")
+		collectedWarnings.add(WarningCompressionIgnored,
+			"The compression flag is not respected for the copy command")
+	}
+	if c.Bool("no-progress") {
+		imageProgress = nil
 	}
 
 	privateKey, err := getKey(c)
@@ -88,7 +95,11 @@ func Copy(c *cli.Context) (err error) {
 		}
 	}
 	var vfile VPFile
-	switch parseCLIOptions(c) {
+	op := parseCLIOptions(c)
+	if c.Bool("recursive") && (op == copyin || op == copyout) {
+		return copyRecursive(c, privateKey, op)
+	}
+	switch op {
 	case copyin:
 		r, err = os.Open(c.Args().First())
 		defer r.Close()
@@ -101,6 +112,25 @@ func Copy(c *cli.Context) (err error) {
 			dstPath = dstPath + filepath.Base(c.Args().First())
 		}
 
+		if c.Bool("dry-run") {
+			st, err := hostFileStat(c.Args().First())
+			if err == nil {
+				err = dryRunImageChange(privateKey, dryRunWrite, dstPath, st)
+			}
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			return nil
+		}
+
+		unlock, err := lockImagePath(c, dstPath)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer unlock()
+		if err = backupImagePath(c, dstPath); err != nil {
+			return cli.NewExitError(err, 1)
+		}
 		vfile, err = virtualImage.OpenFile(privateKey, dstPath)
 		defer wclose(vfile)
 		if err != nil {
@@ -111,7 +141,30 @@ func Copy(c *cli.Context) (err error) {
 		}
 		return nil
 	case copyinstdin:
+		if c.Bool("recursive") {
+			return cli.NewExitError("can not copy recursively from stdin", 1)
+		}
 		r = os.Stdin
+		if c.Bool("dry-run") {
+			n, err := io.Copy(ioutil.Discard, r)
+			if err == nil {
+				// Data from stdin is buffered in a private temporary file.
+				st := imageFileStat{exists: true, size: n, mode: 0600, hasMode: true}
+				err = dryRunImageChange(privateKey, dryRunWrite, c.Args().Get(1), st)
+			}
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			return nil
+		}
+		unlock, err := lockImagePath(c, c.Args().Get(1))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer unlock()
+		if err = backupImagePath(c, c.Args().Get(1)); err != nil {
+			return cli.NewExitError(err, 1)
+		}
 		vfile, err = virtualImage.OpenFile(privateKey, c.Args().Get(1))
 		defer wclose(vfile)
 		if err != nil {
@@ -119,6 +172,15 @@ func Copy(c *cli.Context) (err error) {
 		}
 		w = vfile
 	case copyout:
+		if c.Bool("dry-run") {
+			fmt.Printf("unchanged %s: copied to %s\n", c.Args().First(), c.Args().Get(1))
+			return nil
+		}
+		unlock, err := lockImagePath(c, c.Args().First())
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer unlock()
 		vfile, err = virtualImage.OpenFile(privateKey, c.Args().First())
 		defer wclose(vfile)
 		if err != nil {
@@ -143,6 +205,51 @@ func Copy(c *cli.Context) (err error) {
 	return nil
 }
 
+// copyRecursive copies a directory tree into or out of an image.
+func copyRecursive(c *cli.Context, privateKey SigningKey, op int) error {
+	if op == copyin {
+		imgname, fpath, err := parseImgPath(c.Args().Get(1))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		target := recursiveCopyTarget(c.Args().First(), fpath)
+		if !c.Bool("dry-run") {
+			unlock, err := lockImagePath(c, c.Args().Get(1))
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			defer unlock()
+			if err = backupImagePath(c, c.Args().Get(1)); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
+		err = copyDirIntoImage(privateKey, c.Args().First(), imgname, target, c.Bool("dry-run"))
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		return nil
+	}
+
+	imgname, fpath, err := parseImgPath(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	target := recursiveCopyTarget(fpath, c.Args().Get(1))
+	if c.Bool("dry-run") {
+		fmt.Printf("unchanged %s: copied to %s\n", c.Args().First(), target)
+		return nil
+	}
+	unlock, err := lockImagePath(c, c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer unlock()
+	if err = copyDirFromImage(privateKey, imgname, fpath, target); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
 // Install installs a file from the host filesystem or directory onto either
 // a mender artifact, or an sdimg.
 func Install(c *cli.Context) (err error) {
@@ -170,6 +277,35 @@ func Install(c *cli.Context) (err error) {
 				" the cp command should fit your needs", 1)
 		}
 		perm = os.FileMode(c.Int("mode"))
+		if c.Bool("dry-run") {
+			op, dst := dryRunMkdir, c.Args().First()
+			st := imageFileStat{exists: true, isDir: true, mode: perm, hasMode: true}
+			if !directory {
+				op, dst = dryRunWrite, c.Args().Get(1)
+				if st, err = hostFileStat(c.Args().First()); err == nil {
+					st.mode = perm
+				}
+			}
+			if err == nil {
+				err = dryRunImageChange(privateKey, op, dst, st)
+			}
+			if err != nil {
+				return cli.NewExitError(err, 1)
+			}
+			return nil
+		}
+		imgAndPath := c.Args().Get(1)
+		if directory {
+			imgAndPath = c.Args().First()
+		}
+		unlock, err := lockImagePath(c, imgAndPath)
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		defer unlock()
+		if err = backupImagePath(c, imgAndPath); err != nil {
+			return cli.NewExitError(err, 1)
+		}
 		if directory {
 			vdir, err := virtualImage.OpenDir(privateKey, c.Args().First())
 			defer wclose(vdir)
@@ -180,6 +316,11 @@ func Install(c *cli.Context) (err error) {
 			if err = vdir.Create(); err != nil {
 				return cli.NewExitError(err, 1)
 			}
+			if c.String("chown") != "" {
+				if err = chownInstalled(vdir, c.String("chown")); err != nil {
+					return cli.NewExitError(err, 1)
+				}
+			}
 			return nil
 		}
 		f, err := os.Open(c.Args().First())
@@ -195,9 +336,9 @@ func Install(c *cli.Context) (err error) {
 
 		Log.Debugf("Created tempfile: %s", tfName)
 		defer func() {
-			lerr := os.RemoveAll(filepath.Dir(tfName))
+			lerr := utils.RemoveTemp(filepath.Dir(tfName))
 			if lerr != nil {
-				Log.Warnf("Failed to remove tmpdir with: %v", lerr)
+				warnf(WarningCleanupFailed, "Failed to remove tmpdir with: %v", lerr)
 			}
 		}()
 
@@ -210,6 +351,11 @@ func Install(c *cli.Context) (err error) {
 		if err = vfile.CopyTo(tfName); err != nil {
 			return cli.NewExitError(err, 1)
 		}
+		if c.String("chown") != "" {
+			if err = chownInstalled(vfile, c.String("chown")); err != nil {
+				return cli.NewExitError(err, 1)
+			}
+		}
 		return nil
 	case parseError:
 		return cli.NewExitError("No artifact or sdimg provided", 1)
@@ -242,6 +388,25 @@ func Remove(c *cli.Context) (err error) {
 	if !isimg.MatchString(c.Args().First()) {
 		return cli.NewExitError("The input image does not have a valid extension", 1)
 	}
+	if c.Bool("dry-run") {
+		op := dryRunRemove
+		if c.Bool("recursive") {
+			op = dryRunRemoveAll
+		}
+		err = dryRunImageChange(privateKey, op, c.Args().First(), imageFileStat{})
+		if err != nil {
+			return cli.NewExitError(err, 1)
+		}
+		return nil
+	}
+	unlock, err := lockImagePath(c, c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer unlock()
+	if err = backupImagePath(c, c.Args().First()); err != nil {
+		return cli.NewExitError(err, 1)
+	}
 	f, err := virtualImage.OpenFile(privateKey, c.Args().First())
 	defer wclose(f)
 	if err != nil {
@@ -302,7 +467,7 @@ func parseCLIOptions(c *cli.Context) int {
 // current file, with the permissions given by perm.
 func createTmpFileWithPerm(f *os.File, perm os.FileMode) (string, error) {
 
-	td, err := ioutil.TempDir("", "mender-artifact-install")
+	td, err := utils.TempDir("", "install")
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,164 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// walkHostDir calls fn for hostDir and every directory and regular file below
+// it, together with the path the entry gets below imageDir. Anything else,
+// like symlinks and device nodes, is skipped with a warning.
+func walkHostDir(
+	hostDir, imageDir string,
+	fn func(hostPath, imagePath string, info os.FileInfo) error,
+) error {
+	return filepath.Walk(hostDir, func(hostPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			warnf(WarningFileSkipped, "Skipping %s: not a regular file or directory", hostPath)
+			return nil
+		}
+		rel, err := filepath.Rel(hostDir, hostPath)
+		if err != nil {
+			return err
+		}
+		return fn(hostPath, filepath.Join(imageDir, rel), info)
+	})
+}
+
+// copyDirIntoImage copies the directory hostDir on the host, and everything
+// below it, to imageDir in the image. Files keep their permissions.
+func copyDirIntoImage(key SigningKey, hostDir, imgname, imageDir string, dryRun bool) (err error) {
+	image, err := virtualImage.Open(key, imgname)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		cerr := image.Close()
+		if err == nil {
+			err = cerr
+		}
+	}()
+
+	if dryRun {
+		// The image is never marked dirty, so closing it does not repack it.
+		return walkHostDir(hostDir, imageDir,
+			func(hostPath, imagePath string, info os.FileInfo) error {
+				st := imageFileStat{exists: true, isDir: true}
+				op := dryRunMkdir
+				if !info.IsDir() {
+					st = imageFileStat{
+						exists:  true,
+						size:    info.Size(),
+						mode:    info.Mode(),
+						hasMode: true,
+					}
+					op = dryRunWrite
+				}
+				return dryRunChangeIn(image, op, imgname+":"+imagePath, imagePath, st)
+			})
+	}
+
+	image.dirtyImage()
+	return walkHostDir(hostDir, imageDir, func(hostPath, imagePath string, info os.FileInfo) error {
+		if !info.IsDir() {
+			return errors.Wrapf(CopyIntoImage(hostPath, image, imagePath),
+				"can not copy %s", hostPath)
+		}
+		dir, err := image.OpenDir(imagePath)
+		if err != nil {
+			return err
+		}
+		err = dir.Create()
+		if cerr := dir.Close(); err == nil {
+			err = cerr
+		}
+		return errors.Wrapf(err, "can not create directory %s", imagePath)
+	})
+}
+
+// copyDirFromImage copies imagePath in the image, and everything below it if
+// it is a directory, to hostPath on the host. Files and directories keep their
+// permissions, when the filesystem in the image has any.
+func copyDirFromImage(key SigningKey, imgname, imagePath, hostPath string) (err error) {
+	image, err := virtualImage.Open(key, imgname)
+	if err != nil {
+		return err
+	}
+	// The image is never marked dirty, so closing it does not repack it.
+	defer image.Close()
+
+	st, err := statInImage(image, imagePath)
+	if err != nil {
+		return err
+	}
+	if !st.exists {
+		return errors.Errorf("%s:%s: no such file or directory", imgname, imagePath)
+	}
+	if !st.isDir {
+		return CopyFromImage(image, imagePath, hostPath)
+	}
+	mode := os.FileMode(0755)
+	if st.hasMode {
+		mode = st.mode.Perm()
+	}
+	return copyImageDir(image, imagePath, hostPath, mode)
+}
+
+func copyImageDir(image VPImage, imageDir, hostDir string, mode os.FileMode) error {
+	entries, err := readImageDir(image, imageDir)
+	if err != nil {
+		return err
+	}
+
+	// Fill the directory before applying its permissions, as these might
+	// not allow writing to it.
+	if err = os.MkdirAll(hostDir, 0700); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		imagePath := filepath.Join(imageDir, entry.Name)
+		hostPath := filepath.Join(hostDir, entry.Name)
+		switch {
+		case entry.Mode.IsDir():
+			err = copyImageDir(image, imagePath, hostPath, entry.Mode.Perm())
+		case entry.Mode.IsRegular():
+			err = errors.Wrapf(CopyFromImage(image, imagePath, hostPath),
+				"can not copy %s", imagePath)
+		default:
+			warnf(WarningFileSkipped, "Skipping %s: not a regular file or directory", imagePath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chmod(hostDir, mode)
+}
+
+// recursiveCopyTarget returns the destination of copying src to dst
+// recursively: a dst ending with a slash is a directory to copy src into.
+func recursiveCopyTarget(src, dst string) string {
+	if strings.HasSuffix(dst, "/") {
+		return dst + filepath.Base(strings.TrimRight(src, "/"))
+	}
+	return dst
+}
@@ -574,7 +574,7 @@ func TestCopyRootfsImage(t *testing.T) {
 		{
 			name: "Error when deleting a non-empty directory from an image or Artifact",
 			argv: []string{"mender-artifact", "rm", "<artifact|sdimg|fat-sdimg>:/etc/mender/"},
-			err:  "debugfsRemoveDir: debugfs: error running command:",
+			err:  "directory not empty",
 		},
 		{
 			name: "Delete a directory from an image or Artifact recursively",
@@ -1013,3 +1013,144 @@ func TestCopyModuleImage(t *testing.T) {
 	assert.Contains(t, err.Error(), errFsTypeUnsupported.Error())
 
 }
+
+func TestImageDryRun(t *testing.T) {
+	artifact, sdimg, _, _, cleanup := testSetupTeardown(t)
+	defer cleanup()
+
+	before, err := ioutil.ReadFile(artifact)
+	require.NoError(t, err)
+
+	hostFile := filepath.Join(filepath.Dir(artifact), "host.txt")
+	require.NoError(t, ioutil.WriteFile(hostFile, []byte("new content"), 0640))
+
+	tests := map[string]struct {
+		argv     []string
+		expected string
+		err      string
+	}{
+		"cp replace": {
+			argv: []string{"mender-artifact", "cp", "--dry-run",
+				hostFile, artifact + ":/etc/mender/artifact_info"},
+			expected: "replace " + artifact + ":/etc/mender/artifact_info:" +
+				" size 24 -> 11, mode 0644 -> 0640",
+		},
+		"cp add": {
+			argv: []string{"mender-artifact", "cp", "--dry-run",
+				hostFile, artifact + ":/etc/mender/"},
+			expected: "add " + artifact + ":/etc/mender/host.txt: size 11, mode 0640",
+		},
+		"install mode": {
+			argv: []string{"mender-artifact", "install", "--dry-run", "-m", "0755",
+				hostFile, artifact + ":/etc/mender/tenant.conf"},
+			expected: "replace " + artifact + ":/etc/mender/tenant.conf:" +
+				" size 6 -> 11, mode 0600 -> 0755",
+		},
+		"install directory": {
+			argv: []string{"mender-artifact", "install", "--dry-run", "-d",
+				artifact + ":/etc/newdir"},
+			expected: "add directory " + artifact + ":/etc/newdir",
+		},
+		"rm file": {
+			argv: []string{"mender-artifact", "rm", "--dry-run",
+				artifact + ":/etc/mender/server.crt"},
+			expected: "remove " + artifact + ":/etc/mender/server.crt: size 1166, mode 0444",
+		},
+		"rm directory": {
+			argv: []string{"mender-artifact", "rm", "--dry-run", "-r",
+				artifact + ":/etc/mender"},
+			expected: "remove directory " + artifact + ":/etc/mender: mode 0755\n" +
+				"remove " + artifact + ":/etc/mender/artifact-verify-key.pem: size 0, mode 0644\n" +
+				"remove " + artifact + ":/etc/mender/artifact_info: size 24, mode 0644\n" +
+				"remove " + artifact + ":/etc/mender/mender.conf: size 262, mode 0644\n" +
+				"remove " + artifact + ":/etc/mender/server.crt: size 1166, mode 0444\n" +
+				"remove " + artifact + ":/etc/mender/tenant.conf: size 6, mode 0600",
+		},
+		"rm sdimg file": {
+			argv: []string{"mender-artifact", "rm", "--dry-run",
+				sdimg + ":/etc/mender/mender.conf"},
+			expected: "remove " + sdimg + ":/etc/mender/mender.conf: size 262, mode 0644",
+		},
+		"rm directory without -r": {
+			argv: []string{"mender-artifact", "rm", "--dry-run",
+				artifact + ":/etc/mender"},
+			err: "directory not empty, use -r",
+		},
+		"rm missing": {
+			argv: []string{"mender-artifact", "rm", "--dry-run",
+				artifact + ":/etc/missing"},
+			err: "no such file or directory",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			out, err := runAndCollectStdout(test.argv)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, out)
+			}
+
+			after, err := ioutil.ReadFile(artifact)
+			require.NoError(t, err)
+			assert.Equal(t, before, after, "dry run modified the artifact")
+		})
+	}
+}
+
+func TestCopyRecursive(t *testing.T) {
+	artifact, _, _, _, closer := testSetupTeardown(t)
+	defer closer()
+
+	tmp, err := ioutil.TempDir("", "mender-copy-recursive")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "tree")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "a.conf"), []byte("a"), 0640))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "run.sh"), []byte("b"), 0755))
+
+	// A destination ending with a slash is the directory to copy into.
+	err = Run([]string{"mender-artifact", "cp", "-r", src, artifact + ":/etc/"})
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(artifact)
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "cp", "-r", "--dry-run", src, artifact + ":/etc/"})
+	require.NoError(t, err)
+	after, err := ioutil.ReadFile(artifact)
+	require.NoError(t, err)
+	assert.Equal(t, data, after, "dry-run must not modify the artifact")
+
+	dst := filepath.Join(tmp, "out")
+	err = Run([]string{"mender-artifact", "cp", "-r", artifact + ":/etc/tree", dst})
+	require.NoError(t, err)
+
+	for name, expected := range map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"a.conf":                       {"a", 0640},
+		filepath.Join("sub", "run.sh"): {"b", 0755},
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, expected.content, string(content), name)
+		info, err := os.Stat(filepath.Join(dst, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, expected.mode, info.Mode().Perm(), name)
+	}
+	info, err := os.Stat(filepath.Join(dst, "sub"))
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	err = Run([]string{"mender-artifact", "cp", "-r", artifact + ":/etc/missing", dst})
+	assert.Error(t, err)
+
+	err = Run([]string{"mender-artifact", "cp", "-r", "-", artifact + ":/etc/"})
+	assert.EqualError(t, err, "can not copy recursively from stdin")
+}
@@ -0,0 +1,447 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// extEntry is a file system entry on an ext partition, as listed by debugfs.
+type extEntry struct {
+	path string
+	mode uint32 // Including the file type bits.
+	uid  int
+	gid  int
+	size int64
+}
+
+const (
+	extTypeMask    = 0170000
+	extTypeDir     = 0040000
+	extTypeRegular = 0100000
+	extTypeSymlink = 0120000
+)
+
+var (
+	debugfsFastLink = regexp.MustCompile(`Fast link dest: "(.*)"`)
+	debugfsModTime  = regexp.MustCompile(`(?m)^\s*mtime: 0x([0-9a-f]+)`)
+)
+
+// debugfsListRecursive lists all entries below dir on the ext image, parents
+// before their children.
+func debugfsListRecursive(image, dir string) ([]extEntry, error) {
+	out, err := debugfsExecuteCommand(fmt.Sprintf("ls -p %s", debugfsQuote(dir)), image)
+	if err != nil {
+		return nil, err
+	}
+	var entries []extEntry
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		// Format: /inode/mode/uid/gid/name/size/
+		fields := strings.Split(scanner.Text(), "/")
+		if len(fields) != 8 {
+			continue
+		}
+		name := fields[5]
+		if name == "." || name == ".." || name == "" {
+			continue
+		}
+		entry := extEntry{path: path.Join(dir, name)}
+		mode, err := strconv.ParseUint(fields[2], 8, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "debugfs: invalid mode for %s", entry.path)
+		}
+		entry.mode = uint32(mode)
+		if entry.uid, err = strconv.Atoi(fields[3]); err != nil {
+			return nil, errors.Wrapf(err, "debugfs: invalid uid for %s", entry.path)
+		}
+		if entry.gid, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, errors.Wrapf(err, "debugfs: invalid gid for %s", entry.path)
+		}
+		if fields[6] != "" {
+			entry.size, _ = strconv.ParseInt(fields[6], 10, 64)
+		}
+		entries = append(entries, entry)
+		if entry.mode&extTypeMask == extTypeDir {
+			children, err := debugfsListRecursive(image, entry.path)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, children...)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func debugfsReadLink(image, link string) (string, error) {
+	out, err := debugfsExecuteCommand(fmt.Sprintf("stat %s", debugfsQuote(link)), image)
+	if err != nil {
+		return "", err
+	}
+	if m := debugfsFastLink.FindStringSubmatch(out.String()); m != nil {
+		return m[1], nil
+	}
+	// Slow symlinks store the target in a data block.
+	dir, err := debugfsCopyFile(link, image)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	target, err := ioutil.ReadFile(filepath.Join(dir, path.Base(link)))
+	return string(target), err
+}
+
+// debugfsModTimes returns the modification times of the given entries, in
+// order, using a single debugfs run.
+func debugfsModTimes(image string, entries []extEntry) ([]time.Time, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	var script strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&script, "stat %s\n", debugfsQuote(entry.path))
+	}
+	out, err := debugfsExecuteCommand(script.String(), image)
+	if err != nil {
+		return nil, err
+	}
+	matches := debugfsModTime.FindAllStringSubmatch(out.String(), -1)
+	if len(matches) != len(entries) {
+		return nil, errors.Errorf("debugfs: got %d modification times for %d entries",
+			len(matches), len(entries))
+	}
+	times := make([]time.Time, len(entries))
+	for i, m := range matches {
+		sec, err := strconv.ParseInt(m[1], 16, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "debugfs: invalid mtime for %s", entries[i].path)
+		}
+		times[i] = time.Unix(sec, 0)
+	}
+	return times, nil
+}
+
+// exportExtPartition writes all the contents of the ext partition image to
+// w as a tar archive, preserving modes, ownership and modification times.
+func exportExtPartition(image string, w io.Writer) error {
+	entries, err := debugfsListRecursive(image, "/")
+	if err != nil {
+		return errors.Wrap(err, "can not list partition contents")
+	}
+	modTimes, err := debugfsModTimes(image, entries)
+	if err != nil {
+		return errors.Wrap(err, "can not read modification times")
+	}
+
+	tmpdir, err := utils.TempDir("", "data-partition")
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(tmpdir)
+
+	// Dump all regular files in one go.
+	var dumpCmd strings.Builder
+	for i, entry := range entries {
+		if entry.mode&extTypeMask == extTypeRegular {
+			fmt.Fprintf(&dumpCmd, "dump %s %s\n", debugfsQuote(entry.path),
+				debugfsQuote(filepath.Join(tmpdir, strconv.Itoa(i))))
+		}
+	}
+	if dumpCmd.Len() > 0 {
+		if _, err = debugfsExecuteCommand(dumpCmd.String()+"close", image); err != nil {
+			return errors.Wrap(err, "can not dump partition contents")
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	for i, entry := range entries {
+		if entry.path == "/lost+found" || strings.HasPrefix(entry.path, "/lost+found/") {
+			continue
+		}
+		hdr := &tar.Header{
+			Name:    strings.TrimPrefix(entry.path, "/"),
+			Mode:    int64(entry.mode &^ extTypeMask),
+			Uid:     entry.uid,
+			Gid:     entry.gid,
+			ModTime: modTimes[i],
+		}
+		switch entry.mode & extTypeMask {
+		case extTypeDir:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case extTypeRegular:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = entry.size
+		case extTypeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			if hdr.Linkname, err = debugfsReadLink(image, entry.path); err != nil {
+				return errors.Wrapf(err, "can not read symlink %s", entry.path)
+			}
+		default:
+			warnf(WarningFileSkipped, "Skipping special file %s", entry.path)
+			continue
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "can not write tar header for %s", entry.path)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if err = copyFileToTar(tw, filepath.Join(tmpdir, strconv.Itoa(i))); err != nil {
+				return errors.Wrapf(err, "can not write %s to tar", entry.path)
+			}
+		}
+	}
+	return tw.Close()
+}
+
+func copyFileToTar(tw *tar.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// importExtPartition restores the contents of the tar archive in r onto the
+// ext partition image, preserving modes, ownership and modification times.
+// Existing files are replaced, and so are existing directories where the
+// archive has something else.
+func importExtPartition(image string, r io.Reader) error {
+	existing, err := debugfsListRecursive(image, "/")
+	if err != nil {
+		return errors.Wrap(err, "can not list partition contents")
+	}
+	existingModes := make(map[string]uint32, len(existing))
+	for _, entry := range existing {
+		existingModes[entry.path] = entry.mode
+	}
+
+	tmpdir, err := utils.TempDir("", "data-partition")
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(tmpdir)
+
+	// Times are set last, as writing into a directory updates its mtime.
+	var script, times strings.Builder
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "can not read tar archive")
+		}
+		// Cleaning the rooted name keeps ".." entries inside the partition.
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		// A debugfs script runs one command per line.
+		if strings.ContainsAny(name+hdr.Linkname, "\n\r") {
+			return errors.Errorf("can not import %q: newline in name", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			warnf(WarningFileSkipped, "Skipping unsupported tar entry %s", hdr.Name)
+			continue
+		}
+
+		oldMode, exists := existingModes[name]
+		oldDir := exists && oldMode&extTypeMask == extTypeDir
+		if exists && (!oldDir || hdr.Typeflag != tar.TypeDir) {
+			debugfsRemoveAll(&script, existingModes, name)
+			exists, oldDir = false, false
+		}
+
+		var typeBits int64
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			typeBits = extTypeDir
+			if oldDir {
+				break
+			}
+			fmt.Fprintf(&script, "mkdir %s\n", debugfsQuote(name))
+		case tar.TypeReg:
+			typeBits = extTypeRegular
+			hostFile := filepath.Join(tmpdir, strconv.Itoa(i))
+			if err = writeTarEntry(tr, hostFile); err != nil {
+				return err
+			}
+			fmt.Fprintf(&script, "cd %s\nwrite %s %s\ncd /\n",
+				debugfsQuote(path.Dir(name)), debugfsQuote(hostFile),
+				debugfsQuote(path.Base(name)))
+		case tar.TypeSymlink:
+			typeBits = extTypeSymlink
+			fmt.Fprintf(&script, "symlink %s %s\n",
+				debugfsQuote(name), debugfsQuote(hdr.Linkname))
+		}
+		fmt.Fprintf(&script, "sif %s uid %d\n", debugfsQuote(name), hdr.Uid)
+		fmt.Fprintf(&script, "sif %s gid %d\n", debugfsQuote(name), hdr.Gid)
+		fmt.Fprintf(&script, "sif %s mode 0%o\n", debugfsQuote(name),
+			typeBits|(hdr.Mode&07777))
+		fmt.Fprintf(&times, "sif %s mtime @%d\n", debugfsQuote(name), hdr.ModTime.Unix())
+		existingModes[name] = uint32(typeBits)
+	}
+	if script.Len() == 0 {
+		return nil
+	}
+	_, err = debugfsExecuteCommand(script.String()+times.String()+"close", image)
+	return errors.Wrap(err, "can not write partition contents")
+}
+
+// debugfsRemoveAll adds the commands to remove name and, if it is a
+// directory, everything below it, to script. The removed entries are dropped
+// from modes.
+func debugfsRemoveAll(script *strings.Builder, modes map[string]uint32, name string) {
+	var below []string
+	for p := range modes {
+		if strings.HasPrefix(p, name+"/") {
+			below = append(below, p)
+		}
+	}
+	// Children sort after their parents, so reversing removes them first.
+	sort.Sort(sort.Reverse(sort.StringSlice(below)))
+	for _, p := range append(below, name) {
+		if modes[p]&extTypeMask == extTypeDir {
+			fmt.Fprintf(script, "rmdir %s\n", debugfsQuote(p))
+		} else {
+			fmt.Fprintf(script, "rm %s\n", debugfsQuote(p))
+		}
+		delete(modes, p)
+	}
+}
+
+func writeTarEntry(tr *tar.Reader, name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, tr); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openDataPartition returns the image and the extracted data partition of
+// the given sdimg.
+func openDataPartition(imgname string) (*ModImageSdimg, string, error) {
+	image, err := virtualImage.Open(nil, imgname)
+	if err != nil {
+		return nil, "", err
+	}
+	sdimg, ok := image.(*ModImageSdimg)
+	if !ok {
+		image.Close()
+		return nil, "", errors.New("data partitions can only be handled on sdimg images")
+	}
+	data := sdimg.candidates[3].path
+	if data == "" {
+		image.Close()
+		return nil, "", errors.New("the image has no data partition")
+	}
+	fstype, err := imgFilesystemType(data)
+	if err == nil && fstype != ext {
+		err = errors.New("data partitions can only be exported and imported on ext filesystems")
+	}
+	if err != nil {
+		image.Close()
+		return nil, "", err
+	}
+	return sdimg, data, nil
+}
+
+func exportDataPartition(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+	image, data, err := openDataPartition(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+	defer image.Close()
+
+	var w io.Writer = os.Stdout
+	if c.String("file") != "-" {
+		f, err := os.Create(c.String("file"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), errSystemError)
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil && cerr != nil {
+				err = cli.NewExitError(cerr.Error(), errSystemError)
+			}
+		}()
+		w = f
+	}
+
+	if err = exportExtPartition(data, w); err != nil {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	return nil
+}
+
+func importDataPartition(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+	var r io.Reader = os.Stdin
+	if c.String("file") != "-" {
+		f, err := os.Open(c.String("file"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactOpen)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	image, data, err := openDataPartition(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+
+	if err = importExtPartition(data, r); err != nil {
+		image.Close()
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	image.dirtyImage()
+	if err = image.Close(); err != nil {
+		return cli.NewExitError("Error closing image: "+err.Error(), errSystemError)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestTar(t *testing.T, r io.Reader) map[string]*tar.Header {
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[hdr.Name] = hdr
+	}
+	return headers
+}
+
+func TestExportImportExtPartition(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-data-partition")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	img := filepath.Join(tmpdir, "data.ext4")
+	require.NoError(t, copyFile("mender_test.img", img))
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, exportExtPartition(img, buf))
+	headers := readTestTar(t, buf)
+
+	require.Contains(t, headers, "etc/mender/")
+	assert.Equal(t, byte(tar.TypeDir), headers["etc/mender/"].Typeflag)
+	require.Contains(t, headers, "etc/mender/server.crt")
+	assert.Equal(t, int64(0444), headers["etc/mender/server.crt"].Mode)
+	assert.Equal(t, int64(1166), headers["etc/mender/server.crt"].Size)
+	assert.NotContains(t, headers, "lost+found/")
+
+	// Import a few new entries with custom ownership.
+	in := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(in)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/", Typeflag: tar.TypeDir,
+		Mode: 0700, Uid: 1000, Gid: 1001}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/mender-store",
+		Typeflag: tar.TypeReg, Mode: 0600, Uid: 1000, Gid: 1001, Size: 5,
+		ModTime: time.Unix(1600000000, 0)}))
+	_, err = tw.Write([]byte("store"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "etc/mender/server.crt",
+		Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	_, err = tw.Write([]byte("cert"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/link",
+		Typeflag: tar.TypeSymlink, Linkname: "mender-store", Mode: 0777}))
+	// Paths with spaces and quotes must reach debugfs as single arguments.
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/my \"dir\"/",
+		Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/my \"dir\"/a b.conf",
+		Typeflag: tar.TypeReg, Mode: 0640, Uid: 1000, Size: 3}))
+	_, err = tw.Write([]byte("a b"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	require.NoError(t, importExtPartition(img, in))
+
+	buf.Reset()
+	require.NoError(t, exportExtPartition(img, buf))
+	headers = readTestTar(t, buf)
+
+	require.Contains(t, headers, "mender/")
+	assert.Equal(t, int64(0700), headers["mender/"].Mode)
+	assert.Equal(t, 1000, headers["mender/"].Uid)
+	require.Contains(t, headers, "mender/mender-store")
+	assert.Equal(t, int64(0600), headers["mender/mender-store"].Mode)
+	assert.Equal(t, 1000, headers["mender/mender-store"].Uid)
+	assert.Equal(t, 1001, headers["mender/mender-store"].Gid)
+	assert.Equal(t, int64(5), headers["mender/mender-store"].Size)
+	assert.Equal(t, int64(1600000000), headers["mender/mender-store"].ModTime.Unix())
+	assert.Equal(t, int64(4), headers["etc/mender/server.crt"].Size)
+	assert.Equal(t, int64(0644), headers["etc/mender/server.crt"].Mode)
+	require.Contains(t, headers, "mender/link")
+	assert.Equal(t, "mender-store", headers["mender/link"].Linkname)
+	require.Contains(t, headers, "mender/my \"dir\"/a b.conf")
+	assert.Equal(t, int64(0640), headers["mender/my \"dir\"/a b.conf"].Mode)
+	assert.Equal(t, 1000, headers["mender/my \"dir\"/a b.conf"].Uid)
+	assert.Equal(t, int64(3), headers["mender/my \"dir\"/a b.conf"].Size)
+
+	// A file replaces an existing directory and everything below it.
+	in.Reset()
+	tw = tar.NewWriter(in)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender", Typeflag: tar.TypeReg,
+		Mode: 0644, Size: 4, ModTime: time.Unix(1500000000, 0)}))
+	_, err = tw.Write([]byte("file"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, importExtPartition(img, in))
+
+	buf.Reset()
+	require.NoError(t, exportExtPartition(img, buf))
+	headers = readTestTar(t, buf)
+	require.Contains(t, headers, "mender")
+	assert.Equal(t, byte(tar.TypeReg), headers["mender"].Typeflag)
+	assert.Equal(t, int64(4), headers["mender"].Size)
+	assert.Equal(t, int64(1500000000), headers["mender"].ModTime.Unix())
+	for name := range headers {
+		assert.False(t, strings.HasPrefix(name, "mender/"), name)
+	}
+
+	// A newline would end the debugfs command early.
+	in.Reset()
+	tw = tar.NewWriter(in)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "mender/a\nrm /etc",
+		Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.Close())
+	err = importExtPartition(img, in)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "newline in name")
+}
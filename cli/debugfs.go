@@ -18,8 +18,6 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
-	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -35,23 +33,23 @@ const (
 )
 
 func debugfsCopyFile(file, image string) (ret string, err error) {
-	tmpDir, err := ioutil.TempDir("", "mender")
+	tmpDir, err := utils.TempDir("", "debugfs")
 	if err != nil {
 		return "", errors.Wrap(err, "debugfs: create temp directory")
 	}
 	defer func() {
 		if err != nil {
-			os.RemoveAll(tmpDir)
+			utils.RemoveTemp(tmpDir)
 		}
 	}()
 
-	dumpCmd := fmt.Sprintf("dump %s %s", file,
-		filepath.Join(tmpDir, filepath.Base(file)))
+	dumpCmd := fmt.Sprintf("dump %s %s", debugfsQuote(file),
+		debugfsQuote(filepath.Join(tmpDir, filepath.Base(file))))
 	bin, err := utils.GetBinaryPath("debugfs")
 	if err != nil {
 		return "", fmt.Errorf(debugfsMissingErr)
 	}
-	cmd := exec.Command(bin, "-R", dumpCmd, image)
+	cmd := ioCommand(bin, "-R", dumpCmd, image)
 	ep, err := cmd.StderrPipe()
 	if err != nil {
 		return "", errors.Wrap(err, "failed to open stderr pipe of command")
@@ -117,6 +115,12 @@ func debugfsReplaceFile(imageFile, hostFile, image string) (err error) {
 	return err
 }
 
+func debugfsChown(imageFile, image string, uid, gid int) error {
+	cmd := fmt.Sprintf("sif %s uid %d\nsif %s gid %d\nclose", imageFile, uid, imageFile, gid)
+	_, err := debugfsExecuteCommand(cmd, image)
+	return err
+}
+
 func debugfsRemoveFileOrDir(imageFile, image string, recursive bool) (err error) {
 	// Check that the file or directory exists.
 	cmd := fmt.Sprintf("cd %s", filepath.Dir(imageFile))
@@ -164,13 +168,19 @@ func debugfsRemoveDir(imageFile, image string, recursive bool) (err error) {
 	return nil
 }
 
+// debugfsQuote quotes arg as a single argument of a debugfs command, so that
+// paths with spaces or quotes are passed on unchanged.
+func debugfsQuote(arg string) string {
+	return `"` + strings.ReplaceAll(arg, `"`, `""`) + `"`
+}
+
 // debugfsExecuteCommand takes a command string and passes it on to debugfs on the image given.
 func debugfsExecuteCommand(cmdstr, image string) (stdout *bytes.Buffer, err error) {
-	scr, err := ioutil.TempFile("", "mender-debugfs")
+	scr, err := utils.TempFile("", "debugfs-script")
 	if err != nil {
 		return nil, errors.Wrap(err, "debugfs: create sync script file")
 	}
-	defer os.Remove(scr.Name())
+	defer utils.RemoveTemp(scr.Name())
 	defer scr.Close()
 
 	err = scr.Chmod(0755)
@@ -190,7 +200,7 @@ func debugfsExecuteCommand(cmdstr, image string) (stdout *bytes.Buffer, err erro
 		return nil, fmt.Errorf(debugfsMissingErr)
 	}
 
-	cmd := exec.Command(bin, "-w", "-f", scr.Name(), image)
+	cmd := ioCommand(bin, "-w", "-f", scr.Name(), image)
 	cmd.Env = []string{"DEBUGFS_PAGER='cat'"}
 	errbuf := bytes.NewBuffer(nil)
 	stdout = bytes.NewBuffer(nil)
@@ -58,8 +58,9 @@ func TestExternalBinaryDependency(t *testing.T) {
 	_, err = debugfsExecuteCommand("foobar", "bash")
 	assert.EqualError(t, err, debugfsMissingErr)
 
+	// The partition table is read without any external binary.
 	_, err = processSdimg("foobar")
-	assert.Contains(t, err.Error(), "`parted` binary not found on the system")
+	assert.NotContains(t, err.Error(), "binary not found")
 
 	_, err = imgFilesystemType("foobar")
 	assert.EqualError(t, err, "`blkid` binary not found on the system")
@@ -0,0 +1,172 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// deltaBase is the payload of the Artifact a delta Artifact is written
+// against.
+type deltaBase struct {
+	name       string
+	updateType string
+	// checksums maps the names of the payload files to their checksums.
+	checksums map[string]string
+}
+
+func readDeltaBase(name string) (*deltaBase, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not open delta base: %s", name)
+	}
+	defer f.Close()
+
+	ar := areader.NewReader(f)
+	if err = ar.ReadArtifact(); err != nil {
+		return nil, errors.Wrapf(err, "Can not read delta base: %s", name)
+	}
+	if ar.GetInfo().Version < 3 {
+		return nil, errors.New("The delta base must be an Artifact of version 3")
+	}
+	inst := ar.GetHandlers()
+	if len(inst) != 1 {
+		return nil, errors.New("The delta base must have exactly one payload")
+	}
+	base := &deltaBase{
+		name:      ar.GetArtifactName(),
+		checksums: make(map[string]string),
+	}
+	if t := inst[0].GetUpdateType(); t != nil {
+		base.updateType = *t
+	}
+	for _, file := range inst[0].GetUpdateFiles() {
+		base.checksums[filepath.Base(file.Name)] = string(file.Checksum)
+	}
+	return base, nil
+}
+
+func fileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// diff returns the files which are new or changed compared to the base, and
+// the names of the base files which are not among the files anymore.
+func (b *deltaBase) diff(files []string) (changed, deleted []string, err error) {
+	present := make(map[string]bool)
+	for _, file := range files {
+		name := filepath.Base(file)
+		present[name] = true
+		sum, err := fileChecksum(file)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "Can not read %s", file)
+		}
+		if b.checksums[name] != sum {
+			changed = append(changed, file)
+		}
+	}
+	for name := range b.checksums {
+		if !present[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	return changed, deleted, nil
+}
+
+// makeDelta reduces the payload files to the ones which changed compared to
+// the Artifact given with --delta-base, and returns the delta information for
+// the payload meta-data.
+func makeDelta(ctx *cli.Context) ([]string, *artifact.DeltaInfo, error) {
+	base, err := readDeltaBase(ctx.String("delta-base"))
+	if err != nil {
+		return nil, nil, cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+	if base.updateType != ctx.String("type") {
+		return nil, nil, cli.NewExitError(
+			fmt.Sprintf("The delta base has a payload of type %q, not %q",
+				base.updateType, ctx.String("type")),
+			errArtifactInvalidParameters)
+	}
+	if ctx.String("augment-type") != "" {
+		return nil, nil, cli.NewExitError("Delta Artifacts can not be augmented",
+			errArtifactUnsupportedFeature)
+	}
+	changed, deleted, err := base.diff(ctx.StringSlice("file"))
+	if err != nil {
+		return nil, nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	return changed, &artifact.DeltaInfo{
+		BaseArtifactName: base.name,
+		DeletedFiles:     deleted,
+	}, nil
+}
+
+// applyDelta records the delta information in the payload meta-data and
+// makes the Artifact depend on the base Artifact.
+func applyDelta(
+	delta *artifact.DeltaInfo,
+	metaData map[string]interface{},
+	depends *artifact.ArtifactDepends,
+) (map[string]interface{}, error) {
+	if len(depends.ArtifactName) == 0 {
+		depends.ArtifactName = []string{delta.BaseArtifactName}
+	} else if !dependsOnName(depends.ArtifactName, delta.BaseArtifactName) {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("The Artifact name depends must include the delta base %q",
+				delta.BaseArtifactName),
+			errArtifactInvalidParameters)
+	}
+	if metaData == nil {
+		metaData = make(map[string]interface{})
+	}
+	if _, ok := metaData[artifact.DeltaMetaDataKey]; ok {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("The meta-data key %q is reserved for delta Artifacts",
+				artifact.DeltaMetaDataKey),
+			errArtifactInvalidParameters)
+	}
+	metaData[artifact.DeltaMetaDataKey] = delta.MetaData()
+	return metaData, nil
+}
+
+func dependsOnName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
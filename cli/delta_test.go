@@ -0,0 +1,91 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+func TestWriteDeltaModuleImage(t *testing.T) {
+	tmpdir := t.TempDir()
+	writeFiles := func(dir string, files map[string]string) []string {
+		require.NoError(t, os.Mkdir(filepath.Join(tmpdir, dir), 0755))
+		var args []string
+		for name, content := range files {
+			path := filepath.Join(tmpdir, dir, name)
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+			args = append(args, "-f", path)
+		}
+		return args
+	}
+	base := filepath.Join(tmpdir, "base.mender")
+	err := Run(append([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module", "-o", base},
+		writeFiles("base", map[string]string{"a": "a", "b": "b", "c": "c"})...))
+	require.NoError(t, err)
+
+	newFiles := writeFiles("new", map[string]string{"a": "a", "b": "changed", "d": "d"})
+	delta := filepath.Join(tmpdir, "delta.mender")
+	err = Run(append([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-2", "-T", "my-module", "-o", delta,
+		"--delta-base", base}, newFiles...))
+	require.NoError(t, err)
+
+	f, err := os.Open(delta)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+
+	inst := ar.GetHandlers()[0]
+	var names []string
+	for _, file := range inst.GetUpdateFiles() {
+		names = append(names, filepath.Base(file.Name))
+	}
+	assert.ElementsMatch(t, []string{"b", "d"}, names)
+	metaData, err := inst.GetUpdateMetaData()
+	require.NoError(t, err)
+	deltaInfo, err := artifact.GetDeltaInfo(metaData)
+	require.NoError(t, err)
+	assert.Equal(t, &artifact.DeltaInfo{
+		BaseArtifactName: "release-1",
+		DeletedFiles:     []string{"c"},
+	}, deltaInfo)
+	assert.Equal(t, []string{"release-1"}, ar.GetArtifactDepends().ArtifactName)
+	depends, err := ar.MergeArtifactDepends()
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"release-1"}, depends["artifact_name"])
+
+	// The base must match the payload type and the Artifact name depends.
+	err = Run(append([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-2", "-T", "other-module", "-o", delta,
+		"--delta-base", base}, newFiles...))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "The delta base has a payload of type \"my-module\"")
+	err = Run(append([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-2", "-T", "my-module", "-o", delta,
+		"--delta-base", base, "-N", "release-0"}, newFiles...))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must include the delta base \"release-1\"")
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// The kinds of differences between two Artifacts.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// artifactDifference is a field which differs between two Artifacts.
+type artifactDifference struct {
+	Field  string      `json:"field"`
+	Change string      `json:"change"`
+	Old    interface{} `json:"old,omitempty"`
+	New    interface{} `json:"new,omitempty"`
+}
+
+// readDiffDocument reads the Artifact at path, and returns its fields as
+// read --json prints them, with the checksums of the state scripts and the
+// payload files instead of only their names.
+func readDiffDocument(path string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not open artifact: %s", path)
+	}
+	defer f.Close()
+
+	var scripts []string
+	scriptSums := map[string]interface{}{}
+	ar := areader.NewReader(f)
+	ar.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		scripts = append(scripts, info.Name())
+		scriptSums[info.Name()] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+	if err = ar.ReadArtifact(); err != nil {
+		return nil, errors.Wrapf(err, "Can not read artifact: %s", path)
+	}
+	fields, err := getArtifactFields(ar, "", scripts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Can not read artifact: %s", path)
+	}
+
+	doc := fields.(map[string]interface{})
+	// Without keys, the signatures can not be compared.
+	delete(doc, "signature")
+	if len(scriptSums) > 0 {
+		doc["state_scripts"] = scriptSums
+	}
+	payloads, _ := doc["payloads"].([]interface{})
+	installers := ar.GetHandlers()
+	for i, payload := range payloads {
+		files := map[string]interface{}{}
+		for _, file := range installers[i].GetUpdateAllFiles() {
+			files[file.Name] = string(file.Checksum)
+		}
+		payload.(map[string]interface{})["files"] = files
+	}
+	return doc, nil
+}
+
+// flattenDiffDocument maps the dot separated paths of the values in doc to
+// the values. Lists are only descended into if they hold objects, such as
+// the payloads, and are values otherwise.
+func flattenDiffDocument(doc interface{}, path string, out map[string]interface{}) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range doc {
+			flattenDiffDocument(value, join(key), out)
+		}
+		return
+	case []interface{}:
+		if len(doc) > 0 {
+			if _, ok := doc[0].(map[string]interface{}); ok {
+				for i, value := range doc {
+					flattenDiffDocument(value, join(strconv.Itoa(i)), out)
+				}
+				return
+			}
+		}
+	}
+	out[path] = doc
+}
+
+// diffArtifactDocuments returns the differences between the two documents,
+// sorted by field.
+func diffArtifactDocuments(a, b map[string]interface{}) []artifactDifference {
+	oldFields := map[string]interface{}{}
+	flattenDiffDocument(a, "", oldFields)
+	newFields := map[string]interface{}{}
+	flattenDiffDocument(b, "", newFields)
+
+	var diffs []artifactDifference
+	for field, value := range oldFields {
+		newValue, ok := newFields[field]
+		if !ok {
+			diffs = append(diffs, artifactDifference{
+				Field: field, Change: diffRemoved, Old: value,
+			})
+			continue
+		}
+		oldJSON, _ := json.Marshal(value)
+		newJSON, _ := json.Marshal(newValue)
+		if string(oldJSON) != string(newJSON) {
+			diffs = append(diffs, artifactDifference{
+				Field: field, Change: diffChanged, Old: value, New: newValue,
+			})
+		}
+	}
+	for field, value := range newFields {
+		if _, ok := oldFields[field]; !ok {
+			diffs = append(diffs, artifactDifference{
+				Field: field, Change: diffAdded, New: value,
+			})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Field < diffs[j].Field
+	})
+	return diffs
+}
+
+// diffValue formats a value for the human readable output.
+func diffValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func diffArtifacts(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return cli.NewExitError("Please give the two Artifacts to compare",
+			errArtifactInvalidParameters)
+	}
+	a, err := readDiffDocument(c.Args().Get(0))
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+	b, err := readDiffDocument(c.Args().Get(1))
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+	diffs := diffArtifactDocuments(a, b)
+
+	if c.Bool("json") {
+		if diffs == nil {
+			diffs = []artifactDifference{}
+		}
+		data, err := json.MarshalIndent(diffs, "", defaultIndentation)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("The Artifacts do not differ")
+		return nil
+	}
+	for _, diff := range diffs {
+		switch diff.Change {
+		case diffAdded:
+			fmt.Printf("+ %s: %s\n", diff.Field, diffValue(diff.New))
+		case diffRemoved:
+			fmt.Printf("- %s: %s\n", diff.Field, diffValue(diff.Old))
+		default:
+			fmt.Printf("~ %s: %s -> %s\n", diff.Field, diffValue(diff.Old),
+				diffValue(diff.New))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffArtifacts(t *testing.T) {
+	tmpdir := t.TempDir()
+	payload := filepath.Join(tmpdir, "payload")
+	require.NoError(t, os.WriteFile(payload, []byte("first"), 0644))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0755))
+	first := filepath.Join(tmpdir, "first.mender")
+	second := filepath.Join(tmpdir, "second.mender")
+
+	write := func(output string, args ...string) {
+		err := Run(append([]string{"mender-artifact", "write", "module-image",
+			"-T", "app", "-t", "my-device", "-f", payload, "-o", output}, args...))
+		require.NoError(t, err)
+	}
+	write(first, "-n", "release-1", "-p", "app.channel:stable", "-s", script)
+	require.NoError(t, os.WriteFile(payload, []byte("second"), 0644))
+	write(second, "-n", "release-2", "-t", "other-device")
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "diff", first, second})
+	require.NoError(t, err)
+	assert.Contains(t, data, "~ name: release-1 -> release-2\n")
+	assert.Contains(t, data,
+		`~ compatible_devices: ["my-device"] -> ["my-device","other-device"]`+"\n")
+	assert.Contains(t, data, "- payloads.0.provides.app.channel: stable\n")
+	assert.Contains(t, data, "- state_scripts.ArtifactInstall_Enter_00: ")
+	assert.Contains(t, data, "~ payloads.0.files.payload: ")
+	assert.NotContains(t, data, "payloads.0.type")
+
+	data, err = runAndCollectStdout([]string{"mender-artifact", "diff", "--json",
+		first, second})
+	require.NoError(t, err)
+	var diffs []artifactDifference
+	require.NoError(t, json.Unmarshal([]byte(data), &diffs))
+	assert.Contains(t, diffs, artifactDifference{
+		Field: "name", Change: diffChanged, Old: "release-1", New: "release-2",
+	})
+
+	data, err = runAndCollectStdout([]string{"mender-artifact", "diff", first, first})
+	require.NoError(t, err)
+	assert.Equal(t, "The Artifacts do not differ", data)
+	data, err = runAndCollectStdout([]string{"mender-artifact", "diff", "--json",
+		first, first})
+	require.NoError(t, err)
+	assert.Equal(t, "[]", data)
+
+	err = Run([]string{"mender-artifact", "diff", first})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "two Artifacts")
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// externalTool is an external program which some features of the CLI run.
+type externalTool struct {
+	name string
+	// binaries are the programs of the tool, all of which the features
+	// need; the version is read from the first one.
+	binaries []string
+	// pkg is the package the tool is usually installed with.
+	pkg         string
+	versionArgs []string
+	// minVersion is the oldest version known to work, and incompatibility
+	// what goes wrong with older ones.
+	minVersion      string
+	incompatibility string
+	// features are the features which are unavailable, or degraded,
+	// without the tool.
+	features []string
+}
+
+// externalTools are the tools `doctor` checks for.
+var externalTools = []externalTool{
+	{
+		name:        "debugfs",
+		binaries:    []string{"debugfs"},
+		pkg:         "e2fsprogs",
+		versionArgs: []string{"-V"},
+		minVersion:  "1.43",
+		incompatibility: "can not write to ext4 images with metadata_csum, which" +
+			" mkfs.ext4 enables by default since 1.43",
+		features: []string{
+			"cp, install and rm on ext4 images",
+			"data-partition",
+			"write rootfs-image --detect-platform",
+		},
+	},
+	{
+		name:        "fsck.ext4",
+		binaries:    []string{"fsck.ext4"},
+		pkg:         "e2fsprogs",
+		versionArgs: []string{"-V"},
+		minVersion:  "1.43",
+		incompatibility: "can not check ext4 images with metadata_csum, which" +
+			" mkfs.ext4 enables by default since 1.43",
+		features: []string{
+			"checking ext4 images before modifying them (degraded to the built-in check)",
+		},
+	},
+	{
+		name:        "mtools",
+		binaries:    []string{"mcopy", "mtype", "mdel", "mdeltree", "mmd", "mdir"},
+		pkg:         "mtools",
+		versionArgs: []string{"--version"},
+		features: []string{
+			"cat, cp, install, ls and rm on vfat images",
+		},
+	},
+	{
+		name:     "fsck.vfat",
+		binaries: []string{"fsck.vfat"},
+		pkg:      "dosfstools",
+		// fsck.fat prints its version with the usage.
+		features: []string{
+			"cp, install and rm on vfat images",
+			"write rootfs-image with vfat images",
+		},
+	},
+	{
+		name:        "blkid",
+		binaries:    []string{"blkid"},
+		pkg:         "util-linux",
+		versionArgs: []string{"-V"},
+		features: []string{
+			"recognizing vfat images",
+		},
+	},
+	{
+		name:        "mount",
+		binaries:    []string{"mount", "umount"},
+		pkg:         "util-linux",
+		versionArgs: []string{"-V"},
+		features: []string{
+			"cat, cp, install, ls and rm on btrfs and xfs images",
+			"mount",
+		},
+	},
+	{
+		name:        "ssh",
+		binaries:    []string{"ssh"},
+		pkg:         "openssh-client",
+		versionArgs: []string{"-V"},
+		features: []string{
+			"write rootfs-image --file ssh://",
+		},
+	},
+	{
+		name:        "cosign",
+		binaries:    []string{"cosign"},
+		pkg:         "cosign",
+		versionArgs: []string{"version"},
+		minVersion:  "2.0",
+		incompatibility: "does not upload signatures made with keys to the Rekor" +
+			" transparency log, which verification needs",
+		features: []string{
+			"signing and verifying with --sigstore",
+		},
+	},
+}
+
+// The statuses of the tools.
+const (
+	toolOK           = "ok"
+	toolMissing      = "missing"
+	toolIncompatible = "incompatible"
+)
+
+// toolVersionTimeout is how long a tool may take to print its version.
+const toolVersionTimeout = 5 * time.Second
+
+var toolVersionPattern = regexp.MustCompile(`[0-9]+\.[0-9]+(\.[0-9]+)?`)
+
+// toolReport is the result of checking an external tool.
+type toolReport struct {
+	Tool    string `json:"tool"`
+	Package string `json:"package"`
+	Path    string `json:"path,omitempty"`
+	Version string `json:"version,omitempty"`
+	Status  string `json:"status"`
+	Problem string `json:"problem,omitempty"`
+	// Features are the features which are unavailable or degraded, unless
+	// the status is ok.
+	Features []string `json:"features"`
+}
+
+// toolVersion runs the tool at path to find its version, which is empty if
+// it does not print one.
+func toolVersion(path string, args []string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), toolVersionTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// Some tools exit with an error after printing their version.
+	_ = cmd.Run()
+	return toolVersionPattern.FindString(out.String())
+}
+
+// checkExternalTool checks that the binaries of the tool are found with
+// lookup, and that the version is not known to be incompatible.
+func checkExternalTool(tool externalTool, lookup func(string) (string, error)) toolReport {
+	report := toolReport{
+		Tool:     tool.name,
+		Package:  tool.pkg,
+		Status:   toolOK,
+		Features: tool.features,
+	}
+	var missing []string
+	for _, binary := range tool.binaries {
+		path, err := lookup(binary)
+		if err != nil {
+			missing = append(missing, binary)
+		} else if report.Path == "" && binary == tool.binaries[0] {
+			report.Path = path
+		}
+	}
+	if len(missing) > 0 {
+		report.Status = toolMissing
+		report.Problem = fmt.Sprintf("%s not found; install the %s package",
+			strings.Join(missing, ", "), tool.pkg)
+		return report
+	}
+
+	report.Version = toolVersion(report.Path, tool.versionArgs)
+	if report.Version == "" || tool.minVersion == "" {
+		return report
+	}
+	version, err := parseClientVersion(report.Version)
+	if err != nil {
+		return report
+	}
+	minVersion, err := parseClientVersion(tool.minVersion)
+	if err == nil && clientVersionBefore(version, minVersion) {
+		report.Status = toolIncompatible
+		report.Problem = fmt.Sprintf("versions before %s %s", tool.minVersion,
+			tool.incompatibility)
+	}
+	return report
+}
+
+// doctor checks for the external tools which the CLI runs, and reports the
+// features which are unavailable or degraded on this host.
+func doctor(c *cli.Context) error {
+	var reports []toolReport
+	for _, tool := range externalTools {
+		reports = append(reports, checkExternalTool(tool, utils.GetBinaryPath))
+	}
+
+	if c.Bool("json") {
+		data, err := json.MarshalIndent(reports, "", defaultIndentation)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	problems := 0
+	for _, report := range reports {
+		version := report.Version
+		if version == "" {
+			version = "unknown version"
+		}
+		switch report.Status {
+		case toolOK:
+			fmt.Printf("%-13s %s %s (%s)\n", report.Status, report.Tool, version,
+				report.Path)
+			continue
+		case toolMissing:
+			fmt.Printf("%-13s %s: %s\n", report.Status, report.Tool, report.Problem)
+		default:
+			fmt.Printf("%-13s %s %s: %s\n", report.Status, report.Tool, version,
+				report.Problem)
+		}
+		problems++
+		for _, feature := range report.Features {
+			fmt.Printf("%-13s - %s\n", "", feature)
+		}
+	}
+	if problems == 0 {
+		fmt.Println("All the features are available")
+	} else {
+		fmt.Printf("%d of %d tools missing or incompatible; the features listed"+
+			" under them are unavailable or degraded\n", problems, len(reports))
+	}
+	return nil
+}
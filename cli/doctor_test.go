@@ -0,0 +1,90 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckExternalTool(t *testing.T) {
+	dir := t.TempDir()
+	makeTool := func(name, version string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path,
+			[]byte("#!/bin/sh\necho \""+version+"\" >&2\nexit 1\n"), 0755))
+		return path
+	}
+	lookup := func(name string) (string, error) {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			return name, exec.ErrNotFound
+		}
+		return path, nil
+	}
+	tool := externalTool{
+		name:            "debugfs",
+		binaries:        []string{"debugfs", "tune2fs"},
+		pkg:             "e2fsprogs",
+		minVersion:      "1.43",
+		incompatibility: "are too old",
+		features:        []string{"ext4"},
+	}
+
+	report := checkExternalTool(tool, lookup)
+	assert.Equal(t, toolMissing, report.Status)
+	assert.Equal(t, "debugfs, tune2fs not found; install the e2fsprogs package",
+		report.Problem)
+	assert.Equal(t, []string{"ext4"}, report.Features)
+
+	path := makeTool("debugfs", "debugfs 1.42.9 (4-Feb-2014)")
+	report = checkExternalTool(tool, lookup)
+	assert.Equal(t, toolMissing, report.Status)
+	assert.Equal(t, "tune2fs not found; install the e2fsprogs package", report.Problem)
+
+	makeTool("tune2fs", "tune2fs 1.42.9 (4-Feb-2014)")
+	report = checkExternalTool(tool, lookup)
+	assert.Equal(t, toolIncompatible, report.Status)
+	assert.Equal(t, path, report.Path)
+	assert.Equal(t, "1.42.9", report.Version)
+	assert.Equal(t, "versions before 1.43 are too old", report.Problem)
+
+	makeTool("debugfs", "debugfs 1.47.0 (5-Feb-2023)")
+	report = checkExternalTool(tool, lookup)
+	assert.Equal(t, toolOK, report.Status)
+	assert.Equal(t, "1.47.0", report.Version)
+	assert.Empty(t, report.Problem)
+
+	makeTool("debugfs", "no version")
+	report = checkExternalTool(tool, lookup)
+	assert.Equal(t, toolOK, report.Status)
+	assert.Empty(t, report.Version)
+}
+
+func TestDoctor(t *testing.T) {
+	out, err := runAndCollectStdout([]string{"mender-artifact", "doctor"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "debugfs")
+	assert.Contains(t, out, "mtools")
+
+	out, err = runAndCollectStdout([]string{"mender-artifact", "doctor", "--json"})
+	require.NoError(t, err)
+	assert.Contains(t, out, `"tool": "cosign"`)
+}
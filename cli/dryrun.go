@@ -0,0 +1,225 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Changes reported by a dry run.
+const (
+	dryRunWrite = iota
+	dryRunMkdir
+	dryRunRemove
+	dryRunRemoveAll
+)
+
+// imageFileStat describes a file inside an image, as far as needed to report
+// the effect of a change.
+type imageFileStat struct {
+	exists bool
+	isDir  bool
+	size   int64
+	mode   os.FileMode
+	// hasMode is false for filesystems without permission bits (vfat).
+	hasMode bool
+}
+
+// statInImage returns information about fpath inside image, without
+// modifying the image. It lists the parent directory through the same VPDir
+// the commands open, so every filesystem they support is covered.
+func statInImage(image VPImage, fpath string) (imageFileStat, error) {
+	fpath = path.Clean("/" + fpath)
+	dir, err := image.OpenDir(path.Dir(fpath))
+	if err != nil {
+		return imageFileStat{}, err
+	}
+	defer dir.Close()
+	hasMode := dirHasModes(dir)
+	if fpath == "/" {
+		return imageFileStat{exists: true, isDir: true, mode: os.ModeDir}, nil
+	}
+
+	entries, err := dir.ReadDir()
+	if err != nil {
+		// The parent can not be listed when it does not exist either.
+		parent, perr := statInImage(image, path.Dir(fpath))
+		if perr == nil && !parent.exists {
+			return imageFileStat{}, nil
+		}
+		return imageFileStat{}, err
+	}
+	for _, entry := range entries {
+		if entry.Name == path.Base(fpath) {
+			return imageFileStat{
+				exists:  true,
+				isDir:   entry.Mode.IsDir(),
+				size:    entry.Size,
+				mode:    entry.Mode,
+				hasMode: hasMode,
+			}, nil
+		}
+	}
+	return imageFileStat{}, nil
+}
+
+// dirHasModes tells whether the filesystem of d has permission bits.
+func dirHasModes(d VPDir) bool {
+	switch d := d.(type) {
+	case *fatDir:
+		return false
+	case sdimgDir:
+		return len(d) == 0 || dirHasModes(d[0])
+	}
+	return true
+}
+
+func formatMode(st imageFileStat) string {
+	if !st.hasMode {
+		return "-"
+	}
+	return fmt.Sprintf("%04o", st.mode.Perm())
+}
+
+// dryRunImageChange prints the change that applying op to imgAndPath would
+// make, without modifying or repacking the image. newFile describes the file
+// being written for dryRunWrite and dryRunMkdir.
+func dryRunImageChange(
+	key SigningKey,
+	op int,
+	imgAndPath string,
+	newFile imageFileStat,
+) error {
+	imgname, fpath, err := parseImgPath(imgAndPath)
+	if err != nil {
+		return err
+	}
+	image, err := virtualImage.Open(key, imgname)
+	if err != nil {
+		return err
+	}
+	// The image is never marked dirty, so closing it does not repack it.
+	defer image.Close()
+
+	return dryRunChangeIn(image, op, imgAndPath, fpath, newFile)
+}
+
+// dryRunChangeIn is dryRunImageChange for an image which is already open,
+// fpath being the path of imgAndPath inside the image.
+func dryRunChangeIn(
+	image VPImage,
+	op int,
+	imgAndPath string,
+	fpath string,
+	newFile imageFileStat,
+) error {
+	oldFile, err := statInImage(image, fpath)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case dryRunWrite:
+		if oldFile.exists {
+			fmt.Printf("replace %s: size %d -> %d, mode %s -> %s\n", imgAndPath,
+				oldFile.size, newFile.size, formatMode(oldFile), formatMode(newFile))
+		} else {
+			fmt.Printf("add %s: size %d, mode %s\n", imgAndPath,
+				newFile.size, formatMode(newFile))
+		}
+	case dryRunMkdir:
+		if oldFile.exists {
+			fmt.Printf("unchanged %s: directory exists\n", imgAndPath)
+		} else {
+			fmt.Printf("add directory %s\n", imgAndPath)
+		}
+	case dryRunRemove, dryRunRemoveAll:
+		if !oldFile.exists {
+			return errors.Errorf("%s: no such file or directory", imgAndPath)
+		}
+		if !oldFile.isDir {
+			fmt.Printf("remove %s: size %d, mode %s\n", imgAndPath,
+				oldFile.size, formatMode(oldFile))
+			return nil
+		}
+		entries, err := readImageDir(image, fpath)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 && op != dryRunRemoveAll {
+			return errors.Errorf("%s: directory not empty, use -r", imgAndPath)
+		}
+		fmt.Printf("remove directory %s: mode %s\n", imgAndPath, formatMode(oldFile))
+		return dryRunRemoveEntries(image, imgAndPath, fpath, entries, oldFile.hasMode)
+	}
+	return nil
+}
+
+// dryRunRemoveEntries prints the removal of the entries of the directory fpath,
+// and of everything below them.
+func dryRunRemoveEntries(
+	image VPImage,
+	imgAndPath string,
+	fpath string,
+	entries []VPDirEntry,
+	hasMode bool,
+) error {
+	for _, entry := range entries {
+		st := imageFileStat{
+			exists:  true,
+			isDir:   entry.Mode.IsDir(),
+			size:    entry.Size,
+			mode:    entry.Mode,
+			hasMode: hasMode,
+		}
+		entryImgAndPath := strings.TrimSuffix(imgAndPath, "/") + "/" + entry.Name
+		if !st.isDir {
+			fmt.Printf("remove %s: size %d, mode %s\n", entryImgAndPath,
+				st.size, formatMode(st))
+			continue
+		}
+		fmt.Printf("remove directory %s: mode %s\n", entryImgAndPath, formatMode(st))
+		entryPath := path.Join(fpath, entry.Name)
+		children, err := readImageDir(image, entryPath)
+		if err != nil {
+			return err
+		}
+		err = dryRunRemoveEntries(image, entryImgAndPath, entryPath, children, hasMode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hostFileStat describes a file on the host which is about to be written into
+// an image.
+func hostFileStat(name string) (imageFileStat, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return imageFileStat{}, err
+	}
+	return imageFileStat{
+		exists:  true,
+		size:    info.Size(),
+		mode:    info.Mode(),
+		hasMode: true,
+	}, nil
+}
@@ -20,6 +20,8 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/mendersoftware/mender-artifact/areader"
@@ -33,6 +35,13 @@ import (
 type dumpFileStore struct {
 	fileDir string
 	args    *[]string
+	// payloadArgs are the key=value pairs of the --payload flags of the
+	// payloads after the first.
+	payloadArgs map[int][]string
+	// multi is true if the Artifact has more than one payload, whose files
+	// are dumped into a subdirectory each.
+	multi      bool
+	payloadNum int
 }
 
 func DumpCommand(c *cli.Context) error {
@@ -43,7 +52,7 @@ func DumpCommand(c *cli.Context) error {
 			errArtifactInvalidParameters)
 	}
 
-	art, err := os.Open(c.Args().First())
+	art, err := openArtifactInput(c.Args().First())
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf(
 			"Error opening Artifact: %s", err.Error()),
@@ -53,15 +62,24 @@ func DumpCommand(c *cli.Context) error {
 
 	ar := areader.NewReader(art)
 
-	scriptsReadCallback := func(r io.Reader, i os.FileInfo) error {
-		fullPath := path.Join(c.String("scripts"), i.Name())
+	dumpScript := func(dir string, r io.Reader, i os.FileInfo) (string, error) {
+		fullPath, err := artifact.JoinArchivePath(dir, i.Name())
+		if err != nil {
+			return "", err
+		}
 		script, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
 		if err != nil {
-			return err
+			return "", err
 		}
 		defer script.Close()
 
-		_, err = io.Copy(script, r)
+		if _, err = io.Copy(script, r); err != nil {
+			return "", err
+		}
+		return fullPath, restoreFileInfo(fullPath, i)
+	}
+	scriptsReadCallback := func(r io.Reader, i os.FileInfo) error {
+		fullPath, err := dumpScript(c.String("scripts"), r, i)
 		if err != nil {
 			return err
 		}
@@ -70,6 +88,22 @@ func DumpCommand(c *cli.Context) error {
 
 		return nil
 	}
+	// The scripts of a single device type go into a subdirectory named
+	// after it, like in the Artifact.
+	deviceTypeScriptsReadCallback := func(deviceType string, r io.Reader, i os.FileInfo) error {
+		dir := path.Join(c.String("scripts"), deviceType)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		fullPath, err := dumpScript(dir, r, i)
+		if err != nil {
+			return err
+		}
+
+		dumpArgs = append(dumpArgs, "--script-for-device-type", deviceType+":"+fullPath)
+
+		return nil
+	}
 	if len(c.String("scripts")) > 0 {
 		err = os.MkdirAll(c.String("scripts"), 0755)
 		if err != nil {
@@ -77,6 +111,7 @@ func DumpCommand(c *cli.Context) error {
 				"Could not create directory: %s", err.Error()), errSystemError)
 		}
 		ar.ScriptsReadCallback = scriptsReadCallback
+		ar.DeviceTypeScriptsReadCallback = deviceTypeScriptsReadCallback
 	}
 
 	err = ar.ReadArtifactHeaders()
@@ -85,7 +120,9 @@ func DumpCommand(c *cli.Context) error {
 			err.Error()), errArtifactInvalid)
 	}
 
-	err = dumpPayloads(c, ar, &dumpArgs)
+	var damaged []string
+	payloadArgs := map[int][]string{}
+	err = dumpPayloads(c, ar, &dumpArgs, payloadArgs, &damaged)
 	if err != nil {
 		return err
 	}
@@ -93,23 +130,49 @@ func DumpCommand(c *cli.Context) error {
 	if c.Bool("print-cmdline") && c.Bool("print0-cmdline") {
 		return errors.New("--print-cmdline and --print0-cmdline are conflicting options.")
 	} else if c.Bool("print-cmdline") {
-		printCmdline(ar, dumpArgs, ' ', '\n')
+		err = printCmdline(ar, dumpArgs, payloadArgs, ' ', '\n')
 	} else if c.Bool("print0-cmdline") {
-		printCmdline(ar, dumpArgs, 0, 0)
+		err = printCmdline(ar, dumpArgs, payloadArgs, 0, 0)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactUnsupportedFeature)
+	}
+
+	if descriptor := c.String("descriptor"); descriptor != "" {
+		if err = writeAssembleDescriptor(descriptor, ar, dumpArgs, payloadArgs); err != nil {
+			return cli.NewExitError(fmt.Sprintf(
+				"Could not write descriptor: %s", err.Error()), errArtifactUnsupportedFeature)
+		}
+	}
+
+	if len(damaged) > 0 {
+		fmt.Fprintln(os.Stderr, "Damaged components, which were not dumped:")
+		for _, line := range damaged {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+		return cli.NewExitError(fmt.Sprintf(
+			"The Artifact is damaged: %d component(s) could not be salvaged", len(damaged)),
+			errArtifactInvalid)
 	}
 
 	return nil
 }
 
-func dumpPayloads(c *cli.Context, ar *areader.Reader, dumpArgs *[]string) error {
+func dumpPayloads(
+	c *cli.Context,
+	ar *areader.Reader,
+	dumpArgs *[]string,
+	payloadArgs map[int][]string,
+	damaged *[]string,
+) error {
 	handlers := ar.GetHandlers()
-	if len(handlers) != 1 {
-		return cli.NewExitError("The dump command can handle one payload only",
+	if len(handlers) == 0 {
+		return cli.NewExitError("The Artifact has no payloads to dump",
 			errArtifactUnsupportedFeature)
 	}
 
 	if len(c.String("meta-data")) > 0 {
-		err := dumpMetaData(c.String("meta-data"), dumpArgs, handlers)
+		err := dumpMetaData(c.String("meta-data"), dumpArgs, payloadArgs, handlers)
 		if err != nil {
 			return err
 		}
@@ -117,14 +180,23 @@ func dumpPayloads(c *cli.Context, ar *areader.Reader, dumpArgs *[]string) error
 
 	if len(c.String("files")) > 0 {
 		store := &dumpFileStore{
-			fileDir: c.String("files"),
-			args:    dumpArgs,
+			fileDir:     c.String("files"),
+			args:        dumpArgs,
+			payloadArgs: payloadArgs,
+			multi:       len(handlers) > 1,
 		}
 		for _, h := range handlers {
 			h.SetUpdateStorerProducer(store)
 		}
 	}
 
+	if c.Bool("salvage") {
+		ar.DamageCallback = func(name string, err error) error {
+			*damaged = append(*damaged, fmt.Sprintf("%s: %s", name, err.Error()))
+			return nil
+		}
+	}
+
 	err := ar.ReadArtifactData()
 	if err != nil {
 		return cli.NewExitError(fmt.Sprintf("Error dumping Artifact: %s",
@@ -134,9 +206,12 @@ func dumpPayloads(c *cli.Context, ar *areader.Reader, dumpArgs *[]string) error
 	return nil
 }
 
+// dumpMetaData dumps the meta-data of every payload into a file named after
+// its index, such as 0000.meta-data.
 func dumpMetaData(
 	metaDataDir string,
 	dumpArgs *[]string,
+	payloadArgs map[int][]string,
 	handlers map[int]handlers.Installer,
 ) error {
 	err := os.MkdirAll(metaDataDir, 0755)
@@ -145,20 +220,33 @@ func dumpMetaData(
 			"Unable to create directory: %s", err.Error()), errSystemError)
 	}
 
-	// Hardcode to 0 index for now.
-	handler := handlers[0]
+	for i := 0; i < len(handlers); i++ {
+		if err = dumpPayloadMetaData(metaDataDir, i, handlers[i], dumpArgs,
+			payloadArgs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+func dumpPayloadMetaData(
+	metaDataDir string,
+	payloadNum int,
+	handler handlers.Installer,
+	dumpArgs *[]string,
+	payloadArgs map[int][]string,
+) error {
 	for _, augmented := range []bool{false, true} {
 		var metaData map[string]interface{}
 		var fullPath string
 		var metaDataArg string
 		if augmented {
 			metaData = handler.GetUpdateAugmentMetaData()
-			fullPath = path.Join(metaDataDir, "0000.meta-data-augment")
+			fullPath = path.Join(metaDataDir, fmt.Sprintf("%04d.meta-data-augment", payloadNum))
 			metaDataArg = "--augment-meta-data"
 		} else {
 			metaData = handler.GetUpdateOriginalMetaData()
-			fullPath = path.Join(metaDataDir, "0000.meta-data")
+			fullPath = path.Join(metaDataDir, fmt.Sprintf("%04d.meta-data", payloadNum))
 			metaDataArg = "--meta-data"
 		}
 
@@ -180,80 +268,183 @@ func dumpMetaData(
 			return errors.New("Unencodeable map in dumpPayloads. Should not happen.")
 		}
 
-		*dumpArgs = append(*dumpArgs, metaDataArg, fullPath)
+		if payloadNum == 0 {
+			*dumpArgs = append(*dumpArgs, metaDataArg, fullPath)
+		} else if !augmented {
+			payloadArgs[payloadNum] = append(payloadArgs[payloadNum], "meta-data="+fullPath)
+		}
 	}
 
 	return nil
 }
 
-func printCmdline(ar *areader.Reader, args []string, sep, endChar rune) {
+// payloadSpec returns the --payload flag value which recreates the payload
+// after the first, with the dumped files and meta-data in args.
+func payloadSpec(payloadNum int, handler handlers.Installer, args []string) (string, error) {
+	if len(handler.GetUpdateAugmentMetaData()) > 0 ||
+		len(handler.GetUpdateAugmentProvides()) > 0 ||
+		len(handler.GetUpdateAugmentDepends()) > 0 ||
+		len(handler.GetUpdateAugmentClearsProvides()) > 0 ||
+		len(handler.GetUpdateAugmentFiles()) > 0 {
+		return "", errors.Errorf("payload %d has augmented headers, which can not be"+
+			" recreated with --payload", payloadNum)
+	}
+	if installSizeOverride(handler) > 0 {
+		return "", errors.Errorf("payload %d has an install size, which can not be"+
+			" recreated with --payload", payloadNum)
+	}
+
+	fields := []string{"type=" + *handler.GetUpdateType()}
+	fields = append(fields, sortedKeyValues("provides", handler.GetUpdateOriginalProvides())...)
+	depends := map[string]string{}
+	for key, value := range handler.GetUpdateOriginalDepends() {
+		depends[key] = fmt.Sprint(value)
+	}
+	fields = append(fields, sortedKeyValues("depends", depends)...)
+	for _, value := range handler.GetUpdateOriginalClearsProvides() {
+		fields = append(fields, "clears-provides="+value)
+	}
+	fields = append(fields, args...)
+	for _, field := range fields {
+		if strings.Contains(field, ",") {
+			return "", errors.Errorf("payload %d can not be recreated with --payload,"+
+				" since %q contains a comma", payloadNum, field)
+		}
+	}
+	return strings.Join(fields, ","), nil
+}
+
+// sortedKeyValues returns key=name:value for the values, sorted by name.
+func sortedKeyValues(key string, values map[string]string) []string {
+	var fields []string
+	for name, value := range values {
+		fields = append(fields, fmt.Sprintf("%s=%s:%s", key, name, value))
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// buildCmdline returns the arguments of the write command which recreates
+// the Artifact from the dumped files in args and payloadArgs.
+func buildCmdline(
+	ar *areader.Reader,
+	args []string,
+	payloadArgs map[int][]string,
+) ([]string, error) {
+	handlers := ar.GetHandlers()
+	var specs []string
+	for i := 1; i < len(handlers); i++ {
+		spec, err := payloadSpec(i, handlers[i], payloadArgs[i])
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+
 	// Even if it is a rootfs payload, we use the module-image writer, since
 	// this can recreate either type.
-	fmt.Printf("write%cmodule-image", sep)
+	cmdline := []string{"write", "module-image"}
 
 	if ar.GetInfo().Version == 3 {
 		artProvs := ar.GetArtifactProvides()
-		fmt.Printf("%c--artifact-name%c%s", sep, sep, artProvs.ArtifactName)
+		cmdline = append(cmdline, "--artifact-name", artProvs.ArtifactName)
 		if len(artProvs.ArtifactGroup) > 0 {
-			fmt.Printf("%c--provides-group%c%s", sep, sep, artProvs.ArtifactGroup)
+			cmdline = append(cmdline, "--provides-group", artProvs.ArtifactGroup)
 		}
 
 		artDeps := ar.GetArtifactDepends()
-		if len(artDeps.ArtifactName) > 0 {
-			fmt.Printf("%c--artifact-name-depends%c%s", sep, sep,
-				strings.Join(artDeps.ArtifactName,
-					fmt.Sprintf("%c--artifact-name-depends%c", sep, sep)))
+		for _, name := range artDeps.ArtifactName {
+			cmdline = append(cmdline, "--artifact-name-depends", name)
+		}
+		for _, device := range artDeps.CompatibleDevices {
+			cmdline = append(cmdline, "--device-type", device)
 		}
-		fmt.Printf("%c--device-type%c%s", sep, sep,
-			strings.Join(artDeps.CompatibleDevices, fmt.Sprintf("%c--device-type%c", sep, sep)))
-		if len(artDeps.ArtifactGroup) > 0 {
-			fmt.Printf("%c--depends-groups%c%s", sep, sep,
-				strings.Join(artDeps.ArtifactGroup, fmt.Sprintf("%c--depends-groups%c", sep, sep)))
+		for _, group := range artDeps.ArtifactGroup {
+			cmdline = append(cmdline, "--depends-groups", group)
+		}
+		for _, device := range artDeps.ExcludedDevices {
+			cmdline = append(cmdline, "--not-device-type", device)
+		}
+
+		if ar.IsMetadataImmutable() {
+			cmdline = append(cmdline, "--immutable-metadata")
+		}
+		if algorithm := ar.ChecksumAlgorithm(); algorithm != artifact.ChecksumSHA256 {
+			cmdline = append(cmdline, "--checksum-algorithm", algorithm)
+		}
+		if ar.HasUncompressedHeader() {
+			cmdline = append(cmdline, "--uncompressed-header")
 		}
 
 	} else if ar.GetInfo().Version == 2 {
-		fmt.Printf("%c--artifact-name%c%s", sep, sep, ar.GetArtifactName())
-		fmt.Printf("%c--device-type%c%s", sep, sep,
-			strings.Join(ar.GetCompatibleDevices(), " --device-type "))
+		cmdline = append(cmdline, "--artifact-name", ar.GetArtifactName())
+		for _, device := range ar.GetCompatibleDevices() {
+			cmdline = append(cmdline, "--device-type", device)
+		}
 	}
 
-	handlers := ar.GetHandlers()
 	handler := handlers[0]
 
-	fmt.Printf("%c--type%c%s", sep, sep, *handler.GetUpdateType())
+	cmdline = append(cmdline, "--type", *handler.GetUpdateType())
 
 	// Always add this flag, since we will write custom flags.
-	fmt.Printf("%c--%s", sep, noDefaultSoftwareVersionFlag)
+	cmdline = append(cmdline, "--"+noDefaultSoftwareVersionFlag)
 
 	provs := handler.GetUpdateOriginalProvides()
 	for key, value := range provs {
-		fmt.Printf("%c--provides%c%s:%s", sep, sep, key, value)
+		cmdline = append(cmdline, "--provides", key+":"+value)
 	}
 
 	deps := handler.GetUpdateOriginalDepends()
 	for key, value := range deps {
-		fmt.Printf("%c--depends%c%s:%s", sep, sep, key, value)
+		cmdline = append(cmdline, "--depends", key+":"+fmt.Sprint(value))
 	}
 
 	// Always add this flag, since we will write custom flags.
-	fmt.Printf("%c--%s", sep, noDefaultClearsProvidesFlag)
+	cmdline = append(cmdline, "--"+noDefaultClearsProvidesFlag)
 
 	caps := handler.GetUpdateOriginalClearsProvides()
 	for _, value := range caps {
-		fmt.Printf("%c--%s%c%s", sep, clearsProvidesFlag, sep, value)
+		cmdline = append(cmdline, "--"+clearsProvidesFlag, value)
 	}
 
-	if len(args) > 0 {
-		fmt.Printf("%c%s", sep, strings.Join(args, string(sep)))
+	if size := installSizeOverride(handler); size > 0 {
+		cmdline = append(cmdline, "--install-size", strconv.FormatInt(size, 10))
 	}
-	fmt.Printf("%c", endChar)
+
+	cmdline = append(cmdline, args...)
+	for _, spec := range specs {
+		cmdline = append(cmdline, "--payload", spec)
+	}
+	return cmdline, nil
+}
+
+// printCmdline prints the write command which recreates the Artifact, with
+// sep between the arguments, and endChar after them.
+func printCmdline(
+	ar *areader.Reader,
+	args []string,
+	payloadArgs map[int][]string,
+	sep, endChar rune,
+) error {
+	cmdline, err := buildCmdline(ar, args, payloadArgs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s%c", strings.Join(cmdline, string(sep)), endChar)
+	return nil
 }
 
 func (d *dumpFileStore) NewUpdateStorer(
 	updateType *string,
 	payloadNum int,
 ) (handlers.UpdateStorer, error) {
-	return d, nil
+	store := *d
+	store.payloadNum = payloadNum
+	if d.multi {
+		store.fileDir = path.Join(d.fileDir, fmt.Sprintf("%04d", payloadNum))
+	}
+	return &store, nil
 }
 
 func (d *dumpFileStore) Initialize(artifactHeaders,
@@ -268,7 +459,10 @@ func (d *dumpFileStore) PrepareStoreUpdate() error {
 }
 
 func (d *dumpFileStore) StoreUpdate(r io.Reader, info os.FileInfo) error {
-	fullPath := path.Join(d.fileDir, info.Name())
+	fullPath, err := artifact.JoinArchivePath(d.fileDir, info.Name())
+	if err != nil {
+		return err
+	}
 	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
 		return err
@@ -277,10 +471,20 @@ func (d *dumpFileStore) StoreUpdate(r io.Reader, info os.FileInfo) error {
 
 	_, err = io.Copy(file, r)
 	if err != nil {
+		// Do not leave damaged or partial files behind.
+		file.Close()
+		os.Remove(fullPath)
+		return err
+	}
+	if err = restoreFileInfo(fullPath, info); err != nil {
 		return err
 	}
 
-	*d.args = append(*d.args, "--file", fullPath)
+	if d.payloadNum == 0 {
+		*d.args = append(*d.args, "--file", fullPath)
+	} else {
+		d.payloadArgs[d.payloadNum] = append(d.payloadArgs[d.payloadNum], "file="+fullPath)
+	}
 
 	return nil
 }
@@ -288,3 +492,18 @@ func (d *dumpFileStore) StoreUpdate(r io.Reader, info os.FileInfo) error {
 func (d *dumpFileStore) FinishStoreUpdate() error {
 	return nil
 }
+
+// restoreFileInfo gives the dumped file at path the permissions and the
+// modification time it had in the Artifact, so that writing it again stores
+// the same header.
+func restoreFileInfo(path string, info os.FileInfo) error {
+	if perm := info.Mode().Perm(); perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			return err
+		}
+	}
+	if info.ModTime().IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
@@ -105,6 +105,7 @@ func testDumpContent(t *testing.T, imageType, printCmdline string) {
 	makeFile(t, tmpdir, "meta-data", "{\"a\":\"b\"}")
 	makeFile(t, tmpdir, "ArtifactInstall_Enter_45_test", "Bash magic")
 	makeFile(t, tmpdir, "ArtifactCommit_Leave_55", "More Bash magic")
+	makeFile(t, tmpdir, "ArtifactInstall_Enter_45_device", "Device Bash magic")
 
 	// --------------------------------------------------------------------
 	// Single values
@@ -188,13 +189,18 @@ func testDumpContent(t *testing.T, imageType, printCmdline string) {
 		"-m", path.Join(tmpdir, "meta-data"),
 		"-s", path.Join(tmpdir, "ArtifactInstall_Enter_45_test"),
 		"-s", path.Join(tmpdir, "ArtifactCommit_Leave_55"),
+		"--script-for-device-type",
+		"TestDevice2:" + path.Join(tmpdir, "ArtifactInstall_Enter_45_device"),
 		"-d", "testDepends:someDep",
 		"-p", "testProvides:someProv",
 		"-d", "testDepends2:someDep2",
 		"-p", "testProvides2:someProv2",
 		"-g", "providesGroup",
 		"-G", "dependsGroup",
-		"-G", "dependsGroup2"})
+		"-G", "dependsGroup2",
+		"--not-device-type", "TestDevice3",
+		"--immutable-metadata",
+		"--install-size", "1000000"})
 	require.NoError(t, err)
 
 	printed, err = runAndCollectStdout([]string{"mender-artifact", "dump",
@@ -220,6 +226,9 @@ func testDumpContent(t *testing.T, imageType, printCmdline string) {
 			" --device-type TestDevice2"+
 			" --depends-groups dependsGroup"+
 			" --depends-groups dependsGroup2"+
+			" --not-device-type TestDevice3"+
+			" --immutable-metadata"+
+			" --install-size 1000000"+
 			fmt.Sprintf(" --type %s", imageType)+
 			" --no-default-software-version"+
 			" --no-default-clears-provides"+
@@ -230,6 +239,8 @@ func testDumpContent(t *testing.T, imageType, printCmdline string) {
 			" --depends testDepends2:someDep2"+
 			fmt.Sprintf(" --script %s/scripts/ArtifactInstall_Enter_45_test", tmpdir)+
 			fmt.Sprintf(" --script %s/scripts/ArtifactCommit_Leave_55", tmpdir)+
+			fmt.Sprintf(" --script-for-device-type"+
+				" TestDevice2:%s/scripts/TestDevice2/ArtifactInstall_Enter_45_device", tmpdir)+
 			fmt.Sprintf(" --clears-provides %s.*", imageType)+
 			fmt.Sprintf(" --clears-provides rootfs-image.%s.*", imageType)+
 			fmt.Sprintf(" --meta-data %s/meta/0000.meta-data", tmpdir)+
@@ -269,32 +280,170 @@ func testDumpContent(t *testing.T, imageType, printCmdline string) {
 		"artifact-name",
 		"artifact-name-depends",
 		"clears-provides",
-		"compression", // Not tested in "dump".
+		"checksum-file",   // Not relevant for "dump".
+		"compression",     // Not tested in "dump".
+		"compression-opt", // Not tested in "dump".
 		"depends",
 		"depends-groups",
+		"delta-base",      // Not tested in "dump".
+		"detect-platform", // Not relevant for "dump", which uses "module-image".
 		"device-type",
+		"dir",              // Dumped as "file".
+		"dry-run",          // Not relevant for "dump".
+		"external-payload", // Not tested in "dump".
 		"file",
-		"gcp-kms-key",                  // Not tested in "dump".
+		"files-from",  // Dumped as "file".
+		"gcp-kms-key", // Not tested in "dump".
+		"immutable-metadata",
+		"install-size",
+		"not-device-type",
 		"vault-transit-key",            // Not tested in "dump".
 		"keyfactor-signserver-worker",  // Not tested in "dump".
 		"key",                          // Not tested in "dump".
+		"key-provider",                 // Not tested in "dump".
 		"legacy-rootfs-image-checksum", // Not relevant for "dump", which uses "module-image".
 		"meta-data",
 		"no-checksum-provide", // Not relevant for "dump", which uses "module-image".
 		"no-default-clears-provides",
 		"no-default-software-version",
-		"output-path", // Not relevant for "dump".
+		"normalize-device-types", // Dumped device types are already normalized.
+		"output-path",            // Not relevant for "dump".
+		"payload",                // Tested in TestDumpMultiplePayloads.
+		"payload-sign-key",       // Not tested in "dump".
+		"profile",                // Dumped as the headers it results in.
 		"provides",
+		"provides-for-device", // Dumped as "provides" of each Artifact.
 		"provides-group",
+		"sanitize-filenames", // Files are dumped under their name in the Artifact.
 		"script",
+		"script-dir", // Dumped as "script".
+		"script-for-device-type",
 		"software-filesystem", // These three indirectly handled by --provides.
 		"software-name",       // <
 		"software-version",    // <
 		"ssh-args",            // Not relevant for "dump".
+		"stats",               // Not relevant for "dump".
+		"buffer-memory",       // Not relevant for "dump".
+		"temp-dir",            // Not relevant for "dump".
+		"reproducible",        // Tested in TestWriteReproducible.
+		"checksum-algorithm",  // Tested in TestWriteChecksumAlgorithm.
+		"from-device-json",    // Only sets other flags.
 		"type",
-		"version", // Could be supported, but in practice we only support >= v3.
+		"uncompressed-header", // Not tested in "dump".
+		"verity",              // Not relevant for "dump", which uses "module-image".
+		"version",             // Could be supported, but in practice we only support >= v3.
 		"no-progress",
 	})
 
 	flagChecker.checkAllFlagsTested(t)
 }
+
+func TestDumpSalvage(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	makeFile(t, tmpdir, "file2", "payload2")
+	artfile := path.Join(tmpdir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "--compression", "none", "write", "module-image",
+		"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+		"-f", path.Join(tmpdir, "file"), "-f", path.Join(tmpdir, "file2")})
+	require.NoError(t, err)
+
+	// Corrupt the second file, keeping the Artifact readable.
+	data, err := os.ReadFile(artfile)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "payload2"))
+	data = []byte(strings.Replace(string(data), "payload2", "PAYLOAD2", 1))
+	require.NoError(t, os.WriteFile(artfile, data, 0644))
+
+	files := path.Join(tmpdir, "dump")
+	err = Run([]string{"mender-artifact", "dump", "--files", files, artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+
+	require.NoError(t, os.RemoveAll(files))
+	// The command line is printed even though the command fails.
+	stdout, err := os.Create(path.Join(tmpdir, "stdout"))
+	require.NoError(t, err)
+	savedStdout := os.Stdout
+	os.Stdout = stdout
+	err = Run([]string{"mender-artifact", "dump", "--salvage",
+		"--files", files, "--print-cmdline", artfile})
+	os.Stdout = savedStdout
+	stdout.Close()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 component(s) could not be salvaged")
+	assert.Equal(t, errArtifactInvalid, lastExitCode)
+	out, err := os.ReadFile(stdout.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "--file "+path.Join(files, "file"))
+	assert.NotContains(t, string(out), "file2")
+
+	salvaged, err := os.ReadFile(path.Join(files, "file"))
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(salvaged))
+	assert.NoFileExists(t, path.Join(files, "file2"))
+}
+
+func TestDumpUnsafePath(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	makeFile(t, tmpdir, "file2", "payload2")
+	artfile := path.Join(tmpdir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "--compression", "none", "write", "module-image",
+		"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+		"-f", path.Join(tmpdir, "file"), "-f", path.Join(tmpdir, "file2")})
+	require.NoError(t, err)
+
+	// Make the second file escape the dump directory.
+	data, err := os.ReadFile(artfile)
+	require.NoError(t, err)
+	data = []byte(strings.Replace(string(data), "data/0000/file2", "data/0000/../f2", 1))
+	require.NoError(t, os.WriteFile(artfile, data, 0644))
+
+	files := path.Join(tmpdir, "dump", "files")
+	err = Run([]string{"mender-artifact", "dump", "--files", files, artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsafe path in Artifact")
+	assert.NoFileExists(t, path.Join(tmpdir, "dump", "f2"))
+}
+
+func TestDumpMultiplePayloads(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	makeFile(t, tmpdir, "app.conf", "config")
+	makeFile(t, tmpdir, "meta-data", "{\"a\":\"b\"}")
+	artfile := path.Join(tmpdir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+		"-f", path.Join(tmpdir, "file"),
+		"--payload", "type=config,file=" + path.Join(tmpdir, "app.conf") +
+			",provides=config.version:2,depends=config.version:1,meta-data=" +
+			path.Join(tmpdir, "meta-data")})
+	require.NoError(t, err)
+
+	files := path.Join(tmpdir, "files")
+	meta := path.Join(tmpdir, "meta")
+	printed, err := runAndCollectStdout([]string{"mender-artifact", "dump",
+		"--files", files, "--meta-data", meta, "--print0-cmdline", artfile})
+	require.NoError(t, err)
+
+	assert.FileExists(t, path.Join(files, "0000", "file"))
+	assert.FileExists(t, path.Join(files, "0001", "app.conf"))
+	assert.FileExists(t, path.Join(meta, "0001.meta-data"))
+	assert.Contains(t, printed, "--file\x00"+path.Join(files, "0000", "file"))
+	assert.Contains(t, printed, "--payload\x00type=config,"+
+		"provides=config.version:2,provides=rootfs-image.config.version:Name,"+
+		"depends=config.version:1,clears-provides=rootfs-image.config.*,"+
+		"meta-data="+path.Join(meta, "0001.meta-data")+
+		",file="+path.Join(files, "0001", "app.conf"))
+
+	// The command line recreates the same Artifact.
+	recreated := path.Join(tmpdir, "recreated.mender")
+	args := append([]string{"mender-artifact"}, strings.Split(printed, "\x00")...)
+	require.NoError(t, Run(append(args, "-o", recreated)))
+	a, err := readDiffDocument(artfile)
+	require.NoError(t, err)
+	b, err := readDiffDocument(recreated)
+	require.NoError(t, err)
+	assert.Empty(t, diffArtifactDocuments(a, b))
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// makeExternalFiles returns the references to the --file files stored at
+// the --external-payload URLs, which a thin Artifact records instead of the
+// files themselves.
+func makeExternalFiles(ctx *cli.Context, files []string) ([]artifact.ExternalFile, error) {
+	urls := ctx.StringSlice("external-payload")
+	if ctx.String("delta-base") != "" {
+		return nil, cli.NewExitError("Thin Artifacts can not be delta Artifacts",
+			errArtifactUnsupportedFeature)
+	}
+	if ctx.String("dir") != "" || ctx.String("files-from") != "" {
+		return nil, cli.NewExitError("The payload files of thin Artifacts must be given"+
+			" with --file", errArtifactInvalidParameters)
+	}
+	if len(urls) != len(files) {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("Give one --external-payload URL for each --file: got %d URLs"+
+				" and %d files", len(urls), len(files)),
+			errArtifactInvalidParameters)
+	}
+
+	external := make([]artifact.ExternalFile, 0, len(files))
+	names := make(map[string]bool)
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		sum, err := fileChecksum(file)
+		if err != nil {
+			return nil, cli.NewExitError(
+				fmt.Sprintf("Can not read %s: %s", file, err), errArtifactInvalidParameters)
+		}
+		f := artifact.ExternalFile{
+			Name:     filepath.Base(file),
+			URL:      urls[i],
+			Checksum: sum,
+			Size:     info.Size(),
+		}
+		if names[f.Name] {
+			return nil, cli.NewExitError("Duplicate payload file name: "+f.Name,
+				errArtifactInvalidParameters)
+		}
+		names[f.Name] = true
+		if err = f.Validate(); err != nil {
+			return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		external = append(external, f)
+	}
+	return external, nil
+}
+
+// applyExternalFiles records the external files in the payload meta-data and
+// declares their size as the install size, unless one was given.
+func applyExternalFiles(
+	external []artifact.ExternalFile,
+	metaData map[string]interface{},
+	typeInfo *artifact.TypeInfoV3,
+) (map[string]interface{}, error) {
+	if metaData == nil {
+		metaData = make(map[string]interface{})
+	}
+	if _, ok := metaData[artifact.ExternalPayloadMetaDataKey]; ok {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("The meta-data key %q is reserved for thin Artifacts",
+				artifact.ExternalPayloadMetaDataKey),
+			errArtifactInvalidParameters)
+	}
+	metaData[artifact.ExternalPayloadMetaDataKey] = artifact.ExternalFilesMetaData(external)
+	if typeInfo.InstallSize == 0 {
+		for _, f := range external {
+			typeInfo.InstallSize += f.Size
+		}
+	}
+	return metaData, nil
+}
+
+// externalFileClient downloads the external payload files. Its timeout bounds
+// the whole download, so that a stalled server does not hang validation.
+var externalFileClient = &http.Client{Timeout: 30 * time.Minute}
+
+// fetchExternalFile downloads the file, and checks its size and checksum.
+func fetchExternalFile(f artifact.ExternalFile) error {
+	resp, err := externalFileClient.Get(f.URL)
+	if err != nil {
+		return errors.Wrapf(err, "can not fetch %s", f.Name)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("can not fetch %s from %s: %s", f.Name, f.URL, resp.Status)
+	}
+	return f.Verify(resp.Body)
+}
+
+// validateExternalFiles checks the references to the payload files of thin
+// Artifacts, and verifies the files with --fetch-external.
+func validateExternalFiles(c *cli.Context, ar *areader.Reader) error {
+	external, err := checkExternalFiles(ar, c.Bool("fetch-external"))
+	if err != nil {
+		return err
+	}
+	for _, file := range external {
+		switch file.Status {
+		case "failed":
+			return errors.New(file.Error)
+		case "ok":
+			fmt.Printf("External payload file '%s' verified from %s\n", file.Name, file.URL)
+		}
+	}
+	return nil
+}
+
+// validationExternalFile is the result of checking one file of a thin
+// Artifact.
+type validationExternalFile struct {
+	Payload  int    `json:"payload"`
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// checkExternalFiles returns the external files of the Artifact, which are
+// fetched and verified if fetch is true, and otherwise left unchecked.
+func checkExternalFiles(ar *areader.Reader, fetch bool) ([]validationExternalFile, error) {
+	external, err := ar.ExternalFiles()
+	if err != nil {
+		return nil, err
+	}
+	payloads := make([]int, 0, len(external))
+	for i := range external {
+		payloads = append(payloads, i)
+	}
+	sort.Ints(payloads)
+
+	var checked []validationExternalFile
+	for _, i := range payloads {
+		for _, f := range external[i] {
+			file := validationExternalFile{
+				Payload:  i,
+				Name:     f.Name,
+				URL:      f.URL,
+				Checksum: f.Checksum,
+				Size:     f.Size,
+				Status:   "unchecked",
+			}
+			if fetch {
+				file.Status = "ok"
+				if err := fetchExternalFile(f); err != nil {
+					file.Status = "failed"
+					file.Error = err.Error()
+				}
+			}
+			checked = append(checked, file)
+		}
+	}
+	return checked, nil
+}
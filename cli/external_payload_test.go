@@ -0,0 +1,116 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+func TestWriteThinArtifact(t *testing.T) {
+	tmpdir := t.TempDir()
+	payload := filepath.Join(tmpdir, "rootfs.img")
+	require.NoError(t, os.WriteFile(payload, []byte("the payload"), 0644))
+
+	content := "the payload"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rootfs.img" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	thin := filepath.Join(tmpdir, "thin.mender")
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module", "-o", thin,
+		"-f", payload, "--external-payload", server.URL + "/rootfs.img"})
+	require.NoError(t, err)
+
+	f, err := os.Open(thin)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	inst := ar.GetHandlers()[0]
+	assert.Empty(t, inst.GetUpdateFiles())
+	assert.Equal(t, int64(len(content)), inst.GetUpdateInstallSize())
+	external, err := ar.ExternalFiles()
+	require.NoError(t, err)
+	require.Len(t, external[0], 1)
+	assert.Equal(t, artifact.ExternalFile{
+		Name:     "rootfs.img",
+		URL:      server.URL + "/rootfs.img",
+		Checksum: "7589d543b008d1508b11f71c49784a7dbe0b7b672ffeb847abe08289f7c9867d",
+		Size:     int64(len(content)),
+	}, external[0][0])
+
+	// The references are only checked if asked for.
+	out, err := runAndCollectStdout([]string{"mender-artifact", "validate", thin})
+	require.NoError(t, err)
+	assert.NotContains(t, out, "External payload file")
+	out, err = runAndCollectStdout([]string{"mender-artifact", "validate", thin,
+		"--fetch-external"})
+	require.NoError(t, err)
+	assert.Contains(t, out, "External payload file 'rootfs.img' verified from "+server.URL)
+
+	content = "another payload"
+	err = Run([]string{"mender-artifact", "validate", thin, "--fetch-external"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "external payload: rootfs.img is larger than 11 bytes")
+
+	report, err := validateJSON(t, "--fetch-external", thin)
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	require.Len(t, report.ExternalFiles, 1)
+	assert.Equal(t, "failed", report.ExternalFiles[0].Status)
+
+	// A server which does not answer in time fails the check.
+	stalled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer slow.Close()
+	defer close(stalled)
+	savedClient := externalFileClient
+	externalFileClient = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { externalFileClient = savedClient }()
+	err = fetchExternalFile(artifact.ExternalFile{Name: "rootfs.img", URL: slow.URL})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not fetch rootfs.img")
+
+	// One URL is needed for every file.
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module", "-o", thin,
+		"-f", payload, "-f", payload, "--external-payload", server.URL + "/rootfs.img"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Give one --external-payload URL for each --file")
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module", "-o", thin,
+		"-f", payload, "--external-payload", "ftp://cdn.example.com/rootfs.img"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not an http or https URL")
+}
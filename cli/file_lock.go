@@ -0,0 +1,111 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// lockPollInterval is how often a lock held by another process is retried.
+var lockPollInterval = 100 * time.Millisecond
+
+var noLockFlag = cli.BoolFlag{
+	Name:  "no-lock",
+	Usage: "Do not lock the file being modified against concurrent mender-artifact runs",
+}
+
+var lockTimeoutFlag = cli.DurationFlag{
+	Name:  "lock-timeout",
+	Value: time.Minute,
+	Usage: "How long to wait for another mender-artifact run to release the file" +
+		" being modified",
+}
+
+// fileLock is an exclusive advisory lock on a file. It only guards against
+// other processes which also take it, such as parallel mender-artifact runs.
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile locks path, waiting at most timeout for another holder to release
+// it. Files are often modified by renaming a new file over them, so a lock
+// taken on a file which has since been replaced is dropped, and the new file
+// locked instead.
+func lockFile(path string, timeout time.Duration) (*fileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not open %s for locking", path)
+		}
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "can not lock %s", path)
+		}
+		if locked {
+			held, herr := f.Stat()
+			current, cerr := os.Stat(path)
+			if herr == nil && cerr == nil && os.SameFile(held, current) {
+				return &fileLock{f: f}, nil
+			}
+			unlockFile(f)
+		}
+		f.Close()
+
+		if !time.Now().Before(deadline) {
+			return nil, errors.Errorf("timed out after %s waiting for %s to be released"+
+				" by another process; use --no-lock to skip locking", timeout, path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Unlock releases the lock.
+func (l *fileLock) Unlock() error {
+	unlockFile(l.f)
+	return l.f.Close()
+}
+
+// lockImage locks the image or Artifact which a command is about to modify,
+// unless locking is disabled with --no-lock or nothing is modified because of
+// --dry-run. The returned function releases the lock.
+func lockImage(c *cli.Context, path string) (func(), error) {
+	if c.Bool("no-lock") || c.Bool("dry-run") {
+		return func() {}, nil
+	}
+	l, err := lockFile(path, c.Duration("lock-timeout"))
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := l.Unlock(); err != nil {
+			Log.Debugf("Failed to release the lock on %s: %v", path, err)
+		}
+	}, nil
+}
+
+// lockImagePath is lockImage for [artifact|sdimg]:<filepath> arguments.
+func lockImagePath(c *cli.Context, imgAndPath string) (func(), error) {
+	imagepath, _, err := parseImgPath(imgAndPath)
+	if err != nil {
+		return nil, err
+	}
+	return lockImage(c, imagepath)
+}
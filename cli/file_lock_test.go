@@ -0,0 +1,127 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifact.mender")
+	require.NoError(t, os.WriteFile(path, []byte("artifact"), 0644))
+
+	l, err := lockFile(path, time.Second)
+	require.NoError(t, err)
+
+	_, err = lockFile(path, 200*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out")
+
+	require.NoError(t, l.Unlock())
+	l, err = lockFile(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+
+	_, err = lockFile(filepath.Join(t.TempDir(), "missing"), 0)
+	assert.Error(t, err)
+}
+
+func TestLockFileReplaced(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "artifact.mender")
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0644))
+
+	l, err := lockFile(path, time.Second)
+	require.NoError(t, err)
+
+	locked := make(chan *fileLock)
+	go func() {
+		l, err := lockFile(path, 5*time.Second)
+		assert.NoError(t, err)
+		locked <- l
+	}()
+
+	// Replace the file the way modify and sign do, and only then release
+	// the lock on the old one.
+	time.Sleep(200 * time.Millisecond)
+	tmp := filepath.Join(dir, "tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("new"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	require.NoError(t, l.Unlock())
+
+	l = <-locked
+	require.NotNil(t, l)
+	defer l.Unlock()
+	held, err := l.f.Stat()
+	require.NoError(t, err)
+	current, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.True(t, os.SameFile(held, current))
+}
+
+func TestModifyLocked(t *testing.T) {
+	modifyFlagsTested.addFlags([]string{"no-lock", "lock-timeout"})
+
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+
+	l, err := lockFile(art, time.Second)
+	require.NoError(t, err)
+	defer l.Unlock()
+
+	err = Run([]string{"mender-artifact", "modify", "--lock-timeout", "100ms",
+		"-n", "release-2", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--no-lock")
+
+	err = Run([]string{"mender-artifact", "modify", "--no-lock",
+		"-n", "release-2", art})
+	require.NoError(t, err)
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", art})
+	require.NoError(t, err)
+	assert.Contains(t, data, "Name: release-2\n")
+}
+
+func TestCopyLocked(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+	host := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(host, []byte("data"), 0644))
+
+	l, err := lockFile(art, time.Second)
+	require.NoError(t, err)
+	defer l.Unlock()
+
+	for _, args := range [][]string{
+		{"cp", host, art + ":/etc/file"},
+		{"install", "-m", "0644", host, art + ":/etc/file"},
+		{"rm", art + ":/etc/file"},
+	} {
+		args = append([]string{"mender-artifact", args[0], "--lock-timeout", "0s"},
+			args[1:]...)
+		err = Run(args)
+		require.Error(t, err, args)
+		assert.Contains(t, err.Error(), "timed out", args)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+var zipMagic = []byte("PK\x03\x04")
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// filesFromArchive returns the regular files in the tar or zip archive at
+// path as payload data files, which keep their base names in the Artifact.
+// The files are streamed from the archive when the Artifact is written, in
+// the order they are returned, and the archive is closed by the returned
+// closer. Tar archives may be compressed with any registered compressor.
+func filesFromArchive(path string) ([]*handlers.DataFile, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can not open the archive")
+	}
+	magic := make([]byte, artifact.CompressorMagicSize)
+	n, err := io.ReadFull(f, magic)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, nil, errors.Wrapf(err, "can not read the archive %s", path)
+	}
+	if bytes.HasPrefix(magic[:n], zipMagic) {
+		return filesFromZip(path)
+	}
+	return filesFromTar(path)
+}
+
+func filesFromZip(path string) ([]*handlers.DataFile, io.Closer, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can not read the zip archive %s", path)
+	}
+	var files []*handlers.DataFile
+	for _, entry := range zr.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, &handlers.DataFile{
+			Name:   entry.Name,
+			Size:   int64(entry.UncompressedSize64),
+			Date:   entry.Modified,
+			Reader: &zipEntryReader{entry: entry},
+		})
+	}
+	return files, closerFunc(func() error {
+		return zr.Close()
+	}), nil
+}
+
+// zipEntryReader opens its entry of a zip archive when it is first read.
+type zipEntryReader struct {
+	entry *zip.File
+	r     io.ReadCloser
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	if z.r == nil {
+		r, err := z.entry.Open()
+		if err != nil {
+			return 0, err
+		}
+		z.r = r
+	}
+	n, err := z.r.Read(p)
+	if err == io.EOF {
+		z.r.Close()
+	}
+	return n, err
+}
+
+// tarStream is a tar archive, whose entries are read one after the other.
+type tarStream struct {
+	f    *os.File
+	tr   *tar.Reader
+	next int
+}
+
+func openTarStream(path string) (*tarStream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not open the archive")
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(artifact.CompressorMagicSize)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, errors.Wrapf(err, "can not read the archive %s", path)
+	}
+	r, err := artifact.DetectCompressor(magic).NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "can not decompress the archive %s", path)
+	}
+	return &tarStream{f: f, tr: tar.NewReader(r)}, nil
+}
+
+// seek advances the stream to the entry with the given index.
+func (s *tarStream) seek(index int) error {
+	if index < s.next-1 {
+		return errors.New("the files of a tar archive must be read in order")
+	}
+	for s.next <= index {
+		if _, err := s.tr.Next(); err != nil {
+			return errors.Wrap(err, "can not read the tar archive")
+		}
+		s.next++
+	}
+	return nil
+}
+
+func filesFromTar(path string) ([]*handlers.DataFile, io.Closer, error) {
+	// The names of the files are needed for the headers, which are written
+	// before the data, so the archive is listed before it is streamed.
+	list, err := openTarStream(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer list.f.Close()
+
+	stream, err := openTarStream(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*handlers.DataFile
+	for index := 0; ; index++ {
+		hdr, err := list.tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			stream.f.Close()
+			return nil, nil, errors.Wrapf(err, "can not read the tar archive %s", path)
+		}
+		if !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		files = append(files, &handlers.DataFile{
+			Name:   hdr.Name,
+			Size:   hdr.Size,
+			Date:   hdr.ModTime,
+			Reader: &tarEntryReader{stream: stream, index: index},
+		})
+	}
+	return files, stream.f, nil
+}
+
+// tarEntryReader reads its entry of a tar stream.
+type tarEntryReader struct {
+	stream *tarStream
+	index  int
+}
+
+func (t *tarEntryReader) Read(p []byte) (int, error) {
+	if err := t.stream.seek(t.index); err != nil {
+		return 0, err
+	}
+	return t.stream.tr.Read(p)
+}
+
+// filesFromDir returns the regular files in the directory tree at dir as
+// payload data files, along with their paths relative to dir.
+func filesFromDir(dir string) ([]*handlers.DataFile, []string, error) {
+	var files []*handlers.DataFile
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		} else if !info.Mode().IsRegular() {
+			warnf(WarningFileSkipped, "Skipping %s, which is not a regular file", path)
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &handlers.DataFile{Name: path})
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can not read the payload directory")
+	}
+	if len(files) == 0 {
+		return nil, nil, errors.Errorf("no files in the payload directory %s", dir)
+	}
+	return files, paths, nil
+}
+
+// nameDirFiles gives the files from a directory tree valid names in the
+// payload, which are unique among them and the files preceding them. Their
+// paths are kept in the meta-data, so they need not keep their names.
+func nameDirFiles(preceding []string, dirFiles []*handlers.DataFile) {
+	names := make([]string, 0, len(preceding)+len(dirFiles))
+	for _, name := range preceding {
+		names = append(names, filepath.Base(name))
+	}
+	for _, file := range dirFiles {
+		names = append(names, filepath.Base(file.Name))
+	}
+	sanitized := artifact.SanitizePayloadFileNames(names)
+	for i, file := range dirFiles {
+		if name := sanitized[len(preceding)+i]; name != filepath.Base(file.Name) {
+			file.PayloadName = name
+		}
+	}
+}
+
+// withFilePaths records the paths of the files from a directory tree in the
+// payload meta-data.
+func withFilePaths(
+	metaData map[string]interface{},
+	dirFiles []*handlers.DataFile,
+	paths []string,
+) (map[string]interface{}, error) {
+	if len(dirFiles) == 0 {
+		return metaData, nil
+	}
+	if metaData == nil {
+		metaData = make(map[string]interface{})
+	}
+	if _, ok := metaData[artifact.FilePathsMetaDataKey]; ok {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("The meta-data key %q is reserved for the paths of files from --dir",
+				artifact.FilePathsMetaDataKey),
+			errArtifactInvalidParameters)
+	}
+	filePaths := make(map[string]interface{}, len(dirFiles))
+	for i, file := range dirFiles {
+		filePaths[file.GetPayloadName()] = paths[i]
+	}
+	metaData[artifact.FilePathsMetaDataKey] = filePaths
+	return metaData, nil
+}
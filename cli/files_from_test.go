@@ -0,0 +1,177 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+var filesFromContent = map[string]string{
+	"first":         "first file",
+	"dir/second.sh": "#!/bin/sh\necho second\n",
+}
+
+func writeTestTarGz(t *testing.T, path string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "dir/",
+		Mode:     0755,
+	}))
+	for _, name := range []string{"first", "dir/second.sh"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(filesFromContent[name])),
+		}))
+		_, err = tw.Write([]byte(filesFromContent[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func writeTestZip(t *testing.T, path string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	_, err = zw.Create("dir/")
+	require.NoError(t, err)
+	for _, name := range []string{"first", "dir/second.sh"} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(filesFromContent[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+}
+
+func TestWriteFilesFrom(t *testing.T) {
+	for name, writeArchive := range map[string]func(*testing.T, string){
+		"bundle.tar.gz": writeTestTarGz,
+		"bundle.zip":    writeTestZip,
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpdir := t.TempDir()
+			archive := filepath.Join(tmpdir, name)
+			writeArchive(t, archive)
+			artfile := filepath.Join(tmpdir, "artifact.mender")
+
+			err := Run([]string{"mender-artifact", "write", "module-image",
+				"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+				"--files-from", archive})
+			require.NoError(t, err)
+
+			f, err := os.Open(artfile)
+			require.NoError(t, err)
+			defer f.Close()
+			ar := areader.NewReader(f)
+			require.NoError(t, ar.ReadArtifact())
+			files := ar.GetHandlers()[0].GetUpdateFiles()
+			require.Len(t, files, 2)
+			for i, path := range []string{"first", "dir/second.sh"} {
+				sum := sha256.Sum256([]byte(filesFromContent[path]))
+				assert.Equal(t, filepath.Base(path), files[i].Name)
+				assert.Equal(t, hex.EncodeToString(sum[:]), string(files[i].Checksum))
+			}
+		})
+	}
+}
+
+func TestWriteFilesFromErrors(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"--files-from", filepath.Join(tmpdir, "missing.tar")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not open the archive")
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+
+	notArchive := filepath.Join(tmpdir, "not-an-archive")
+	require.NoError(t, os.WriteFile(notArchive, []byte("no archive here"), 0644))
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"--files-from", notArchive})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not read the tar archive")
+}
+
+func TestWriteDir(t *testing.T) {
+	tmpdir := t.TempDir()
+	dir := filepath.Join(tmpdir, "tree")
+	for path, content := range map[string]string{
+		"etc/app/config": "app config",
+		"usr/config":     "usr config",
+		"usr/my file":    "my file",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, path), []byte(content), 0644))
+	}
+	require.NoError(t, os.Symlink("config", filepath.Join(dir, "usr", "link")))
+	config := filepath.Join(tmpdir, "config")
+	require.NoError(t, os.WriteFile(config, []byte("given with -f"), 0644))
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"-f", config, "--dir", dir}))
+	assert.Contains(t, warningClasses(Warnings()), WarningFileSkipped)
+
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	handler := ar.GetHandlers()[0]
+	var names []string
+	for _, file := range handler.GetUpdateFiles() {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"config", "config-2", "config-3", "my_file"}, names)
+	metaData, err := handler.GetUpdateMetaData()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"config-2": "etc/app/config",
+		"config-3": "usr/config",
+		"my_file":  "usr/my file",
+	}, metaData[artifact.FilePathsMetaDataKey])
+
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"--dir", t.TempDir()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no files in the payload directory")
+}
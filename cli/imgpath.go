@@ -0,0 +1,167 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// Image types an image path can resolve to.
+const (
+	ImageTypeArtifact = "artifact"
+	ImageTypeSdimg    = "sdimg"
+	ImageTypeRaw      = "raw"
+)
+
+// Partition names, in the order they appear on an sdimg.
+const (
+	PartitionBoot    = "boot"
+	PartitionRootfsA = "rootfsa"
+	PartitionRootfsB = "rootfsb"
+	PartitionData    = "data"
+)
+
+var sdimgPartitionNames = []string{
+	PartitionBoot,
+	PartitionRootfsA,
+	PartitionRootfsB,
+	PartitionData,
+}
+
+var bootPathRegexp = regexp.MustCompile("/(uboot|boot/(efi|grub))[/]")
+
+// ImagePath describes how an <image>:<path> argument, as accepted by the
+// cat, cp, install and rm commands, is interpreted.
+type ImagePath struct {
+	// Image is the path to the image on the host.
+	Image string
+	// Path is the path inside the image, as given by the user.
+	Path string
+	// ImageType is one of ImageTypeArtifact, ImageTypeSdimg or ImageTypeRaw.
+	ImageType string
+	// Partitions lists the partitions the path resolves to. For sdimg
+	// files inside the rootfs resolve to both rootfs partitions.
+	Partitions []string
+	// PartitionPath is the path inside the partition filesystem(s).
+	PartitionPath string
+}
+
+// sdimgTargets returns the indexes of the sdimg partitions fpath refers to,
+// together with the path inside those partitions.
+func sdimgTargets(fpath string) ([]int, string) {
+	if strings.HasPrefix(fpath, "/data") {
+		// The data dir is not a directory in the data partition
+		return []int{3}, strings.TrimPrefix(fpath, "/data/")
+	} else if bootPathRegexp.MatchString(fpath) {
+		// /uboot, /boot/efi, /boot/grub are not directories on the boot partition.
+		return []int{0}, bootPathRegexp.ReplaceAllString(fpath, "")
+	}
+	return []int{1, 2}, fpath
+}
+
+// detectImageType returns the image type of imgname. Like vImage.Open and
+// processSdimg, which the image modification commands use, it tells valid
+// artifacts by their headers, and other images by their partition table.
+func detectImageType(imgname string) (string, error) {
+	f, err := os.Open(imgname)
+	if err != nil {
+		return "", errors.Wrap(err, "can not open image")
+	}
+	defer f.Close()
+	ar := areader.NewReader(f)
+	err = ar.ReadArtifactHeaders()
+	ar.Close()
+	if err == nil {
+		return ImageTypeArtifact, nil
+	}
+
+	partitions, err := readPartitionTable(imgname)
+	if err == errNoPartitionTable && isFilesystemImage(imgname) {
+		return ImageTypeRaw, nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "invalid partition table or image is broken")
+	}
+	if _, err = assignPartitionRoles(partitions); err != nil {
+		return "", errors.Wrap(err, "invalid partition table")
+	}
+	return ImageTypeSdimg, nil
+}
+
+// ParseImagePath parses and resolves imgpath the same way the image
+// modification commands do, so that wrapper tools can resolve paths
+// identically. The image must exist, since its type is read from it.
+func ParseImagePath(imgpath string) (*ImagePath, error) {
+	imgname, fpath, err := parseImgPath(imgpath)
+	if err != nil {
+		return nil, err
+	}
+	imageType, err := detectImageType(imgname)
+	if err != nil {
+		return nil, errors.Wrap(err, imgname)
+	}
+	ip := &ImagePath{
+		Image:     imgname,
+		Path:      fpath,
+		ImageType: imageType,
+	}
+
+	switch ip.ImageType {
+	case ImageTypeArtifact:
+		if bootPathRegexp.MatchString(fpath) || strings.HasPrefix(fpath, "/data") {
+			return nil, errors.Errorf(
+				"%s: A mender artifact does not contain a boot or data partition,"+
+					" only a rootfs", imgpath)
+		}
+		ip.Partitions = []string{"rootfs"}
+		ip.PartitionPath = fpath
+	case ImageTypeRaw:
+		ip.Partitions = []string{"filesystem"}
+		ip.PartitionPath = fpath
+	default:
+		indexes, pfpath := sdimgTargets(fpath)
+		for _, i := range indexes {
+			ip.Partitions = append(ip.Partitions, sdimgPartitionNames[i])
+		}
+		ip.PartitionPath = pfpath
+	}
+	return ip, nil
+}
+
+func explainPath(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError(
+			fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+	ip, err := ParseImagePath(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	fmt.Printf("Image: %s\n", ip.Image)
+	fmt.Printf("Image type: %s\n", ip.ImageType)
+	fmt.Printf("Path: %s\n", ip.Path)
+	fmt.Printf("Partitions: %s\n", strings.Join(ip.Partitions, ", "))
+	fmt.Printf("Path in partition: %s\n", ip.PartitionPath)
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImagePath(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-imgpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	require.NoError(t, WriteArtifact(tmpdir, 3, ""))
+	art := filepath.Join(tmpdir, "artifact.mender")
+	sdimg := filepath.Join(tmpdir, "foo.sdimg")
+	require.NoError(t, copyFile("mender_test.sdimg", sdimg))
+	raw := filepath.Join(tmpdir, "rootfs.img")
+	require.NoError(t, copyFile("mender_test.img", raw))
+	// The type is told by the contents, not the extension.
+	misnamedRaw := filepath.Join(tmpdir, "rootfs.sdimg")
+	require.NoError(t, copyFile("mender_test.img", misnamedRaw))
+	misnamedSdimg := filepath.Join(tmpdir, "disk.img")
+	require.NoError(t, copyFile("mender_test.sdimg", misnamedSdimg))
+
+	tc := map[string]struct {
+		imgpath string
+		result  *ImagePath
+		err     string
+	}{
+		"artifact rootfs": {
+			imgpath: art + ":/etc/mender/mender.conf",
+			result: &ImagePath{
+				Image:         art,
+				Path:          "/etc/mender/mender.conf",
+				ImageType:     ImageTypeArtifact,
+				Partitions:    []string{"rootfs"},
+				PartitionPath: "/etc/mender/mender.conf",
+			},
+		},
+		"artifact data": {
+			imgpath: art + ":/data/foo",
+			err:     "does not contain a boot or data partition",
+		},
+		"sdimg rootfs": {
+			imgpath: sdimg + ":/etc/hosts",
+			result: &ImagePath{
+				Image:         sdimg,
+				Path:          "/etc/hosts",
+				ImageType:     ImageTypeSdimg,
+				Partitions:    []string{PartitionRootfsA, PartitionRootfsB},
+				PartitionPath: "/etc/hosts",
+			},
+		},
+		"sdimg data": {
+			imgpath: misnamedSdimg + ":/data/mender/device_type",
+			result: &ImagePath{
+				Image:         misnamedSdimg,
+				Path:          "/data/mender/device_type",
+				ImageType:     ImageTypeSdimg,
+				Partitions:    []string{PartitionData},
+				PartitionPath: "mender/device_type",
+			},
+		},
+		"sdimg boot": {
+			imgpath: sdimg + ":/uboot/uboot.env",
+			result: &ImagePath{
+				Image:         sdimg,
+				Path:          "/uboot/uboot.env",
+				ImageType:     ImageTypeSdimg,
+				Partitions:    []string{PartitionBoot},
+				PartitionPath: "uboot.env",
+			},
+		},
+		"raw image": {
+			imgpath: raw + ":/etc/hosts",
+			result: &ImagePath{
+				Image:         raw,
+				Path:          "/etc/hosts",
+				ImageType:     ImageTypeRaw,
+				Partitions:    []string{"filesystem"},
+				PartitionPath: "/etc/hosts",
+			},
+		},
+		"misnamed raw image": {
+			imgpath: misnamedRaw + ":/etc/hosts",
+			result: &ImagePath{
+				Image:         misnamedRaw,
+				Path:          "/etc/hosts",
+				ImageType:     ImageTypeRaw,
+				Partitions:    []string{"filesystem"},
+				PartitionPath: "/etc/hosts",
+			},
+		},
+		"missing image": {
+			imgpath: filepath.Join(tmpdir, "missing.sdimg") + ":/etc/hosts",
+			err:     "can not open image",
+		},
+		"not an image": {
+			imgpath: "test.txt:/etc/hosts",
+			err:     "invalid partition table or image is broken",
+		},
+		"no path": {
+			imgpath: "foo.sdimg:",
+			err:     "please enter a path into the image",
+		},
+		"no separator": {
+			imgpath: "foo.sdimg",
+			err:     "failed to parse image path",
+		},
+	}
+
+	for name, test := range tc {
+		t.Run(name, func(t *testing.T) {
+			ip, err := ParseImagePath(test.imgpath)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.result, ip)
+		})
+	}
+}
+
+func TestExplainPath(t *testing.T) {
+	sdimg := filepath.Join(t.TempDir(), "foo.sdimg")
+	require.NoError(t, copyFile("mender_test.sdimg", sdimg))
+	out, err := runAndCollectStdout([]string{"mender-artifact", "explain-path",
+		sdimg + ":/data/mender/device_type"})
+	require.NoError(t, err)
+	assert.Equal(t, "Image: "+sdimg+"\n"+
+		"Image type: sdimg\n"+
+		"Path: /data/mender/device_type\n"+
+		"Partitions: data\n"+
+		"Path in partition: mender/device_type", out)
+
+	err = Run([]string{"mender-artifact", "explain-path"})
+	assert.Error(t, err)
+}
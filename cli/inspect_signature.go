@@ -0,0 +1,134 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// Exit codes of inspect-signature. Only a signature verified with the given
+// key or certificate exits with zero; all other outcomes, including invalid
+// parameters, exit with non-zero codes so that pipelines can rely on it.
+const (
+	exitSignatureUnsigned = 10 + iota
+	exitSignatureUnknownKey
+	exitSignatureExpiredCert
+	exitSignatureInvalidParameters
+)
+
+// certificateVerifier returns a verifier for the public key of the PEM
+// encoded X.509 certificate in the file called name.
+func certificateVerifier(name string) (SigningKey, *x509.Certificate, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Error reading certificate file")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, errors.Errorf("no PEM encoded certificate in %s", name)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse certificate")
+	}
+	pub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode certificate public key")
+	}
+	key, err := artifact.NewPKIVerifier(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pub,
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, cert, nil
+}
+
+func inspectSignature(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Exactly one Artifact must be given",
+			exitSignatureInvalidParameters)
+	}
+
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitSignatureInvalidParameters)
+	}
+	var cert *x509.Certificate
+	if c.String("certificate") != "" {
+		if key != nil {
+			return cli.NewExitError("Only one of a key and a certificate can be given",
+				exitSignatureInvalidParameters)
+		}
+		if key, cert, err = certificateVerifier(c.String("certificate")); err != nil {
+			return cli.NewExitError(err.Error(), exitSignatureInvalidParameters)
+		}
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.NewExitError("Can not open artifact: "+err.Error(), errArtifactOpen)
+	}
+	defer f.Close()
+
+	var verifyErr error
+	verified := false
+	ar := areader.NewReader(f)
+	ar.ReadBufferSize = c.Int("read-buffer-size")
+	ar.ReadAheadBuffers = c.Int("read-ahead")
+	ar.VerifySignatureCallback = func(message, sig []byte) error {
+		if key != nil && !verified {
+			verifyErr = key.Verify(message, sig)
+			verified = verifyErr == nil
+		}
+		return nil
+	}
+	if err = ar.ReadArtifact(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+
+	switch {
+	case !ar.IsSigned:
+		return cli.NewExitError("unsigned: the Artifact is not signed",
+			exitSignatureUnsigned)
+	case key == nil:
+		return cli.NewExitError("signed-unknown-key: no key given to verify the signature",
+			exitSignatureUnknownKey)
+	case verifyErr != nil:
+		return cli.NewExitError("signed-unknown-key: the signature does not match the given key: "+
+			verifyErr.Error(), exitSignatureUnknownKey)
+	}
+	if cert != nil {
+		if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return cli.NewExitError(fmt.Sprintf("signed-expired-cert: the certificate is"+
+				" valid from %s to %s", cert.NotBefore.UTC().Format(time.RFC3339),
+				cert.NotAfter.UTC().Format(time.RFC3339)), exitSignatureExpiredCert)
+		}
+	}
+	fmt.Println("signed-valid: the signature is verified")
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+func writeTestCertificate(t *testing.T, name string, notAfter time.Time) {
+	block, _ := pem.Decode([]byte(PrivateECDSAKey))
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signing"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(name,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+}
+
+func inspectSignatureExitCode(t *testing.T, args ...string) int {
+	err := Run(append([]string{"mender-artifact", "inspect-signature"}, args...))
+	if err == nil {
+		return 0
+	}
+	exitErr, ok := err.(cli.ExitCoder)
+	require.True(t, ok, err.Error())
+	return exitErr.ExitCode()
+}
+
+func TestInspectSignature(t *testing.T) {
+	tmpdir := t.TempDir()
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	privKey := filepath.Join(tmpdir, "ecdsa.key")
+	require.NoError(t, ioutil.WriteFile(privKey, []byte(PrivateECDSAKey), 0600))
+	pubKey := filepath.Join(tmpdir, "ecdsa.pub")
+	require.NoError(t, ioutil.WriteFile(pubKey, []byte(PublicECDSAKey), 0644))
+	otherKey := filepath.Join(tmpdir, "other.pub")
+	require.NoError(t, ioutil.WriteFile(otherKey, []byte(testPublicKey), 0644))
+	validCert := filepath.Join(tmpdir, "valid.crt")
+	writeTestCertificate(t, validCert, time.Now().Add(24*time.Hour))
+	expiredCert := filepath.Join(tmpdir, "expired.crt")
+	writeTestCertificate(t, expiredCert, time.Now().Add(-24*time.Hour))
+
+	unsigned := filepath.Join(tmpdir, "unsigned.mender")
+	require.NoError(t, Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", unsigned}))
+	signed := filepath.Join(tmpdir, "signed.mender")
+	require.NoError(t, Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", signed, "-k", privKey}))
+
+	assert.Equal(t, exitSignatureUnsigned, inspectSignatureExitCode(t, unsigned))
+	assert.Equal(t, exitSignatureUnsigned, inspectSignatureExitCode(t, unsigned, "-k", pubKey))
+	assert.Equal(t, exitSignatureUnknownKey, inspectSignatureExitCode(t, signed))
+	assert.Equal(t, exitSignatureUnknownKey, inspectSignatureExitCode(t, signed, "-k", otherKey))
+	assert.Equal(t, 0, inspectSignatureExitCode(t, signed, "-k", pubKey))
+	assert.Equal(t, 0, inspectSignatureExitCode(t, signed, "-c", validCert))
+	assert.Equal(t, exitSignatureExpiredCert, inspectSignatureExitCode(t, signed, "-c", expiredCert))
+
+	assert.Equal(t, exitSignatureInvalidParameters, inspectSignatureExitCode(t))
+	assert.Equal(t, exitSignatureInvalidParameters,
+		inspectSignatureExitCode(t, signed, "-k", pubKey, "-c", validCert))
+	assert.Equal(t, exitSignatureInvalidParameters,
+		inspectSignatureExitCode(t, signed, "-c", pubKey))
+	assert.Equal(t, errArtifactOpen,
+		inspectSignatureExitCode(t, filepath.Join(tmpdir, "missing.mender")))
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const ioprioWhoProcess = 1
+
+// getIOPriority returns the I/O priority of the calling thread.
+func getIOPriority() (int, error) {
+	prio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(prio), nil
+}
+
+// setIOPriority sets the I/O priority of the process, for the disk I/O it
+// does itself, such as reading and writing ext4 images. Linux keeps the
+// priority per thread, so it is set for each of them until no new thread
+// shows up; threads started later inherit it, as do subprocesses.
+func setIOPriority(prio int) error {
+	done := make(map[int]bool)
+	for {
+		tasks, err := ioutil.ReadDir("/proc/self/task")
+		if err != nil {
+			return err
+		}
+		changed := false
+		for _, task := range tasks {
+			tid, err := strconv.Atoi(task.Name())
+			if err != nil || done[tid] {
+				continue
+			}
+			_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess,
+				uintptr(tid), uintptr(prio))
+			if errno != 0 && errno != unix.ESRCH {
+				return errno
+			}
+			done[tid] = true
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+	}
+}
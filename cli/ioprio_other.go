@@ -0,0 +1,28 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package cli
+
+// getIOPriority and setIOPriority do nothing where the I/O priority of the
+// process is only set through the ionice binary.
+func getIOPriority() (int, error) {
+	return 0, nil
+}
+
+func setIOPriority(prio int) error {
+	return nil
+}
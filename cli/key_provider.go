@@ -0,0 +1,156 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/artifact/gcp"
+	"github.com/mendersoftware/mender-artifact/artifact/keyfactor"
+	"github.com/mendersoftware/mender-artifact/artifact/sigstore"
+	"github.com/mendersoftware/mender-artifact/artifact/vault"
+)
+
+// KeyUsage tells whether a command signs, or verifies signatures.
+type KeyUsage int
+
+const (
+	KeyUsageSign KeyUsage = iota
+	KeyUsageVerify
+)
+
+// KeyProvider gives the signing keys of one key backend, such as key files
+// or a key management service.
+type KeyProvider interface {
+	// Key returns the key identified by argument, which is what follows the
+	// provider name in --key-provider <name>:<argument>.
+	Key(argument string, usage KeyUsage) (SigningKey, error)
+}
+
+// KeyProviderFunc lets ordinary functions be used as KeyProviders.
+type KeyProviderFunc func(argument string, usage KeyUsage) (SigningKey, error)
+
+func (f KeyProviderFunc) Key(argument string, usage KeyUsage) (SigningKey, error) {
+	return f(argument, usage)
+}
+
+var keyProviders = make(map[string]KeyProvider)
+
+// RegisterKeyProvider makes provider available to all commands taking
+// signing keys, as --key-provider <name>:<argument>.
+func RegisterKeyProvider(name string, provider KeyProvider) {
+	keyProviders[name] = provider
+}
+
+// GetRegisteredKeyProviders returns the names of the key providers, sorted.
+func GetRegisteredKeyProviders() []string {
+	names := make([]string, 0, len(keyProviders))
+	for name := range keyProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyProviderFlags maps the flags of the individual key backends to the
+// providers handling them.
+var keyProviderFlags = []struct {
+	flag     string
+	provider string
+}{
+	{"key", "file"},
+	{"gcp-kms-key", "gcp-kms"},
+	{"vault-transit-key", "vault"},
+	{"key-pkcs11", "pkcs11"},
+	{"keyfactor-signserver-worker", "keyfactor-signserver"},
+	{"sigstore", "sigstore"},
+}
+
+func init() {
+	RegisterKeyProvider("file", KeyProviderFunc(fileKey))
+	RegisterKeyProvider("env", KeyProviderFunc(envKey))
+	RegisterKeyProvider("gcp-kms", KeyProviderFunc(
+		func(name string, usage KeyUsage) (SigningKey, error) {
+			return gcp.NewKMSSigner(context.TODO(), name)
+		}))
+	RegisterKeyProvider("vault", KeyProviderFunc(
+		func(keyName string, usage KeyUsage) (SigningKey, error) {
+			return vault.NewVaultSigner(keyName)
+		}))
+	RegisterKeyProvider("pkcs11", KeyProviderFunc(
+		func(pkcsKey string, usage KeyUsage) (SigningKey, error) {
+			return artifact.NewPKCS11Signer(pkcsKey)
+		}))
+	RegisterKeyProvider("keyfactor-signserver", KeyProviderFunc(
+		func(workerName string, usage KeyUsage) (SigningKey, error) {
+			return keyfactor.NewSignServerSigner(workerName)
+		}))
+	RegisterKeyProvider("sigstore", KeyProviderFunc(
+		func(argument string, usage KeyUsage) (SigningKey, error) {
+			if usage == KeyUsageVerify {
+				return sigstore.NewVerifier(argument)
+			}
+			return sigstore.NewSigner(argument)
+		}))
+}
+
+func pemKey(key []byte, usage KeyUsage) (SigningKey, error) {
+	if usage == KeyUsageVerify {
+		return artifact.NewPKIVerifier(key)
+	}
+	return artifact.NewPKISigner(key)
+}
+
+// fileKey reads a PEM encoded key from the file called name, which can be an
+// inherited file descriptor like /dev/fd/3.
+func fileKey(name string, usage KeyUsage) (SigningKey, error) {
+	key, err := readSecretFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error reading key file")
+	}
+	return pemKey(key, usage)
+}
+
+// envKey reads a PEM encoded key from the environment variable called name,
+// so that keys can be given by CI systems without writing them to disk.
+func envKey(name string, usage KeyUsage) (SigningKey, error) {
+	key := os.Getenv(name)
+	if key == "" {
+		return nil, fmt.Errorf("environment variable %q with the key is not set", name)
+	}
+	return pemKey([]byte(key), usage)
+}
+
+// parseKeyProvider splits a --key-provider value into the provider name and
+// its argument, which is everything after the first colon.
+func parseKeyProvider(spec string) (provider, argument string, err error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid key provider %q; use <provider>:<argument>", spec)
+	}
+	provider, argument = parts[0], parts[1]
+	if _, ok := keyProviders[provider]; !ok {
+		return "", "", fmt.Errorf("unknown key provider %q; use one of %s", provider,
+			strings.Join(GetRegisteredKeyProviders(), ", "))
+	}
+	return provider, argument, nil
+}
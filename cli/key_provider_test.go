@@ -0,0 +1,93 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+const PublicECDSAKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE9iC/hyQO1UQfw0fFj1RjEjwOvPIB
+sz6Of3ock/gIwmnhnC/7USo3yOTl4wVLQKA6mFvMV9o8B9yTBNg3mQS0vA==
+-----END PUBLIC KEY-----`
+
+func TestParseKeyProvider(t *testing.T) {
+	provider, argument, err := parseKeyProvider("vault:secret/path")
+	require.NoError(t, err)
+	assert.Equal(t, "vault", provider)
+	assert.Equal(t, "secret/path", argument)
+
+	// Only the first colon separates the provider.
+	provider, argument, err = parseKeyProvider("pkcs11:pkcs11:object=key")
+	require.NoError(t, err)
+	assert.Equal(t, "pkcs11", provider)
+	assert.Equal(t, "pkcs11:object=key", argument)
+
+	_, _, err = parseKeyProvider("vault")
+	assert.Contains(t, err.Error(), "use <provider>:<argument>")
+	_, _, err = parseKeyProvider("file:")
+	assert.Contains(t, err.Error(), "use <provider>:<argument>")
+	_, _, err = parseKeyProvider("bogus:key")
+	assert.Contains(t, err.Error(), "unknown key provider \"bogus\"")
+}
+
+func TestKeyProviders(t *testing.T) {
+	tmpdir := t.TempDir()
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	pubKey := filepath.Join(tmpdir, "ecdsa.pub")
+	require.NoError(t, ioutil.WriteFile(pubKey, []byte(PublicECDSAKey), 0644))
+	art := filepath.Join(tmpdir, "artifact.mender")
+
+	t.Setenv("TEST_SIGNING_KEY", PrivateECDSAKey)
+	err := Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", art,
+		"--key-provider", "env:TEST_SIGNING_KEY"})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "validate", art, "--key-provider", "file:" + pubKey})
+	assert.NoError(t, err)
+
+	// Providers registered by users of the package are available too.
+	var gotUsage KeyUsage = -1
+	RegisterKeyProvider("test", KeyProviderFunc(
+		func(argument string, usage KeyUsage) (SigningKey, error) {
+			gotUsage = usage
+			return artifact.NewPKIVerifier([]byte(argument))
+		}))
+	defer delete(keyProviders, "test")
+	err = Run([]string{"mender-artifact", "validate", art, "--key-provider",
+		"test:" + PublicECDSAKey})
+	assert.NoError(t, err)
+	assert.Equal(t, KeyUsageVerify, gotUsage)
+
+	err = Run([]string{"mender-artifact", "validate", art,
+		"--key-provider", "file:" + pubKey, "-k", pubKey})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many signing keys given: [key key-provider]")
+
+	os.Unsetenv("TEST_SIGNING_KEY")
+	err = Run([]string{"mender-artifact", "sign", art, "--key-provider", "env:TEST_SIGNING_KEY"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "\"TEST_SIGNING_KEY\" with the key is not set")
+}
@@ -0,0 +1,125 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// The limits set with the global --cpu-limit and --io-nice flags, which let
+// Artifact builds share their host with other jobs. They are reset for every
+// run.
+var (
+	// cpuLimit, if positive, is the maximum number of goroutines
+	// compressing or checksumming at once.
+	cpuLimit int
+	// ioniceCommand, if set, starts the command line of the subprocesses
+	// doing most of the disk I/O, such as debugfs, to lower their I/O
+	// priority.
+	ioniceCommand []string
+	// savedIOPriority is the I/O priority of the process before --io-nice
+	// lowered it, which is restored for runs without it; negative if it
+	// was not changed.
+	savedIOPriority = -1
+)
+
+// I/O scheduling classes of the I/O priority of a process.
+const (
+	ioprioClassBestEffort = 2
+	ioprioClassIdle       = 3
+	ioprioClassShift      = 13
+)
+
+// setResourceLimits sets the limits of the run from the values of the global
+// flags.
+func setResourceLimits(cpus int, ioNice string) error {
+	cpuLimit, ioniceCommand = 0, nil
+	if cpus < 0 {
+		return errors.Errorf("invalid --cpu-limit value %d", cpus)
+	}
+	cpuLimit = cpus
+
+	var args []string
+	var prio int
+	switch ioNice {
+	case "":
+		return restoreIOPriority()
+	case "idle":
+		args = []string{"-c", "3"}
+		prio = ioprioClassIdle << ioprioClassShift
+	default:
+		level, err := strconv.Atoi(ioNice)
+		if err != nil || level < 0 || level > 7 {
+			return errors.Errorf(
+				"invalid --io-nice value %q; use idle, or a level from 0 to 7", ioNice)
+		}
+		args = []string{"-c", "2", "-n", ioNice}
+		prio = ioprioClassBestEffort<<ioprioClassShift | level
+	}
+	bin, err := utils.GetBinaryPath("ionice")
+	if err != nil {
+		return errors.New("--io-nice needs the `ionice` binary, which is not found on the" +
+			" system. It can typically be installed through the `util-linux` package.")
+	}
+	ioniceCommand = append([]string{bin}, args...)
+
+	// The process reads and writes images itself as well.
+	if savedIOPriority < 0 {
+		if savedIOPriority, err = getIOPriority(); err != nil {
+			savedIOPriority = -1
+			return errors.Wrap(err, "can not get the I/O priority")
+		}
+	}
+	return errors.Wrap(setIOPriority(prio), "can not set the I/O priority")
+}
+
+// restoreIOPriority restores the I/O priority the process had before
+// --io-nice changed it.
+func restoreIOPriority() error {
+	if savedIOPriority < 0 {
+		return nil
+	}
+	prio := savedIOPriority
+	savedIOPriority = -1
+	return errors.Wrap(setIOPriority(prio), "can not restore the I/O priority")
+}
+
+// limitJobs returns the number of jobs to run at once: jobs, or one per CPU
+// if zero, but no more than cpuLimit.
+func limitJobs(jobs int) int {
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+	if cpuLimit > 0 && jobs > cpuLimit {
+		return cpuLimit
+	}
+	return jobs
+}
+
+// ioCommand returns the command running bin with args, with the I/O
+// priority given with --io-nice.
+func ioCommand(bin string, args ...string) *exec.Cmd {
+	if ioniceCommand == nil {
+		return exec.Command(bin, args...)
+	}
+	full := append(append([]string{}, ioniceCommand[1:]...), bin)
+	return exec.Command(ioniceCommand[0], append(full, args...)...)
+}
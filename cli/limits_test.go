@@ -0,0 +1,102 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimits(t *testing.T) {
+	defer setResourceLimits(0, "")
+
+	require.NoError(t, setResourceLimits(0, ""))
+	assert.Equal(t, runtime.NumCPU(), limitJobs(0))
+	assert.Equal(t, 3, limitJobs(3))
+	assert.Equal(t, []string{"debugfs", "-R", "stat /"},
+		ioCommand("debugfs", "-R", "stat /").Args)
+
+	require.NoError(t, setResourceLimits(2, ""))
+	assert.LessOrEqual(t, limitJobs(0), 2)
+	assert.Equal(t, 1, limitJobs(1))
+	assert.Equal(t, 2, limitJobs(3))
+
+	assert.Error(t, setResourceLimits(-1, ""))
+	for _, value := range []string{"8", "-1", "realtime"} {
+		err := setResourceLimits(0, value)
+		require.Error(t, err, value)
+		assert.Contains(t, err.Error(), "invalid --io-nice value")
+	}
+
+	if _, err := exec.LookPath("ionice"); err != nil {
+		t.Skip("ionice not found")
+	}
+	require.NoError(t, setResourceLimits(0, "idle"))
+	assert.Equal(t, []string{"-c", "3", "debugfs", "-R", "stat /"},
+		ioCommand("debugfs", "-R", "stat /").Args[1:])
+	require.NoError(t, setResourceLimits(0, "7"))
+	cmd := ioCommand("debugfs", "-R", "stat /")
+	assert.Equal(t, "ionice", filepath.Base(cmd.Args[0]))
+	assert.Equal(t, []string{"-c", "2", "-n", "7", "debugfs", "-R", "stat /"},
+		cmd.Args[1:])
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	// The process itself gets the same priority, until a run without
+	// --io-nice restores the original one.
+	require.NoError(t, setResourceLimits(0, ""))
+	original, err := getIOPriority()
+	require.NoError(t, err)
+	require.NoError(t, setResourceLimits(0, "7"))
+	prio, err := getIOPriority()
+	require.NoError(t, err)
+	assert.Equal(t, ioprioClassBestEffort<<ioprioClassShift|7, prio)
+	require.NoError(t, setResourceLimits(0, "idle"))
+	prio, err = getIOPriority()
+	require.NoError(t, err)
+	assert.Equal(t, ioprioClassIdle<<ioprioClassShift, prio)
+	require.NoError(t, setResourceLimits(0, ""))
+	prio, err = getIOPriority()
+	require.NoError(t, err)
+	assert.Equal(t, original, prio)
+}
+
+func TestWriteCPULimit(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	for _, compression := range []string{"gzip", "zstd_better", "zstd_seekable"} {
+		require.NoError(t, Run([]string{"mender-artifact", "--cpu-limit", "1",
+			"--compression", compression, "write", "module-image",
+			"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+			"-f", filepath.Join(tmpdir, "file")}), compression)
+		require.NoError(t, Run([]string{"mender-artifact", "validate", "--no-progress",
+			artfile}),
+			compression)
+	}
+
+	err := Run([]string{"mender-artifact", "--cpu-limit", "-1", "validate", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --cpu-limit value -1")
+	err = Run([]string{"mender-artifact", "--io-nice", "high", "validate", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid --io-nice value "high"`)
+}
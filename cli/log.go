@@ -28,7 +28,7 @@ type simpleFormatter struct {
 }
 
 func (f *simpleFormatter) Format(entry *logrus.Entry) ([]byte, error) {
-	return []byte(fmt.Sprintf("%s: %s\n", entry.Level, entry.Message)), nil
+	return []byte(fmt.Sprintf("%s: %s\n", entry.Level, scrubSecrets(entry.Message))), nil
 }
 
 func init() {
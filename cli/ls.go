@@ -0,0 +1,138 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// List lists the contents of a directory, or a single file, inside an
+// artifact or image.
+func List(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()), 1)
+	}
+	if !isimg.MatchString(c.Args().First()) {
+		return cli.NewExitError("The input image does not seem to be a valid image", 1)
+	}
+
+	privateKey, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError("Unable to load key: "+err.Error(), 1)
+	}
+	imgname, fpath, err := parseImgPath(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	image, err := virtualImage.Open(privateKey, imgname)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	// The image is never marked dirty, so closing it does not repack it.
+	defer image.Close()
+
+	if err = listImagePath(os.Stdout, image, fpath, c.Bool("long"), c.Bool("recursive")); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+// readImageDir returns the entries of the directory dir in the image, sorted
+// by name.
+func readImageDir(image VPImage, dir string) ([]VPDirEntry, error) {
+	d, err := image.OpenDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := d.ReadDir()
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func listImagePath(w io.Writer, image VPImage, fpath string, long, recursive bool) error {
+	st, err := statInImage(image, fpath)
+	if err != nil {
+		return err
+	}
+	if !st.exists {
+		return errors.Errorf("%s: no such file or directory", fpath)
+	}
+	if st.isDir {
+		return listImageDir(w, image, fpath, long, recursive, recursive)
+	}
+
+	// Take the details of a single file from the listing of its directory.
+	entries, err := readImageDir(image, filepath.Dir(fpath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name == filepath.Base(fpath) {
+			entry.Name = fpath
+			printDirEntry(w, entry, long)
+			return nil
+		}
+	}
+	return errors.Errorf("%s: no such file or directory", fpath)
+}
+
+func listImageDir(w io.Writer, image VPImage, dir string, long, recursive, header bool) error {
+	entries, err := readImageDir(image, dir)
+	if err != nil {
+		return err
+	}
+	if header {
+		fmt.Fprintf(w, "%s:\n", dir)
+	}
+	for _, entry := range entries {
+		printDirEntry(w, entry, long)
+	}
+	if !recursive {
+		return nil
+	}
+	for _, entry := range entries {
+		if !entry.Mode.IsDir() {
+			continue
+		}
+		fmt.Fprintln(w)
+		err = listImageDir(w, image, filepath.Join(dir, entry.Name), long, recursive, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printDirEntry(w io.Writer, entry VPDirEntry, long bool) {
+	if !long {
+		fmt.Fprintln(w, entry.Name)
+		return
+	}
+	fmt.Fprintf(w, "%s %10d %s %s\n", entry.Mode, entry.Size,
+		entry.ModTime.Format("2006-01-02 15:04"), entry.Name)
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	artifact, _, _, _, closer := testSetupTeardown(t)
+	defer closer()
+
+	image, err := virtualImage.Open(nil, artifact)
+	require.NoError(t, err)
+	defer image.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, listImagePath(&buf, image, "/etc/mender", false, false))
+	assert.Equal(t, "artifact-verify-key.pem\nartifact_info\nmender.conf\n"+
+		"server.crt\ntenant.conf\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, listImagePath(&buf, image, "/etc/mender/tenant.conf", true, false))
+	assert.Regexp(t,
+		`^-rw------- +6 [0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2} /etc/mender/tenant.conf\n$`,
+		buf.String())
+
+	buf.Reset()
+	require.NoError(t, listImagePath(&buf, image, "/etc", false, true))
+	assert.Contains(t, buf.String(), "/etc:\nmender\n")
+	assert.Contains(t, buf.String(), "\n\n/etc/mender:\nartifact-verify-key.pem\n")
+
+	assert.EqualError(t, listImagePath(&buf, image, "/nonexisting", false, false),
+		"/nonexisting: no such file or directory")
+
+	err = Run([]string{"mender-artifact", "ls", artifact + ":/nonexisting"})
+	assert.EqualError(t, err, "/nonexisting: no such file or directory")
+}
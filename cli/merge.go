@@ -0,0 +1,255 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// sameStrings returns true if a and b hold the same strings, in any order.
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMergeable returns an error if the Artifact-wide headers of ua differ
+// from the ones of first, as the merged Artifact can only have one set.
+func checkMergeable(first, ua *unpackedArtifact) error {
+	if ua.ar.GetInfo().Version != 3 {
+		return errors.Errorf("%s: only version 3 Artifacts can be merged", ua.origPath)
+	}
+	if !sameStrings(first.writeArgs.Devices, ua.writeArgs.Devices) {
+		return errors.Errorf("%s is compatible with the device types %s, not %s",
+			ua.origPath, strings.Join(ua.writeArgs.Devices, ", "),
+			strings.Join(first.writeArgs.Devices, ", "))
+	}
+	if first.writeArgs.Provides.ArtifactGroup != ua.writeArgs.Provides.ArtifactGroup {
+		return errors.Errorf("%s provides the group %q, not %q", ua.origPath,
+			ua.writeArgs.Provides.ArtifactGroup, first.writeArgs.Provides.ArtifactGroup)
+	}
+	if !sameStrings(first.writeArgs.Depends.ArtifactName, ua.writeArgs.Depends.ArtifactName) ||
+		!sameStrings(first.writeArgs.Depends.ArtifactGroup, ua.writeArgs.Depends.ArtifactGroup) {
+		return errors.Errorf("%s has other Artifact name or group depends than %s",
+			ua.origPath, first.origPath)
+	}
+	return nil
+}
+
+// mergeScripts returns the state scripts of all the Artifacts. Scripts with
+// the same name must have the same contents.
+func mergeScripts(unpacked []*unpackedArtifact) (*artifact.Scripts, error) {
+	scr := &artifact.Scripts{}
+	// The checksums of the added scripts, by device type and name.
+	added := map[string]map[string]string{}
+	add := func(ua *unpackedArtifact, deviceType, path string) error {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(path)
+		if added[deviceType] == nil {
+			added[deviceType] = map[string]string{}
+		}
+		if existing, ok := added[deviceType][name]; ok {
+			if existing != sum {
+				return errors.Errorf("The script %s of %s differs from the one of the"+
+					" same name in another Artifact", name, ua.origPath)
+			}
+			return nil
+		}
+		added[deviceType][name] = sum
+		if deviceType == "" {
+			return scr.Add(path)
+		}
+		return scr.AddForDeviceType(deviceType, path)
+	}
+	for _, ua := range unpacked {
+		for _, path := range ua.scripts {
+			if err := add(ua, "", path); err != nil {
+				return nil, err
+			}
+		}
+		for deviceType, paths := range ua.deviceTypeScripts {
+			for _, path := range paths {
+				if err := add(ua, deviceType, path); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return scr, nil
+}
+
+// mergeWriteArgs returns the arguments to write the payloads of all the
+// Artifacts as one Artifact called name.
+func mergeWriteArgs(unpacked []*unpackedArtifact, name string) (*awriter.WriteArtifactArgs, error) {
+	first := unpacked[0]
+	upd := &awriter.Updates{}
+	var headers []awriter.PayloadHeader
+	// The payload which provides and depends on each key.
+	provides := map[string]string{}
+	depends := map[string]string{}
+	immutable := false
+	for _, ua := range unpacked {
+		if err := checkMergeable(first, ua); err != nil {
+			return nil, err
+		}
+		typeInfo := ua.writeArgs.TypeInfoV3
+		for key := range typeInfo.ArtifactProvides {
+			if other, ok := provides[key]; ok {
+				return nil, errors.Errorf("Both %s and %s provide %s", other, ua.origPath, key)
+			}
+			provides[key] = ua.origPath
+		}
+		for key := range typeInfo.ArtifactDepends {
+			if other, ok := depends[key]; ok {
+				return nil, errors.Errorf("Both %s and %s depend on %s", other, ua.origPath, key)
+			}
+			depends[key] = ua.origPath
+		}
+		upd.Updates = append(upd.Updates, ua.writeArgs.Updates.Updates...)
+		headers = append(headers, awriter.PayloadHeader{
+			TypeInfoV3: typeInfo,
+			MetaData:   ua.writeArgs.MetaData,
+		})
+		immutable = immutable || ua.writeArgs.ImmutableMetadata
+	}
+	scr, err := mergeScripts(unpacked)
+	if err != nil {
+		return nil, err
+	}
+
+	args := *first.writeArgs
+	args.Name = name
+	args.Updates = upd
+	args.Scripts = scr
+	args.Provides = &artifact.ArtifactProvides{
+		ArtifactName:  name,
+		ArtifactGroup: first.writeArgs.Provides.ArtifactGroup,
+	}
+	args.TypeInfoV3 = headers[0].TypeInfoV3
+	args.MetaData = headers[0].MetaData
+	args.PayloadHeaders = headers
+	args.ImmutableMetadata = immutable
+	return &args, nil
+}
+
+func mergeArtifacts(c *cli.Context) (err error) {
+	if c.NArg() < 3 {
+		return cli.NewExitError("Please give the merged Artifact, and at least two"+
+			" Artifacts to merge", errArtifactInvalidParameters)
+	}
+	output := c.Args().First()
+	inputs := c.Args().Tail()
+	if len(c.StringSlice("compression-opt")) > 0 && c.String("compression") == "" {
+		// Without --compression the compression of the first Artifact is
+		// kept, and the options would be silently ignored.
+		return cli.NewExitError("--compression-opt requires --compression", 1)
+	}
+
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError("Can not use signing key provided: "+err.Error(), 1)
+	}
+
+	var unpacked []*unpackedArtifact
+	defer func() {
+		for _, ua := range unpacked {
+			utils.RemoveTemp(ua.unpackDir)
+		}
+	}()
+	signed := false
+	for _, input := range inputs {
+		ua, err := unpackArtifact(input)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Can not read %s: %s", input, err),
+				errArtifactOpen)
+		}
+		unpacked = append(unpacked, ua)
+		if ua.writeArgs.ImmutableMetadata && !c.Bool("force-unlock") {
+			return cli.NewExitError("Artifact ["+input+"] has immutable metadata;"+
+				" use --force-unlock to merge it anyway", errArtifactUnsupportedFeature)
+		}
+		signed = signed || ua.ar.IsSigned
+	}
+
+	name := c.String("artifact-name")
+	if name == "" {
+		name = unpacked[0].writeArgs.Name
+	}
+	args, err := mergeWriteArgs(unpacked, name)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	comp := unpacked[0].ar.Compressor()
+	if c.String("compression") != "" {
+		if comp, err = getCompressor(c); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	tmp, err := utils.TempFile(filepath.Dir(output), "merge")
+	if err != nil {
+		return cli.NewExitError("Can not create merged artifact: "+err.Error(),
+			errArtifactCreate)
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	aw := awriter.NewWriter(tmp, comp)
+	if key != nil {
+		aw = awriter.NewWriterSigned(tmp, comp, key)
+	} else if signed {
+		warnf(WarningUnsignedOutput,
+			"Some of the merged Artifacts were signed, but no signing key was given;"+
+				" the result is unsigned")
+	}
+	if err = aw.WriteArtifact(args); err != nil {
+		return cli.NewExitError("Can not write merged artifact: "+err.Error(),
+			errArtifactCreate)
+	}
+	if err = tmp.Close(); err != nil {
+		return cli.NewExitError("Can not store merged artifact: "+err.Error(),
+			errArtifactCreate)
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return cli.NewExitError("Can not store merged artifact: "+err.Error(),
+			errArtifactCreate)
+	}
+	return nil
+}
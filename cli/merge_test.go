@@ -0,0 +1,132 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+func TestMergeArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file1", "payload1")
+	makeFile(t, dir, "file2", "payload2")
+	makeFile(t, dir, "meta.json", `{"key": "value"}`)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "scripts"), 0755))
+	makeFile(t, dir, "scripts/ArtifactInstall_Enter_00", "#!/bin/sh\necho shared\n")
+	makeFile(t, dir, "scripts/ArtifactCommit_Leave_00", "#!/bin/sh\necho second\n")
+	write := func(name, updateType, device, file string, extra ...string) string {
+		out := filepath.Join(dir, name)
+		err := Run(append([]string{"mender-artifact", "write", "module-image",
+			"-t", device, "-n", name, "-T", updateType, "-f", filepath.Join(dir, file),
+			"-o", out}, extra...))
+		require.NoError(t, err)
+		return out
+	}
+	in1 := write("in1.mender", "app1", "my-device", "file1",
+		"-s", filepath.Join(dir, "scripts/ArtifactInstall_Enter_00"),
+		"--meta-data", filepath.Join(dir, "meta.json"))
+	in2 := write("in2.mender", "app2", "my-device", "file2",
+		"-s", filepath.Join(dir, "scripts/ArtifactInstall_Enter_00"),
+		"-s", filepath.Join(dir, "scripts/ArtifactCommit_Leave_00"))
+
+	priv, pub, err := generateKeys()
+	require.NoError(t, err)
+	makeFile(t, dir, "private.key", string(priv))
+	makeFile(t, dir, "public.key", string(pub))
+	out := filepath.Join(dir, "merged.mender")
+	err = Run([]string{"mender-artifact", "merge", "-n", "combined",
+		"-k", filepath.Join(dir, "private.key"), out, in1, in2})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "validate",
+		"-k", filepath.Join(dir, "public.key"), out})
+	require.NoError(t, err)
+
+	f, err := os.Open(out)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	ar.CollectScripts = true
+	require.NoError(t, ar.ReadArtifact())
+	assert.Equal(t, "combined", ar.GetArtifactName())
+	assert.Equal(t, []string{"my-device"}, ar.GetCompatibleDevices())
+	inst := ar.GetHandlers()
+	require.Len(t, inst, 2)
+	assert.Equal(t, "app1", *inst[0].GetUpdateType())
+	assert.Equal(t, "app2", *inst[1].GetUpdateType())
+	require.Len(t, inst[0].GetUpdateFiles(), 1)
+	assert.Equal(t, "file1", filepath.Base(inst[0].GetUpdateFiles()[0].Name))
+	require.Len(t, inst[1].GetUpdateFiles(), 1)
+	assert.Equal(t, "file2", filepath.Base(inst[1].GetUpdateFiles()[0].Name))
+	metaData, err := inst[0].GetUpdateMetaData()
+	require.NoError(t, err)
+	assert.Equal(t, "value", metaData["key"])
+	provides, err := ar.MergeArtifactProvides()
+	require.NoError(t, err)
+	assert.Equal(t, "in1.mender", provides["rootfs-image.app1.version"])
+	assert.Equal(t, "in2.mender", provides["rootfs-image.app2.version"])
+	var scripts []string
+	for _, script := range ar.GetScripts() {
+		scripts = append(scripts, script.Name)
+	}
+	assert.ElementsMatch(t, []string{"ArtifactInstall_Enter_00", "ArtifactCommit_Leave_00"},
+		scripts)
+}
+
+func TestMergeArtifactsConflicts(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	write := func(name string, args ...string) string {
+		out := filepath.Join(dir, name)
+		err := Run(append([]string{"mender-artifact", "write", "module-image",
+			"-n", name, "-f", filepath.Join(dir, "file"), "-o", out}, args...))
+		require.NoError(t, err)
+		return out
+	}
+	base := write("base.mender", "-t", "my-device", "-T", "app")
+	out := filepath.Join(dir, "merged.mender")
+
+	err := Run([]string{"mender-artifact", "merge", out, base})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "at least two Artifacts to merge")
+
+	sameType := write("same-type.mender", "-t", "my-device", "-T", "app")
+	err = Run([]string{"mender-artifact", "merge", out, base, sameType})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "provide rootfs-image.app.version")
+
+	otherDevice := write("other-device.mender", "-t", "other-device", "-T", "other-app")
+	err = Run([]string{"mender-artifact", "merge", out, base, otherDevice})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		"is compatible with the device types other-device, not my-device")
+
+	locked := write("locked.mender", "-t", "my-device", "-T", "other-app",
+		"--immutable-metadata")
+	err = Run([]string{"mender-artifact", "merge", out, base, locked})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use --force-unlock to merge it anyway")
+	err = Run([]string{"mender-artifact", "merge", "--force-unlock", out, base, locked})
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "merged.mender"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+)
+
+// messageCatalogs hold the translations of the labels of the read command,
+// and of the most common messages, keyed by the English messages, which
+// need no catalog. The messages with verbs are formatted with trf.
+var messageCatalogs = map[string]map[string]string{
+	"de": {
+		"Mender Artifact":                  "Mender-Artefakt",
+		"Name":                             "Name",
+		"Format":                           "Format",
+		"Version":                          "Version",
+		"Checksum algorithm":               "Prüfsummenalgorithmus",
+		"Signature":                        "Signatur",
+		"Compatible devices":               "Kompatible Geräte",
+		"Excluded devices":                 "Ausgeschlossene Geräte",
+		"Provides group":                   "Stellt Gruppe bereit",
+		"Depends on one of artifact(s)":    "Hängt von einem der Artefakte ab",
+		"Depends on one of group(s)":       "Hängt von einer der Gruppen ab",
+		"Immutable metadata":               "Unveränderliche Metadaten",
+		"State scripts":                    "Zustandsskripte",
+		"State scripts for device type %s": "Zustandsskripte für Gerätetyp %s",
+		"Updates":                          "Updates",
+		"Type":                             "Typ",
+		"Empty type":                       "Leerer Typ",
+		"Provides":                         "Stellt bereit",
+		"Depends":                          "Hängt ab von",
+		"Clears Provides":                  "Löscht Bereitstellungen",
+		"Install size":                     "Installationsgröße",
+		"Metadata":                         "Metadaten",
+		"Files":                            "Dateien",
+		"Download plan":                    "Downloadplan",
+		"Transfer size":                    "Übertragungsgröße",
+		"Cached size":                      "Zwischengespeicherte Größe",
+		"Invalid provides section: %s":     "Ungültiger Provides-Abschnitt: %s",
+		"Invalid depends section: %s":      "Ungültiger Depends-Abschnitt: %s",
+		"Invalid metadata section: %s":     "Ungültiger Metadaten-Abschnitt: %s",
+		"Valid bootstrap Artifact":         "Gültiges Bootstrap-Artefakt",
+		"Not a valid bootstrap Artifact:":  "Kein gültiges Bootstrap-Artefakt:",
+
+		signatureNone:     "keine Signatur",
+		signatureVerified: "signiert und erfolgreich verifiziert",
+		signatureNoKey: "signiert, aber kein Schlüssel zur Verifizierung angegeben;" +
+			" bitte die Option `-k` verwenden, um einen Verifizierungsschlüssel anzugeben",
+		signatureFailed: "signiert; Verifizierung mit dem angegebenen Schlüssel" +
+			" fehlgeschlagen",
+
+		nothingRead:                 "Nichts angegeben, nichts gelesen. \nMeinten Sie 'artifacts read <pathspec>'?",
+		nothingValidated:            "Nichts angegeben, nichts validiert. \nMeinten Sie 'artifacts validate <pathspec>'?",
+		"Can not open artifact: %s": "Artefakt kann nicht geöffnet werden: %s",
+		"Invalid Artifact. No 'device-type' found.": "Ungültiges Artefakt. Kein 'device-type' gefunden.",
+		"Artifact file '%s' validated successfully": "Artefaktdatei '%s' erfolgreich validiert",
+		"whitespace is not allowed in the artifact-name": "Leerzeichen sind im artifact-name" +
+			" nicht erlaubt",
+		"must provide `device-type`, `artifact-name` and `file`": "`device-type`," +
+			" `artifact-name` und `file` müssen angegeben werden",
+	},
+	"es": {
+		"Mender Artifact":                  "Artefacto Mender",
+		"Name":                             "Nombre",
+		"Format":                           "Formato",
+		"Version":                          "Versión",
+		"Checksum algorithm":               "Algoritmo de suma de comprobación",
+		"Signature":                        "Firma",
+		"Compatible devices":               "Dispositivos compatibles",
+		"Excluded devices":                 "Dispositivos excluidos",
+		"Provides group":                   "Proporciona el grupo",
+		"Depends on one of artifact(s)":    "Depende de uno de los artefactos",
+		"Depends on one of group(s)":       "Depende de uno de los grupos",
+		"Immutable metadata":               "Metadatos inmutables",
+		"State scripts":                    "Scripts de estado",
+		"State scripts for device type %s": "Scripts de estado para el tipo de dispositivo %s",
+		"Updates":                          "Actualizaciones",
+		"Type":                             "Tipo",
+		"Empty type":                       "Tipo vacío",
+		"Provides":                         "Proporciona",
+		"Depends":                          "Depende de",
+		"Clears Provides":                  "Borra lo proporcionado",
+		"Install size":                     "Tamaño de instalación",
+		"Metadata":                         "Metadatos",
+		"Files":                            "Archivos",
+		"Download plan":                    "Plan de descarga",
+		"Transfer size":                    "Tamaño de transferencia",
+		"Cached size":                      "Tamaño en caché",
+		"Invalid provides section: %s":     "Sección provides no válida: %s",
+		"Invalid depends section: %s":      "Sección depends no válida: %s",
+		"Invalid metadata section: %s":     "Sección de metadatos no válida: %s",
+		"Valid bootstrap Artifact":         "Artefacto de arranque válido",
+		"Not a valid bootstrap Artifact:":  "No es un artefacto de arranque válido:",
+
+		signatureNone:     "sin firma",
+		signatureVerified: "firmado y verificado correctamente",
+		signatureNoKey: "firmado, pero no se proporcionó ninguna clave de verificación;" +
+			" use la opción `-k` para proporcionar la clave de verificación",
+		signatureFailed: "firmado; la verificación con la clave proporcionada falló",
+
+		nothingRead:                 "No se especificó nada, no se leyó nada. \n¿Quería decir 'artifacts read <pathspec>'?",
+		nothingValidated:            "No se especificó nada, no se validó nada. \n¿Quería decir 'artifacts validate <pathspec>'?",
+		"Can not open artifact: %s": "No se puede abrir el artefacto: %s",
+		"Invalid Artifact. No 'device-type' found.": "Artefacto no válido. No se encontró 'device-type'.",
+		"Artifact file '%s' validated successfully": "Archivo de artefacto '%s' validado correctamente",
+		"whitespace is not allowed in the artifact-name": "no se permiten espacios en blanco" +
+			" en artifact-name",
+		"must provide `device-type`, `artifact-name` and `file`": "debe proporcionar" +
+			" `device-type`, `artifact-name` y `file`",
+	},
+}
+
+// The messages which are used in several places.
+const (
+	signatureNone     = "no signature"
+	signatureVerified = "signed and verified correctly"
+	signatureNoKey    = "signed but no key for verification provided; " +
+		"please use `-k` option for providing verification key"
+	signatureFailed = "signed; verification using provided key failed"
+
+	nothingRead = "Nothing specified, nothing read. \nMaybe you wanted" +
+		" to say 'artifacts read <pathspec>'?"
+	nothingValidated = "Nothing specified, nothing validated. \nMaybe you wanted" +
+		" to say 'artifacts validate <pathspec>'?"
+)
+
+// language is the language of the messages, set with the global --lang flag
+// for every run. English has no catalog.
+var language string
+
+// languages returns the languages --lang takes, besides auto.
+func languages() []string {
+	langs := []string{"en"}
+	for lang := range messageCatalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// detectLanguage returns the language of the locale of the environment, as
+// gettext picks it, if there is a catalog for it, and English otherwise.
+func detectLanguage() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		locale := os.Getenv(env)
+		if locale == "" {
+			continue
+		}
+		// Such as de_DE.UTF-8 or es_ES@euro.
+		lang := strings.ToLower(strings.FieldsFunc(locale, func(r rune) bool {
+			return r == '_' || r == '.' || r == '@'
+		})[0])
+		if _, ok := messageCatalogs[lang]; ok {
+			return lang
+		}
+		return "en"
+	}
+	return "en"
+}
+
+// setLanguage sets the language of the messages to lang, or the one of the
+// environment with auto. Without lang, the messages are only translated when
+// the output is a terminal, so that the output parsed by scripts does not
+// depend on the locale.
+func setLanguage(lang string) error {
+	language = ""
+	if lang == "" {
+		lang = "en"
+		if isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+			lang = "auto"
+		}
+	}
+	switch lang {
+	case "auto":
+		lang = detectLanguage()
+	case "en":
+	default:
+		if _, ok := messageCatalogs[lang]; !ok {
+			return errors.Errorf("unsupported --lang value %q; use auto, %s",
+				lang, strings.Join(languages(), ", "))
+		}
+	}
+	language = lang
+	return nil
+}
+
+// tr returns message in the language of the run, or as it is if it has no
+// translation.
+func tr(message string) string {
+	if translated, ok := messageCatalogs[language][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// trf formats the translation of format, like fmt.Sprintf.
+func trf(format string, args ...interface{}) string {
+	return fmt.Sprintf(tr(format), args...)
+}
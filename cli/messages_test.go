@@ -0,0 +1,125 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain runs the tests in English, since most of them check the output,
+// whatever the locale of the environment is.
+func TestMain(m *testing.M) {
+	os.Setenv("MENDER_ARTIFACT_LANG", "en")
+	os.Exit(m.Run())
+}
+
+func TestMessageCatalogs(t *testing.T) {
+	verbs := regexp.MustCompile(`%[a-z]`)
+	for lang, catalog := range messageCatalogs {
+		assert.Len(t, catalog, len(messageCatalogs["de"]), lang)
+		for message, translated := range catalog {
+			_, ok := messageCatalogs["de"][message]
+			assert.True(t, ok, "%s: %q is only translated to %s", lang, message, lang)
+			assert.Equal(t, verbs.FindAllString(message, -1),
+				verbs.FindAllString(translated, -1), "%s: %q", lang, message)
+		}
+	}
+}
+
+func TestSetLanguage(t *testing.T) {
+	t.Cleanup(func() { language = "" })
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		t.Setenv(env, "")
+	}
+
+	require.NoError(t, setLanguage("es"))
+	assert.Equal(t, "Firma", tr("Signature"))
+	assert.Equal(t, "No se puede abrir el artefacto: a.mender",
+		trf("Can not open artifact: %s", "a.mender"))
+	// Messages without a translation are kept.
+	assert.Equal(t, "Unknown message", tr("Unknown message"))
+
+	require.NoError(t, setLanguage("en"))
+	assert.Equal(t, "Signature", tr("Signature"))
+
+	tests := []struct {
+		env, value, lang string
+	}{
+		{"LANG", "de_DE.UTF-8", "de"},
+		{"LANG", "es_ES@euro", "es"},
+		{"LANG", "fr_FR.UTF-8", "en"},
+		{"LANG", "C", "en"},
+		{"LC_MESSAGES", "es", "es"},
+		// LC_ALL takes precedence over LANG, as with gettext.
+		{"LC_ALL", "C.UTF-8", "en"},
+	}
+	for _, test := range tests {
+		t.Setenv(test.env, test.value)
+		require.NoError(t, setLanguage("auto"))
+		assert.Equal(t, test.lang, language, "%s=%s", test.env, test.value)
+	}
+
+	// Without --lang, output which is not a terminal is not translated.
+	t.Setenv("LANG", "de_DE.UTF-8")
+	t.Setenv("LC_ALL", "")
+	require.NoError(t, setLanguage(""))
+	assert.Equal(t, "en", language)
+
+	err := setLanguage("fr")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use auto, de, en, es")
+}
+
+func TestReadLang(t *testing.T) {
+	t.Cleanup(func() { language = "" })
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "--lang", "de", "read",
+		"--no-progress", art})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Mender-Artefakt:\n")
+	assert.Contains(t, out, "Signatur: keine Signatur\n")
+	assert.Contains(t, out, "Kompatible Geräte: []\n")
+	assert.Contains(t, out, "Dateien:\n")
+
+	// The JSON output is for programs, and stays the same.
+	out, err = runAndCollectStdout([]string{"mender-artifact", "--lang", "de", "read",
+		"--no-progress", "--field", "signature", art})
+	require.NoError(t, err)
+	assert.Equal(t, "no signature", out)
+
+	err = Run([]string{"mender-artifact", "--lang", "es", "read",
+		filepath.Join(dir, "missing.mender")})
+	require.Error(t, err)
+	assert.Equal(t, "No se puede abrir el artefacto: "+filepath.Join(dir, "missing.mender"),
+		err.Error())
+
+	// Every run sets the language again.
+	out, err = runAndCollectStdout([]string{"mender-artifact", "read", "--no-progress", art})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Mender Artifact:\n")
+
+	err = Run([]string{"mender-artifact", "--lang", "fr", "read", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported --lang value")
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readMetaData reads the payload meta-data from the JSON files and
+// directories at paths, and merges them in order. A file holds a JSON object.
+// A directory holds the meta-data sysfs style: each subdirectory is the
+// object under its name, and each .json file is the value of the key named
+// like the file without the extension. The merged documents may only set the
+// same key to different values if both are objects, which are merged in turn.
+func readMetaData(paths []string) (map[string]interface{}, error) {
+	var metaData map[string]interface{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		var fragment map[string]interface{}
+		if info.IsDir() {
+			fragment, err = readMetaDataDir(path)
+		} else {
+			fragment, err = readMetaDataFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if metaData == nil {
+			metaData = fragment
+		} else if err = mergeMetaData(metaData, fragment, "", path); err != nil {
+			return nil, err
+		}
+	}
+	return metaData, nil
+}
+
+func readMetaDataFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metaData map[string]interface{}
+	if err = json.Unmarshal(data, &metaData); err != nil {
+		return nil, errors.Wrapf(err, "can not parse %s", path)
+	}
+	return metaData, nil
+}
+
+func readMetaDataDir(dir string) (map[string]interface{}, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Read "key.json" before "key/", whatever the collation.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return !entries[i].IsDir() && entries[j].IsDir()
+	})
+
+	metaData := map[string]interface{}{}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		var key string
+		var value interface{}
+		if entry.IsDir() {
+			key = name
+			if value, err = readMetaDataDir(path); err != nil {
+				return nil, err
+			}
+		} else if strings.HasSuffix(name, ".json") && entry.Type().IsRegular() {
+			key = strings.TrimSuffix(name, ".json")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err = json.Unmarshal(data, &value); err != nil {
+				return nil, errors.Wrapf(err, "can not parse %s", path)
+			}
+		} else {
+			return nil, errors.Errorf("%s is not a .json file or a directory", path)
+		}
+		if err = mergeMetaData(metaData, map[string]interface{}{key: value}, "", path); err != nil {
+			return nil, err
+		}
+	}
+	return metaData, nil
+}
+
+// mergeMetaData merges src, read from source, into dst. prefix is the key of
+// dst in the whole meta-data, for messages.
+func mergeMetaData(dst, src map[string]interface{}, prefix, source string) error {
+	for key, value := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		existingObject, existingIsObject := existing.(map[string]interface{})
+		object, isObject := value.(map[string]interface{})
+		if existingIsObject && isObject {
+			if err := mergeMetaData(existingObject, object, prefix+key+".", source); err != nil {
+				return err
+			}
+		} else if !reflect.DeepEqual(existing, value) {
+			return errors.Errorf("meta-data key %q from %s conflicts with an earlier value",
+				prefix+key, source)
+		}
+	}
+	return nil
+}
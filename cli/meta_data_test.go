@@ -0,0 +1,134 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeMetaDataFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestReadMetaData(t *testing.T) {
+	dir := t.TempDir()
+	writeMetaDataFiles(t, dir, map[string]string{
+		"base.json":               `{"board": "beaglebone", "net": {"dhcp": true}}`,
+		"build.json":              `{"build": {"id": 42}, "board": "beaglebone"}`,
+		"fragments/version.json":  `"1.2.3"`,
+		"fragments/net.json":      `{"dhcp": true, "mtu": 1500}`,
+		"fragments/net/wifi.json": `{"ssid": "device"}`,
+		"fragments/.hidden":       `not read`,
+	})
+
+	metaData, err := readMetaData([]string{
+		filepath.Join(dir, "base.json"),
+		filepath.Join(dir, "build.json"),
+		filepath.Join(dir, "fragments"),
+	})
+	require.NoError(t, err)
+	expected := `{
+		"board": "beaglebone",
+		"build": {"id": 42},
+		"version": "1.2.3",
+		"net": {"dhcp": true, "mtu": 1500, "wifi": {"ssid": "device"}}
+	}`
+	actual, err := json.Marshal(metaData)
+	require.NoError(t, err)
+	assert.JSONEq(t, expected, string(actual))
+
+	metaData, err = readMetaData(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, metaData)
+}
+
+func TestReadMetaDataErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeMetaDataFiles(t, dir, map[string]string{
+		"a.json":             `{"board": "beaglebone", "net": {"mtu": 1500}}`,
+		"b.json":             `{"board": "raspberrypi"}`,
+		"c.json":             `{"net": {"mtu": 9000}}`,
+		"array.json":         `["not", "an", "object"]`,
+		"conflict/net.json":  `{"mtu": 1500}`,
+		"conflict/net/mtu":   `9000`,
+		"object/net.json":    `"eth0"`,
+		"object/net/x.json":  `1`,
+		"broken/broken.json": `{`,
+	})
+
+	for paths, expected := range map[[2]string]string{
+		{"a.json", "b.json"}:     `meta-data key "board" from`,
+		{"a.json", "c.json"}:     `meta-data key "net.mtu" from`,
+		{"array.json", ""}:       "can not parse",
+		{"conflict", ""}:         "is not a .json file or a directory",
+		{"object", ""}:           `meta-data key "net" from`,
+		{"broken", ""}:           "can not parse",
+		{"does-not-exist", ""}:   "no such file",
+		{"a.json", "broken"}:     "can not parse",
+		{"a.json", "conflict"}:   "is not a .json file or a directory",
+		{"c.json", "conflict/."}: "is not a .json file or a directory",
+	} {
+		var args []string
+		for _, p := range paths {
+			if p != "" {
+				args = append(args, filepath.Join(dir, p))
+			}
+		}
+		_, err := readMetaData(args)
+		require.Error(t, err, paths)
+		assert.Contains(t, err.Error(), expected, paths)
+	}
+}
+
+func TestWriteModuleImageMetaDataFragments(t *testing.T) {
+	dir := t.TempDir()
+	writeMetaDataFiles(t, dir, map[string]string{
+		"base.json":          `{"board": "beaglebone"}`,
+		"fragments/id.json":  `42`,
+		"fragments/net.json": `{"mtu": 1500}`,
+	})
+	art := filepath.Join(dir, "artifact.mender")
+
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"--type", "test-type", "-n", "test-artifact", "-t", "test-device",
+		"-m", filepath.Join(dir, "base.json"), "-m", filepath.Join(dir, "fragments"),
+		"-o", art})
+	require.NoError(t, err)
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--field", "payloads.0.meta_data", "--json", art})
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"payloads.0.meta_data": {"board": "beaglebone", "id": 42, "net": {"mtu": 1500}}}`, out)
+
+	writeMetaDataFiles(t, dir, map[string]string{"other.json": `{"id": 43}`})
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"--type", "test-type", "-n", "test-artifact", "-t", "test-device",
+		"-m", filepath.Join(dir, "fragments"), "-m", filepath.Join(dir, "other.json"),
+		"-o", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `meta-data key "id"`)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+}
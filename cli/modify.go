@@ -20,20 +20,24 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 func modifyArtifact(c *cli.Context) (err error) {
-	comp, err := artifact.NewCompressorFromId(c.GlobalString("compression"))
+	comp, err := getCompressor(c)
 	if err != nil {
-		return cli.NewExitError(
-			"compressor '"+c.GlobalString("compression")+"' is not supported: "+err.Error(),
-			1,
-		)
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(c.StringSlice("compression-opt")) > 0 && c.String("compression") == "" {
+		// Without --compression the existing compression is kept, and
+		// the options would be silently ignored.
+		return cli.NewExitError("--compression-opt requires --compression", 1)
 	}
 
 	privateKey, err := getKey(c)
@@ -50,6 +54,15 @@ func modifyArtifact(c *cli.Context) (err error) {
 		return cli.NewExitError("File ["+c.Args().First()+"] does not exist.", 1)
 	}
 
+	unlock, err := lockImage(c, c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer unlock()
+	if err = backupImage(c, c.Args().First()); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
 	var image VPImage
 	if c.String("compression") != "" {
 		image, err = virtualImage.Open(privateKey, c.Args().First(), comp)
@@ -71,6 +84,12 @@ func modifyArtifact(c *cli.Context) (err error) {
 		}
 	}()
 
+	if art, ok := image.(*ModImageArtifact); ok &&
+		art.writeArgs.ImmutableMetadata && !c.Bool("force-unlock") {
+		return cli.NewExitError("Artifact ["+c.Args().First()+"] has immutable metadata;"+
+			" use --force-unlock to modify it anyway", errArtifactUnsupportedFeature)
+	}
+
 	image.dirtyImage()
 	if err := modifyExisting(c, image); err != nil {
 		return cli.NewExitError("Error modifying artifact["+c.Args().First()+"]: "+
@@ -92,11 +111,11 @@ func modifyArtifactInfoName(name string, image VPImage) error {
 	}
 
 	data := fmt.Sprintf("artifact_name=%s", name)
-	tmpNameFile, err := ioutil.TempFile("", "mender-name")
+	tmpNameFile, err := utils.TempFile("", "name")
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmpNameFile.Name())
+	defer utils.RemoveTemp(tmpNameFile.Name())
 	defer tmpNameFile.Close()
 
 	if _, err = tmpNameFile.WriteString(data); err != nil {
@@ -137,11 +156,11 @@ func modifyVerificationKey(newKey string, image VPImage) error {
 func modifyMenderConfVar(confKey, confValue string, image VPImage) error {
 	confFile := "/etc/mender/mender.conf"
 
-	dir, err := ioutil.TempDir("", "")
+	dir, err := utils.TempDir("", "conf")
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(dir)
+	defer utils.RemoveTemp(dir)
 
 	localFile := filepath.Join(dir, filepath.Base(confFile))
 
@@ -192,6 +211,15 @@ func extractKeyValuesIfArtifact(
 }
 
 func modifyExisting(c *cli.Context, image VPImage) error {
+	if err := checkGroupFlags(c); err != nil {
+		return err
+	}
+	// Read before anything is modified.
+	token, err := tenantToken(c)
+	if err != nil {
+		return err
+	}
+
 	if c.String("server-uri") != "" {
 		if err := modifyMenderConfVar("ServerURL",
 			c.String("server-uri"), image); err != nil {
@@ -211,14 +239,13 @@ func modifyExisting(c *cli.Context, image VPImage) error {
 		}
 	}
 
-	if c.String("tenant-token") != "" {
-		if err := modifyMenderConfVar("TenantToken",
-			c.String("tenant-token"), image); err != nil {
+	if token != "" {
+		if err := modifyMenderConfVar("TenantToken", token, image); err != nil {
 			return err
 		}
 	}
 
-	err := modifyArtifactAttributes(c, image)
+	err = modifyArtifactAttributes(c, image)
 	if err != nil {
 		return err
 	}
@@ -228,6 +255,74 @@ func modifyExisting(c *cli.Context, image VPImage) error {
 		return err
 	}
 
+	return modifyArtifactGroup(c, image)
+}
+
+// checkGroupFlags refuses conflicting ways of changing the artifact_group.
+func checkGroupFlags(c *cli.Context) error {
+	var given []string
+	for _, flag := range []string{"provides-group", setGroupFlag, clearGroupFlag} {
+		if c.IsSet(flag) {
+			given = append(given, "--"+flag)
+		}
+	}
+	if len(given) > 1 {
+		return errors.Errorf("%s can not be used together", strings.Join(given, " and "))
+	}
+	if c.IsSet(setGroupFlag) {
+		return artifact.ValidateGroupName(c.String(setGroupFlag))
+	}
+	return nil
+}
+
+// modifyArtifactGroup applies --set-group and --clear-group. Since the
+// group of the devices is only replaced or cleared if the Artifact says so,
+// it warns about group or clears provides changes which leave devices in the
+// group they are in.
+func modifyArtifactGroup(c *cli.Context, image VPImage) error {
+	changed := false
+	for _, flag := range []string{
+		"provides-group", setGroupFlag, clearGroupFlag,
+		clearsProvidesFlag, deleteClearsProvidesFlag,
+	} {
+		changed = changed || c.IsSet(flag)
+	}
+	if !changed {
+		return nil
+	}
+	art, isArt := image.(*ModImageArtifact)
+	if !isArt {
+		for _, flag := range []string{setGroupFlag, clearGroupFlag} {
+			if c.IsSet(flag) {
+				return errors.Errorf("`--%s` argument must be used with an Artifact", flag)
+			}
+		}
+		return nil
+	}
+	args := art.writeArgs
+	if args.Provides == nil || args.TypeInfoV3 == nil {
+		if c.IsSet(setGroupFlag) || c.IsSet(clearGroupFlag) {
+			return errors.New("artifact groups need a version 3 Artifact")
+		}
+		return nil
+	}
+
+	if c.IsSet(setGroupFlag) {
+		args.Provides.ArtifactGroup = c.String(setGroupFlag)
+	} else if c.Bool(clearGroupFlag) {
+		args.Provides.ArtifactGroup = ""
+		if !artifact.ClearsArtifactGroup(args.TypeInfoV3.ClearsArtifactProvides) {
+			args.TypeInfoV3.ClearsArtifactProvides = append(
+				args.TypeInfoV3.ClearsArtifactProvides, artifact.ArtifactGroupKey)
+		}
+	}
+
+	if artifact.NewGroupTransition(args.Provides, args.Depends,
+		args.TypeInfoV3.ClearsArtifactProvides).KeepsGroup() {
+		warnf(WarningArtifactGroup, "The Artifact neither provides a group nor clears %s;"+
+			" devices installing it stay in the group they are in. Use --%s or --%s"+
+			" to change their group.", artifact.ArtifactGroupKey, setGroupFlag, clearGroupFlag)
+	}
 	return nil
 }
 
@@ -247,6 +342,26 @@ func modifyArtifactAttributes(c *cli.Context, image VPImage) error {
 		art.writeArgs.Depends.ArtifactName = c.StringSlice("artifact-name-depends")
 	}
 
+	if isArt {
+		devices := art.writeArgs.Devices
+		if c.Bool("normalize-device-types") {
+			devices = artifact.NormalizeDeviceTypes(devices)
+			if err := checkDeviceTypes(devices); err != nil {
+				return err
+			}
+			art.writeArgs.Devices = devices
+			if art.writeArgs.Depends != nil {
+				art.writeArgs.Depends.CompatibleDevices = devices
+			}
+		} else if err := checkDeviceTypes(devices); err != nil {
+			// Do not refuse to modify Artifacts written before device types
+			// were validated.
+			warnf(WarningDeviceType, "%v", err)
+		}
+	} else if c.Bool("normalize-device-types") {
+		return errors.New("`--normalize-device-types` argument must be used with an Artifact")
+	}
+
 	if c.IsSet("depends-groups") {
 		if !isArt {
 			return errors.New("`--depends-groups` argument must be used with an Artifact")
@@ -254,6 +369,13 @@ func modifyArtifactAttributes(c *cli.Context, image VPImage) error {
 		art.writeArgs.Depends.ArtifactGroup = c.StringSlice("depends-groups")
 	}
 
+	if c.IsSet("not-device-type") {
+		if !isArt {
+			return errors.New("`--not-device-type` argument must be used with an Artifact")
+		}
+		art.writeArgs.Depends.ExcludedDevices = c.StringSlice("not-device-type")
+	}
+
 	if c.IsSet("script") {
 		if !isArt {
 			return errors.New("`--script` argument must be used with an Artifact")
@@ -15,6 +15,7 @@
 package cli
 
 import (
+	"encoding/hex"
 	"io"
 	"os"
 	"os/exec"
@@ -24,6 +25,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/handlers"
 )
 
 // Check that flags that originally came from "write" are handled.
@@ -358,6 +363,7 @@ Updates:
       rootfs-image.version: release-1
     Depends: {}
     Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]
+    Install size: 524288
     Metadata: {}
     Files:
         name: mender_test.img
@@ -386,6 +392,7 @@ Updates:
       rootfs-image.version: release-1
     Depends: {}
     Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]
+    Install size: 524288
     Metadata: {}
     Files:
         name: mender_test.img
@@ -448,6 +455,7 @@ Updates:
       rootfs-image.version: release-1
     Depends: {}
     Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]
+    Install size: 524288
     Metadata: {}
     Files:
         name: mender_test.img
@@ -465,6 +473,7 @@ Updates:
 		"keyfactor-signserver-worker",
 		"vault-transit-key",
 		"key",
+		"key-provider",
 		"output-path",
 		"script",
 	})
@@ -474,6 +483,7 @@ Updates:
 		"keyfactor-signserver-worker",
 		"vault-transit-key",
 		"key",
+		"key-provider",
 		"name",
 	})
 }
@@ -521,6 +531,7 @@ Updates:
       rootfs-image.testType.version: testName
     Depends: {}
     Clears Provides: [rootfs-image.testType.*]
+    Install size: 27
     Metadata: {}
     Files:
         name: updateFile
@@ -661,6 +672,7 @@ Updates:
       rootfs-image.testType.version: testName
     Depends: {}
     Clears Provides: [rootfs-image.testType.*]
+    Install size: 27
     Metadata:
       {
         "a": "b"
@@ -707,7 +719,7 @@ func TestModifyBrokenArtifact(t *testing.T) {
 		"-n", "release-1",
 		artFile})
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "can not execute `parted` command or image is broken")
+	assert.Contains(t, err.Error(), "invalid partition table or image is broken")
 }
 
 func TestModifyExtraAttributes(t *testing.T) {
@@ -745,6 +757,7 @@ func TestModifyExtraAttributes(t *testing.T) {
 		"--provides-group", "testProvidesGroup",
 		"--depends-groups", "testDependsGroup",
 		"--depends-groups", "testDependsGroup2",
+		"--not-device-type", "testDevice2",
 		"--provides", "testProvide1:SomeStuff1",
 		"--provides", "testProvide2:SomeStuff2",
 		"--depends", "testDepends1:SomeStuff1",
@@ -757,6 +770,7 @@ func TestModifyExtraAttributes(t *testing.T) {
   Version: 3
   Signature: no signature
   Compatible devices: [testDevice]
+  Excluded devices: [testDevice2]
   Provides group: testProvidesGroup
   Depends on one of artifact(s): [testNameDepends, testNameDepends2]
   Depends on one of group(s): [testDependsGroup, testDependsGroup2]
@@ -771,6 +785,7 @@ Updates:
       testDepends1: SomeStuff1
       testDepends2: SomeStuff2
     Clears Provides: []
+    Install size: 13
     Metadata:
       {
         "meta": "data"
@@ -796,6 +811,7 @@ Updates:
 		"legacy-rootfs-image-checksum", // Just a generic provide
 		"no-default-clears-provides",
 		"no-default-software-version",
+		"not-device-type",
 		"output-path",
 		"provides",
 		"provides-group",
@@ -812,6 +828,7 @@ Updates:
 		"depends",
 		"depends-groups",
 		"meta-data",
+		"not-device-type",
 		"provides",
 		"provides-group",
 	})
@@ -835,11 +852,13 @@ func TestModifyExtraAttributesOnNonArtifact(t *testing.T) {
 		{"--artifact-name-depends", "testNameDepends"},
 		{"--provides-group", "testGroupProvides"},
 		{"--depends-groups", "testGroupDepends"},
+		{"--not-device-type", "testDevice2"},
 		{"--depends", "depends:value"},
 		{"--provides", "provides:value"},
 		{"--meta-data", filepath.Join(tmpdir, "meta-data")},
 		{"--clears-provides", "rootfs-image.my-new-app.*"},
 		{"--delete-clears-provides", "rootfs-image.*"},
+		{"--set-group", "testGroup"},
 	}
 
 	for _, p := range paramPairs {
@@ -914,6 +933,73 @@ Updates:
 	})
 }
 
+func readGroupTransition(t *testing.T, artFile string) artifact.GroupTransition {
+	f, err := os.Open(artFile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifactHeaders())
+	return ar.GroupTransition()
+}
+
+func TestModifyArtifactGroup(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err := Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "testType",
+		"--depends-groups", "fleet-a",
+	})
+	require.NoError(t, err)
+	assert.True(t, readGroupTransition(t, artfile).KeepsGroup())
+
+	data := modifyAndRead(t, artfile, "--set-group", "fleet-b")
+	assert.Contains(t, data, "Provides group: fleet-b\n")
+	assert.Equal(t, "group fleet-a -> group fleet-b",
+		readGroupTransition(t, artfile).String())
+
+	data = modifyAndRead(t, artfile, "--clear-group")
+	assert.Contains(t, data, "Provides group: \n")
+	assert.Contains(t, data, "Clears Provides: [rootfs-image.testType.*, artifact_group]\n")
+	transition := readGroupTransition(t, artfile)
+	assert.True(t, transition.Cleared)
+	assert.Equal(t, "group fleet-a -> no group", transition.String())
+
+	// Clearing again does not add another clears provides.
+	data = modifyAndRead(t, artfile, "--clear-group")
+	assert.Contains(t, data, "Clears Provides: [rootfs-image.testType.*, artifact_group]\n")
+
+	err = Run([]string{"mender-artifact", "modify", "--set-group", "fleet-b",
+		"--clear-group", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--set-group and --clear-group can not be used together")
+	err = Run([]string{"mender-artifact", "modify", "--provides-group", "fleet-b",
+		"--set-group", "fleet-c", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--provides-group and --set-group can not be used together")
+	err = Run([]string{"mender-artifact", "modify", "--set-group", " fleet-b", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		`the group name " fleet-b" has leading or trailing whitespace`)
+	assert.True(t, readGroupTransition(t, artfile).Cleared)
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"artifact-name",
+		"depends-groups",
+		"device-type",
+		"output-path",
+		"type",
+	})
+	modifyFlagsTested.addFlags([]string{
+		"clear-group",
+		"set-group",
+	})
+}
+
 func TestModifyNoProvides(t *testing.T) {
 	tmpdir, err := os.MkdirTemp("", "mendertest")
 	require.NoError(t, err)
@@ -951,6 +1037,7 @@ Updates:
     Provides: {}
     Depends: {}
     Clears Provides: []
+    Install size: 13
     Metadata: {}
     Files:
         name: updateFile
@@ -1010,6 +1097,7 @@ Updates:
       rootfs-image.version: testName
     Depends: {}
     Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]
+    Install size: 13
     Metadata: {}
     Files:
         name: updateFile
@@ -1022,14 +1110,358 @@ Updates:
 	require.NoError(t, err)
 	assert.Contains(t, string(output), ".xz")
 
+	err = Run([]string{"mender-artifact", "modify", "--compression-opt", "rsyncable", artfile})
+	require.Error(t, err)
+	assert.Equal(t, "--compression-opt requires --compression", err.Error())
+
+	err = Run([]string{"mender-artifact", "modify",
+		"--compression", "lzma", "--compression-opt", "rsyncable", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `compressor does not support option "rsyncable"`)
+
+	data = modifyAndRead(t, artfile,
+		"--compression", "zstd_fast", "--compression-opt", "rsyncable")
+	assert.Equal(t, expected, removeVolatileEntries(data))
+
+	output, err = exec.Command("tar", "tf", artfile).Output()
+	require.NoError(t, err)
+	assert.Contains(t, string(output), ".zst")
+
 	modifyWriteFlagsTested.addFlags([]string{
 		"artifact-name",
+		"compression-opt",
 		"device-type",
 		"output-path",
 		"type",
 	})
 	modifyFlagsTested.addFlags([]string{
 		"compression",
+		"compression-opt",
+	})
+}
+
+func TestModifyUncompressedHeader(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err := os.WriteFile(filepath.Join(tmpdir, "updateFile"), []byte("updateContent"), 0644)
+	require.NoError(t, err)
+
+	err = Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "testType",
+		"-f", filepath.Join(tmpdir, "updateFile"),
+		"--uncompressed-header",
+	})
+	require.NoError(t, err)
+
+	members := func() []string {
+		output, err := exec.Command("tar", "tf", artfile).Output()
+		require.NoError(t, err)
+		return strings.Fields(string(output))
+	}
+	assert.Equal(t, []string{"version", "manifest", "header.tar", "data/0000.tar.gz"},
+		members())
+
+	// The header stays uncompressed when the Artifact is modified.
+	data := modifyAndRead(t, artfile, "-n", "newName")
+	assert.Contains(t, data, "Name: newName")
+	assert.Equal(t, []string{"version", "manifest", "header.tar", "data/0000.tar.gz"},
+		members())
+
+	data = modifyAndRead(t, artfile, "--compression", "zstd_fast")
+	assert.Contains(t, data, "Name: newName")
+	assert.Equal(t, []string{"version", "manifest", "header.tar", "data/0000.tar.zst"},
+		members())
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"uncompressed-header",
+	})
+}
+
+func TestModifyImmutableMetadata(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err = os.WriteFile(filepath.Join(tmpdir, "updateFile"), []byte("updateContent"), 0644)
+	require.NoError(t, err)
+
+	err = Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "testType",
+		"-f", filepath.Join(tmpdir, "updateFile"),
+		"--immutable-metadata",
+	})
+	require.NoError(t, err)
+
+	// Refused without --force-unlock.
+	err = Run([]string{"mender-artifact", "modify", "-n", "newName", artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has immutable metadata")
+
+	data := modifyAndRead(t, artfile, "-n", "newName", "--force-unlock")
+	assert.Contains(t, data, "Name: newName")
+	// The lock survives the modification.
+	assert.Contains(t, data, "Immutable metadata: true")
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"artifact-name",
+		"device-type",
+		"file",
+		"immutable-metadata",
+		"output-path",
+		"type",
+	})
+	modifyFlagsTested.addFlags([]string{
+		"artifact-name",
+		"force-unlock",
+	})
+}
+
+func TestModifyInstallSize(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err = os.WriteFile(filepath.Join(tmpdir, "updateFile"), []byte("updateContent"), 0644)
+	require.NoError(t, err)
+
+	writeArgs := []string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "testType",
+		"-f", filepath.Join(tmpdir, "updateFile"),
+	}
+
+	// Computed from the payload files by default.
+	err = Run(writeArgs)
+	require.NoError(t, err)
+	data := modifyAndRead(t, artfile, "-n", "newName")
+	assert.Contains(t, data, "Install size: 13\n")
+
+	// An explicit size is kept through modifications.
+	err = Run(append(writeArgs, "--install-size", "1048576"))
+	require.NoError(t, err)
+	data = modifyAndRead(t, artfile, "-n", "newName")
+	assert.Contains(t, data, "Install size: 1048576\n")
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"artifact-name",
+		"device-type",
+		"file",
+		"install-size",
+		"output-path",
+		"type",
+	})
+}
+
+func readVerityProvides(t *testing.T, artfile string) artifact.TypeInfoProvides {
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	reader := areader.NewReader(f)
+	require.NoError(t, reader.ReadArtifact())
+	provides, err := reader.GetHandlers()[0].GetUpdateProvides()
+	require.NoError(t, err)
+	return provides
+}
+
+func TestModifyVerity(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err = Run([]string{
+		"mender-artifact", "write", "rootfs-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-f", "mender_test.img",
+		"--verity",
+	})
+	require.NoError(t, err)
+	before := readVerityProvides(t, artfile)
+
+	newFile := filepath.Join(tmpdir, "newFile")
+	require.NoError(t, os.WriteFile(newFile, []byte("new content"), 0644))
+	err = Run([]string{"mender-artifact", "cp", newFile, artfile + ":/etc/newFile"})
+	require.NoError(t, err)
+	after := readVerityProvides(t, artfile)
+
+	assert.Equal(t, before["rootfs-image.verity.salt"], after["rootfs-image.verity.salt"])
+	assert.Equal(t, before["rootfs-image.verity.hash-offset"],
+		after["rootfs-image.verity.hash-offset"])
+	assert.NotEqual(t, before["rootfs-image.verity.root-hash"],
+		after["rootfs-image.verity.root-hash"])
+
+	// The new root hash matches the modified image.
+	err = Run([]string{"mender-artifact", "dump",
+		"--files", filepath.Join(tmpdir, "files"), artfile})
+	require.NoError(t, err)
+	payload, err := os.Open(filepath.Join(tmpdir, "files", "mender_test.img"))
+	require.NoError(t, err)
+	defer payload.Close()
+	salt, err := hex.DecodeString(after["rootfs-image.verity.salt"])
+	require.NoError(t, err)
+	tree, err := artifact.NewVerityHashTree(io.LimitReader(payload, 524288), salt)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(tree.RootHash), after["rootfs-image.verity.root-hash"])
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"artifact-name",
+		"device-type",
+		"file",
+		"output-path",
+		"verity",
+	})
+}
+
+func readPayloadSignatures(t *testing.T, artfile string) (map[string]string, []*handlers.DataFile) {
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	reader := areader.NewReader(f)
+	require.NoError(t, reader.ReadArtifact())
+	handler := reader.GetHandlers()[0]
+	return handler.GetUpdatePayloadSignatures(), handler.GetUpdateFiles()
+}
+
+func TestModifyPayloadSignatures(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	keyFile := filepath.Join(tmpdir, "payload.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(PrivateECDSAKey), 0600))
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	err = Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "testType",
+		"-f", updateFile,
+		"--payload-sign-key", keyFile,
+	})
+	require.NoError(t, err)
+
+	verifier, err := artifact.NewPKISigner([]byte(PrivateECDSAKey))
+	require.NoError(t, err)
+
+	signatures, files := readPayloadSignatures(t, artfile)
+	require.Len(t, files, 1)
+	require.Contains(t, signatures, "updateFile")
+	assert.NoError(t, verifier.Verify(files[0].Checksum, []byte(signatures["updateFile"])))
+
+	// Signatures survive metadata modifications.
+	data := modifyAndRead(t, artfile, "-n", "newName")
+	assert.Contains(t, data, "signature: "+signatures["updateFile"])
+
+	// But are removed when the signed file is modified, which happens to
+	// rootfs images when the name is changed.
+	require.NoError(t, copyFile("mender_test.img", filepath.Join(tmpdir, "rootfs.ext4")))
+	err = Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-T", "rootfs-image",
+		"-f", filepath.Join(tmpdir, "rootfs.ext4"),
+		"--payload-sign-key", keyFile,
+	})
+	require.NoError(t, err)
+	signatures, _ = readPayloadSignatures(t, artfile)
+	require.Contains(t, signatures, "rootfs.ext4")
+
+	data = modifyAndRead(t, artfile, "-n", "newName")
+	assert.NotContains(t, data, "signature: ")
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"artifact-name",
+		"device-type",
+		"file",
+		"output-path",
+		"payload-sign-key",
+		"type",
+	})
+	modifyFlagsTested.addFlags([]string{
+		"artifact-name",
+	})
+}
+
+func TestModifyNormalizeDeviceTypes(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	err = Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "TestDevice",
+		"-t", "testdevice",
+		"-t", "Other-Device",
+		"-T", "testType",
+		"-f", updateFile,
+	})
+	require.NoError(t, err)
+	assert.Len(t, Warnings(), 2)
+
+	data := modifyAndRead(t, artfile, "--normalize-device-types")
+	assert.Contains(t, data, "Compatible devices: [testdevice, other-device]")
+
+	modifyFlagsTested.addFlags([]string{
+		"normalize-device-types",
+	})
+}
+
+func TestModifyDeviceTypeScripts(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh"), 0755))
+
+	err := Run([]string{
+		"mender-artifact", "write", "module-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "rpi3",
+		"-t", "rpi4",
+		"-T", "testType",
+		"-f", updateFile,
+		"--script-for-device-type", "rpi4:" + script,
+	})
+	require.NoError(t, err)
+
+	// The scripts of the device type are kept.
+	data := modifyAndRead(t, artfile, "-n", "newName")
+	assert.Contains(t, data, "Name: newName")
+	assert.Contains(t, data, `  State scripts: []
+  State scripts for device type rpi4:
+    - ArtifactInstall_Enter_00
+`)
+
+	modifyWriteFlagsTested.addFlags([]string{
+		"script-for-device-type",
 	})
 }
 
@@ -1040,6 +1472,45 @@ func TestModifyAllFlagsTested(t *testing.T) {
 		"ssh-args",
 		"version",     // Could be supported, but we don't care about this.
 		"no-progress", // Has no effect on the output
+		"delta-base",  // Only selects the payload files; modify keeps them as they are.
+		"script-dir",  // Collects the same scripts as "script".
+		"dry-run",     // Does not write anything.
+		// Tested in TestModifyNormalizeDeviceTypes.
+		"normalize-device-types",
+		// Only splits the output into one Artifact per device type.
+		"provides-for-device",
+		// Only renames payload files, which modify keeps as they are.
+		"sanitize-filenames",
+		// Only writes a file next to the Artifact.
+		"checksum-file",
+		// Only moves the payload files out of the Artifact, which modify
+		// keeps as they are.
+		"external-payload",
+		// Only sets the defaults of other flags.
+		"profile",
+		// Only prints the compression statistics.
+		"stats",
+		// Only affect how the Artifact is buffered while written.
+		"buffer-memory",
+		"temp-dir",
+		// Only affects the times and owners of the files in the Artifact.
+		"reproducible",
+		// Only collects payload files, which modify keeps as they are.
+		"files-from",
+		"dir",
+		// Modify handles Artifacts with one payload only.
+		"payload",
+		// Only adds provides, which modify keeps as they are.
+		"detect-platform",
+		// Modify keeps the algorithm, tested in TestWriteChecksumAlgorithm.
+		"checksum-algorithm",
+		// Only sets other flags of bootstrap Artifacts.
+		"from-device-json",
+	})
+
+	modifyFlagsTested.addFlags([]string{
+		// Tested in TestTenantToken.
+		"tenant-token-fd",
 	})
 
 	modifyWriteFlagsTested.checkAllFlagsTested(t)
@@ -0,0 +1,246 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// checkRootfsPayload reads the headers of the Artifact and checks that its
+// only payload is a rootfs-image.
+func checkRootfsPayload(r *areader.Reader) error {
+	if err := r.ReadArtifactHeaders(); err != nil {
+		return err
+	}
+	inst := r.GetHandlers()
+	if len(inst) != 1 {
+		return errors.New("only Artifacts with one payload can be mounted")
+	}
+	updType := inst[0].GetUpdateType()
+	if updType == nil || *updType != "rootfs-image" {
+		return errors.New("only rootfs-image payloads can be mounted")
+	}
+	return nil
+}
+
+// verifyRootfsPayload checks the rootfs-image payload of the Artifact, and
+// streams it through the reader to verify its checksum without storing it.
+func verifyRootfsPayload(r *areader.Reader) error {
+	if err := checkRootfsPayload(r); err != nil {
+		return err
+	}
+	if err := r.ReadArtifactData(); err != nil {
+		return err
+	}
+	if len(r.GetHandlers()[0].GetUpdateFiles()) != 1 {
+		return errors.New("rootfs-image artifacts with more than one file not supported")
+	}
+	return nil
+}
+
+// extractRootfsPayload streams the rootfs-image payload of the Artifact into
+// dir, without unpacking the rest of the Artifact, and returns its path.
+func extractRootfsPayload(r *areader.Reader, dir string) (string, error) {
+	if err := checkRootfsPayload(r); err != nil {
+		return "", err
+	}
+	store := &writeUpdateStorer{dir: dir}
+	r.GetHandlers()[0].SetUpdateStorerProducer(store)
+	if err := r.ReadArtifactData(); err != nil {
+		return "", err
+	}
+	if len(store.names) != 1 {
+		return "", errors.New("rootfs-image artifacts with more than one file not supported")
+	}
+	return store.names[0], nil
+}
+
+// locatePayloadFile returns the offset and size of the first file of the
+// payload in the Artifact f, so that it can be loop mounted in place. It
+// returns false if the payload data is compressed or the file is sparse.
+func locatePayloadFile(f *os.File) (offset, size int64, ok bool, err error) {
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, false, err
+	}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return 0, 0, false, nil
+		} else if err != nil {
+			return 0, 0, false, errors.Wrap(err, "can not read Artifact")
+		}
+		if !strings.HasPrefix(hdr.Name, "data/0000.") {
+			continue
+		}
+		if hdr.Name != "data/0000.tar" {
+			return 0, 0, false, nil
+		}
+		// The tar readers read exactly up to the file contents, so the
+		// position of f is where the file starts.
+		data := tar.NewReader(tr)
+		for {
+			hdr, err = data.Next()
+			if err != nil {
+				return 0, 0, false, errors.Wrap(err, "can not read payload data")
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				break
+			}
+		}
+		for key := range hdr.PAXRecords {
+			if strings.HasPrefix(key, "GNU.sparse.") {
+				return 0, 0, false, nil
+			}
+		}
+		offset, err = f.Seek(0, io.SeekCurrent)
+		return offset, hdr.Size, err == nil, err
+	}
+}
+
+// shellQuote quotes s as a single word for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func mountInPlaceOptions(offset, size int64) string {
+	return fmt.Sprintf("loop,ro,offset=%d,sizelimit=%d", offset, size)
+}
+
+// mountInPlaceRecipe mounts the payload file directly from the Artifact.
+func mountInPlaceRecipe(artifactPath, mountpoint string, offset, size int64) string {
+	return fmt.Sprintf("mount -o %s %s %s\n"+
+		"# When done:\n"+
+		"umount %s\n",
+		mountInPlaceOptions(offset, size), shellQuote(artifactPath),
+		shellQuote(mountpoint), shellQuote(mountpoint))
+}
+
+// mountExtractRecipe extracts the compressed payload only when it is run.
+func mountExtractRecipe(app, artifactPath, mountpoint string) string {
+	return fmt.Sprintf("dir=$(mktemp -d)\n"+
+		"%s dump --files \"$dir\" %s\n"+
+		"mount -o loop,ro \"$dir\"/* %s\n"+
+		"# When done:\n"+
+		"umount %s\n"+
+		"rm -r \"$dir\"\n",
+		app, shellQuote(artifactPath), shellQuote(mountpoint), shellQuote(mountpoint))
+}
+
+func mountArtifact(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants two", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+	artifactPath := c.Args().First()
+	mountpoint := c.Args().Get(1)
+
+	f, err := os.Open(artifactPath)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Can not open artifact: %s", err.Error()),
+			errArtifactOpen)
+	}
+	defer f.Close()
+
+	offset, size, inPlace, err := locatePayloadFile(f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Can not read Artifact: %s", err.Error()),
+			errArtifactInvalid)
+	}
+
+	ar := areader.NewReader(f)
+	ar.ReadBufferSize = c.Int("read-buffer-size")
+	ar.ReadAheadBuffers = c.Int("read-ahead")
+	var image, dir string
+	switch {
+	case inPlace:
+		err = verifyRootfsPayload(ar)
+	case c.Bool("print-recipe") || !c.Bool("extract"):
+		err = checkRootfsPayload(ar)
+	default:
+		if dir, err = utils.TempDir("", "mount"); err != nil {
+			return cli.NewExitError(err.Error(), errSystemError)
+		}
+		if image, err = extractRootfsPayload(ar, dir); err != nil {
+			utils.RemoveTemp(dir)
+		}
+	}
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Can not read Artifact: %s", err.Error()),
+			errArtifactInvalid)
+	}
+
+	// Compressed payloads can not be mounted without decompressing all of
+	// them, so that is only done on request.
+	if !inPlace && !c.Bool("extract") {
+		return cli.NewExitError("The payload is compressed or sparse and can not be"+
+			" mounted in place. Write the Artifact with --compression none, or use"+
+			" --extract to extract the payload to a temporary file first",
+			errArtifactInvalidParameters)
+	}
+
+	if c.Bool("print-recipe") {
+		if inPlace {
+			fmt.Print(mountInPlaceRecipe(artifactPath, mountpoint, offset, size))
+		} else {
+			fmt.Print(mountExtractRecipe(c.App.Name, artifactPath, mountpoint))
+		}
+		return nil
+	}
+
+	options := "loop,ro"
+	if inPlace {
+		image, options = artifactPath, mountInPlaceOptions(offset, size)
+	} else {
+		// The payload stays in dir until the user unmounts and removes it.
+		utils.ForgetTemp(dir)
+	}
+	bin, err := utils.GetBinaryPath("mount")
+	if err != nil {
+		utils.RemoveTemp(dir)
+		return cli.NewExitError("mount command not found", errSystemError)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, "-o", options, image, mountpoint)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		utils.RemoveTemp(dir)
+		return cli.NewExitError(
+			fmt.Sprintf("Can not mount payload: %s: %s; use --print-recipe to mount manually",
+				err.Error(), strings.TrimSpace(stderr.String())),
+			errSystemError)
+	}
+	fmt.Printf("Mounted %s read-only on %s. When done, run:\numount %s\n",
+		image, mountpoint, shellQuote(mountpoint))
+	if dir != "" {
+		fmt.Printf("rm -r %s\n", shellQuote(dir))
+	}
+	return nil
+}
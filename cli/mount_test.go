@@ -0,0 +1,93 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMountPrintRecipe(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-mount")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	mnt := filepath.Join(tmpdir, "it's mounted")
+
+	// An uncompressed payload is mounted in place, inside the Artifact.
+	art := filepath.Join(tmpdir, "artifact.mender")
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "--compression", "none",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", art})
+	require.NoError(t, err)
+	data, err := runAndCollectStdout([]string{"mender-artifact", "mount",
+		"--print-recipe", art, mnt})
+	require.NoError(t, err)
+
+	var offset, size int64
+	var quotedArt, quotedMnt string
+	_, err = fmt.Sscanf(data, "mount -o loop,ro,offset=%d,sizelimit=%d %s %s",
+		&offset, &size, &quotedArt, &quotedMnt)
+	require.NoError(t, err, data)
+	assert.Equal(t, "'"+art+"'", quotedArt)
+	assert.True(t, strings.HasSuffix(data, "\numount '"+strings.ReplaceAll(mnt, "'", `'\''`)+"'"),
+		data)
+	assert.NotContains(t, data, "rm -r")
+
+	f, err := os.Open(art)
+	require.NoError(t, err)
+	defer f.Close()
+	content := make([]byte, size)
+	_, err = f.ReadAt(content, offset)
+	require.NoError(t, err)
+	assert.Equal(t, "my update", string(content))
+
+	// A compressed payload is only extracted on request, when the recipe is
+	// run.
+	art = filepath.Join(tmpdir, "compressed.mender")
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "--compression", "gzip",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", art})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "mount", "--print-recipe", art, mnt})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--extract")
+	data, err = runAndCollectStdout([]string{"mender-artifact", "mount",
+		"--print-recipe", "--extract", art, mnt})
+	require.NoError(t, err)
+	assert.Equal(t, "dir=$(mktemp -d)\n"+
+		"mender-artifact dump --files \"$dir\" '"+art+"'\n"+
+		"mount -o loop,ro \"$dir\"/* '"+strings.ReplaceAll(mnt, "'", `'\''`)+"'\n"+
+		"# When done:\n"+
+		"umount '"+strings.ReplaceAll(mnt, "'", `'\''`)+"'\n"+
+		"rm -r \"$dir\"", data)
+
+	// Module payloads can not be mounted.
+	modArt := filepath.Join(tmpdir, "module.mender")
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "my-module",
+		"-f", rootfs, "-o", modArt})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "mount", "--print-recipe", modArt, mnt})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only rootfs-image payloads can be mounted")
+}
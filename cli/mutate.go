@@ -0,0 +1,73 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/awriter"
+)
+
+// faultNames returns the names of the faults mutate can introduce.
+func faultNames() string {
+	names := make([]string, len(awriter.Faults))
+	for i, fault := range awriter.Faults {
+		names[i] = string(fault)
+	}
+	return strings.Join(names, ", ")
+}
+
+func mutateArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give the Artifact to mutate",
+			errArtifactInvalidParameters)
+	}
+	artFile := c.Args().First()
+	outputFile := c.String("output-path")
+	if isSameFile(artFile, outputFile) {
+		return cli.NewExitError("The mutated Artifact can not replace the original",
+			errArtifactInvalidParameters)
+	}
+	fault := awriter.Fault(c.String("fault"))
+	if !fault.Known() {
+		return cli.NewExitError("Unknown fault: "+string(fault)+"; use one of "+
+			faultNames(), errArtifactInvalidParameters)
+	}
+
+	f, err := os.Open(artFile)
+	if err != nil {
+		err = errors.Wrapf(err, "Can not open: %s", artFile)
+		return cli.NewExitError(err, errArtifactOpen)
+	}
+	defer f.Close()
+
+	mutate := func(w io.Writer) error {
+		return awriter.MutateArtifact(f, w, fault)
+	}
+	if outputFile == "-" {
+		err = mutate(os.Stdout)
+	} else {
+		err = writeOutputFile(outputFile, artFile, mutate)
+	}
+	if err != nil {
+		return cli.NewExitError("Can not mutate artifact: "+err.Error(), errArtifactCreate)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/awriter"
+)
+
+func TestMutateArtifact(t *testing.T) {
+	tmpdir := t.TempDir()
+	payload := filepath.Join(tmpdir, "payload")
+	require.NoError(t, os.WriteFile(payload, []byte("data"), 0644))
+	original := filepath.Join(tmpdir, "original.mender")
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-T", "app", "-t", "my-device", "-n", "release-1", "-f", payload,
+		"-o", original})
+	require.NoError(t, err)
+
+	expected := map[awriter.Fault]string{
+		awriter.FaultMissingVersion:      "can not read version file",
+		awriter.FaultMissingManifest:     "wrong element: header.tar.gz",
+		awriter.FaultMissingHeader:       "Invalid structure",
+		awriter.FaultMissingData:         "not part of artifact",
+		awriter.FaultHeaderChecksum:      "invalid checksum",
+		awriter.FaultDataChecksum:        "invalid checksum",
+		awriter.FaultManifestExtraFile:   "not part of artifact",
+		awriter.FaultManifestMissingData: "can not find data file",
+		awriter.FaultParseOrder:          "wrong element: header.tar.gz",
+	}
+	require.Len(t, expected, len(awriter.Faults))
+	for _, fault := range awriter.Faults {
+		t.Run(string(fault), func(t *testing.T) {
+			mutated := filepath.Join(tmpdir, string(fault)+".mender")
+			err := Run([]string{"mender-artifact", "mutate", original,
+				"--fault", string(fault), "-o", mutated})
+			require.NoError(t, err)
+			err = Run([]string{"mender-artifact", "validate", mutated})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), expected[fault])
+		})
+	}
+
+	mutated := filepath.Join(tmpdir, "unknown.mender")
+	err = Run([]string{"mender-artifact", "mutate", original,
+		"--fault", "bit-rot", "-o", mutated})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown fault: bit-rot; use one of missing-version,")
+	_, err = os.Stat(mutated)
+	assert.True(t, os.IsNotExist(err))
+}
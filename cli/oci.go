@@ -0,0 +1,194 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/oci"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+var ociFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "username",
+		Usage:  "Username for the OCI registry",
+		EnvVar: "MENDER_ARTIFACT_OCI_USERNAME",
+	},
+	cli.StringFlag{
+		Name:   "password",
+		Usage:  "Password or token for the OCI registry",
+		EnvVar: "MENDER_ARTIFACT_OCI_PASSWORD",
+	},
+	cli.BoolFlag{
+		Name:  "plain-http",
+		Usage: "Connect to the OCI registry using http instead of https",
+	},
+}
+
+func newOCIClient(c *cli.Context) *oci.Client {
+	return &oci.Client{
+		Username:  c.String("username"),
+		Password:  c.String("password"),
+		PlainHTTP: c.Bool("plain-http"),
+	}
+}
+
+// ociAnnotations describes the Artifact read by ar in manifest annotations.
+func ociAnnotations(ar *areader.Reader) (map[string]string, error) {
+	provides := map[string]string{}
+	if ap := ar.GetArtifactProvides(); ap != nil {
+		provides["artifact_name"] = ap.ArtifactName
+		if ap.ArtifactGroup != "" {
+			provides["artifact_group"] = ap.ArtifactGroup
+		}
+	}
+	for _, handler := range ar.GetHandlers() {
+		typeProvides, err := handler.GetUpdateProvides()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range typeProvides {
+			provides[k] = v
+		}
+	}
+	data, err := json.Marshal(provides)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		oci.AnnotationName:        ar.GetArtifactName(),
+		oci.AnnotationDeviceTypes: strings.Join(ar.GetCompatibleDevices(), ","),
+		oci.AnnotationProvides:    string(data),
+	}, nil
+}
+
+func pushArtifact(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return cli.NewExitError("Please give an Artifact and where to push it: "+
+			"'mender-artifact push <artifact> oci://<registry>/<repository>:<tag>'",
+			errArtifactInvalidParameters)
+	}
+	ref, err := oci.ParseReference(c.Args().Get(1))
+	if err != nil {
+		return cli.NewExitError(err, errArtifactInvalidParameters)
+	}
+
+	path := c.Args().First()
+	f, err := os.Open(path)
+	if err != nil {
+		return cli.NewExitError("Can not open artifact: "+path, errArtifactOpen)
+	}
+	defer f.Close()
+
+	ar := areader.NewReader(f)
+	if err = ar.ReadArtifactHeaders(); err != nil {
+		return cli.NewExitError("Can not read artifact: "+err.Error(), errArtifactInvalid)
+	}
+	annotations, err := ociAnnotations(ar)
+	if err != nil {
+		return cli.NewExitError(err, errArtifactInvalid)
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return cli.NewExitError(err, errSystemError)
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return cli.NewExitError(err, errSystemError)
+	}
+	layer := oci.Descriptor{
+		MediaType:   oci.ArtifactMediaType,
+		Digest:      "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Size:        size,
+		Annotations: map[string]string{oci.AnnotationTitle: filepath.Base(path)},
+	}
+
+	manifest, err := newOCIClient(c).Push(ref, f, layer, annotations)
+	if err != nil {
+		return cli.NewExitError("Can not push artifact: "+err.Error(), 1)
+	}
+	fmt.Printf("Pushed %s\nDigest: %s\n", ref, manifest.Digest)
+	return nil
+}
+
+func pullArtifact(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give where to pull the Artifact from: "+
+			"'mender-artifact pull oci://<registry>/<repository>:<tag>'",
+			errArtifactInvalidParameters)
+	}
+	ref, err := oci.ParseReference(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err, errArtifactInvalidParameters)
+	}
+
+	client := newOCIClient(c)
+	manifest, err := client.FetchManifest(ref)
+	if err != nil {
+		return cli.NewExitError("Can not pull artifact: "+err.Error(), 1)
+	}
+	layer, err := manifest.ArtifactLayer()
+	if err != nil {
+		return cli.NewExitError(errors.Wrapf(err, "%s is not a Mender Artifact", ref), 1)
+	}
+
+	output := c.String("output-path")
+	if output == "" {
+		// Never let the registry choose a path outside the working directory.
+		output = filepath.Base(layer.Annotations[oci.AnnotationTitle])
+		if output == "." || output == "/" || output == ".." {
+			output = "artifact.mender"
+		}
+	}
+
+	blob, err := client.FetchBlob(ref, layer)
+	if err != nil {
+		return cli.NewExitError("Can not pull artifact: "+err.Error(), 1)
+	}
+	defer blob.Close()
+
+	tmp, err := utils.TempFile(filepath.Dir(output), "pull")
+	if err != nil {
+		return cli.NewExitError(
+			errors.Wrap(err, "Can not create temporary file for storing artifact"), 1)
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	if _, err = io.Copy(tmp, blob); err != nil {
+		return cli.NewExitError("Can not pull artifact: "+err.Error(), 1)
+	}
+	if err = tmp.Close(); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return cli.NewExitError("Can not store artifact: "+err.Error(), 1)
+	}
+	fmt.Printf("Pulled %s to %s\n", ref, output)
+	return nil
+}
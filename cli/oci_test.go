@@ -0,0 +1,113 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/oci"
+)
+
+// newTestOCIRegistry serves an in-memory OCI registry which requires basic
+// authentication.
+func newTestOCIRegistry(t *testing.T) (string, map[string][]byte) {
+	var lock sync.Mutex
+	content := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/release/")
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "/v2/release/upload")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			if path == "upload" {
+				path = "blobs/" + r.URL.Query().Get("digest")
+			}
+			content[path] = data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			data, ok := content[path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "oci://" + strings.TrimPrefix(srv.URL, "http://") + "/release", content
+}
+
+func TestPushPullArtifact(t *testing.T) {
+	registry, content := newTestOCIRegistry(t)
+	dir := t.TempDir()
+	rootfs := filepath.Join(dir, "update.ext4")
+	require.NoError(t, os.WriteFile(rootfs, []byte("my update"), 0644))
+	art := filepath.Join(dir, "release.mender")
+	require.NoError(t, Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-o", art, "-n", "release-1", "-t", "vexpress", "-t", "beaglebone", "-f", rootfs}))
+
+	t.Setenv("MENDER_ARTIFACT_OCI_PASSWORD", "secret")
+	err := Run([]string{"mender-artifact", "push", "--plain-http",
+		"--username", "user", art, registry + ":1.0"})
+	require.NoError(t, err)
+
+	var manifest oci.Manifest
+	require.NoError(t, json.Unmarshal(content["manifests/1.0"], &manifest))
+	assert.Equal(t, oci.ArtifactType, manifest.ArtifactType)
+	assert.Equal(t, "release-1", manifest.Annotations[oci.AnnotationName])
+	assert.Equal(t, "vexpress,beaglebone", manifest.Annotations[oci.AnnotationDeviceTypes])
+	assert.Contains(t, manifest.Annotations[oci.AnnotationProvides],
+		`"artifact_name":"release-1"`)
+	require.Len(t, manifest.Layers, 1)
+	assert.Equal(t, "release.mender", manifest.Layers[0].Annotations[oci.AnnotationTitle])
+
+	pulled := filepath.Join(dir, "pulled.mender")
+	err = Run([]string{"mender-artifact", "pull", "--plain-http",
+		"--username", "user", "-o", pulled, registry + ":1.0"})
+	require.NoError(t, err)
+	expected, err := os.ReadFile(art)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(pulled)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	err = Run([]string{"mender-artifact", "pull", "--plain-http", registry + ":1.0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a username and password")
+
+	err = Run([]string{"mender-artifact", "push", art, "registry/release:1.0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not an oci:// reference")
+}
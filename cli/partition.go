@@ -26,17 +26,21 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/ext4"
 	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 const (
 	fat = iota
 	ext
+	btrfs
+	xfs
 	unsupported
 
 	// empty placeholder, so that we can write virtualImage.Open()
@@ -44,7 +48,8 @@ const (
 	virtualImage vImage = 1
 )
 
-var errFsTypeUnsupported = errors.New("mender-artifact can only modify ext4 and vfat payloads")
+var errFsTypeUnsupported = errors.New(
+	"mender-artifact can only modify ext4, vfat, btrfs and xfs payloads")
 var errBlkidNotFound = errors.New("`blkid` binary not found on the system")
 
 type VPImage interface {
@@ -66,12 +71,40 @@ type VPFile interface {
 type VPDir interface {
 	io.Closer
 	Create() error
+	ReadDir() ([]VPDirEntry, error)
+}
+
+// VPOwner is implemented by the files and directories in images whose
+// filesystem has file ownership. Chown applies to the file in the image, so
+// data written to a VPFile must be flushed by closing it first.
+type VPOwner interface {
+	Chown(uid, gid int) error
+}
+
+// chownVP sets the owner and group of the file or directory in the image.
+func chownVP(v interface{}, uid, gid int) error {
+	owner, ok := v.(VPOwner)
+	if !ok {
+		return errors.New("the filesystem does not have file ownership")
+	}
+	return owner.Chown(uid, gid)
+}
+
+// VPDirEntry is a single entry of a directory in an Artifact or on an sdimg.
+type VPDirEntry struct {
+	Name    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
 }
 
 type partition struct {
 	offset string
 	size   string
 	path   string
+	// labels are the GPT partition name and the filesystem label, when
+	// the partition has them.
+	labels []string
 }
 
 type ModImageBase struct {
@@ -121,7 +154,9 @@ func (v vImage) Open(
 	}
 	defer art.Close()
 
+	imageProgress.Stage("Reading %s", imgname)
 	aReader := areader.NewReader(art)
+	imageProgress.ShowReading(aReader)
 	err = aReader.ReadArtifact()
 	if err == nil {
 		// we have VALID artifact,
@@ -260,6 +295,11 @@ func (v *vImageAndFile) CopyTo(hostFile string) error {
 	return v.file.CopyTo(hostFile)
 }
 
+func (v *vImageAndFile) Chown(uid, gid int) error {
+	v.image.dirtyImage()
+	return chownVP(v.file, uid, gid)
+}
+
 func (v *vImageAndFile) CopyFrom(hostFile string) error {
 	return v.file.CopyFrom(hostFile)
 }
@@ -280,6 +320,15 @@ func (v *vImageAndDir) Create() error {
 	return v.dir.Create()
 }
 
+func (v *vImageAndDir) Chown(uid, gid int) error {
+	v.image.dirtyImage()
+	return chownVP(v.dir, uid, gid)
+}
+
+func (v *vImageAndDir) ReadDir() ([]VPDirEntry, error) {
+	return v.dir.ReadDir()
+}
+
 func (v *vImageAndDir) Close() error {
 	dirErr := v.dir.Close()
 	imageErr := v.image.Close()
@@ -304,7 +353,7 @@ func (i *ModImageArtifact) OpenDir(fpath string) (VPDir, error) {
 // Closes and repacks the artifact or sdimg.
 func (i *ModImageArtifact) Close() error {
 	if i.unpackDir != "" {
-		defer os.RemoveAll(i.unpackDir)
+		defer utils.RemoveTemp(i.unpackDir)
 	}
 	if i.dirty {
 		return repackArtifact(i.comp, i.key, i.unpackedArtifact)
@@ -328,7 +377,7 @@ func (i *ModImageSdimg) OpenDir(fpath string) (VPDir, error) {
 func (i *ModImageSdimg) Close() error {
 	for _, cand := range i.candidates {
 		if cand.path != "" && cand.path != i.path {
-			defer os.RemoveAll(cand.path)
+			defer utils.RemoveTemp(cand.path)
 		}
 	}
 	if i.dirty {
@@ -342,11 +391,11 @@ func (i *ModImageSdimg) dirtyImage() {
 }
 
 func (i *ModImageRaw) Open(fpath string) (VPFile, error) {
-	return newExtFile(i.path, fpath)
+	return newRootfsFile(i.path, fpath)
 }
 
 func (i *ModImageRaw) OpenDir(fpath string) (VPDir, error) {
-	return newExtDir(i.path, fpath)
+	return newRootfsDir(i.path, fpath)
 }
 
 func (i *ModImageRaw) Close() error {
@@ -372,8 +421,17 @@ func parseImgPath(imgpath string) (imgname, fpath string, err error) {
 }
 
 // imgFilesystemtype returns the filesystem type of a partition.
-// Currently only distinguishes ext from fat.
+// Currently distinguishes ext, fat, btrfs and xfs.
 func imgFilesystemType(imgpath string) (int, error) {
+	if ext4.Probe(imgpath) {
+		return ext, nil
+	}
+	switch probeMountedFilesystem(imgpath) {
+	case "btrfs":
+		return btrfs, nil
+	case "xfs":
+		return xfs, nil
+	}
 	bin, err := utils.GetBinaryPath("blkid")
 	if err != nil {
 		return unsupported, errBlkidNotFound
@@ -403,12 +461,20 @@ func imgFilesystemType(imgpath string) (int, error) {
 //	16     Usage or syntax error
 //	32     Checking canceled by user request
 //	128    Shared-library error
+//
+// If fsck.ext4 is not installed, ext4 images are only checked for being
+// readable by the built-in ext4 support.
 func runFsck(image, fstype string) error {
 	bin, err := utils.GetBinaryPath("fsck." + fstype)
 	if err != nil {
+		if fstype == "ext4" {
+			if fs, err := ext4.Open(image, os.O_RDONLY); err == nil {
+				return fs.Close()
+			}
+		}
 		return errors.Wrap(err, "fsck command not found")
 	}
-	cmd := exec.Command(bin, "-a", image)
+	cmd := ioCommand(bin, "-a", image)
 	if err := cmd.Run(); err != nil {
 		// try to get the exit code
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -436,7 +502,18 @@ type sdimgFile []VPFile
 type sdimgDir []VPDir
 
 func isSparsePartition(part partition) bool {
+	if part.path == "" {
+		// The image has no such partition.
+		return true
+	}
+	if probeMountedFilesystem(part.path) != "" {
+		return false
+	}
 	// NOTE: Basically just checking for a filesystem
+	if fs, err := ext4.Open(part.path, os.O_RDONLY); err == nil {
+		fs.Close()
+		return false
+	}
 	_, err := debugfsExecuteCommand("stat /", part.path)
 	return err != nil
 }
@@ -457,51 +534,33 @@ func filterSparsePartitions(parts []partition) []partition {
 // for {data,/[u]boot} this is one partition.
 // for rootfs{a,b}, this is the two partitions (unless one of them is unpopulated,
 // then only the one with data is returned)
-func getFilesystems(fpath string, modcands []partition) ([]partition, string) {
-
-	reg := regexp.MustCompile("/(uboot|boot/(efi|grub))[/]")
-
+func getFilesystems(fpath string, modcands []partition) ([]partition, string, error) {
 	var filesystems []partition
-	if strings.HasPrefix(fpath, "/data") {
-		// The data dir is not a directory in the data partition
-		fpath = strings.TrimPrefix(fpath, "/data/")
-		filesystems = append(filesystems, modcands[3])
-	} else if reg.MatchString(fpath) {
-		// /uboot, /boot/efi, /boot/grub are not directories on the boot partition.
-		fpath = reg.ReplaceAllString(fpath, "")
-		filesystems = append(filesystems, modcands[0])
+	indexes, fpath := sdimgTargets(fpath)
+	if len(indexes) == 1 {
+		if modcands[indexes[0]].path == "" {
+			return nil, fpath, errors.Errorf("the image has no %s partition",
+				sdimgPartitionNames[indexes[0]])
+		}
+		filesystems = append(filesystems, modcands[indexes[0]])
 	} else {
 		filesystems = append(filesystems, filterSparsePartitions(modcands[1:3])...)
 	}
 
-	return filesystems, fpath
+	return filesystems, fpath, nil
 }
 
 func newSDImgFile(image *ModImageSdimg, fpath string, modcands []partition) (sdimgFile, error) {
-	if len(modcands) < 4 {
-		return nil, fmt.Errorf("newSDImgFile: %d partitions found, 4 needed", len(modcands))
+	filesystems, pfpath, err := getFilesystems(fpath, modcands)
+	if err != nil {
+		return nil, err
 	}
 
-	filesystems, pfpath := getFilesystems(fpath, modcands)
-
 	// Since boot partitions can be either fat or ext, return a
 	// readWriteCloser dependent upon the underlying filesystem type.
 	var sdimgFile sdimgFile
 	for _, fs := range filesystems {
-		fstype, err := imgFilesystemType(fs.path)
-		if err != nil {
-			return nil, errors.Wrap(err, "partition: error reading file-system type on partition")
-		}
-		var f VPFile
-		switch fstype {
-		case fat:
-			f, err = newFatFile(fs.path, pfpath)
-		case ext:
-			f, err = newExtFile(fs.path, pfpath)
-		case unsupported:
-			err = errors.New("partition: unsupported filesystem")
-
-		}
+		f, err := newFilesystemFile(fs.path, pfpath)
 		if err != nil {
 			sdimgFile.Close()
 			return nil, err
@@ -512,30 +571,16 @@ func newSDImgFile(image *ModImageSdimg, fpath string, modcands []partition) (sdi
 }
 
 func newSDImgDir(image *ModImageSdimg, fpath string, modcands []partition) (sdimgDir, error) {
-	if len(modcands) < 4 {
-		return nil, fmt.Errorf("newSDImgDir: %d partitions found, 4 needed", len(modcands))
+	filesystems, pfpath, err := getFilesystems(fpath, modcands)
+	if err != nil {
+		return nil, err
 	}
 
-	filesystems, pfpath := getFilesystems(fpath, modcands)
-
 	// Since boot partitions can be either fat or ext, return a
 	// Closer dependent upon the underlying filesystem type.
 	var sdimgDir sdimgDir
 	for _, fs := range filesystems {
-		fstype, err := imgFilesystemType(fs.path)
-		if err != nil {
-			return nil, errors.Wrap(err, "partition: error reading file-system type on partition")
-		}
-		var d VPDir
-		switch fstype {
-		case fat:
-			d, err = newFatDir(fs.path, pfpath)
-		case ext:
-			d, err = newExtDir(fs.path, pfpath)
-		case unsupported:
-			err = errors.New("partition: unsupported filesystem")
-
-		}
+		d, err := newFilesystemDir(fs.path, pfpath)
 		if err != nil {
 			sdimgDir.Close()
 			return nil, err
@@ -545,6 +590,46 @@ func newSDImgDir(image *ModImageSdimg, fpath string, modcands []partition) (sdim
 	return sdimgDir, nil
 }
 
+// newFilesystemFile opens the file in the filesystem image, using the
+// tools for its filesystem type.
+func newFilesystemFile(imagePath, imageFilePath string) (VPFile, error) {
+	fstype, err := imgFilesystemType(imagePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "partition: error reading file-system type on partition")
+	}
+	switch fstype {
+	case fat:
+		return newFatFile(imagePath, imageFilePath)
+	case ext:
+		return newExtFile(imagePath, imageFilePath)
+	case btrfs:
+		return newMountedFile(imagePath, imageFilePath, "btrfs")
+	case xfs:
+		return newMountedFile(imagePath, imageFilePath, "xfs")
+	}
+	return nil, errors.New("partition: unsupported filesystem")
+}
+
+// newFilesystemDir opens the directory in the filesystem image, using the
+// tools for its filesystem type.
+func newFilesystemDir(imagePath, imageFilePath string) (VPDir, error) {
+	fstype, err := imgFilesystemType(imagePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "partition: error reading file-system type on partition")
+	}
+	switch fstype {
+	case fat:
+		return newFatDir(imagePath, imageFilePath)
+	case ext:
+		return newExtDir(imagePath, imageFilePath)
+	case btrfs:
+		return newMountedDir(imagePath, imageFilePath, "btrfs")
+	case xfs:
+		return newMountedDir(imagePath, imageFilePath, "xfs")
+	}
+	return nil, errors.New("partition: unsupported filesystem")
+}
+
 // Write forces a write from the underlying writers sdimgFile wraps.
 func (p sdimgFile) Write(b []byte) (int, error) {
 	for _, part := range p {
@@ -585,6 +670,16 @@ func (p sdimgFile) CopyFrom(hostFile string) error {
 	return p[0].CopyFrom(hostFile)
 }
 
+// Chown sets the owner of the file on all the partitions holding it.
+func (p sdimgFile) Chown(uid, gid int) error {
+	for _, part := range p {
+		if err := chownVP(part, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Read reads a file from an sdimg.
 func (p sdimgFile) Delete(recursive bool) (err error) {
 	for _, part := range p {
@@ -620,6 +715,24 @@ func (p sdimgDir) Create() (err error) {
 	return nil
 }
 
+// Chown sets the owner of the directory on all the partitions holding it.
+func (p sdimgDir) Chown(uid, gid int) error {
+	for _, part := range p {
+		if err := chownVP(part, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDir lists the directory on the first partition holding it.
+func (p sdimgDir) ReadDir() ([]VPDirEntry, error) {
+	if len(p) == 0 {
+		return nil, errors.New("sdimgDir: no partition found")
+	}
+	return p[0].ReadDir()
+}
+
 // Close closes the underlying closers.
 func (p sdimgDir) Close() (err error) {
 	if p == nil {
@@ -654,7 +767,7 @@ func newArtifactExtFile(
 		)
 	}
 
-	return newExtFile(imgpath, fpath)
+	return newRootfsFile(imgpath, fpath)
 }
 
 func newArtifactExtDir(
@@ -675,7 +788,25 @@ func newArtifactExtDir(
 		)
 	}
 
-	return newExtDir(imgpath, fpath)
+	return newRootfsDir(imgpath, fpath)
+}
+
+// newRootfsFile opens the file in a rootfs image, which is ext unless it is
+// recognized as btrfs or xfs.
+func newRootfsFile(imagePath, imageFilePath string) (VPFile, error) {
+	if fstype := probeMountedFilesystem(imagePath); fstype != "" {
+		return newMountedFile(imagePath, imageFilePath, fstype)
+	}
+	return newExtFile(imagePath, imageFilePath)
+}
+
+// newRootfsDir opens the directory in a rootfs image, which is ext unless it
+// is recognized as btrfs or xfs.
+func newRootfsDir(imagePath, imageFilePath string) (VPDir, error) {
+	if fstype := probeMountedFilesystem(imagePath); fstype != "" {
+		return newMountedDir(imagePath, imageFilePath, fstype)
+	}
+	return newExtDir(imagePath, imageFilePath)
 }
 
 // extFile wraps partition and implements ReadWriteCloser
@@ -698,13 +829,23 @@ func newExtFile(imagePath, imageFilePath string) (e *extFile, err error) {
 	}
 
 	// Check that the given directory exists.
-	_, err = debugfsExecuteCommand(fmt.Sprintf("cd %s", filepath.Dir(imageFilePath)), imagePath)
+	native, err := withExt4(imagePath, os.O_RDONLY, func(fs *ext4.FS) error {
+		info, err := fs.Stat(filepath.Dir(imageFilePath))
+		if err == nil && !info.IsDir() {
+			return ext4.ErrNotDir
+		}
+		return err
+	})
+	if !native {
+		_, err = debugfsExecuteCommand(
+			fmt.Sprintf("cd %s", filepath.Dir(imageFilePath)), imagePath)
+	}
 	if err != nil {
 		return nil, fmt.Errorf(
 			"The directory: %s does not exist in the image", filepath.Dir(imageFilePath),
 		)
 	}
-	tmpf, err := ioutil.TempFile("", "mendertmp-extfile")
+	tmpf, err := utils.TempFile("", "extfile")
 	// Cleanup resources in case of error.
 	e = &extFile{
 		imagePath:     imagePath,
@@ -727,6 +868,24 @@ func newExtDir(imagePath, imageFilePath string) (e *extDir, err error) {
 	return e, err
 }
 
+// withExt4 runs fn on the image using the built-in ext4 support. It returns
+// false if the image, or the operation, is not supported by it, in which case
+// the caller falls back to debugfs.
+func withExt4(image string, flag int, fn func(fs *ext4.FS) error) (bool, error) {
+	fs, err := ext4.Open(image, flag)
+	if err != nil {
+		return false, nil
+	}
+	err = fn(fs)
+	if cerr := fs.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, ext4.ErrUnsupported) {
+		return false, nil
+	}
+	return true, err
+}
+
 // Write reads all bytes from b into the partitionFile using debugfs.
 func (ef *extFile) Write(b []byte) (int, error) {
 	n, err := ef.tmpf.Write(b)
@@ -736,12 +895,23 @@ func (ef *extFile) Write(b []byte) (int, error) {
 
 // Read reads all bytes from the filepath on the partition image into b
 func (ef *extFile) Read(b []byte) (int, error) {
+	var data []byte
+	native, err := withExt4(ef.imagePath, os.O_RDONLY, func(fs *ext4.FS) (err error) {
+		data, err = fs.ReadFile(ef.imageFilePath)
+		return err
+	})
+	if native {
+		if err != nil {
+			return 0, errors.Wrap(err, "extFile: ReadError")
+		}
+		return copy(b, data), io.EOF
+	}
 	str, err := debugfsCopyFile(ef.imageFilePath, ef.imagePath)
-	defer os.RemoveAll(str) // ignore error removing tmp-dir
+	defer utils.RemoveTemp(str) // ignore error removing tmp-dir
 	if err != nil {
 		return 0, errors.Wrap(err, "extFile: ReadError: debugfsCopyFile failed")
 	}
-	data, err := ioutil.ReadFile(filepath.Join(str, filepath.Base(ef.imageFilePath)))
+	data, err = ioutil.ReadFile(filepath.Join(str, filepath.Base(ef.imageFilePath)))
 	if err != nil {
 		return 0, errors.Wrapf(
 			err,
@@ -753,13 +923,54 @@ func (ef *extFile) Read(b []byte) (int, error) {
 }
 
 func (ef *extFile) CopyTo(hostFile string) error {
+	if native, err := replaceExt4File(ef.imageFilePath, hostFile, ef.imagePath); native {
+		return err
+	}
 	if err := debugfsReplaceFile(ef.imageFilePath, hostFile, ef.imagePath); err != nil {
 		return err
 	}
 	return nil
 }
 
+// replaceExt4File writes the host file to the image with the built-in ext4
+// support, keeping the permissions of the host file like debugfs' write.
+func replaceExt4File(imageFile, hostFile, image string) (bool, error) {
+	return withExt4(image, os.O_RDWR, func(fs *ext4.FS) error {
+		f, err := os.Open(hostFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return fs.WriteFile(imageFile, f, info.Size(), info.Mode().Perm())
+	})
+}
+
 func (ef *extFile) CopyFrom(hostFile string) error {
+	native, err := withExt4(ef.imagePath, os.O_RDONLY, func(fs *ext4.FS) error {
+		f, err := fs.Open(ef.imageFilePath)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("The file: %s does not exist in the image", ef.imageFilePath)
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+		out, err := os.Create(hostFile)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		if _, err = io.Copy(out, f); err != nil {
+			return err
+		}
+		return out.Chmod(f.Stat().Mode().Perm())
+	})
+	if native {
+		return err
+	}
 	// Get the file permissions
 	d, err := debugfsExecuteCommand(fmt.Sprintf("stat %s", ef.imageFilePath), ef.imagePath)
 	if err != nil {
@@ -801,7 +1012,35 @@ func (ef *extFile) CopyFrom(hostFile string) error {
 	return nil
 }
 
+func (ef *extFile) Chown(uid, gid int) error {
+	return chownExt(ef.imagePath, ef.imageFilePath, uid, gid)
+}
+
+// chownExt sets the owner and group of the file in the ext image.
+func chownExt(image, imageFile string, uid, gid int) error {
+	native, err := withExt4(image, os.O_RDWR, func(fs *ext4.FS) error {
+		return fs.Chown(imageFile, uid, gid)
+	})
+	if native {
+		return err
+	}
+	return debugfsChown(imageFile, image, uid, gid)
+}
+
 func (ef *extFile) Delete(recursive bool) (err error) {
+	native, err := withExt4(ef.imagePath, os.O_RDWR, func(fs *ext4.FS) error {
+		info, err := fs.Lstat(ef.imageFilePath)
+		if err != nil {
+			return err
+		}
+		if recursive && info.IsDir() {
+			return fs.RemoveAll(ef.imageFilePath)
+		}
+		return fs.Remove(ef.imageFilePath)
+	})
+	if native {
+		return err
+	}
 	err = debugfsRemoveFileOrDir(ef.imageFilePath, ef.imagePath, recursive)
 	if err != nil {
 		return err
@@ -818,9 +1057,13 @@ func (ef *extFile) Close() (err error) {
 		defer func() {
 			// Ignore tmp-errors
 			ef.tmpf.Close()
-			os.Remove(ef.tmpf.Name())
+			utils.RemoveTemp(ef.tmpf.Name())
 		}()
 		if ef.flush {
+			if native, err := replaceExt4File(
+				ef.imageFilePath, ef.tmpf.Name(), ef.imagePath); native {
+				return err
+			}
 			err = debugfsReplaceFile(ef.imageFilePath, ef.tmpf.Name(), ef.imagePath)
 			if err != nil {
 				return err
@@ -830,11 +1073,98 @@ func (ef *extFile) Close() (err error) {
 	return err
 }
 
+func (ed *extDir) Chown(uid, gid int) error {
+	return chownExt(ed.imagePath, ed.imageFilePath, uid, gid)
+}
+
 func (ed *extDir) Create() error {
-	err := debugfsMakeDir(ed.imageFilePath, ed.imagePath)
+	native, err := withExt4(ed.imagePath, os.O_RDWR, func(fs *ext4.FS) error {
+		return fs.MkdirAll(ed.imageFilePath, 0755)
+	})
+	if native {
+		return err
+	}
+	err = debugfsMakeDir(ed.imageFilePath, ed.imagePath)
 	return err
 }
 
+// debugfsLsLine matches an entry in the output of debugfs' ls -l:
+// inode, mode, (links), uid, gid, size, modification time and name.
+var debugfsLsLine = regexp.MustCompile(
+	`(?m)^ *[0-9]+ +([0-7]+) +\([0-9]+\) +[0-9]+ +[0-9]+ +([0-9]+) +(\S+ [0-9]+:[0-9]+) (.*)$`,
+)
+
+// ReadDir lists the directory using debugfs' ls -l. debugfs runs without a
+// TZ, so the modification times it prints are in UTC.
+func (ed *extDir) ReadDir() ([]VPDirEntry, error) {
+	var entries []VPDirEntry
+	native, err := withExt4(ed.imagePath, os.O_RDONLY, func(fs *ext4.FS) error {
+		infos, err := fs.ReadDir(ed.imageFilePath)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			st := info.Sys().(*ext4.Stat)
+			entries = append(entries, VPDirEntry{
+				Name:    info.Name(),
+				Mode:    extFileMode(uint32(st.Mode)),
+				Size:    info.Size(),
+				ModTime: info.ModTime().UTC(),
+			})
+		}
+		return nil
+	})
+	if native {
+		if err != nil {
+			return nil, errors.Wrapf(err, "extDir: can not list directory %s", ed.imageFilePath)
+		}
+		return entries, nil
+	}
+	out, err := debugfsExecuteCommand(fmt.Sprintf("ls -l %s", ed.imageFilePath), ed.imagePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "extDir: can not list directory %s", ed.imageFilePath)
+	}
+	for _, m := range debugfsLsLine.FindAllStringSubmatch(out.String(), -1) {
+		if m[4] == "." || m[4] == ".." {
+			continue
+		}
+		mode, err := strconv.ParseUint(m[1], 8, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extDir: invalid mode of %s", m[4])
+		}
+		size, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "extDir: invalid size of %s", m[4])
+		}
+		mtime, err := time.Parse("2-Jan-2006 15:04", m[3])
+		if err != nil {
+			return nil, errors.Wrapf(err, "extDir: invalid modification time of %s", m[4])
+		}
+		entries = append(entries, VPDirEntry{
+			Name:    m[4],
+			Mode:    extFileMode(uint32(mode)),
+			Size:    size,
+			ModTime: mtime,
+		})
+	}
+	return entries, nil
+}
+
+// extFileMode converts an ext4 inode mode to an os.FileMode.
+func extFileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	switch mode & 0170000 {
+	case 0100000:
+	case 0040000:
+		m |= os.ModeDir
+	case 0120000:
+		m |= os.ModeSymlink
+	default:
+		m |= os.ModeIrregular
+	}
+	return m
+}
+
 // Close closes the temporary file held by partitionFile path.
 func (ed *extDir) Close() (err error) {
 	if ed == nil {
@@ -861,7 +1191,7 @@ func newFatFile(imagePath, imageFilePath string) (*fatFile, error) {
 		return nil, err
 	}
 
-	tmpf, err := ioutil.TempFile("", "mendertmp-fatfile")
+	tmpf, err := utils.TempFile("", "fatfile")
 	ff := &fatFile{
 		imagePath:     imagePath,
 		imageFilePath: imageFilePath,
@@ -884,7 +1214,7 @@ func newFatDir(imagePath, imageFilePath string) (fd *fatDir, err error) {
 
 // Read Dump the file contents to stdout, and capture, using MTools' mtype
 func (f *fatFile) Read(b []byte) (n int, err error) {
-	cmd := exec.Command("mtype", "-n", "-i", f.imagePath, "::"+f.imageFilePath)
+	cmd := ioCommand("mtype", "-n", "-i", f.imagePath, "::"+f.imageFilePath)
 	dbuf := bytes.NewBuffer(nil)
 	cmd.Stdout = dbuf // capture Stdout
 	if err = cmd.Run(); err != nil {
@@ -904,7 +1234,7 @@ func (f *fatFile) Write(b []byte) (n int, err error) {
 }
 
 func (f *fatFile) CopyTo(hostFile string) error {
-	cmd := exec.Command("mcopy", "-oi", f.imagePath, hostFile, "::"+f.imageFilePath)
+	cmd := ioCommand("mcopy", "-oi", f.imagePath, hostFile, "::"+f.imageFilePath)
 	data := bytes.NewBuffer(nil)
 	cmd.Stdout = data
 	if err := cmd.Run(); err != nil {
@@ -914,7 +1244,7 @@ func (f *fatFile) CopyTo(hostFile string) error {
 }
 
 func (f *fatFile) CopyFrom(hostFile string) error {
-	cmd := exec.Command("mcopy", "-n", "-i", f.imagePath, "::"+f.imageFilePath, hostFile)
+	cmd := ioCommand("mcopy", "-n", "-i", f.imagePath, "::"+f.imageFilePath, hostFile)
 	dbuf := bytes.NewBuffer(nil)
 	cmd.Stdout = dbuf // capture Stdout
 	if err := cmd.Run(); err != nil {
@@ -945,10 +1275,10 @@ func (f *fatFile) Close() (err error) {
 	if f.tmpf != nil {
 		defer func() {
 			f.tmpf.Close()
-			os.Remove(f.tmpf.Name())
+			utils.RemoveTemp(f.tmpf.Name())
 		}()
 		if f.flush {
-			cmd := exec.Command(
+			cmd := ioCommand(
 				"mcopy",
 				"-n",
 				"-i",
@@ -967,7 +1297,11 @@ func (f *fatFile) Close() (err error) {
 }
 
 func (fd *fatDir) Create() (err error) {
-	cmd := exec.Command("mmd", fd.imageFilePath)
+	// If the directory already exists, just return
+	if exec.Command("mdir", "-i", fd.imagePath, "::"+fd.imageFilePath).Run() == nil {
+		return nil
+	}
+	cmd := exec.Command("mmd", "-i", fd.imagePath, "::"+fd.imageFilePath)
 	data := bytes.NewBuffer(nil)
 	cmd.Stdout = data
 	if err := cmd.Run(); err != nil {
@@ -976,74 +1310,122 @@ func (fd *fatDir) Create() (err error) {
 	return err
 }
 
+// mdirLine matches an entry in the output of MTools' mdir: the short name,
+// extension, size or <DIR>, modification date and time, and the long name if
+// there is one.
+var mdirLine = regexp.MustCompile(
+	`(?m)^(\S+) +(\S*) +(<DIR>|[0-9]+) +([0-9]{4}-[0-9]{2}-[0-9]{2}) +([0-9]+:[0-9]{2}) *(.*)$`,
+)
+
+// ReadDir lists the directory using MTools' mdir. Since vfat has no
+// permissions, all the entries get default ones.
+func (fd *fatDir) ReadDir() ([]VPDirEntry, error) {
+	cmd := exec.Command("mdir", "-i", fd.imagePath, "::"+fd.imageFilePath)
+	data := bytes.NewBuffer(nil)
+	cmd.Stdout = data
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrap(err, "fatDir: ReadDir: MTools execution failed")
+	}
+	var entries []VPDirEntry
+	for _, m := range mdirLine.FindAllStringSubmatch(data.String(), -1) {
+		entry := VPDirEntry{Name: strings.TrimSpace(m[6]), Mode: 0644}
+		if entry.Name == "" {
+			entry.Name = m[1]
+			if m[2] != "" {
+				entry.Name += "." + m[2]
+			}
+		}
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		if m[3] == "<DIR>" {
+			entry.Mode = os.ModeDir | 0755
+		} else {
+			entry.Size, _ = strconv.ParseInt(m[3], 10, 64)
+		}
+		entry.ModTime, _ = time.Parse("2006-01-02 15:04", m[4]+" "+m[5])
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
 func (fd *fatDir) Close() (err error) {
 	if fd == nil {
 		return nil
 	}
-	os.Remove(fd.imagePath)
 	return err
 }
 
 func processSdimg(image string) (VPImage, error) {
-	bin, err := utils.GetBinaryPath("parted")
-	if err != nil {
-		return nil, errors.Wrap(err, "`parted` binary not found on the system")
-	}
-	out, err := exec.Command(bin, image, "unit s", "print").Output()
-	if err != nil {
-		return nil, errors.Wrap(err, "can not execute `parted` command or image is broken; "+
-			"make sure parted is available in your system and is in the $PATH")
-	}
-
-	reg := regexp.MustCompile(
-		`(?m)^[[:blank:]][0-9]+[[:blank:]]+([0-9]+)s[[:blank:]]+[0-9]+s[[:blank:]]+([0-9]+)s`,
-	)
-	partitionMatch := reg.FindAllStringSubmatch(string(out), -1)
-
-	if len(partitionMatch) == 4 {
-		partitions := make([]partition, 0)
-		// we will have three groups per each entry in the partition table
-		for i := 0; i < 4; i++ {
-			single := partitionMatch[i]
-			partitions = append(partitions, partition{offset: single[1], size: single[2]})
-		}
-		if partitions, err = extractFromSdimg(partitions, image); err != nil {
-			return nil, err
-		}
-		return &ModImageSdimg{
-			ModImageBase: ModImageBase{
-				path:  image,
-				dirty: false,
-			},
-			candidates: partitions,
-		}, nil
-		// if we have single ext file there is no need to mount it
-
-	} else if len(partitionMatch) == 1 && partitionMatch[0][1] == "0" {
-		// For one partition match which has an offset of zero, we
-		// assume it is a raw filesystem image.
+	partitions, err := readPartitionTable(image)
+	if err == errNoPartitionTable && isFilesystemImage(image) {
 		return &ModImageRaw{
 			ModImageBase: ModImageBase{
 				path:  image,
 				dirty: false,
 			},
 		}, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "invalid partition table or image is broken")
+	}
+	if partitions, err = assignPartitionRoles(partitions); err != nil {
+		return nil, errors.Wrap(err, "invalid partition table")
+	}
+	if partitions, err = extractFromSdimg(partitions, image); err != nil {
+		return nil, err
+	}
+	return &ModImageSdimg{
+		ModImageBase: ModImageBase{
+			path:  image,
+			dirty: false,
+		},
+		candidates: partitions,
+	}, nil
+}
+
+// sectorSize is the size of the sectors in which the partition table of
+// sdimgs gives the offsets and sizes of the partitions.
+const sectorSize = 512
+
+// byteRange returns the offset and the size of the partition in bytes.
+func (p partition) byteRange() (int64, int64, error) {
+	offset, err := strconv.ParseInt(p.offset, 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid partition offset %q", p.offset)
+	}
+	size, err := strconv.ParseInt(p.size, 10, 64)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "invalid partition size %q", p.size)
 	}
-	return nil, fmt.Errorf("invalid partition table: %s", string(out))
+	return offset * sectorSize, size * sectorSize, nil
 }
 
 func extractFromSdimg(partitions []partition, image string) ([]partition, error) {
+	img, err := os.Open(image)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not open sdimg")
+	}
+	defer img.Close()
 	for i, part := range partitions {
-		tmp, err := ioutil.TempFile("", "mender-modify-image")
+		if part.offset == "" {
+			// The image has no such partition.
+			continue
+		}
+		offset, size, err := part.byteRange()
+		if err != nil {
+			return nil, err
+		}
+		tmp, err := utils.TempFile("", "partition")
 		if err != nil {
 			return nil, errors.Wrap(err, "can not create temp file for storing image")
 		}
-		if err = tmp.Close(); err != nil {
-			return nil, errors.Wrapf(err, "can not close temporary file: %s", tmp.Name())
+		imageProgress.Stage("Extracting partition %d of %s", i+1, image)
+		_, err = io.Copy(tmp, imageProgress.Reader(io.NewSectionReader(img, offset, size), size))
+		if closeErr := tmp.Close(); err == nil && closeErr != nil {
+			err = errors.Wrapf(closeErr, "can not close temporary file: %s", tmp.Name())
 		}
-		cmd := exec.Command("dd", "if="+image, "of="+tmp.Name(),
-			"skip="+part.offset, "count="+part.size)
-		if err = cmd.Run(); err != nil {
+		if err != nil {
+			utils.RemoveTemp(tmp.Name())
 			return nil, errors.Wrap(err, "can not extract image from sdimg")
 		}
 		partitions[i].path = tmp.Name()
@@ -1052,12 +1434,38 @@ func extractFromSdimg(partitions []partition, image string) ([]partition, error)
 }
 
 func repackSdimg(partitions []partition, image string) error {
-	for _, part := range partitions {
-		if err := exec.Command("dd", "if="+part.path, "of="+image,
-			"seek="+part.offset, "count="+part.size,
-			"conv=notrunc").Run(); err != nil {
+	img, err := os.OpenFile(image, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "can not open sdimg")
+	}
+	defer img.Close()
+	for i, part := range partitions {
+		if part.offset == "" {
+			continue
+		}
+		offset, size, err := part.byteRange()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(part.path)
+		if err != nil {
+			return errors.Wrap(err, "can not copy image back to sdimg")
+		}
+		info, err := f.Stat()
+		if err == nil && info.Size() < size {
+			size = info.Size()
+		}
+		if err == nil {
+			_, err = img.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			imageProgress.Stage("Writing partition %d back to %s", i+1, image)
+			_, err = io.CopyN(img, imageProgress.Reader(f, size), size)
+		}
+		f.Close()
+		if err != nil {
 			return errors.Wrap(err, "can not copy image back to sdimg")
 		}
 	}
-	return nil
+	return img.Close()
 }
@@ -0,0 +1,358 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// Superblock magics of the filesystems which are modified by mounting them.
+const (
+	btrfsMagicOffset = 0x10040
+	btrfsMagic       = "_BHRfS_M"
+	xfsMagic         = "XFSB"
+)
+
+// probeMountedFilesystem returns "btrfs" or "xfs" if image holds one of
+// these filesystems, and "" otherwise.
+func probeMountedFilesystem(image string) string {
+	f, err := os.Open(image)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	magic := make([]byte, 8)
+	if _, err = f.ReadAt(magic[:4], 0); err == nil && string(magic[:4]) == xfsMagic {
+		return "xfs"
+	}
+	if _, err = f.ReadAt(magic, btrfsMagicOffset); err == nil && string(magic) == btrfsMagic {
+		return "btrfs"
+	}
+	return ""
+}
+
+// withMountedImage loop mounts the btrfs or xfs image on a temporary
+// directory, and runs fn on the root of the filesystem. There are no tools
+// to modify these filesystems without the kernel drivers, so this needs the
+// privileges to mount. The image is only mounted for as long as fn runs,
+// since rootfs partitions are often clones sharing a filesystem UUID, which
+// can not be mounted at the same time.
+func withMountedImage(image, fstype string, readOnly bool, fn func(root string) error) error {
+	mount, err := utils.GetBinaryPath("mount")
+	if err != nil {
+		return errors.New("mount command not found; it is needed to modify " +
+			fstype + " filesystems")
+	}
+	umount, err := utils.GetBinaryPath("umount")
+	if err != nil {
+		return errors.New("umount command not found; it is needed to modify " +
+			fstype + " filesystems")
+	}
+	dir, err := utils.TempDir("", "mountpoint")
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(dir)
+
+	options := "loop"
+	if readOnly {
+		options += ",ro"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(mount, "-t", fstype, "-o", options, image, dir)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return errors.Errorf("can not mount %s image (needs root privileges): %s: %s",
+			fstype, err.Error(), strings.TrimSpace(stderr.String()))
+	}
+	err = fn(dir)
+	stderr.Reset()
+	cmd = exec.Command(umount, dir)
+	cmd.Stderr = &stderr
+	if uerr := cmd.Run(); uerr != nil && err == nil {
+		err = errors.Errorf("can not unmount %s image: %s: %s",
+			fstype, uerr.Error(), strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// mountedPath returns the path of imageFilePath below the mount point root.
+// The contents of the image are not trusted, so symbolic links are refused
+// instead of being followed, as they could lead anywhere on the host.
+// Components which do not exist yet are fine, as nothing below them can be
+// a link.
+func mountedPath(root, imageFilePath string) (string, error) {
+	path := root
+	for _, name := range strings.Split(filepath.Clean("/"+imageFilePath), "/") {
+		if name == "" {
+			continue
+		}
+		path = filepath.Join(path, name)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return filepath.Join(root, filepath.Clean("/"+imageFilePath)), nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.Errorf("%s: refusing to follow the symbolic link %s in the image",
+				imageFilePath, strings.TrimPrefix(path, root))
+		}
+	}
+	return path, nil
+}
+
+// mountedLinkPath is mountedPath for operations which act on a symbolic link
+// itself, so that only the directories leading to it must not be links.
+func mountedLinkPath(root, imageFilePath string) (string, error) {
+	imageFilePath = filepath.Clean("/" + imageFilePath)
+	dir, err := mountedPath(root, filepath.Dir(imageFilePath))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(imageFilePath)), nil
+}
+
+// mountedFile is a file in a btrfs or xfs image.
+type mountedFile struct {
+	imagePath     string
+	imageFilePath string
+	fstype        string
+	flush         bool     // True if Close() needs to copy the file to the image
+	tmpf          *os.File // Used as a buffer for multiple write operations
+}
+
+// mountedDir is a directory in a btrfs or xfs image.
+type mountedDir struct {
+	imagePath     string
+	imageFilePath string
+	fstype        string
+}
+
+func newMountedFile(imagePath, imageFilePath, fstype string) (*mountedFile, error) {
+	err := withMountedImage(imagePath, fstype, true, func(root string) error {
+		dir, err := mountedPath(root, filepath.Dir(imageFilePath))
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(dir)
+		if err == nil && !info.IsDir() {
+			return os.ErrNotExist
+		}
+		return err
+	})
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf(
+			"The directory: %s does not exist in the image", filepath.Dir(imageFilePath),
+		)
+	} else if err != nil {
+		return nil, err
+	}
+	tmpf, err := utils.TempFile("", "mountedfile")
+	return &mountedFile{
+		imagePath:     imagePath,
+		imageFilePath: imageFilePath,
+		fstype:        fstype,
+		tmpf:          tmpf,
+	}, err
+}
+
+func newMountedDir(imagePath, imageFilePath, fstype string) (*mountedDir, error) {
+	return &mountedDir{
+		imagePath:     imagePath,
+		imageFilePath: imageFilePath,
+		fstype:        fstype,
+	}, nil
+}
+
+// Write buffers b, which is written to the image on Close.
+func (mf *mountedFile) Write(b []byte) (int, error) {
+	n, err := mf.tmpf.Write(b)
+	mf.flush = true
+	return n, err
+}
+
+// Read reads all bytes from the file in the image into b.
+func (mf *mountedFile) Read(b []byte) (int, error) {
+	var data []byte
+	err := withMountedImage(mf.imagePath, mf.fstype, true, func(root string) error {
+		path, err := mountedPath(root, mf.imageFilePath)
+		if err != nil {
+			return err
+		}
+		data, err = ioutil.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "mountedFile: ReadError")
+	}
+	return copy(b, data), io.EOF
+}
+
+// copyHostFile copies src to dst, with the permissions of src.
+func copyHostFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (mf *mountedFile) CopyTo(hostFile string) error {
+	return withMountedImage(mf.imagePath, mf.fstype, false, func(root string) error {
+		path, err := mountedPath(root, mf.imageFilePath)
+		if err != nil {
+			return err
+		}
+		return copyHostFile(hostFile, path)
+	})
+}
+
+func (mf *mountedFile) CopyFrom(hostFile string) error {
+	return withMountedImage(mf.imagePath, mf.fstype, true, func(root string) error {
+		path, err := mountedPath(root, mf.imageFilePath)
+		if err != nil {
+			return err
+		}
+		err = copyHostFile(path, hostFile)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("The file: %s does not exist in the image", mf.imageFilePath)
+		}
+		return err
+	})
+}
+
+func (mf *mountedFile) Chown(uid, gid int) error {
+	return chownMounted(mf.imagePath, mf.imageFilePath, mf.fstype, uid, gid)
+}
+
+// chownMounted sets the owner and group of the file in the btrfs or xfs
+// image, or of the symbolic link itself.
+func chownMounted(image, imageFile, fstype string, uid, gid int) error {
+	return withMountedImage(image, fstype, false, func(root string) error {
+		path, err := mountedLinkPath(root, imageFile)
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+func (mf *mountedFile) Delete(recursive bool) error {
+	return withMountedImage(mf.imagePath, mf.fstype, false, func(root string) error {
+		path, err := mountedLinkPath(root, mf.imageFilePath)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if recursive && info.IsDir() {
+			return os.RemoveAll(path)
+		}
+		return os.Remove(path)
+	})
+}
+
+// Close writes the buffered data to the image, and removes the buffer.
+func (mf *mountedFile) Close() error {
+	if mf == nil || mf.tmpf == nil {
+		return nil
+	}
+	defer func() {
+		// Ignore tmp-errors
+		mf.tmpf.Close()
+		utils.RemoveTemp(mf.tmpf.Name())
+	}()
+	if !mf.flush {
+		return nil
+	}
+	return mf.CopyTo(mf.tmpf.Name())
+}
+
+func (md *mountedDir) Create() error {
+	return withMountedImage(md.imagePath, md.fstype, false, func(root string) error {
+		path, err := mountedPath(root, md.imageFilePath)
+		if err != nil {
+			return err
+		}
+		return os.MkdirAll(path, 0755)
+	})
+}
+
+func (md *mountedDir) Chown(uid, gid int) error {
+	return chownMounted(md.imagePath, md.imageFilePath, md.fstype, uid, gid)
+}
+
+func (md *mountedDir) ReadDir() ([]VPDirEntry, error) {
+	var entries []VPDirEntry
+	err := withMountedImage(md.imagePath, md.fstype, true, func(root string) error {
+		path, err := mountedPath(root, md.imageFilePath)
+		if err != nil {
+			return err
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			entries = append(entries, VPDirEntry{
+				Name:    info.Name(),
+				Mode:    info.Mode(),
+				Size:    info.Size(),
+				ModTime: info.ModTime().UTC(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "mountedDir: can not list directory %s",
+			md.imageFilePath)
+	}
+	return entries, nil
+}
+
+func (md *mountedDir) Close() error {
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+func TestProbeMountedFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	image := make([]byte, 128*1024)
+	copy(image, xfsMagic)
+	xfsImage := filepath.Join(dir, "xfs.img")
+	require.NoError(t, os.WriteFile(xfsImage, image, 0644))
+
+	image = make([]byte, 128*1024)
+	copy(image[btrfsMagicOffset:], btrfsMagic)
+	btrfsImage := filepath.Join(dir, "btrfs.img")
+	require.NoError(t, os.WriteFile(btrfsImage, image, 0644))
+
+	assert.Equal(t, "xfs", probeMountedFilesystem(xfsImage))
+	assert.Equal(t, "btrfs", probeMountedFilesystem(btrfsImage))
+	assert.Equal(t, "", probeMountedFilesystem("mender_test.img"))
+	assert.Equal(t, "", probeMountedFilesystem(filepath.Join(dir, "missing")))
+
+	fstype, err := imgFilesystemType(btrfsImage)
+	require.NoError(t, err)
+	assert.Equal(t, btrfs, fstype)
+	assert.True(t, isFilesystemImage(xfsImage))
+	assert.False(t, isSparsePartition(partition{path: xfsImage}))
+}
+
+func TestMountedPath(t *testing.T) {
+	root := t.TempDir()
+	host := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.Symlink(host, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink("/etc/shadow", filepath.Join(root, "etc", "shadow")))
+
+	path, err := mountedPath(root, "/etc/hostname")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "etc", "hostname"), path)
+	path, err = mountedPath(root, "../new/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "new", "dir", "file"), path)
+
+	// Links are not followed out of the image, in any component.
+	_, err = mountedPath(root, "/escape/file")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to follow the symbolic link /escape")
+	_, err = mountedPath(root, "/etc/shadow")
+	require.Error(t, err)
+	_, err = mountedLinkPath(root, "/escape/file")
+	require.Error(t, err)
+
+	// Except for operations on the link itself.
+	path, err = mountedLinkPath(root, "/etc/shadow")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "etc", "shadow"), path)
+}
+
+func TestMountedFilesystemCopy(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mounting images needs root privileges")
+	}
+	for _, fstype := range []string{"btrfs", "xfs"} {
+		t.Run(fstype, func(t *testing.T) {
+			mkfs, err := utils.GetBinaryPath("mkfs." + fstype)
+			if err != nil {
+				t.Skipf("mkfs.%s not found", fstype)
+			}
+			dir := t.TempDir()
+			image := filepath.Join(dir, "rootfs.img")
+			require.NoError(t, os.WriteFile(image, make([]byte, 320*1024*1024), 0644))
+			out, err := exec.Command(mkfs, "-q", image).CombinedOutput()
+			require.NoError(t, err, string(out))
+			if err = withMountedImage(image, fstype, true, func(string) error {
+				return nil
+			}); err != nil {
+				t.Skipf("can not mount %s: %v", fstype, err)
+			}
+
+			hostFile := filepath.Join(dir, "hostname")
+			require.NoError(t, os.WriteFile(hostFile, []byte("device\n"), 0600))
+			err = Run([]string{"mender-artifact", "cp", hostFile, image + ":/etc/hostname"})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "The directory: /etc does not exist in the image")
+
+			img, err := virtualImage.Open(nil, image)
+			require.NoError(t, err)
+			etc, err := img.OpenDir("/etc")
+			require.NoError(t, err)
+			require.NoError(t, etc.Create())
+			require.NoError(t, CopyIntoImage(hostFile, img, "/etc/hostname"))
+			entries, err := etc.ReadDir()
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "hostname", entries[0].Name)
+			assert.Equal(t, os.FileMode(0600), entries[0].Mode)
+			require.NoError(t, etc.Close())
+			require.NoError(t, img.Close())
+
+			data, err := runAndCollectStdout([]string{"mender-artifact", "cat",
+				image + ":/etc/hostname"})
+			require.NoError(t, err)
+			assert.Equal(t, "device\n", data)
+
+			require.NoError(t, Run([]string{"mender-artifact", "rm",
+				image + ":/etc/hostname"}))
+			_, err = runAndCollectStdout([]string{"mender-artifact", "cat",
+				image + ":/etc/hostname"})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/ext4"
+)
+
+// errNoPartitionTable is returned by readPartitionTable for images without
+// an MBR.
+var errNoPartitionTable = errors.New("no partition table found")
+
+const (
+	mbrEntries      = 446
+	mbrEntrySize    = 16
+	mbrTypeGPT      = 0xee
+	gptSignature    = "EFI PART"
+	gptMinEntrySize = 128
+	// Limits against corrupt tables; the GPT usually has 128 entries.
+	maxGPTEntries     = 1024
+	maxLogicalEntries = 128
+)
+
+// mbrEntry is a partition entry of an MBR or an EBR, in sectors.
+type mbrEntry struct {
+	status byte
+	typ    byte
+	start  int64
+	size   int64
+}
+
+func readMBREntries(sector []byte) []mbrEntry {
+	entries := make([]mbrEntry, 4)
+	for i := range entries {
+		b := sector[mbrEntries+i*mbrEntrySize:]
+		entries[i] = mbrEntry{
+			status: b[0],
+			typ:    b[4],
+			start:  int64(binary.LittleEndian.Uint32(b[8:])),
+			size:   int64(binary.LittleEndian.Uint32(b[12:])),
+		}
+	}
+	return entries
+}
+
+func isExtendedPartition(typ byte) bool {
+	return typ == 0x05 || typ == 0x0f || typ == 0x85
+}
+
+// readPartitionTable returns the partitions of the MBR or the GPT of the
+// image, in the order of their numbers. Extended partitions are left out,
+// but the logical partitions in them are included.
+func readPartitionTable(image string) ([]partition, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sectors := info.Size() / sectorSize
+
+	mbr := make([]byte, sectorSize)
+	if _, err = io.ReadFull(f, mbr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errNoPartitionTable
+	} else if err != nil {
+		return nil, err
+	}
+	if mbr[510] != 0x55 || mbr[511] != 0xaa {
+		return nil, errNoPartitionTable
+	}
+	entries := readMBREntries(mbr)
+	used := 0
+	gpt := false
+	for _, e := range entries {
+		if e.typ == 0 {
+			continue
+		}
+		// A boot sector of a filesystem has code in place of the
+		// entries, which does not make sense as such. The protective
+		// partition of a GPT covers the whole disk, or more.
+		if e.status&0x7f != 0 || e.start == 0 ||
+			(e.typ != mbrTypeGPT && e.start+e.size > sectors) {
+			return nil, errNoPartitionTable
+		}
+		gpt = gpt || e.typ == mbrTypeGPT
+		used++
+	}
+	if used == 0 {
+		return nil, errNoPartitionTable
+	}
+
+	var partitions []partition
+	if gpt {
+		if partitions, err = readGPT(f, sectors); err != nil {
+			return nil, err
+		}
+	} else {
+		var logical []partition
+		for _, e := range entries {
+			if e.typ == 0 {
+				continue
+			}
+			if isExtendedPartition(e.typ) {
+				if logical, err = readLogicalPartitions(f, e.start, sectors); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			partitions = append(partitions, newSectorPartition(e.start, e.size))
+		}
+		partitions = append(partitions, logical...)
+	}
+	for i := range partitions {
+		offset, _, err := partitions[i].byteRange()
+		if err != nil {
+			return nil, err
+		}
+		if label := filesystemLabel(f, offset); label != "" {
+			partitions[i].labels = append(partitions[i].labels, label)
+		}
+	}
+	return partitions, nil
+}
+
+func newSectorPartition(start, size int64) partition {
+	return partition{
+		offset: strconv.FormatInt(start, 10),
+		size:   strconv.FormatInt(size, 10),
+	}
+}
+
+// readLogicalPartitions follows the chain of EBRs of the extended partition
+// starting at sector extended.
+func readLogicalPartitions(f io.ReaderAt, extended, sectors int64) ([]partition, error) {
+	var partitions []partition
+	ebr := make([]byte, sectorSize)
+	next := extended
+	for i := 0; ; i++ {
+		if i == maxLogicalEntries {
+			return nil, errors.New("too many logical partitions")
+		}
+		if _, err := f.ReadAt(ebr, next*sectorSize); err != nil {
+			return nil, errors.Wrap(err, "can not read extended boot record")
+		}
+		if ebr[510] != 0x55 || ebr[511] != 0xaa {
+			return nil, errors.Errorf("invalid extended boot record at sector %d", next)
+		}
+		entries := readMBREntries(ebr)
+		if entries[0].typ != 0 {
+			start := next + entries[0].start
+			if start+entries[0].size > sectors {
+				return nil, errors.Errorf("logical partition %d is out of the image", i+5)
+			}
+			partitions = append(partitions, newSectorPartition(start, entries[0].size))
+		}
+		if !isExtendedPartition(entries[1].typ) {
+			return partitions, nil
+		}
+		next = extended + entries[1].start
+	}
+}
+
+// readGPT reads the partitions of the primary GPT. The sector size is
+// detected from the location of its header.
+func readGPT(f io.ReaderAt, sectors int64) ([]partition, error) {
+	for _, lbaSize := range []int64{512, 4096} {
+		header := make([]byte, 512)
+		if _, err := f.ReadAt(header, lbaSize); err != nil {
+			continue
+		}
+		if !bytes.Equal(header[:8], []byte(gptSignature)) {
+			continue
+		}
+		return readGPTEntries(f, header, lbaSize, sectors)
+	}
+	return nil, errors.New("protective MBR without a GPT header")
+}
+
+func readGPTEntries(f io.ReaderAt, header []byte, lbaSize, sectors int64) ([]partition, error) {
+	le := binary.LittleEndian
+	headerSize := le.Uint32(header[12:])
+	if headerSize < 92 || headerSize > uint32(len(header)) {
+		return nil, errors.Errorf("invalid GPT header size %d", headerSize)
+	}
+	sum := le.Uint32(header[16:])
+	check := append([]byte(nil), header[:headerSize]...)
+	le.PutUint32(check[16:], 0)
+	if crc32.ChecksumIEEE(check) != sum {
+		return nil, errors.New("invalid GPT header checksum")
+	}
+	entriesLBA := int64(le.Uint64(header[72:]))
+	count := le.Uint32(header[80:])
+	entrySize := le.Uint32(header[84:])
+	if count > maxGPTEntries || entrySize < gptMinEntrySize || entrySize%8 != 0 ||
+		entrySize > 1024 {
+		return nil, errors.New("invalid GPT partition entries")
+	}
+	entries := make([]byte, int(count)*int(entrySize))
+	if _, err := f.ReadAt(entries, entriesLBA*lbaSize); err != nil {
+		return nil, errors.Wrap(err, "can not read GPT partition entries")
+	}
+	if crc32.ChecksumIEEE(entries) != le.Uint32(header[88:]) {
+		return nil, errors.New("invalid GPT partition entries checksum")
+	}
+
+	// The offsets and sizes of partitions are in units of sectorSize.
+	scale := lbaSize / sectorSize
+	var partitions []partition
+	for i := 0; i < int(count); i++ {
+		e := entries[i*int(entrySize):]
+		if bytes.Equal(e[:16], make([]byte, 16)) {
+			continue
+		}
+		first := int64(le.Uint64(e[32:]))
+		last := int64(le.Uint64(e[40:]))
+		if first == 0 || last < first || (last+1)*scale > sectors {
+			return nil, errors.Errorf("GPT partition %d is out of the image", i+1)
+		}
+		p := newSectorPartition(first*scale, (last-first+1)*scale)
+		if name := gptPartitionName(e[56:128]); name != "" {
+			p.labels = []string{name}
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// gptPartitionName decodes the UTF-16 name of a GPT partition entry.
+func gptPartitionName(b []byte) string {
+	var name []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		name = append(name, c)
+	}
+	return string(utf16.Decode(name))
+}
+
+// filesystemLabel returns the label of the ext or FAT filesystem at offset,
+// or "" if it has none.
+func filesystemLabel(f io.ReaderAt, offset int64) string {
+	// The ext superblock is at 1024 bytes into the filesystem.
+	sb := make([]byte, 2048)
+	if _, err := f.ReadAt(sb, offset); err != nil {
+		return ""
+	}
+	var label []byte
+	switch {
+	case binary.LittleEndian.Uint16(sb[1024+56:]) == 0xef53:
+		label = sb[1024+120 : 1024+136]
+	case sb[510] != 0x55 || sb[511] != 0xaa:
+		return ""
+	case bytes.HasPrefix(sb[82:], []byte("FAT32")):
+		label = sb[71:82]
+	case bytes.HasPrefix(sb[54:], []byte("FAT")):
+		label = sb[43:54]
+	}
+	name := strings.TrimSpace(string(bytes.TrimRight(label, "\x00")))
+	if name == "NO NAME" {
+		return ""
+	}
+	return name
+}
+
+// isFilesystemImage returns true if image holds an ext, FAT, btrfs or xfs
+// filesystem rather than a partitioned disk.
+func isFilesystemImage(image string) bool {
+	if ext4.Probe(image) || probeMountedFilesystem(image) != "" {
+		return true
+	}
+	f, err := os.Open(image)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	boot := make([]byte, sectorSize)
+	if _, err := io.ReadFull(f, boot); err != nil {
+		return false
+	}
+	return boot[510] == 0x55 && boot[511] == 0xaa &&
+		(bytes.HasPrefix(boot[54:], []byte("FAT")) || bytes.HasPrefix(boot[82:], []byte("FAT")))
+}
+
+// partitionRoleLabels maps normalized partition and filesystem labels to
+// the index of the role in sdimgPartitionNames they stand for.
+var partitionRoleLabels = map[string]int{
+	"boot":       0,
+	"bootfs":     0,
+	"uboot":      0,
+	"efi":        0,
+	"esp":        0,
+	"efisystem":  0,
+	"rootfsa":    1,
+	"roota":      1,
+	"primary":    1,
+	"rootfs1":    1,
+	"systema":    1,
+	"rootfsb":    2,
+	"rootb":      2,
+	"secondary":  2,
+	"rootfs2":    2,
+	"systemb":    2,
+	"data":       3,
+	"userdata":   3,
+	"menderdata": 3,
+}
+
+// genericRootfsLabels are labels of either of the rootfs partitions. The
+// first such partition is taken as rootfsa and the second as rootfsb.
+var genericRootfsLabels = map[string]bool{
+	"rootfs": true,
+	"root":   true,
+}
+
+func normalizeLabel(label string) string {
+	return strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(label))
+}
+
+// assignRolesByLabel assigns partitions to roles by their labels, and
+// returns false unless one of the rootfs partitions was found that way. A
+// missing boot or data partition is taken to be the unlabeled partition
+// before or after the rootfs partitions, as in sdimgs without labels.
+func assignRolesByLabel(partitions []partition) ([]partition, bool) {
+	roles := make([]partition, len(sdimgPartitionNames))
+	// The index of the partition of each role, or -1.
+	indexes := []int{-1, -1, -1, -1}
+	for i, p := range partitions {
+		for _, label := range p.labels {
+			label = normalizeLabel(label)
+			role, ok := partitionRoleLabels[label]
+			if genericRootfsLabels[label] {
+				role, ok = 1, true
+				if indexes[1] >= 0 {
+					role = 2
+				}
+			}
+			if ok && indexes[role] < 0 {
+				roles[role] = p
+				indexes[role] = i
+				break
+			}
+		}
+	}
+	first, last := indexes[1], indexes[2]
+	if first < 0 || (last >= 0 && last < first) {
+		first = last
+	}
+	if last < indexes[1] {
+		last = indexes[1]
+	}
+	if first < 0 {
+		return nil, false
+	}
+	if i := first - 1; indexes[0] < 0 && i >= 0 && len(partitions[i].labels) == 0 {
+		roles[0] = partitions[i]
+	}
+	if i := last + 1; indexes[3] < 0 && i < len(partitions) &&
+		len(partitions[i].labels) == 0 {
+		roles[3] = partitions[i]
+	}
+	return roles, true
+}
+
+// assignPartitionRoles picks the partitions for each of sdimgPartitionNames;
+// those the image does not have are left empty. The roles are recognized by
+// the labels of the partitions, which allows for any number of partitions,
+// and for a single rootfs. Without labels, images with three partitions have
+// no boot partition, and any partitions after the fourth one are left alone.
+func assignPartitionRoles(partitions []partition) ([]partition, error) {
+	if roles, ok := assignRolesByLabel(partitions); ok {
+		return roles, nil
+	}
+	switch {
+	case len(partitions) >= 4:
+		return partitions[:4], nil
+	case len(partitions) == 3:
+		return append([]partition{{}}, partitions...), nil
+	}
+	return nil, errors.Errorf("%d partitions found, at least 3 needed", len(partitions))
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func putMBREntry(sector []byte, i int, typ byte, start, size uint32) {
+	b := sector[mbrEntries+i*mbrEntrySize:]
+	b[4] = typ
+	binary.LittleEndian.PutUint32(b[8:], start)
+	binary.LittleEndian.PutUint32(b[12:], size)
+	sector[510], sector[511] = 0x55, 0xaa
+}
+
+func writeTestImage(t *testing.T, disk []byte) string {
+	image := filepath.Join(t.TempDir(), "disk.img")
+	require.NoError(t, os.WriteFile(image, disk, 0644))
+	return image
+}
+
+func sectorPartitions(ranges ...int64) []partition {
+	var partitions []partition
+	for i := 0; i < len(ranges); i += 2 {
+		partitions = append(partitions, newSectorPartition(ranges[i], ranges[i+1]))
+	}
+	return partitions
+}
+
+func TestReadPartitionTableMBR(t *testing.T) {
+	disk := make([]byte, 100*sectorSize)
+	putMBREntry(disk, 0, 0x0c, 2, 8)
+	putMBREntry(disk, 1, 0x83, 10, 20)
+	putMBREntry(disk, 2, 0x05, 40, 60)
+	// Two logical partitions, each after its EBR.
+	ebr := disk[40*sectorSize:]
+	putMBREntry(ebr, 0, 0x83, 1, 19)
+	putMBREntry(ebr, 1, 0x05, 20, 40)
+	ebr = disk[60*sectorSize:]
+	putMBREntry(ebr, 0, 0x83, 1, 39)
+
+	partitions, err := readPartitionTable(writeTestImage(t, disk))
+	require.NoError(t, err)
+	assert.Equal(t, sectorPartitions(2, 8, 10, 20, 41, 19, 61, 39), partitions)
+
+	roles, err := assignPartitionRoles(partitions[:3])
+	require.NoError(t, err)
+	assert.Equal(t, "", roles[0].offset)
+	assert.Equal(t, partitions[:3], roles[1:])
+	_, err = assignPartitionRoles(partitions[:2])
+	assert.EqualError(t, err, "2 partitions found, at least 3 needed")
+
+	// Partitions beyond the end of the image.
+	putMBREntry(disk, 3, 0x83, 90, 20)
+	_, err = readPartitionTable(writeTestImage(t, disk))
+	assert.Equal(t, errNoPartitionTable, err)
+}
+
+func TestReadPartitionTableGPT(t *testing.T) {
+	le := binary.LittleEndian
+	disk := make([]byte, 100*sectorSize)
+	putMBREntry(disk, 0, mbrTypeGPT, 1, 0xffffffff)
+
+	entries := disk[2*sectorSize : 6*sectorSize]
+	for i, r := range [][2]uint64{{34, 49}, {50, 89}} {
+		e := entries[i*gptMinEntrySize:]
+		e[0] = 1 // Any non-zero type GUID.
+		le.PutUint64(e[32:], r[0])
+		le.PutUint64(e[40:], r[1])
+	}
+	header := disk[sectorSize : 2*sectorSize]
+	copy(header, gptSignature)
+	le.PutUint32(header[12:], 92)
+	le.PutUint64(header[72:], 2)
+	le.PutUint32(header[80:], 16)
+	le.PutUint32(header[84:], gptMinEntrySize)
+	le.PutUint32(header[88:], crc32.ChecksumIEEE(entries[:16*gptMinEntrySize]))
+	le.PutUint32(header[16:], crc32.ChecksumIEEE(header[:92]))
+
+	partitions, err := readPartitionTable(writeTestImage(t, disk))
+	require.NoError(t, err)
+	assert.Equal(t, sectorPartitions(34, 16, 50, 40), partitions)
+
+	entries[0] ^= 1
+	_, err = readPartitionTable(writeTestImage(t, disk))
+	assert.EqualError(t, err, "invalid GPT partition entries checksum")
+}
+
+func TestReadPartitionTableFilesystem(t *testing.T) {
+	// The boot sector of a FAT filesystem.
+	disk := make([]byte, 10*sectorSize)
+	copy(disk, []byte{0xeb, 0x3c, 0x90})
+	copy(disk[54:], "FAT16   ")
+	disk[510], disk[511] = 0x55, 0xaa
+	disk[mbrEntries] = 0x12
+	disk[mbrEntries+4] = 0x34
+	image := writeTestImage(t, disk)
+
+	_, err := readPartitionTable(image)
+	assert.Equal(t, errNoPartitionTable, err)
+	assert.True(t, isFilesystemImage(image))
+	assert.False(t, isFilesystemImage(writeTestImage(t, make([]byte, sectorSize))))
+}
+
+func labeledPartitions(labels ...string) []partition {
+	var partitions []partition
+	for i, label := range labels {
+		p := newSectorPartition(int64(i+1)*100, 100)
+		if label != "" {
+			p.labels = []string{label}
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions
+}
+
+func TestAssignPartitionRolesByLabel(t *testing.T) {
+	tests := map[string]struct {
+		labels []string
+		// The index in labels of the partition of each role, or -1.
+		roles []int
+	}{
+		"extra partitions": {
+			labels: []string{"", "rootfs_a", "rootfs_b", "", "swap", "Factory"},
+			roles:  []int{0, 1, 2, 3},
+		},
+		"boot last": {
+			labels: []string{"rootfsB", "rootfsA", "DATA", "ESP"},
+			roles:  []int{3, 1, 0, 2},
+		},
+		"single rootfs": {
+			labels: []string{"boot", "rootfs", "data"},
+			roles:  []int{0, 1, -1, 2},
+		},
+		"single rootfs without data": {
+			labels: []string{"", "root", "swap"},
+			roles:  []int{0, 1, -1, -1},
+		},
+		"generic rootfs labels": {
+			labels: []string{"efi", "rootfs", "rootfs", "mender-data"},
+			roles:  []int{0, 1, 2, 3},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			partitions := labeledPartitions(test.labels...)
+			roles, err := assignPartitionRoles(partitions)
+			require.NoError(t, err)
+			require.Len(t, roles, len(sdimgPartitionNames))
+			for role, i := range test.roles {
+				if i < 0 {
+					assert.Equal(t, "", roles[role].offset, sdimgPartitionNames[role])
+				} else {
+					assert.Equal(t, partitions[i], roles[role], sdimgPartitionNames[role])
+				}
+			}
+		})
+	}
+
+	// Without rootfs labels, the roles follow the partition order.
+	partitions := labeledPartitions("", "active", "inactive", "data", "")
+	roles, err := assignPartitionRoles(partitions)
+	require.NoError(t, err)
+	assert.Equal(t, partitions[:4], roles)
+}
+
+func TestReadPartitionLabels(t *testing.T) {
+	le := binary.LittleEndian
+	disk := make([]byte, 100*sectorSize)
+	putMBREntry(disk, 0, 0x0c, 2, 8)
+	putMBREntry(disk, 1, 0x83, 10, 20)
+	// A FAT32 boot sector, and an ext superblock.
+	boot := disk[2*sectorSize:]
+	copy(boot[71:], "EFI        FAT32")
+	boot[510], boot[511] = 0x55, 0xaa
+	sb := disk[10*sectorSize+1024:]
+	le.PutUint16(sb[56:], 0xef53)
+	copy(sb[120:], "rootfs-a")
+
+	partitions, err := readPartitionTable(writeTestImage(t, disk))
+	require.NoError(t, err)
+	require.Len(t, partitions, 2)
+	assert.Equal(t, []string{"EFI"}, partitions[0].labels)
+	assert.Equal(t, []string{"rootfs-a"}, partitions[1].labels)
+
+	name := make([]byte, 72)
+	for i, c := range "primary" {
+		le.PutUint16(name[2*i:], uint16(c))
+	}
+	assert.Equal(t, "primary", gptPartitionName(name))
+}
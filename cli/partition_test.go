@@ -15,9 +15,16 @@
 package cli
 
 import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 func TestFsck(t *testing.T) {
@@ -27,3 +34,91 @@ func TestFsck(t *testing.T) {
 	err = runFsck("mender_test.img", "ext4")
 	assert.NoError(t, err)
 }
+
+func TestExtractAndRepackSdimg(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "test.sdimg")
+	content := append(bytes.Repeat([]byte{0}, sectorSize),
+		append(bytes.Repeat([]byte{1}, 2*sectorSize), bytes.Repeat([]byte{2}, sectorSize)...)...)
+	require.NoError(t, os.WriteFile(image, content, 0644))
+
+	partitions, err := extractFromSdimg([]partition{
+		{offset: "1", size: "2"},
+		{offset: "3", size: "1"},
+	}, image)
+	require.NoError(t, err)
+	for _, part := range partitions {
+		defer os.Remove(part.path)
+	}
+	data, err := os.ReadFile(partitions[0].path)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 2*sectorSize), data)
+	data, err = os.ReadFile(partitions[1].path)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{2}, sectorSize), data)
+
+	require.NoError(t, os.WriteFile(partitions[1].path,
+		bytes.Repeat([]byte{3}, sectorSize), 0644))
+	require.NoError(t, repackSdimg(partitions, image))
+	data, err = os.ReadFile(image)
+	require.NoError(t, err)
+	assert.Equal(t, append(content[:3*sectorSize:3*sectorSize],
+		bytes.Repeat([]byte{3}, sectorSize)...), data)
+
+	_, err = extractFromSdimg([]partition{{offset: "x", size: "1"}}, image)
+	assert.Contains(t, err.Error(), "invalid partition offset")
+}
+
+func TestExtFileWithoutExternalTools(t *testing.T) {
+	image := filepath.Join(t.TempDir(), "rootfs.img")
+	require.NoError(t, copyFile("mender_test.img", image))
+
+	origPATH := os.Getenv("PATH")
+	origExternalBinaryPaths := utils.ExternalBinaryPaths
+	utils.ExternalBinaryPaths = []string{}
+	defer func() {
+		os.Setenv("PATH", origPATH)
+		utils.ExternalBinaryPaths = origExternalBinaryPaths
+	}()
+	os.Setenv("PATH", "")
+
+	fstype, err := imgFilesystemType(image)
+	require.NoError(t, err)
+	assert.Equal(t, ext, fstype)
+
+	dir, err := newExtDir(image, "/var/lib/test")
+	require.NoError(t, err)
+	require.NoError(t, dir.Create())
+
+	f, err := newExtFile(image, "/var/lib/test/file.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("foobar"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	f, err = newExtFile(image, "/var/lib/test/file.txt")
+	require.NoError(t, err)
+	buf := make([]byte, 100)
+	n, err := f.Read(buf)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "foobar", string(buf[:n]))
+	hostFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, f.CopyFrom(hostFile))
+	data, err := os.ReadFile(hostFile)
+	require.NoError(t, err)
+	assert.Equal(t, "foobar", string(data))
+	require.NoError(t, f.Close())
+
+	entries, err := dir.ReadDir()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "file.txt", entries[0].Name)
+	assert.Equal(t, int64(6), entries[0].Size)
+
+	f, err = newExtFile(image, "/var/lib/test")
+	require.NoError(t, err)
+	assert.Error(t, f.Delete(false))
+	require.NoError(t, f.Delete(true))
+	require.NoError(t, f.Close())
+	_, err = newExtFile(image, "/var/lib/test/file.txt")
+	assert.EqualError(t, err, "The directory: /var/lib/test does not exist in the image")
+}
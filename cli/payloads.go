@@ -0,0 +1,150 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+)
+
+// extraPayload is a payload given with --payload, which follows the payload
+// described by the other module-image flags.
+type extraPayload struct {
+	composer handlers.Composer
+	header   awriter.PayloadHeader
+}
+
+// makeExtraPayloads returns the payloads of all the --payload flags.
+func makeExtraPayloads(ctx *cli.Context) ([]*extraPayload, error) {
+	var payloads []*extraPayload
+	for _, spec := range ctx.StringSlice("payload") {
+		payload, err := parsePayloadSpec(ctx, spec)
+		if err != nil {
+			return nil, cli.NewExitError(
+				fmt.Sprintf("invalid --payload %q: %s", spec, err.Error()),
+				errArtifactInvalidParameters)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// parsePayloadSpec parses a comma separated list of key=value pairs into a
+// payload. The "type" key is required. The "file", "provides", "depends" and
+// "clears-provides" keys may be repeated, and "meta-data" is a JSON file or
+// directory, see readMetaData.
+// The default software version provides and clears-provides are added the
+// same way as for the first payload.
+func parsePayloadSpec(ctx *cli.Context, spec string) (*extraPayload, error) {
+	var updateType, metaDataFile string
+	var files, provides, depends, clearsProvides []string
+	for _, field := range strings.Split(spec, ",") {
+		split := strings.SplitN(field, "=", 2)
+		if len(split) != 2 || split[1] == "" {
+			return nil, errors.Errorf("%q is not of the form key=value", field)
+		}
+		key, value := split[0], split[1]
+		switch key {
+		case "type":
+			updateType = value
+		case "file":
+			files = append(files, value)
+		case "provides":
+			provides = append(provides, value)
+		case "depends":
+			depends = append(depends, value)
+		case "clears-provides":
+			clearsProvides = append(clearsProvides, value)
+		case "meta-data":
+			metaDataFile = value
+		default:
+			return nil, errors.Errorf("unknown key %q", key)
+		}
+	}
+	if updateType == "" {
+		return nil, errors.New("the payload type is required")
+	}
+
+	typeInfo := &artifact.TypeInfoV3{
+		Type:                   &updateType,
+		ClearsArtifactProvides: clearsProvides,
+	}
+	keyValues, err := extractKeyValues(depends)
+	if err != nil {
+		return nil, err
+	} else if keyValues != nil {
+		if typeInfo.ArtifactDepends, err = artifact.NewTypeInfoDepends(*keyValues); err != nil {
+			return nil, err
+		}
+	}
+	typeInfo.ArtifactProvides = artifact.TypeInfoProvides{}
+	keyValues, err = extractKeyValues(provides)
+	if err != nil {
+		return nil, err
+	} else if keyValues != nil {
+		if typeInfo.ArtifactProvides, err = artifact.NewTypeInfoProvides(*keyValues); err != nil {
+			return nil, err
+		}
+	}
+	noDefault := ctx.Bool(noDefaultSoftwareVersionFlag)
+	for key, value := range getSoftwareVersion(
+		ctx.String("artifact-name"), "", "", updateType, "", noDefault,
+	) {
+		if _, ok := typeInfo.ArtifactProvides[key]; !ok {
+			typeInfo.ArtifactProvides[key] = value
+		}
+	}
+	if !noDefault && !ctx.Bool(noDefaultClearsProvidesFlag) {
+		defaultCap := artifact.BuildSoftwareClearsProvides("", updateType)
+		present := false
+		for _, cap := range typeInfo.ClearsArtifactProvides {
+			present = present || cap == defaultCap
+		}
+		if !present {
+			typeInfo.ClearsArtifactProvides = append(typeInfo.ClearsArtifactProvides,
+				defaultCap)
+		}
+	}
+
+	var metaData map[string]interface{}
+	if metaDataFile != "" {
+		if metaData, err = readMetaData([]string{metaDataFile}); err != nil {
+			return nil, err
+		}
+	}
+
+	handler := handlers.NewModuleImage(updateType)
+	dataFiles := make([]*handlers.DataFile, 0, len(files))
+	for _, file := range files {
+		dataFiles = append(dataFiles, &handlers.DataFile{Name: file})
+	}
+	if err := handler.SetUpdateFiles(dataFiles); err != nil {
+		return nil, err
+	}
+	return &extraPayload{
+		composer: handler,
+		header: awriter.PayloadHeader{
+			TypeInfoV3: typeInfo,
+			MetaData:   metaData,
+		},
+	}, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+func TestWriteMultiplePayloads(t *testing.T) {
+	tmpdir := t.TempDir()
+	for name, content := range map[string]string{
+		"app":       "app binary",
+		"app.conf":  "app config",
+		"meta.json": `{"restart": true}`,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0644))
+	}
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "app", "-t", "testDevice", "-n", "testName",
+		"-f", filepath.Join(tmpdir, "app"),
+		"--payload", "type=config,file=" + filepath.Join(tmpdir, "app.conf") +
+			",provides=config.version:2,depends=app.version:1" +
+			",meta-data=" + filepath.Join(tmpdir, "meta.json"),
+		"--payload", "type=empty"})
+	require.NoError(t, err)
+
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	inst := ar.GetHandlers()
+	require.Len(t, inst, 3)
+
+	assert.Equal(t, "app", *inst[0].GetUpdateType())
+	require.Len(t, inst[0].GetUpdateFiles(), 1)
+	assert.Equal(t, "app", inst[0].GetUpdateFiles()[0].Name)
+
+	assert.Equal(t, "config", *inst[1].GetUpdateType())
+	require.Len(t, inst[1].GetUpdateFiles(), 1)
+	assert.Equal(t, "app.conf", inst[1].GetUpdateFiles()[0].Name)
+	provides, err := inst[1].GetUpdateProvides()
+	require.NoError(t, err)
+	assert.Equal(t, artifact.TypeInfoProvides{
+		"config.version":              "2",
+		"rootfs-image.config.version": "testName",
+	}, provides)
+	depends, err := inst[1].GetUpdateDepends()
+	require.NoError(t, err)
+	assert.Equal(t, artifact.TypeInfoDepends{"app.version": "1"}, depends)
+	assert.Equal(t, []string{"rootfs-image.config.*"}, inst[1].GetUpdateClearsProvides())
+	metaData, err := inst[1].GetUpdateMetaData()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"restart": true}, metaData)
+
+	assert.Equal(t, "empty", *inst[2].GetUpdateType())
+	assert.Empty(t, inst[2].GetUpdateFiles())
+}
+
+func TestWriteMultiplePayloadsErrors(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	for spec, msg := range map[string]string{
+		"file=foo":                     "the payload type is required",
+		"type=config,size=1":           `unknown key "size"`,
+		"type=config,file":             `"file" is not of the form key=value`,
+		"type=config,provides=":        `"provides=" is not of the form key=value`,
+		"type=config,provides=version": "argument must have a delimiting colon",
+	} {
+		err := Run([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "app", "-t", "testDevice", "-n", "testName",
+			"--payload", spec})
+		require.Error(t, err, spec)
+		assert.Contains(t, err.Error(), msg)
+		assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+	}
+}
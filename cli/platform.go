@@ -0,0 +1,180 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bufio"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// maxSymlinkHops bounds the symbolic links followed inside an image.
+const maxSymlinkHops = 16
+
+// platform is the architecture and operating system of a root filesystem.
+type platform struct {
+	arch string
+	os   string
+}
+
+// detectPlatform inspects the ext4 filesystem image and returns the
+// architecture of its binaries, from the ELF header of /bin/sh, and the ID of
+// its operating system, from its os-release file. The operating system is
+// left empty if the image has no os-release file.
+func detectPlatform(image string) (*platform, error) {
+	shell, err := readImageFile(image, "/bin/sh")
+	if err != nil {
+		return nil, errors.Wrap(err, "can not detect the architecture")
+	}
+	defer os.RemoveAll(filepath.Dir(shell))
+	f, err := elf.Open(shell)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not detect the architecture from /bin/sh")
+	}
+	defer f.Close()
+	p := &platform{arch: elfArch(&f.FileHeader)}
+
+	for _, osRelease := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		file, err := readImageFile(image, osRelease)
+		if err != nil {
+			continue
+		}
+		defer os.RemoveAll(filepath.Dir(file))
+		if p.os, err = osReleaseID(file); err != nil {
+			return nil, err
+		}
+		break
+	}
+	if p.os == "" {
+		warnf(WarningPlatformDetection,
+			"No operating system ID found in the os-release file of %s", image)
+	}
+	return p, nil
+}
+
+// readImageFile copies file out of the ext4 filesystem image, following
+// symbolic links within the image, and returns the path of the copy. The
+// caller removes the directory of the copy.
+func readImageFile(image, file string) (string, error) {
+	for i := 0; i < maxSymlinkHops; i++ {
+		out, err := debugfsExecuteCommand("stat "+file, image)
+		if err != nil {
+			return "", err
+		}
+		if !strings.Contains(out.String(), "Type: symlink") {
+			dir, err := debugfsCopyFile(file, image)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(dir, path.Base(file)), nil
+		}
+		target, err := debugfsReadLink(image, file)
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(file), target)
+		}
+		file = path.Clean(target)
+	}
+	return "", errors.Errorf("too many levels of symbolic links in %s", file)
+}
+
+// elfArch returns the name of the architecture of an ELF binary, in the form
+// used by the Linux kernel (uname -m), with the endianness where it is part
+// of the name.
+func elfArch(h *elf.FileHeader) string {
+	little := h.Data == elf.ELFDATA2LSB
+	is64 := h.Class == elf.ELFCLASS64
+	switch h.Machine {
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_386:
+		return "i686"
+	case elf.EM_AARCH64:
+		if little {
+			return "aarch64"
+		}
+		return "aarch64_be"
+	case elf.EM_ARM:
+		if little {
+			return "arm"
+		}
+		return "armeb"
+	case elf.EM_RISCV:
+		if is64 {
+			return "riscv64"
+		}
+		return "riscv32"
+	case elf.EM_MIPS:
+		arch := "mips"
+		if is64 {
+			arch = "mips64"
+		}
+		if little {
+			arch += "el"
+		}
+		return arch
+	case elf.EM_PPC64:
+		if little {
+			return "ppc64le"
+		}
+		return "ppc64"
+	case elf.EM_PPC:
+		return "ppc"
+	}
+	return strings.ToLower(strings.TrimPrefix(h.Machine.String(), "EM_"))
+}
+
+// osReleaseID returns the ID field of an os-release file.
+func osReleaseID(file string) (string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "can not read the os-release file")
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "ID=") {
+			continue
+		}
+		return strings.Trim(strings.TrimPrefix(line, "ID="), `"'`), nil
+	}
+	return "", scanner.Err()
+}
+
+// addPlatformProvides adds the detected platform to the payload provides,
+// unless they are given explicitly.
+func addPlatformProvides(typeInfo *artifact.TypeInfoV3, p *platform) {
+	if typeInfo.ArtifactProvides == nil {
+		typeInfo.ArtifactProvides = artifact.TypeInfoProvides{}
+	}
+	for key, value := range map[string]string{
+		artifact.RootfsImageArchKey: p.arch,
+		artifact.RootfsImageOSKey:   p.os,
+	} {
+		if _, ok := typeInfo.ArtifactProvides[key]; value != "" && !ok {
+			typeInfo.ArtifactProvides[key] = value
+		}
+	}
+}
@@ -15,8 +15,18 @@
 package cli
 
 import (
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
 )
 
 func skipPartedTestsOnMac(t *testing.T) {
@@ -24,3 +34,98 @@ func skipPartedTestsOnMac(t *testing.T) {
 		t.Skip("Not supported on Mac OS because `parted` is missing.")
 	}
 }
+
+// writeTestELF writes the ELF header of a binary for the given machine.
+func writeTestELF(t *testing.T, path string, machine elf.Machine) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, binary.Write(f, binary.LittleEndian, &elf.Header64{
+		Ident: [elf.EI_NIDENT]byte{0x7f, 'E', 'L', 'F',
+			byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)},
+		Type:    uint16(elf.ET_DYN),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}))
+}
+
+func TestWriteDetectPlatform(t *testing.T) {
+	tmpdir := t.TempDir()
+	img := filepath.Join(tmpdir, "rootfs.ext4")
+	require.NoError(t, copyFile("mender_test.img", img))
+
+	shell := filepath.Join(tmpdir, "busybox")
+	writeTestELF(t, shell, elf.EM_AARCH64)
+	osRelease := filepath.Join(tmpdir, "os-release")
+	require.NoError(t, os.WriteFile(osRelease,
+		[]byte("NAME=\"Poky\"\nID=poky\nVERSION_ID=5.0\n"), 0644))
+	require.NoError(t, debugfsMakeDir("/bin", img))
+	require.NoError(t, debugfsMakeDir("/usr/lib", img))
+	require.NoError(t, debugfsReplaceFile("/bin/busybox", shell, img))
+	require.NoError(t, debugfsReplaceFile("/usr/lib/os-release", osRelease, img))
+	_, err := debugfsExecuteCommand("cd /bin\nsymlink sh busybox\n"+
+		"cd /etc\nsymlink os-release ../usr/lib/os-release\nclose", img)
+	require.NoError(t, err)
+
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "-t", "my-device",
+		"-n", "release-1", "-f", img, "-o", artfile, "--detect-platform"})
+	require.NoError(t, err)
+
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	provides, err := ar.GetHandlers()[0].GetUpdateProvides()
+	require.NoError(t, err)
+	assert.Equal(t, "aarch64", provides[artifact.RootfsImageArchKey])
+	assert.Equal(t, "poky", provides[artifact.RootfsImageOSKey])
+
+	// Explicit provides take precedence.
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "-t", "my-device",
+		"-n", "release-1", "-f", img, "-o", artfile, "--detect-platform",
+		"--provides", artifact.RootfsImageOSKey + ":yocto"})
+	require.NoError(t, err)
+	f, err = os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar = areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	provides, err = ar.GetHandlers()[0].GetUpdateProvides()
+	require.NoError(t, err)
+	assert.Equal(t, "yocto", provides[artifact.RootfsImageOSKey])
+
+	// Without an os-release file only the architecture is detected.
+	noOS := filepath.Join(tmpdir, "no-os.ext4")
+	require.NoError(t, copyFile("mender_test.img", noOS))
+	require.NoError(t, debugfsMakeDir("/bin", noOS))
+	require.NoError(t, debugfsReplaceFile("/bin/sh", shell, noOS))
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "-t", "my-device",
+		"-n", "release-1", "-f", noOS, "-o", artfile, "--detect-platform"})
+	require.NoError(t, err)
+	assert.Contains(t, warningClasses(Warnings()), WarningPlatformDetection)
+
+	// The test image has no /bin/sh.
+	err = Run([]string{"mender-artifact", "write", "rootfs-image", "-t", "my-device",
+		"-n", "release-1", "-f", "mender_test.img", "-o", artfile, "--detect-platform"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not detect the architecture")
+}
+
+func TestElfArch(t *testing.T) {
+	for arch, header := range map[string]elf.FileHeader{
+		"x86_64":  {Machine: elf.EM_X86_64, Class: elf.ELFCLASS64, Data: elf.ELFDATA2LSB},
+		"arm":     {Machine: elf.EM_ARM, Class: elf.ELFCLASS32, Data: elf.ELFDATA2LSB},
+		"armeb":   {Machine: elf.EM_ARM, Class: elf.ELFCLASS32, Data: elf.ELFDATA2MSB},
+		"mipsel":  {Machine: elf.EM_MIPS, Class: elf.ELFCLASS32, Data: elf.ELFDATA2LSB},
+		"mips64":  {Machine: elf.EM_MIPS, Class: elf.ELFCLASS64, Data: elf.ELFDATA2MSB},
+		"ppc64le": {Machine: elf.EM_PPC64, Class: elf.ELFCLASS64, Data: elf.ELFDATA2LSB},
+		"riscv64": {Machine: elf.EM_RISCV, Class: elf.ELFCLASS64, Data: elf.ELFDATA2LSB},
+		"sparcv9": {Machine: elf.EM_SPARCV9, Class: elf.ELFCLASS64, Data: elf.ELFDATA2MSB},
+	} {
+		header := header
+		assert.Equal(t, arch, elfArch(&header))
+	}
+}
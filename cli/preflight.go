@@ -0,0 +1,225 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// writePreflight copies everything in the Artifact in r before its data to
+// w: the version, the manifests, the signature and the headers, with the
+// state scripts. The result is a valid Artifact without payload data, which
+// is all a device needs to check whether it can install the Artifact.
+func writePreflight(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	var headerFound bool
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "can not read Artifact")
+		}
+		if i == 0 && hdr.Name != "version" {
+			return errors.New("not an Artifact: the first file is not 'version'")
+		}
+		if strings.HasPrefix(hdr.Name, artifact.DataDirectory+"/") {
+			break
+		}
+		if strings.HasPrefix(hdr.Name, "header.tar") {
+			headerFound = true
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrapf(err, "can not write %s", hdr.Name)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return errors.Wrapf(err, "can not copy %s", hdr.Name)
+		}
+	}
+	if !headerFound {
+		return errors.New("not an Artifact: no header found")
+	}
+	return tw.Close()
+}
+
+func preflightArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Need exactly one Artifact to make a preflight bundle of",
+			errArtifactInvalidParameters)
+	}
+	output := c.String("output-path")
+	if output == "" {
+		return cli.NewExitError("--output-path is required", errArtifactInvalidParameters)
+	}
+
+	in, err := openArtifactInput(c.Args().First())
+	if err != nil {
+		return cli.NewExitError("Can not open artifact: "+err.Error(), errArtifactOpen)
+	}
+	defer in.Close()
+	out, err := os.Create(output)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactCreate)
+	}
+
+	err = writePreflight(in, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+	return nil
+}
+
+// checkDepends checks that a device of deviceType with provides can install
+// the Artifact read by ar, like the Mender client does before downloading
+// the payloads.
+func checkDepends(ar *areader.Reader, deviceType string, provides map[string]string) error {
+	if deviceType != "" {
+		compatible := false
+		for _, device := range ar.GetCompatibleDevices() {
+			if device == deviceType {
+				compatible = true
+				break
+			}
+		}
+		if !compatible {
+			return errors.Errorf("the Artifact is not compatible with device type %q;"+
+				" it is compatible with %s", deviceType,
+				strings.Join(ar.GetCompatibleDevices(), ", "))
+		}
+		if ar.GetArtifactDepends() != nil && ar.GetArtifactDepends().ExcludesDevice(deviceType) {
+			return errors.Errorf("the Artifact excludes device type %q", deviceType)
+		}
+	}
+
+	depends, err := ar.MergeArtifactDepends()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(depends))
+	for key := range depends {
+		// Checked above, against the device type rather than the provides.
+		if key != "device_type" && key != "excluded_device_type" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		have, ok := provides[key]
+		if !ok {
+			return errors.Errorf("the Artifact depends on %s, which the device does not provide",
+				key)
+		}
+		if !dependsOnValue(depends[key], have) {
+			return errors.Errorf("the Artifact depends on %s %v, but the device has %q",
+				key, depends[key], have)
+		}
+	}
+	return nil
+}
+
+// dependsOnValue returns true if value is, or is one of, want.
+func dependsOnValue(want interface{}, value string) bool {
+	switch want := want.(type) {
+	case string:
+		return want == value
+	case []string:
+		for _, w := range want {
+			if w == value {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, w := range want {
+			if w == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func preflightVerify(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Need exactly one preflight bundle to verify",
+			errArtifactInvalidParameters)
+	}
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	provides, err := extractKeyValues(c.StringSlice("provides"))
+	if err != nil {
+		return err
+	}
+	if provides == nil {
+		provides = &map[string]string{}
+	}
+
+	f, err := openArtifactInput(c.Args().First())
+	if err != nil {
+		return cli.NewExitError("Can not open preflight bundle: "+err.Error(), errArtifactOpen)
+	}
+	defer f.Close()
+
+	ar := areader.NewReader(f)
+	var sigErr error
+	verified := false
+	ar.VerifySignatureCallback = func(message, sig []byte) error {
+		if key != nil && !verified {
+			sigErr = key.Verify(message, sig)
+			verified = sigErr == nil
+		}
+		return nil
+	}
+	if err := ar.ReadArtifactHeaders(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+
+	sigInfo := signatureNone
+	switch {
+	case key != nil && !ar.IsSigned:
+		return cli.NewExitError("missing signature", errArtifactInvalid)
+	case sigErr != nil:
+		return cli.NewExitError("invalid signature: "+sigErr.Error(), errArtifactInvalid)
+	case key != nil:
+		sigInfo = signatureVerified
+	case ar.IsSigned:
+		sigInfo = "signed, not verified"
+	}
+
+	if err := checkDepends(ar, c.String("device-type"), *provides); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+
+	fmt.Printf("Artifact '%s' can be installed (signature: %s)\n",
+		ar.GetArtifactName(), sigInfo)
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+func TestPreflight(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	bundle := filepath.Join(tmpdir, "preflight.tar")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, bytes.Repeat([]byte("update"), 100000), 0644))
+	privKey := filepath.Join(tmpdir, "private.key")
+	require.NoError(t, os.WriteFile(privKey, []byte(PrivateECDSAKey), 0600))
+	pubKey := filepath.Join(tmpdir, "public.key")
+	require.NoError(t, os.WriteFile(pubKey, []byte(PublicECDSAKey), 0644))
+	otherKey := filepath.Join(tmpdir, "other.key")
+	require.NoError(t, os.WriteFile(otherKey, []byte(PublicValidateRSAKey), 0644))
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "dev-1", "-t", "dev-2", "-n", "release-1",
+		"-N", "release-0", "-d", "custom:value", "-f", updateFile, "-k", privKey}))
+
+	require.NoError(t, Run([]string{"mender-artifact", "preflight", "-o", bundle, artfile}))
+
+	f, err := os.Open(bundle)
+	require.NoError(t, err)
+	defer f.Close()
+	var members []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		members = append(members, hdr.Name)
+	}
+	assert.Equal(t, []string{"version", "manifest", "manifest.sig", "header.tar.gz"}, members)
+
+	verify := func(args ...string) (string, error) {
+		return runAndCollectStdout(append(append([]string{
+			"mender-artifact", "preflight-verify"}, args...), bundle))
+	}
+
+	out, err := verify("-k", pubKey, "-t", "dev-2",
+		"-p", "artifact_name:release-0", "-p", "custom:value", "-p", "other:x")
+	require.NoError(t, err)
+	assert.Equal(t, "Artifact 'release-1' can be installed"+
+		" (signature: signed and verified correctly)", out)
+
+	out, err = verify("-p", "artifact_name:release-0", "-p", "custom:value")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(signature: signed, not verified)")
+
+	tests := map[string]struct {
+		args []string
+		err  string
+	}{
+		"wrong key": {
+			args: []string{"-k", otherKey},
+			err:  "invalid signature: ",
+		},
+		"wrong device type": {
+			args: []string{"-t", "dev-3"},
+			err: `the Artifact is not compatible with device type "dev-3";` +
+				" it is compatible with dev-1, dev-2",
+		},
+		"missing provides": {
+			args: []string{"-p", "artifact_name:release-0"},
+			err:  "the Artifact depends on custom, which the device does not provide",
+		},
+		"wrong provides": {
+			args: []string{"-p", "artifact_name:release-2", "-p", "custom:value"},
+			err:  `the Artifact depends on artifact_name [release-0], but the device has "release-2"`,
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := verify(test.args...)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+			assert.Equal(t, errArtifactInvalid, lastExitCode)
+		})
+	}
+
+	err = Run([]string{"mender-artifact", "preflight", "-o", bundle, updateFile})
+	assert.Error(t, err)
+	_, err = os.Stat(bundle)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestNotDeviceType(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	bundle := filepath.Join(tmpdir, "preflight.tar")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("update"), 0644))
+
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "testType", "-n", "release-1", "-f", updateFile,
+			"-t", "board", "-t", "board-rev1"}, args...))
+	}
+	err := write("--not-device-type", "board", "--not-device-type", "board-rev1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "all the compatible device types are excluded")
+	require.NoError(t, write("--not-device-type", "board-rev1"))
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Compatible devices: [board, board-rev1]\n"+
+		"  Excluded devices: [board-rev1]\n")
+	out, err = runAndCollectStdout([]string{"mender-artifact", "read",
+		"--field", "excluded_devices", artfile})
+	require.NoError(t, err)
+	assert.Equal(t, `["board-rev1"]`, out)
+	out, err = runAndCollectStdout([]string{"mender-artifact", "dump", "--print-cmdline",
+		artfile})
+	require.NoError(t, err)
+	assert.Contains(t, out, " --not-device-type board-rev1 ")
+
+	// Readers checking their device type reject the Artifact.
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	var compatible []string
+	ar.CompatibleDevicesCallback = func(devices []string) error {
+		compatible = devices
+		return nil
+	}
+	require.NoError(t, ar.ReadArtifactHeaders())
+	assert.Equal(t, []string{"board"}, compatible)
+	assert.Equal(t, []string{"board-rev1"}, ar.GetExcludedDevices())
+
+	require.NoError(t, Run([]string{"mender-artifact", "preflight", "-o", bundle, artfile}))
+	_, err = runAndCollectStdout([]string{"mender-artifact", "preflight-verify",
+		"-t", "board", bundle})
+	require.NoError(t, err)
+	_, err = runAndCollectStdout([]string{"mender-artifact", "preflight-verify",
+		"-t", "board-rev1", bundle})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `the Artifact excludes device type "board-rev1"`)
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// writeProfile is a coherent set of defaults of the write flags for a
+// generation of Mender clients.
+type writeProfile struct {
+	// client is the oldest client version of the generation, which written
+	// Artifacts are checked against.
+	client string
+	// flags are the defaults, which only apply to flags the command has and
+	// which are not given.
+	flags map[string]string
+}
+
+// writeProfiles are the profiles selected with --profile.
+var writeProfiles = map[string]writeProfile{
+	"mender-2.x": {
+		client: "2.0",
+		flags: map[string]string{
+			"version":                      strconv.Itoa(LatestFormatVersion),
+			"compression":                  "gzip",
+			"legacy-rootfs-image-checksum": "true",
+			noDefaultClearsProvidesFlag:    "true",
+			noDefaultSoftwareVersionFlag:   "true",
+		},
+	},
+	"mender-3.x": {
+		client: "3.0",
+		flags: map[string]string{
+			"version":                      strconv.Itoa(LatestFormatVersion),
+			"compression":                  "gzip",
+			"legacy-rootfs-image-checksum": "false",
+			noDefaultClearsProvidesFlag:    "false",
+			noDefaultSoftwareVersionFlag:   "false",
+		},
+	},
+	"latest": {
+		client: latestClientVersion(),
+		flags: map[string]string{
+			"version":                      strconv.Itoa(LatestFormatVersion),
+			"compression":                  "gzip",
+			"legacy-rootfs-image-checksum": "false",
+			noDefaultClearsProvidesFlag:    "false",
+			noDefaultSoftwareVersionFlag:   "false",
+		},
+	},
+}
+
+// latestClientVersion returns the newest client version of the capability
+// matrix, which handles every feature a Mender client handles.
+func latestClientVersion() string {
+	latest, latestParsed := "", []int{0, 0, 0}
+	for _, feature := range clientFeatures {
+		parsed, err := parseClientVersion(feature.since)
+		if err == nil && clientVersionBefore(latestParsed, parsed) {
+			latest, latestParsed = feature.since, parsed
+		}
+	}
+	return latest
+}
+
+func writeProfileNames() string {
+	names := make([]string, 0, len(writeProfiles))
+	for name := range writeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyWriteProfile sets the flags of the --profile to their defaults in the
+// profile, unless they are given on the command line or in the environment.
+func applyWriteProfile(c *cli.Context) error {
+	name := c.String("profile")
+	if name == "" {
+		return nil
+	}
+	profile, ok := writeProfiles[name]
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("Unknown profile %q; use one of %s",
+			name, writeProfileNames()), errArtifactInvalidParameters)
+	}
+	has := map[string]bool{}
+	for _, flag := range c.Command.Flags {
+		has[strings.TrimSpace(strings.Split(flag.GetName(), ",")[0])] = true
+	}
+	for flag, value := range profile.flags {
+		if !has[flag] || c.IsSet(flag) || (flag == "compression" && c.GlobalIsSet(flag)) {
+			continue
+		}
+		if err := c.Set(flag, value); err != nil {
+			return errors.Wrapf(err, "profile %s", name)
+		}
+	}
+	return nil
+}
+
+// applyProfileInCommand applies the --profile, and then the --compression
+// of the command.
+func applyProfileInCommand(c *cli.Context) error {
+	if err := applyWriteProfile(c); err != nil {
+		return err
+	}
+	return applyCompressionInCommand(c)
+}
+
+// withProfileCheck runs the write action, and then warns about the features
+// of the written Artifacts which the client generation of the --profile
+// does not handle.
+func withProfileCheck(action func(*cli.Context) error) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		if err := action(c); err != nil || c.String("profile") == "" || c.Bool("dry-run") {
+			return err
+		}
+		name := c.String("output-path")
+		if name == "" {
+			name = "artifact.mender"
+		} else if name == "-" {
+			return nil
+		}
+		outputs := []string{name}
+		if len(c.StringSlice("provides-for-device")) > 0 {
+			devices, err := deviceTypes(c)
+			if err != nil {
+				return err
+			}
+			outputs = outputs[:0]
+			for _, device := range devices {
+				outputs = append(outputs, perDeviceOutputPath(name, device))
+			}
+		}
+		profile := writeProfiles[c.String("profile")]
+		for _, output := range outputs {
+			if err := checkProfileClient(output, c.String("profile"), profile); err != nil {
+				return cli.NewExitError(err.Error(), errArtifactOpen)
+			}
+		}
+		return nil
+	}
+}
+
+func checkProfileClient(path, name string, profile writeProfile) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "can not check the written Artifact")
+	}
+	defer f.Close()
+	ar := areader.NewReader(f)
+	if err = ar.ReadArtifactHeaders(); err != nil {
+		return errors.Wrap(err, "can not check the written Artifact")
+	}
+	warnings, err := checkTargetClient(ar, profile.client)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		warnf(WarningTargetClient, "Profile %s: %s", name, warning)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// readProfileArtifact returns the merged provides and clears provides of
+// the Artifact at path.
+func readProfileArtifact(t *testing.T, path string) (*areader.Reader, map[string]string) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifact())
+	provides, err := ar.MergeArtifactProvides()
+	require.NoError(t, err)
+	return ar, provides
+}
+
+func TestWriteProfile(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "rootfs.ext4", "rootfs")
+	art := filepath.Join(dir, "artifact.mender")
+	writeRootfs := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "rootfs-image",
+			"-t", "my-device", "-n", "release-1", "-f", filepath.Join(dir, "rootfs.ext4"),
+			"-o", art}, args...))
+	}
+
+	require.NoError(t, writeRootfs("--profile", "mender-2.x"))
+	ar, provides := readProfileArtifact(t, art)
+	assert.Contains(t, provides, artifact.LegacyRootfsImageChecksumKey)
+	assert.NotContains(t, provides, artifact.RootfsImageChecksumKey)
+	assert.NotContains(t, provides, "rootfs-image.version")
+	assert.Empty(t, ar.MergeArtifactClearsProvides())
+	assert.Equal(t, ".gz", ar.Compressor().GetFileExtension())
+	assert.NotContains(t, warningClasses(Warnings()), WarningTargetClient)
+
+	for _, profile := range []string{"mender-3.x", "latest"} {
+		require.NoError(t, writeRootfs("--profile", profile))
+		ar, provides = readProfileArtifact(t, art)
+		assert.Contains(t, provides, artifact.RootfsImageChecksumKey, profile)
+		assert.Equal(t, "release-1", provides["rootfs-image.version"], profile)
+		assert.NotEmpty(t, ar.MergeArtifactClearsProvides(), profile)
+	}
+
+	// Explicit flags take precedence, and the Artifact is checked against
+	// the clients of the profile.
+	require.NoError(t, writeRootfs("--profile", "mender-2.x", "--compression", "zstd_fast",
+		"--no-default-software-version=false"))
+	ar, provides = readProfileArtifact(t, art)
+	assert.Equal(t, ".zst", ar.Compressor().GetFileExtension())
+	assert.Equal(t, "release-1", provides["rootfs-image.version"])
+	require.Len(t, Warnings(), 2)
+	assert.Equal(t, WarningTargetClient, Warnings()[1].Class)
+	assert.Equal(t, "Profile mender-2.x: zstd compression requires Mender client 3.0 or"+
+		" later, the target is 2.0", Warnings()[1].Message)
+
+	err := writeRootfs("--profile", "mender-1.x")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		`Unknown profile "mender-1.x"; use one of latest, mender-2.x, mender-3.x`)
+}
+
+func TestWriteProfileModuleImage(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	art := filepath.Join(dir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-t", "my-device", "-n", "release-1", "-T", "app", "-f", filepath.Join(dir, "file"),
+		"-o", art, "--profile", "mender-2.x"})
+	require.NoError(t, err)
+	ar, provides := readProfileArtifact(t, art)
+	assert.NotContains(t, provides, "rootfs-image.app.version")
+	assert.Empty(t, ar.MergeArtifactClearsProvides())
+}
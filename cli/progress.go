@@ -0,0 +1,145 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mattn/go-isatty"
+	"github.com/mendersoftware/progressbar"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact/stage"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+var noProgressFlag = cli.BoolFlag{
+	Name:  "no-progress",
+	Usage: "Suppress the progressbar output",
+}
+
+// showProgress returns true if a command with the --no-progress flag should
+// report its progress, which it does unless --no-progress or --progress
+// never is given.
+func showProgress(c *cli.Context) bool {
+	return !c.Bool("no-progress") && c.GlobalString("progress") != "never"
+}
+
+// imageProgress reports what the image modification commands are doing while
+// they unpack, extract and repack whole images, which can take minutes. It is
+// nil, and silent, unless progress is enabled with --progress.
+var imageProgress *stageReporter
+
+// stageReporter prints the names of stages, and progress bars for stages
+// which copy data of a known size.
+type stageReporter struct {
+	out  io.Writer
+	mark string
+}
+
+// newStageReporter returns the reporter for progressMode, which is one of
+// "auto", "always" and "never". With auto, progress is only reported on
+// terminals. colorMode is that of statusMark.
+func newStageReporter(progressMode, colorMode string, out *os.File) *stageReporter {
+	terminal := isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd())
+	if progressMode == "always" || (progressMode == "auto" && terminal) {
+		return &stageReporter{out: out, mark: statusMark(colorMode, out)}
+	}
+	return nil
+}
+
+// Stage reports the start of a stage.
+func (s *stageReporter) Stage(format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+// Reader returns r, which has size bytes, wrapped to show the progress of
+// reading it.
+func (s *stageReporter) Reader(r io.Reader, size int64) io.Reader {
+	if s == nil {
+		return r
+	}
+	return utils.NewProgressReader().Wrap(r, size)
+}
+
+// ShowReading makes ar show the stages of reading the Artifact.
+func (s *stageReporter) ShowReading(ar *areader.Reader) {
+	if s != nil {
+		ar.StageProgressCallback = newReadProgress(s.out, s.mark).report
+	}
+}
+
+// ShowWriting makes aw show the progress of writing its payloads.
+func (s *stageReporter) ShowWriting(aw *awriter.Writer) {
+	if s != nil {
+		aw.ProgressWriter = utils.NewProgressWriter()
+	}
+}
+
+// readProgress prints the stages of reading an Artifact, as reportProgress
+// does for writing, with a progress bar for the data of each payload.
+type readProgress struct {
+	out   io.Writer
+	mark  string
+	stage string
+	bar   *progressbar.Bar
+	read  int64
+}
+
+func newReadProgress(out io.Writer, mark string) *readProgress {
+	return &readProgress{out: out, mark: mark}
+}
+
+// readProgressFn returns the callback which prints the stages of reading an
+// Artifact to stderr, or nil if the command should not report its progress.
+func readProgressFn(c *cli.Context) areader.ProgressFn {
+	if !showProgress(c) {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "Reading Artifact...")
+	return newReadProgress(os.Stderr, statusMark(c.GlobalString("color"), os.Stderr)).report
+}
+
+func (p *readProgress) report(event areader.ProgressEvent) {
+	if event.Stage != p.stage {
+		if p.stage != "" && p.stage != stage.Data {
+			fmt.Fprintln(p.out, p.mark)
+		}
+		p.stage = event.Stage
+		if event.Stage == stage.Data {
+			fmt.Fprintln(p.out, "Payload")
+		} else {
+			fmt.Fprintf(p.out, "%-20s\t", event.Stage)
+		}
+	}
+	if event.Stage != stage.Data {
+		return
+	}
+	if event.Read == 0 {
+		// The data of the next payload.
+		p.bar = progressbar.New(event.Size)
+		p.read = 0
+		return
+	}
+	p.bar.Tick(event.Read - p.read)
+	p.read = event.Read
+}
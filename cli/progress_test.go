@@ -0,0 +1,100 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+func TestStageReporter(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "progress"))
+	require.NoError(t, err)
+	defer out.Close()
+
+	assert.Nil(t, newStageReporter("never", "auto", out))
+	// Not a terminal.
+	assert.Nil(t, newStageReporter("auto", "auto", out))
+
+	var silent *stageReporter
+	silent.Stage("Nothing to see")
+
+	s := newStageReporter("always", "auto", out)
+	require.NotNil(t, s)
+	s.Stage("Unpacking Artifact %s", "artifact.mender")
+	data, err := os.ReadFile(out.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "Unpacking Artifact artifact.mender\n", string(data))
+}
+
+func TestProgressFlag(t *testing.T) {
+	err := Run([]string{"mender-artifact", "--progress", "sometimes", "validate", "foo"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --progress value")
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"-f", update}))
+	require.NoError(t, Run([]string{"mender-artifact", "--progress", "always",
+		"modify", "-n", "newName", artfile}))
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Name: newName")
+}
+
+func TestReadProgress(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"-f", update}))
+
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	var out bytes.Buffer
+	ar := areader.NewReader(f)
+	ar.StageProgressCallback = newReadProgress(&out, "OK").report
+	require.NoError(t, ar.ReadArtifact())
+	assert.Equal(t, "Version             \tOK\n"+
+		"Manifest            \tOK\n"+
+		"Header              \tOK\n"+
+		"Payload\n", out.String())
+
+	key := filepath.Join(tmpdir, "private.key")
+	require.NoError(t, os.WriteFile(key, []byte(PrivateECDSAKey), 0600))
+	for _, args := range [][]string{
+		{"validate", "--no-progress", artfile},
+		{"--progress", "never", "validate", "--json", artfile},
+		{"sign", "--no-progress", "-k", key, "-o", filepath.Join(tmpdir, "signed.mender"),
+			artfile},
+	} {
+		require.NoError(t, Run(append([]string{"mender-artifact"}, args...)), args)
+	}
+}
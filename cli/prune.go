@@ -0,0 +1,147 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// pruneCandidate is an Artifact found in the directory being pruned.
+type pruneCandidate struct {
+	path    string
+	name    string
+	devices []string
+	modTime time.Time
+	keep    bool
+}
+
+func pruneArtifacts(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError("Please give the directory to prune",
+			errArtifactInvalidParameters)
+	}
+	keep := c.Int("keep-latest")
+	if keep < 1 {
+		return cli.NewExitError("--keep-latest must be at least 1",
+			errArtifactInvalidParameters)
+	}
+
+	candidates, err := readPruneCandidates(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactOpen)
+	}
+	selectPruned(candidates, keep, c.Bool("per-device-type"))
+
+	pruned := 0
+	for _, a := range candidates {
+		action := "keep"
+		if !a.keep {
+			action = "delete"
+			pruned++
+		}
+		fmt.Printf("%-6s %s (%s, device types: %s)\n", action, a.path,
+			displayValue(a.name), strings.Join(displayValues(a.devices), ", "))
+	}
+
+	if !c.Bool("delete") {
+		fmt.Printf("Dry run; use --delete to remove %d Artifact(s)\n", pruned)
+		return nil
+	}
+	for _, a := range candidates {
+		if a.keep {
+			continue
+		}
+		if err = os.Remove(a.path); err != nil {
+			return cli.NewExitError("Can not remove artifact: "+err.Error(), errSystemError)
+		}
+	}
+	fmt.Printf("Removed %d Artifact(s)\n", pruned)
+	return nil
+}
+
+// readPruneCandidates reads the headers of the Artifacts in dir. Files which
+// are not Artifacts are left alone.
+func readPruneCandidates(dir string) ([]*pruneCandidate, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read the Artifact directory")
+	}
+	var candidates []*pruneCandidate
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "can not open artifact")
+		}
+		ar := areader.NewReader(f)
+		err = ar.ReadArtifactHeaders()
+		f.Close()
+		if err != nil {
+			warnf(WarningFileSkipped, "Skipping %s, which is not an Artifact: %s",
+				path, err.Error())
+			continue
+		}
+		candidates = append(candidates, &pruneCandidate{
+			path:    path,
+			name:    ar.GetArtifactName(),
+			devices: ar.GetCompatibleDevices(),
+			modTime: info.ModTime(),
+		})
+	}
+	return candidates, nil
+}
+
+// selectPruned marks the keep latest Artifacts to be kept, newest first by
+// modification time, as Artifacts carry no build time. With perDeviceType the
+// latest are kept for each device type, and an Artifact is kept if it is
+// among the latest for any of its device types.
+func selectPruned(candidates []*pruneCandidate, keep int, perDeviceType bool) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].modTime.Equal(candidates[j].modTime) {
+			return candidates[i].modTime.After(candidates[j].modTime)
+		}
+		return candidates[i].path > candidates[j].path
+	})
+
+	kept := make(map[string]int)
+	for _, a := range candidates {
+		groups := []string{""}
+		if perDeviceType {
+			groups = a.devices
+		}
+		for _, group := range groups {
+			if kept[group] < keep {
+				a.keep = true
+			}
+		}
+		for _, group := range groups {
+			kept[group]++
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	tmpdir := t.TempDir()
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	dir := filepath.Join(tmpdir, "artifacts")
+	require.NoError(t, os.Mkdir(dir, 0755))
+
+	now := time.Now()
+	write := func(name, device string, age time.Duration) {
+		artfile := filepath.Join(dir, name+".mender")
+		require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "testType", "-t", device, "-n", name,
+			"-f", update}))
+		require.NoError(t, os.Chtimes(artfile, now.Add(-age), now.Add(-age)))
+	}
+	write("a1", "devA", 4*time.Hour)
+	write("a2", "devA", 3*time.Hour)
+	write("b1", "devB", 2*time.Hour)
+	write("a3", "devA", time.Hour)
+	notArtifact := filepath.Join(dir, "README")
+	require.NoError(t, os.WriteFile(notArtifact, []byte("not an Artifact"), 0644))
+	path := func(name string) string {
+		return filepath.Join(dir, name+".mender")
+	}
+
+	collectedWarnings.reset()
+	out, err := runAndCollectStdout([]string{"mender-artifact", "prune",
+		"--keep-latest", "2", dir})
+	require.NoError(t, err)
+	assert.Equal(t, "keep   "+path("a3")+" (a3, device types: devA)\n"+
+		"keep   "+path("b1")+" (b1, device types: devB)\n"+
+		"delete "+path("a2")+" (a2, device types: devA)\n"+
+		"delete "+path("a1")+" (a1, device types: devA)\n"+
+		"Dry run; use --delete to remove 2 Artifact(s)", out)
+	assert.Contains(t, warningClasses(Warnings()), WarningFileSkipped)
+	assert.FileExists(t, path("a1"))
+
+	out, err = runAndCollectStdout([]string{"mender-artifact", "prune",
+		"--keep-latest", "2", "--per-device-type", "--delete", dir})
+	require.NoError(t, err)
+	assert.Contains(t, out, "delete "+path("a1"))
+	assert.Contains(t, out, "Removed 1 Artifact(s)")
+	assert.NoFileExists(t, path("a1"))
+	for _, name := range []string{"a2", "a3", "b1"} {
+		assert.FileExists(t, path(name))
+	}
+	assert.FileExists(t, notArtifact)
+
+	err = Run([]string{"mender-artifact", "prune", "--keep-latest", "0", dir})
+	require.Error(t, err)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+}
@@ -19,10 +19,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
@@ -30,7 +35,6 @@ import (
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
 	"github.com/mendersoftware/mender-artifact/handlers"
-	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 var defaultIndentation = "  "
@@ -50,18 +54,44 @@ func sortedKeys(mapWithKeys interface{}) sort.StringSlice {
 	return keys
 }
 
+// displayValue returns value as it is if it displays unambiguously, or
+// quoted and escaped if it is not valid UTF-8, holds characters which are
+// not printable, such as line breaks or terminal escape sequences, or starts
+// with a quote.
+func displayValue(value string) string {
+	if !utf8.ValidString(value) || strings.HasPrefix(value, `"`) {
+		return strconv.Quote(value)
+	}
+	for _, c := range value {
+		if !unicode.IsPrint(c) {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+// displayValues returns the displayValue of each of values.
+func displayValues(values []string) []string {
+	displayed := make([]string, len(values))
+	for i, value := range values {
+		displayed[i] = displayValue(value)
+	}
+	return displayed
+}
+
 func printList(title string, iterable []string, err string, shouldFlow bool, indentationLevel int) {
-	fmt.Printf("%s%s:", strings.Repeat(defaultIndentation, indentationLevel), title)
+	fmt.Printf("%s%s:", strings.Repeat(defaultIndentation, indentationLevel), tr(title))
 	if len(err) > 0 {
 		fmt.Printf("%s\n", err)
 	} else if len(iterable) == 0 {
 		fmt.Printf(" []\n")
 	} else if shouldFlow {
-		fmt.Printf(" [%s]\n", strings.Join(iterable, ", "))
+		fmt.Printf(" [%s]\n", strings.Join(displayValues(iterable), ", "))
 	} else {
 		fmt.Printf("\n")
 		for _, value := range iterable {
-			fmt.Printf("%s- %s\n", strings.Repeat(defaultIndentation, indentationLevel+1), value)
+			fmt.Printf("%s- %s\n", strings.Repeat(defaultIndentation, indentationLevel+1),
+				displayValue(value))
 		}
 	}
 }
@@ -72,7 +102,7 @@ func printObject(
 	err string,
 	indentationLevel int,
 ) {
-	fmt.Printf("%s%s:", strings.Repeat(defaultIndentation, indentationLevel), title)
+	fmt.Printf("%s%s:", strings.Repeat(defaultIndentation, indentationLevel), tr(title))
 	if len(err) > 0 {
 		fmt.Printf("%s\n", err)
 	} else if len(someObject) == 0 {
@@ -82,7 +112,8 @@ func printObject(
 		keys := sortedKeys(someObject)
 		for _, key := range keys {
 			fmt.Printf("%s%s: %s\n",
-				strings.Repeat(defaultIndentation, indentationLevel+1), key, (someObject)[key])
+				strings.Repeat(defaultIndentation, indentationLevel+1), displayValue(key),
+				displayValue(fmt.Sprintf("%s", someObject[key])))
 		}
 	}
 }
@@ -96,7 +127,8 @@ func printUnnamedObject(
 	} else {
 		keys := sortedKeys(someObject)
 		for index, key := range keys {
-			entry := fmt.Sprintf("%s: %s", key, (someObject)[key])
+			entry := fmt.Sprintf("%s: %s",
+				displayValue(key), displayValue(fmt.Sprintf("%s", someObject[key])))
 			if index == 0 {
 				fmt.Printf("%s- %s\n", strings.Repeat(defaultIndentation, indentationLevel), entry)
 				continue
@@ -110,35 +142,109 @@ func printUnnamedObject(
 
 func printHeader(ar *areader.Reader, sigInfo string, indentationLevel int) {
 	info := ar.GetInfo()
-	fmt.Printf("%sMender Artifact:\n", strings.Repeat(defaultIndentation, indentationLevel))
+	fmt.Printf("%s%s:\n", strings.Repeat(defaultIndentation, indentationLevel), tr("Mender Artifact"))
 	fmt.Printf(
-		"%sName: %s\n",
-		strings.Repeat(defaultIndentation, indentationLevel+1),
-		ar.GetArtifactName(),
+		"%s%s: %s\n",
+		strings.Repeat(defaultIndentation, indentationLevel+1), tr("Name"),
+		displayValue(ar.GetArtifactName()),
 	)
 	fmt.Printf(
-		"%sFormat: %s\n",
-		strings.Repeat(defaultIndentation, indentationLevel+1),
-		info.Format,
+		"%s%s: %s\n",
+		strings.Repeat(defaultIndentation, indentationLevel+1), tr("Format"),
+		displayValue(info.Format),
 	)
 	fmt.Printf(
-		"%sVersion: %d\n",
-		strings.Repeat(defaultIndentation, indentationLevel+1),
+		"%s%s: %d\n",
+		strings.Repeat(defaultIndentation, indentationLevel+1), tr("Version"),
 		info.Version,
 	)
-	fmt.Printf("%sSignature: %s\n", strings.Repeat(defaultIndentation, indentationLevel+1), sigInfo)
+	if info.ChecksumAlgorithm != "" {
+		fmt.Printf("%s%s: %s\n", strings.Repeat(defaultIndentation, indentationLevel+1),
+			tr("Checksum algorithm"), info.ChecksumAlgorithm)
+	}
+	fmt.Printf("%s%s: %s\n", strings.Repeat(defaultIndentation, indentationLevel+1),
+		tr("Signature"), tr(sigInfo))
 	printList("Compatible devices", ar.GetCompatibleDevices(), "", true, indentationLevel+1)
+	if excluded := ar.GetExcludedDevices(); len(excluded) > 0 {
+		printList("Excluded devices", excluded, "", true, indentationLevel+1)
+	}
+}
+
+// printArtifactInfo prints the header and the artifact wide provides and
+// depends.
+func printArtifactInfo(ar *areader.Reader, sigInfo string) {
+	printHeader(ar, sigInfo, 0)
+
+	provides := ar.GetArtifactProvides()
+	if provides != nil {
+		fmt.Printf("%s%s: %s\n", defaultIndentation, tr("Provides group"),
+			displayValue(provides.ArtifactGroup))
+	}
+
+	depends := ar.GetArtifactDepends()
+	if depends != nil {
+		fmt.Printf(
+			"%s%s: [%s]\n",
+			defaultIndentation, tr("Depends on one of artifact(s)"), strings.Join(displayValues(depends.ArtifactName), ", "),
+		)
+		fmt.Printf(
+			"%s%s: [%s]\n",
+			defaultIndentation, tr("Depends on one of group(s)"), strings.Join(displayValues(depends.ArtifactGroup), ", "),
+		)
+	}
+	if ar.IsMetadataImmutable() {
+		fmt.Printf("%s%s: true\n", defaultIndentation, tr("Immutable metadata"))
+	}
+}
+
+// describeSignature returns a signature verification callback which, instead
+// of failing, describes the signature status in sigInfo. Without a key the
+// signature is only reported as present. If the Artifact has several
+// signatures, it is reported as verified if any of them is.
+func describeSignature(key SigningKey, sigInfo *string) areader.SignatureVerifyFn {
+	return func(message, sig []byte) error {
+		if *sigInfo == signatureVerified {
+			return nil
+		}
+		*sigInfo = signatureNoKey
+		if key != nil {
+			if err := key.Verify(message, sig); err != nil {
+				*sigInfo = signatureFailed
+			} else {
+				*sigInfo = signatureVerified
+			}
+		}
+		return nil
+	}
 }
 
 func printStateScripts(scripts []string, indentationLevel int) {
 	printList("State scripts", scripts, "", false, indentationLevel)
 }
 
-func printFiles(files []*handlers.DataFile, indentationLevel int) {
+// printDeviceTypeScripts prints the state scripts of each device type, if
+// there are any.
+func printDeviceTypeScripts(scripts map[string][]string, indentationLevel int) {
+	deviceTypes := make([]string, 0, len(scripts))
+	for deviceType := range scripts {
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	sort.Strings(deviceTypes)
+	for _, deviceType := range deviceTypes {
+		printList(trf("State scripts for device type %s", deviceType), scripts[deviceType], "",
+			false, indentationLevel)
+	}
+}
+
+func printFiles(
+	files []*handlers.DataFile,
+	signatures map[string]string,
+	indentationLevel int,
+) {
 	if len(files) == 0 {
-		fmt.Printf("%sFiles: []\n", strings.Repeat(defaultIndentation, indentationLevel))
+		fmt.Printf("%s%s: []\n", strings.Repeat(defaultIndentation, indentationLevel), tr("Files"))
 	} else {
-		fmt.Printf("%sFiles:\n", strings.Repeat(defaultIndentation, indentationLevel))
+		fmt.Printf("%s%s:\n", strings.Repeat(defaultIndentation, indentationLevel), tr("Files"))
 		for _, f := range files {
 			data := map[string]interface{}{
 				"name":     f.Name,
@@ -146,6 +252,9 @@ func printFiles(files []*handlers.DataFile, indentationLevel int) {
 				"modified": f.Date,
 				"checksum": f.Checksum,
 			}
+			if sig, ok := signatures[filepath.Base(f.Name)]; ok {
+				data["signature"] = sig
+			}
 			printUnnamedObject(data, indentationLevel+1)
 		}
 	}
@@ -155,7 +264,7 @@ func printProvides(p handlers.Installer, indentationLevel int) {
 	provides, err := p.GetUpdateProvides()
 	error := ""
 	if err != nil {
-		error = fmt.Sprintf(" Invalid provides section: %s", err.Error())
+		error = " " + trf("Invalid provides section: %s", err.Error())
 	}
 	providesWorkaround := make(map[string]interface{}, len(provides))
 	for k, v := range provides {
@@ -168,11 +277,18 @@ func printDepends(p handlers.Installer, indentationLevel int) {
 	depends, err := p.GetUpdateDepends()
 	error := ""
 	if err != nil {
-		error = fmt.Sprintf(" Invalid depends section: %s", err.Error())
+		error = " " + trf("Invalid depends section: %s", err.Error())
 	}
 	printObject("Depends", depends, error, indentationLevel)
 }
 
+func printInstallSize(p handlers.Installer, indentationLevel int) {
+	if size := p.GetUpdateInstallSize(); size > 0 {
+		fmt.Printf("%s%s: %d\n",
+			strings.Repeat(defaultIndentation, indentationLevel), tr("Install size"), size)
+	}
+}
+
 func printClearsProvides(p handlers.Installer, indentationLevel int) {
 	caps := p.GetUpdateClearsProvides()
 	printList("Clears Provides", caps, "", true, indentationLevel)
@@ -180,9 +296,9 @@ func printClearsProvides(p handlers.Installer, indentationLevel int) {
 
 func printUpdateMetadata(p handlers.Installer, indentationLevel int) {
 	metaData, err := p.GetUpdateMetaData()
-	fmt.Printf("%sMetadata:", strings.Repeat(defaultIndentation, indentationLevel))
+	fmt.Printf("%s%s:", strings.Repeat(defaultIndentation, indentationLevel), tr("Metadata"))
 	if err != nil {
-		fmt.Printf(" Invalid metadata section: %s\n", err.Error())
+		fmt.Printf(" %s\n", trf("Invalid metadata section: %s", err.Error()))
 	} else if len(metaData) == 0 {
 		fmt.Printf(" {}\n")
 	} else {
@@ -199,7 +315,7 @@ func printUpdateMetadata(p handlers.Installer, indentationLevel int) {
 				defaultIndentation)
 		}
 		if err != nil {
-			fmt.Printf(" Invalid metadata section: %s\n", err.Error())
+			fmt.Printf(" %s\n", trf("Invalid metadata section: %s", err.Error()))
 		} else {
 			fmt.Printf("\n")
 			fmt.Printf(
@@ -213,13 +329,13 @@ func printUpdateMetadata(p handlers.Installer, indentationLevel int) {
 func printType(p handlers.Installer, indentationLevel int) {
 	updateType := p.GetUpdateType()
 	if updateType == nil {
-		emptyType := "Empty type"
+		emptyType := tr("Empty type")
 		updateType = &emptyType
 	}
 	fmt.Printf(
-		"%s- Type: %v\n",
-		strings.Repeat(defaultIndentation, indentationLevel),
-		*updateType,
+		"%s- %s: %v\n",
+		strings.Repeat(defaultIndentation, indentationLevel), tr("Type"),
+		displayValue(*updateType),
 	)
 }
 
@@ -230,12 +346,13 @@ func printPayload(p handlers.Installer, indentationLevel int) {
 	printProvides(p, indentationLevel+1)
 	printDepends(p, indentationLevel+1)
 	printClearsProvides(p, indentationLevel+1)
+	printInstallSize(p, indentationLevel+1)
 	printUpdateMetadata(p, indentationLevel+1)
-	printFiles(p.GetUpdateAllFiles(), indentationLevel+1)
+	printFiles(p.GetUpdateAllFiles(), p.GetUpdatePayloadSignatures(), indentationLevel+1)
 }
 
 func printUpdates(updatePayloads map[int]handlers.Installer, indentationLevel int) {
-	fmt.Printf("%sUpdates:\n", strings.Repeat(defaultIndentation, indentationLevel))
+	fmt.Printf("%s%s:\n", strings.Repeat(defaultIndentation, indentationLevel), tr("Updates"))
 	for _, payload := range updatePayloads {
 		printPayload(payload, indentationLevel+1)
 	}
@@ -243,43 +360,63 @@ func printUpdates(updatePayloads map[int]handlers.Installer, indentationLevel in
 
 func readArtifact(c *cli.Context) error {
 	if c.NArg() == 0 {
-		return cli.NewExitError("Nothing specified, nothing read. \nMaybe you wanted"+
-			" to say 'artifacts read <pathspec>'?", errArtifactInvalidParameters)
+		return cli.NewExitError(tr(nothingRead), errArtifactInvalidParameters)
 	}
 
-	f, err := os.Open(c.Args().First())
+	f, err := openArtifactInput(c.Args().First())
 	if err != nil {
-		return cli.NewExitError("Can not open artifact: "+c.Args().First(),
+		return cli.NewExitError(trf("Can not open artifact: %s", c.Args().First()),
 			errArtifactOpen)
 	}
 	defer f.Close()
 
-	var verifyCallback areader.SignatureVerifyFn
-
 	key, err := getKey(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
 	}
-	if key != nil {
-		verifyCallback = key.Verify
+
+	var cache []string
+	if c.String("plan") != "" {
+		if cache, err = readPlanCache(c.String("plan")); err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
 	}
 
-	// if key is not provided just continue reading artifact returning
-	// info that signature can not be verified
-	sigInfo := "no signature"
-	ver := func(message, sig []byte) error {
-		sigInfo = "signed but no key for verification provided; " +
-			"please use `-k` option for providing verification key"
-		if key != nil {
-			err = verifyCallback(message, sig)
-			if err != nil {
-				sigInfo = "signed; verification using provided key failed"
-			} else {
-				sigInfo = "signed and verified correctly"
+	if !c.Bool("multi") {
+		return readAndPrintArtifact(c, areader.NewReader(f), key, cache)
+	}
+
+	mr := areader.NewMultiReader(f)
+	for i := 0; ; i++ {
+		ar, err := mr.Next()
+		if err == io.EOF {
+			if i == 0 {
+				return cli.NewExitError("No Artifacts found in: "+artifactInputName(c.Args().First()), 1)
 			}
+			return nil
+		} else if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		if err = readAndPrintArtifact(c, ar, key, cache); err != nil {
+			return err
 		}
-		return nil
 	}
+}
+
+// readAndPrintArtifact reads the Artifact from ar and prints its contents,
+// and the download plan for a device with the cached files if --plan is given.
+// With --field or --json only the selected fields are printed.
+func readAndPrintArtifact(
+	c *cli.Context,
+	ar *areader.Reader,
+	key SigningKey,
+	cache []string,
+) error {
+	sigInfo := signatureNone
+	ver := describeSignature(key, &sigInfo)
 
 	var scripts []string
 	readScripts := func(r io.Reader, info os.FileInfo) error {
@@ -287,44 +424,91 @@ func readArtifact(c *cli.Context) error {
 		return nil
 	}
 
-	ar := areader.NewReader(f)
-	if !c.Bool("no-progress") {
-		fmt.Fprintln(os.Stderr, "Reading Artifact...")
-		ar.ProgressReader = utils.NewProgressReader()
-	}
+	ar.ReadBufferSize = c.Int("read-buffer-size")
+	ar.ReadAheadBuffers = c.Int("read-ahead")
+	ar.TranslateLegacyProvides = c.Bool("translate-legacy-provides")
+	ar.StageProgressCallback = readProgressFn(c)
 	ar.ScriptsReadCallback = readScripts
 	ar.VerifySignatureCallback = ver
-	err = ar.ReadArtifact()
+	err := ar.ReadArtifact()
 	if err != nil {
 		if errors.Cause(err) == artifact.ErrCompatibleDevices {
-			return cli.NewExitError("Invalid Artifact. No 'device-type' found.", 1)
+			return cli.NewExitError(tr("Invalid Artifact. No 'device-type' found."), 1)
 		}
 		return cli.NewExitError(err.Error(), 1)
 	}
-
-	printHeader(ar, sigInfo, 0)
-
-	provides := ar.GetArtifactProvides()
-	if provides != nil {
-		fmt.Printf("%sProvides group: %s\n", defaultIndentation, provides.ArtifactGroup)
+	if c.Bool("bootstrap-check") {
+		if err = checkBootstrap(ar); err != nil {
+			return err
+		}
 	}
 
-	depends := ar.GetArtifactDepends()
-	if depends != nil {
-		fmt.Printf(
-			"%sDepends on one of artifact(s): [%s]\n",
-			defaultIndentation, strings.Join(depends.ArtifactName, ", "),
-		)
-		fmt.Printf(
-			"%sDepends on one of group(s): [%s]\n",
-			defaultIndentation, strings.Join(depends.ArtifactGroup, ", "),
-		)
+	if len(c.StringSlice("field")) > 0 || c.Bool("json") {
+		doc, err := getArtifactFields(ar, sigInfo, scripts)
+		if err == nil {
+			err = printFields(doc, c.StringSlice("field"), c.Bool("json"))
+		}
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
 	}
 
+	printArtifactInfo(ar, sigInfo)
 	printStateScripts(scripts, 1)
+	printDeviceTypeScripts(ar.GetDeviceTypeScripts(), 1)
 	fmt.Println()
 	updatePayloads := ar.GetHandlers()
 	printUpdates(updatePayloads, 0)
 
+	if c.String("plan") != "" {
+		plan, err := ar.PlanDownload(cache)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		printDownloadPlan(plan, 0)
+	}
+	if c.Bool("bootstrap-check") {
+		fmt.Println("\n" + tr("Valid bootstrap Artifact"))
+	}
+
 	return nil
 }
+
+// readPlanCache reads the checksums of the files a device already has, from
+// a JSON list of sha256 checksums.
+func readPlanCache(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read the plan cache")
+	}
+	var cache []string
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, errors.Wrapf(err, "%s is not a JSON list of checksums", path)
+	}
+	return cache, nil
+}
+
+func printDownloadPlan(plan *areader.DownloadPlan, indentationLevel int) {
+	indent := strings.Repeat(defaultIndentation, indentationLevel)
+	fmt.Printf("%s%s:\n", indent, tr("Download plan"))
+	for _, payload := range plan.Payloads {
+		action := "skip"
+		if payload.Transfer {
+			action = "transfer"
+		}
+		fmt.Printf("%s%s- %s: %s\n", indent, defaultIndentation, payload.DataMember, action)
+		for _, f := range payload.Files {
+			state := "missing"
+			if f.Cached {
+				state = "cached"
+			}
+			fmt.Printf("%s%s%s%s: %s (%d bytes)\n", indent, defaultIndentation,
+				defaultIndentation+defaultIndentation, displayValue(f.Name), state, f.Size)
+		}
+	}
+	fmt.Printf("%s%s%s: %d\n", indent, defaultIndentation, tr("Transfer size"),
+		plan.TransferSize())
+	fmt.Printf("%s%s%s: %d\n", indent, defaultIndentation, tr("Cached size"),
+		plan.CachedSize())
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// artifactFields is the document which `read --field` selects from.
+type artifactFields struct {
+	Name              string                 `json:"name"`
+	Format            string                 `json:"format"`
+	Version           int                    `json:"version"`
+	ChecksumAlgorithm string                 `json:"checksum_algorithm,omitempty"`
+	Signature         string                 `json:"signature"`
+	CompatibleDevices []string               `json:"compatible_devices"`
+	ExcludedDevices   []string               `json:"excluded_devices,omitempty"`
+	Provides          map[string]string      `json:"provides,omitempty"`
+	Depends           map[string]interface{} `json:"depends,omitempty"`
+	ClearsProvides    []string               `json:"clears_provides,omitempty"`
+	StateScripts      []string               `json:"state_scripts,omitempty"`
+	DeviceTypeScripts map[string][]string    `json:"device_type_scripts,omitempty"`
+	Payloads          []payloadFields        `json:"payloads"`
+}
+
+type payloadFields struct {
+	Type           string                 `json:"type"`
+	Provides       map[string]string      `json:"provides,omitempty"`
+	Depends        map[string]interface{} `json:"depends,omitempty"`
+	ClearsProvides []string               `json:"clears_provides,omitempty"`
+	MetaData       map[string]interface{} `json:"meta_data,omitempty"`
+	InstallSize    int64                  `json:"install_size,omitempty"`
+	Files          []string               `json:"files"`
+}
+
+// getArtifactFields collects the fields of the Artifact read by ar. The
+// provides and depends of the Artifact include those of its payloads.
+func getArtifactFields(
+	ar *areader.Reader,
+	sigInfo string,
+	scripts []string,
+) (interface{}, error) {
+	info := ar.GetInfo()
+	fields := artifactFields{
+		Name:              ar.GetArtifactName(),
+		Format:            info.Format,
+		Version:           info.Version,
+		ChecksumAlgorithm: info.ChecksumAlgorithm,
+		Signature:         sigInfo,
+		CompatibleDevices: ar.GetCompatibleDevices(),
+		ExcludedDevices:   ar.GetExcludedDevices(),
+		ClearsProvides:    ar.MergeArtifactClearsProvides(),
+		StateScripts:      scripts,
+		DeviceTypeScripts: ar.GetDeviceTypeScripts(),
+	}
+	var err error
+	if fields.Provides, err = ar.MergeArtifactProvides(); err != nil {
+		return nil, err
+	}
+	if fields.Depends, err = ar.MergeArtifactDepends(); err != nil {
+		return nil, err
+	}
+
+	installers := ar.GetHandlers()
+	for i := 0; i < len(installers); i++ {
+		p, ok := installers[i]
+		if !ok {
+			return nil, errors.Errorf("payload %04d is missing", i)
+		}
+		payload := payloadFields{
+			ClearsProvides: p.GetUpdateClearsProvides(),
+			InstallSize:    p.GetUpdateInstallSize(),
+			Files:          []string{},
+		}
+		if updateType := p.GetUpdateType(); updateType != nil {
+			payload.Type = *updateType
+		}
+		if payload.Provides, err = p.GetUpdateProvides(); err != nil {
+			return nil, errors.Wrap(err, "invalid provides section")
+		}
+		if payload.Depends, err = p.GetUpdateDepends(); err != nil {
+			return nil, errors.Wrap(err, "invalid depends section")
+		}
+		if payload.MetaData, err = p.GetUpdateMetaData(); err != nil {
+			return nil, errors.Wrap(err, "invalid metadata section")
+		}
+		for _, f := range p.GetUpdateAllFiles() {
+			payload.Files = append(payload.Files, f.Name)
+		}
+		fields.Payloads = append(fields.Payloads, payload)
+	}
+
+	// Go through JSON, so that lookupField only has to deal with the types
+	// of decoded JSON.
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// lookupField returns the value at the dot separated path in doc. List
+// elements are selected by their index. As keys such as the provides may
+// contain dots themselves, the longest key matching the path is tried first.
+func lookupField(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return doc, true
+	}
+	parts := strings.Split(path, ".")
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for i := len(parts); i > 0; i-- {
+			value, ok := doc[strings.Join(parts[:i], ".")]
+			if !ok {
+				continue
+			}
+			if value, ok = lookupField(value, strings.Join(parts[i:], ".")); ok {
+				return value, true
+			}
+		}
+	case []interface{}:
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 || i >= len(doc) {
+			return nil, false
+		}
+		return lookupField(doc[i], strings.Join(parts[1:], "."))
+	}
+	return nil, false
+}
+
+// printFields prints the values of the given fields of doc, one per line, or
+// all of them as one JSON object, keyed by field, if asJSON is set. Strings
+// are printed as they are on their own lines, any other value as JSON.
+func printFields(doc interface{}, fields []string, asJSON bool) error {
+	values := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		value, ok := lookupField(doc, field)
+		if !ok {
+			return errors.Errorf("field %q not found in the Artifact", field)
+		}
+		values[field] = value
+	}
+
+	if asJSON {
+		var out interface{} = values
+		if len(fields) == 0 {
+			out = doc
+		}
+		data, err := json.MarshalIndent(out, "", defaultIndentation)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, field := range fields {
+		if value, ok := values[field].(string); ok {
+			fmt.Println(value)
+			continue
+		}
+		data, err := json.Marshal(values[field])
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+	return nil
+}
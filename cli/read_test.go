@@ -15,10 +15,13 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -131,6 +134,7 @@ Updates:
       testDependKey1: testDependValue1
       testDependKey2: testDependValue2
     Clears Provides: [rootfs-image.testType.*]
+    Install size: 27
     Metadata:
       {
         "metadata": "augment"
@@ -172,6 +176,7 @@ Updates:
       rootfs-image.version: testName
     Depends: {}
     Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]
+    Install size: 13
     Metadata: {}
     Files:
         name: updateFile
@@ -290,3 +295,200 @@ func checkMenderArtifactRead(t *testing.T, tmpdir, artfile, expected string,
 		fmt.Sprintf("\n%s\n--- DOESN'T MATCH EXPECTED ---\n%s", cleaned, expected),
 	)
 }
+
+func TestReadTranslateLegacyProvides(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, os.WriteFile(rootfs, []byte("my update"), 0644))
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err = Run([]string{
+		"mender-artifact", "write", "rootfs-image",
+		"-o", artfile,
+		"-n", "testName",
+		"-t", "testDevice",
+		"-f", rootfs,
+		"--legacy-rootfs-image-checksum",
+	})
+	require.NoError(t, err)
+
+	const checksum = "bfb4567944c5730face9f3d54efc0c1ff3b5dd1338862b23b849ac87679e162f"
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+	require.NoError(t, err)
+	assert.Contains(t, data, "rootfs_image_checksum: "+checksum+"\n")
+
+	data, err = runAndCollectStdout([]string{"mender-artifact", "read",
+		"--translate-legacy-provides", artfile})
+	require.NoError(t, err)
+	assert.Contains(t, data, "rootfs-image.checksum: "+checksum+"\n")
+	assert.NotContains(t, data, "rootfs_image_checksum: ")
+}
+
+func TestReadMulti(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, os.WriteFile(rootfs, []byte("my update"), 0644))
+
+	var all []byte
+	for _, name := range []string{"first", "second"} {
+		artfile := filepath.Join(tmpdir, name+".mender")
+		err = Run([]string{
+			"mender-artifact", "write", "rootfs-image",
+			"-o", artfile,
+			"-n", name,
+			"-t", "testDevice",
+			"-f", rootfs,
+		})
+		require.NoError(t, err)
+		data, err := os.ReadFile(artfile)
+		require.NoError(t, err)
+		all = append(all, data...)
+	}
+	multi := filepath.Join(tmpdir, "multi.mender")
+	require.NoError(t, os.WriteFile(multi, all, 0644))
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", "--multi", multi})
+	require.NoError(t, err)
+	first := strings.Index(data, "Name: first\n")
+	second := strings.Index(data, "Name: second\n")
+	assert.True(t, first >= 0 && second > first, data)
+
+	empty := filepath.Join(tmpdir, "empty.mender")
+	require.NoError(t, os.WriteFile(empty, nil, 0644))
+	err = Run([]string{"mender-artifact", "read", "--multi", empty})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No Artifacts found")
+}
+
+func TestReadDisplayValue(t *testing.T) {
+	assert.Equal(t, "rootfs-image.version", displayValue("rootfs-image.version"))
+	assert.Equal(t, "mise à jour 日本語", displayValue("mise à jour 日本語"))
+	assert.Equal(t, `"1.0\nrootfs-image.checksum: fake"`,
+		displayValue("1.0\nrootfs-image.checksum: fake"))
+	assert.Equal(t, `"\x1b[2Jname"`, displayValue("\x1b[2Jname"))
+	assert.Equal(t, `"release\xff"`, displayValue("release\xff"))
+	assert.Equal(t, `"right-to-left\u202eoverride"`, displayValue("right-to-left\u202eoverride"))
+	assert.Equal(t, `"\"quoted\""`, displayValue(`"quoted"`))
+}
+
+func TestReadControlCharacters(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+	metaData := filepath.Join(tmpdir, "meta-data")
+	require.NoError(t, os.WriteFile(metaData,
+		[]byte(`{"note": "line\nbreak \u001b[31mred"}`), 0644))
+
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "testType", "-t", "testdevice", "-f", updateFile},
+			args...))
+	}
+
+	// Names, provides and depends with control characters are rejected.
+	err := write("-n", "name\nSignature: signed and verified correctly")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `contains the control character '\n'`)
+	err = write("-n", "name", "-p", "rootfs-image.version:1.0\x1b[2K")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `provides: "1.0\x1b[2K" contains the control character`)
+	err = write("-n", "name", "-d", "key:\x00")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "depends: ")
+
+	// Meta-data is free form, but printed as JSON, which escapes them.
+	require.NoError(t, write("-n", "name", "-m", metaData))
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+	require.NoError(t, err)
+	assert.Contains(t, out, `"note": "line\nbreak \u001b[31mred"`)
+	assert.NotContains(t, out, "\x1b")
+}
+
+func TestReadPlan(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	cached := filepath.Join(tmpdir, "cached")
+	require.NoError(t, os.WriteFile(cached, []byte("cached content"), 0644))
+	missing := filepath.Join(tmpdir, "missing")
+	require.NoError(t, os.WriteFile(missing, []byte("missing"), 0644))
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testdevice", "-n", "name",
+		"-f", cached, "-f", missing}))
+
+	sum := sha256.Sum256([]byte("cached content"))
+	cacheFile := filepath.Join(tmpdir, "cache.json")
+	require.NoError(t, os.WriteFile(cacheFile,
+		[]byte(`["`+hex.EncodeToString(sum[:])+`"]`), 0644))
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--plan", cacheFile, artfile})
+	require.NoError(t, err)
+	assert.Contains(t, out, `Download plan:
+  - data/0000.tar.gz: transfer
+      cached: cached (14 bytes)
+      missing: missing (7 bytes)
+  Transfer size: 7
+  Cached size: 14`)
+
+	require.NoError(t, os.WriteFile(cacheFile, []byte(`{}`), 0644))
+	err = Run([]string{"mender-artifact", "read", "--plan", cacheFile, artfile})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a JSON list of checksums")
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+}
+
+func TestReadFields(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-T", "testType", "-t", "testDevice", "-n", "testName",
+		"-p", "rootfs-image.version:v1", "-f", update}))
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", "--field", "provides.rootfs-image.version",
+		"--field", "name", "--field", "compatible_devices",
+		"--field", "payloads.0.files", artfile})
+	require.NoError(t, err)
+	assert.Equal(t, "v1\ntestName\n[\"testDevice\"]\n[\"update\"]", out)
+
+	out, err = runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", "--json", "--field", "payloads.0.type", artfile})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"payloads.0.type": "testType"}`, out)
+
+	for _, field := range []string{"nothing", "provides.nothing", "payloads.1.type"} {
+		err = Run([]string{"mender-artifact", "read", "--no-progress",
+			"--field", field, artfile})
+		require.Error(t, err, field)
+		assert.Contains(t, err.Error(), "not found in the Artifact")
+	}
+}
+
+func TestLookupField(t *testing.T) {
+	doc := map[string]interface{}{
+		"a":   map[string]interface{}{"b.c": "dotted", "b": map[string]interface{}{"d": "nested"}},
+		"lst": []interface{}{"zero", "one"},
+	}
+	for path, expected := range map[string]interface{}{
+		"a.b.c": "dotted",
+		"a.b.d": "nested",
+		"lst.1": "one",
+	} {
+		value, ok := lookupField(doc, path)
+		assert.True(t, ok, path)
+		assert.Equal(t, expected, value, path)
+	}
+	for _, path := range []string{"a.b.e", "lst.2", "lst.x", "a.b.c.d"} {
+		_, ok := lookupField(doc, path)
+		assert.False(t, ok, path)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// addScriptDir adds all the scripts below dir, in any depth of
+// subdirectories.
+func addScriptDir(scr *artifact.Scripts, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "can not collect scripts from %s", dir)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return scr.Add(path)
+	})
+}
+
+// addDeviceTypeScript adds the script given as DEVICE-TYPE:PATH with
+// --script-for-device-type.
+func addDeviceTypeScript(scr *artifact.Scripts, arg string) error {
+	split := strings.SplitN(arg, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return errors.Errorf(
+			"invalid --script-for-device-type %q, expected DEVICE-TYPE:PATH", arg)
+	}
+	deviceType, path := split[0], split[1]
+	if _, err := os.Stat(path); err != nil {
+		return errors.Wrapf(err, "can not stat script file: %s", path)
+	}
+	return scr.AddForDeviceType(deviceType, path)
+}
+
+func reportScripts(w io.Writer, scr *artifact.Scripts) {
+	ordered := scr.Ordered()
+	if len(ordered) == 0 {
+		fmt.Fprintln(w, "State scripts: none")
+	} else {
+		fmt.Fprintln(w, "State scripts, in the order they run:")
+		for _, path := range ordered {
+			fmt.Fprintf(w, "  %s (%s)\n", filepath.Base(path), path)
+		}
+	}
+	for _, deviceType := range scr.DeviceTypes() {
+		fmt.Fprintf(w, "State scripts for device type %s, in the order they run:\n", deviceType)
+		for _, path := range scr.ForDeviceType(deviceType).Ordered() {
+			fmt.Fprintf(w, "  %s (%s)\n", filepath.Base(path), path)
+		}
+	}
+}
+
+// collectScripts collects the state scripts given with --script,
+// --script-dir and --script-for-device-type. As scripts from several directories may end up with the
+// same ordering, these are checked, and the final order is reported: on
+// stdout with --dry-run, and on stderr otherwise.
+func collectScripts(c *cli.Context) (*artifact.Scripts, error) {
+	scr, err := scripts(c.StringSlice("script"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range c.StringSlice("script-dir") {
+		if err = addScriptDir(scr, dir); err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range c.StringSlice("script-for-device-type") {
+		if err = addDeviceTypeScript(scr, arg); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.StringSlice("script-dir")) > 0 {
+		if err = scr.CheckOrdering(); err != nil {
+			return nil, err
+		}
+	}
+	if c.Bool("dry-run") {
+		reportScripts(os.Stdout, scr)
+	} else if len(c.StringSlice("script-dir")) > 0 {
+		reportScripts(os.Stderr, scr)
+	}
+	return scr, nil
+}
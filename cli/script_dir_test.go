@@ -0,0 +1,76 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteScriptDir(t *testing.T) {
+	tmpdir := t.TempDir()
+	writeScript := func(path string) string {
+		path = filepath.Join(tmpdir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+		return path
+	}
+	writeScript("base/ArtifactInstall_Enter_10_base")
+	writeScript("product/commit/ArtifactCommit_Leave_01_product")
+	writeScript("product/install/deep/ArtifactInstall_Enter_05_product")
+	extra := writeScript("ArtifactReboot_Enter_00_extra")
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	art := filepath.Join(tmpdir, "artifact.mender")
+
+	args := []string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", art,
+		"-s", extra,
+		"--script-dir", filepath.Join(tmpdir, "base"),
+		"--script-dir", filepath.Join(tmpdir, "product")}
+	data, err := runAndCollectStdout(append(args, "--dry-run"))
+	require.NoError(t, err)
+	assert.Equal(t, "State scripts, in the order they run:\n"+
+		"  ArtifactInstall_Enter_05_product ("+
+		filepath.Join(tmpdir, "product/install/deep/ArtifactInstall_Enter_05_product")+")\n"+
+		"  ArtifactInstall_Enter_10_base ("+
+		filepath.Join(tmpdir, "base/ArtifactInstall_Enter_10_base")+")\n"+
+		"  ArtifactReboot_Enter_00_extra ("+extra+")\n"+
+		"  ArtifactCommit_Leave_01_product ("+
+		filepath.Join(tmpdir, "product/commit/ArtifactCommit_Leave_01_product")+")",
+		data)
+	_, err = os.Stat(art)
+	assert.True(t, os.IsNotExist(err), "dry run wrote the Artifact")
+
+	require.NoError(t, Run(append(args, "--no-progress")))
+	data, err = runAndCollectStdout([]string{"mender-artifact", "read", art})
+	require.NoError(t, err)
+	assert.Contains(t, data, "    - ArtifactInstall_Enter_05_product\n")
+	assert.Contains(t, data, "    - ArtifactInstall_Enter_10_base\n")
+	assert.Contains(t, data, "    - ArtifactReboot_Enter_00_extra\n")
+	assert.Contains(t, data, "    - ArtifactCommit_Leave_01_product\n")
+
+	// The same ordering in two layers is ambiguous.
+	writeScript("product/ArtifactInstall_Enter_10_product")
+	err = Run(append(args, "--dry-run"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "have the same state and ordering number"+
+		" ArtifactInstall_Enter_10")
+}
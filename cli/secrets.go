@@ -0,0 +1,112 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+const fdPathPrefix = "/dev/fd/"
+
+// readSecretFile reads the secret, such as a private key, in the file at
+// path. Paths like /dev/fd/3 are read straight from the inherited file
+// descriptor, so that secrets need not be written to disk or given on the
+// command line, even in containers where /dev/fd is not mounted.
+func readSecretFile(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, fdPathPrefix) {
+		return ioutil.ReadFile(path)
+	}
+	fd, err := strconv.Atoi(strings.TrimPrefix(path, fdPathPrefix))
+	if err != nil || fd < 0 {
+		return nil, errors.Errorf("invalid file descriptor path %s", path)
+	}
+	return readSecretFd(fd)
+}
+
+// readSecretFd reads the secret in the file descriptor fd, until EOF, and
+// closes it.
+func readSecretFd(fd int) ([]byte, error) {
+	f := os.NewFile(uintptr(fd), fdPathPrefix+strconv.Itoa(fd))
+	if f == nil {
+		return nil, errors.Errorf("invalid file descriptor %d", fd)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not read file descriptor %d", fd)
+	}
+	return data, nil
+}
+
+// tenantToken returns the tenant token given with --tenant-token, or read
+// from the file descriptor given with --tenant-token-fd.
+func tenantToken(c *cli.Context) (string, error) {
+	token := c.String("tenant-token")
+	if c.IsSet("tenant-token-fd") {
+		if token != "" {
+			return "", errors.New("--tenant-token and --tenant-token-fd are mutually exclusive")
+		}
+		data, err := readSecretFd(c.Int("tenant-token-fd"))
+		if err != nil {
+			return "", errors.Wrap(err, "tenant token")
+		}
+		token = strings.TrimRight(string(data), "\r\n")
+		if token == "" {
+			return "", errors.New("the tenant token read from --tenant-token-fd is empty")
+		}
+	}
+	registerSecret(token)
+	return token, nil
+}
+
+var (
+	secretsLock sync.Mutex
+	secrets     []string
+
+	privateKeyPEM = regexp.MustCompile(
+		`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+)
+
+const redacted = "[REDACTED]"
+
+// registerSecret makes scrubSecrets redact secret from the log.
+func registerSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// scrubSecrets redacts the registered secrets, and PEM encoded private keys,
+// from msg.
+func scrubSecrets(msg string) string {
+	msg = privateKeyPEM.ReplaceAllString(msg, redacted)
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, secret := range secrets {
+		msg = strings.ReplaceAll(msg, secret, redacted)
+	}
+	return msg
+}
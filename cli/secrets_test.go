@@ -0,0 +1,121 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli"
+)
+
+// secretFd returns a file descriptor to read secret from, like one inherited
+// from a parent process.
+func secretFd(t *testing.T, secret string) int {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = w.WriteString(secret)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	fd, err := syscall.Dup(int(r.Fd()))
+	require.NoError(t, err)
+	return fd
+}
+
+func TestReadSecretFile(t *testing.T) {
+	data, err := readSecretFile(fmt.Sprintf("/dev/fd/%d", secretFd(t, "secret")))
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+
+	_, err = readSecretFile("/dev/fd/x")
+	assert.EqualError(t, err, "invalid file descriptor path /dev/fd/x")
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("from file"), 0600))
+	data, err = readSecretFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "from file", string(data))
+}
+
+func TestKeyFromFd(t *testing.T) {
+	tmpdir := t.TempDir()
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, os.WriteFile(rootfs, []byte("my update"), 0644))
+	pubKey := filepath.Join(tmpdir, "ecdsa.pub")
+	require.NoError(t, os.WriteFile(pubKey, []byte(PublicECDSAKey), 0644))
+	art := filepath.Join(tmpdir, "artifact.mender")
+
+	err := Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", rootfs, "-o", art,
+		"-k", fmt.Sprintf("/dev/fd/%d", secretFd(t, PrivateECDSAKey))})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "validate", art, "-k", pubKey})
+	assert.NoError(t, err)
+}
+
+func TestTenantToken(t *testing.T) {
+	context := func(args ...string) *cli.Context {
+		set := flag.NewFlagSet("modify", flag.ContinueOnError)
+		set.String("tenant-token", "", "")
+		set.Int("tenant-token-fd", 0, "")
+		require.NoError(t, set.Parse(args))
+		return cli.NewContext(nil, set, nil)
+	}
+
+	token, err := tenantToken(context())
+	require.NoError(t, err)
+	assert.Equal(t, "", token)
+
+	token, err = tenantToken(context("--tenant-token", "from-argv"))
+	require.NoError(t, err)
+	assert.Equal(t, "from-argv", token)
+
+	fd := fmt.Sprint(secretFd(t, "from-fd\n"))
+	token, err = tenantToken(context("--tenant-token-fd", fd))
+	require.NoError(t, err)
+	assert.Equal(t, "from-fd", token)
+	assert.Equal(t, "token: [REDACTED]", scrubSecrets("token: from-fd"))
+
+	fd = fmt.Sprint(secretFd(t, "\n"))
+	_, err = tenantToken(context("--tenant-token-fd", fd))
+	assert.EqualError(t, err, "the tenant token read from --tenant-token-fd is empty")
+
+	_, err = tenantToken(context("--tenant-token", "a", "--tenant-token-fd", "0"))
+	assert.EqualError(t, err, "--tenant-token and --tenant-token-fd are mutually exclusive")
+
+}
+
+func TestScrubSecrets(t *testing.T) {
+	registerSecret("hunter2")
+
+	var out bytes.Buffer
+	Log.Out = &out
+	defer func() {
+		Log.Out = os.Stdout
+	}()
+	Log.Errorf("password hunter2, key %s", PrivateECDSAKey)
+	assert.Equal(t, "error: password [REDACTED], key [REDACTED]\n", out.String())
+
+	assert.Equal(t, PublicECDSAKey, scrubSecrets(PublicECDSAKey))
+}
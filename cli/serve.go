@@ -0,0 +1,416 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// The endpoints of `serve`. Each one takes an Artifact as the body of a POST
+// request.
+const (
+	serveValidatePath  = "/api/v1/validate"
+	serveMetadataPath  = "/api/v1/metadata"
+	serveSignPath      = "/api/v1/sign"
+	serveRepackagePath = "/api/v1/repackage"
+)
+
+// Limits of the server, against clients which hold connections open.
+const (
+	serveReadHeaderTimeout = 10 * time.Second
+	serveIdleTimeout       = 2 * time.Minute
+)
+
+// serveOptions configures the artifactServer.
+type serveOptions struct {
+	// maxSize is the largest Artifact accepted; 0 means no limit.
+	maxSize int64
+	// token is the bearer token clients must send, if not empty.
+	token string
+	// clientCerts is true if clients are authenticated by their TLS
+	// certificates.
+	clientCerts bool
+}
+
+// artifactServer serves the Artifact operations over HTTP. The signing key
+// configured on the command line is used to sign, and to verify signatures.
+type artifactServer struct {
+	key SigningKey
+	serveOptions
+
+	// Operations run one at a time: repackaging uses global state such as
+	// the progress reporter, and not all key providers can be used
+	// concurrently.
+	mutex sync.Mutex
+}
+
+// serveError is an error with the HTTP status to reply with.
+type serveError struct {
+	status int
+	err    error
+}
+
+func (e *serveError) Error() string {
+	return e.err.Error()
+}
+
+func newArtifactServer(key SigningKey, opts serveOptions) http.Handler {
+	s := &artifactServer{key: key, serveOptions: opts}
+	mux := http.NewServeMux()
+	mux.HandleFunc(serveValidatePath, s.handle(s.validate, false))
+	mux.HandleFunc(serveMetadataPath, s.handle(s.metadata, false))
+	mux.HandleFunc(serveSignPath, s.handle(s.sign, true))
+	mux.HandleFunc(serveRepackagePath, s.handle(s.repackage, true))
+	return mux
+}
+
+// authenticated tells whether clients are authenticated at all, which the
+// endpoints producing Artifacts require.
+func (s *artifactServer) authenticated() bool {
+	return s.token != "" || s.clientCerts
+}
+
+func (s *artifactServer) validToken(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// receive stores the uploaded Artifact in a temporary file, which the caller
+// must remove.
+func (s *artifactServer) receive(w http.ResponseWriter, r *http.Request) (*os.File, error) {
+	var body io.Reader = r.Body
+	if s.maxSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.maxSize)
+	}
+	upload, err := utils.TempFile("", "serve-upload")
+	if err != nil {
+		return nil, &serveError{http.StatusInternalServerError, err}
+	}
+	n, err := io.Copy(upload, body)
+	if err == nil {
+		_, err = upload.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		upload.Close()
+		utils.RemoveTemp(upload.Name())
+		if s.maxSize > 0 && n >= s.maxSize {
+			return nil, &serveError{http.StatusRequestEntityTooLarge, errors.Errorf(
+				"the Artifact is larger than the limit of %d bytes", s.maxSize)}
+		}
+		return nil, &serveError{http.StatusBadRequest,
+			errors.Wrap(err, "can not receive the Artifact")}
+	}
+	return upload, nil
+}
+
+// handle runs op on the uploaded Artifact and replies with its error, if
+// any, as a JSON document. Errors without a status are caused by the
+// Artifact. The upload is received before waiting for other operations, so
+// that a slow client does not hold them up. Privileged operations need the
+// clients to be authenticated.
+func (s *artifactServer) handle(
+	op func(w http.ResponseWriter, r *http.Request, upload *os.File) error,
+	privileged bool,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeServeJSON(w, http.StatusMethodNotAllowed,
+				map[string]string{"error": "the Artifact must be POSTed"})
+			return
+		}
+		err := s.serve(w, r, op, privileged)
+		if err == nil {
+			return
+		}
+		status := http.StatusUnprocessableEntity
+		if e, ok := err.(*serveError); ok {
+			status = e.status
+		}
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeServeJSON(w, status, map[string]string{"error": err.Error()})
+	}
+}
+
+func (s *artifactServer) serve(
+	w http.ResponseWriter,
+	r *http.Request,
+	op func(w http.ResponseWriter, r *http.Request, upload *os.File) error,
+	privileged bool,
+) error {
+	if s.token != "" && !s.validToken(r) {
+		return &serveError{http.StatusUnauthorized, errors.New("invalid or missing token")}
+	}
+	if privileged && !s.authenticated() {
+		return &serveError{http.StatusForbidden, errors.Errorf(
+			"%s is disabled; it needs the server to be run with --token-file or"+
+				" --tls-client-ca", r.URL.Path)}
+	}
+	upload, err := s.receive(w, r)
+	if err != nil {
+		return err
+	}
+	defer utils.RemoveTemp(upload.Name())
+	defer upload.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return op(w, r, upload)
+}
+
+func writeServeJSON(w http.ResponseWriter, status int, doc interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(doc)
+}
+
+func (s *artifactServer) verifier() artifact.Verifier {
+	if s.key == nil {
+		return nil
+	}
+	return s.key
+}
+
+func (s *artifactServer) validate(w http.ResponseWriter, r *http.Request, art *os.File) error {
+	ar, err := validateReader(art, s.verifier(), 0, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	writeServeJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":  true,
+		"signed": ar.IsSigned,
+	})
+	return nil
+}
+
+func (s *artifactServer) metadata(w http.ResponseWriter, r *http.Request, art *os.File) error {
+	sigInfo := signatureNone
+	var scripts []string
+	ar := areader.NewReader(art)
+	ar.VerifySignatureCallback = describeSignature(s.key, &sigInfo)
+	ar.ScriptsReadCallback = func(r io.Reader, info os.FileInfo) error {
+		scripts = append(scripts, info.Name())
+		return nil
+	}
+	if err := ar.ReadArtifact(); err != nil {
+		return err
+	}
+	doc, err := getArtifactFields(ar, sigInfo, scripts)
+	if err != nil {
+		return err
+	}
+	writeServeJSON(w, http.StatusOK, doc)
+	return nil
+}
+
+func (s *artifactServer) sign(w http.ResponseWriter, r *http.Request, art *os.File) error {
+	if s.key == nil {
+		return &serveError{http.StatusNotImplemented,
+			errors.New("the server has no signing key configured")}
+	}
+	return serveArtifactFile(w, func(out io.Writer) error {
+		err := awriter.SignExisting(art, out, s.key, r.URL.Query().Get("force") == "true")
+		if err == awriter.ErrAlreadyExistingSignature {
+			return &serveError{http.StatusConflict, errors.New(
+				"Artifact already signed, refusing to re-sign. Use force=true to override")}
+		}
+		return err
+	})
+}
+
+// repackage writes the Artifact anew, with the compression given by the
+// "compression" parameter, or the one it had. It is signed if the server has
+// a signing key.
+func (s *artifactServer) repackage(w http.ResponseWriter, r *http.Request, upload *os.File) error {
+	var comp artifact.Compressor
+	if id := r.URL.Query().Get("compression"); id != "" {
+		var err error
+		if comp, err = artifact.NewCompressorFromId(id); err != nil {
+			return &serveError{http.StatusBadRequest,
+				errors.Errorf("compressor '%s' is not supported: %s", id, err.Error())}
+		}
+	}
+
+	ua, err := unpackArtifact(upload.Name())
+	if ua != nil {
+		defer utils.RemoveTemp(ua.unpackDir)
+	}
+	if err != nil {
+		return err
+	}
+	if comp == nil {
+		comp = ua.ar.Compressor()
+	}
+	return serveArtifactFile(w, func(out io.Writer) error {
+		return repack(comp, ua, out, s.key)
+	})
+}
+
+// serveArtifactFile replies with the Artifact written by write. It is
+// written to a temporary file first, so that errors can still be reported
+// with their status.
+func serveArtifactFile(w http.ResponseWriter, write func(out io.Writer) error) error {
+	tmp, err := utils.TempFile("", "serve")
+	if err != nil {
+		return &serveError{http.StatusInternalServerError, err}
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return &serveError{http.StatusInternalServerError, err}
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return &serveError{http.StatusInternalServerError, err}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, tmp)
+	if err != nil {
+		Log.Warnf("Could not send the Artifact: %s", err)
+	}
+	return nil
+}
+
+// serveTLSConfig returns the TLS configuration of the server, which requires
+// client certificates signed by the CA in clientCA, if given.
+func serveTLSConfig(clientCA string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, errors.Wrap(err, "can not read the client CA")
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificates found in %s", clientCA)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+func readServeToken(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrap(err, "can not read the token")
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.Errorf("the token file %s is empty", name)
+	}
+	return token, nil
+}
+
+func serveArtifacts(c *cli.Context) error {
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	if c.Int64("max-upload-size") < 0 {
+		return cli.NewExitError("--max-upload-size can not be negative",
+			errArtifactInvalidParameters)
+	}
+	certFile, keyFile := c.String("tls-cert"), c.String("tls-key")
+	if (certFile == "") != (keyFile == "") {
+		return cli.NewExitError("--tls-cert and --tls-key must be given together",
+			errArtifactInvalidParameters)
+	}
+	if c.String("tls-client-ca") != "" && certFile == "" {
+		return cli.NewExitError("--tls-client-ca needs --tls-cert and --tls-key",
+			errArtifactInvalidParameters)
+	}
+	tlsConfig, err := serveTLSConfig(c.String("tls-client-ca"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	token, err := readServeToken(c.String("token-file"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	// The progress of the operations is of no use to the clients.
+	imageProgress = nil
+
+	opts := serveOptions{
+		maxSize:     c.Int64("max-upload-size"),
+		token:       token,
+		clientCerts: tlsConfig.ClientCAs != nil,
+	}
+	server := &http.Server{
+		Addr:              c.String("listen"),
+		Handler:           newArtifactServer(key, opts),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: serveReadHeaderTimeout,
+		ReadTimeout:       c.Duration("request-timeout"),
+		WriteTimeout:      c.Duration("request-timeout"),
+		IdleTimeout:       serveIdleTimeout,
+	}
+	if !opts.clientCerts && token == "" {
+		Log.Warnf("No client authentication configured; %s and %s are disabled",
+			serveSignPath, serveRepackagePath)
+	}
+	// Shut down gracefully, which removes the temporary files as well.
+	utils.StopCleanupTempOnSignal()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = server.Shutdown(context.Background())
+	}()
+
+	Log.Infof("Serving Artifact operations on %s", server.Addr)
+	if certFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	return nil
+}
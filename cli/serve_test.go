@@ -0,0 +1,226 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+const testServeToken = "serve-token"
+
+func newTestArtifactServer(
+	t *testing.T,
+	withKey bool,
+	opts serveOptions,
+) (*httptest.Server, []byte) {
+	dir := t.TempDir()
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art, err := os.ReadFile(filepath.Join(dir, "artifact.mender"))
+	require.NoError(t, err)
+
+	var key SigningKey
+	if withKey {
+		priv, _, err := generateKeys()
+		require.NoError(t, err)
+		key, err = artifact.NewPKISigner(priv)
+		require.NoError(t, err)
+	}
+	server := httptest.NewServer(newArtifactServer(key, opts))
+	t.Cleanup(server.Close)
+	return server, art
+}
+
+func postArtifact(t *testing.T, url string, art []byte) (int, []byte) {
+	return postArtifactWithToken(t, url, testServeToken, art)
+}
+
+func postArtifactWithToken(t *testing.T, url, token string, art []byte) (int, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(art))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+func TestServeValidateAndMetadata(t *testing.T) {
+	server, art := newTestArtifactServer(t, false, serveOptions{})
+
+	status, body := postArtifact(t, server.URL+serveValidatePath, art)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"valid": true, "signed": false}`, string(body))
+
+	status, body = postArtifact(t, server.URL+serveValidatePath, art[:len(art)/2])
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Contains(t, string(body), `"error"`)
+
+	status, body = postArtifact(t, server.URL+serveMetadataPath, art)
+	require.Equal(t, http.StatusOK, status)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &doc))
+	assert.Equal(t, "test-artifact", doc["name"])
+	assert.Equal(t, "no signature", doc["signature"])
+
+	resp, err := http.Get(server.URL + serveMetadataPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestServeSign(t *testing.T) {
+	server, art := newTestArtifactServer(t, false, serveOptions{token: testServeToken})
+	status, body := postArtifact(t, server.URL+serveSignPath, art)
+	assert.Equal(t, http.StatusNotImplemented, status)
+	assert.Contains(t, string(body), "no signing key configured")
+
+	server, art = newTestArtifactServer(t, true, serveOptions{token: testServeToken})
+	status, signed := postArtifact(t, server.URL+serveSignPath, art)
+	require.Equal(t, http.StatusOK, status)
+
+	status, body = postArtifact(t, server.URL+serveValidatePath, signed)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"valid": true, "signed": true}`, string(body))
+
+	status, body = postArtifact(t, server.URL+serveSignPath, signed)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Contains(t, string(body), "already signed")
+
+	status, _ = postArtifact(t, server.URL+serveSignPath+"?force=true", signed)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestServeRepackage(t *testing.T) {
+	server, art := newTestArtifactServer(t, true, serveOptions{token: testServeToken})
+
+	status, body := postArtifact(t, server.URL+serveRepackagePath+"?compression=none", art)
+	require.Equal(t, http.StatusOK, status, string(body))
+	ar := areader.NewReader(bytes.NewReader(body))
+	require.NoError(t, ar.ReadArtifact())
+	assert.Equal(t, "test-artifact", ar.GetArtifactName())
+	assert.True(t, ar.IsSigned)
+	assert.IsType(t, &artifact.CompressorNone{}, ar.Compressor())
+
+	status, body = postArtifact(t, server.URL+serveRepackagePath+"?compression=bogus", art)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.True(t, strings.Contains(string(body), "not supported"), string(body))
+}
+
+func TestServeAuthentication(t *testing.T) {
+	// Without client authentication, only reading Artifacts is enabled.
+	server, art := newTestArtifactServer(t, false, serveOptions{})
+	status, _ := postArtifactWithToken(t, server.URL+serveValidatePath, "", art)
+	assert.Equal(t, http.StatusOK, status)
+	for _, path := range []string{serveSignPath, serveRepackagePath} {
+		status, body := postArtifactWithToken(t, server.URL+path, "", art)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, string(body), "--token-file")
+	}
+
+	// With a token, every endpoint needs it.
+	server, art = newTestArtifactServer(t, true, serveOptions{token: testServeToken})
+	for _, token := range []string{"", "wrong"} {
+		status, body := postArtifactWithToken(t, server.URL+serveValidatePath, token, art)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Contains(t, string(body), "invalid or missing token")
+	}
+	status, _ = postArtifact(t, server.URL+serveSignPath, art)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestServeMaxUploadSize(t *testing.T) {
+	server, art := newTestArtifactServer(t, false, serveOptions{maxSize: 1024})
+	status, body := postArtifact(t, server.URL+serveValidatePath, art)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+	assert.Contains(t, string(body), "larger than the limit of 1024 bytes")
+}
+
+func TestServeTLSConfig(t *testing.T) {
+	config, err := serveTLSConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+
+	config, err = serveTLSConfig(ca)
+	require.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+	assert.NotNil(t, config.ClientCAs)
+}
+
+func TestServeInvalidListenAddress(t *testing.T) {
+	err := Run([]string{"mender-artifact", "serve", "--listen", "not-an-address"})
+	require.Error(t, err)
+	assert.Equal(t, errSystemError, lastExitCode)
+
+	err = Run([]string{"mender-artifact", "serve", "--max-upload-size", "-1"})
+	require.Error(t, err)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(emptyFile, nil, 0600))
+	for _, args := range [][]string{
+		{"--tls-cert", "cert.pem"},
+		{"--tls-client-ca", emptyFile},
+		{"--tls-cert", "cert.pem", "--tls-key", "key.pem", "--tls-client-ca", emptyFile},
+		{"--token-file", emptyFile},
+	} {
+		err = Run(append([]string{"mender-artifact", "serve"}, args...))
+		require.Error(t, err, args)
+		assert.Equal(t, errArtifactInvalidParameters, lastExitCode, args)
+	}
+}
@@ -15,7 +15,8 @@
 package cli
 
 import (
-	"io/ioutil"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -23,6 +24,7 @@ import (
 	"github.com/urfave/cli"
 
 	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 func signExisting(c *cli.Context) error {
@@ -41,16 +43,106 @@ func signExisting(c *cli.Context) error {
 	}
 
 	artFile := c.Args().First()
-	outputFile := artFile
-	if len(c.String("output-path")) > 0 {
-		outputFile = c.String("output-path")
+	outputFile := c.String("output-path")
+	if outputFile == "" || isSameFile(artFile, outputFile) {
+		return signInPlace(c, artFile, privateKey)
 	}
-	tFile, err := ioutil.TempFile(filepath.Dir(outputFile), "mender-artifact")
+	return signToOutput(c, artFile, outputFile, privateKey)
+}
+
+// isSameFile reports whether both paths exist and refer to the same file.
+func isSameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	return err == nil && os.SameFile(aInfo, bInfo)
+}
+
+func signArtifact(c *cli.Context, src io.Reader, dst io.Writer, key SigningKey) error {
+	var err error
+	if c.Bool("add-signature") {
+		if c.Bool("force") {
+			return cli.NewExitError(
+				"--add-signature and --force are mutually exclusive",
+				errArtifactInvalidParameters,
+			)
+		}
+		err = awriter.AddSignature(src, dst, key)
+	} else {
+		err = awriter.SignExisting(src, dst, key, c.Bool("force"))
+	}
+	if err == awriter.ErrAlreadyExistingSignature {
+		return cli.NewExitError(
+			"Artifact already signed, refusing to re-sign. Use force option to override",
+			1,
+		)
+	} else if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	return nil
+}
+
+// signProgress returns f, wrapped to show the progress of signing it, unless
+// the command should not report its progress.
+func signProgress(c *cli.Context, f *os.File) io.Reader {
+	if !showProgress(c) {
+		return f
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return f
+	}
+	fmt.Fprintln(os.Stderr, "Signing Artifact...")
+	return utils.NewProgressReader().Wrap(f, info.Size())
+}
+
+// signToOutput writes the signed Artifact to outputFile, or streams it to
+// stdout for '-'. The source Artifact is only read, so it may be on
+// read-only media.
+func signToOutput(c *cli.Context, artFile, outputFile string, key SigningKey) error {
+	f, err := os.Open(artFile)
+	if err != nil {
+		err = errors.Wrapf(err, "Can not open: %s", artFile)
+		return cli.NewExitError(err, 1)
+	}
+	defer f.Close()
+
+	src := signProgress(c, f)
+	if outputFile == "-" {
+		return signArtifact(c, src, os.Stdout, key)
+	}
+
+	err = writeOutputFile(outputFile, artFile, func(w io.Writer) error {
+		return signArtifact(c, src, w, key)
+	})
+	if _, ok := err.(*cli.ExitError); ok {
+		return err
+	} else if err != nil {
+		return cli.NewExitError("Can not store signed artifact: "+err.Error(), 1)
+	}
+	return nil
+}
+
+// signInPlace replaces artFile with its signed version, keeping its owner
+// and permissions.
+func signInPlace(c *cli.Context, artFile string, key SigningKey) error {
+	unlock, err := lockImage(c, artFile)
+	if err != nil {
+		return cli.NewExitError(err, 1)
+	}
+	defer unlock()
+	if err = backupImage(c, artFile); err != nil {
+		return cli.NewExitError(err, 1)
+	}
+
+	tFile, err := utils.TempFile(filepath.Dir(artFile), "sign")
 	if err != nil {
 		err = errors.Wrap(err, "Can not create temporary file for storing artifact")
 		return cli.NewExitError(err, 1)
 	}
-	defer os.Remove(tFile.Name())
+	defer utils.RemoveTemp(tFile.Name())
 	defer tFile.Close()
 
 	f, err := os.Open(artFile)
@@ -73,21 +165,15 @@ func signExisting(c *cli.Context) error {
 	if err != nil {
 		return cli.NewExitError("Could not give signed artifact same permissions", 1)
 	}
-	err = awriter.SignExisting(f, tFile, privateKey, c.Bool("force"))
-	if err == awriter.ErrAlreadyExistingSignature {
-		return cli.NewExitError(
-			"Artifact already signed, refusing to re-sign. Use force option to override",
-			1,
-		)
-	} else if err != nil {
-		return cli.NewExitError(err, 1)
+	if err = signArtifact(c, signProgress(c, f), tFile, key); err != nil {
+		return err
 	}
 
 	if err = tFile.Close(); err != nil {
 		return err
 	}
 
-	err = os.Rename(tFile.Name(), outputFile)
+	err = os.Rename(tFile.Name(), artFile)
 	if err != nil {
 		return cli.NewExitError("Can not store signed artifact: "+err.Error(), 1)
 	}
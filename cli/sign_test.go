@@ -15,14 +15,20 @@
 package cli
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSignExistingV2(t *testing.T) {
@@ -357,3 +363,231 @@ func TestSignExistingPermissions(t *testing.T) {
 
 	assert.Equal(t, preSignStat.Mode(), postSignStat.Mode())
 }
+
+func TestSignToOutputReadOnlySource(t *testing.T) {
+	srcDir := t.TempDir()
+	outDir := t.TempDir()
+	require.NoError(t, WriteArtifact(srcDir, 3, ""))
+	src := filepath.Join(srcDir, "artifact.mender")
+	keyFile := filepath.Join(outDir, "private.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(PrivateECDSAKey), 0600))
+	pubFile := filepath.Join(outDir, "public.key")
+	require.NoError(t, os.WriteFile(pubFile, []byte(PublicECDSAKey), 0600))
+
+	require.NoError(t, os.Chmod(src, 0444))
+	require.NoError(t, os.Chmod(srcDir, 0555))
+	defer os.Chmod(srcDir, 0755)
+
+	signed := filepath.Join(outDir, "signed.mender")
+	err := Run([]string{"mender-artifact", "sign", "-k", keyFile, "-o", signed, src})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "validate", "-k", pubFile, signed})
+	assert.NoError(t, err)
+
+	// The signed Artifact keeps the permissions of the source, and no
+	// temporary files are left behind.
+	info, err := os.Stat(signed)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0444), info.Mode().Perm())
+	entries, err := os.ReadDir(outDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"private.key", "public.key", "signed.mender"}, names)
+
+	// A failed signing does not leave a partial output behind, nor does it
+	// truncate an existing one.
+	again := filepath.Join(outDir, "again.mender")
+	err = Run([]string{"mender-artifact", "sign", "-k", keyFile, "-o", again, signed})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Artifact already signed")
+	assert.NoFileExists(t, again)
+	require.NoError(t, os.WriteFile(again, []byte("previous"), 0644))
+	err = Run([]string{"mender-artifact", "sign", "-k", keyFile, "-o", again, signed})
+	require.Error(t, err)
+	data, err := os.ReadFile(again)
+	require.NoError(t, err)
+	assert.Equal(t, "previous", string(data))
+	require.NoError(t, os.Remove(again))
+
+	// Signing to stdout.
+	fromStdout := filepath.Join(outDir, "stdout.mender")
+	stdout, err := os.Create(fromStdout)
+	require.NoError(t, err)
+	savedStdout := os.Stdout
+	os.Stdout = stdout
+	err = Run([]string{"mender-artifact", "sign", "-k", keyFile, "-o", "-", src})
+	os.Stdout = savedStdout
+	require.NoError(t, err)
+	require.NoError(t, stdout.Close())
+	err = Run([]string{"mender-artifact", "validate", "-k", pubFile, fromStdout})
+	assert.NoError(t, err)
+}
+
+// fakeCosign stands in for cosign, "signing" with the checksum of the
+// message.
+const fakeCosign = `#!/bin/sh
+cmd=$1
+shift
+while [ $# -gt 1 ]; do
+	case $1 in
+	--bundle) bundle=$2; shift 2;;
+	*) shift;;
+	esac
+done
+sig="sigstore bundle $(sha256sum "$1" | cut -d' ' -f1)"
+case $cmd in
+sign-blob) echo "$sig" > "$bundle";;
+verify-blob) [ "$(cat "$bundle")" = "$sig" ] || { echo "invalid signature" >&2; exit 1; };;
+esac
+`
+
+func TestSignSigstore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The fake cosign is a shell script")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cosign"), []byte(fakeCosign), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	key := filepath.Join(dir, "cosign.key")
+	require.NoError(t, os.WriteFile(key, []byte("key"), 0600))
+
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+
+	err := Run([]string{"mender-artifact", "sign", "--sigstore", key, art})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "validate", "--sigstore", key, art})
+	assert.NoError(t, err)
+
+	// Re-sign keyless, changing the manifest with the name.
+	require.NoError(t, Run([]string{"mender-artifact", "modify", "-n", "release-2", art}))
+	err = Run([]string{"mender-artifact", "validate", "--sigstore", key, art})
+	require.Error(t, err)
+	require.NoError(t, Run([]string{"mender-artifact", "sign", "--sigstore", "keyless",
+		"--force", art}))
+
+	t.Setenv("SIGSTORE_OIDC_ISSUER", "")
+	err = Run([]string{"mender-artifact", "validate", "--sigstore", "dev@example.com", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "SIGSTORE_OIDC_ISSUER")
+	t.Setenv("SIGSTORE_OIDC_ISSUER", "https://issuer")
+	err = Run([]string{"mender-artifact", "validate", "--sigstore", "dev@example.com", art})
+	assert.NoError(t, err)
+}
+
+func TestSignAddSignature(t *testing.T) {
+	dir := t.TempDir()
+	var entries []TestDirEntry
+	for _, name := range []string{"old", "new", "other"} {
+		priv, pub, err := generateKeys()
+		require.NoError(t, err)
+		entries = append(entries,
+			TestDirEntry{Path: name + ".key", Content: priv},
+			TestDirEntry{Path: name + ".pub", Content: pub})
+	}
+	require.NoError(t, MakeFakeUpdateDir(dir, entries))
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	art := filepath.Join(dir, "artifact.mender")
+
+	// Adding to an unsigned Artifact gives it its first signature.
+	err := Run([]string{"mender-artifact", "sign", "--add-signature",
+		"-k", filepath.Join(dir, "old.key"), art})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "sign", "--add-signature",
+		"-k", filepath.Join(dir, "new.key"), art})
+	require.NoError(t, err)
+
+	for _, key := range []string{"old.pub", "new.pub"} {
+		err = Run([]string{"mender-artifact", "validate",
+			"-k", filepath.Join(dir, key), art})
+		assert.NoError(t, err, key)
+	}
+	err = Run([]string{"mender-artifact", "validate",
+		"-k", filepath.Join(dir, "other.pub"), art})
+	assert.Error(t, err)
+
+	// Re-signing replaces all the signatures.
+	err = Run([]string{"mender-artifact", "sign", "-f",
+		"-k", filepath.Join(dir, "other.key"), art})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "validate",
+		"-k", filepath.Join(dir, "other.pub"), art})
+	assert.NoError(t, err)
+	err = Run([]string{"mender-artifact", "validate",
+		"-k", filepath.Join(dir, "new.pub"), art})
+	assert.Error(t, err)
+
+	err = Run([]string{"mender-artifact", "sign", "-f", "--add-signature",
+		"-k", filepath.Join(dir, "new.key"), art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mutually exclusive")
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+}
+
+func TestSignAddSignatureV2(t *testing.T) {
+	dir := t.TempDir()
+	priv, _, err := generateKeys()
+	require.NoError(t, err)
+	require.NoError(t, MakeFakeUpdateDir(dir,
+		[]TestDirEntry{{Path: "private.key", Content: priv}}))
+	require.NoError(t, WriteArtifact(dir, 2, ""))
+	art := filepath.Join(dir, "artifact.mender")
+
+	err = Run([]string{"mender-artifact", "sign",
+		"-k", filepath.Join(dir, "private.key"), art})
+	require.NoError(t, err)
+	err = Run([]string{"mender-artifact", "sign", "--add-signature",
+		"-k", filepath.Join(dir, "private.key"), art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Version 2 Artifacts can only hold one signature")
+}
+
+func TestWriteSignValidateED25519(t *testing.T) {
+	dir := t.TempDir()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	privKey := filepath.Join(dir, "private.key")
+	pubKey := filepath.Join(dir, "public.key")
+	require.NoError(t, os.WriteFile(privKey,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600))
+	require.NoError(t, os.WriteFile(pubKey,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644))
+
+	written := filepath.Join(dir, "written.mender")
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"--type", "test-type", "-n", "test-artifact", "-t", "test-device",
+		"-k", privKey, "-o", written})
+	require.NoError(t, err)
+
+	require.NoError(t, WriteArtifact(dir, 3, ""))
+	signed := filepath.Join(dir, "artifact.mender")
+	err = Run([]string{"mender-artifact", "sign", "-k", privKey, signed})
+	require.NoError(t, err)
+
+	for _, art := range []string{written, signed} {
+		err = Run([]string{"mender-artifact", "validate", "-k", pubKey, art})
+		assert.NoError(t, err, art)
+
+		out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+			"-k", pubKey, "--field", "signature", art})
+		require.NoError(t, err)
+		assert.Equal(t, "signed and verified correctly", strings.TrimSpace(out))
+	}
+
+	// A different key does not verify.
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherDER, err := x509.MarshalPKIXPublicKey(other)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(pubKey,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherDER}), 0644))
+	err = Run([]string{"mender-artifact", "validate", "-k", pubKey, signed})
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// tarballMagics maps the magic numbers of compressed tarballs to the ids of
+// the compressors reading them.
+var tarballMagics = []struct {
+	magic []byte
+	id    string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "lzma"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd_fast"},
+}
+
+// openTarball returns a reader of the uncompressed tar archive in r, which
+// may be gzip, xz or zstd compressed.
+func openTarball(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	for _, m := range tarballMagics {
+		head, _ := br.Peek(len(m.magic))
+		if bytes.Equal(head, m.magic) {
+			comp, err := artifact.NewCompressorFromId(m.id)
+			if err != nil {
+				return nil, err
+			}
+			return comp.NewReader(br)
+		}
+	}
+	return br, nil
+}
+
+// tarballBuildID computes an identifier of the contents of a tarball: the
+// names, types, permissions, link targets and file contents of its entries.
+// Timestamps, ownership, entry order and compression do not affect it, so
+// rebuilding the same sources gives the same build-id.
+func tarballBuildID(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	r, err := openTarball(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "can not decompress %s", name)
+	}
+
+	var entries []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", errors.Wrapf(err, "can not read tarball %s", name)
+		}
+		h := sha256.New()
+		if _, err = io.Copy(h, tr); err != nil {
+			return "", errors.Wrapf(err, "can not read tarball %s", name)
+		}
+		entries = append(entries, fmt.Sprintf("%s %c %04o %s %x\n",
+			path.Clean("/"+hdr.Name), hdr.Typeflag, hdr.Mode&07777, hdr.Linkname, h.Sum(nil)))
+	}
+	if len(entries) == 0 {
+		return "", errors.Errorf("tarball %s is empty", name)
+	}
+	sort.Strings(entries)
+
+	h := sha256.New()
+	for _, entry := range entries {
+		io.WriteString(h, entry)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// srcTarballBuildIDKey returns the provide holding the build-id; it is in
+// the namespace of the software version, so that the default clears provides
+// cover it.
+func srcTarballBuildIDKey(ctx *cli.Context) string {
+	softwareName := ctx.String("type")
+	if ctx.String(softwareNameFlag) != "" {
+		softwareName = ctx.String(softwareNameFlag)
+	}
+	return artifact.BuildSoftwareProvidesKey(ctx.String(softwareFilesystemFlag), softwareName,
+		"build-id")
+}
+
+func writeSrcTarball(ctx *cli.Context) error {
+	if len(ctx.StringSlice("file")) != 1 {
+		return cli.NewExitError("Exactly one tarball must be given with --file",
+			errArtifactInvalidParameters)
+	}
+	return writeModuleImage(ctx)
+}
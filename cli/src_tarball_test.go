@@ -0,0 +1,134 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testTarEntry struct {
+	name    string
+	content string
+}
+
+func writeTestTarball(t *testing.T, name string, compress bool, mtime time.Time,
+	entries ...testTarEntry) {
+
+	f, err := os.Create(name)
+	require.NoError(t, err)
+	defer f.Close()
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, e := range entries {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:    e.name,
+			Mode:    0644,
+			Size:    int64(len(e.content)),
+			ModTime: mtime,
+		}))
+		_, err = tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+}
+
+func TestTarballBuildID(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-src-tarball")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	a := testTarEntry{"src/a.c", "int a;"}
+	b := testTarEntry{"src/b.c", "int b;"}
+
+	first := filepath.Join(tmpdir, "first.tar.gz")
+	writeTestTarball(t, first, true, time.Unix(1000, 0), a, b)
+	// Same contents, in another order, packed at another time, uncompressed.
+	second := filepath.Join(tmpdir, "second.tar")
+	writeTestTarball(t, second, false, time.Unix(2000, 0), b, a)
+	changed := filepath.Join(tmpdir, "changed.tar.gz")
+	writeTestTarball(t, changed, true, time.Unix(1000, 0), a,
+		testTarEntry{"src/b.c", "int c;"})
+
+	firstID, err := tarballBuildID(first)
+	require.NoError(t, err)
+	assert.Len(t, firstID, 64)
+	secondID, err := tarballBuildID(second)
+	require.NoError(t, err)
+	assert.Equal(t, firstID, secondID)
+	changedID, err := tarballBuildID(changed)
+	require.NoError(t, err)
+	assert.NotEqual(t, firstID, changedID)
+
+	empty := filepath.Join(tmpdir, "empty.tar")
+	writeTestTarball(t, empty, false, time.Unix(1000, 0))
+	_, err = tarballBuildID(empty)
+	assert.Error(t, err)
+}
+
+func TestWriteSrcTarball(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-src-tarball")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	tarball := filepath.Join(tmpdir, "app.tar.gz")
+	writeTestTarball(t, tarball, true, time.Unix(1000, 0),
+		testTarEntry{"etc/app.conf", "key=value\n"})
+	buildID, err := tarballBuildID(tarball)
+	require.NoError(t, err)
+	art := filepath.Join(tmpdir, "artifact.mender")
+
+	err = Run([]string{"mender-artifact", "write", "src-tarball",
+		"-t", "my-device", "-n", "release-1", "-f", tarball, "-o", art})
+	require.NoError(t, err)
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read", art})
+	require.NoError(t, err)
+	assert.Contains(t, data, "- Type: src-tarball\n")
+	assert.Contains(t, data, "rootfs-image.src-tarball.build-id: "+buildID+"\n")
+	assert.Contains(t, data, "rootfs-image.src-tarball.version: release-1\n")
+	assert.Contains(t, data, "Clears Provides: [rootfs-image.src-tarball.*]\n")
+	assert.Contains(t, data, "name: app.tar.gz\n")
+
+	// The software name moves the build-id along with the version.
+	err = Run([]string{"mender-artifact", "write", "src-tarball",
+		"-t", "my-device", "-n", "release-1", "-f", tarball, "-o", art,
+		"-T", "app-config", "--software-name", "app"})
+	require.NoError(t, err)
+	data, err = runAndCollectStdout([]string{"mender-artifact", "read", art})
+	require.NoError(t, err)
+	assert.Contains(t, data, "- Type: app-config\n")
+	assert.Contains(t, data, "rootfs-image.app.build-id: "+buildID+"\n")
+	assert.Contains(t, data, "Clears Provides: [rootfs-image.app.*]\n")
+
+	err = Run([]string{"mender-artifact", "write", "src-tarball",
+		"-t", "my-device", "-n", "release-1", "-f", tarball, "-f", tarball, "-o", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Exactly one tarball")
+}
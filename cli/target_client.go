@@ -0,0 +1,159 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/mender-artifact/areader"
+)
+
+// clientFeature is a feature of Artifacts which Mender clients only handle
+// from a given version onwards.
+type clientFeature struct {
+	name string
+	// First client version handling the feature; empty if no client
+	// release handles it.
+	since string
+	used  func(ar *areader.Reader) bool
+}
+
+// clientFeatures is the capability matrix `validate --target-client` checks
+// Artifacts against.
+var clientFeatures = []clientFeature{
+	{
+		name:  "Artifact format version 3",
+		since: "2.0",
+		used: func(ar *areader.Reader) bool {
+			return ar.GetInfo().Version >= 3
+		},
+	},
+	{
+		name:  "clears_artifact_provides",
+		since: "2.5",
+		used: func(ar *areader.Reader) bool {
+			return len(ar.MergeArtifactClearsProvides()) > 0
+		},
+	},
+	{
+		name:  "zstd compression",
+		since: "3.0",
+		used: func(ar *areader.Reader) bool {
+			return ar.Compressor() != nil && ar.Compressor().GetFileExtension() == ".zst"
+		},
+	},
+	{
+		name:  "bootstrap payloads",
+		since: "3.5",
+		used: func(ar *areader.Reader) bool {
+			for _, inst := range ar.GetHandlers() {
+				if inst.GetUpdateType() == nil {
+					return true
+				}
+			}
+			return false
+		},
+	},
+	{
+		name: "more than one payload",
+		used: func(ar *areader.Reader) bool {
+			return len(ar.GetHandlers()) > 1
+		},
+	},
+	{
+		name: "device type specific state scripts",
+		used: func(ar *areader.Reader) bool {
+			return len(ar.GetDeviceTypeScripts()) > 0
+		},
+	},
+	{
+		name: "deduplicated payload files",
+		used: func(ar *areader.Reader) bool {
+			return len(ar.LinkedDataFiles()) > 0
+		},
+	},
+	{
+		name: "payload signatures",
+		used: func(ar *areader.Reader) bool {
+			for _, inst := range ar.GetHandlers() {
+				if len(inst.GetUpdatePayloadSignatures()) > 0 {
+					return true
+				}
+			}
+			return false
+		},
+	},
+}
+
+// parseClientVersion parses a client version of the form MAJOR[.MINOR[.PATCH]].
+func parseClientVersion(version string) ([]int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return nil, errors.Errorf("invalid client version %q", version)
+	}
+	parsed := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid client version %q", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// clientVersionBefore returns true if version a is older than version b.
+func clientVersionBefore(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// checkTargetClient returns a message for every feature the Artifact uses
+// which the given client version does not handle.
+func checkTargetClient(ar *areader.Reader, target string) ([]string, error) {
+	version, err := parseClientVersion(target)
+	if err != nil {
+		return nil, err
+	}
+	var unsupported []string
+	for _, feature := range clientFeatures {
+		if !feature.used(ar) {
+			continue
+		}
+		if feature.since == "" {
+			unsupported = append(unsupported,
+				fmt.Sprintf("%s is not supported by any Mender client", feature.name))
+			continue
+		}
+		since, err := parseClientVersion(feature.since)
+		if err != nil {
+			return nil, err
+		}
+		if clientVersionBefore(version, since) {
+			unsupported = append(unsupported, fmt.Sprintf(
+				"%s requires Mender client %s or later, the target is %s",
+				feature.name, feature.since, target))
+		}
+	}
+	return unsupported, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/awriter"
+	"github.com/mendersoftware/mender-artifact/handlers"
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+// upgradeWriteArgs turns the write arguments of an unpacked version 2 rootfs
+// Artifact into the ones of an equivalent version 3 Artifact, with the same
+// provides and clears provides as "write rootfs-image" would use by default.
+func upgradeWriteArgs(ua *unpackedArtifact) error {
+	args := ua.writeArgs
+	if args.Version != 2 {
+		return errors.Errorf("expected a version 2 Artifact, got version %d", args.Version)
+	}
+	if args.TypeInfoV3 == nil || args.TypeInfoV3.Type == nil ||
+		*args.TypeInfoV3.Type != "rootfs-image" {
+		return errors.New("only rootfs-image Artifacts can be upgraded")
+	}
+	if len(ua.files) != 1 {
+		return errors.New("only rootfs-image Artifacts with one file can be upgraded")
+	}
+
+	updateType := artifact.RootfsImageType
+	typeInfoV3 := &artifact.TypeInfoV3{
+		Type: &updateType,
+		ArtifactProvides: artifact.TypeInfoProvides{
+			artifact.RootfsImageVersionKey: args.Name,
+		},
+		ClearsArtifactProvides: []string{
+			artifact.ArtifactGroupKey,
+			artifact.LegacyRootfsImageChecksumKey,
+			artifact.BuildSoftwareClearsProvides("", ""),
+		},
+	}
+	if err := writeRootfsImageChecksum(ua.files[0], typeInfoV3, false); err != nil {
+		return err
+	}
+
+	args.Version = 3
+	args.Updates = &awriter.Updates{
+		Updates: []handlers.Composer{handlers.NewRootfsV3(ua.files[0])},
+	}
+	args.Provides = &artifact.ArtifactProvides{
+		ArtifactName: args.Name,
+	}
+	args.Depends = &artifact.ArtifactDepends{
+		CompatibleDevices: args.Devices,
+	}
+	args.TypeInfoV3 = typeInfoV3
+	return nil
+}
+
+func upgradeArtifact(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.NewExitError(fmt.Sprintf("Got %d arguments, wants one", c.NArg()),
+			errArtifactInvalidParameters)
+	}
+
+	comp, err := getCompressor(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError("Unable to load key: "+err.Error(), errArtifactInvalidParameters)
+	}
+
+	ua, err := unpackArtifact(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Can not read Artifact: %s", err.Error()),
+			errArtifactOpen)
+	}
+	defer utils.RemoveTemp(ua.unpackDir)
+
+	if err = upgradeWriteArgs(ua); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactUnsupportedVersion)
+	}
+
+	output := c.String("output-path")
+	tmp, err := utils.TempFile(filepath.Dir(output), "upgrade")
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactCreate)
+	}
+	defer utils.RemoveTemp(tmp.Name())
+	defer tmp.Close()
+
+	aWriter := awriter.NewWriter(tmp, comp)
+	if key != nil {
+		aWriter = awriter.NewWriterSigned(tmp, comp, key)
+	}
+	if err = aWriter.WriteArtifact(ua.writeArgs); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactCreate)
+	}
+	if err = tmp.Close(); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactCreate)
+	}
+	if err = os.Rename(tmp.Name(), output); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactCreate)
+	}
+	return nil
+}
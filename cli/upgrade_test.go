@@ -0,0 +1,64 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeArtifact(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-upgrade")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	script := filepath.Join(tmpdir, "ArtifactInstall_Enter_00")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\n"), 0755))
+	v2 := filepath.Join(tmpdir, "v2.mender")
+	v3 := filepath.Join(tmpdir, "v3.mender")
+
+	err = Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-t", "other-device",
+		"-n", "release-1", "-f", rootfs, "-s", script,
+		"-o", v2, "-v", "2"})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "upgrade", v2, "-o", v3})
+	require.NoError(t, err)
+
+	data, err := runAndCollectStdout([]string{"mender-artifact", "read", v3})
+	require.NoError(t, err)
+	assert.Contains(t, data, "Name: release-1\n")
+	assert.Contains(t, data, "Version: 3\n")
+	assert.Contains(t, data, "Compatible devices: [my-device, other-device]\n")
+	assert.Contains(t, data, "State scripts:\n    - ArtifactInstall_Enter_00\n")
+	assert.Contains(t, data, "rootfs-image.version: release-1\n")
+	assert.Contains(t, data, "rootfs-image.checksum: "+
+		"bfb4567944c5730face9f3d54efc0c1ff3b5dd1338862b23b849ac87679e162f\n")
+	assert.Contains(t, data,
+		"Clears Provides: [artifact_group, rootfs_image_checksum, rootfs-image.*]\n")
+
+	// Upgrading a version 3 Artifact is refused.
+	err = Run([]string{"mender-artifact", "upgrade", v3, "-o", v2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expected a version 2 Artifact")
+}
@@ -86,3 +86,40 @@ func EchoSigHandler(
 		}
 	}
 }
+
+// MakeRaw puts the terminal into raw mode, so that key presses are read one
+// by one, without echo or signals. It returns the previous state, for
+// RestoreTerminal.
+func MakeRaw(fd int) (*unix.Termios, error) {
+	term, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	newTerm := *term
+	newTerm.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	newTerm.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	newTerm.Cflag &^= unix.CSIZE | unix.PARENB
+	newTerm.Cflag |= unix.CS8
+	newTerm.Cc[unix.VMIN] = 1
+	newTerm.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &newTerm); err != nil {
+		return nil, err
+	}
+	return term, nil
+}
+
+// RestoreTerminal restores a terminal state returned by MakeRaw.
+func RestoreTerminal(fd int, term *unix.Termios) error {
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, term)
+}
+
+// TerminalSize returns the width and height of the terminal.
+func TerminalSize(fd int) (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
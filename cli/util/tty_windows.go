@@ -75,3 +75,45 @@ func EchoSigHandler(ctx context.Context, sigChan chan os.Signal, errChan chan er
 		}
 	}
 }
+
+// MakeRaw puts the console into raw mode with virtual terminal sequences, so
+// that key presses are read one by one, without echo. It returns the previous
+// input mode, for RestoreTerminal.
+func MakeRaw(fd int) (uint32, error) {
+	var cmode uint32
+	if err := windows.GetConsoleMode(windows.Handle(fd), &cmode); err != nil {
+		return 0, err
+	}
+
+	newCmode := cmode
+	newCmode &^= (windows.ENABLE_ECHO_INPUT |
+		windows.ENABLE_LINE_INPUT |
+		windows.ENABLE_PROCESSED_INPUT)
+	newCmode |= windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(windows.Handle(fd), newCmode); err != nil {
+		return 0, err
+	}
+
+	// The output has to understand the escape sequences too.
+	out := windows.Handle(os.Stdout.Fd())
+	var omode uint32
+	if err := windows.GetConsoleMode(out, &omode); err == nil {
+		_ = windows.SetConsoleMode(out, omode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	}
+	return cmode, nil
+}
+
+// RestoreTerminal restores a console input mode returned by MakeRaw.
+func RestoreTerminal(fd int, cmode uint32) error {
+	return windows.SetConsoleMode(windows.Handle(fd), cmode)
+}
+
+// TerminalSize returns the width and height of the console window.
+func TerminalSize(fd int) (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(fd), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1,
+		int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
@@ -15,8 +15,10 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/pkg/errors"
@@ -24,21 +26,50 @@ import (
 
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/attestation"
+	"github.com/mendersoftware/mender-artifact/avalidate"
 )
 
 func validate(art io.Reader, key artifact.Verifier) error {
+	return validateBuffered(art, key, 0, 0)
+}
+
+func validateBuffered(art io.Reader, key artifact.Verifier, bufSize, readAhead int) error {
+	_, err := validateReader(art, key, bufSize, readAhead, 0, nil)
+	return err
+}
+
+// validateReader validates the Artifact and returns the reader it was read
+// with, so that the caller can inspect the validated headers. checksumJobs
+// is the number of payload files hashed at once, see
+// areader.Reader.ChecksumWorkers. progress, if not nil, is called as the
+// Artifact is read.
+func validateReader(
+	art io.Reader,
+	key artifact.Verifier,
+	bufSize, readAhead, checksumJobs int,
+	progress areader.ProgressFn,
+) (*areader.Reader, error) {
 	// do not return error immediately if we can not validate signature;
 	// just continue checking consistency and return info if
 	// signature verification failed
 	var validationError error
+	verified := false
 
 	ar := areader.NewReader(art)
+	ar.ReadBufferSize = bufSize
+	ar.ReadAheadBuffers = readAhead
+	ar.ChecksumWorkers = checksumJobs
+	ar.StageProgressCallback = progress
 	ar.VerifySignatureCallback = func(message, sig []byte) error {
 		if key == nil {
 			return nil
 		}
 		if key != nil {
-			if err := key.Verify(message, sig); err != nil {
+			// Any of the signatures of the Artifact will do.
+			if err := key.Verify(message, sig); err == nil {
+				verified = true
+			} else if validationError == nil {
 				validationError = err
 			}
 		}
@@ -46,41 +77,203 @@ func validate(art io.Reader, key artifact.Verifier) error {
 	}
 
 	if err := ar.ReadArtifact(); err != nil {
-		return err
+		return nil, err
 	}
-	if validationError != nil {
-		return validationError
+	if validationError != nil && !verified {
+		return nil, validationError
 	}
 	if key != nil && !ar.IsSigned {
-		return errors.New("missing signature")
+		return nil, errors.New("missing signature")
 	}
 	if key == nil && ar.IsSigned {
-		return errors.New("missing verifier")
+		return nil, errors.New("missing verifier")
 	}
-	return nil
+	return ar, nil
+}
+
+// verifyAttestation checks that the device which produced the attestation
+// runs the validated Artifact.
+func verifyAttestation(c *cli.Context, ar *areader.Reader) error {
+	if c.String("attestation-key") == "" {
+		return errors.New("--attestation requires --attestation-key")
+	}
+	keyPEM, err := ioutil.ReadFile(c.String("attestation-key"))
+	if err != nil {
+		return errors.Wrap(err, "can not read attestation key")
+	}
+	key, err := artifact.GetKeyAndVerifyMethod(keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "invalid attestation key")
+	}
+
+	f, err := os.Open(c.String("attestation"))
+	if err != nil {
+		return errors.Wrap(err, "can not open attestation")
+	}
+	defer f.Close()
+	att, err := attestation.Parse(f)
+	if err != nil {
+		return err
+	}
+
+	ref, err := attestation.NewReference(ar)
+	if err != nil {
+		return err
+	}
+	return att.Verify(key.Key, ref, c.String("attestation-nonce"))
 }
 
 func validateArtifact(c *cli.Context) error {
 	if c.NArg() == 0 {
-		return cli.NewExitError("Nothing specified, nothing validated. \nMaybe you wanted"+
-			" to say 'artifacts validate <pathspec>'?", errArtifactInvalidParameters)
+		return cli.NewExitError(tr(nothingValidated), errArtifactInvalidParameters)
+	}
+	if c.Int("checksum-jobs") < 0 {
+		return cli.NewExitError("--checksum-jobs can not be negative",
+			errArtifactInvalidParameters)
 	}
 
 	key, err := getKey(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
 	}
+	if target := c.String("target-client"); target != "" {
+		if _, err = parseClientVersion(target); err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+	}
 
-	art, err := os.Open(c.Args().First())
+	art, err := openArtifactInput(c.Args().First())
 	if err != nil {
-		return cli.NewExitError("Can not open artifact: "+err.Error(), errArtifactOpen)
+		return cli.NewExitError(trf("Can not open artifact: %s", err.Error()), errArtifactOpen)
 	}
 	defer art.Close()
 
-	if err := validate(art, key); err != nil {
+	if c.Bool("json") {
+		return validateArtifactJSON(c, art, key)
+	}
+
+	ar, err := validateReader(art, key, c.Int("read-buffer-size"), c.Int("read-ahead"),
+		checksumJobs(c), readProgressFn(c))
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+	if err = validateExternalFiles(c, ar); err != nil {
 		return cli.NewExitError(err.Error(), errArtifactInvalid)
 	}
 
-	fmt.Printf("Artifact file '%s' validated successfully\n", c.Args().First())
+	fmt.Println(trf("Artifact file '%s' validated successfully",
+		artifactInputName(c.Args().First())))
+
+	if _, err := warnTargetClient(c, ar); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	if c.String("attestation") == "" {
+		return nil
+	}
+	if err := verifyAttestation(c, ar); err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalid)
+	}
+	fmt.Printf("Attestation '%s' matches the Artifact\n", c.String("attestation"))
+	return nil
+}
+
+// validationReport is the result of `validate --json`.
+type validationReport struct {
+	Artifact string `json:"artifact"`
+	avalidate.Report
+	Attestation string `json:"attestation,omitempty"`
+	// Features of the Artifact the --target-client does not handle.
+	TargetClient         string   `json:"target_client,omitempty"`
+	TargetClientWarnings []string `json:"target_client_warnings,omitempty"`
+	// The payload files of thin Artifacts, which are only checked with
+	// --fetch-external.
+	ExternalFiles []validationExternalFile `json:"external_files,omitempty"`
+}
+
+// validateToReport validates the Artifact like validateReader, but goes on
+// past damaged payload files, so that the result of every file of the
+// manifest can be reported.
+func validateToReport(
+	art io.Reader,
+	key artifact.Verifier,
+	bufSize, readAhead, checksumJobs int,
+	progress areader.ProgressFn,
+	report *validationReport,
+) *areader.Reader {
+	report.Report, _ = avalidate.Validate(art,
+		avalidate.WithVerifier(key),
+		avalidate.WithReadBufferSize(bufSize),
+		avalidate.WithReadAhead(readAhead),
+		avalidate.WithChecksumWorkers(checksumJobs),
+		avalidate.WithProgress(progress))
+	return report.Reader
+}
+
+// warnTargetClient warns about every feature of the Artifact which the
+// --target-client does not handle, and returns the warnings.
+func warnTargetClient(c *cli.Context, ar *areader.Reader) ([]string, error) {
+	if c.String("target-client") == "" {
+		return nil, nil
+	}
+	warnings, err := checkTargetClient(ar, c.String("target-client"))
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		warnf(WarningTargetClient, "%s", warning)
+	}
+	return warnings, nil
+}
+
+// checksumJobs returns the number of payload files to hash at once, given
+// with --checksum-jobs and bounded by --cpu-limit.
+func checksumJobs(c *cli.Context) int {
+	if jobs := c.Int("checksum-jobs"); jobs > 0 {
+		return limitJobs(jobs)
+	}
+	return 0
+}
+
+func validateArtifactJSON(c *cli.Context, art io.Reader, key artifact.Verifier) error {
+	report := validationReport{Artifact: artifactInputName(c.Args().First())}
+	ar := validateToReport(art, key, c.Int("read-buffer-size"), c.Int("read-ahead"),
+		checksumJobs(c), readProgressFn(c), &report)
+	if ar != nil {
+		report.TargetClient = c.String("target-client")
+		warnings, err := warnTargetClient(c, ar)
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		report.TargetClientWarnings = warnings
+
+		external, err := checkExternalFiles(ar, c.Bool("fetch-external"))
+		if err != nil {
+			report.Fail(err)
+		}
+		for _, file := range external {
+			if file.Status == "failed" {
+				report.Fail(errors.New(file.Error))
+			}
+		}
+		report.ExternalFiles = external
+	}
+	if c.String("attestation") != "" && report.Valid {
+		if err := verifyAttestation(c, ar); err != nil {
+			report.Attestation = "failed"
+			report.Fail(err)
+		} else {
+			report.Attestation = "verified"
+		}
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), errSystemError)
+	}
+	fmt.Println(string(out))
+	if !report.Valid {
+		return cli.NewExitError("", errArtifactInvalid)
+	}
 	return nil
 }
@@ -0,0 +1,206 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/artifact"
+)
+
+// batchValidation is the result of validating one Artifact of a batch.
+type batchValidation struct {
+	Artifact  string   `json:"artifact"`
+	Valid     bool     `json:"valid"`
+	Errors    []string `json:"errors,omitempty"`
+	Signature string   `json:"signature"`
+	Name      string   `json:"name,omitempty"`
+	// Seconds is the time the validation took.
+	Seconds float64 `json:"seconds"`
+}
+
+// batchSummary sums up the results of a batch.
+type batchSummary struct {
+	Total   int `json:"total"`
+	Valid   int `json:"valid"`
+	Invalid int `json:"invalid"`
+	// Signatures counts the Artifacts by the state of their signature.
+	Signatures map[string]int `json:"signatures"`
+	Seconds    float64        `json:"seconds"`
+}
+
+// batchReport is the report written by validate-batch --report.
+type batchReport struct {
+	Summary   batchSummary      `json:"summary"`
+	Artifacts []batchValidation `json:"artifacts"`
+}
+
+// findBatchArtifacts returns the Artifacts to validate, sorted: the files
+// given, and the files ending in .mender in the directories given and their
+// subdirectories.
+func findBatchArtifacts(paths []string) ([]string, error) {
+	var found []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			found = append(found, path)
+			continue
+		}
+		err = filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && strings.HasSuffix(name, ".mender") {
+				found = append(found, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// validateBatchArtifact validates the Artifact at path like validate --json.
+func validateBatchArtifact(path string, key artifact.Verifier) batchValidation {
+	start := time.Now()
+	result := batchValidation{Artifact: path, Signature: "none"}
+	f, err := os.Open(path)
+	if err != nil {
+		result.Errors = []string{"Can not open artifact: " + err.Error()}
+	} else {
+		report := validationReport{Artifact: path}
+		validateToReport(f, key, 0, 0, 0, nil, &report)
+		f.Close()
+		result.Valid = report.Valid
+		result.Errors = report.Errors
+		result.Signature = report.Signature
+		result.Name = report.Name
+	}
+	result.Seconds = time.Since(start).Seconds()
+	return result
+}
+
+// validateBatch validates the Artifacts with jobs of them at a time, and
+// returns the results in the order of paths.
+func validateBatch(paths []string, key artifact.Verifier, jobs int) []batchValidation {
+	results := make([]batchValidation, len(paths))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = validateBatchArtifact(paths[index], key)
+			}
+		}()
+	}
+	for index := range paths {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+func summarizeBatch(results []batchValidation, elapsed time.Duration) batchSummary {
+	summary := batchSummary{
+		Total:      len(results),
+		Signatures: map[string]int{},
+		Seconds:    elapsed.Seconds(),
+	}
+	for _, result := range results {
+		if result.Valid {
+			summary.Valid++
+		} else {
+			summary.Invalid++
+		}
+		summary.Signatures[result.Signature]++
+	}
+	return summary
+}
+
+func validateArtifactBatch(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return cli.NewExitError("Please give the directories or Artifacts to validate",
+			errArtifactInvalidParameters)
+	}
+	jobs := c.Int("jobs")
+	if jobs < 0 {
+		return cli.NewExitError("--jobs can not be negative", errArtifactInvalidParameters)
+	}
+	jobs = limitJobs(jobs)
+	key, err := getKey(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+
+	paths, err := findBatchArtifacts(c.Args())
+	if err != nil {
+		return cli.NewExitError("Can not list artifacts: "+err.Error(), errArtifactOpen)
+	}
+	start := time.Now()
+	results := validateBatch(paths, key, jobs)
+	summary := summarizeBatch(results, time.Since(start))
+
+	for _, result := range results {
+		if result.Valid {
+			fmt.Printf("OK      %s\n", result.Artifact)
+		} else {
+			fmt.Printf("INVALID %s: %s\n", result.Artifact, strings.Join(result.Errors, "; "))
+		}
+	}
+	fmt.Printf("Validated %d Artifact(s) in %.1fs: %d valid, %d invalid\n",
+		summary.Total, summary.Seconds, summary.Valid, summary.Invalid)
+
+	if dest := c.String("report"); dest != "" {
+		if results == nil {
+			results = []batchValidation{}
+		}
+		data, err := json.MarshalIndent(batchReport{Summary: summary, Artifacts: results},
+			"", defaultIndentation)
+		if err == nil {
+			err = ioutil.WriteFile(dest, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			return cli.NewExitError(
+				errors.Wrap(err, "Can not write report").Error(), errSystemError)
+		}
+	}
+
+	if summary.Invalid > 0 {
+		return cli.NewExitError(
+			fmt.Sprintf("%d of %d Artifact(s) are invalid", summary.Invalid, summary.Total),
+			errArtifactInvalid)
+	}
+	return nil
+}
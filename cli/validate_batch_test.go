@@ -0,0 +1,120 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateBatch(t *testing.T) {
+	tmpdir := t.TempDir()
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	dir := filepath.Join(tmpdir, "artifacts")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+
+	write := func(name string) string {
+		artfile := filepath.Join(dir, name+".mender")
+		require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-T", "testType", "-t", "dev", "-n", filepath.Base(name),
+			"-f", update}))
+		return artfile
+	}
+	a := write("a")
+	b := write(filepath.Join("sub", "b"))
+	c := write("c")
+	// Not an Artifact by its name, so it is not validated.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("text"), 0644))
+
+	out, err := runAndCollectStdout([]string{"mender-artifact", "validate-batch",
+		"--jobs", "2", dir})
+	require.NoError(t, err)
+	assert.Contains(t, out, "OK      "+a+"\n")
+	assert.Contains(t, out, "OK      "+b+"\n")
+	assert.Contains(t, out, "3 valid, 0 invalid")
+
+	// Damage one of them.
+	data, err := os.ReadFile(c)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(c, data[:len(data)/2], 0644))
+
+	report := filepath.Join(tmpdir, "report.json")
+	_, err = runAndCollectStdout([]string{"mender-artifact", "validate-batch",
+		"--jobs", "8", "--report", report, dir})
+	require.Error(t, err)
+	assert.Equal(t, errArtifactInvalid, lastExitCode)
+	assert.Contains(t, err.Error(), "1 of 3 Artifact(s) are invalid")
+
+	data, err = os.ReadFile(report)
+	require.NoError(t, err)
+	var parsed batchReport
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, 3, parsed.Summary.Total)
+	assert.Equal(t, 2, parsed.Summary.Valid)
+	assert.Equal(t, 1, parsed.Summary.Invalid)
+	assert.Equal(t, map[string]int{"none": 3}, parsed.Summary.Signatures)
+	require.Len(t, parsed.Artifacts, 3)
+	// Sorted by path.
+	assert.Equal(t, a, parsed.Artifacts[0].Artifact)
+	assert.Equal(t, c, parsed.Artifacts[1].Artifact)
+	assert.False(t, parsed.Artifacts[1].Valid)
+	assert.NotEmpty(t, parsed.Artifacts[1].Errors)
+	assert.Equal(t, b, parsed.Artifacts[2].Artifact)
+	assert.Equal(t, "b", parsed.Artifacts[2].Name)
+}
+
+func TestValidateBatchWithKey(t *testing.T) {
+	tmpdir := t.TempDir()
+	update := filepath.Join(tmpdir, "update")
+	require.NoError(t, os.WriteFile(update, []byte("my update"), 0644))
+	privateKey := filepath.Join(tmpdir, "private.key")
+	require.NoError(t, os.WriteFile(privateKey, []byte(PrivateECDSAKey), 0600))
+	publicKey := filepath.Join(tmpdir, "public.key")
+	require.NoError(t, os.WriteFile(publicKey, []byte(PublicECDSAKey), 0644))
+	dir := filepath.Join(tmpdir, "artifacts")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+
+	for _, name := range []string{"signed", "unsigned"} {
+		args := []string{"mender-artifact", "write", "module-image",
+			"-o", filepath.Join(dir, name+".mender"), "-T", "testType", "-t", "dev",
+			"-n", name, "-f", update}
+		if name == "signed" {
+			args = append(args, "-k", privateKey)
+		}
+		require.NoError(t, Run(args))
+	}
+
+	report := filepath.Join(tmpdir, "report.json")
+	_, err := runAndCollectStdout([]string{"mender-artifact", "validate-batch",
+		"-k", publicKey, "--report", report, dir})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 2 Artifact(s) are invalid")
+
+	data, err := os.ReadFile(report)
+	require.NoError(t, err)
+	var parsed batchReport
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	require.Len(t, parsed.Artifacts, 2)
+	assert.True(t, parsed.Artifacts[0].Valid)
+	assert.Equal(t, "verified", parsed.Artifacts[0].Signature)
+	assert.False(t, parsed.Artifacts[1].Valid)
+	assert.Equal(t, "missing", parsed.Artifacts[1].Signature)
+}
@@ -15,14 +15,26 @@
 package cli
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/attestation"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -143,6 +155,52 @@ func TestArtifactsValidate(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// runWithArtifactOnStdin runs args with the Artifact at path on standard
+// input, through a pipe, so that it can not be seeked.
+func runWithArtifactOnStdin(t *testing.T, path string, args []string) (string, error) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	go func() {
+		f, err := os.Open(path)
+		if err == nil {
+			_, _ = io.Copy(w, f)
+			f.Close()
+		}
+		w.Close()
+	}()
+
+	orgStdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = orgStdin }()
+	return runAndCollectStdout(args)
+}
+
+func TestReadOnlyCommandsFromStdin(t *testing.T) {
+	updateTestDir := t.TempDir()
+	require.NoError(t, WriteArtifact(updateTestDir, 3, ""))
+	artfile := filepath.Join(updateTestDir, "artifact.mender")
+
+	out, err := runWithArtifactOnStdin(t, artfile,
+		[]string{"mender-artifact", "validate", "-"})
+	require.NoError(t, err)
+	assert.Equal(t, "Artifact file '<stdin>' validated successfully", out)
+
+	fromFile, err := runAndCollectStdout([]string{"mender-artifact", "read", artfile})
+	require.NoError(t, err)
+	out, err = runWithArtifactOnStdin(t, artfile, []string{"mender-artifact", "read", "-"})
+	require.NoError(t, err)
+	assert.Equal(t, fromFile, out)
+
+	fromFile, err = runAndCollectStdout([]string{"mender-artifact", "dump",
+		"--print-cmdline", "--files", filepath.Join(updateTestDir, "files1"), artfile})
+	require.NoError(t, err)
+	out, err = runWithArtifactOnStdin(t, artfile, []string{"mender-artifact", "dump",
+		"--print-cmdline", "--files", filepath.Join(updateTestDir, "files2"), "-"})
+	require.NoError(t, err)
+	assert.Equal(t, strings.ReplaceAll(fromFile, "files1", "files2"), out)
+}
+
 func TestArtifactsValidateError(t *testing.T) {
 	err := Run([]string{"mender-artifact", "validate"})
 	assert.Error(t, err)
@@ -155,3 +213,265 @@ func TestArtifactsValidateError(t *testing.T) {
 	assert.Equal(t, errArtifactOpen, lastExitCode)
 	assert.Contains(t, fakeErrWriter.String(), "no such file")
 }
+
+func TestValidateAttestation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attestation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "rootfs.ext4")
+	require.NoError(t, ioutil.WriteFile(image, []byte("rootfs"), 0644))
+	art := filepath.Join(dir, "artifact.mender")
+	require.NoError(t, Run([]string{"mender-artifact", "write", "rootfs-image",
+		"-t", "my-device", "-n", "release-1", "-f", image, "-o", art}))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	keyFile := filepath.Join(dir, "attestation.pem")
+	require.NoError(t, ioutil.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	// writeAttestation writes an attestation of name, like a device which
+	// has installed the Artifact would.
+	checksum := sha256.Sum256([]byte("rootfs"))
+	writeAttestation := func(name string) string {
+		claims := attestation.Claims{
+			Provides: map[string]string{
+				"artifact_name":         name,
+				"rootfs-image.checksum": hex.EncodeToString(checksum[:]),
+			},
+			Nonce: "challenge",
+		}
+		digest, err := claims.Digest()
+		require.NoError(t, err)
+		quote := (&attestation.Quote{
+			ExtraData:     digest,
+			PCRSelections: []attestation.PCRSelection{{Hash: attestation.TPMAlgSHA256}},
+		}).Marshal()
+		hashed := sha256.Sum256(quote)
+		sig, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+		require.NoError(t, err)
+
+		data, err := json.Marshal(&attestation.Attestation{
+			Claims:    claims,
+			Quote:     quote,
+			Signature: sig,
+		})
+		require.NoError(t, err)
+		path := filepath.Join(dir, name+".json")
+		require.NoError(t, ioutil.WriteFile(path, data, 0644))
+		return path
+	}
+
+	good := writeAttestation("release-1")
+	out, err := runAndCollectStdout([]string{"mender-artifact", "validate",
+		"--attestation", good, "--attestation-key", keyFile,
+		"--attestation-nonce", "challenge", art})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Attestation '"+good+"' matches the Artifact")
+
+	err = Run([]string{"mender-artifact", "validate",
+		"--attestation", good, "--attestation-key", keyFile,
+		"--attestation-nonce", "other", art})
+	assert.EqualError(t, err, "attestation: the nonce does not match")
+	assert.Equal(t, errArtifactInvalid, lastExitCode)
+
+	err = Run([]string{"mender-artifact", "validate",
+		"--attestation", writeAttestation("release-2"),
+		"--attestation-key", keyFile, art})
+	assert.EqualError(t, err, `attestation: the device runs "release-2", not "release-1"`)
+
+	err = Run([]string{"mender-artifact", "validate", "--attestation", good, art})
+	assert.EqualError(t, err, "--attestation requires --attestation-key")
+}
+
+// validateJSON runs `validate --json` with args and decodes the report,
+// which is printed even if validation fails.
+func validateJSON(t *testing.T, args ...string) (validationReport, error) {
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	require.NoError(t, err)
+	savedStdout := os.Stdout
+	os.Stdout = stdout
+	runErr := Run(append([]string{"mender-artifact", "validate", "--json"}, args...))
+	os.Stdout = savedStdout
+	stdout.Close()
+
+	var report validationReport
+	out, err := os.ReadFile(stdout.Name())
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(out, &report), string(out))
+	return report, runErr
+}
+
+func TestValidateJSON(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	makeFile(t, dir, "file2", "payload2")
+	art := filepath.Join(dir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "--compression", "none", "write", "module-image",
+		"-o", art, "-n", "release-1", "-t", "test-device", "-T", "test-type",
+		"-p", "test.key:value", "-f", filepath.Join(dir, "file"),
+		"-f", filepath.Join(dir, "file2")})
+	require.NoError(t, err)
+
+	report, err := validateJSON(t, art)
+	require.NoError(t, err)
+	assert.True(t, report.Valid)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, art, report.Artifact)
+	assert.Equal(t, "none", report.Signature)
+	assert.Equal(t, "release-1", report.Name)
+	assert.Equal(t, 3, report.Version)
+	assert.Equal(t, "release-1", report.Provides["artifact_name"])
+	assert.Equal(t, "value", report.Provides["test.key"])
+	require.Len(t, report.Payloads, 1)
+	assert.Equal(t, "test-type", report.Payloads[0].Type)
+	names := []string{}
+	for _, f := range report.Files {
+		assert.Equal(t, "ok", f.Status, f.Name)
+		assert.Len(t, f.Checksum, 64, f.Name)
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t,
+		[]string{"version", "header.tar", "data/0000/file", "data/0000/file2"}, names)
+
+	// A signed Artifact without a key to verify it is not valid.
+	priv, pub, err := generateKeys()
+	require.NoError(t, err)
+	makeFile(t, dir, "private.key", string(priv))
+	makeFile(t, dir, "public.key", string(pub))
+	err = Run([]string{"mender-artifact", "sign", "-k", filepath.Join(dir, "private.key"), art})
+	require.NoError(t, err)
+	report, err = validateJSON(t, art)
+	require.Error(t, err)
+	assert.Equal(t, errArtifactInvalid, lastExitCode)
+	assert.False(t, report.Valid)
+	assert.Equal(t, "unverified", report.Signature)
+	assert.Equal(t, []string{"missing verifier"}, report.Errors)
+
+	report, err = validateJSON(t, "-k", filepath.Join(dir, "public.key"), art)
+	require.NoError(t, err)
+	assert.Equal(t, "verified", report.Signature)
+
+	// A damaged file is reported, and the others still checked.
+	data, err := os.ReadFile(art)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "payload2"))
+	data = []byte(strings.Replace(string(data), "payload2", "PAYLOAD2", 1))
+	require.NoError(t, os.WriteFile(art, data, 0644))
+	report, err = validateJSON(t, "-k", filepath.Join(dir, "public.key"), art)
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	assert.Equal(t, "verified", report.Signature)
+	for _, f := range report.Files {
+		if f.Name == "data/0000/file2" {
+			assert.Equal(t, "failed", f.Status)
+			assert.NotEmpty(t, f.Error)
+		} else {
+			assert.Equal(t, "ok", f.Status, f.Name)
+		}
+	}
+	require.Len(t, report.Errors, 1)
+	assert.Contains(t, report.Errors[0], "data/0000/file2")
+
+	// Hashing the files on other goroutines finds the same damage.
+	hashed, err := validateJSON(t, "--checksum-jobs", "2",
+		"-k", filepath.Join(dir, "public.key"), art)
+	require.Error(t, err)
+	assert.Equal(t, report.Files, hashed.Files)
+	assert.Equal(t, report.Errors, hashed.Errors)
+	err = Run([]string{"mender-artifact", "validate", "--checksum-jobs", "2", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file2")
+	err = Run([]string{"mender-artifact", "validate", "--checksum-jobs", "-1", art})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--checksum-jobs can not be negative")
+
+	// Broken headers leave little to report.
+	require.NoError(t, os.WriteFile(art, data[:1024], 0644))
+	report, err = validateJSON(t, art)
+	require.Error(t, err)
+	assert.False(t, report.Valid)
+	assert.NotEmpty(t, report.Errors)
+	assert.Empty(t, report.Files)
+}
+
+func TestValidateTargetClient(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	art := filepath.Join(dir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "--compression", "zstd_fast", "write", "module-image",
+		"-o", art, "-n", "release-1", "-t", "test-device", "-T", "test-type",
+		"--clears-provides", "test.*", "-f", filepath.Join(dir, "file")})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "validate", "--target-client", "2.4", art})
+	require.NoError(t, err)
+	assert.Equal(t, []WarningClass{WarningTargetClient, WarningTargetClient},
+		warningClasses(Warnings()))
+	assert.Equal(t,
+		"clears_artifact_provides requires Mender client 2.5 or later, the target is 2.4",
+		Warnings()[0].Message)
+	assert.Equal(t,
+		"zstd compression requires Mender client 3.0 or later, the target is 2.4",
+		Warnings()[1].Message)
+
+	err = Run([]string{"mender-artifact", "validate", "--target-client", "3.3", art})
+	require.NoError(t, err)
+	assert.Empty(t, Warnings())
+
+	report, err := validateJSON(t, "--target-client", "2.5.1", art)
+	require.NoError(t, err)
+	assert.Equal(t, "2.5.1", report.TargetClient)
+	assert.Equal(t,
+		[]string{"zstd compression requires Mender client 3.0 or later, the target is 2.5.1"},
+		report.TargetClientWarnings)
+
+	err = Run([]string{"mender-artifact", "validate", "--target-client", "three", art})
+	assert.EqualError(t, err, `invalid client version "three"`)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+}
+
+func TestValidateTargetClientDeduplicated(t *testing.T) {
+	dir := t.TempDir()
+	makeFile(t, dir, "file", "payload")
+	makeFile(t, dir, "augment", "payload")
+	art := filepath.Join(dir, "artifact.mender")
+	err := Run([]string{"mender-artifact", "write", "module-image",
+		"-o", art, "-n", "release-1", "-t", "test-device", "-T", "test-type",
+		"-f", filepath.Join(dir, "file"), "--augment-type", "test-type",
+		"--augment-file", filepath.Join(dir, "augment"), "--deduplicate-augment-files"})
+	require.NoError(t, err)
+
+	err = Run([]string{"mender-artifact", "validate", "--target-client", "3.5", art})
+	require.NoError(t, err)
+	require.Len(t, Warnings(), 1)
+	assert.Equal(t, "deduplicated payload files is not supported by any Mender client",
+		Warnings()[0].Message)
+}
+
+func TestClientVersion(t *testing.T) {
+	for _, tc := range []struct {
+		a, b   string
+		before bool
+	}{
+		{"2.4", "2.5", true},
+		{"2.5", "2.5", false},
+		{"2.5.1", "2.5", false},
+		{"3", "2.5", false},
+		{"2.10", "2.9", false},
+		{"1.7.1", "2.0", true},
+	} {
+		a, err := parseClientVersion(tc.a)
+		require.NoError(t, err)
+		b, err := parseClientVersion(tc.b)
+		require.NoError(t, err)
+		assert.Equal(t, tc.before, clientVersionBefore(a, b), "%s < %s", tc.a, tc.b)
+	}
+	for _, invalid := range []string{"", "3.", "v3.3", "1.2.3.4", "-1"} {
+		_, err := parseClientVersion(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
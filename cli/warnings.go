@@ -0,0 +1,140 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WarningClass identifies the kind of a warning, so that tools can act on
+// specific classes of warnings.
+type WarningClass string
+
+const (
+	WarningLegacyChecksumKey       WarningClass = "legacy-checksum-key"
+	WarningUnsignedOutput          WarningClass = "unsigned-output"
+	WarningPayloadSignatureRemoved WarningClass = "payload-signature-removed"
+	WarningCompressionIgnored      WarningClass = "compression-ignored"
+	WarningFsckSkipped             WarningClass = "fsck-skipped"
+	WarningFileSkipped             WarningClass = "file-skipped"
+	WarningCleanupFailed           WarningClass = "cleanup-failed"
+	WarningDeviceType              WarningClass = "device-type"
+	WarningTargetClient            WarningClass = "target-client"
+	WarningArtifactGroup           WarningClass = "artifact-group"
+	WarningPlatformDetection       WarningClass = "platform-detection"
+	WarningEnvironmentIgnored      WarningClass = "environment-ignored"
+)
+
+// Warning is a warning issued while running a command.
+type Warning struct {
+	Class   WarningClass `json:"class"`
+	Message string       `json:"message"`
+}
+
+type warningCollector struct {
+	lock     sync.Mutex
+	warnings []Warning
+}
+
+var collectedWarnings warningCollector
+
+func (w *warningCollector) reset() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.warnings = nil
+}
+
+func (w *warningCollector) add(class WarningClass, message string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.warnings = append(w.warnings, Warning{Class: class, Message: message})
+}
+
+func (w *warningCollector) list() []Warning {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]Warning{}, w.warnings...)
+}
+
+// Warnings returns the warnings issued by the last call to Run.
+func Warnings() []Warning {
+	return collectedWarnings.list()
+}
+
+// warnf logs a warning and records it with the given class.
+func warnf(class WarningClass, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	Log.Warn(message)
+	collectedWarnings.add(class, message)
+}
+
+func writeWarningsJSON(w io.Writer) error {
+	data, err := json.Marshal(struct {
+		Warnings []Warning `json:"warnings"`
+	}{Warnings: Warnings()})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// warningsFds keeps the files opened for file descriptors given by the
+// caller reachable, as they would close the descriptor once collected.
+var warningsFds = map[uintptr]*os.File{}
+
+func warningsFdFile(fd uintptr) *os.File {
+	switch fd {
+	case 1:
+		return os.Stdout
+	case 2:
+		return os.Stderr
+	}
+	f, ok := warningsFds[fd]
+	if !ok {
+		if f = os.NewFile(fd, "warnings"); f != nil {
+			warningsFds[fd] = f
+		}
+	}
+	return f
+}
+
+// emitWarningsJSON writes the collected warnings to dest, which is either a
+// file descriptor number or a file name.
+func emitWarningsJSON(dest string) error {
+	if fd, err := strconv.ParseUint(dest, 10, 0); err == nil {
+		f := warningsFdFile(uintptr(fd))
+		if f == nil {
+			return errors.Errorf("invalid file descriptor: %d", fd)
+		}
+		return errors.Wrap(writeWarningsJSON(f), "can not write warnings")
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return errors.Wrap(err, "can not write warnings")
+	}
+	defer f.Close()
+	if err = writeWarningsJSON(f); err != nil {
+		return errors.Wrap(err, "can not write warnings")
+	}
+	return errors.Wrap(f.Close(), "can not write warnings")
+}
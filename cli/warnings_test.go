@@ -0,0 +1,82 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func warningClasses(warnings []Warning) []WarningClass {
+	var classes []WarningClass
+	for _, w := range warnings {
+		classes = append(classes, w.Class)
+	}
+	return classes
+}
+
+func TestWarningsJSON(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mender-warnings")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	rootfs := filepath.Join(tmpdir, "update.ext4")
+	require.NoError(t, ioutil.WriteFile(rootfs, []byte("my update"), 0644))
+	keyFile := filepath.Join(tmpdir, "ecdsa.key")
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte(PrivateECDSAKey), 0600))
+	art := filepath.Join(tmpdir, "artifact.mender")
+	warningsFile := filepath.Join(tmpdir, "warnings.json")
+
+	err = Run([]string{"mender-artifact", "--warnings-json", warningsFile,
+		"write", "rootfs-image", "-t", "my-device", "-n", "release-1",
+		"-f", rootfs, "-o", art, "-k", keyFile, "--legacy-rootfs-image-checksum"})
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(warningsFile)
+	require.NoError(t, err)
+	var doc struct {
+		Warnings []Warning `json:"warnings"`
+	}
+	require.NoError(t, json.Unmarshal(data, &doc))
+	assert.Equal(t, []WarningClass{WarningLegacyChecksumKey}, warningClasses(doc.Warnings))
+	assert.Equal(t, doc.Warnings, Warnings())
+
+	// Modifying the signed Artifact without a key leaves it unsigned.
+	err = Run([]string{"mender-artifact", "modify", "-n", "release-2", art})
+	require.NoError(t, err)
+	assert.Contains(t, warningClasses(Warnings()), WarningUnsignedOutput)
+
+	// Warnings are reset for every run, and can be written to a file
+	// descriptor.
+	pipeR, pipeW, err := os.Pipe()
+	require.NoError(t, err)
+	defer pipeR.Close()
+	defer pipeW.Close()
+	err = Run([]string{"mender-artifact", "--warnings-json", strconv.Itoa(int(pipeW.Fd())),
+		"read", "--no-progress", art})
+	require.NoError(t, err)
+	assert.Empty(t, Warnings())
+	pipeW.Close()
+	data, err = ioutil.ReadAll(pipeR)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"warnings\":[]}\n", string(data))
+}
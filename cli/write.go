@@ -17,12 +17,15 @@ package cli
 import (
 	"bufio"
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -30,6 +33,7 @@ import (
 	"io"
 	"io/ioutil"
 
+	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 
@@ -56,9 +60,11 @@ func writeRootfsImageChecksum(rootfsFilename string,
 	}
 	checksum := string(chk.Checksum())
 
-	checksumKey := "rootfs-image.checksum"
+	checksumKey := artifact.RootfsImageChecksumKey
 	if legacy {
-		checksumKey = "rootfs_image_checksum"
+		checksumKey = artifact.LegacyRootfsImageChecksumKey
+		warnf(WarningLegacyChecksumKey,
+			"Using the legacy `rootfs_image_checksum` provide instead of `rootfs-image.checksum`")
 	}
 
 	Log.Debugf("Adding the `%s`: %q to Artifact provides", checksumKey, checksum)
@@ -77,6 +83,97 @@ func writeRootfsImageChecksum(rootfsFilename string,
 	return nil
 }
 
+// writeVerityImage writes a copy of the rootfs image with its dm-verity hash
+// tree appended, under the same name in a new temporary directory, and
+// returns the name of the copy.
+func writeVerityImage(rootfsFilename string) (string, *artifact.VerityHashTree, error) {
+	rootfs, err := os.Open(rootfsFilename)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "Failed to open the payload file")
+	}
+	defer rootfs.Close()
+
+	tmpdir, err := utils.TempDir("", "verity")
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := os.Create(filepath.Join(tmpdir, filepath.Base(rootfsFilename)))
+	if err != nil {
+		utils.RemoveTemp(tmpdir)
+		return "", nil, err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			utils.RemoveTemp(tmpdir)
+		}
+	}()
+
+	salt := make([]byte, artifact.VeritySaltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return "", nil, errors.Wrap(err, "Failed to generate the verity salt")
+	}
+	tree, err := artifact.NewVerityHashTree(io.TeeReader(rootfs, out), salt)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = tree.WriteTo(out); err != nil {
+		return "", nil, err
+	}
+	if err = out.Close(); err != nil {
+		return "", nil, err
+	}
+	return out.Name(), tree, nil
+}
+
+// addVerityProvides records the parameters needed to open the hash tree
+// appended to the rootfs image in the Artifact provides.
+func addVerityProvides(typeInfo *artifact.TypeInfoV3, tree *artifact.VerityHashTree) {
+	if typeInfo.ArtifactProvides == nil {
+		typeInfo.ArtifactProvides = artifact.TypeInfoProvides{}
+	}
+	typeInfo.ArtifactProvides["rootfs-image.verity.root-hash"] = hex.EncodeToString(tree.RootHash)
+	typeInfo.ArtifactProvides["rootfs-image.verity.salt"] = hex.EncodeToString(tree.Salt)
+	typeInfo.ArtifactProvides["rootfs-image.verity.hash-offset"] = strconv.FormatInt(
+		tree.DataBlocks*artifact.VerityBlockSize, 10)
+}
+
+// refreshVerityHashTree recomputes the hash tree appended to the rootfs image,
+// after the image has been modified, keeping the salt and the hash offset.
+func refreshVerityHashTree(rootfsFilename string, typeInfo *artifact.TypeInfoV3) error {
+	hashOffset := typeInfo.ArtifactProvides["rootfs-image.verity.hash-offset"]
+	offset, err := strconv.ParseInt(hashOffset, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "Invalid rootfs-image.verity.hash-offset provide")
+	}
+	salt, err := hex.DecodeString(typeInfo.ArtifactProvides["rootfs-image.verity.salt"])
+	if err != nil {
+		return errors.Wrap(err, "Invalid rootfs-image.verity.salt provide")
+	}
+
+	f, err := os.OpenFile(rootfsFilename, os.O_RDWR, 0)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open the payload file")
+	}
+	defer f.Close()
+
+	tree, err := artifact.NewVerityHashTree(io.LimitReader(f, offset), salt)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = tree.WriteTo(f); err != nil {
+		return err
+	}
+	addVerityProvides(typeInfo, tree)
+	return f.Close()
+}
+
 func validateInput(c *cli.Context) error {
 	// Version 2 and 3 validation.
 	fileMissing := false
@@ -88,20 +185,53 @@ func validateInput(c *cli.Context) error {
 	if len(c.StringSlice("device-type")) == 0 ||
 		len(c.String("artifact-name")) == 0 || fileMissing {
 		return cli.NewExitError(
-			"must provide `device-type`, `artifact-name` and `file`",
+			tr("must provide `device-type`, `artifact-name` and `file`"),
 			errArtifactInvalidParameters,
 		)
 	}
 	if len(strings.Fields(c.String("artifact-name"))) > 1 {
 		// check for whitespace in artifact-name
 		return cli.NewExitError(
-			"whitespace is not allowed in the artifact-name",
+			tr("whitespace is not allowed in the artifact-name"),
 			errArtifactInvalidParameters,
 		)
 	}
 	return nil
 }
 
+// deviceTypes returns the device types given with --device-type, normalized
+// if --normalize-device-types is set.
+func deviceTypes(c *cli.Context) ([]string, error) {
+	devices := c.StringSlice("device-type")
+	if c.Bool("normalize-device-types") {
+		devices = artifact.NormalizeDeviceTypes(devices)
+	}
+	if err := checkDeviceTypes(devices); err != nil {
+		return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+	}
+	return devices, nil
+}
+
+// checkDeviceTypes validates device types, and warns about the ones which
+// are only valid because letter case is kept.
+func checkDeviceTypes(devices []string) error {
+	if err := artifact.ValidateDeviceTypes(devices); err != nil {
+		if artifact.ValidateDeviceTypes(artifact.NormalizeDeviceTypes(devices)) == nil {
+			return errors.Wrap(err, "use --normalize-device-types to trim and lowercase"+
+				" device types")
+		}
+		return err
+	}
+	for _, device := range devices {
+		if device != strings.ToLower(device) {
+			warnf(WarningDeviceType, "Device type %q contains uppercase letters, which"+
+				" devices must match exactly; use --normalize-device-types to lowercase it",
+				device)
+		}
+	}
+	return nil
+}
+
 func createRootfsFromSSH(c *cli.Context) (string, error) {
 	rootfsFilename, err := getDeviceSnapshot(c)
 	if err != nil {
@@ -112,7 +242,7 @@ func createRootfsFromSSH(c *cli.Context) (string, error) {
 	fstype, err := imgFilesystemType(rootfsFilename)
 	if err != nil {
 		if err == errBlkidNotFound {
-			Log.Warnf("Skipping running fsck on the Artifact: %v", err)
+			warnf(WarningFsckSkipped, "Skipping running fsck on the Artifact: %v", err)
 			return rootfsFilename, nil
 		}
 		return rootfsFilename, cli.NewExitError(
@@ -156,18 +286,23 @@ func makeEmptyUpdates(ctx *cli.Context) (*awriter.Updates, error) {
 }
 
 func writeBootstrapArtifact(c *cli.Context) error {
-	comp, err := artifact.NewCompressorFromId(c.GlobalString("compression"))
+	comp, err := getCompressor(c)
 	if err != nil {
-		return cli.NewExitError(
-			"compressor '"+c.GlobalString("compression")+"' is not supported: "+err.Error(),
-			1,
-		)
+		return cli.NewExitError(err.Error(), 1)
 	}
 
+	deviceProvides, err := applyDeviceJSON(c)
+	if err != nil {
+		return err
+	}
 	if err := validateInput(c); err != nil {
 		Log.Error(err.Error())
 		return err
 	}
+	devices, err := deviceTypes(c)
+	if err != nil {
+		return err
+	}
 
 	// set the default name
 	name := "artifact.mender"
@@ -178,6 +313,11 @@ func writeBootstrapArtifact(c *cli.Context) error {
 
 	Log.Debugf("creating bootstrap artifact [%s], version: %d", name, version)
 
+	sums, err := newChecksumFile(c, name)
+	if err != nil {
+		return err
+	}
+
 	var w io.Writer
 	if name == "-" {
 		w = os.Stdout
@@ -193,15 +333,16 @@ func writeBootstrapArtifact(c *cli.Context) error {
 		w = f
 	}
 
-	aw, err := artifactWriter(c, comp, w, version)
+	aw, err := artifactWriter(c, comp, sums.wrap(w), version)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
 	depends := artifact.ArtifactDepends{
 		ArtifactName:      c.StringSlice("artifact-name-depends"),
-		CompatibleDevices: c.StringSlice("device-type"),
+		CompatibleDevices: devices,
 		ArtifactGroup:     c.StringSlice("depends-groups"),
+		ExcludedDevices:   c.StringSlice("not-device-type"),
 	}
 
 	provides := artifact.ArtifactProvides{
@@ -218,46 +359,59 @@ func writeBootstrapArtifact(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	addDeviceProvides(typeInfoV3, deviceProvides)
 
 	if !c.Bool("no-progress") {
 		ctx, cancel := context.WithCancel(context.Background())
-		go reportProgress(ctx, aw.State)
+		go reportProgress(ctx, aw.State, os.Stderr, statusMark(c.GlobalString("color"), os.Stderr))
 		defer cancel()
 		aw.ProgressWriter = utils.NewProgressWriter()
 	}
 
 	err = aw.WriteArtifact(
 		&awriter.WriteArtifactArgs{
-			Format:     "mender",
-			Version:    version,
-			Devices:    c.StringSlice("device-type"),
-			Name:       c.String("artifact-name"),
-			Updates:    upd,
-			Scripts:    nil,
-			Depends:    &depends,
-			Provides:   &provides,
-			TypeInfoV3: typeInfoV3,
-			Bootstrap:  true,
+			Format:             "mender",
+			Version:            version,
+			Devices:            devices,
+			Name:               c.String("artifact-name"),
+			Updates:            upd,
+			Scripts:            nil,
+			Depends:            &depends,
+			Provides:           &provides,
+			TypeInfoV3:         typeInfoV3,
+			Bootstrap:          true,
+			UncompressedHeader: c.Bool("uncompressed-header"),
+			ChecksumAlgorithm:  c.String("checksum-algorithm"),
 		})
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	return nil
+	printWriteStats(c, aw)
+	return sums.write(aw.Manifest())
 }
 
 func writeRootfs(c *cli.Context) error {
-	comp, err := artifact.NewCompressorFromId(c.GlobalString("compression"))
+	comp, err := getCompressor(c)
 	if err != nil {
-		return cli.NewExitError(
-			"compressor '"+c.GlobalString("compression")+"' is not supported: "+err.Error(),
-			1,
-		)
+		return cli.NewExitError(err.Error(), 1)
 	}
 
 	if err := validateInput(c); err != nil {
 		Log.Error(err.Error())
 		return err
 	}
+	devices, err := deviceTypes(c)
+	if err != nil {
+		return err
+	}
+
+	scr, err := collectScripts(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.Bool("dry-run") {
+		return nil
+	}
 
 	// set the default name
 	name := "artifact.mender"
@@ -270,10 +424,36 @@ func writeRootfs(c *cli.Context) error {
 	rootfsFilename := c.String("file")
 	if strings.HasPrefix(rootfsFilename, "ssh://") {
 		rootfsFilename, err = createRootfsFromSSH(c)
-		defer os.Remove(rootfsFilename)
+		defer utils.RemoveTemp(rootfsFilename)
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactCreate)
+		}
+	}
+
+	var detected *platform
+	if c.Bool("detect-platform") {
+		if version < 3 {
+			return cli.NewExitError("--detect-platform requires Artifact version 3 or later",
+				errArtifactInvalidParameters)
+		}
+		if detected, err = detectPlatform(rootfsFilename); err != nil {
+			return cli.NewExitError(err.Error(), errArtifactCreate)
+		}
+		Log.Infof("Detected architecture %q and operating system %q", detected.arch,
+			detected.os)
+	}
+
+	var verityTree *artifact.VerityHashTree
+	if c.Bool("verity") {
+		if version < 3 {
+			return cli.NewExitError("--verity requires Artifact version 3 or later",
+				errArtifactInvalidParameters)
+		}
+		rootfsFilename, verityTree, err = writeVerityImage(rootfsFilename)
 		if err != nil {
 			return cli.NewExitError(err.Error(), errArtifactCreate)
 		}
+		defer utils.RemoveTemp(filepath.Dir(rootfsFilename))
 	}
 
 	var h handlers.Composer
@@ -293,6 +473,11 @@ func writeRootfs(c *cli.Context) error {
 		Updates: []handlers.Composer{h},
 	}
 
+	sums, err := newChecksumFile(c, name)
+	if err != nil {
+		return err
+	}
+
 	var w io.Writer
 	if name == "-" {
 		w = os.Stdout
@@ -308,20 +493,16 @@ func writeRootfs(c *cli.Context) error {
 		w = f
 	}
 
-	aw, err := artifactWriter(c, comp, w, version)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	scr, err := scripts(c.StringSlice("script"))
+	aw, err := artifactWriter(c, comp, sums.wrap(w), version)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
 	depends := artifact.ArtifactDepends{
 		ArtifactName:      c.StringSlice("artifact-name-depends"),
-		CompatibleDevices: c.StringSlice("device-type"),
+		CompatibleDevices: devices,
 		ArtifactGroup:     c.StringSlice("depends-groups"),
+		ExcludedDevices:   c.StringSlice("not-device-type"),
 	}
 
 	provides := artifact.ArtifactProvides{
@@ -333,6 +514,12 @@ func writeRootfs(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if verityTree != nil {
+		addVerityProvides(typeInfoV3, verityTree)
+	}
+	if detected != nil {
+		addPlatformProvides(typeInfoV3, detected)
+	}
 
 	if !c.Bool("no-checksum-provide") {
 		legacy := c.Bool("legacy-rootfs-image-checksum")
@@ -346,43 +533,66 @@ func writeRootfs(c *cli.Context) error {
 
 	if !c.Bool("no-progress") {
 		ctx, cancel := context.WithCancel(context.Background())
-		go reportProgress(ctx, aw.State)
+		go reportProgress(ctx, aw.State, os.Stderr, statusMark(c.GlobalString("color"), os.Stderr))
 		defer cancel()
 		aw.ProgressWriter = utils.NewProgressWriter()
 	}
 
 	err = aw.WriteArtifact(
 		&awriter.WriteArtifactArgs{
-			Format:     "mender",
-			Version:    version,
-			Devices:    c.StringSlice("device-type"),
-			Name:       c.String("artifact-name"),
-			Updates:    upd,
-			Scripts:    scr,
-			Depends:    &depends,
-			Provides:   &provides,
-			TypeInfoV3: typeInfoV3,
+			Format:             "mender",
+			Version:            version,
+			Devices:            devices,
+			Name:               c.String("artifact-name"),
+			Updates:            upd,
+			Scripts:            scr,
+			Depends:            &depends,
+			Provides:           &provides,
+			TypeInfoV3:         typeInfoV3,
+			ImmutableMetadata:  c.Bool("immutable-metadata"),
+			UncompressedHeader: c.Bool("uncompressed-header"),
+			ChecksumAlgorithm:  c.String("checksum-algorithm"),
 		})
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	return nil
+	printWriteStats(c, aw)
+	return sums.write(aw.Manifest())
+}
+
+// statusMark returns the mark printed for completed stages on out: a green
+// check mark on terminals, and plain text otherwise. Colors are left out if
+// the NO_COLOR environment variable is set, unless colorMode is "always".
+func statusMark(colorMode string, out *os.File) string {
+	terminal := isatty.IsTerminal(out.Fd()) || isatty.IsCygwinTerminal(out.Fd())
+	switch {
+	case colorMode == "always":
+		return "\033[1;32m\u2713\033[0m"
+	case colorMode == "never" && terminal:
+		return "\u2713"
+	case colorMode == "never" || !terminal:
+		return "OK"
+	case os.Getenv("NO_COLOR") != "":
+		return "\u2713"
+	default:
+		return "\033[1;32m\u2713\033[0m"
+	}
 }
 
-func reportProgress(c context.Context, state chan string) {
-	fmt.Fprintln(os.Stderr, "Writing Artifact...")
+func reportProgress(c context.Context, state chan string, out io.Writer, mark string) {
+	fmt.Fprintln(out, "Writing Artifact...")
 	str := fmt.Sprintf("%-20s\t", <-state)
-	fmt.Fprint(os.Stderr, str)
+	fmt.Fprint(out, str)
 	for {
 		select {
 		case str = <-state:
 			if str == stage.Data {
-				fmt.Fprintf(os.Stderr, "\033[1;32m\u2713\033[0m\n")
-				fmt.Fprintln(os.Stderr, "Payload")
+				fmt.Fprintln(out, mark)
+				fmt.Fprintln(out, "Payload")
 			} else {
-				fmt.Fprintf(os.Stderr, "\033[1;32m\u2713\033[0m\n")
+				fmt.Fprintln(out, mark)
 				str = fmt.Sprintf("%-20s\t", str)
-				fmt.Fprint(os.Stderr, str)
+				fmt.Fprint(out, str)
 			}
 		case <-c.Done():
 			return
@@ -392,21 +602,43 @@ func reportProgress(c context.Context, state chan string) {
 
 func artifactWriter(c *cli.Context, comp artifact.Compressor, w io.Writer,
 	ver int) (*awriter.Writer, error) {
+	if c.Int64("buffer-memory") < 0 {
+		return nil, errors.New("--buffer-memory can not be negative")
+	}
 	privateKey, err := getKey(c)
 	if err != nil {
 		return nil, err
 	}
+	var aw *awriter.Writer
 	if privateKey != nil {
 		if ver == 0 {
 			// check if we are having correct version
 			return nil, errors.New("can not use signed artifact with version 0")
 		}
-		return awriter.NewWriterSigned(w, comp, privateKey), nil
+		aw = awriter.NewWriterSigned(w, comp, privateKey)
+	} else {
+		aw = awriter.NewWriter(w, comp)
+	}
+	aw.MemoryBufferSize = c.Int64("buffer-memory")
+	aw.TempDir = c.String("temp-dir")
+	if c.Bool("reproducible") {
+		// The global --source-date-epoch, if given, is already in effect.
+		aw.ReproducibleTime = artifact.ReproducibleTime()
+		if aw.ReproducibleTime.IsZero() {
+			aw.ReproducibleTime = time.Unix(0, 0)
+		}
 	}
-	return awriter.NewWriter(w, comp), nil
+	return aw, nil
 }
 
-func makeUpdates(ctx *cli.Context) (*awriter.Updates, error) {
+// makeUpdates returns the module image payload of the given files, followed
+// by the already collected archived files, and then the extra payloads.
+func makeUpdates(
+	ctx *cli.Context,
+	files []string,
+	archived []*handlers.DataFile,
+	extra []handlers.Composer,
+) (*awriter.Updates, error) {
 	version := ctx.Int("version")
 
 	var handler, augmentHandler handlers.Composer
@@ -424,10 +656,11 @@ func makeUpdates(ctx *cli.Context) (*awriter.Updates, error) {
 		)
 	}
 
-	dataFiles := make([](*handlers.DataFile), 0, len(ctx.StringSlice("file")))
-	for _, file := range ctx.StringSlice("file") {
+	dataFiles := make([](*handlers.DataFile), 0, len(files)+len(archived))
+	for _, file := range files {
 		dataFiles = append(dataFiles, &handlers.DataFile{Name: file})
 	}
+	dataFiles = append(dataFiles, archived...)
 	if err := handler.SetUpdateFiles(dataFiles); err != nil {
 		return nil, cli.NewExitError(
 			err,
@@ -436,7 +669,7 @@ func makeUpdates(ctx *cli.Context) (*awriter.Updates, error) {
 	}
 
 	upd := &awriter.Updates{
-		Updates: []handlers.Composer{handler},
+		Updates: append([]handlers.Composer{handler}, extra...),
 	}
 
 	if ctx.String("augment-type") != "" {
@@ -454,9 +687,67 @@ func makeUpdates(ctx *cli.Context) (*awriter.Updates, error) {
 		upd.Augments = []handlers.Composer{augmentHandler}
 	}
 
+	if ctx.Bool("sanitize-filenames") {
+		sanitizePayloadFileNames(upd)
+	} else if err := awriter.ValidatePayloadFileNames(upd); err != nil {
+		return nil, cli.NewExitError(
+			err.Error()+"\nUse --sanitize-filenames to rename the file(s) in the Artifact.",
+			errArtifactInvalidParameters)
+	}
+
 	return upd, nil
 }
 
+// sanitizePayloadFileNames gives the data files of upd which can not be stored
+// in the Artifact under their own name a valid name, unique within their
+// payload.
+func sanitizePayloadFileNames(upd *awriter.Updates) {
+	for n, u := range upd.Updates {
+		files := u.GetUpdateFiles()
+		if n < len(upd.Augments) {
+			files = append(files, upd.Augments[n].GetUpdateAugmentFiles()...)
+		}
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			names = append(names, filepath.Base(file.Name))
+		}
+		for i, name := range artifact.SanitizePayloadFileNames(names) {
+			if name != names[i] {
+				Log.Infof("Renaming %s to %s in the Artifact", files[i].Name, name)
+				files[i].PayloadName = name
+			}
+		}
+	}
+}
+
+// withOriginalFileNames records the original names of the renamed files in
+// the payload meta-data.
+func withOriginalFileNames(
+	metaData map[string]interface{},
+	files []*handlers.DataFile,
+) (map[string]interface{}, error) {
+	originals := map[string]interface{}{}
+	for _, file := range files {
+		if file.PayloadName != "" {
+			originals[file.PayloadName] = filepath.Base(file.Name)
+		}
+	}
+	if len(originals) == 0 {
+		return metaData, nil
+	}
+	if metaData == nil {
+		metaData = make(map[string]interface{})
+	}
+	if _, ok := metaData[artifact.OriginalFileNamesMetaDataKey]; ok {
+		return nil, cli.NewExitError(
+			fmt.Sprintf("The meta-data key %q is reserved for renamed payload files",
+				artifact.OriginalFileNamesMetaDataKey),
+			errArtifactInvalidParameters)
+	}
+	metaData[artifact.OriginalFileNamesMetaDataKey] = originals
+	return metaData, nil
+}
+
 // makeTypeInfo returns the type-info provides and depends and the augmented
 // type-info provides and depends, or nil.
 func makeTypeInfo(ctx *cli.Context) (*artifact.TypeInfoV3, *artifact.TypeInfoV3, error) {
@@ -484,6 +775,16 @@ func makeTypeInfo(ctx *cli.Context) (*artifact.TypeInfoV3, *artifact.TypeInfoV3,
 		}
 	}
 	typeInfoProvides = applySoftwareVersionToTypeInfoProvides(ctx, typeInfoProvides)
+	if ctx.Command.Name == "src-tarball" {
+		key := srcTarballBuildIDKey(ctx)
+		if _, ok := typeInfoProvides[key]; !ok {
+			buildID, err := tarballBuildID(ctx.StringSlice("file")[0])
+			if err != nil {
+				return nil, nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+			}
+			typeInfoProvides[key] = buildID
+		}
+	}
 
 	var augmentTypeInfoDepends artifact.TypeInfoDepends
 	keyValues, err = extractKeyValues(ctx.StringSlice("augment-depends"))
@@ -520,6 +821,7 @@ func makeTypeInfo(ctx *cli.Context) (*artifact.TypeInfoV3, *artifact.TypeInfoV3,
 		ArtifactDepends:        typeInfoDepends,
 		ArtifactProvides:       typeInfoProvides,
 		ClearsArtifactProvides: clearsArtifactProvides,
+		InstallSize:            ctx.Int64("install-size"),
 	}
 
 	if ctx.String("augment-type") == "" {
@@ -527,7 +829,7 @@ func makeTypeInfo(ctx *cli.Context) (*artifact.TypeInfoV3, *artifact.TypeInfoV3,
 		if len(ctx.StringSlice("augment-file")) != 0 ||
 			len(ctx.StringSlice("augment-depends")) != 0 ||
 			len(ctx.StringSlice("augment-provides")) != 0 ||
-			ctx.String("augment-meta-data") != "" {
+			len(ctx.StringSlice("augment-meta-data")) != 0 {
 
 			err = errors.New("Must give --augment-type argument if making augmented artifact")
 			fmt.Println(err.Error())
@@ -555,10 +857,6 @@ func getSoftwareVersion(
 	noDefaultSoftwareVersion bool,
 ) map[string]string {
 	result := map[string]string{}
-	softwareVersionName := "rootfs-image"
-	if softwareFilesystem != "" {
-		softwareVersionName = softwareFilesystem
-	}
 	if !noDefaultSoftwareVersion {
 		if softwareName == "" {
 			softwareName = softwareNameDefault
@@ -567,11 +865,9 @@ func getSoftwareVersion(
 			softwareVersion = artifactName
 		}
 	}
-	if softwareName != "" {
-		softwareVersionName += fmt.Sprintf(".%s", softwareName)
-	}
-	if softwareVersionName != "" && softwareVersion != "" {
-		result[softwareVersionName+".version"] = softwareVersion
+	if softwareVersion != "" {
+		key := artifact.BuildSoftwareVersionKey(softwareFilesystem, softwareName)
+		result[key] = softwareVersion
 	}
 	return result
 }
@@ -590,7 +886,7 @@ func applySoftwareVersionToTypeInfoProvides(
 	softwareFilesystem := ctx.String(softwareFilesystemFlag)
 	softwareName := ctx.String(softwareNameFlag)
 	softwareNameDefault := ""
-	if ctx.Command.Name == "module-image" {
+	if ctx.Command.Name == "module-image" || ctx.Command.Name == "src-tarball" {
 		softwareNameDefault = ctx.String("type")
 	}
 	if ctx.Command.Name == "bootstrap-artifact" {
@@ -650,12 +946,12 @@ func makeClearsArtifactProvides(ctx *cli.Context) ([]string, error) {
 	if ctx.IsSet("software-filesystem") {
 		softwareFilesystem = ctx.String("software-filesystem")
 	} else {
-		softwareFilesystem = "rootfs-image"
+		softwareFilesystem = artifact.RootfsImageType
 	}
 
 	var softwareName string
 	if len(ctx.String("software-name")) > 0 {
-		softwareName = ctx.String("software-name") + "."
+		softwareName = ctx.String("software-name")
 	} else if ctx.Command.Name == "rootfs-image" {
 		softwareName = ""
 		// "rootfs_image_checksum" is included for legacy
@@ -667,18 +963,19 @@ func makeClearsArtifactProvides(ctx *cli.Context) ([]string, error) {
 		// "artifact_group" is included as a sane default for
 		// rootfs-image updates. A standard rootfs-image update should
 		// clear the group if it does not have one.
-		if softwareFilesystem == "rootfs-image" {
-			list = append(list, "artifact_group", "rootfs_image_checksum")
+		if softwareFilesystem == artifact.RootfsImageType {
+			list = append(list, artifact.ArtifactGroupKey,
+				artifact.LegacyRootfsImageChecksumKey)
 		}
-	} else if ctx.Command.Name == "module-image" {
-		softwareName = ctx.String("type") + "."
+	} else if ctx.Command.Name == "module-image" || ctx.Command.Name == "src-tarball" {
+		softwareName = ctx.String("type")
 	} else {
 		return nil, errors.New(
 			"Unknown write command in makeClearsArtifactProvides(), this is a bug.",
 		)
 	}
 
-	defaultCap := fmt.Sprintf("%s.%s*", softwareFilesystem, softwareName)
+	defaultCap := artifact.BuildSoftwareClearsProvides(softwareFilesystem, softwareName)
 	for _, cap := range list {
 		if defaultCap == cap {
 			// Avoid adding it twice if the default is the same as a
@@ -696,42 +993,23 @@ func makeMetaData(ctx *cli.Context) (map[string]interface{}, map[string]interfac
 	var metaData map[string]interface{}
 	var augmentMetaData map[string]interface{}
 
-	if len(ctx.String("meta-data")) > 0 {
-		file, err := os.Open(ctx.String("meta-data"))
-		if err != nil {
-			return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
-		}
-		defer file.Close()
-		dec := json.NewDecoder(file)
-		err = dec.Decode(&metaData)
-		if err != nil {
-			return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
-		}
+	metaData, err := readMetaData(ctx.StringSlice("meta-data"))
+	if err != nil {
+		return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
 	}
 
-	if len(ctx.String("augment-meta-data")) > 0 {
-		file, err := os.Open(ctx.String("augment-meta-data"))
-		if err != nil {
-			return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
-		}
-		defer file.Close()
-		dec := json.NewDecoder(file)
-		err = dec.Decode(&augmentMetaData)
-		if err != nil {
-			return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
-		}
+	augmentMetaData, err = readMetaData(ctx.StringSlice("augment-meta-data"))
+	if err != nil {
+		return metaData, augmentMetaData, cli.NewExitError(err, errArtifactInvalidParameters)
 	}
 
 	return metaData, augmentMetaData, nil
 }
 
 func writeModuleImage(ctx *cli.Context) error {
-	comp, err := artifact.NewCompressorFromId(ctx.GlobalString("compression"))
+	comp, err := getCompressor(ctx)
 	if err != nil {
-		return cli.NewExitError(
-			"compressor '"+ctx.GlobalString("compression")+"' is not supported: "+err.Error(),
-			1,
-		)
+		return cli.NewExitError(err.Error(), 1)
 	}
 
 	// set the default name
@@ -749,8 +1027,111 @@ func writeModuleImage(ctx *cli.Context) error {
 	if len(ctx.StringSlice("device-type")) == 0 {
 		return cli.NewExitError("The `device-type` flag is required", 1)
 	}
+	devices, err := deviceTypes(ctx)
+	if err != nil {
+		return err
+	}
 
-	upd, err := makeUpdates(ctx)
+	scr, err := collectScripts(ctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if ctx.Bool("dry-run") {
+		return nil
+	}
+
+	files := ctx.StringSlice("file")
+	var external []artifact.ExternalFile
+	if len(ctx.StringSlice("external-payload")) > 0 {
+		if external, err = makeExternalFiles(ctx, files); err != nil {
+			return err
+		}
+		// Only the references to the files are stored in thin Artifacts.
+		files = nil
+	}
+	var delta *artifact.DeltaInfo
+	if ctx.String("delta-base") != "" {
+		if files, delta, err = makeDelta(ctx); err != nil {
+			return err
+		}
+	}
+
+	overrides, err := providesForDevice(ctx, devices)
+	if err != nil {
+		return err
+	}
+	if len(overrides) == 0 {
+		return writeModuleArtifact(ctx, comp, name, version, devices, scr, files, delta,
+			external, nil)
+	}
+	if name == "-" {
+		return cli.NewExitError("--provides-for-device writes one Artifact per device"+
+			" type, which can not be written to stdout", errArtifactInvalidParameters)
+	}
+	for _, device := range devices {
+		output := perDeviceOutputPath(name, device)
+		err = writeModuleArtifact(ctx, comp, output, version, []string{device}, scr,
+			files, delta, external, overrides[device])
+		if err != nil {
+			return err
+		}
+		Log.Infof("Wrote Artifact for %s to %s", device, output)
+	}
+	return nil
+}
+
+// writeModuleArtifact writes a module-image Artifact for the given device
+// types to name, with extraProvides added to the payload provides.
+func writeModuleArtifact(
+	ctx *cli.Context,
+	comp artifact.Compressor,
+	name string,
+	version int,
+	devices []string,
+	scr *artifact.Scripts,
+	files []string,
+	delta *artifact.DeltaInfo,
+	external []artifact.ExternalFile,
+	extraProvides artifact.TypeInfoProvides,
+) error {
+	var archived []*handlers.DataFile
+	if ctx.String("files-from") != "" {
+		var archive io.Closer
+		var err error
+		archived, archive, err = filesFromArchive(ctx.String("files-from"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		defer archive.Close()
+	}
+	var dirFiles []*handlers.DataFile
+	var dirPaths []string
+	if ctx.String("dir") != "" {
+		var err error
+		dirFiles, dirPaths, err = filesFromDir(ctx.String("dir"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		preceding := append([]string{}, files...)
+		for _, file := range archived {
+			preceding = append(preceding, file.Name)
+		}
+		nameDirFiles(preceding, dirFiles)
+	}
+	extras, err := makeExtraPayloads(ctx)
+	if err != nil {
+		return err
+	}
+	extraComposers := make([]handlers.Composer, 0, len(extras))
+	for _, extra := range extras {
+		extraComposers = append(extraComposers, extra.composer)
+	}
+	upd, err := makeUpdates(ctx, files, append(archived, dirFiles...), extraComposers)
+	if err != nil {
+		return err
+	}
+
+	sums, err := newChecksumFile(ctx, name)
 	if err != nil {
 		return err
 	}
@@ -770,20 +1151,16 @@ func writeModuleImage(ctx *cli.Context) error {
 		w = f
 	}
 
-	aw, err := artifactWriter(ctx, comp, w, version)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	scr, err := scripts(ctx.StringSlice("script"))
+	aw, err := artifactWriter(ctx, comp, sums.wrap(w), version)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
 	depends := artifact.ArtifactDepends{
 		ArtifactName:      ctx.StringSlice("artifact-name-depends"),
-		CompatibleDevices: ctx.StringSlice("device-type"),
+		CompatibleDevices: devices,
 		ArtifactGroup:     ctx.StringSlice("depends-groups"),
+		ExcludedDevices:   ctx.StringSlice("not-device-type"),
 	}
 
 	provides := artifact.ArtifactProvides{
@@ -795,31 +1172,149 @@ func writeModuleImage(ctx *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	if len(extraProvides) > 0 {
+		if typeInfoV3.ArtifactProvides == nil {
+			typeInfoV3.ArtifactProvides = artifact.TypeInfoProvides{}
+		}
+		for key, value := range extraProvides {
+			typeInfoV3.ArtifactProvides[key] = value
+		}
+	}
 
 	metaData, augmentMetaData, err := makeMetaData(ctx)
 	if err != nil {
 		return err
 	}
+	metaData, err = withOriginalFileNames(metaData, upd.Updates[0].GetUpdateFiles())
+	if err != nil {
+		return err
+	}
+	metaData, err = withFilePaths(metaData, dirFiles, dirPaths)
+	if err != nil {
+		return err
+	}
+	if len(upd.Augments) > 0 {
+		augmentMetaData, err = withOriginalFileNames(augmentMetaData,
+			upd.Augments[0].GetUpdateAugmentFiles())
+		if err != nil {
+			return err
+		}
+	}
+	if delta != nil {
+		if metaData, err = applyDelta(delta, metaData, &depends); err != nil {
+			return err
+		}
+	}
+	if external != nil {
+		if metaData, err = applyExternalFiles(external, metaData, typeInfoV3); err != nil {
+			return err
+		}
+	}
+	var payloadHeaders []awriter.PayloadHeader
+	if len(extras) > 0 {
+		payloadHeaders = []awriter.PayloadHeader{{TypeInfoV3: typeInfoV3, MetaData: metaData}}
+		for _, extra := range extras {
+			header := extra.header
+			header.MetaData, err = withOriginalFileNames(header.MetaData,
+				extra.composer.GetUpdateFiles())
+			if err != nil {
+				return err
+			}
+			payloadHeaders = append(payloadHeaders, header)
+		}
+	}
+
+	var payloadSigner artifact.Signer
+	if ctx.String("payload-sign-key") != "" {
+		key, err := readSecretFile(ctx.String("payload-sign-key"))
+		if err != nil {
+			return cli.NewExitError("Unable to read payload signing key: "+err.Error(), 1)
+		}
+		if payloadSigner, err = artifact.NewPKISigner(key); err != nil {
+			return cli.NewExitError("Unable to load payload signing key: "+err.Error(), 1)
+		}
+	}
 
 	err = aw.WriteArtifact(
 		&awriter.WriteArtifactArgs{
-			Format:            "mender",
-			Version:           version,
-			Devices:           ctx.StringSlice("device-type"),
-			Name:              ctx.String("artifact-name"),
-			Updates:           upd,
-			Scripts:           scr,
-			Depends:           &depends,
-			Provides:          &provides,
-			TypeInfoV3:        typeInfoV3,
-			MetaData:          metaData,
-			AugmentTypeInfoV3: augmentTypeInfoV3,
-			AugmentMetaData:   augmentMetaData,
+			Format:             "mender",
+			Version:            version,
+			Devices:            devices,
+			Name:               ctx.String("artifact-name"),
+			Updates:            upd,
+			Scripts:            scr,
+			Depends:            &depends,
+			Provides:           &provides,
+			TypeInfoV3:         typeInfoV3,
+			MetaData:           metaData,
+			AugmentTypeInfoV3:  augmentTypeInfoV3,
+			AugmentMetaData:    augmentMetaData,
+			ImmutableMetadata:  ctx.Bool("immutable-metadata"),
+			PayloadSigner:      payloadSigner,
+			UncompressedHeader: ctx.Bool("uncompressed-header"),
+			ChecksumAlgorithm:  ctx.String("checksum-algorithm"),
+			PayloadHeaders:     payloadHeaders,
+			DeduplicateFiles:   ctx.Bool("deduplicate-augment-files"),
 		})
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	return nil
+	printWriteStats(ctx, aw)
+	return sums.write(aw.Manifest())
+}
+
+// providesForDevice parses the --provides-for-device flags, each of the form
+// <device-type>=<key>:<value>, into the extra payload provides of each
+// device type.
+func providesForDevice(
+	ctx *cli.Context,
+	devices []string,
+) (map[string]artifact.TypeInfoProvides, error) {
+	overrides := map[string]artifact.TypeInfoProvides{}
+	for _, arg := range ctx.StringSlice("provides-for-device") {
+		split := strings.SplitN(arg, "=", 2)
+		if len(split) != 2 {
+			return nil, cli.NewExitError(
+				fmt.Sprintf("argument must be of the form <device-type>=<key>:<value>: %s", arg),
+				errArtifactInvalidParameters)
+		}
+		device := split[0]
+		if ctx.Bool("normalize-device-types") {
+			device = artifact.NormalizeDeviceType(device)
+		}
+		known := false
+		for _, d := range devices {
+			known = known || d == device
+		}
+		if !known {
+			return nil, cli.NewExitError(
+				fmt.Sprintf("--provides-for-device given for %q, which is not a --device-type",
+					device),
+				errArtifactInvalidParameters)
+		}
+		keyValues, err := extractKeyValues([]string{split[1]})
+		if err != nil {
+			return nil, err
+		}
+		provides, err := artifact.NewTypeInfoProvides(*keyValues)
+		if err != nil {
+			return nil, cli.NewExitError(err.Error(), errArtifactInvalidParameters)
+		}
+		if overrides[device] == nil {
+			overrides[device] = artifact.TypeInfoProvides{}
+		}
+		for key, value := range provides {
+			overrides[device][key] = value
+		}
+	}
+	return overrides, nil
+}
+
+// perDeviceOutputPath returns the output path of the Artifact for one
+// device type: the device type is added before the extension of name.
+func perDeviceOutputPath(name, device string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + device + ext
 }
 
 func extractKeyValues(params []string) (*map[string]string, error) {
@@ -900,7 +1395,7 @@ func getDeviceSnapshot(c *cli.Context) (string, error) {
 	}
 
 	// Create tempfile for storing the snapshot
-	f, err := ioutil.TempFile("", "rootfs.tmp")
+	f, err := utils.TempFile("", "snapshot")
 	if err != nil {
 		return "", err
 	}
@@ -915,7 +1410,11 @@ func getDeviceSnapshot(c *cli.Context) (string, error) {
 		sigChan = make(chan os.Signal, 1)
 		errChan = make(chan error, 1)
 		// Make sure that echo is enabled if the process gets
-		// interrupted
+		// interrupted, which returns an error rather than exiting, so
+		// that the temporary files are removed as the command fails.
+		if utils.StopCleanupTempOnSignal() {
+			defer utils.CleanupTempOnSignal()
+		}
 		signal.Notify(sigChan)
 		go util.EchoSigHandler(ctx, sigChan, errChan, term)
 	} else if err != syscall.ENOTTY {
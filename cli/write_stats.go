@@ -0,0 +1,50 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/mendersoftware/mender-artifact/awriter"
+)
+
+const writeStatsFlag = "stats"
+
+// printWriteStats prints the compression statistics of the Artifact written
+// by aw if --stats is set. They go to stderr, as the Artifact itself may be
+// written to stdout.
+func printWriteStats(c *cli.Context, aw *awriter.Writer) {
+	if c.Bool(writeStatsFlag) {
+		reportWriteStats(os.Stderr, aw.Stats())
+	}
+}
+
+func reportWriteStats(w io.Writer, stats []awriter.MemberStats) {
+	fmt.Fprintln(w, "Compression statistics:")
+	var in, out int64
+	for _, s := range stats {
+		fmt.Fprintf(w, "  %s: %d -> %d bytes (%.1f%%) in %s\n",
+			s.Name, s.InputSize, s.OutputSize, 100*s.Ratio(), s.Duration.Round(time.Millisecond))
+		in += s.InputSize
+		out += s.OutputSize
+	}
+	total := awriter.MemberStats{InputSize: in, OutputSize: out}
+	fmt.Fprintf(w, "  total: %d -> %d bytes (%.1f%%)\n", in, out, 100*total.Ratio())
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteStats(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", strings.Repeat("payload", 1000))
+
+	stderr, err := os.Create(filepath.Join(tmpdir, "stderr"))
+	require.NoError(t, err)
+	savedStderr := os.Stderr
+	os.Stderr = stderr
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-o", filepath.Join(tmpdir, "artifact.mender"), "-n", "Name",
+		"-t", "TestDevice", "-T", "my-own-type",
+		"-f", filepath.Join(tmpdir, "file"), "--stats"})
+	os.Stderr = savedStderr
+	stderr.Close()
+	require.NoError(t, err)
+
+	out, err := os.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "Compression statistics:", lines[0])
+	assert.Regexp(t, `^  header\.tar\.gz: \d+ -> \d+ bytes \(\d+\.\d%\) in \S+$`, lines[1])
+	assert.Regexp(t, `^  data/0000\.tar\.gz: \d+ -> \d+ bytes \(\d+\.\d%\) in \S+$`, lines[2])
+	assert.Regexp(t, `^  total: \d+ -> \d+ bytes \(\d+\.\d%\)$`, lines[3])
+}
@@ -15,13 +15,24 @@
 package cli
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mendersoftware/mender-artifact/areader"
 	"github.com/mendersoftware/mender-artifact/artifact"
+	"github.com/mendersoftware/mender-artifact/artifact/stage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -878,3 +889,468 @@ func TestWriteClearsProvides(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteRootfsVerity(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "mendertest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpdir)
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+
+	err = Run([]string{
+		"mender-artifact", "write", "rootfs-image",
+		"-t", "mydevice",
+		"-n", "testName",
+		"-f", "mender_test.img",
+		"-o", artfile,
+		"--verity",
+	})
+	require.NoError(t, err)
+
+	artFd, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer artFd.Close()
+	reader := areader.NewReader(artFd)
+	require.NoError(t, reader.ReadArtifact())
+	handler := reader.GetHandlers()[0]
+	provides, err := handler.GetUpdateProvides()
+	require.NoError(t, err)
+
+	salt, err := hex.DecodeString(provides["rootfs-image.verity.salt"])
+	require.NoError(t, err)
+	assert.Len(t, salt, artifact.VeritySaltSize)
+
+	img, err := os.Open("mender_test.img")
+	require.NoError(t, err)
+	defer img.Close()
+	tree, err := artifact.NewVerityHashTree(img, salt)
+	require.NoError(t, err)
+
+	assert.Equal(t, hex.EncodeToString(tree.RootHash), provides["rootfs-image.verity.root-hash"])
+	assert.Equal(t, "524288", provides["rootfs-image.verity.hash-offset"])
+	assert.Equal(t, 524288+tree.Size(), handler.GetUpdateFiles()[0].Size)
+
+	// Version 2 Artifacts have no provides to store the root hash in.
+	err = Run([]string{
+		"mender-artifact", "write", "rootfs-image",
+		"-t", "mydevice",
+		"-n", "testName",
+		"-f", "mender_test.img",
+		"-o", artfile,
+		"-v", "2",
+		"--verity",
+	})
+	assert.Error(t, err)
+}
+
+func TestStatusMark(t *testing.T) {
+	// Test output is not a terminal.
+	out, err := ioutil.TempFile("", "mender-status")
+	require.NoError(t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	t.Setenv("NO_COLOR", "")
+	assert.Equal(t, "OK", statusMark("auto", out))
+	assert.Equal(t, "OK", statusMark("never", out))
+	assert.Equal(t, "\033[1;32m\u2713\033[0m", statusMark("always", out))
+	t.Setenv("NO_COLOR", "1")
+	assert.Equal(t, "OK", statusMark("auto", out))
+	assert.Equal(t, "\033[1;32m\u2713\033[0m", statusMark("always", out))
+}
+
+func TestReportProgress(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	state := make(chan string)
+	buf := &bytes.Buffer{}
+	done := make(chan struct{})
+	go func() {
+		reportProgress(ctx, state, buf, "OK")
+		close(done)
+	}()
+	state <- stage.Version
+	state <- stage.Manifest
+	state <- stage.Data
+	// Give the last stage time to be printed before stopping.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	<-done
+
+	assert.NotContains(t, buf.String(), "\033")
+	assert.Contains(t, buf.String(), "Writing Artifact...\n")
+	assert.Contains(t, buf.String(), "\tOK\nPayload\n")
+}
+
+func TestInvalidColorFlag(t *testing.T) {
+	err := Run([]string{"mender-artifact", "--color", "sometimes", "validate", "foo"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --color value")
+}
+
+func TestWriteDeviceTypes(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-n", "testName", "-T", "testType", "-f", updateFile}, args...))
+	}
+
+	err := write("-t", "my device")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `device type "my device" contains whitespace`)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+
+	err = write("-t", "mydevice ")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use --normalize-device-types")
+
+	require.NoError(t, write("--normalize-device-types", "-t", " MyDevice ", "-t", "mydevice"))
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifactHeaders())
+	assert.Equal(t, []string{"mydevice"}, ar.GetCompatibleDevices())
+	assert.Empty(t, Warnings())
+
+	require.NoError(t, write("-t", "MyDevice"))
+	require.Len(t, Warnings(), 1)
+	assert.Equal(t, WarningDeviceType, Warnings()[0].Class)
+}
+
+func TestWriteProvidesForDevice(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-n", "testName", "-T", "testType", "-f", updateFile,
+			"-t", "raspberrypi4", "-t", "beaglebone", "-p", "common:yes"}, args...))
+	}
+
+	err := write(
+		"--provides-for-device", "raspberrypi4=rpi.firmware:1.2",
+		"--provides-for-device", "raspberrypi4=common:overridden",
+	)
+	require.NoError(t, err)
+	assert.NoFileExists(t, artfile)
+
+	for device, expected := range map[string]artifact.TypeInfoProvides{
+		"raspberrypi4": {"common": "overridden", "rpi.firmware": "1.2"},
+		"beaglebone":   {"common": "yes"},
+	} {
+		f, err := os.Open(filepath.Join(tmpdir, "artifact-"+device+".mender"))
+		require.NoError(t, err)
+		ar := areader.NewReader(f)
+		require.NoError(t, ar.ReadArtifactHeaders())
+		f.Close()
+
+		assert.Equal(t, []string{device}, ar.GetCompatibleDevices())
+		provides, err := ar.GetHandlers()[0].GetUpdateProvides()
+		require.NoError(t, err)
+		for key, value := range expected {
+			assert.Equal(t, value, provides[key], device+": "+key)
+		}
+		if device == "beaglebone" {
+			assert.NotContains(t, provides, "rpi.firmware")
+		}
+	}
+
+	err = write("--provides-for-device", "qemux86-64=key:value")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"qemux86-64", which is not a --device-type`)
+
+	err = write("--provides-for-device", "raspberrypi4:key:value")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "<device-type>=<key>:<value>")
+}
+
+func TestWriteCompressionOpt(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	write := func(compression string) error {
+		return Run([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-n", "testName", "-T", "testType", "-t", "testDevice",
+			"-f", updateFile,
+			"--compression", compression, "--compression-opt", "rsyncable"})
+	}
+
+	for _, compression := range []string{"gzip", "zstd_best"} {
+		require.NoError(t, write(compression))
+		require.NoError(t, Run([]string{"mender-artifact", "validate", artfile}))
+
+		filesDir := filepath.Join(tmpdir, compression)
+		require.NoError(t, Run([]string{"mender-artifact", "dump",
+			"--files", filesDir, artfile}))
+		content, err := os.ReadFile(filepath.Join(filesDir, "updateFile"))
+		require.NoError(t, err)
+		assert.Equal(t, "updateContent", string(content))
+	}
+
+	err := write("none")
+	require.Error(t, err)
+	assert.Equal(t, `compressor 'none': compressor does not support option "rsyncable"`,
+		err.Error())
+}
+
+func TestWriteZstdSeekable(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	require.NoError(t, Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-n", "testName", "-T", "testType", "-t", "testDevice",
+		"-f", updateFile, "--compression", "zstd_seekable"}))
+	require.NoError(t, Run([]string{"mender-artifact", "validate", artfile}))
+
+	data := artifactMember(t, artfile, "data/0000.tar.zst")
+	zr, err := artifact.NewZstdSeekableReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	defer zr.Close()
+	tr := tar.NewReader(io.NewSectionReader(zr, 0, zr.Size()))
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "updateFile", hdr.Name)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	assert.Equal(t, "updateContent", string(content))
+}
+
+func TestWriteSanitizeFilenames(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	invalid := filepath.Join(tmpdir, "my update.bin")
+	other := filepath.Join(tmpdir, "other", "update.bin")
+	require.NoError(t, os.WriteFile(invalid, []byte("first"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Dir(other), 0755))
+	require.NoError(t, os.WriteFile(other, []byte("second"), 0644))
+	valid := filepath.Join(tmpdir, "update.bin")
+	require.NoError(t, os.WriteFile(valid, []byte("third"), 0644))
+
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-n", "testName", "-T", "testType", "-t", "testDevice"},
+			args...))
+	}
+
+	err := write("-f", invalid)
+	require.Error(t, err)
+	assert.Equal(t, errArtifactInvalidParameters, lastExitCode)
+	assert.Contains(t, err.Error(), `"my update.bin" contains the invalid character ' '`)
+	assert.Contains(t, err.Error(), "--sanitize-filenames")
+	assert.NoFileExists(t, artfile)
+
+	err = write("-f", valid, "-f", other)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "would both be named update.bin")
+
+	err = write("-f", invalid, "-f", valid, "-f", other, "--sanitize-filenames")
+	require.NoError(t, err)
+
+	filesDir := filepath.Join(tmpdir, "files")
+	require.NoError(t, Run([]string{"mender-artifact", "dump", "--files", filesDir, artfile}))
+	for name, content := range map[string]string{
+		"my_update.bin": "first",
+		"update.bin":    "third",
+		"update-2.bin":  "second",
+	} {
+		data, err := os.ReadFile(filepath.Join(filesDir, name))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+
+	f, err := os.Open(artfile)
+	require.NoError(t, err)
+	defer f.Close()
+	ar := areader.NewReader(f)
+	require.NoError(t, ar.ReadArtifactHeaders())
+	metaData, err := ar.GetHandlers()[0].GetUpdateMetaData()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"my_update.bin": "my update.bin",
+		"update-2.bin":  "update.bin",
+	}, metaData[artifact.OriginalFileNamesMetaDataKey])
+}
+
+func TestWriteChecksumFile(t *testing.T) {
+	tmpdir := t.TempDir()
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	updateFile := filepath.Join(tmpdir, "updateFile")
+	require.NoError(t, os.WriteFile(updateFile, []byte("updateContent"), 0644))
+
+	write := func(output string) error {
+		return Run([]string{"mender-artifact", "write", "module-image",
+			"-o", output, "-n", "testName", "-T", "testType", "-t", "testDevice",
+			"-f", updateFile, "--checksum-file"})
+	}
+	require.NoError(t, write(artfile))
+
+	data, err := os.ReadFile(artfile + ".sha256")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	artifactData, err := os.ReadFile(artfile)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x  artifact.mender", sha256.Sum256(artifactData)), lines[0])
+	assert.Contains(t, lines,
+		fmt.Sprintf("%x  data/0000/updateFile", sha256.Sum256([]byte("updateContent"))))
+
+	var names []string
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "  ")
+		require.Len(t, fields, 2)
+		names = append(names, fields[1])
+	}
+	assert.ElementsMatch(t,
+		[]string{"data/0000/updateFile", "header.tar.gz", "version"}, names)
+
+	// The Artifact checks without the files inside it.
+	if bin, err := exec.LookPath("sha256sum"); err == nil {
+		cmd := exec.Command(bin, "-c", "--ignore-missing", "artifact.mender.sha256")
+		cmd.Dir = tmpdir
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		assert.Contains(t, string(out), "artifact.mender: OK")
+	}
+
+	// Checksums of other algorithms are left out.
+	err = Run([]string{"mender-artifact", "write", "module-image",
+		"-o", artfile, "-n", "testName", "-T", "testType", "-t", "testDevice",
+		"-f", updateFile, "--checksum-file", "--checksum-algorithm", "sha512"})
+	require.NoError(t, err)
+	data, err = os.ReadFile(artfile + ".sha256")
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+
+	err = write("-")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--checksum-file can not be used")
+}
+
+func TestWriteBufferMemory(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	write := func(args ...string) error {
+		return Run(append([]string{"mender-artifact", "write", "module-image",
+			"-o", filepath.Join(tmpdir, "artifact.mender"), "-n", "Name",
+			"-t", "TestDevice", "-T", "my-own-type",
+			"-f", filepath.Join(tmpdir, "file"),
+			"--temp-dir", filepath.Join(tmpdir, "missing")}, args...))
+	}
+
+	// The Artifact fits in memory, so no temporary files are needed.
+	require.NoError(t, write("--buffer-memory", "1048576"))
+	require.NoError(t, Run([]string{"mender-artifact", "validate",
+		filepath.Join(tmpdir, "artifact.mender")}))
+
+	err := write()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not create temporary file")
+
+	err = write("--buffer-memory", "-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--buffer-memory can not be negative")
+}
+
+func TestWriteReproducible(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	for _, script := range []string{"ArtifactInstall_Enter_00", "ArtifactCommit_Leave_10",
+		"ArtifactInstall_Leave_05", "ArtifactReboot_Enter_00"} {
+		makeFile(t, tmpdir, script, "#!/bin/sh\ntrue\n")
+	}
+	write := func(name string, args ...string) []byte {
+		artfile := filepath.Join(tmpdir, name)
+		require.NoError(t, Run(append(args, "write", "module-image",
+			"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+			"-f", filepath.Join(tmpdir, "file"),
+			"-s", filepath.Join(tmpdir, "ArtifactInstall_Enter_00"),
+			"-s", filepath.Join(tmpdir, "ArtifactCommit_Leave_10"),
+			"-s", filepath.Join(tmpdir, "ArtifactInstall_Leave_05"),
+			"-s", filepath.Join(tmpdir, "ArtifactReboot_Enter_00"),
+			"--reproducible")))
+		data, err := ioutil.ReadFile(artfile)
+		require.NoError(t, err)
+		return data
+	}
+	touch := func(mtime time.Time) {
+		for _, name := range []string{"file", "ArtifactInstall_Enter_00"} {
+			require.NoError(t, os.Chtimes(filepath.Join(tmpdir, name), mtime, mtime))
+		}
+	}
+
+	touch(time.Now().Add(-time.Hour))
+	first := write("first.mender", "mender-artifact")
+	touch(time.Now())
+	second := write("second.mender", "mender-artifact")
+	assert.Equal(t, first, second)
+
+	f, err := os.Open(filepath.Join(tmpdir, "first.mender"))
+	require.NoError(t, err)
+	defer f.Close()
+	hdr, err := tar.NewReader(f).Next()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), hdr.ModTime.Unix())
+
+	// The global --source-date-epoch gives the time.
+	third := write("third.mender", "mender-artifact", "--source-date-epoch", "1700000000")
+	assert.NotEqual(t, first, third)
+	assert.Equal(t, third, write("fourth.mender", "mender-artifact",
+		"--source-date-epoch", "1700000000"))
+}
+
+func TestWriteChecksumAlgorithm(t *testing.T) {
+	tmpdir := t.TempDir()
+	makeFile(t, tmpdir, "file", "payload")
+	artfile := filepath.Join(tmpdir, "artifact.mender")
+	write := func(algorithm string) error {
+		return Run([]string{"mender-artifact", "write", "module-image",
+			"-o", artfile, "-n", "Name", "-t", "TestDevice", "-T", "my-own-type",
+			"-f", filepath.Join(tmpdir, "file"), "--checksum-algorithm", algorithm})
+	}
+
+	err := write("md5")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unsupported checksum algorithm "md5"`)
+
+	for _, algorithm := range []string{"sha384", "sha512"} {
+		require.NoError(t, write(algorithm))
+		out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+			"--no-progress", artfile})
+		require.NoError(t, err)
+		assert.Contains(t, out, "Checksum algorithm: "+algorithm)
+		require.NoError(t, Run([]string{"mender-artifact", "validate",
+			"--no-progress", artfile}))
+
+		// Modifying the Artifact keeps the algorithm.
+		require.NoError(t, Run([]string{"mender-artifact", "modify",
+			"-n", "Modified", artfile}))
+		out, err = runAndCollectStdout([]string{"mender-artifact", "read",
+			"--no-progress", "--field", "checksum_algorithm", artfile})
+		require.NoError(t, err)
+		assert.Equal(t, algorithm, strings.TrimSpace(out))
+		require.NoError(t, Run([]string{"mender-artifact", "validate",
+			"--no-progress", artfile}))
+
+		out, err = runAndCollectStdout([]string{"mender-artifact", "dump",
+			"--print-cmdline", "--files", filepath.Join(tmpdir, "dump-"+algorithm),
+			artfile})
+		require.NoError(t, err)
+		assert.Contains(t, out, "--checksum-algorithm "+algorithm)
+	}
+
+	// sha256 is not recorded.
+	require.NoError(t, write("sha256"))
+	out, err := runAndCollectStdout([]string{"mender-artifact", "read",
+		"--no-progress", artfile})
+	require.NoError(t, err)
+	assert.NotContains(t, out, "Checksum algorithm")
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ext4
+
+import (
+	"github.com/pkg/errors"
+)
+
+// blockRun is a range of consecutive blocks.
+type blockRun struct {
+	start  uint64
+	length uint64
+}
+
+func testBit(bitmap []byte, i uint32) bool {
+	return bitmap[i/8]&(1<<(i%8)) != 0
+}
+
+func setBit(bitmap []byte, i uint32) {
+	bitmap[i/8] |= 1 << (i % 8)
+}
+
+func clearBit(bitmap []byte, i uint32) {
+	bitmap[i/8] &^= 1 << (i % 8)
+}
+
+// blockBitmap returns the block bitmap of the group, building it for
+// groups which are not initialized yet.
+func (fs *FS) blockBitmap(group uint32) ([]byte, error) {
+	if bitmap, ok := fs.blockBitmaps[group]; ok {
+		return bitmap, nil
+	}
+	var bitmap []byte
+	var err error
+	if fs.groupFlags(group)&bgBlockUninit != 0 {
+		bitmap = fs.initBlockBitmap(group)
+	} else {
+		bitmap, err = fs.readBlock(fs.gd32(group, gdBlockBitmap, gdBlockBitmapHi))
+		if err != nil {
+			return nil, err
+		}
+	}
+	fs.blockBitmaps[group] = bitmap
+	return bitmap, nil
+}
+
+// initBlockBitmap builds the bitmap of an uninitialized group, where only
+// the copy of the superblock and the group descriptors, and the bitmaps and
+// inode tables of any group stored in the group are in use.
+func (fs *FS) initBlockBitmap(group uint32) []byte {
+	bitmap := make([]byte, fs.blockSize)
+	first := fs.groupFirstBlock(group)
+	blocks := fs.groupBlocks(group)
+	if fs.hasSuper(group) {
+		used := 1 + uint64(fs.gdtBlocks()) + uint64(fs.sb16(sbReservedGdt))
+		for i := uint64(0); i < used && i < uint64(blocks); i++ {
+			setBit(bitmap, uint32(i))
+		}
+	}
+	mark := func(block uint64) {
+		if block >= first && block < first+uint64(blocks) {
+			setBit(bitmap, uint32(block-first))
+		}
+	}
+	for g := uint32(0); g < fs.groupCount; g++ {
+		mark(fs.gd32(g, gdBlockBitmap, gdBlockBitmapHi))
+		mark(fs.gd32(g, gdInodeBitmap, gdInodeBitmapHi))
+		table := fs.gd32(g, gdInodeTable, gdInodeTableHi)
+		for i := uint64(0); i < fs.inodeTableBlocks(); i++ {
+			mark(table + i)
+		}
+	}
+	for i := blocks; i < uint32(fs.blockSize*8); i++ {
+		setBit(bitmap, i)
+	}
+	return bitmap
+}
+
+// inodeBitmap returns the inode bitmap of the group, building it for
+// groups which are not initialized yet.
+func (fs *FS) inodeBitmap(group uint32) ([]byte, error) {
+	if bitmap, ok := fs.inodeBitmaps[group]; ok {
+		return bitmap, nil
+	}
+	var bitmap []byte
+	var err error
+	if fs.groupFlags(group)&bgInodeUninit != 0 {
+		bitmap = make([]byte, fs.blockSize)
+		for i := fs.inodesPerGroup; i < uint32(fs.blockSize*8); i++ {
+			setBit(bitmap, i)
+		}
+	} else {
+		bitmap, err = fs.readBlock(fs.gd32(group, gdInodeBitmap, gdInodeBitmapHi))
+		if err != nil {
+			return nil, err
+		}
+	}
+	fs.inodeBitmaps[group] = bitmap
+	return bitmap, nil
+}
+
+// allocBlocks allocates count blocks, as few runs as possible, starting the
+// search in the group of goal.
+func (fs *FS) allocBlocks(goal uint64, count uint64) ([]blockRun, error) {
+	if count > fs.freeBlocks() {
+		return nil, ErrNoSpace
+	}
+	var runs []blockRun
+	startGroup := uint32(0)
+	if goal >= uint64(fs.firstDataBlock) {
+		startGroup = uint32((goal - uint64(fs.firstDataBlock)) / uint64(fs.blocksPerGroup))
+	}
+	for n := uint32(0); n < fs.groupCount && count > 0; n++ {
+		group := (startGroup + n) % fs.groupCount
+		if fs.gd16(group, gdFreeBlocks, gdFreeBlocksHi) == 0 {
+			continue
+		}
+		bitmap, err := fs.blockBitmap(group)
+		if err != nil {
+			fs.freeRuns(runs)
+			return nil, err
+		}
+		allocated := uint32(0)
+		first := fs.groupFirstBlock(group)
+		for i := uint32(0); i < fs.groupBlocks(group) && count > 0; i++ {
+			if testBit(bitmap, i) {
+				continue
+			}
+			setBit(bitmap, i)
+			allocated++
+			count--
+			block := first + uint64(i)
+			if len(runs) > 0 && runs[len(runs)-1].start+runs[len(runs)-1].length == block {
+				runs[len(runs)-1].length++
+			} else {
+				runs = append(runs, blockRun{start: block, length: 1})
+			}
+		}
+		if allocated > 0 {
+			free := fs.gd16(group, gdFreeBlocks, gdFreeBlocksHi)
+			fs.setGd16(group, gdFreeBlocks, gdFreeBlocksHi, free-allocated)
+			fs.clearGroupFlag(group, bgBlockUninit)
+			fs.setFreeBlocks(fs.freeBlocks() - uint64(allocated))
+		}
+	}
+	if count > 0 {
+		fs.freeRuns(runs)
+		return nil, ErrNoSpace
+	}
+	return runs, nil
+}
+
+// freeBlockRange marks the blocks as free.
+func (fs *FS) freeBlockRange(start, length uint64) error {
+	for block := start; block < start+length; block++ {
+		if block < uint64(fs.firstDataBlock) || block >= fs.blocksCount() {
+			return errors.Errorf("ext4: freeing block %d out of range", block)
+		}
+		group := uint32((block - uint64(fs.firstDataBlock)) / uint64(fs.blocksPerGroup))
+		bitmap, err := fs.blockBitmap(group)
+		if err != nil {
+			return err
+		}
+		i := uint32(block - fs.groupFirstBlock(group))
+		if !testBit(bitmap, i) {
+			continue
+		}
+		clearBit(bitmap, i)
+		free := fs.gd16(group, gdFreeBlocks, gdFreeBlocksHi)
+		fs.setGd16(group, gdFreeBlocks, gdFreeBlocksHi, free+1)
+		fs.setFreeBlocks(fs.freeBlocks() + 1)
+	}
+	return nil
+}
+
+// freeRuns releases blocks allocated by an operation which failed.
+func (fs *FS) freeRuns(runs []blockRun) {
+	for _, run := range runs {
+		_ = fs.freeBlockRange(run.start, run.length)
+	}
+}
+
+// allocInode allocates an inode, preferably in the given group.
+func (fs *FS) allocInode(goalGroup uint32, dir bool) (uint32, error) {
+	if fs.sb32(sbFreeInodes) == 0 {
+		return 0, ErrNoSpace
+	}
+	firstIno := uint32(11)
+	if fs.sb32(sbRevLevel) > 0 {
+		firstIno = fs.sb32(sbFirstIno)
+	}
+	for n := uint32(0); n < fs.groupCount; n++ {
+		group := (goalGroup + n) % fs.groupCount
+		if fs.gd16(group, gdFreeInodes, gdFreeInodesHi) == 0 {
+			continue
+		}
+		bitmap, err := fs.inodeBitmap(group)
+		if err != nil {
+			return 0, err
+		}
+		for i := uint32(0); i < fs.inodesPerGroup; i++ {
+			ino := group*fs.inodesPerGroup + i + 1
+			if ino < firstIno || testBit(bitmap, i) {
+				continue
+			}
+			setBit(bitmap, i)
+			free := fs.gd16(group, gdFreeInodes, gdFreeInodesHi)
+			fs.setGd16(group, gdFreeInodes, gdFreeInodesHi, free-1)
+			if dir {
+				dirs := fs.gd16(group, gdUsedDirs, gdUsedDirsHi)
+				fs.setGd16(group, gdUsedDirs, gdUsedDirsHi, dirs+1)
+			}
+			if fs.hasGroupCsum() {
+				fs.clearGroupFlag(group, bgInodeUninit)
+				unused := fs.gd16(group, gdItableUnused, gdItableUnusedHi)
+				if i >= fs.inodesPerGroup-unused {
+					fs.setGd16(group, gdItableUnused, gdItableUnusedHi, fs.inodesPerGroup-i-1)
+				}
+			}
+			le.PutUint32(fs.sb[sbFreeInodes:], fs.sb32(sbFreeInodes)-1)
+			return ino, nil
+		}
+	}
+	return 0, ErrNoSpace
+}
+
+// freeInode marks the inode as free.
+func (fs *FS) freeInode(ino uint32, dir bool) error {
+	group := (ino - 1) / fs.inodesPerGroup
+	bitmap, err := fs.inodeBitmap(group)
+	if err != nil {
+		return err
+	}
+	i := (ino - 1) % fs.inodesPerGroup
+	if !testBit(bitmap, i) {
+		return nil
+	}
+	clearBit(bitmap, i)
+	free := fs.gd16(group, gdFreeInodes, gdFreeInodesHi)
+	fs.setGd16(group, gdFreeInodes, gdFreeInodesHi, free+1)
+	if dir {
+		dirs := fs.gd16(group, gdUsedDirs, gdUsedDirsHi)
+		fs.setGd16(group, gdUsedDirs, gdUsedDirsHi, dirs-1)
+	}
+	le.PutUint32(fs.sb[sbFreeInodes:], fs.sb32(sbFreeInodes)+1)
+	return nil
+}
@@ -0,0 +1,545 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ext4
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	direntHeaderLen = 8
+	direntTailLen   = 12
+	direntTailType  = 0xDE
+	maxNameLen      = 255
+)
+
+// Directory entry file types.
+const (
+	ftRegular = 1
+	ftDir     = 2
+)
+
+// dirent is an entry of a directory block.
+type dirent struct {
+	offset int
+	inode  uint32
+	recLen int
+	name   string
+}
+
+// dirBlock is a block of a directory.
+type dirBlock struct {
+	logical  uint32
+	physical uint64
+	data     []byte
+}
+
+func direntLen(nameLen int) int {
+	return (direntHeaderLen + nameLen + 3) &^ 3
+}
+
+func (fs *FS) recLen(b []byte) int {
+	n := int(le.Uint16(b[4:]))
+	if n == 0 || n == 65535 {
+		return int(fs.blockSize)
+	}
+	return n
+}
+
+func (fs *FS) setRecLen(b []byte, n int) {
+	if n == 65536 {
+		n = 65535
+	}
+	le.PutUint16(b[4:], uint16(n))
+}
+
+// usableLen is the part of directory blocks holding entries, before the
+// checksum tail.
+func (fs *FS) usableLen() int {
+	if fs.hasMetadataCsum() {
+		return int(fs.blockSize) - direntTailLen
+	}
+	return int(fs.blockSize)
+}
+
+// entries parses the entries of a directory block.
+func (fs *FS) entries(b []byte) ([]dirent, error) {
+	var entries []dirent
+	for off := 0; off < len(b); {
+		if off+direntHeaderLen > len(b) {
+			return nil, errors.New("ext4: invalid directory entry")
+		}
+		e := b[off:]
+		d := dirent{offset: off, inode: le.Uint32(e[0:]), recLen: fs.recLen(e)}
+		nameLen := int(e[6])
+		if !fs.hasIncompat(incompatFiletype) {
+			nameLen |= int(e[7]) << 8
+		}
+		if d.recLen < direntHeaderLen || off+d.recLen > len(b) ||
+			direntHeaderLen+nameLen > d.recLen {
+			return nil, errors.New("ext4: invalid directory entry")
+		}
+		d.name = string(e[direntHeaderLen : direntHeaderLen+nameLen])
+		entries = append(entries, d)
+		off += d.recLen
+	}
+	return entries, nil
+}
+
+// dirBlocks returns the blocks of the directory.
+func (fs *FS) dirBlocks(dir *inode) ([]dirBlock, error) {
+	extents, _, err := fs.mapping(dir)
+	if err != nil {
+		return nil, err
+	}
+	count := uint32(dir.size() / fs.blockSize)
+	blocks := make([]dirBlock, 0, count)
+	for logical := uint32(0); logical < count; logical++ {
+		block, err := fs.dirBlock(extents, logical)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (fs *FS) dirBlock(extents []extent, logical uint32) (dirBlock, error) {
+	phys, _ := physical(extents, logical)
+	if phys == 0 {
+		return dirBlock{}, errors.New("ext4: hole in directory")
+	}
+	data, err := fs.readBlock(phys)
+	return dirBlock{logical: logical, physical: phys, data: data}, err
+}
+
+func (fs *FS) writeDirBlock(dir *inode, block dirBlock) error {
+	if fs.hasMetadataCsum() {
+		tail := block.data[len(block.data)-direntTailLen:]
+		if le.Uint32(tail[0:]) != 0 || le.Uint16(tail[4:]) != direntTailLen ||
+			tail[7] != direntTailType {
+			return errors.Wrap(ErrUnsupported, "directory block without checksum")
+		}
+		le.PutUint32(tail[8:], crc32c(fs.inodeCsumSeed(dir), block.data[:len(block.data)-direntTailLen]))
+	}
+	return fs.writeBlock(block.physical, block.data)
+}
+
+// emptyDirBlock returns a directory block without entries.
+func (fs *FS) emptyDirBlock() []byte {
+	b := make([]byte, fs.blockSize)
+	fs.setRecLen(b, fs.usableLen())
+	if fs.hasMetadataCsum() {
+		tail := b[len(b)-direntTailLen:]
+		le.PutUint16(tail[4:], direntTailLen)
+		tail[7] = direntTailType
+	}
+	return b
+}
+
+func (fs *FS) putDirent(b []byte, ino uint32, recLen int, name string, fileType byte) {
+	le.PutUint32(b[0:], ino)
+	fs.setRecLen(b, recLen)
+	b[6] = byte(len(name))
+	b[7] = 0
+	if fs.hasIncompat(incompatFiletype) {
+		b[7] = fileType
+	}
+	copy(b[direntHeaderLen:], name)
+}
+
+// lookup finds the entry of name in the directory.
+func (fs *FS) lookup(dir *inode, name string) (dirBlock, dirent, bool, error) {
+	blocks, err := fs.dirBlocks(dir)
+	if err != nil {
+		return dirBlock{}, dirent{}, false, err
+	}
+	for _, block := range blocks {
+		entries, err := fs.entries(block.data)
+		if err != nil {
+			return dirBlock{}, dirent{}, false, err
+		}
+		for _, e := range entries {
+			if e.inode != 0 && e.name == name {
+				return block, e, true, nil
+			}
+		}
+	}
+	return dirBlock{}, dirent{}, false, nil
+}
+
+// slot is where a new directory entry fits.
+type slot struct {
+	block  dirBlock
+	offset int
+	// grow tells that the entry needs a new block at the end of the
+	// directory.
+	grow bool
+}
+
+// findSlot finds room for an entry of name in the directory, without
+// changing anything.
+func (fs *FS) findSlot(dir *inode, name string) (slot, error) {
+	need := direntLen(len(name))
+	if dir.flags()&flagIndex != 0 {
+		block, err := fs.dxLeaf(dir, name)
+		if err != nil {
+			return slot{}, err
+		}
+		if off, ok := fs.fit(block.data, need); ok {
+			return slot{block: block, offset: off}, nil
+		}
+		return slot{}, errors.Wrap(ErrUnsupported, "splitting hashed directory blocks")
+	}
+	blocks, err := fs.dirBlocks(dir)
+	if err != nil {
+		return slot{}, err
+	}
+	for _, block := range blocks {
+		if off, ok := fs.fit(block.data, need); ok {
+			return slot{block: block, offset: off}, nil
+		}
+	}
+	if dir.flags()&flagExtents == 0 {
+		return slot{}, errors.Wrap(ErrUnsupported, "growing directories without extents")
+	}
+	return slot{grow: true}, nil
+}
+
+// fit finds the offset of the entry which has room for need more bytes.
+func (fs *FS) fit(b []byte, need int) (int, bool) {
+	entries, err := fs.entries(b[:fs.usableLen()])
+	if err != nil {
+		return 0, false
+	}
+	for _, e := range entries {
+		used := 0
+		if e.inode != 0 {
+			used = direntLen(len(e.name))
+		}
+		if e.recLen-used >= need {
+			return e.offset, true
+		}
+	}
+	return 0, false
+}
+
+// link adds an entry for ino to the directory, in the slot found before.
+func (fs *FS) link(dir *inode, s slot, name string, ino uint32, fileType byte) error {
+	if s.grow {
+		return fs.linkInNewBlock(dir, name, ino, fileType)
+	}
+	b := s.block.data
+	e := b[s.offset:]
+	recLen := fs.recLen(e)
+	if le.Uint32(e[0:]) != 0 {
+		used := direntLen(int(e[6]))
+		fs.setRecLen(e, used)
+		e = e[used:]
+		recLen -= used
+	}
+	fs.putDirent(e, ino, recLen, name, fileType)
+	return fs.writeDirBlock(dir, s.block)
+}
+
+func (fs *FS) linkInNewBlock(dir *inode, name string, ino uint32, fileType byte) error {
+	extents, meta, err := fs.mapping(dir)
+	if err != nil {
+		return err
+	}
+	var goal uint64
+	if n := len(extents); n > 0 {
+		goal = extents[n-1].start + uint64(extents[n-1].length)
+	}
+	runs, err := fs.allocBlocks(goal, 1)
+	if err != nil {
+		return err
+	}
+	block := dirBlock{
+		logical:  uint32(dir.size() / fs.blockSize),
+		physical: runs[0].start,
+		data:     fs.emptyDirBlock(),
+	}
+	fs.putDirent(block.data, ino, fs.usableLen(), name, fileType)
+	if err = fs.writeDirBlock(dir, block); err != nil {
+		fs.freeRuns(runs)
+		return err
+	}
+
+	n := len(extents)
+	if n > 0 && extents[n-1].start+uint64(extents[n-1].length) == block.physical &&
+		extents[n-1].logical+extents[n-1].length == block.logical &&
+		extents[n-1].length < maxExtentLength && !extents[n-1].uninit {
+		extents[n-1].length++
+	} else {
+		extents = append(extents, extent{logical: block.logical, start: block.physical, length: 1})
+	}
+	tree, err := fs.buildExtentTree(dir, extents)
+	if err != nil {
+		fs.freeRuns(runs)
+		return err
+	}
+	fs.freeBlockList(meta)
+
+	dir.setSize(dir.size() + fs.blockSize)
+	fs.setBlocks(dir, fs.sectors(dir)/uint64(fs.blockSize/512)+1+uint64(len(tree))-uint64(len(meta)))
+	return fs.writeInode(dir)
+}
+
+// unlink removes the entry of name from the directory block.
+func (fs *FS) unlink(dir *inode, block dirBlock, e dirent) error {
+	entries, err := fs.entries(block.data[:fs.usableLen()])
+	if err != nil {
+		return err
+	}
+	var prev *dirent
+	for i := range entries {
+		if entries[i].offset == e.offset {
+			break
+		}
+		prev = &entries[i]
+	}
+	if prev == nil {
+		le.PutUint32(block.data[e.offset:], 0)
+	} else {
+		fs.setRecLen(block.data[prev.offset:], prev.recLen+e.recLen)
+	}
+	return fs.writeDirBlock(dir, block)
+}
+
+// setEntryInode points the entry to another inode.
+func (fs *FS) setEntryInode(dir *inode, block dirBlock, e dirent, ino uint32, fileType byte) error {
+	b := block.data[e.offset:]
+	le.PutUint32(b[0:], ino)
+	if fs.hasIncompat(incompatFiletype) {
+		b[7] = fileType
+	}
+	return fs.writeDirBlock(dir, block)
+}
+
+// dxLeaf returns the leaf block of a hashed directory which holds name.
+func (fs *FS) dxLeaf(dir *inode, name string) (dirBlock, error) {
+	extents, _, err := fs.mapping(dir)
+	if err != nil {
+		return dirBlock{}, err
+	}
+	root, err := fs.dirBlock(extents, 0)
+	if err != nil {
+		return dirBlock{}, err
+	}
+	info := root.data[0x18:]
+	version := info[4]
+	levels := int(info[6])
+	maxLevels := 2
+	if fs.hasIncompat(incompatLargeDir) {
+		maxLevels = 3
+	}
+	if info[5] != 8 || levels > maxLevels {
+		return dirBlock{}, errors.New("ext4: invalid hashed directory")
+	}
+	if version <= dxHashTea && fs.sb32(sbFlags)&sbFlagUnsignedHash != 0 {
+		version += dxHashUnsignedOffset
+	}
+	hash, err := fs.nameHash(name, version)
+	if err != nil {
+		return dirBlock{}, err
+	}
+
+	node := root.data[0x18+8:]
+	for level := 0; ; level++ {
+		limit := int(le.Uint16(node[0:]))
+		count := int(le.Uint16(node[2:]))
+		if count == 0 || count > limit || 8*count > len(node) {
+			return dirBlock{}, errors.New("ext4: invalid hashed directory")
+		}
+		// The first entry covers all hashes below the second one.
+		next := le.Uint32(node[4:])
+		for i := 1; i < count; i++ {
+			if le.Uint32(node[8*i:]) > hash {
+				break
+			}
+			next = le.Uint32(node[8*i+4:])
+		}
+		block, err := fs.dirBlock(extents, next)
+		if err != nil || level == levels {
+			return block, err
+		}
+		node = block.data[8:]
+	}
+}
+
+// Hash versions of hashed directories.
+const (
+	dxHashLegacy         = 0
+	dxHashHalfMD4        = 1
+	dxHashTea            = 2
+	dxHashUnsignedOffset = 3
+)
+
+func (fs *FS) nameHash(name string, version byte) (uint32, error) {
+	signed := version < dxHashUnsignedOffset
+	if !signed {
+		version -= dxHashUnsignedOffset
+	}
+	buf := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	var seed [4]uint32
+	zero := true
+	for i := range seed {
+		seed[i] = fs.sb32(sbHashSeed + 4*i)
+		zero = zero && seed[i] == 0
+	}
+	if !zero {
+		buf = seed
+	}
+
+	var hash uint32
+	p := []byte(name)
+	switch version {
+	case dxHashLegacy:
+		hash = legacyHash(p, signed)
+	case dxHashHalfMD4:
+		var in [8]uint32
+		for len(p) > 0 {
+			str2hashbuf(p, in[:], signed)
+			halfMD4Transform(&buf, &in)
+			if len(p) <= 32 {
+				break
+			}
+			p = p[32:]
+		}
+		hash = buf[1]
+	case dxHashTea:
+		var in [4]uint32
+		for len(p) > 0 {
+			str2hashbuf(p, in[:], signed)
+			teaTransform(&buf, &in)
+			if len(p) <= 16 {
+				break
+			}
+			p = p[16:]
+		}
+		hash = buf[0]
+	default:
+		return 0, errors.Wrapf(ErrUnsupported, "directory hash version %d", version)
+	}
+	hash &^= 1
+	if hash == 0x7fffffff<<1 {
+		hash = (0x7fffffff - 1) << 1
+	}
+	return hash, nil
+}
+
+// char returns a byte of a name the way C reads a signed or unsigned char.
+func char(b byte, signed bool) uint32 {
+	if signed {
+		return uint32(int32(int8(b)))
+	}
+	return uint32(b)
+}
+
+func legacyHash(p []byte, signed bool) uint32 {
+	hash0, hash1 := uint32(0x12a3fe2d), uint32(0x37abe8f9)
+	for _, b := range p {
+		hash := hash1 + (hash0 ^ char(b, signed)*7152373)
+		if hash&0x80000000 != 0 {
+			hash -= 0x7fffffff
+		}
+		hash1, hash0 = hash0, hash
+	}
+	return hash0 << 1
+}
+
+func str2hashbuf(p []byte, out []uint32, signed bool) {
+	length := uint32(len(p))
+	pad := length | length<<8
+	pad |= pad << 16
+	val := pad
+	if len(p) > 4*len(out) {
+		p = p[:4*len(out)]
+	}
+	n := 0
+	for i, b := range p {
+		val = char(b, signed) + val<<8
+		if i%4 == 3 {
+			out[n] = val
+			n++
+			val = pad
+		}
+	}
+	if n < len(out) {
+		out[n] = val
+		n++
+	}
+	for ; n < len(out); n++ {
+		out[n] = pad
+	}
+}
+
+func rol32(x uint32, s uint) uint32 {
+	return x<<s | x>>(32-s)
+}
+
+func halfMD4Transform(buf *[4]uint32, in *[8]uint32) {
+	const k2, k3 = 013240474631, 015666365641
+	f := func(x, y, z uint32) uint32 { return z ^ (x & (y ^ z)) }
+	g := func(x, y, z uint32) uint32 { return (x & y) + ((x ^ y) & z) }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	a, b, c, d := buf[0], buf[1], buf[2], buf[3]
+
+	a = rol32(a+f(b, c, d)+in[0], 3)
+	d = rol32(d+f(a, b, c)+in[1], 7)
+	c = rol32(c+f(d, a, b)+in[2], 11)
+	b = rol32(b+f(c, d, a)+in[3], 19)
+	a = rol32(a+f(b, c, d)+in[4], 3)
+	d = rol32(d+f(a, b, c)+in[5], 7)
+	c = rol32(c+f(d, a, b)+in[6], 11)
+	b = rol32(b+f(c, d, a)+in[7], 19)
+
+	a = rol32(a+g(b, c, d)+in[1]+k2, 3)
+	d = rol32(d+g(a, b, c)+in[3]+k2, 5)
+	c = rol32(c+g(d, a, b)+in[5]+k2, 9)
+	b = rol32(b+g(c, d, a)+in[7]+k2, 13)
+	a = rol32(a+g(b, c, d)+in[0]+k2, 3)
+	d = rol32(d+g(a, b, c)+in[2]+k2, 5)
+	c = rol32(c+g(d, a, b)+in[4]+k2, 9)
+	b = rol32(b+g(c, d, a)+in[6]+k2, 13)
+
+	a = rol32(a+h(b, c, d)+in[3]+k3, 3)
+	d = rol32(d+h(a, b, c)+in[7]+k3, 9)
+	c = rol32(c+h(d, a, b)+in[2]+k3, 11)
+	b = rol32(b+h(c, d, a)+in[6]+k3, 15)
+	a = rol32(a+h(b, c, d)+in[1]+k3, 3)
+	d = rol32(d+h(a, b, c)+in[5]+k3, 9)
+	c = rol32(c+h(d, a, b)+in[0]+k3, 11)
+	b = rol32(b+h(c, d, a)+in[4]+k3, 15)
+
+	buf[0] += a
+	buf[1] += b
+	buf[2] += c
+	buf[3] += d
+}
+
+func teaTransform(buf *[4]uint32, in *[4]uint32) {
+	var sum uint32
+	b0, b1 := buf[0], buf[1]
+	a, b, c, d := in[0], in[1], in[2], in[3]
+	for n := 0; n < 16; n++ {
+		sum += 0x9E3779B9
+		b0 += ((b1 << 4) + a) ^ (b1 + sum) ^ ((b1 >> 5) + b)
+		b1 += ((b0 << 4) + c) ^ (b0 + sum) ^ ((b0 >> 5) + d)
+	}
+	buf[0] += b0
+	buf[1] += b1
+}
@@ -0,0 +1,526 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package ext4 reads and modifies ext2, ext3 and ext4 file system images
+// in-process, so that files can be copied into and out of images without
+// debugfs.
+//
+// Only the common feature set is supported: extent mapped files, linear and
+// hashed directories, flexible block groups, 64 bit block numbers and
+// metadata checksums. Images using other features, like inline data or meta
+// block groups, are refused with ErrUnsupported, and so are changes which
+// would need a hashed directory to be split. The journal is left alone, so
+// images must be cleanly unmounted.
+package ext4
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnsupported is returned for file systems and operations the package
+	// can not handle. The file system is left unmodified.
+	ErrUnsupported = errors.New("ext4: unsupported")
+
+	ErrNotDir   = errors.New("not a directory")
+	ErrIsDir    = errors.New("is a directory")
+	ErrNotEmpty = errors.New("directory not empty")
+	ErrNoSpace  = errors.New("no space left on file system")
+)
+
+var le = binary.LittleEndian
+
+const (
+	superblockOffset = 1024
+	superblockSize   = 1024
+	superblockMagic  = 0xEF53
+
+	rootInode = 2
+)
+
+// Offsets of the superblock fields in use.
+const (
+	sbInodesCount      = 0x0
+	sbBlocksCountLo    = 0x4
+	sbFreeBlocksLo     = 0xC
+	sbFreeInodes       = 0x10
+	sbFirstDataBlock   = 0x14
+	sbLogBlockSize     = 0x18
+	sbBlocksPerGroup   = 0x20
+	sbInodesPerGroup   = 0x28
+	sbMagic            = 0x38
+	sbRevLevel         = 0x4C
+	sbFirstIno         = 0x54
+	sbInodeSize        = 0x58
+	sbFeatureCompat    = 0x5C
+	sbFeatureIncompat  = 0x60
+	sbFeatureRoCompat  = 0x64
+	sbUUID             = 0x68
+	sbReservedGdt      = 0xCE
+	sbHashSeed         = 0xEC
+	sbDescSize         = 0xFE
+	sbBlocksCountHi    = 0x150
+	sbFreeBlocksHi     = 0x158
+	sbWantExtraIsize   = 0x15E
+	sbFlags            = 0x160
+	sbBackupBgs        = 0x24C
+	sbChecksumSeed     = 0x270
+	sbChecksum         = 0x3FC
+	sbFlagUnsignedHash = 0x2
+)
+
+const (
+	compatSparseSuper2 = 0x200
+
+	incompatFiletype  = 0x2
+	incompatRecover   = 0x4
+	incompatExtents   = 0x40
+	incompat64Bit     = 0x80
+	incompatFlexBG    = 0x200
+	incompatCsumSeed  = 0x2000
+	incompatLargeDir  = 0x4000
+	supportedIncompat = incompatFiletype | incompatExtents | incompat64Bit |
+		incompatFlexBG | incompatCsumSeed | incompatLargeDir
+
+	roCompatSparseSuper  = 0x1
+	roCompatLargeFile    = 0x2
+	roCompatHugeFile     = 0x8
+	roCompatGdtCsum      = 0x10
+	roCompatDirNlink     = 0x20
+	roCompatExtraIsize   = 0x40
+	roCompatMetadataCsum = 0x400
+	supportedRoCompat    = roCompatSparseSuper | roCompatLargeFile | roCompatHugeFile |
+		roCompatGdtCsum | roCompatDirNlink | roCompatExtraIsize | roCompatMetadataCsum
+)
+
+// Offsets of the group descriptor fields in use, as pairs of the low and
+// high part. The high parts only exist with 64 byte descriptors.
+const (
+	gdBlockBitmap     = 0x0
+	gdBlockBitmapHi   = 0x20
+	gdInodeBitmap     = 0x4
+	gdInodeBitmapHi   = 0x24
+	gdInodeTable      = 0x8
+	gdInodeTableHi    = 0x28
+	gdFreeBlocks      = 0xC
+	gdFreeBlocksHi    = 0x2C
+	gdFreeInodes      = 0xE
+	gdFreeInodesHi    = 0x2E
+	gdUsedDirs        = 0x10
+	gdUsedDirsHi      = 0x30
+	gdFlags           = 0x12
+	gdBlockBitmapCsum = 0x18
+	gdBlockCsumHi     = 0x38
+	gdInodeBitmapCsum = 0x1A
+	gdInodeCsumHi     = 0x3A
+	gdItableUnused    = 0x1C
+	gdItableUnusedHi  = 0x32
+	gdChecksum        = 0x1E
+
+	bgInodeUninit = 0x1
+	bgBlockUninit = 0x2
+)
+
+// FS is an ext file system image opened for reading, or for reading and
+// writing.
+type FS struct {
+	f        *os.File
+	writable bool
+
+	sb             []byte
+	gdt            []byte
+	blockSize      int64
+	descSize       int
+	inodeSize      int
+	groupCount     uint32
+	blocksPerGroup uint32
+	inodesPerGroup uint32
+	firstDataBlock uint32
+	csumSeed       uint32
+
+	blockBitmaps map[uint32][]byte
+	inodeBitmaps map[uint32][]byte
+	dirtyGroups  map[uint32]bool
+}
+
+// Probe tells whether the file at name holds an ext file system.
+func Probe(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [2]byte
+	if _, err = f.ReadAt(magic[:], superblockOffset+sbMagic); err != nil {
+		return false
+	}
+	return le.Uint16(magic[:]) == superblockMagic
+}
+
+// Open opens the file system image at name. flag is os.O_RDONLY or
+// os.O_RDWR.
+func Open(name string, flag int) (*FS, error) {
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	f, err := os.OpenFile(name, flag&(os.O_WRONLY|os.O_RDWR), 0)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FS{
+		f:            f,
+		writable:     writable,
+		blockBitmaps: map[uint32][]byte{},
+		inodeBitmaps: map[uint32][]byte{},
+		dirtyGroups:  map[uint32]bool{},
+	}
+	if err = fs.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FS) load() error {
+	fs.sb = make([]byte, superblockSize)
+	if _, err := fs.f.ReadAt(fs.sb, superblockOffset); err != nil {
+		return errors.Wrap(err, "ext4: can not read superblock")
+	}
+	if le.Uint16(fs.sb[sbMagic:]) != superblockMagic {
+		return errors.New("ext4: not an ext file system")
+	}
+
+	incompat := fs.sb32(sbFeatureIncompat)
+	if incompat&incompatRecover != 0 {
+		return errors.Wrap(ErrUnsupported, "the journal needs to be recovered")
+	}
+	if unknown := incompat &^ supportedIncompat; unknown != 0 {
+		return errors.Wrapf(ErrUnsupported, "incompatible features %#x", unknown)
+	}
+	if fs.writable {
+		if unknown := fs.sb32(sbFeatureRoCompat) &^ supportedRoCompat; unknown != 0 {
+			return errors.Wrapf(ErrUnsupported, "read-only compatible features %#x", unknown)
+		}
+		if !fs.hasIncompat(incompatExtents) {
+			return errors.Wrap(ErrUnsupported, "writing without extents")
+		}
+	}
+
+	fs.blockSize = 1024 << fs.sb32(sbLogBlockSize)
+	fs.blocksPerGroup = fs.sb32(sbBlocksPerGroup)
+	fs.inodesPerGroup = fs.sb32(sbInodesPerGroup)
+	fs.firstDataBlock = fs.sb32(sbFirstDataBlock)
+	fs.inodeSize = 128
+	if fs.sb32(sbRevLevel) > 0 {
+		fs.inodeSize = int(fs.sb16(sbInodeSize))
+	}
+	fs.descSize = 32
+	if fs.hasIncompat(incompat64Bit) {
+		fs.descSize = int(fs.sb16(sbDescSize))
+	}
+	if fs.blockSize > 65536 || fs.blocksPerGroup == 0 || fs.inodesPerGroup == 0 ||
+		fs.descSize < 32 || fs.inodeSize < 128 || int64(fs.inodeSize) > fs.blockSize {
+		return errors.New("ext4: invalid superblock")
+	}
+	fs.groupCount = uint32((fs.blocksCount() - uint64(fs.firstDataBlock) +
+		uint64(fs.blocksPerGroup) - 1) / uint64(fs.blocksPerGroup))
+
+	if fs.hasIncompat(incompatCsumSeed) {
+		fs.csumSeed = fs.sb32(sbChecksumSeed)
+	} else {
+		fs.csumSeed = crc32c(^uint32(0), fs.sb[sbUUID:sbUUID+16])
+	}
+
+	fs.gdt = make([]byte, fs.gdtBlocks()*fs.blockSize)
+	gdtOffset := (int64(fs.firstDataBlock) + 1) * fs.blockSize
+	if _, err := fs.f.ReadAt(fs.gdt, gdtOffset); err != nil {
+		return errors.Wrap(err, "ext4: can not read group descriptors")
+	}
+	return nil
+}
+
+// Close writes back any pending changes and closes the image.
+func (fs *FS) Close() error {
+	err := fs.sync()
+	if closeErr := fs.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (fs *FS) sb16(off int) uint16 {
+	return le.Uint16(fs.sb[off:])
+}
+
+func (fs *FS) sb32(off int) uint32 {
+	return le.Uint32(fs.sb[off:])
+}
+
+func (fs *FS) hasIncompat(feature uint32) bool {
+	return fs.sb32(sbFeatureIncompat)&feature != 0
+}
+
+func (fs *FS) hasRoCompat(feature uint32) bool {
+	return fs.sb32(sbFeatureRoCompat)&feature != 0
+}
+
+func (fs *FS) hasMetadataCsum() bool {
+	return fs.hasRoCompat(roCompatMetadataCsum)
+}
+
+// hasGroupCsum tells whether group descriptors are checksummed, which is
+// what allows uninitialized groups.
+func (fs *FS) hasGroupCsum() bool {
+	return fs.hasRoCompat(roCompatGdtCsum | roCompatMetadataCsum)
+}
+
+func (fs *FS) blocksCount() uint64 {
+	n := uint64(fs.sb32(sbBlocksCountLo))
+	if fs.hasIncompat(incompat64Bit) {
+		n |= uint64(fs.sb32(sbBlocksCountHi)) << 32
+	}
+	return n
+}
+
+func (fs *FS) freeBlocks() uint64 {
+	n := uint64(fs.sb32(sbFreeBlocksLo))
+	if fs.hasIncompat(incompat64Bit) {
+		n |= uint64(fs.sb32(sbFreeBlocksHi)) << 32
+	}
+	return n
+}
+
+func (fs *FS) setFreeBlocks(n uint64) {
+	le.PutUint32(fs.sb[sbFreeBlocksLo:], uint32(n))
+	if fs.hasIncompat(incompat64Bit) {
+		le.PutUint32(fs.sb[sbFreeBlocksHi:], uint32(n>>32))
+	}
+}
+
+func (fs *FS) gdtBlocks() int64 {
+	size := int64(fs.groupCount) * int64(fs.descSize)
+	return (size + fs.blockSize - 1) / fs.blockSize
+}
+
+func (fs *FS) inodeTableBlocks() uint64 {
+	size := uint64(fs.inodesPerGroup) * uint64(fs.inodeSize)
+	return (size + uint64(fs.blockSize) - 1) / uint64(fs.blockSize)
+}
+
+func (fs *FS) groupFirstBlock(group uint32) uint64 {
+	return uint64(fs.firstDataBlock) + uint64(group)*uint64(fs.blocksPerGroup)
+}
+
+// groupBlocks returns the number of blocks in the group, which is less than
+// the blocks per group for the last one.
+func (fs *FS) groupBlocks(group uint32) uint32 {
+	if group == fs.groupCount-1 {
+		return uint32(fs.blocksCount() - fs.groupFirstBlock(group))
+	}
+	return fs.blocksPerGroup
+}
+
+// hasSuper tells whether the group holds a copy of the superblock and the
+// group descriptors.
+func (fs *FS) hasSuper(group uint32) bool {
+	if group == 0 {
+		return true
+	}
+	if fs.sb32(sbFeatureCompat)&compatSparseSuper2 != 0 {
+		return group == fs.sb32(sbBackupBgs) || group == fs.sb32(sbBackupBgs+4)
+	}
+	if !fs.hasRoCompat(roCompatSparseSuper) || group == 1 {
+		return true
+	}
+	for _, base := range []uint32{3, 5, 7} {
+		n := base
+		for n < group {
+			n *= base
+		}
+		if n == group {
+			return true
+		}
+	}
+	return false
+}
+
+func (fs *FS) gd(group uint32) []byte {
+	return fs.gdt[int(group)*fs.descSize : int(group+1)*fs.descSize]
+}
+
+// gd32 returns a 32 bit group descriptor field combined with its high part.
+func (fs *FS) gd32(group uint32, lo, hi int) uint64 {
+	gd := fs.gd(group)
+	n := uint64(le.Uint32(gd[lo:]))
+	if fs.descSize >= 64 {
+		n |= uint64(le.Uint32(gd[hi:])) << 32
+	}
+	return n
+}
+
+// gd16 returns a 16 bit group descriptor field combined with its high part.
+func (fs *FS) gd16(group uint32, lo, hi int) uint32 {
+	gd := fs.gd(group)
+	n := uint32(le.Uint16(gd[lo:]))
+	if fs.descSize >= 64 {
+		n |= uint32(le.Uint16(gd[hi:])) << 16
+	}
+	return n
+}
+
+func (fs *FS) setGd16(group uint32, lo, hi int, n uint32) {
+	gd := fs.gd(group)
+	le.PutUint16(gd[lo:], uint16(n))
+	if fs.descSize >= 64 {
+		le.PutUint16(gd[hi:], uint16(n>>16))
+	}
+	fs.dirtyGroups[group] = true
+}
+
+func (fs *FS) groupFlags(group uint32) uint16 {
+	if !fs.hasGroupCsum() {
+		return 0
+	}
+	return le.Uint16(fs.gd(group)[gdFlags:])
+}
+
+func (fs *FS) clearGroupFlag(group uint32, flag uint16) {
+	gd := fs.gd(group)
+	le.PutUint16(gd[gdFlags:], le.Uint16(gd[gdFlags:])&^flag)
+	fs.dirtyGroups[group] = true
+}
+
+func (fs *FS) groupDescChecksum(group uint32) uint16 {
+	gd := append([]byte{}, fs.gd(group)...)
+	le.PutUint16(gd[gdChecksum:], 0)
+	var num [4]byte
+	le.PutUint32(num[:], group)
+	if fs.hasMetadataCsum() {
+		return uint16(crc32c(crc32c(fs.csumSeed, num[:]), gd))
+	}
+	crc := crc16(0xFFFF, fs.sb[sbUUID:sbUUID+16])
+	crc = crc16(crc, num[:])
+	if !fs.hasIncompat(incompat64Bit) {
+		gd = gd[:32]
+	}
+	return crc16(crc, gd)
+}
+
+func (fs *FS) readAt(p []byte, block uint64) error {
+	_, err := fs.f.ReadAt(p, int64(block)*fs.blockSize)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return errors.Wrapf(err, "ext4: can not read block %d", block)
+}
+
+func (fs *FS) readBlock(block uint64) ([]byte, error) {
+	if block >= fs.blocksCount() {
+		return nil, errors.Errorf("ext4: block %d out of range", block)
+	}
+	b := make([]byte, fs.blockSize)
+	return b, fs.readAt(b, block)
+}
+
+func (fs *FS) writeBlock(block uint64, b []byte) error {
+	if block < uint64(fs.firstDataBlock)+1 || block >= fs.blocksCount() {
+		return errors.Errorf("ext4: refusing to write block %d", block)
+	}
+	_, err := fs.f.WriteAt(b, int64(block)*fs.blockSize)
+	return errors.Wrapf(err, "ext4: can not write block %d", block)
+}
+
+// sync writes the modified bitmaps, group descriptors and the superblock
+// back to the image.
+func (fs *FS) sync() error {
+	if !fs.writable || len(fs.dirtyGroups) == 0 {
+		return nil
+	}
+	for group := range fs.dirtyGroups {
+		gd := fs.gd(group)
+		if bitmap, ok := fs.blockBitmaps[group]; ok {
+			if err := fs.writeBlock(fs.gd32(group, gdBlockBitmap, gdBlockBitmapHi), bitmap); err != nil {
+				return err
+			}
+			if fs.hasMetadataCsum() {
+				csum := crc32c(fs.csumSeed, bitmap[:fs.blocksPerGroup/8])
+				le.PutUint16(gd[gdBlockBitmapCsum:], uint16(csum))
+				if fs.descSize >= 64 {
+					le.PutUint16(gd[gdBlockCsumHi:], uint16(csum>>16))
+				}
+			}
+		}
+		if bitmap, ok := fs.inodeBitmaps[group]; ok {
+			if err := fs.writeBlock(fs.gd32(group, gdInodeBitmap, gdInodeBitmapHi), bitmap); err != nil {
+				return err
+			}
+			if fs.hasMetadataCsum() {
+				csum := crc32c(fs.csumSeed, bitmap[:fs.inodesPerGroup/8])
+				le.PutUint16(gd[gdInodeBitmapCsum:], uint16(csum))
+				if fs.descSize >= 64 {
+					le.PutUint16(gd[gdInodeCsumHi:], uint16(csum>>16))
+				}
+			}
+		}
+		if fs.hasGroupCsum() {
+			le.PutUint16(gd[gdChecksum:], fs.groupDescChecksum(group))
+		}
+	}
+	gdtOffset := (int64(fs.firstDataBlock) + 1) * fs.blockSize
+	if _, err := fs.f.WriteAt(fs.gdt, gdtOffset); err != nil {
+		return errors.Wrap(err, "ext4: can not write group descriptors")
+	}
+	if fs.hasMetadataCsum() {
+		le.PutUint32(fs.sb[sbChecksum:], crc32c(^uint32(0), fs.sb[:sbChecksum]))
+	}
+	if _, err := fs.f.WriteAt(fs.sb, superblockOffset); err != nil {
+		return errors.Wrap(err, "ext4: can not write superblock")
+	}
+	fs.dirtyGroups = map[uint32]bool{}
+	return nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32c continues the checksum crc the way ext4 does, without the final
+// inversion.
+func crc32c(crc uint32, p []byte) uint32 {
+	return ^crc32.Update(^crc, castagnoli, p)
+}
+
+var crc16Table = func() (table [256]uint16) {
+	for i := range table {
+		crc := uint16(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crc16 is the checksum of group descriptors without metadata checksums.
+func crc16(crc uint16, p []byte) uint16 {
+	for _, b := range p {
+		crc = crc>>8 ^ crc16Table[byte(crc)^b]
+	}
+	return crc
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ext4
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mendersoftware/mender-artifact/utils"
+)
+
+func requireBinary(t *testing.T, name string) string {
+	bin, err := utils.GetBinaryPath(name)
+	if err != nil {
+		t.Skipf("%s not found: %v", name, err)
+	}
+	return bin
+}
+
+// makeImage creates an image populated with a few files, using mkfs.ext4
+// with the given options, except for a hash_alg= option which is given to
+// tune2fs. Directories are indexed by e2fsck afterwards.
+func makeImage(t *testing.T, options ...string) (string, []byte) {
+	mkfs := requireBinary(t, "mkfs.ext4")
+	fsck := requireBinary(t, "e2fsck")
+	var hashAlg string
+	if n := len(options); n > 0 && strings.HasPrefix(options[n-1], "hash_alg=") {
+		hashAlg = options[n-1]
+		options = options[:n-1]
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "etc", "many"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "data", "sub"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("device\n"), 0644))
+	big := make([]byte, 3*1024*1024+123)
+	rand.New(rand.NewSource(1)).Read(big)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "data", "big.bin"), big, 0600))
+	for i := 0; i < 300; i++ {
+		name := filepath.Join(src, "etc", "many", fmt.Sprintf("file-with-a-long-name-%03d", i))
+		require.NoError(t, os.WriteFile(name, []byte(name), 0644))
+	}
+	require.NoError(t, os.Symlink("/etc/hostname", filepath.Join(src, "link")))
+	require.NoError(t, os.Symlink(strings.Repeat("long/", 20)+"target",
+		filepath.Join(src, "data", "longlink")))
+	require.NoError(t, os.Symlink("/data", filepath.Join(src, "etc", "datadir")))
+
+	image := filepath.Join(dir, "image.ext4")
+	args := append([]string{"-q", "-F", "-d", src}, options...)
+	args = append(args, image, "16M")
+	out, err := exec.Command(mkfs, args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	if hashAlg != "" {
+		out, err = exec.Command(requireBinary(t, "tune2fs"), "-E", hashAlg, image).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	// Exit code 1 means that directories were optimized.
+	out, err = exec.Command(fsck, "-fyD", image).CombinedOutput()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		require.True(t, ok && exitErr.ExitCode() == 1, string(out))
+	}
+	return image, big
+}
+
+func checkImage(t *testing.T, image string) {
+	fsck := requireBinary(t, "e2fsck")
+	out, err := exec.Command(fsck, "-fn", image).CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+var imageOptions = map[string][]string{
+	"default":       {},
+	"1k-no-csum":    {"-b", "1024", "-I", "128", "-O", "^metadata_csum"},
+	"gdt-csum":      {"-O", "^metadata_csum,uninit_bg", "-T", "small"},
+	"no-flex-bg":    {"-O", "^flex_bg,^has_journal", "-g", "1024", "-b", "1024"},
+	"2k-csum-seed":  {"-b", "2048", "-O", "metadata_csum_seed"},
+	"small-inodes":  {"-N", "600"},
+	"sparse-super2": {"-O", "sparse_super2"},
+	"tea-hash":      {"hash_alg=tea"},
+	"legacy-hash":   {"-b", "1024", "hash_alg=legacy"},
+}
+
+func TestRead(t *testing.T) {
+	for name, options := range imageOptions {
+		t.Run(name, func(t *testing.T) {
+			image, big := makeImage(t, options...)
+			fs, err := Open(image, os.O_RDONLY)
+			require.NoError(t, err)
+			defer fs.Close()
+
+			data, err := fs.ReadFile("/etc/hostname")
+			require.NoError(t, err)
+			assert.Equal(t, "device\n", string(data))
+			data, err = fs.ReadFile("/data/big.bin")
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(big, data))
+
+			// Symlinks are followed, also in the middle of paths.
+			data, err = fs.ReadFile("/link")
+			require.NoError(t, err)
+			assert.Equal(t, "device\n", string(data))
+			info, err := fs.Stat("/etc/datadir/big.bin")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(big)), info.Size())
+			assert.Equal(t, os.FileMode(0600), info.Mode())
+			target, err := fs.ReadLink("/data/longlink")
+			require.NoError(t, err)
+			assert.Equal(t, strings.Repeat("long/", 20)+"target", target)
+			info, err = fs.Lstat("/link")
+			require.NoError(t, err)
+			assert.Equal(t, os.ModeSymlink|0777, info.Mode())
+
+			entries, err := fs.ReadDir("/etc/many")
+			require.NoError(t, err)
+			assert.Len(t, entries, 300)
+			entries, err = fs.ReadDir("/data")
+			require.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			sort.Strings(names)
+			assert.Equal(t, []string{"big.bin", "longlink", "sub"}, names)
+
+			_, err = fs.Stat("/etc/missing")
+			assert.True(t, os.IsNotExist(err))
+			_, err = fs.ReadDir("/etc/hostname")
+			assert.ErrorIs(t, err, ErrNotDir)
+			assert.ErrorIs(t, fs.Remove("/etc/hostname"), os.ErrPermission)
+		})
+	}
+}
+
+func debugfsCat(t *testing.T, image, file string) string {
+	debugfs := requireBinary(t, "debugfs")
+	out, err := exec.Command(debugfs, "-R", "cat "+file, image).Output()
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestWrite(t *testing.T) {
+	for name, options := range imageOptions {
+		t.Run(name, func(t *testing.T) {
+			image, big := makeImage(t, options...)
+			fs, err := Open(image, os.O_RDWR)
+			require.NoError(t, err)
+
+			// Replace a file, and add files to linear and hashed
+			// directories.
+			require.NoError(t, fs.WriteFile("/etc/hostname",
+				strings.NewReader("other\n"), 6, 0640))
+			require.NoError(t, fs.WriteFile("/etc/many/new",
+				strings.NewReader("new"), 3, 0644))
+			require.NoError(t, fs.WriteFile("/data/sub/empty",
+				strings.NewReader(""), 0, 0644))
+			// Large enough to need an extent tree in blocks.
+			require.NoError(t, fs.WriteFile("/data/copy.bin",
+				bytes.NewReader(big), int64(len(big)), 0755))
+
+			require.NoError(t, fs.MkdirAll("/var/lib/mender", 0755))
+			for i := 0; i < 200; i++ {
+				file := fmt.Sprintf("/var/lib/mender/a-file-with-a-long-name-%03d", i)
+				require.NoError(t, fs.WriteFile(file, strings.NewReader(file),
+					int64(len(file)), 0644))
+			}
+			for i := 0; i < 200; i += 2 {
+				file := fmt.Sprintf("/etc/many/file-with-a-long-name-%03d", i)
+				require.NoError(t, fs.Remove(file))
+			}
+			require.NoError(t, fs.Chown("/etc/many/new", 1000, 70000))
+			require.NoError(t, fs.RemoveAll("/data/sub"))
+			require.NoError(t, fs.Remove("/link"))
+			assert.ErrorIs(t, fs.Remove("/var"), ErrNotEmpty)
+			assert.True(t, os.IsExist(fs.Mkdir("/var", 0755)))
+			require.NoError(t, fs.Close())
+
+			checkImage(t, image)
+			assert.Equal(t, "other\n", debugfsCat(t, image, "/etc/hostname"))
+			assert.Equal(t, "new", debugfsCat(t, image, "/etc/many/new"))
+			assert.True(t, debugfsCat(t, image, "/data/copy.bin") == string(big))
+			assert.Equal(t, "/var/lib/mender/a-file-with-a-long-name-199",
+				debugfsCat(t, image, "/var/lib/mender/a-file-with-a-long-name-199"))
+
+			fs, err = Open(image, os.O_RDONLY)
+			require.NoError(t, err)
+			defer fs.Close()
+			info, err := fs.Stat("/etc/hostname")
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0640), info.Mode())
+			info, err = fs.Stat("/etc/many/new")
+			require.NoError(t, err)
+			assert.Equal(t, uint32(1000), info.Sys().(*Stat).Uid)
+			assert.Equal(t, uint32(70000), info.Sys().(*Stat).Gid)
+			entries, err := fs.ReadDir("/etc/many")
+			require.NoError(t, err)
+			assert.Len(t, entries, 201)
+			_, err = fs.Stat("/data/sub")
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestWriteNoSpace(t *testing.T) {
+	image, big := makeImage(t)
+	fs, err := Open(image, os.O_RDWR)
+	require.NoError(t, err)
+	var i int
+	for i = 0; i < 10; i++ {
+		err = fs.WriteFile(fmt.Sprintf("/copy-%d", i), bytes.NewReader(big), int64(len(big)), 0644)
+		if err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, ErrNoSpace)
+	assert.Less(t, i, 10)
+	require.NoError(t, fs.Close())
+	checkImage(t, image)
+}
+
+func TestUnsupported(t *testing.T) {
+	image, _ := makeImage(t, "-O", "inline_data")
+	_, err := Open(image, os.O_RDONLY)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = Open("ext4_test.go", os.O_RDONLY)
+	assert.Error(t, err)
+	assert.False(t, Probe("ext4_test.go"))
+	assert.True(t, Probe(image))
+}
@@ -0,0 +1,721 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ext4
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const maxSymlinks = 40
+
+// FileInfo describes a file in the file system. It implements os.FileInfo.
+type FileInfo struct {
+	name string
+	in   *inode
+}
+
+// Stat holds the details of an inode, as returned by FileInfo.Sys.
+type Stat struct {
+	Ino   uint32
+	Mode  uint16
+	Links uint16
+	Uid   uint32
+	Gid   uint32
+}
+
+func (fi *FileInfo) Name() string {
+	return fi.name
+}
+
+func (fi *FileInfo) Size() int64 {
+	return fi.in.size()
+}
+
+// Mode converts the mode of the inode to an os.FileMode.
+func (fi *FileInfo) Mode() os.FileMode {
+	mode := fi.in.mode()
+	m := os.FileMode(mode & 0777)
+	if mode&04000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		m |= os.ModeSticky
+	}
+	switch mode & modeTypeMask {
+	case modeRegular:
+	case modeDir:
+		m |= os.ModeDir
+	case modeSymlink:
+		m |= os.ModeSymlink
+	case modeChar:
+		m |= os.ModeDevice | os.ModeCharDevice
+	case modeBlock:
+		m |= os.ModeDevice
+	case modeFIFO:
+		m |= os.ModeNamedPipe
+	case modeSocket:
+		m |= os.ModeSocket
+	default:
+		m |= os.ModeIrregular
+	}
+	return m
+}
+
+func (fi *FileInfo) ModTime() time.Time {
+	return fi.in.mtime()
+}
+
+func (fi *FileInfo) IsDir() bool {
+	return fi.in.isDir()
+}
+
+// Sys returns a *Stat.
+func (fi *FileInfo) Sys() interface{} {
+	return &Stat{
+		Ino:   fi.in.num,
+		Mode:  fi.in.mode(),
+		Links: fi.in.links(),
+		Uid:   fi.in.uid(),
+		Gid:   fi.in.gid(),
+	}
+}
+
+func splitPath(name string) []string {
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// walk resolves the path to an inode. Symlinks are followed, except for the
+// last component unless followLast is set.
+func (fs *FS) walk(name string, followLast bool) (*inode, error) {
+	cur, err := fs.readInode(rootInode)
+	if err != nil {
+		return nil, err
+	}
+	parts := splitPath(name)
+	hops := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if !cur.isDir() {
+			return nil, ErrNotDir
+		}
+		_, e, ok, err := fs.lookup(cur, part)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		next, err := fs.readInode(e.inode)
+		if err != nil {
+			return nil, err
+		}
+		if next.mode()&modeTypeMask != modeSymlink || (len(parts) == 0 && !followLast) {
+			cur = next
+			continue
+		}
+		if hops++; hops > maxSymlinks {
+			return nil, errors.New("too many levels of symbolic links")
+		}
+		target, err := fs.readLink(next)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(target, "/") {
+			if cur, err = fs.readInode(rootInode); err != nil {
+				return nil, err
+			}
+		}
+		parts = append(splitPath(target), parts...)
+	}
+	return cur, nil
+}
+
+// parent resolves the directory holding name, and returns the last
+// component of name.
+func (fs *FS) parent(name string) (*inode, string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil, "", errors.New("invalid path")
+	}
+	dir, err := fs.walk(path.Dir(name), true)
+	if err != nil {
+		return nil, "", err
+	}
+	if !dir.isDir() {
+		return nil, "", ErrNotDir
+	}
+	return dir, path.Base(name), nil
+}
+
+func (fs *FS) stat(op, name string, followLast bool) (*FileInfo, error) {
+	in, err := fs.walk(name, followLast)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return &FileInfo{name: path.Base(path.Clean("/" + name)), in: in}, nil
+}
+
+// Stat returns the details of the file, following symlinks.
+func (fs *FS) Stat(name string) (*FileInfo, error) {
+	return fs.stat("stat", name, true)
+}
+
+// Lstat returns the details of the file, without following a final
+// symlink.
+func (fs *FS) Lstat(name string) (*FileInfo, error) {
+	return fs.stat("lstat", name, false)
+}
+
+// ReadDir returns the entries of the directory, in directory order and
+// without "." and "..".
+func (fs *FS) ReadDir(name string) ([]*FileInfo, error) {
+	dir, err := fs.walk(name, true)
+	if err == nil && !dir.isDir() {
+		err = ErrNotDir
+	}
+	var blocks []dirBlock
+	if err == nil {
+		blocks, err = fs.dirBlocks(dir)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	var infos []*FileInfo
+	for _, block := range blocks {
+		entries, err := fs.entries(block.data)
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		for _, e := range entries {
+			if e.inode == 0 || e.name == "." || e.name == ".." {
+				continue
+			}
+			in, err := fs.readInode(e.inode)
+			if err != nil {
+				return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+			}
+			infos = append(infos, &FileInfo{name: e.name, in: in})
+		}
+	}
+	return infos, nil
+}
+
+func (fs *FS) readLink(in *inode) (string, error) {
+	if in.mode()&modeTypeMask != modeSymlink {
+		return "", errors.New("not a symlink")
+	}
+	if fs.isFastSymlink(in) {
+		return string(in.raw[inBlock : inBlock+in.size()]), nil
+	}
+	f, err := fs.openInode(in)
+	if err != nil {
+		return "", err
+	}
+	target := make([]byte, in.size())
+	if _, err = io.ReadFull(f, target); err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// ReadLink returns the target of the symlink.
+func (fs *FS) ReadLink(name string) (string, error) {
+	in, err := fs.walk(name, false)
+	if err == nil {
+		var target string
+		if target, err = fs.readLink(in); err == nil {
+			return target, nil
+		}
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+}
+
+// File is a regular file opened for reading.
+type File struct {
+	fs      *FS
+	in      *inode
+	extents []extent
+	offset  int64
+}
+
+func (fs *FS) openInode(in *inode) (*File, error) {
+	extents, _, err := fs.mapping(in)
+	if err != nil {
+		return nil, err
+	}
+	return &File{fs: fs, in: in, extents: extents}, nil
+}
+
+// Open opens the file for reading, following symlinks.
+func (fs *FS) Open(name string) (*File, error) {
+	in, err := fs.walk(name, true)
+	if err == nil && in.isDir() {
+		err = ErrIsDir
+	}
+	var f *File
+	if err == nil {
+		f, err = fs.openInode(in)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// ReadFile returns the contents of the file.
+func (fs *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, f.Size())
+	_, err = io.ReadFull(f, data)
+	return data, err
+}
+
+// Size returns the size of the file.
+func (f *File) Size() int64 {
+	return f.in.size()
+}
+
+// Stat returns the details of the file.
+func (f *File) Stat() *FileInfo {
+	return &FileInfo{in: f.in}
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	size := f.in.size()
+	bs := f.fs.blockSize
+	n := 0
+	for n < len(p) && off < size {
+		logical := uint32(off / bs)
+		chunk := bs - off%bs
+		var e *extent
+		for i := range f.extents {
+			if logical >= f.extents[i].logical && logical-f.extents[i].logical < f.extents[i].length {
+				e = &f.extents[i]
+				break
+			}
+		}
+		if e != nil {
+			chunk += int64(e.logical+e.length-logical-1) * bs
+		}
+		if rest := int64(len(p) - n); chunk > rest {
+			chunk = rest
+		}
+		if rest := size - off; chunk > rest {
+			chunk = rest
+		}
+		buf := p[n : n+int(chunk)]
+		if e == nil || e.uninit {
+			for i := range buf {
+				buf[i] = 0
+			}
+		} else {
+			pos := int64(e.start+uint64(logical-e.logical))*bs + off%bs
+			if _, err := f.fs.f.ReadAt(buf, pos); err != nil {
+				return n, errors.Wrap(err, "ext4: can not read file data")
+			}
+		}
+		n += int(chunk)
+		off += chunk
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close is a no-op; it exists so that a File can be used as an
+// io.ReadCloser.
+func (f *File) Close() error {
+	return nil
+}
+
+func unixMode(perm os.FileMode) uint16 {
+	mode := uint16(perm.Perm())
+	if perm&os.ModeSetuid != 0 {
+		mode |= 04000
+	}
+	if perm&os.ModeSetgid != 0 {
+		mode |= 02000
+	}
+	if perm&os.ModeSticky != 0 {
+		mode |= 01000
+	}
+	return mode
+}
+
+func (fs *FS) checkWritable(op, name string) error {
+	if !fs.writable {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func touch(in *inode) {
+	now := uint32(time.Now().Unix())
+	le.PutUint32(in.raw[inMtime:], now)
+	le.PutUint32(in.raw[inCtime:], now)
+}
+
+// dropLink removes a link to the inode, releasing it with the last one.
+func (fs *FS) dropLink(in *inode) error {
+	if in.links() > 1 {
+		in.setLinks(in.links() - 1)
+		le.PutUint32(in.raw[inCtime:], uint32(time.Now().Unix()))
+		return fs.writeInode(in)
+	}
+	return fs.releaseInode(in)
+}
+
+// WriteFile writes size bytes read from r to the file, replacing it if it
+// exists. The new file is owned by root.
+func (fs *FS) WriteFile(name string, r io.Reader, size int64, perm os.FileMode) error {
+	if err := fs.checkWritable("write", name); err != nil {
+		return err
+	}
+	if err := fs.writeFile(name, r, size, perm); err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	return fs.sync()
+}
+
+func (fs *FS) writeFile(name string, r io.Reader, size int64, perm os.FileMode) error {
+	dir, base, err := fs.parent(name)
+	if err != nil {
+		return err
+	}
+	if len(base) > maxNameLen {
+		return errors.New("file name too long")
+	}
+	block, e, exists, err := fs.lookup(dir, base)
+	if err != nil {
+		return err
+	}
+	var old *inode
+	var s slot
+	if exists {
+		if old, err = fs.readInode(e.inode); err != nil {
+			return err
+		}
+		if old.isDir() {
+			return ErrIsDir
+		}
+	} else if s, err = fs.findSlot(dir, base); err != nil {
+		return err
+	}
+
+	in, err := fs.newInode((dir.num-1)/fs.inodesPerGroup, modeRegular|unixMode(perm))
+	if err != nil {
+		return err
+	}
+	blocks := uint64((size + fs.blockSize - 1) / fs.blockSize)
+	var runs []blockRun
+	if blocks > 0 {
+		goal := fs.groupFirstBlock((in.num - 1) / fs.inodesPerGroup)
+		if runs, err = fs.allocBlocks(goal, blocks); err != nil {
+			_ = fs.freeInode(in.num, false)
+			return err
+		}
+	}
+	abort := func(err error) error {
+		fs.freeRuns(runs)
+		_ = fs.freeInode(in.num, false)
+		return err
+	}
+
+	var extents []extent
+	logical := uint32(0)
+	remaining := size
+	buf := make([]byte, 1<<20)
+	for _, run := range runs {
+		extents = append(extents, extent{logical: logical, start: run.start, length: uint32(run.length)})
+		logical += uint32(run.length)
+		for block := run.start; block < run.start+run.length; {
+			n := uint64(len(buf)) / uint64(fs.blockSize)
+			if left := run.start + run.length - block; n > left {
+				n = left
+			}
+			chunk := buf[:n*uint64(fs.blockSize)]
+			want := int64(len(chunk))
+			if want > remaining {
+				want = remaining
+			}
+			if _, err = io.ReadFull(r, chunk[:want]); err != nil {
+				return abort(errors.Wrap(err, "can not read file data"))
+			}
+			for i := want; i < int64(len(chunk)); i++ {
+				chunk[i] = 0
+			}
+			if _, err = fs.f.WriteAt(chunk, int64(block)*fs.blockSize); err != nil {
+				return abort(errors.Wrap(err, "can not write file data"))
+			}
+			remaining -= want
+			block += n
+		}
+	}
+
+	tree, err := fs.buildExtentTree(in, extents)
+	if err != nil {
+		return abort(err)
+	}
+	in.setSize(size)
+	in.setLinks(1)
+	fs.setBlocks(in, blocks+uint64(len(tree)))
+	if err = fs.writeInode(in); err != nil {
+		fs.freeBlockList(tree)
+		return abort(err)
+	}
+
+	if exists {
+		err = fs.setEntryInode(dir, block, e, in.num, ftRegular)
+		if err == nil {
+			err = fs.dropLink(old)
+		}
+	} else {
+		err = fs.link(dir, s, base, in.num, ftRegular)
+	}
+	if err != nil {
+		return err
+	}
+	touch(dir)
+	return fs.writeInode(dir)
+}
+
+// Mkdir creates the directory, owned by root.
+func (fs *FS) Mkdir(name string, perm os.FileMode) error {
+	if err := fs.checkWritable("mkdir", name); err != nil {
+		return err
+	}
+	if err := fs.mkdir(name, perm); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return fs.sync()
+}
+
+func (fs *FS) mkdir(name string, perm os.FileMode) error {
+	dir, base, err := fs.parent(name)
+	if err != nil {
+		return err
+	}
+	if len(base) > maxNameLen {
+		return errors.New("file name too long")
+	}
+	_, _, exists, err := fs.lookup(dir, base)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return os.ErrExist
+	}
+	s, err := fs.findSlot(dir, base)
+	if err != nil {
+		return err
+	}
+
+	in, err := fs.newInode((dir.num-1)/fs.inodesPerGroup, modeDir|unixMode(perm))
+	if err != nil {
+		return err
+	}
+	runs, err := fs.allocBlocks(fs.groupFirstBlock((in.num-1)/fs.inodesPerGroup), 1)
+	if err != nil {
+		_ = fs.freeInode(in.num, true)
+		return err
+	}
+	block := dirBlock{physical: runs[0].start, data: fs.emptyDirBlock()}
+	fs.putDirent(block.data, in.num, direntLen(1), ".", ftDir)
+	fs.putDirent(block.data[direntLen(1):], dir.num, fs.usableLen()-direntLen(1), "..", ftDir)
+	if _, err = fs.buildExtentTree(in, []extent{{start: runs[0].start, length: 1}}); err == nil {
+		in.setSize(fs.blockSize)
+		in.setLinks(2)
+		fs.setBlocks(in, 1)
+		err = fs.writeDirBlock(in, block)
+	}
+	if err == nil {
+		err = fs.writeInode(in)
+	}
+	if err != nil {
+		fs.freeRuns(runs)
+		_ = fs.freeInode(in.num, true)
+		return err
+	}
+
+	if err = fs.link(dir, s, base, in.num, ftDir); err != nil {
+		return err
+	}
+	// With dir_nlink, a link count of 1 stands for too many subdirectories
+	// to count.
+	if links := dir.links(); links > 1 {
+		if links >= 64999 && fs.hasRoCompat(roCompatDirNlink) {
+			dir.setLinks(1)
+		} else {
+			dir.setLinks(links + 1)
+		}
+	}
+	touch(dir)
+	return fs.writeInode(dir)
+}
+
+// Chown sets the owner and group of the file, following symlinks.
+func (fs *FS) Chown(name string, uid, gid int) error {
+	if err := fs.checkWritable("chown", name); err != nil {
+		return err
+	}
+	in, err := fs.walk(name, true)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	in.setOwner(uint32(uid), uint32(gid))
+	le.PutUint32(in.raw[inCtime:], uint32(time.Now().Unix()))
+	if err = fs.writeInode(in); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	return fs.sync()
+}
+
+// MkdirAll creates the directory and any missing parents.
+func (fs *FS) MkdirAll(name string, perm os.FileMode) error {
+	cur := "/"
+	for _, part := range splitPath(name) {
+		cur = path.Join(cur, part)
+		info, err := fs.Stat(cur)
+		if err == nil {
+			if !info.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: cur, Err: ErrNotDir}
+			}
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err = fs.Mkdir(cur, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes the file or the empty directory, without following a
+// final symlink.
+func (fs *FS) Remove(name string) error {
+	if err := fs.checkWritable("remove", name); err != nil {
+		return err
+	}
+	if err := fs.remove(name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return fs.sync()
+}
+
+func (fs *FS) remove(name string) error {
+	dir, base, err := fs.parent(name)
+	if err != nil {
+		return err
+	}
+	block, e, exists, err := fs.lookup(dir, base)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return os.ErrNotExist
+	}
+	if base == "." || base == ".." {
+		return errors.New("invalid path")
+	}
+	in, err := fs.readInode(e.inode)
+	if err != nil {
+		return err
+	}
+	if in.isDir() {
+		blocks, err := fs.dirBlocks(in)
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			entries, err := fs.entries(b.data)
+			if err != nil {
+				return err
+			}
+			for _, child := range entries {
+				if child.inode != 0 && child.name != "." && child.name != ".." {
+					return ErrNotEmpty
+				}
+			}
+		}
+	}
+
+	if err = fs.unlink(dir, block, e); err != nil {
+		return err
+	}
+	if in.isDir() {
+		if links := dir.links(); links > 2 {
+			dir.setLinks(links - 1)
+		}
+		err = fs.releaseInode(in)
+	} else {
+		err = fs.dropLink(in)
+	}
+	if err != nil {
+		return err
+	}
+	touch(dir)
+	return fs.writeInode(dir)
+}
+
+// RemoveAll removes the file, or the directory and everything in it. It
+// is not an error if name does not exist.
+func (fs *FS) RemoveAll(name string) error {
+	info, err := fs.Lstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		children, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err = fs.RemoveAll(path.Join(name, child.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.Remove(name)
+}
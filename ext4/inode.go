@@ -0,0 +1,541 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ext4
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Offsets of the inode fields in use.
+const (
+	inMode        = 0x0
+	inUID         = 0x2
+	inSizeLo      = 0x4
+	inAtime       = 0x8
+	inCtime       = 0xC
+	inMtime       = 0x10
+	inDtime       = 0x14
+	inGID         = 0x18
+	inLinks       = 0x1A
+	inBlocksLo    = 0x1C
+	inFlags       = 0x20
+	inBlock       = 0x28
+	inGeneration  = 0x64
+	inFileACL     = 0x68
+	inSizeHi      = 0x6C
+	inBlocksHi    = 0x74
+	inFileACLHi   = 0x76
+	inUIDHi       = 0x78
+	inGIDHi       = 0x7A
+	inChecksumLo  = 0x7C
+	inExtraIsize  = 0x80
+	inChecksumHi  = 0x82
+	inCrtime      = 0x90
+	inodeBlockLen = 60
+
+	flagIndex      = 0x1000
+	flagHugeFile   = 0x40000
+	flagExtents    = 0x80000
+	flagInlineData = 0x10000000
+
+	modeTypeMask = 0170000
+	modeFIFO     = 0010000
+	modeChar     = 0020000
+	modeDir      = 0040000
+	modeBlock    = 0060000
+	modeRegular  = 0100000
+	modeSymlink  = 0120000
+	modeSocket   = 0140000
+
+	extentMagic     = 0xF30A
+	maxExtentLength = 32768
+
+	xattrMagic = 0xEA020000
+)
+
+type inode struct {
+	num uint32
+	raw []byte
+}
+
+func (in *inode) mode() uint16 {
+	return le.Uint16(in.raw[inMode:])
+}
+
+func (in *inode) isDir() bool {
+	return in.mode()&modeTypeMask == modeDir
+}
+
+func (in *inode) size() int64 {
+	return int64(le.Uint32(in.raw[inSizeLo:])) | int64(le.Uint32(in.raw[inSizeHi:]))<<32
+}
+
+func (in *inode) setSize(size int64) {
+	le.PutUint32(in.raw[inSizeLo:], uint32(size))
+	le.PutUint32(in.raw[inSizeHi:], uint32(size>>32))
+}
+
+func (in *inode) flags() uint32 {
+	return le.Uint32(in.raw[inFlags:])
+}
+
+func (in *inode) links() uint16 {
+	return le.Uint16(in.raw[inLinks:])
+}
+
+func (in *inode) setLinks(n uint16) {
+	le.PutUint16(in.raw[inLinks:], n)
+}
+
+func (in *inode) uid() uint32 {
+	return uint32(le.Uint16(in.raw[inUID:])) | uint32(le.Uint16(in.raw[inUIDHi:]))<<16
+}
+
+func (in *inode) gid() uint32 {
+	return uint32(le.Uint16(in.raw[inGID:])) | uint32(le.Uint16(in.raw[inGIDHi:]))<<16
+}
+
+func (in *inode) setOwner(uid, gid uint32) {
+	le.PutUint16(in.raw[inUID:], uint16(uid))
+	le.PutUint16(in.raw[inUIDHi:], uint16(uid>>16))
+	le.PutUint16(in.raw[inGID:], uint16(gid))
+	le.PutUint16(in.raw[inGIDHi:], uint16(gid>>16))
+}
+
+func (in *inode) mtime() time.Time {
+	return time.Unix(int64(int32(le.Uint32(in.raw[inMtime:]))), 0)
+}
+
+func (in *inode) fileACL() uint64 {
+	return uint64(le.Uint32(in.raw[inFileACL:])) | uint64(le.Uint16(in.raw[inFileACLHi:]))<<32
+}
+
+// sectors returns the number of 512 byte sectors used by the inode.
+func (fs *FS) sectors(in *inode) uint64 {
+	n := uint64(le.Uint32(in.raw[inBlocksLo:])) | uint64(le.Uint16(in.raw[inBlocksHi:]))<<32
+	if in.flags()&flagHugeFile != 0 {
+		n *= uint64(fs.blockSize / 512)
+	}
+	return n
+}
+
+func (fs *FS) setBlocks(in *inode, blocks uint64) {
+	n := blocks * uint64(fs.blockSize/512)
+	le.PutUint32(in.raw[inBlocksLo:], uint32(n))
+	le.PutUint16(in.raw[inBlocksHi:], uint16(n>>32))
+	le.PutUint32(in.raw[inFlags:], in.flags()&^flagHugeFile)
+}
+
+// isFastSymlink tells whether the target of the symlink is stored in the
+// inode itself.
+func (fs *FS) isFastSymlink(in *inode) bool {
+	if in.mode()&modeTypeMask != modeSymlink || in.size() >= inodeBlockLen {
+		return false
+	}
+	sectors := fs.sectors(in)
+	if in.fileACL() != 0 {
+		sectors -= uint64(fs.blockSize / 512)
+	}
+	return sectors == 0
+}
+
+func (fs *FS) inodeOffset(num uint32) (int64, error) {
+	if num == 0 || num > fs.sb32(sbInodesCount) {
+		return 0, errors.Errorf("ext4: inode %d out of range", num)
+	}
+	group := (num - 1) / fs.inodesPerGroup
+	index := (num - 1) % fs.inodesPerGroup
+	table := fs.gd32(group, gdInodeTable, gdInodeTableHi)
+	return int64(table)*fs.blockSize + int64(index)*int64(fs.inodeSize), nil
+}
+
+func (fs *FS) readInode(num uint32) (*inode, error) {
+	off, err := fs.inodeOffset(num)
+	if err != nil {
+		return nil, err
+	}
+	in := &inode{num: num, raw: make([]byte, fs.inodeSize)}
+	if _, err = fs.f.ReadAt(in.raw, off); err != nil {
+		return nil, errors.Wrapf(err, "ext4: can not read inode %d", num)
+	}
+	if in.flags()&flagInlineData != 0 {
+		return nil, errors.Wrap(ErrUnsupported, "inline data")
+	}
+	return in, nil
+}
+
+func (fs *FS) writeInode(in *inode) error {
+	off, err := fs.inodeOffset(in.num)
+	if err != nil {
+		return err
+	}
+	if fs.hasMetadataCsum() {
+		hasHi := fs.inodeSize > 128 && le.Uint16(in.raw[inExtraIsize:]) >= 4
+		raw := append([]byte{}, in.raw...)
+		le.PutUint16(raw[inChecksumLo:], 0)
+		if hasHi {
+			le.PutUint16(raw[inChecksumHi:], 0)
+		}
+		csum := crc32c(fs.inodeCsumSeed(in), raw)
+		le.PutUint16(in.raw[inChecksumLo:], uint16(csum))
+		if hasHi {
+			le.PutUint16(in.raw[inChecksumHi:], uint16(csum>>16))
+		}
+	}
+	_, err = fs.f.WriteAt(in.raw, off)
+	return errors.Wrapf(err, "ext4: can not write inode %d", in.num)
+}
+
+// inodeCsumSeed is the seed of the checksums of the inode and of the blocks
+// belonging to it.
+func (fs *FS) inodeCsumSeed(in *inode) uint32 {
+	var num [4]byte
+	le.PutUint32(num[:], in.num)
+	return crc32c(crc32c(fs.csumSeed, num[:]), in.raw[inGeneration:inGeneration+4])
+}
+
+// newInode allocates and initializes an inode of the given mode.
+func (fs *FS) newInode(goalGroup uint32, mode uint16) (*inode, error) {
+	num, err := fs.allocInode(goalGroup, mode&modeTypeMask == modeDir)
+	if err != nil {
+		return nil, err
+	}
+	in := &inode{num: num, raw: make([]byte, fs.inodeSize)}
+	now := uint32(time.Now().Unix())
+	le.PutUint16(in.raw[inMode:], mode)
+	for _, off := range []int{inAtime, inCtime, inMtime} {
+		le.PutUint32(in.raw[off:], now)
+	}
+	le.PutUint32(in.raw[inFlags:], flagExtents)
+	le.PutUint32(in.raw[inGeneration:], rand.Uint32())
+	if fs.inodeSize > 128 {
+		extra := int(fs.sb16(sbWantExtraIsize))
+		if extra < 32 {
+			extra = 32
+		}
+		if extra > fs.inodeSize-128 {
+			extra = fs.inodeSize - 128
+		}
+		le.PutUint16(in.raw[inExtraIsize:], uint16(extra))
+		if 128+extra >= inCrtime+4 {
+			le.PutUint32(in.raw[inCrtime:], now)
+		}
+	}
+	setExtentHeader(in.raw[inBlock:inBlock+inodeBlockLen], 0, 4, 0)
+	return in, nil
+}
+
+// extent maps length blocks of a file from logical onwards to start.
+type extent struct {
+	logical uint32
+	start   uint64
+	length  uint32
+	uninit  bool
+}
+
+// mapping returns the extents of the data of the inode, and the blocks
+// holding the mapping itself.
+func (fs *FS) mapping(in *inode) ([]extent, []uint64, error) {
+	if fs.isFastSymlink(in) {
+		return nil, nil, nil
+	}
+	var extents []extent
+	var meta []uint64
+	var err error
+	if in.flags()&flagExtents != 0 {
+		err = fs.walkExtents(in.raw[inBlock:inBlock+inodeBlockLen], 0, &extents, &meta)
+	} else {
+		err = fs.walkBlockMap(in, &extents, &meta)
+	}
+	return extents, meta, err
+}
+
+func setExtentHeader(b []byte, entries, max, depth uint16) {
+	le.PutUint16(b[0:], extentMagic)
+	le.PutUint16(b[2:], entries)
+	le.PutUint16(b[4:], max)
+	le.PutUint16(b[6:], depth)
+	le.PutUint32(b[8:], 0)
+}
+
+func (fs *FS) walkExtents(node []byte, level int, extents *[]extent, meta *[]uint64) error {
+	if le.Uint16(node[0:]) != extentMagic || level > 5 {
+		return errors.New("ext4: invalid extent tree")
+	}
+	entries := int(le.Uint16(node[2:]))
+	depth := le.Uint16(node[6:])
+	if 12+12*entries > len(node) {
+		return errors.New("ext4: invalid extent tree")
+	}
+	for i := 0; i < entries; i++ {
+		e := node[12+12*i:]
+		if depth == 0 {
+			length := uint32(le.Uint16(e[4:]))
+			ext := extent{
+				logical: le.Uint32(e[0:]),
+				start:   uint64(le.Uint16(e[6:]))<<32 | uint64(le.Uint32(e[8:])),
+				length:  length,
+			}
+			if length > maxExtentLength {
+				ext.length -= maxExtentLength
+				ext.uninit = true
+			}
+			*extents = append(*extents, ext)
+			continue
+		}
+		child := uint64(le.Uint16(e[8:]))<<32 | uint64(le.Uint32(e[4:]))
+		*meta = append(*meta, child)
+		b, err := fs.readBlock(child)
+		if err != nil {
+			return err
+		}
+		if err = fs.walkExtents(b, level+1, extents, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkBlockMap reads the direct and indirect block map of files without
+// extents.
+func (fs *FS) walkBlockMap(in *inode, extents *[]extent, meta *[]uint64) error {
+	perBlock := uint64(fs.blockSize / 4)
+	logical := uint64(0)
+	add := func(block uint64) {
+		if block != 0 {
+			n := len(*extents)
+			if n > 0 && (*extents)[n-1].start+uint64((*extents)[n-1].length) == block &&
+				uint64((*extents)[n-1].logical)+uint64((*extents)[n-1].length) == logical {
+				(*extents)[n-1].length++
+			} else {
+				*extents = append(*extents, extent{
+					logical: uint32(logical), start: block, length: 1,
+				})
+			}
+		}
+		logical++
+	}
+	var walk func(block uint64, level int) error
+	walk = func(block uint64, level int) error {
+		if block == 0 {
+			span := uint64(1)
+			for i := 0; i < level; i++ {
+				span *= perBlock
+			}
+			logical += span
+			return nil
+		}
+		if level == 0 {
+			add(block)
+			return nil
+		}
+		*meta = append(*meta, block)
+		b, err := fs.readBlock(block)
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < perBlock; i++ {
+			if err = walk(uint64(le.Uint32(b[4*i:])), level-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	blocks := in.raw[inBlock:]
+	for i := 0; i < 12; i++ {
+		if err := walk(uint64(le.Uint32(blocks[4*i:])), 0); err != nil {
+			return err
+		}
+	}
+	for level := 1; level <= 3; level++ {
+		if err := walk(uint64(le.Uint32(blocks[4*(11+level):])), level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// physical returns the block holding the logical block of a file, or 0 if
+// it is a hole.
+func physical(extents []extent, logical uint32) (uint64, bool) {
+	for _, e := range extents {
+		if logical >= e.logical && logical-e.logical < e.length {
+			if e.uninit {
+				return 0, true
+			}
+			return e.start + uint64(logical-e.logical), true
+		}
+	}
+	return 0, false
+}
+
+// buildExtentTree stores the extents in the inode, allocating blocks for
+// the tree if they do not fit in the inode itself. It returns the blocks
+// allocated for the tree.
+func (fs *FS) buildExtentTree(in *inode, extents []extent) ([]uint64, error) {
+	// Split extents which are too long for a single entry.
+	var entries [][]byte
+	for _, e := range extents {
+		for e.length > 0 {
+			length := e.length
+			if length > maxExtentLength {
+				length = maxExtentLength
+			}
+			entry := make([]byte, 12)
+			le.PutUint32(entry[0:], e.logical)
+			le.PutUint16(entry[4:], uint16(length))
+			le.PutUint16(entry[6:], uint16(e.start>>32))
+			le.PutUint32(entry[8:], uint32(e.start))
+			entries = append(entries, entry)
+			e.logical += length
+			e.start += uint64(length)
+			e.length -= length
+		}
+	}
+
+	var allocated []uint64
+	perBlock := int((fs.blockSize - 12) / 12)
+	depth := uint16(0)
+	for len(entries) > 4 {
+		nodes := (len(entries) + perBlock - 1) / perBlock
+		runs, err := fs.allocBlocks(fs.groupFirstBlock((in.num-1)/fs.inodesPerGroup),
+			uint64(nodes))
+		if err != nil {
+			fs.freeBlockList(allocated)
+			return nil, err
+		}
+		var blocks []uint64
+		for _, run := range runs {
+			for i := uint64(0); i < run.length; i++ {
+				blocks = append(blocks, run.start+i)
+			}
+		}
+		allocated = append(allocated, blocks...)
+
+		var index [][]byte
+		for i, block := range blocks {
+			chunk := entries[i*perBlock:]
+			if len(chunk) > perBlock {
+				chunk = chunk[:perBlock]
+			}
+			b := make([]byte, fs.blockSize)
+			setExtentHeader(b, uint16(len(chunk)), uint16(perBlock), depth)
+			for j, entry := range chunk {
+				copy(b[12+12*j:], entry)
+			}
+			if fs.hasMetadataCsum() {
+				tail := 12 + 12*perBlock
+				le.PutUint32(b[tail:], crc32c(fs.inodeCsumSeed(in), b[:tail]))
+			}
+			if err = fs.writeBlock(block, b); err != nil {
+				fs.freeBlockList(allocated)
+				return nil, err
+			}
+			entry := make([]byte, 12)
+			copy(entry[0:4], chunk[0][0:4])
+			le.PutUint32(entry[4:], uint32(block))
+			le.PutUint16(entry[8:], uint16(block>>32))
+			index = append(index, entry)
+		}
+		entries = index
+		depth++
+	}
+
+	root := in.raw[inBlock : inBlock+inodeBlockLen]
+	for i := range root {
+		root[i] = 0
+	}
+	setExtentHeader(root, uint16(len(entries)), 4, depth)
+	for i, entry := range entries {
+		copy(root[12+12*i:], entry)
+	}
+	le.PutUint32(in.raw[inFlags:], in.flags()|flagExtents)
+	return allocated, nil
+}
+
+func (fs *FS) freeBlockList(blocks []uint64) {
+	for _, block := range blocks {
+		_ = fs.freeBlockRange(block, 1)
+	}
+}
+
+// releaseInode frees the data, the mapping and the extended attribute
+// block of an inode without links, and the inode itself.
+func (fs *FS) releaseInode(in *inode) error {
+	extents, meta, err := fs.mapping(in)
+	if err != nil {
+		return err
+	}
+	for _, e := range extents {
+		if err = fs.freeBlockRange(e.start, uint64(e.length)); err != nil {
+			return err
+		}
+	}
+	for _, block := range meta {
+		if err = fs.freeBlockRange(block, 1); err != nil {
+			return err
+		}
+	}
+	if acl := in.fileACL(); acl != 0 {
+		if err = fs.releaseXattrBlock(acl); err != nil {
+			return err
+		}
+	}
+
+	le.PutUint32(in.raw[inDtime:], uint32(time.Now().Unix()))
+	in.setLinks(0)
+	in.setSize(0)
+	fs.setBlocks(in, 0)
+	le.PutUint32(in.raw[inFileACL:], 0)
+	le.PutUint16(in.raw[inFileACLHi:], 0)
+	root := in.raw[inBlock : inBlock+inodeBlockLen]
+	for i := range root {
+		root[i] = 0
+	}
+	if in.flags()&flagExtents != 0 {
+		setExtentHeader(root, 0, 4, 0)
+	}
+	if err = fs.writeInode(in); err != nil {
+		return err
+	}
+	return fs.freeInode(in.num, in.isDir())
+}
+
+// releaseXattrBlock drops a reference to a shared extended attribute
+// block, freeing it with the last one.
+func (fs *FS) releaseXattrBlock(block uint64) error {
+	b, err := fs.readBlock(block)
+	if err != nil {
+		return err
+	}
+	if le.Uint32(b[0:]) != xattrMagic {
+		return errors.Errorf("ext4: invalid extended attribute block %d", block)
+	}
+	refs := le.Uint32(b[4:])
+	if refs <= 1 {
+		return fs.freeBlockRange(block, 1)
+	}
+	le.PutUint32(b[4:], refs-1)
+	if fs.hasMetadataCsum() {
+		var num [8]byte
+		le.PutUint64(num[:], block)
+		le.PutUint32(b[16:], 0)
+		le.PutUint32(b[16:], crc32c(crc32c(fs.csumSeed, num[:]), b))
+	}
+	return fs.writeBlock(block, b)
+}
@@ -67,6 +67,14 @@ func (b *BootstrapArtifact) GetUpdateClearsProvides() []string {
 	return b.typeInfoV3.ClearsArtifactProvides
 }
 
+func (b *BootstrapArtifact) GetUpdateInstallSize() int64 {
+	return 0
+}
+
+func (b *BootstrapArtifact) GetUpdatePayloadSignatures() map[string]string {
+	return nil
+}
+
 // Returns non-augmented (original) data.
 func (b *BootstrapArtifact) GetUpdateOriginalDepends() artifact.TypeInfoDepends {
 	return nil
@@ -39,6 +39,42 @@ type DataFile struct {
 	Date time.Time
 	// checksum of the update file
 	Checksum []byte
+	// name of the update file in the Artifact, if it is not the base name
+	// of Name
+	PayloadName string
+	// content of the update file, which is streamed from it instead of
+	// read from the file Name when the Artifact is written. Size must be
+	// set along with it, and it is read only once
+	Reader io.Reader
+}
+
+// Open returns the content of the update file, from Reader if it is set.
+func (d *DataFile) Open() (io.ReadCloser, error) {
+	if d.Reader != nil {
+		return ioutil.NopCloser(d.Reader), nil
+	}
+	return os.Open(d.Name)
+}
+
+// GetSize returns the size of the update file, which is Size if the content
+// is streamed from Reader.
+func (d *DataFile) GetSize() (int64, error) {
+	if d.Reader != nil {
+		return d.Size, nil
+	}
+	fi, err := os.Stat(d.Name)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// GetPayloadName returns the name of the update file in the Artifact.
+func (d *DataFile) GetPayloadName() string {
+	if d.PayloadName != "" {
+		return d.PayloadName
+	}
+	return filepath.Base(d.Name)
 }
 
 type ComposeHeaderArgs struct {
@@ -66,6 +102,10 @@ type ArtifactUpdateHeaders interface {
 	GetUpdateProvides() (artifact.TypeInfoProvides, error)
 	GetUpdateMetaData() (map[string]interface{}, error) // Generic JSON
 	GetUpdateClearsProvides() []string
+	// Declared uncompressed install size, augmented overriding original.
+	GetUpdateInstallSize() int64
+	// Signatures of the non-augmented payload files, by file name.
+	GetUpdatePayloadSignatures() map[string]string
 
 	// Returns non-augmented (original) data.
 	GetUpdateOriginalDepends() artifact.TypeInfoDepends
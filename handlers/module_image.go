@@ -420,6 +420,26 @@ func (img *ModuleImage) GetUpdateClearsProvides() []string {
 	return img.typeInfoV3.ClearsArtifactProvides
 }
 
+func (img *ModuleImage) GetUpdateInstallSize() int64 {
+	if img.typeInfoV3 == nil || img.typeInfoV3.InstallSize == 0 {
+		if img.original != nil {
+			return img.original.GetUpdateInstallSize()
+		}
+		return 0
+	}
+	return img.typeInfoV3.InstallSize
+}
+
+func (img *ModuleImage) GetUpdatePayloadSignatures() map[string]string {
+	if img.original != nil {
+		return img.original.GetUpdatePayloadSignatures()
+	}
+	if img.typeInfoV3 == nil {
+		return nil
+	}
+	return img.typeInfoV3.PayloadSignatures
+}
+
 func (img *ModuleImage) ComposeHeader(args *ComposeHeaderArgs) error {
 	if img.version < 3 {
 		return errors.New(
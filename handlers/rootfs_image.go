@@ -275,6 +275,26 @@ func (rfs *Rootfs) GetUpdateClearsProvides() []string {
 	return rfs.typeInfoV3.ClearsArtifactProvides
 }
 
+func (rfs *Rootfs) GetUpdateInstallSize() int64 {
+	if rfs.typeInfoV3 == nil || rfs.typeInfoV3.InstallSize == 0 {
+		if rfs.original != nil {
+			return rfs.original.GetUpdateInstallSize()
+		}
+		return 0
+	}
+	return rfs.typeInfoV3.InstallSize
+}
+
+func (rfs *Rootfs) GetUpdatePayloadSignatures() map[string]string {
+	if rfs.original != nil {
+		return rfs.original.GetUpdatePayloadSignatures()
+	}
+	if rfs.typeInfoV3 == nil {
+		return nil
+	}
+	return rfs.typeInfoV3.PayloadSignatures
+}
+
 func (rfs *Rootfs) setUpdateOriginalMetaData(jsonObj map[string]interface{}) error {
 	if rfs.original != nil {
 		return errors.New("setUpdateOriginalMetaData() called on non-original instance.")
@@ -359,7 +379,7 @@ func (rfs *Rootfs) ComposeHeader(args *ComposeHeaderArgs) error {
 	switch rfs.version {
 	case 1, 2:
 		// first store files
-		if err := writeFiles(args.TarWriter, []string{filepath.Base(rfs.update.Name)},
+		if err := writeFiles(args.TarWriter, []string{rfs.update.GetPayloadName()},
 			path); err != nil {
 			return err
 		}
@@ -405,11 +425,19 @@ func (rfs *Rootfs) ComposeHeader(args *ComposeHeaderArgs) error {
 }
 
 func (rfs *Rootfs) GetUpdateOriginalTypeInfoWriter() io.Writer {
-	// We don't use the type-info information with rootfs payloads.
-	return nil
+	if rfs.original != nil {
+		return rfs.original.GetUpdateOriginalTypeInfoWriter()
+	}
+	if rfs.typeInfoV3 == nil {
+		// Version 2 payloads have no type-info.
+		return nil
+	}
+	return rfs.typeInfoV3
 }
 
 func (rfs *Rootfs) GetUpdateAugmentTypeInfoWriter() io.Writer {
-	// We don't use the type-info information with rootfs payloads.
-	return nil
+	if rfs.original == nil || rfs.typeInfoV3 == nil {
+		return nil
+	}
+	return rfs.typeInfoV3
 }
@@ -19,9 +19,11 @@ import (
 	"os"
 
 	"github.com/mendersoftware/mender-artifact/cli"
+	"github.com/mendersoftware/mender-artifact/utils"
 )
 
 func run() error {
+	utils.CleanupTempOnSignal()
 	err := cli.Run(os.Args)
 	if err != nil {
 		fmt.Println(err)
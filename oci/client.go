@@ -0,0 +1,408 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// emptyConfig is the config blob of manifests which have no config.
+var emptyConfig = []byte("{}")
+
+var challengeParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Descriptor describes a blob in a registry.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ArtifactLayer returns the layer of m which holds the Mender Artifact.
+func (m *Manifest) ArtifactLayer() (Descriptor, error) {
+	for _, layer := range m.Layers {
+		if layer.MediaType == ArtifactMediaType {
+			return layer, nil
+		}
+	}
+	return Descriptor{}, errors.New("the manifest has no Mender Artifact layer")
+}
+
+// Digest returns the sha256 digest of data, as used in descriptors.
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Client talks to OCI registries using the distribution API. Registries are
+// accessed anonymously unless Username is set, using basic or token
+// authentication as the registry asks for.
+type Client struct {
+	HTTPClient *http.Client
+	Username   string
+	Password   string
+	// PlainHTTP makes the client use http instead of https.
+	PlainHTTP bool
+
+	basicAuth bool
+	token     string
+}
+
+func (c *Client) url(ref *Reference, path string) string {
+	proto := "https"
+	if c.PlainHTTP {
+		proto = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s", proto, ref.Registry, ref.Repository, path)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.basicAuth {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// do sends the request made by newReq. If the registry asks for
+// authentication, the client authenticates and sends a new request once.
+func (c *Client) do(newReq func() (*http.Request, error)) (*http.Response, error) {
+	req, err := newReq()
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.httpClient().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	if err = c.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	if req, err = newReq(); err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	return c.httpClient().Do(req)
+}
+
+// authenticate answers a WWW-Authenticate challenge of the registry.
+func (c *Client) authenticate(challenge string) error {
+	fields := strings.SplitN(challenge, " ", 2)
+	params := map[string]string{}
+	if len(fields) == 2 {
+		for _, m := range challengeParamRe.FindAllStringSubmatch(fields[1], -1) {
+			params[m[1]] = m[2]
+		}
+	}
+	switch strings.ToLower(fields[0]) {
+	case "basic":
+		if c.Username == "" {
+			return errors.New("the registry requires a username and password")
+		}
+		c.basicAuth = true
+		return nil
+	case "bearer":
+		return c.fetchToken(params["realm"], params["service"], params["scope"])
+	default:
+		return errors.Errorf("unsupported registry authentication: %q", challenge)
+	}
+}
+
+func (c *Client) fetchToken(realm, service, scope string) error {
+	if realm == "" {
+		return errors.New("the registry did not say where to get a token")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return errors.Wrap(err, "invalid token realm")
+	}
+	q := u.Query()
+	if service != "" {
+		q.Set("service", service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return errors.Wrap(err, "can not get registry token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "can not get registry token")
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrap(err, "invalid registry token response")
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return errors.New("the registry returned an empty token")
+	}
+	return nil
+}
+
+// responseError turns an unexpected registry response into an error,
+// including the messages of the registry, if any.
+func responseError(resp *http.Response, what string) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) == nil && len(body.Errors) > 0 {
+		var msgs []string
+		for _, e := range body.Errors {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		return errors.Errorf("%s: %s (%s)", what, resp.Status, strings.Join(msgs, "; "))
+	}
+	return errors.Errorf("%s: %s", what, resp.Status)
+}
+
+// Push uploads layer, which must match desc, and tags a manifest which has
+// it as its only layer. It returns the descriptor of the manifest.
+func (c *Client) Push(
+	ref *Reference,
+	layer io.ReadSeeker,
+	desc Descriptor,
+	annotations map[string]string,
+) (Descriptor, error) {
+	if ref.Tag == "" {
+		return Descriptor{}, errors.New("can not push to a digest; please give a tag")
+	}
+	config := Descriptor{
+		MediaType: EmptyMediaType,
+		Digest:    Digest(emptyConfig),
+		Size:      int64(len(emptyConfig)),
+	}
+	if err := c.pushBlob(ref, bytes.NewReader(emptyConfig), config); err != nil {
+		return Descriptor{}, err
+	}
+	if err := c.pushBlob(ref, layer, desc); err != nil {
+		return Descriptor{}, err
+	}
+
+	data, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        config,
+		Layers:        []Descriptor{desc},
+		Annotations:   annotations,
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPut,
+			c.url(ref, "manifests/"+ref.Tag), bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", ManifestMediaType)
+		}
+		return req, err
+	})
+	if err != nil {
+		return Descriptor{}, errors.Wrap(err, "can not push manifest")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return Descriptor{}, responseError(resp, "can not push manifest")
+	}
+	return Descriptor{
+		MediaType: ManifestMediaType,
+		Digest:    Digest(data),
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// pushBlob uploads a blob, unless the registry already has it.
+func (c *Client) pushBlob(ref *Reference, r io.ReadSeeker, desc Descriptor) error {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodHead, c.url(ref, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not check for blob")
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	uploads := c.url(ref, "blobs/uploads/")
+	resp, err = c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodPost, uploads, nil)
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not start blob upload")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return responseError(resp, "can not start blob upload")
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return errors.New("the registry did not give a blob upload location")
+	}
+	q := location.Query()
+	q.Set("digest", desc.Digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do(func() (*http.Request, error) {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(http.MethodPut, location.String(), io.NopCloser(r))
+		if err == nil {
+			req.ContentLength = desc.Size
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		return req, err
+	})
+	if err != nil {
+		return errors.Wrap(err, "can not upload blob")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "can not upload blob")
+	}
+	return nil
+}
+
+// FetchManifest fetches the manifest which ref points to.
+func (c *Client) FetchManifest(ref *Reference) (*Manifest, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodGet,
+			c.url(ref, "manifests/"+ref.reference()), nil)
+		if err == nil {
+			req.Header.Set("Accept", ManifestMediaType)
+		}
+		return req, err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can not fetch manifest")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "can not fetch manifest")
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4*1024*1024))
+	if err != nil {
+		return nil, errors.Wrap(err, "can not fetch manifest")
+	}
+	if ref.Digest != "" && Digest(data) != ref.Digest {
+		return nil, errors.Errorf("manifest digest mismatch: expected %s", ref.Digest)
+	}
+	manifest := new(Manifest)
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "invalid manifest")
+	}
+	if manifest.MediaType != "" && manifest.MediaType != ManifestMediaType {
+		return nil, errors.Errorf("unsupported manifest type %q", manifest.MediaType)
+	}
+	return manifest, nil
+}
+
+// FetchBlob fetches the blob desc describes. Reading it returns an error at
+// the end if the contents do not match the descriptor.
+func (c *Client) FetchBlob(ref *Reference, desc Descriptor) (io.ReadCloser, error) {
+	resp, err := c.do(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, c.url(ref, "blobs/"+desc.Digest), nil)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can not fetch blob")
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp, "can not fetch blob")
+	}
+	return &verifyingReader{ReadCloser: resp.Body, desc: desc, h: sha256.New()}, nil
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	desc Descriptor
+	h    hash.Hash
+	n    int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+	if v.n > v.desc.Size {
+		return n, errors.Errorf("blob is larger than the expected %d bytes", v.desc.Size)
+	}
+	if err == io.EOF {
+		if v.n != v.desc.Size {
+			return n, errors.Errorf("blob has %d bytes; expected %d", v.n, v.desc.Size)
+		}
+		if digest := "sha256:" + hex.EncodeToString(v.h.Sum(nil)); digest != v.desc.Digest {
+			return n, errors.Errorf("blob digest mismatch: got %s, expected %s",
+				digest, v.desc.Digest)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry is a minimal in-memory OCI registry requiring token
+// authentication.
+type testRegistry struct {
+	sync.Mutex
+	*httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   int
+	corrupt   bool
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	if req.URL.Path == "/token" {
+		user, pass, ok := req.BasicAuth()
+		if !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"token": "token-for-%s"}`, req.URL.Query().Get("scope"))
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer token-for-") {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/token",service="test",scope="repository:release:pull,push"`,
+			r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/release/")
+	switch {
+	case req.Method == http.MethodHead && strings.HasPrefix(path, "blobs/"):
+		if _, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "blobs/"):
+		data, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.corrupt {
+			data = append([]byte{}, data...)
+			data[0]++
+		}
+		_, _ = w.Write(data)
+	case req.Method == http.MethodPost && path == "blobs/uploads/":
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/release/blobs/uploads/%d?state=x", r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "blobs/uploads/"):
+		data, _ := io.ReadAll(req.Body)
+		if req.URL.Query().Get("state") != "x" || Digest(data) != req.URL.Query().Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[Digest(data)] = data
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "manifests/"):
+		data, _ := io.ReadAll(req.Body)
+		r.manifests[strings.TrimPrefix(path, "manifests/")] = data
+		r.manifests[Digest(data)] = data
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "manifests/"):
+		data, ok := r.manifests[strings.TrimPrefix(path, "manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "unknown"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", ManifestMediaType)
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *testRegistry) reference(t *testing.T, tag string) *Reference {
+	u, err := url.Parse(r.URL)
+	require.NoError(t, err)
+	ref, err := ParseReference("oci://" + u.Host + "/release:" + tag)
+	require.NoError(t, err)
+	return ref
+}
+
+func TestPushAndFetch(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := &Client{Username: "user", Password: "secret", PlainHTTP: true}
+	ref := registry.reference(t, "1.0")
+
+	data := []byte("mender artifact")
+	desc := Descriptor{
+		MediaType:   ArtifactMediaType,
+		Digest:      Digest(data),
+		Size:        int64(len(data)),
+		Annotations: map[string]string{AnnotationTitle: "release.mender"},
+	}
+	annotations := map[string]string{AnnotationName: "release-1"}
+	pushed, err := client.Push(ref, bytes.NewReader(data), desc, annotations)
+	require.NoError(t, err)
+	assert.Equal(t, 2, registry.uploads)
+
+	manifest, err := client.FetchManifest(ref)
+	require.NoError(t, err)
+	assert.Equal(t, ArtifactType, manifest.ArtifactType)
+	assert.Equal(t, annotations, manifest.Annotations)
+	assert.Equal(t, EmptyMediaType, manifest.Config.MediaType)
+	layer, err := manifest.ArtifactLayer()
+	require.NoError(t, err)
+	assert.Equal(t, desc, layer)
+
+	// Fetch by digest too.
+	byDigest := *ref
+	byDigest.Digest = pushed.Digest
+	_, err = client.FetchManifest(&byDigest)
+	require.NoError(t, err)
+
+	blob, err := client.FetchBlob(ref, layer)
+	require.NoError(t, err)
+	fetched, err := io.ReadAll(blob)
+	require.NoError(t, err)
+	blob.Close()
+	assert.Equal(t, data, fetched)
+
+	// Blobs which the registry has are not uploaded again.
+	_, err = client.Push(registry.reference(t, "1.1"), bytes.NewReader(data), desc, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, registry.uploads)
+
+	registry.corrupt = true
+	blob, err = client.FetchBlob(ref, layer)
+	require.NoError(t, err)
+	_, err = io.ReadAll(blob)
+	blob.Close()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "digest mismatch")
+}
+
+func TestClientErrors(t *testing.T) {
+	registry := newTestRegistry(t)
+
+	_, err := (&Client{PlainHTTP: true}).FetchManifest(registry.reference(t, "1.0"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can not get registry token: 401")
+
+	client := &Client{Username: "user", Password: "secret", PlainHTTP: true}
+	_, err = client.FetchManifest(registry.reference(t, "missing"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MANIFEST_UNKNOWN: unknown")
+
+	manifest := Manifest{Layers: []Descriptor{{MediaType: EmptyMediaType}}}
+	_, err = manifest.ArtifactLayer()
+	assert.Error(t, err)
+
+	data, err := json.Marshal(Manifest{MediaType: "application/vnd.oci.image.index.v1+json"})
+	require.NoError(t, err)
+	registry.manifests["index"] = data
+	_, err = client.FetchManifest(registry.reference(t, "index"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported manifest type")
+}
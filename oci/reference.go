@@ -0,0 +1,113 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Package oci stores Mender Artifacts in OCI registries, the same registries
+// which hold container images. An Artifact is pushed as an OCI artifact with
+// the .mender file as its only layer.
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ArtifactType is the artifactType of manifests holding a Mender Artifact.
+	ArtifactType = "application/vnd.mender.artifact"
+	// ArtifactMediaType is the media type of the layer holding the .mender
+	// file.
+	ArtifactMediaType = "application/vnd.mender.artifact.v3+tar"
+	// ManifestMediaType is the media type of OCI image manifests.
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// EmptyMediaType is the media type of the empty config blob.
+	EmptyMediaType = "application/vnd.oci.empty.v1+json"
+)
+
+// Annotations describing the Artifact.
+const (
+	AnnotationTitle       = "org.opencontainers.image.title"
+	AnnotationName        = "io.mender.artifact.name"
+	AnnotationDeviceTypes = "io.mender.artifact.device-types"
+	AnnotationProvides    = "io.mender.artifact.provides"
+)
+
+const scheme = "oci://"
+
+var (
+	repositoryRe = regexp.MustCompile(
+		`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagRe    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	digestRe = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference is a location in an OCI registry, written as
+// oci://registry/repository:tag or oci://registry/repository@digest.
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an oci:// reference. The tag defaults to "latest".
+func ParseReference(s string) (*Reference, error) {
+	if !strings.HasPrefix(s, scheme) {
+		return nil, errors.Errorf("%q is not an %s reference", s, scheme)
+	}
+	rest := strings.TrimPrefix(s, scheme)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 {
+		return nil, errors.Errorf("%q has no registry or repository", s)
+	}
+	ref := &Reference{Registry: rest[:slash]}
+	repo := rest[slash+1:]
+	if i := strings.Index(repo, "@"); i >= 0 {
+		ref.Digest = repo[i+1:]
+		repo = repo[:i]
+		if !digestRe.MatchString(ref.Digest) {
+			return nil, errors.Errorf("%q has an invalid digest", s)
+		}
+	} else if i := strings.LastIndex(repo, ":"); i >= 0 {
+		ref.Tag = repo[i+1:]
+		repo = repo[:i]
+		if !tagRe.MatchString(ref.Tag) {
+			return nil, errors.Errorf("%q has an invalid tag", s)
+		}
+	} else {
+		ref.Tag = "latest"
+	}
+	if !repositoryRe.MatchString(repo) {
+		return nil, errors.Errorf("%q has an invalid repository name", s)
+	}
+	ref.Repository = repo
+	return ref, nil
+}
+
+// reference returns the digest or tag part of the reference.
+func (r *Reference) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	if r.Digest != "" {
+		return fmt.Sprintf("%s%s/%s@%s", scheme, r.Registry, r.Repository, r.Digest)
+	}
+	return fmt.Sprintf("%s%s/%s:%s", scheme, r.Registry, r.Repository, r.Tag)
+}
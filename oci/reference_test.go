@@ -0,0 +1,79 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := map[string]struct {
+		ref      string
+		expected *Reference
+		err      string
+	}{
+		"tag": {
+			ref:      "oci://registry.example.com/mender/release:1.0",
+			expected: &Reference{"registry.example.com", "mender/release", "1.0", ""},
+		},
+		"port and default tag": {
+			ref:      "oci://localhost:5000/release",
+			expected: &Reference{"localhost:5000", "release", "latest", ""},
+		},
+		"digest": {
+			ref:      "oci://localhost:5000/release@" + digest,
+			expected: &Reference{"localhost:5000", "release", "", digest},
+		},
+		"no scheme": {
+			ref: "registry.example.com/release:1.0",
+			err: "is not an oci:// reference",
+		},
+		"no repository": {
+			ref: "oci://registry.example.com",
+			err: "has no registry or repository",
+		},
+		"upper case repository": {
+			ref: "oci://registry.example.com/Release:1.0",
+			err: "invalid repository name",
+		},
+		"invalid tag": {
+			ref: "oci://registry.example.com/release:-1",
+			err: "invalid tag",
+		},
+		"invalid digest": {
+			ref: "oci://registry.example.com/release@sha256:1234",
+			err: "invalid digest",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ref, err := ParseReference(test.ref)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ref)
+			if ref.Tag != "latest" {
+				assert.Equal(t, test.ref, ref.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// TempPrefix starts the names of all temporary files and directories, which
+// are followed by the process ID of their creator.
+const TempPrefix = "mender-artifact-"
+
+var tempNamePattern = regexp.MustCompile(`^` + TempPrefix + `([0-9]+)-`)
+
+// The temporary files and directories of the process, which are removed by
+// CleanupTemp if they are still there.
+var (
+	tempMutex   sync.Mutex
+	tempPaths   = map[string]bool{}
+	tempSignals chan os.Signal
+)
+
+func tempPattern(pattern string) string {
+	return fmt.Sprintf("%s%d-%s", TempPrefix, os.Getpid(), pattern)
+}
+
+func trackTemp(path string) {
+	tempMutex.Lock()
+	defer tempMutex.Unlock()
+	tempPaths[path] = true
+}
+
+// TempFile creates a temporary file in dir, or the default temporary
+// directory if dir is empty, like ioutil.TempFile. It is removed by
+// CleanupTemp unless it is removed with RemoveTemp or kept with ForgetTemp
+// first.
+func TempFile(dir, pattern string) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, tempPattern(pattern))
+	if err != nil {
+		return nil, err
+	}
+	trackTemp(f.Name())
+	return f, nil
+}
+
+// TempDir creates a temporary directory like ioutil.TempDir, which is
+// removed with all its contents by CleanupTemp.
+func TempDir(dir, pattern string) (string, error) {
+	name, err := ioutil.TempDir(dir, tempPattern(pattern))
+	if err != nil {
+		return "", err
+	}
+	trackTemp(name)
+	return name, nil
+}
+
+// RemoveTemp removes the temporary file or directory at path, with all its
+// contents, and stops tracking it.
+func RemoveTemp(path string) error {
+	ForgetTemp(path)
+	return os.RemoveAll(path)
+}
+
+// ForgetTemp stops tracking path, such as a temporary file which has been
+// renamed to its final name.
+func ForgetTemp(path string) {
+	tempMutex.Lock()
+	defer tempMutex.Unlock()
+	delete(tempPaths, path)
+}
+
+// CleanupTemp removes all the temporary files and directories which are
+// still there, and returns the first error.
+func CleanupTemp() error {
+	tempMutex.Lock()
+	paths := tempPaths
+	tempPaths = map[string]bool{}
+	tempMutex.Unlock()
+
+	var firstErr error
+	for path := range paths {
+		if err := os.RemoveAll(path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// CleanupTempOnSignal makes the process remove its temporary files and exit
+// when it is interrupted or terminated, until StopCleanupTempOnSignal is
+// called.
+func CleanupTempOnSignal() {
+	tempMutex.Lock()
+	defer tempMutex.Unlock()
+	if tempSignals != nil {
+		return
+	}
+	tempSignals = make(chan os.Signal, 1)
+	signal.Notify(tempSignals, os.Interrupt, syscall.SIGTERM)
+	go func(signals chan os.Signal) {
+		if _, ok := <-signals; ok {
+			_ = CleanupTemp()
+			os.Exit(1)
+		}
+	}(tempSignals)
+}
+
+// StopCleanupTempOnSignal stops CleanupTempOnSignal, for commands which
+// handle the signals themselves, and clean up as they return. It returns
+// true if it was started.
+func StopCleanupTempOnSignal() bool {
+	tempMutex.Lock()
+	defer tempMutex.Unlock()
+	if tempSignals == nil {
+		return false
+	}
+	signal.Stop(tempSignals)
+	close(tempSignals)
+	tempSignals = nil
+	return true
+}
+
+// LeftoverTemp is a temporary file or directory left behind by a process.
+type LeftoverTemp struct {
+	Path string
+	PID  int
+	// Running is true if the process which created it is still running,
+	// and may still be using it.
+	Running bool
+	// Size is the total size of the files.
+	Size int64
+}
+
+// FindLeftoverTemp returns the temporary files and directories of other
+// processes in dir, or the default temporary directory if dir is empty.
+func FindLeftoverTemp(dir string) ([]LeftoverTemp, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var leftovers []LeftoverTemp
+	for _, entry := range entries {
+		match := tempNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		pid, err := strconv.Atoi(match[1])
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		leftover := LeftoverTemp{Path: path, PID: pid, Running: processRunning(pid)}
+		_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				leftover.Size += info.Size()
+			}
+			return nil
+		})
+		leftovers = append(leftovers, leftover)
+	}
+	sort.Slice(leftovers, func(i, j int) bool {
+		return leftovers[i].Path < leftovers[j].Path
+	})
+	return leftovers, nil
+}
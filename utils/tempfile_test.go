@@ -0,0 +1,85 @@
+// Copyright 2024 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempCleanup(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := TempFile(dir, "file")
+	require.NoError(t, err)
+	f.Close()
+	assert.True(t, strings.HasPrefix(filepath.Base(f.Name()),
+		fmt.Sprintf("%s%d-file", TempPrefix, os.Getpid())))
+	removed, err := TempFile(dir, "removed")
+	require.NoError(t, err)
+	removed.Close()
+	kept, err := TempFile(dir, "kept")
+	require.NoError(t, err)
+	kept.Close()
+	tmpDir, err := TempDir(dir, "dir")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "data"), []byte("x"), 0644))
+
+	assert.NoError(t, RemoveTemp(removed.Name()))
+	assert.NoFileExists(t, removed.Name())
+	ForgetTemp(kept.Name())
+
+	assert.NoError(t, CleanupTemp())
+	assert.NoFileExists(t, f.Name())
+	assert.NoDirExists(t, tmpDir)
+	assert.FileExists(t, kept.Name())
+	// Nothing is left to remove.
+	assert.NoError(t, CleanupTemp())
+}
+
+func TestFindLeftoverTemp(t *testing.T) {
+	dir := t.TempDir()
+
+	// Our own temporary files are not left behind.
+	own, err := TempDir(dir, "own")
+	require.NoError(t, err)
+	defer RemoveTemp(own)
+	// Not a process which is running.
+	left := filepath.Join(dir, TempPrefix+"999999999-data")
+	require.NoError(t, os.Mkdir(left, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(left, "a"), []byte("12345"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(left, "b"), []byte("678"), 0644))
+	// The parent of the test is.
+	running := filepath.Join(dir, fmt.Sprintf("%s%d-file", TempPrefix, os.Getppid()))
+	require.NoError(t, ioutil.WriteFile(running, nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other"), nil, 0644))
+
+	leftovers, err := FindLeftoverTemp(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []LeftoverTemp{
+		{Path: left, PID: 999999999, Size: 8},
+		{Path: running, PID: os.Getppid(), Running: true},
+	}, leftovers)
+
+	_, err = FindLeftoverTemp(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}